@@ -0,0 +1,483 @@
+package boom
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"unsafe"
+)
+
+// adaptiveSelectors is the number of distinct hash selectors an entry of an
+// AdaptiveCuckooFilter cycles through when adapting.
+const adaptiveSelectors = 4
+
+// adaptiveEntry is an entry in an AdaptiveCuckooFilter bucket.
+type adaptiveEntry struct {
+	fingerprint []byte // fingerprint computed with the selector
+	selector    uint8  // hash function used to compute the fingerprint
+	key         []byte // original data, used to recompute the fingerprint
+}
+
+// AdaptiveCuckooFilter implements an Adaptive Cuckoo Filter as described by
+// Mitzenmacher, Pontarelli, and Reviriego in Adaptive Cuckoo Filters:
+//
+// https://arxiv.org/abs/1704.06818
+//
+// An Adaptive Cuckoo Filter is a Cuckoo Filter which removes false positives
+// once they are discovered. Each entry has a selector choosing which of
+// several hash functions computes its fingerprint. When the caller reports a
+// false positive with Adapt, the selector of the colliding entry is advanced
+// and its fingerprint recomputed, so the false positive doesn't recur.
+// Recomputing a fingerprint requires the original data, which the filter keeps
+// alongside the entries, analogous to the remote copy of the set kept in slow
+// memory in the paper. The filter answers queries from the fingerprints alone.
+//
+// Because there are a bounded number of selectors, a key which keeps being
+// adapted eventually cycles back to a selector it has collided under before.
+//
+// Adaptive Cuckoo Filters are useful for cases where absent items are queried
+// repeatedly, such as a filter in front of a cache or database, and the
+// application can detect false positives.
+type AdaptiveCuckooFilter struct {
+	buckets [][]adaptiveEntry
+	hash    hash.Hash64 // hash function (used for bucket indices)
+	fhash   hash.Hash32 // hash function (used for fingerprints)
+	m       uint        // number of buckets
+	b       uint        // number of entries per bucket
+	f       uint        // length of fingerprints (in bytes)
+	count   uint        // number of items in the filter
+	n       uint        // filter capacity
+	seed    uint64      // bucket hash seed (zero means unseeded)
+	rand    *rand.Rand  // source of randomness for relocations, if seeded
+}
+
+// NewAdaptiveCuckooFilter creates a new Adaptive Cuckoo Filter optimized to
+// store n items with a specified target false-positive rate.
+func NewAdaptiveCuckooFilter(n uint, fpRate float64, opts ...Option) *AdaptiveCuckooFilter {
+	var (
+		b = uint(4)
+		f = calculateF(b, fpRate)
+		m = power2(n / uint(f) * 8)
+	)
+
+	if f > 4 {
+		f = 4
+	}
+
+	o := applyOptions(opts)
+	return &AdaptiveCuckooFilter{
+		buckets: newAdaptiveBuckets(m, b),
+		hash:    o.newHash64(newFNV64),
+		fhash:   o.newHash32(fnv.New32a),
+		m:       m,
+		b:       b,
+		f:       f,
+		n:       n,
+		seed:    o.seed,
+		rand:    o.rand(),
+	}
+}
+
+// Buckets returns the number of buckets.
+func (a *AdaptiveCuckooFilter) Buckets() uint {
+	return a.m
+}
+
+// Capacity returns the number of items the filter can store.
+func (a *AdaptiveCuckooFilter) Capacity() uint {
+	return a.n
+}
+
+// Count returns the number of items in the filter.
+func (a *AdaptiveCuckooFilter) Count() uint {
+	return a.count
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. This is a probabilistic test, meaning there is a
+// non-zero probability of false positives.
+func (a *AdaptiveCuckooFilter) Test(data []byte) bool {
+	i1, i2 := a.indices(data)
+	return a.find(i1, data) != -1 || a.find(i2, data) != -1
+}
+
+// Add will add the data to the filter. It returns an error if the filter is
+// full. If the filter is full, an item is removed to make room for the new
+// item. This introduces a possibility for false negatives. To avoid this, use
+// Count and Capacity to check if the filter is full before adding an item.
+func (a *AdaptiveCuckooFilter) Add(data []byte) error {
+	i1, i2 := a.indices(data)
+	return a.add(i1, i2, data)
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data is a member, false if not. An error is returned if the filter is
+// full.
+func (a *AdaptiveCuckooFilter) TestAndAdd(data []byte) (bool, error) {
+	i1, i2 := a.indices(data)
+	if a.find(i1, data) != -1 || a.find(i2, data) != -1 {
+		return true, nil
+	}
+
+	return false, a.add(i1, i2, data)
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (a *AdaptiveCuckooFilter) TestString(data string) bool {
+	return a.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied and only copied to be kept alongside its entry.
+func (a *AdaptiveCuckooFilter) AddString(data string) error {
+	return a.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied and only copied to be kept alongside its entry.
+func (a *AdaptiveCuckooFilter) TestAndAddString(data string) (bool, error) {
+	return a.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter, stopping at the first error
+// returned by Add. It returns the number of items added, which are the first
+// ones in data, and the error, if any.
+func (a *AdaptiveCuckooFilter) AddMany(data [][]byte) (int, error) {
+	return addAllContext(context.Background(), data, a.Add)
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+func (a *AdaptiveCuckooFilter) TestMany(data [][]byte) []bool {
+	return testMany(data, a.Test)
+}
+
+// TestAndRemove will test for membership of the data and remove it from the
+// filter if it exists. Returns true if the data was a member, false if not.
+// Only an entry added for exactly this data is removed, so removing an item
+// which was never added doesn't remove another item whose fingerprint
+// collides with it.
+func (a *AdaptiveCuckooFilter) TestAndRemove(data []byte) bool {
+	i1, i2 := a.indices(data)
+	for _, i := range []uint{i1, i2} {
+		for j, entry := range a.buckets[i] {
+			if entry.key != nil && bytes.Equal(entry.key, data) {
+				a.buckets[i][j] = adaptiveEntry{}
+				a.count--
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Adapt removes a false positive reported by the caller. The data, which must
+// not have been added to the filter, is tested and the selector of each entry
+// whose fingerprint matches it is advanced, recomputing the entry's
+// fingerprint with the next hash function. Returns true if any entry was
+// adapted. Test may still report the data as a member if the new fingerprint
+// happens to collide as well, in which case Adapt can be called again.
+func (a *AdaptiveCuckooFilter) Adapt(data []byte) bool {
+	var (
+		i1, i2  = a.indices(data)
+		adapted = false
+	)
+
+	for _, i := range []uint{i1, i2} {
+		for j := range a.buckets[i] {
+			entry := &a.buckets[i][j]
+			if entry.key == nil || bytes.Equal(entry.key, data) {
+				continue
+			}
+
+			if bytes.Equal(entry.fingerprint, a.fingerprint(data, entry.selector)) {
+				entry.selector = (entry.selector + 1) % adaptiveSelectors
+				entry.fingerprint = a.fingerprint(entry.key, entry.selector)
+				adapted = true
+			}
+		}
+		if i1 == i2 {
+			break
+		}
+	}
+
+	return adapted
+}
+
+// Reset restores the filter to its original state. It returns the filter to
+// allow for chaining.
+func (a *AdaptiveCuckooFilter) Reset() *AdaptiveCuckooFilter {
+	a.buckets = newAdaptiveBuckets(a.m, a.b)
+	a.count = 0
+	return a
+}
+
+// WriteTo writes a binary representation of the AdaptiveCuckooFilter to an I/O
+// stream, including the data and selector of each entry. Fingerprints are not
+// written since they're recomputed from these. The hash functions are not
+// written, but the seed is. It returns the number of bytes written.
+func (a *AdaptiveCuckooFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(a.m))
+	e.write(uint64(a.b))
+	e.write(uint64(a.f))
+	e.write(uint64(a.count))
+	e.write(uint64(a.n))
+	for _, b := range a.buckets {
+		for _, entry := range b {
+			if entry.key == nil {
+				e.write(uint8(0))
+				continue
+			}
+			e.write(uint8(1))
+			e.write(entry.selector)
+			e.writeBytes(entry.key)
+		}
+	}
+	e.write(a.seed)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of an AdaptiveCuckooFilter (such as
+// might have been written by WriteTo()) from an I/O stream. The filter keeps
+// its current bucket hash function, which must match the one used by the
+// filter that was written. Returns ErrUnsupportedVersion if the data was
+// written by an incompatible version of the package. It returns the number of
+// bytes read.
+func (a *AdaptiveCuckooFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                    = decoder{r: payload}
+		m, b, f, count, size uint64
+	)
+	d.read(&m)
+	d.read(&b)
+	d.read(&f)
+	d.read(&count)
+	d.read(&size)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	// Every entry takes at least one byte.
+	if m == 0 || b == 0 || m > uint64(payload.Len())/b || f == 0 || f > 4 {
+		return n, errors.New("entries don't match filter size")
+	}
+
+	if a.fhash == nil {
+		a.fhash = fnv.New32a()
+	}
+	a.f = uint(f)
+
+	buckets := newAdaptiveBuckets(uint(m), uint(b))
+	for i := range buckets {
+		for j := range buckets[i] {
+			var present uint8
+			d.read(&present)
+			if present == 1 {
+				entry := &buckets[i][j]
+				d.read(&entry.selector)
+				entry.key = d.readBytes()
+				entry.fingerprint = a.fingerprint(entry.key, entry.selector)
+			}
+			if d.err != nil {
+				return n, d.err
+			}
+		}
+	}
+
+	var seed uint64
+	if payload.Len() > 0 {
+		// Written by format version 2.3 or later.
+		d.read(&seed)
+		if d.err != nil {
+			return n, d.err
+		}
+	}
+
+	a.buckets = buckets
+	a.m = uint(m)
+	a.b = uint(b)
+	a.count = uint(count)
+	a.n = uint(size)
+	a.seed = seed
+	return n, nil
+}
+
+// add will insert the data into the filter returning an error if the filter
+// is full.
+func (a *AdaptiveCuckooFilter) add(i1, i2 uint, data []byte) error {
+	entry := adaptiveEntry{
+		fingerprint: a.fingerprint(data, 0),
+		key:         append([]byte(nil), data...),
+	}
+
+	if a.insert(i1, entry) || a.insert(i2, entry) {
+		a.count++
+		return nil
+	}
+
+	// Must relocate existing items. Since the data is kept, an entry's
+	// alternate bucket is computed from it rather than from the fingerprint.
+	i := i1
+	for n := 0; n < maxNumKicks; n++ {
+		j := a.intn(int(a.b))
+		entry, a.buckets[i][j] = a.buckets[i][j], entry
+		if alt1, alt2 := a.indices(entry.key); alt1 == i {
+			i = alt2
+		} else {
+			i = alt1
+		}
+		if a.insert(i, entry) {
+			a.count++
+			return nil
+		}
+	}
+
+	return errors.New("full")
+}
+
+// insert puts the entry in the first empty slot of the bucket, returning false
+// if the bucket is full.
+func (a *AdaptiveCuckooFilter) insert(i uint, entry adaptiveEntry) bool {
+	for j := range a.buckets[i] {
+		if a.buckets[i][j].key == nil {
+			a.buckets[i][j] = entry
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the index of the entry in the bucket whose fingerprint matches
+// the data or -1 if there is none.
+func (a *AdaptiveCuckooFilter) find(i uint, data []byte) int {
+	for j, entry := range a.buckets[i] {
+		if entry.key != nil && bytes.Equal(entry.fingerprint, a.fingerprint(data, entry.selector)) {
+			return j
+		}
+	}
+	return -1
+}
+
+// indices returns the two bucket indices for the given data.
+func (a *AdaptiveCuckooFilter) indices(data []byte) (uint, uint) {
+	if a.seed != 0 {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], a.seed)
+		a.hash.Write(buf[:])
+	}
+	a.hash.Write(data)
+	sum := a.hash.Sum64()
+	a.hash.Reset()
+	return uint(sum>>32) % a.m, uint(sum&0xffffffff) % a.m
+}
+
+// fingerprint returns the fingerprint of the data computed with the hash
+// function chosen by the selector.
+func (a *AdaptiveCuckooFilter) fingerprint(data []byte, selector uint8) []byte {
+	a.fhash.Write([]byte{selector})
+	a.fhash.Write(data)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], a.fhash.Sum32())
+	a.fhash.Reset()
+	return sum[:a.f]
+}
+
+// intn returns a random number in [0, n) from the filter's source of
+// randomness, falling back to the default source if there is none.
+func (a *AdaptiveCuckooFilter) intn(n int) int {
+	if a.rand != nil {
+		return a.rand.Intn(n)
+	}
+	return rand.Intn(n)
+}
+
+// Equal returns true if the other Adaptive Cuckoo Filter has the same
+// parameters, hash seed, and number of items, and holds the same fingerprints,
+// selectors, and data in the same entries. The hash functions aren't compared.
+func (a *AdaptiveCuckooFilter) Equal(other *AdaptiveCuckooFilter) bool {
+	if a.m != other.m || a.b != other.b || a.f != other.f || a.n != other.n ||
+		a.count != other.count || a.seed != other.seed {
+		return false
+	}
+
+	for i, bucket := range a.buckets {
+		for j, entry := range bucket {
+			o := other.buckets[i][j]
+			if entry.selector != o.selector || !bytes.Equal(entry.fingerprint, o.fingerprint) ||
+				!bytes.Equal(entry.key, o.key) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the Adaptive Cuckoo Filter, including
+// the data kept alongside its entries, which can be used concurrently with the
+// original. The copy's source of randomness for relocations, if seeded, is
+// seeded from the original's.
+func (a *AdaptiveCuckooFilter) Clone() *AdaptiveCuckooFilter {
+	c := *a
+	c.hash = cloneHash64(a.hash)
+	c.fhash = cloneHash32(a.fhash)
+	c.rand = cloneRand(a.rand)
+	c.buckets = make([][]adaptiveEntry, len(a.buckets))
+	for i, bucket := range a.buckets {
+		c.buckets[i] = make([]adaptiveEntry, len(bucket))
+		for j, entry := range bucket {
+			if entry.fingerprint != nil {
+				entry.fingerprint = append([]byte(nil), entry.fingerprint...)
+			}
+			if entry.key != nil {
+				entry.key = append([]byte(nil), entry.key...)
+			}
+			c.buckets[i][j] = entry
+		}
+	}
+	return &c
+}
+
+// ByteSize returns the number of bytes used by the buckets, including the
+// fingerprints and original data of their entries, and metadata of the
+// Adaptive Cuckoo Filter. The hash functions and source of randomness aren't
+// included.
+func (a *AdaptiveCuckooFilter) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*a)) + uint64(cap(a.buckets))*sliceSize
+	for _, bucket := range a.buckets {
+		size += uint64(cap(bucket)) * uint64(unsafe.Sizeof(adaptiveEntry{}))
+		for _, entry := range bucket {
+			size += uint64(cap(entry.fingerprint)) + uint64(cap(entry.key))
+		}
+	}
+	return size
+}
+
+// SetHash sets the hashing function used to compute bucket indices.
+func (a *AdaptiveCuckooFilter) SetHash(h hash.Hash64) {
+	a.hash = h
+}
+
+// newAdaptiveBuckets returns m empty buckets with b entries each.
+func newAdaptiveBuckets(m, b uint) [][]adaptiveEntry {
+	buckets := make([][]adaptiveEntry, m)
+	for i := range buckets {
+		buckets[i] = make([]adaptiveEntry, b)
+	}
+	return buckets
+}
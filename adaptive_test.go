@@ -0,0 +1,187 @@
+package boom
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+// Ensures that Test, Add, TestAndAdd, and TestAndRemove behave correctly.
+func TestAdaptiveCuckooTestAndAdd(t *testing.T) {
+	f := NewAdaptiveCuckooFilter(100, 0.1)
+
+	if f.Test([]byte(`a`)) {
+		t.Error("`a` should not be a member")
+	}
+
+	if err := f.Add([]byte(`a`)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !f.Test([]byte(`a`)) {
+		t.Error("`a` should be a member")
+	}
+
+	if member, err := f.TestAndAdd([]byte(`a`)); !member || err != nil {
+		t.Errorf("`a` should be a member, got %v %v", member, err)
+	}
+
+	if member, err := f.TestAndAdd([]byte(`b`)); member || err != nil {
+		t.Errorf("`b` should not be a member, got %v %v", member, err)
+	}
+
+	if count := f.Count(); count != 2 {
+		t.Errorf("Expected 2, got %d", count)
+	}
+
+	if !f.TestAndRemove([]byte(`b`)) {
+		t.Error("`b` should have been removed")
+	}
+
+	if f.TestAndRemove([]byte(`c`)) {
+		t.Error("`c` should not have been removed")
+	}
+
+	if count := f.Count(); count != 1 {
+		t.Errorf("Expected 1, got %d", count)
+	}
+
+	if f.Reset() != f {
+		t.Error("Returned AdaptiveCuckooFilter should be the same instance")
+	}
+
+	if f.Test([]byte(`a`)) {
+		t.Error("`a` should not be a member")
+	}
+}
+
+// Ensures that Adapt removes a false positive while members remain positive.
+func TestAdaptiveCuckooAdapt(t *testing.T) {
+	f := NewAdaptiveCuckooFilter(1000, 0.1)
+	for i := 0; i < 1000; i++ {
+		if err := f.Add([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	adapted := 0
+	for i := 1000; i < 100000 && adapted < 10; i++ {
+		data := []byte(strconv.Itoa(i))
+		if !f.Test(data) {
+			continue
+		}
+
+		for tries := 0; f.Test(data) && tries < 10; tries++ {
+			if !f.Adapt(data) {
+				t.Fatalf("Expected %s to be adapted", data)
+			}
+		}
+		if f.Test(data) {
+			t.Errorf("Expected %s not to be a member after adapting", data)
+		}
+		adapted++
+	}
+
+	if adapted == 0 {
+		t.Fatal("Expected to find false positives")
+	}
+
+	for i := 0; i < 1000; i++ {
+		if !f.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+
+	if f.Adapt([]byte(`0`)) {
+		t.Error("Expected member not to be adapted")
+	}
+}
+
+func BenchmarkAdaptiveCuckooAdd(b *testing.B) {
+	b.StopTimer()
+	f := NewAdaptiveCuckooFilter(uint(b.N), 0.1)
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.Add(data[n])
+	}
+}
+
+func BenchmarkAdaptiveCuckooTest(b *testing.B) {
+	b.StopTimer()
+	f := NewAdaptiveCuckooFilter(uint(b.N), 0.1)
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.Test(data[n])
+	}
+}
+
+// Ensures that an AdaptiveCuckooFilter read from its written representation
+// has the same members and adapted selectors.
+func TestAdaptiveCuckooWriteToReadFrom(t *testing.T) {
+	f := NewAdaptiveCuckooFilter(1000, 0.01)
+	for i := 0; i < 500; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	for i := 500; i < 20000; i++ {
+		if data := []byte(strconv.Itoa(i)); f.Test(data) {
+			f.Adapt(data)
+		}
+	}
+
+	restored := NewAdaptiveCuckooFilter(10, 0.1)
+	var buf bytes.Buffer
+	wn, err := f.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	rn, err := restored.ReadFrom(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if wn != rn {
+		t.Errorf("Expected %d bytes read, got %d", wn, rn)
+	}
+
+	if restored.Buckets() != f.Buckets() || restored.Capacity() != f.Capacity() || restored.Count() != f.Count() {
+		t.Errorf("Expected %d, %d, %d, got %d, %d, %d", f.Buckets(), f.Capacity(), f.Count(),
+			restored.Buckets(), restored.Capacity(), restored.Count())
+	}
+
+	for i := 0; i < 20000; i++ {
+		data := []byte(strconv.Itoa(i))
+		if restored.Test(data) != f.Test(data) {
+			t.Errorf("Expected %t for %s, got %t", f.Test(data), data, restored.Test(data))
+		}
+	}
+}
+
+// Ensures that ReadFrom rejects a filter without buckets, which can't index
+// any data.
+func TestAdaptiveCuckooReadFromNoBuckets(t *testing.T) {
+	var e encoder
+	e.write(uint64(0))
+	e.write(uint64(4))
+	e.write(uint64(1))
+	e.write(uint64(0))
+	e.write(uint64(0))
+
+	var buf bytes.Buffer
+	if _, err := writeFrame(&buf, e.buf.Bytes()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := (&AdaptiveCuckooFilter{}).ReadFrom(&buf); err == nil {
+		t.Error("Expected error for a filter without buckets")
+	}
+}
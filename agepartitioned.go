@@ -0,0 +1,382 @@
+package boom
+
+import (
+	"context"
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"unsafe"
+)
+
+// AgePartitionedBloomFilter implements an Age-Partitioned Bloom Filter as
+// described by Shtul, Baquero, and Almeida in Age-Partitioned Bloom Filters:
+//
+// https://arxiv.org/abs/2001.03147
+//
+// The filter is a circular sequence of k+l bit slices, each with its own hash
+// function. An item is added by setting one bit in each of the k newest
+// slices, and is a member if its bits are set in any k consecutive slices.
+// Items are added in generations of a fixed number of items: once a
+// generation is full, or when Rotate is called, the oldest slice is cleared
+// and becomes the newest, so every item's bits move one slice older. An item
+// stays a member for the generation it's added in and the following
+// generations-1. Once generations rotations have happened, the oldest of its
+// k slices has been cleared, so it's no longer a member unless its remaining
+// bits are completed by chance, which is likelier than a false positive for a
+// new item in the k-1 generations it takes for the rest of its bits to be
+// cleared. After Slices rotations none of its bits remain.
+//
+// Unlike a StableBloomFilter, whose cells decay at random, an
+// AgePartitionedBloomFilter forgets every item after exactly the same number
+// of generations and has no false negatives before then, which suits
+// deduplicating a stream within a precise window. Rotating on a timer rather
+// than by count makes the window a duration.
+type AgePartitionedBloomFilter struct {
+	words      []uint64    // slices of bits, agePartitionedWords(m) words each
+	hash       hash.Hash64 // hash function (kernel for all k+l functions)
+	m          uint        // bits per slice
+	k          uint        // number of slices an item is added to
+	l          uint        // number of extra slices aging items
+	capacity   uint        // number of items per generation
+	count      uint        // number of items added
+	generation uint        // number of items added to the current generation
+	newest     uint        // physical index of the newest slice
+	seed       uint64      // hash seed (zero means unseeded)
+	modulo     bool        // reduce hashes to indices with a modulo
+}
+
+// NewAgePartitionedBloomFilter creates a new Age-Partitioned Bloom Filter
+// which remembers items for the given number of generations, at least 1, of
+// capacity items each, with a specified target false-positive rate.
+func NewAgePartitionedBloomFilter(capacity, generations uint, fpRate float64, opts ...Option) *AgePartitionedBloomFilter {
+	if capacity == 0 {
+		capacity = 1
+	}
+	if generations == 0 {
+		generations = 1
+	}
+
+	// Each slice holds the items of k generations, at a fill ratio of 1/2,
+	// and a false positive needs any of the l+1 windows of k slices to be set.
+	var (
+		l = generations - 1
+		k = uint(math.Ceil(math.Log2(float64(generations) / fpRate)))
+	)
+	if k == 0 {
+		k = 1
+	}
+	m := uint(math.Ceil(float64(k*capacity) / math.Ln2))
+	o := applyOptions(opts)
+	return &AgePartitionedBloomFilter{
+		words:    make([]uint64, (k+l)*agePartitionedWords(m)),
+		hash:     o.newHash64(newFNV64),
+		m:        m,
+		k:        k,
+		l:        l,
+		capacity: capacity,
+		seed:     o.seed,
+		modulo:   o.modulo,
+	}
+}
+
+// Capacity returns the number of items per generation.
+func (a *AgePartitionedBloomFilter) Capacity() uint {
+	return a.capacity
+}
+
+// Generations returns the number of generations items are remembered for.
+func (a *AgePartitionedBloomFilter) Generations() uint {
+	return a.l + 1
+}
+
+// K returns the number of slices an item is added to.
+func (a *AgePartitionedBloomFilter) K() uint {
+	return a.k
+}
+
+// Slices returns the number of slices, k+l.
+func (a *AgePartitionedBloomFilter) Slices() uint {
+	return a.k + a.l
+}
+
+// Count returns the number of items added to the filter, including those
+// which have been forgotten.
+func (a *AgePartitionedBloomFilter) Count() uint {
+	return a.count
+}
+
+// EstimatedFPRate returns the estimated false-positive rate once the filter
+// has been through enough full generations for every slice to reach its target
+// fill ratio of 1/2, bounded by the union of the l+1 windows of k slices.
+func (a *AgePartitionedBloomFilter) EstimatedFPRate() float64 {
+	return math.Min(1, float64(a.l+1)*math.Pow(0.5, float64(a.k)))
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. This is a probabilistic test, meaning there is a
+// non-zero probability of false positives but a zero probability of false
+// negatives for items added in the last Generations generations.
+func (a *AgePartitionedBloomFilter) Test(data []byte) bool {
+	var (
+		lower, upper = sizedHashKernel(data, a.hash, a.seed, a.m)
+		slices       = a.Slices()
+		run          uint
+	)
+	for i := uint(0); i < slices; i++ {
+		slice := (a.newest + i) % slices
+		if !a.bit(slice, reducedIndex(lower, upper, slice, a.m, a.modulo)) {
+			run = 0
+			continue
+		}
+		if run++; run == a.k {
+			return true
+		}
+	}
+	return false
+}
+
+// Add will add the data to the current generation, rotating first if it's
+// full. It returns the filter to allow for chaining.
+func (a *AgePartitionedBloomFilter) Add(data []byte) Filter {
+	if a.generation == a.capacity {
+		a.Rotate()
+	}
+
+	var (
+		lower, upper = sizedHashKernel(data, a.hash, a.seed, a.m)
+		slices       = a.Slices()
+	)
+	for i := uint(0); i < a.k; i++ {
+		slice := (a.newest + i) % slices
+		bit := slice*agePartitionedWords(a.m)*64 + reducedIndex(lower, upper, slice, a.m, a.modulo)
+		a.words[bit/64] |= 1 << (bit % 64)
+	}
+	a.count++
+	a.generation++
+	return a
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data is a member, false if not.
+func (a *AgePartitionedBloomFilter) TestAndAdd(data []byte) bool {
+	member := a.Test(data)
+	a.Add(data)
+	return member
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (a *AgePartitionedBloomFilter) TestString(data string) bool {
+	return a.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (a *AgePartitionedBloomFilter) AddString(data string) Filter {
+	return a.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (a *AgePartitionedBloomFilter) TestAndAddString(data string) bool {
+	return a.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter. It returns the filter to allow
+// for chaining.
+func (a *AgePartitionedBloomFilter) AddMany(data [][]byte) Filter {
+	for _, d := range data {
+		a.Add(d)
+	}
+	return a
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+func (a *AgePartitionedBloomFilter) TestMany(data [][]byte) []bool {
+	return testMany(data, a.Test)
+}
+
+// AddAllContext adds each item of data to the filter, checking the context for
+// cancellation periodically. It returns the number of items added, which are
+// the first ones in data, and the context's error if it was cancelled before
+// every item was added.
+func (a *AgePartitionedBloomFilter) AddAllContext(ctx context.Context, data [][]byte) (int, error) {
+	return addAllContext(ctx, data, func(d []byte) error {
+		a.Add(d)
+		return nil
+	})
+}
+
+// Rotate starts a new generation, clearing the oldest slice so that the items
+// of the oldest generation are forgotten. It's called by Add once a
+// generation is full, and can be called on a schedule to start generations by
+// time instead, in which case a generation which fills before then still
+// rotates early. It returns the filter to allow for chaining.
+func (a *AgePartitionedBloomFilter) Rotate() *AgePartitionedBloomFilter {
+	var (
+		slices = a.Slices()
+		words  = agePartitionedWords(a.m)
+	)
+	a.newest = (a.newest + slices - 1) % slices
+	slice := a.words[a.newest*words : (a.newest+1)*words]
+	for i := range slice {
+		slice[i] = 0
+	}
+	a.generation = 0
+	return a
+}
+
+// Reset restores the filter to its original state. It returns the filter to
+// allow for chaining.
+func (a *AgePartitionedBloomFilter) Reset() *AgePartitionedBloomFilter {
+	for i := range a.words {
+		a.words[i] = 0
+	}
+	a.count = 0
+	a.generation = 0
+	a.newest = 0
+	return a
+}
+
+// Equal returns true if the other Age-Partitioned Bloom Filter has the same
+// parameters, hash seed, reduction of hashes to indices, number of items
+// added, generation, and bits. The hash functions aren't compared.
+func (a *AgePartitionedBloomFilter) Equal(other *AgePartitionedBloomFilter) bool {
+	if a.m != other.m || a.k != other.k || a.l != other.l || a.capacity != other.capacity ||
+		a.count != other.count || a.generation != other.generation || a.newest != other.newest ||
+		a.seed != other.seed || a.modulo != other.modulo {
+		return false
+	}
+
+	for i, word := range a.words {
+		if word != other.words[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the Age-Partitioned Bloom Filter, which
+// can be used concurrently with the original.
+func (a *AgePartitionedBloomFilter) Clone() *AgePartitionedBloomFilter {
+	c := *a
+	c.words = make([]uint64, len(a.words))
+	copy(c.words, a.words)
+	c.hash = cloneHash64(a.hash)
+	return &c
+}
+
+// ByteSize returns the number of bytes used by the slices and metadata of the
+// filter, excluding the hash function.
+func (a *AgePartitionedBloomFilter) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*a)) + uint64(cap(a.words))*8
+}
+
+// SetHash sets the hashing function used in the filter.
+func (a *AgePartitionedBloomFilter) SetHash(h hash.Hash64) {
+	a.hash = h
+}
+
+// bit returns true if the bit of the physical slice is set.
+func (a *AgePartitionedBloomFilter) bit(slice, i uint) bool {
+	bit := slice*agePartitionedWords(a.m)*64 + i
+	return a.words[bit/64]&(1<<(bit%64)) != 0
+}
+
+// agePartitionedWords returns the number of words in a slice of m bits.
+func agePartitionedWords(m uint) uint {
+	return (m + 63) / 64
+}
+
+// WriteTo writes a binary representation of the AgePartitionedBloomFilter to
+// an I/O stream. The hash function is not written, but the seed is. It returns
+// the number of bytes written.
+func (a *AgePartitionedBloomFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(a.m))
+	e.write(uint64(a.k))
+	e.write(uint64(a.l))
+	e.write(uint64(a.capacity))
+	e.write(uint64(a.count))
+	e.write(uint64(a.generation))
+	e.write(uint64(a.newest))
+	e.write(a.seed)
+	e.write(a.words)
+	writeReduction(&e, a.modulo)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of an AgePartitionedBloomFilter (such
+// as might have been written by WriteTo()) from an I/O stream. The filter keeps
+// its current hash function, which must match the one used by the filter that
+// was written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (a *AgePartitionedBloomFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                                = decoder{r: payload}
+		m, k, l, capacity, count         uint64
+		generation, newest, seed, nWords uint64
+	)
+	d.read(&m)
+	d.read(&k)
+	d.read(&l)
+	d.read(&capacity)
+	d.read(&count)
+	d.read(&generation)
+	d.read(&newest)
+	d.read(&seed)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if m == 0 || k == 0 || capacity == 0 || generation > capacity || newest >= k+l {
+		return n, errors.New("invalid filter parameters")
+	}
+	nWords = (k + l) * uint64(agePartitionedWords(uint(m)))
+	if nWords > uint64(payload.Len())/8 {
+		return n, io.ErrUnexpectedEOF
+	}
+	words := make([]uint64, nWords)
+	d.read(words)
+	modulo, err := readReduction(&d, payload)
+	if err != nil {
+		return n, err
+	}
+
+	if a.hash == nil {
+		a.hash = newFNV64()
+	}
+	a.words = words
+	a.m = uint(m)
+	a.k = uint(k)
+	a.l = uint(l)
+	a.capacity = uint(capacity)
+	a.count = uint(count)
+	a.generation = uint(generation)
+	a.newest = uint(newest)
+	a.seed = seed
+	a.modulo = modulo
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (a *AgePartitionedBloomFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(a)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (a *AgePartitionedBloomFilter) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(a, data)
+}
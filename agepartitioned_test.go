@@ -0,0 +1,105 @@
+package boom
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Ensures that an Age-Partitioned Bloom Filter remembers items for exactly its
+// number of generations.
+func TestAgePartitionedBloomFilterGenerations(t *testing.T) {
+	a := NewAgePartitionedBloomFilter(1000, 3, 0.01, WithSeed(3))
+	if a.Generations() != 3 || a.Slices() != a.K()+2 {
+		t.Errorf("Expected 3 generations and %d slices, got %d and %d", a.K()+2, a.Generations(), a.Slices())
+	}
+
+	// Fill 15 generations; the 14th rotation starts the last one.
+	for i := 0; i < 15000; i++ {
+		a.AddString(strconv.Itoa(i))
+	}
+	if a.Count() != 15000 {
+		t.Errorf("Expected 15000 items, got %d", a.Count())
+	}
+
+	for i := 12000; i < 15000; i++ {
+		if !a.TestString(strconv.Itoa(i)) {
+			t.Fatalf("Expected %d to be a member", i)
+		}
+	}
+
+	// Items at least Slices generations old have no bits left.
+	bound := a.EstimatedFPRate()
+	for _, r := range [][2]int{{0, 3000}, {15000, 115000}} {
+		fp := 0
+		for i := r[0]; i < r[1]; i++ {
+			if a.TestString(strconv.Itoa(i)) {
+				fp++
+			}
+		}
+		if rate := float64(fp) / float64(r[1]-r[0]); rate > bound {
+			t.Errorf("Expected false-positive rate below %f for items %d to %d, got %f", bound, r[0], r[1], rate)
+		}
+	}
+}
+
+// Ensures that Rotate forgets items after the number of generations.
+func TestAgePartitionedBloomFilterRotate(t *testing.T) {
+	a := NewAgePartitionedBloomFilter(100, 2, 0.001)
+	a.AddString("a")
+	if !a.Rotate().TestString("a") {
+		t.Error("Expected a to be a member after 1 rotation")
+	}
+	if a.Rotate().TestString("a") {
+		t.Error("Expected a to be forgotten after 2 rotations")
+	}
+
+	if a.TestAndAddString("b") || !a.TestString("b") {
+		t.Error("Expected b to be added")
+	}
+	a.Reset()
+	if a.TestString("b") || a.Count() != 0 {
+		t.Error("Expected empty filter after reset")
+	}
+}
+
+// Ensures that MarshalBinary and UnmarshalBinary round-trip the filter.
+func TestAgePartitionedBloomFilterReadWrite(t *testing.T) {
+	a := NewAgePartitionedBloomFilter(100, 4, 0.01)
+	for i := 0; i < 350; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+	}
+
+	data, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restored := &AgePartitionedBloomFilter{}
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !restored.Equal(a) {
+		t.Error("Expected restored filter to equal original")
+	}
+
+	clone := a.Clone()
+	clone.Rotate()
+	if clone.Equal(a) {
+		t.Error("Expected clone to be independent")
+	}
+
+	if err := restored.UnmarshalBinary(data[:len(data)-8]); err == nil {
+		t.Error("Expected error for truncated data")
+	}
+}
+
+func BenchmarkAgePartitionedBloomFilterTestAndAdd(b *testing.B) {
+	a := NewAgePartitionedBloomFilter(10000, 8, 0.01)
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		a.TestAndAdd(data[n])
+	}
+}
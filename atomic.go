@@ -0,0 +1,493 @@
+package boom
+
+import (
+	"hash"
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"unsafe"
+)
+
+// atomicPageWords is the number of words in a page of AtomicBuckets, the unit
+// copied when a bucket is first set after a snapshot.
+const atomicPageWords = 512
+
+// atomicPage is a page of the words of AtomicBuckets.
+type atomicPage struct {
+	words []uint64       // read and written atomically
+	owner *AtomicBuckets // buckets allowed to write the page in place
+}
+
+// AtomicBuckets is an array of 1-bit buckets stored in 64-bit words which are
+// read and written atomically, so buckets can be set and tested concurrently
+// without locking. The words are grouped in pages which can be shared with a
+// snapshot, in which case a page is copied the first time a bucket of it is
+// set.
+type AtomicBuckets struct {
+	pages  []unsafe.Pointer // *atomicPage, nil until resolved from the parent
+	parent unsafe.Pointer   // *AtomicBuckets whose pages are shared, if not yet resolved
+	count  uint
+}
+
+// NewAtomicBuckets creates a new AtomicBuckets with the provided number of
+// 1-bit buckets.
+func NewAtomicBuckets(count uint) *AtomicBuckets {
+	a := &AtomicBuckets{count: count}
+	a.pages = a.newPages()
+	return a
+}
+
+// newPages returns cleared pages owned by the buckets.
+func (a *AtomicBuckets) newPages() []unsafe.Pointer {
+	var (
+		words = int((a.count + 63) / 64)
+		pages = make([]unsafe.Pointer, (words+atomicPageWords-1)/atomicPageWords)
+	)
+	for i := range pages {
+		size := words - i*atomicPageWords
+		if size > atomicPageWords {
+			size = atomicPageWords
+		}
+		pages[i] = unsafe.Pointer(&atomicPage{words: make([]uint64, size), owner: a})
+	}
+	return pages
+}
+
+// Count returns the number of buckets.
+func (a *AtomicBuckets) Count() uint {
+	return a.count
+}
+
+// Get returns the value in the specified bucket.
+func (a *AtomicBuckets) Get(bucket uint) uint32 {
+	var (
+		word = bucket / 64
+		page = a.page(word / atomicPageWords)
+	)
+	return uint32(atomic.LoadUint64(&page.words[word%atomicPageWords])>>(bucket%64)) & 1
+}
+
+// Set sets the specified bucket and returns its previous value.
+func (a *AtomicBuckets) Set(bucket uint) uint32 {
+	var (
+		word = bucket / 64
+		mask = uint64(1) << (bucket % 64)
+		page = a.page(word / atomicPageWords)
+	)
+	if atomic.LoadUint64(&page.words[word%atomicPageWords])&mask != 0 {
+		// Already set, so a shared page needn't be copied.
+		return 1
+	}
+
+	addr := &a.ownedPage(word / atomicPageWords).words[word%atomicPageWords]
+	for {
+		old := atomic.LoadUint64(addr)
+		if old&mask != 0 {
+			return 1
+		}
+		if atomic.CompareAndSwapUint64(addr, old, old|mask) {
+			return 0
+		}
+	}
+}
+
+// PopCount returns the number of set buckets.
+func (a *AtomicBuckets) PopCount() uint {
+	count := 0
+	for i := range a.pages {
+		page := a.page(uint(i))
+		for j := range page.words {
+			count += bits.OnesCount64(atomic.LoadUint64(&page.words[j]))
+		}
+	}
+	return uint(count)
+}
+
+// Reset clears every bucket, replacing each page with a cleared one, so a
+// snapshot sharing the pages is unaffected. Returns itself to allow for
+// chaining.
+func (a *AtomicBuckets) Reset() *AtomicBuckets {
+	for i, page := range a.newPages() {
+		atomic.StorePointer(&a.pages[i], page)
+	}
+	return a
+}
+
+// ByteSize returns the number of bytes used by the AtomicBuckets, including
+// pages shared with a snapshot.
+func (a *AtomicBuckets) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*a)) + uint64(cap(a.pages))*pointerSize
+	for i := range a.pages {
+		size += uint64(unsafe.Sizeof(atomicPage{})) + uint64(cap(a.page(uint(i)).words))*8
+	}
+	return size
+}
+
+// page returns the i-th page, resolving it from the parent if it's shared and
+// not yet resolved.
+func (a *AtomicBuckets) page(i uint) *atomicPage {
+	for {
+		if page := atomic.LoadPointer(&a.pages[i]); page != nil {
+			return (*atomicPage)(page)
+		}
+		if parent := (*AtomicBuckets)(atomic.LoadPointer(&a.parent)); parent != nil {
+			atomic.CompareAndSwapPointer(&a.pages[i], nil, unsafe.Pointer(parent.page(i)))
+		}
+	}
+}
+
+// ownedPage returns the i-th page, first replacing it with a copy owned by the
+// buckets if it's shared.
+func (a *AtomicBuckets) ownedPage(i uint) *atomicPage {
+	for {
+		page := a.page(i)
+		if page.owner == a {
+			return page
+		}
+
+		owned := &atomicPage{words: make([]uint64, len(page.words)), owner: a}
+		for j := range owned.words {
+			owned.words[j] = atomic.LoadUint64(&page.words[j])
+		}
+		if atomic.CompareAndSwapPointer(&a.pages[i], unsafe.Pointer(page), unsafe.Pointer(owned)) {
+			return owned
+		}
+	}
+}
+
+// share returns buckets sharing the pages, which must replace these buckets
+// for writers before resolve is called, so that sets of these buckets racing
+// with the replacement can be detected and repeated.
+func (a *AtomicBuckets) share() *AtomicBuckets {
+	return &AtomicBuckets{
+		pages:  make([]unsafe.Pointer, len(a.pages)),
+		parent: unsafe.Pointer(a),
+		count:  a.count,
+	}
+}
+
+// resolve resolves every page of buckets returned by share from the parent and
+// releases it.
+func (a *AtomicBuckets) resolve() {
+	for i := range a.pages {
+		a.page(uint(i))
+	}
+	atomic.StorePointer(&a.parent, nil)
+}
+
+// AtomicBloomFilter implements a classic Bloom filter which is safe for
+// concurrent use without locking. Its bits are kept in AtomicBuckets and, by
+// default, the data is hashed without shared state using 64-bit FNV-1, so it
+// sets the same bits as a BloomFilter using the default hash function with the
+// same capacity, seed, and reduction of hashes to indices.
+type AtomicBloomFilter struct {
+	buckets unsafe.Pointer     // *AtomicBuckets, replaced atomically by Snapshot and Reset
+	m       uint               // filter size
+	k       uint               // number of hash functions
+	count   uint64             // number of items added, updated atomically
+	newHash func() hash.Hash64 // creates a hash function per operation, if set
+	seed    uint64             // hash seed (zero means unseeded)
+	modulo  bool               // reduce hashes to indices with a modulo
+}
+
+// NewAtomicBloomFilter creates a new AtomicBloomFilter optimized to store n
+// items with a specified target false-positive rate. Since hash functions have
+// state, a hash function set with WithHasher is created for every operation.
+func NewAtomicBloomFilter(n uint, fpRate float64, opts ...Option) *AtomicBloomFilter {
+	var (
+		m = OptimalM(n, fpRate)
+		o = applyOptions(opts)
+	)
+	return &AtomicBloomFilter{
+		buckets: unsafe.Pointer(NewAtomicBuckets(m)),
+		m:       m,
+		k:       OptimalK(fpRate),
+		newHash: o.hash64,
+		seed:    o.seed,
+		modulo:  o.modulo,
+	}
+}
+
+// Capacity returns the Bloom filter capacity, m.
+func (a *AtomicBloomFilter) Capacity() uint {
+	return a.m
+}
+
+// K returns the number of hash functions.
+func (a *AtomicBloomFilter) K() uint {
+	return a.k
+}
+
+// Count returns the number of items added to the filter.
+func (a *AtomicBloomFilter) Count() uint {
+	return uint(atomic.LoadUint64(&a.count))
+}
+
+// EstimatedFillRatio returns the current estimated ratio of set bits.
+func (a *AtomicBloomFilter) EstimatedFillRatio() float64 {
+	return 1 - math.Exp((-float64(a.Count())*float64(a.k))/float64(a.m))
+}
+
+// FillRatio returns the ratio of set bits.
+func (a *AtomicBloomFilter) FillRatio() float64 {
+	return float64(a.loadBuckets().PopCount()) / float64(a.m)
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. This is a probabilistic test, meaning there is a
+// non-zero probability of false positives but a zero probability of false
+// negatives.
+func (a *AtomicBloomFilter) Test(data []byte) bool {
+	var (
+		lower, upper = a.hashKernel(data)
+		buckets      = a.loadBuckets()
+	)
+
+	// If any of the K bits are not set, then it's not a member.
+	for i := uint(0); i < a.k; i++ {
+		if buckets.Get(reducedIndex(lower, upper, i, a.m, a.modulo)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Add will add the data to the Bloom filter. It returns the filter to allow
+// for chaining.
+func (a *AtomicBloomFilter) Add(data []byte) Filter {
+	a.TestAndAdd(data)
+	return a
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data is a member, false if not. Each bit is tested and set atomically,
+// but concurrent calls adding the same item may all return false.
+func (a *AtomicBloomFilter) TestAndAdd(data []byte) bool {
+	var (
+		lower, upper = a.hashKernel(data)
+		buckets      = a.loadBuckets()
+		member       = a.set(buckets, lower, upper)
+	)
+	for {
+		// If Snapshot or Reset replaced the buckets while the bits were set,
+		// the new buckets may have missed some, so they're set there too.
+		next := a.loadBuckets()
+		if next == buckets {
+			break
+		}
+		buckets = next
+		a.set(buckets, lower, upper)
+	}
+
+	atomic.AddUint64(&a.count, 1)
+	return member
+}
+
+// set sets the k bits of the base hash values in the buckets and returns true
+// if they were all set.
+func (a *AtomicBloomFilter) set(buckets *AtomicBuckets, lower, upper uint64) bool {
+	member := true
+	for i := uint(0); i < a.k; i++ {
+		if buckets.Set(reducedIndex(lower, upper, i, a.m, a.modulo)) == 0 {
+			member = false
+		}
+	}
+	return member
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (a *AtomicBloomFilter) TestString(data string) bool {
+	return a.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (a *AtomicBloomFilter) AddString(data string) Filter {
+	return a.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (a *AtomicBloomFilter) TestAndAddString(data string) bool {
+	return a.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter. It returns the filter to allow
+// for chaining.
+func (a *AtomicBloomFilter) AddMany(data [][]byte) Filter {
+	for _, d := range data {
+		a.Add(d)
+	}
+	return a
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+func (a *AtomicBloomFilter) TestMany(data [][]byte) []bool {
+	return testMany(data, a.Test)
+}
+
+// Equal returns true if the other AtomicBloomFilter has the same parameters,
+// hash seed, reduction of hashes to indices, number of items added, and bits.
+// It may be called concurrently with other operations, in which case the
+// result reflects the bits as they were read. The hash functions aren't
+// compared.
+func (a *AtomicBloomFilter) Equal(other *AtomicBloomFilter) bool {
+	if a.m != other.m || a.k != other.k || a.seed != other.seed || a.modulo != other.modulo ||
+		a.Count() != other.Count() {
+		return false
+	}
+
+	var (
+		buckets = a.loadBuckets()
+		others  = other.loadBuckets()
+	)
+	for i := range buckets.pages {
+		page, otherPage := buckets.page(uint(i)), others.page(uint(i))
+		for j := range page.words {
+			if atomic.LoadUint64(&page.words[j]) != atomic.LoadUint64(&otherPage.words[j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the AtomicBloomFilter. It may be called
+// concurrently with other operations, in which case the copy holds the bits as
+// they were read.
+func (a *AtomicBloomFilter) Clone() *AtomicBloomFilter {
+	var (
+		from    = a.loadBuckets()
+		buckets = NewAtomicBuckets(from.count)
+	)
+	for i := range buckets.pages {
+		page, fromPage := buckets.page(uint(i)), from.page(uint(i))
+		for j := range page.words {
+			page.words[j] = atomic.LoadUint64(&fromPage.words[j])
+		}
+	}
+
+	return &AtomicBloomFilter{
+		buckets: unsafe.Pointer(buckets),
+		m:       a.m,
+		k:       a.k,
+		count:   atomic.LoadUint64(&a.count),
+		newHash: a.newHash,
+		seed:    a.seed,
+		modulo:  a.modulo,
+	}
+}
+
+// ByteSize returns the number of bytes used by the AtomicBloomFilter's bit
+// array and metadata, excluding the hash function.
+func (a *AtomicBloomFilter) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*a)) + a.loadBuckets().ByteSize()
+}
+
+// Reset restores the Bloom filter to its original state by atomically
+// installing cleared buckets, so concurrent tests see either every bit of the
+// old filter or none of them, and a snapshot taken before keeps its bits.
+// Items added concurrently with Reset may or may not remain members. It
+// returns the filter to allow for chaining.
+func (a *AtomicBloomFilter) Reset() *AtomicBloomFilter {
+	atomic.StorePointer(&a.buckets, unsafe.Pointer(NewAtomicBuckets(a.m)))
+	atomic.StoreUint64(&a.count, 0)
+	return a
+}
+
+// Snapshot returns an immutable view of the filter as of the call, which can
+// keep serving tests while the filter is added to or reset, such as during a
+// periodic rotation. Taking one doesn't copy the bits: the filter and the
+// snapshot share pages of them until the filter first sets a bit of a page,
+// which copies it, so a snapshot costs up to the filter's size in memory as it
+// diverges. Items added concurrently with Snapshot may or may not be members
+// of the snapshot.
+func (a *AtomicBloomFilter) Snapshot() *AtomicBloomSnapshot {
+	for {
+		buckets := a.loadBuckets()
+		shared := buckets.share()
+		if atomic.CompareAndSwapPointer(&a.buckets, unsafe.Pointer(buckets), unsafe.Pointer(shared)) {
+			shared.resolve()
+			return &AtomicBloomSnapshot{filter: &AtomicBloomFilter{
+				buckets: unsafe.Pointer(buckets),
+				m:       a.m,
+				k:       a.k,
+				count:   atomic.LoadUint64(&a.count),
+				newHash: a.newHash,
+				seed:    a.seed,
+				modulo:  a.modulo,
+			}}
+		}
+	}
+}
+
+// loadBuckets returns the current buckets.
+func (a *AtomicBloomFilter) loadBuckets() *AtomicBuckets {
+	return (*AtomicBuckets)(atomic.LoadPointer(&a.buckets))
+}
+
+// AtomicBloomSnapshot is an immutable view of an AtomicBloomFilter returned by
+// Snapshot. It's safe for concurrent use.
+type AtomicBloomSnapshot struct {
+	filter *AtomicBloomFilter
+}
+
+// Capacity returns the Bloom filter capacity, m.
+func (s *AtomicBloomSnapshot) Capacity() uint {
+	return s.filter.Capacity()
+}
+
+// K returns the number of hash functions.
+func (s *AtomicBloomSnapshot) K() uint {
+	return s.filter.K()
+}
+
+// Count returns the number of items added to the filter when the snapshot was
+// taken.
+func (s *AtomicBloomSnapshot) Count() uint {
+	return s.filter.Count()
+}
+
+// FillRatio returns the ratio of set bits.
+func (s *AtomicBloomSnapshot) FillRatio() float64 {
+	return s.filter.FillRatio()
+}
+
+// Test will test for membership of the data and returns true if it was a
+// member when the snapshot was taken, false if not.
+func (s *AtomicBloomSnapshot) Test(data []byte) bool {
+	return s.filter.Test(data)
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (s *AtomicBloomSnapshot) TestString(data string) bool {
+	return s.filter.TestString(data)
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+func (s *AtomicBloomSnapshot) TestMany(data [][]byte) []bool {
+	return s.filter.TestMany(data)
+}
+
+// Clone returns a new AtomicBloomFilter holding the bits of the snapshot,
+// such as to restore it.
+func (s *AtomicBloomSnapshot) Clone() *AtomicBloomFilter {
+	return s.filter.Clone()
+}
+
+// hashKernel returns the upper and lower base hash values from which the k
+// hashes are derived, like sizedHashKernel.
+func (a *AtomicBloomFilter) hashKernel(data []byte) (uint64, uint64) {
+	if a.newHash != nil || uint64(a.m) > 1<<32 {
+		hash := a.newHash
+		if hash == nil {
+			hash = newFNV64
+		}
+		return sizedHashKernel(data, hash(), a.seed, a.m)
+	}
+	lower, upper := fnvHashKernel(data, a.seed)
+	return uint64(lower), uint64(upper)
+}
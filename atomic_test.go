@@ -0,0 +1,201 @@
+package boom
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Ensures that AtomicBuckets set and count bits across word boundaries.
+func TestAtomicBuckets(t *testing.T) {
+	b := NewAtomicBuckets(130)
+	if count := b.Count(); count != 130 {
+		t.Errorf("Expected 130, got %d", count)
+	}
+
+	if old := b.Set(63); old != 0 {
+		t.Errorf("Expected 0, got %d", old)
+	}
+	if old := b.Set(63); old != 1 {
+		t.Errorf("Expected 1, got %d", old)
+	}
+	b.Set(64)
+	b.Set(129)
+
+	for _, bucket := range []uint{63, 64, 129} {
+		if b.Get(bucket) != 1 {
+			t.Errorf("Expected bucket %d to be set", bucket)
+		}
+	}
+
+	if b.Get(62) != 0 {
+		t.Error("Expected bucket 62 not to be set")
+	}
+
+	if count := b.PopCount(); count != 3 {
+		t.Errorf("Expected 3, got %d", count)
+	}
+
+	if count := b.Reset().PopCount(); count != 0 {
+		t.Errorf("Expected 0, got %d", count)
+	}
+}
+
+// Ensures that an AtomicBloomFilter sets the same bits as a BloomFilter using
+// the default hash function.
+func TestAtomicBloomFilterMatchesBloomFilter(t *testing.T) {
+	var (
+		a = NewAtomicBloomFilter(1000, 0.01)
+		f = NewBloomFilter(1000, 0.01)
+	)
+	for i := 0; i < 500; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	for i := uint(0); i < f.Capacity(); i++ {
+		if a.loadBuckets().Get(i) != f.buckets.Get(i) {
+			t.Fatalf("Expected bit %d to match", i)
+		}
+	}
+
+	if a.FillRatio() != f.FillRatio() {
+		t.Errorf("Expected %f, got %f", f.FillRatio(), a.FillRatio())
+	}
+}
+
+// Ensures that an AtomicBloomFilter can be added to and tested concurrently.
+func TestAtomicBloomFilterConcurrent(t *testing.T) {
+	a := NewAtomicBloomFilter(10000, 0.01)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				data := []byte(strconv.Itoa(g*1000 + i))
+				a.Add(data)
+				if !a.Test(data) {
+					t.Errorf("Expected %s to be a member", data)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if count := a.Count(); count != 8000 {
+		t.Errorf("Expected 8000, got %d", count)
+	}
+
+	if !a.TestAndAdd([]byte(`0`)) {
+		t.Error("`0` should be a member")
+	}
+
+	if a.Reset().Test([]byte(`0`)) {
+		t.Error("`0` should not be a member after Reset")
+	}
+}
+
+// Ensures that a snapshot of an AtomicBloomFilter keeps its bits while the
+// filter is added to and reset.
+func TestAtomicBloomFilterSnapshot(t *testing.T) {
+	a := NewAtomicBloomFilter(100000, 0.01)
+	for i := 0; i < 1000; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+	}
+
+	snapshot := a.Snapshot()
+	for i := 1000; i < 2000; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+	}
+	if clone := snapshot.Clone(); clone.Count() != 1000 || !clone.Test([]byte(`0`)) {
+		t.Errorf("Expected clone with 1000 items, got %d", clone.Count())
+	}
+	a.Reset()
+
+	if count := snapshot.Count(); count != 1000 {
+		t.Errorf("Expected 1000, got %d", count)
+	}
+	fp := 0
+	for i := 0; i < 2000; i++ {
+		member := snapshot.TestString(strconv.Itoa(i))
+		if i < 1000 && !member {
+			t.Errorf("Expected %d to be a member of the snapshot", i)
+		}
+		if i >= 1000 && member {
+			fp++
+		}
+	}
+	if fp > 20 {
+		t.Errorf("Expected few items added after the snapshot to be members, got %d", fp)
+	}
+	if a.Test([]byte(`0`)) || a.Count() != 0 {
+		t.Error("Expected the filter to be reset")
+	}
+}
+
+// Ensures that items added to an AtomicBloomFilter concurrently with snapshots
+// remain members.
+func TestAtomicBloomFilterSnapshotConcurrent(t *testing.T) {
+	var (
+		a    = NewAtomicBloomFilter(100000, 0.01)
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				a.Snapshot()
+			}
+		}
+	}()
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				data := []byte(strconv.Itoa(g*2000 + i))
+				a.Add(data)
+				if !a.Test(data) {
+					t.Errorf("Expected %s to be a member", data)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(done)
+
+	for i := 0; i < 8000; i++ {
+		if !a.Test([]byte(strconv.Itoa(i))) {
+			t.Fatalf("Expected %d to be a member", i)
+		}
+	}
+}
+
+func BenchmarkAtomicBloomAdd(b *testing.B) {
+	b.StopTimer()
+	f := NewAtomicBloomFilter(100000, 0.1)
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.Add(data[n])
+	}
+}
+
+func BenchmarkAtomicBloomAddParallel(b *testing.B) {
+	f := NewAtomicBloomFilter(100000, 0.1)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			f.Add([]byte(strconv.Itoa(i)))
+			i++
+		}
+	})
+}
@@ -0,0 +1,186 @@
+package boom
+
+import (
+	"errors"
+	"math"
+)
+
+// AuditStats is a snapshot of the measurements of an AuditedFilter. Only tests
+// of sampled keys are counted, so the rates are estimated from them.
+type AuditStats struct {
+	Tracked        uint   // sampled keys in the exact set
+	Negatives      uint64 // tests of sampled keys not in the exact set
+	FalsePositives uint64 // tests of those reported as members
+	Positives      uint64 // tests of sampled keys in the exact set
+	FalseNegatives uint64 // tests of those reported as non-members
+}
+
+// FPRate returns the measured false-positive rate, the fraction of tests of
+// sampled non-members reported as members, or zero if there were none.
+func (s AuditStats) FPRate() float64 {
+	if s.Negatives == 0 {
+		return 0
+	}
+	return float64(s.FalsePositives) / float64(s.Negatives)
+}
+
+// FNRate returns the measured false-negative rate, the fraction of tests of
+// sampled members reported as non-members, or zero if there were none.
+func (s AuditStats) FNRate() float64 {
+	if s.Positives == 0 {
+		return 0
+	}
+	return float64(s.FalseNegatives) / float64(s.Positives)
+}
+
+// AuditedFilter wraps a Filter, shadowing it with an exact set of a sampled
+// fraction of the keys added, so its false-positive and false-negative rates
+// are measured at runtime rather than estimated from its parameters. This
+// validates the tuning of filters whose rates depend on the stream, such as the
+// false negatives of a StableBloomFilter evicting old keys or of a
+// DeletableBloomFilter failing to remove colliding ones. Whether a key is
+// sampled is decided by its hash, so every addition, test, and removal of a
+// sampled key is seen by the exact set, which takes memory proportional to the
+// sampled keys.
+//
+// An AuditedFilter isn't safe for concurrent use.
+type AuditedFilter struct {
+	filter    Filter              // audited filter
+	threshold uint64              // largest hash of a sampled key
+	all       bool                // sample every key
+	set       map[string]struct{} // sampled keys which are members
+	stats     AuditStats          // measurements so far
+}
+
+// NewAuditedFilter creates a new AuditedFilter wrapping the filter which
+// samples the fraction of keys, between 0 and 1, such as 0.01 to track one key
+// in a hundred.
+func NewAuditedFilter(filter Filter, fraction float64) *AuditedFilter {
+	a := &AuditedFilter{filter: filter, set: make(map[string]struct{})}
+	switch {
+	case fraction >= 1:
+		a.all = true
+	case fraction > 0:
+		a.threshold = uint64(fraction * math.MaxUint64)
+	}
+	return a
+}
+
+// Filter returns the wrapped filter.
+func (a *AuditedFilter) Filter() Filter {
+	return a.filter
+}
+
+// Stats returns the measurements so far.
+func (a *AuditedFilter) Stats() AuditStats {
+	stats := a.stats
+	stats.Tracked = uint(len(a.set))
+	return stats
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. The result is checked against the exact set if the
+// data is sampled.
+func (a *AuditedFilter) Test(data []byte) bool {
+	member := a.filter.Test(data)
+	a.audit(data, member)
+	return member
+}
+
+// Add will add the data to the filter and to the exact set if it's sampled. It
+// returns the AuditedFilter to allow for chaining.
+func (a *AuditedFilter) Add(data []byte) Filter {
+	a.filter.Add(data)
+	a.track(data)
+	return a
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data is a member, false if not.
+func (a *AuditedFilter) TestAndAdd(data []byte) bool {
+	member := a.filter.TestAndAdd(data)
+	a.audit(data, member)
+	a.track(data)
+	return member
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (a *AuditedFilter) TestString(data string) bool {
+	return a.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (a *AuditedFilter) AddString(data string) Filter {
+	return a.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (a *AuditedFilter) TestAndAddString(data string) bool {
+	return a.TestAndAdd(stringBytes(data))
+}
+
+// TestAndRemove removes the data from the filter, such as a
+// DeletableBloomFilter or CuckooFilter, and from the exact set, returning true
+// if the filter reported it as a member. The result is checked like Test's. It
+// returns false without removing anything if the filter doesn't support
+// removal.
+func (a *AuditedFilter) TestAndRemove(data []byte) bool {
+	f, ok := a.filter.(interface {
+		TestAndRemove([]byte) bool
+	})
+	if !ok {
+		return false
+	}
+
+	member := f.TestAndRemove(data)
+	a.audit(data, member)
+	delete(a.set, string(data))
+	return member
+}
+
+// Reset restores the wrapped filter to its original state and clears the
+// exact set, keeping the measurements. Returns an error if the filter can't be
+// reset, such as a TieredFilter.
+func (a *AuditedFilter) Reset() error {
+	if !resetFilter(a.filter) {
+		return errors.New("filter can't be reset")
+	}
+	a.set = make(map[string]struct{})
+	return nil
+}
+
+// sampled returns true if the data is tracked by the exact set.
+func (a *AuditedFilter) sampled(data []byte) bool {
+	return a.all || a.threshold > 0 && xxhash64(data, 0) <= a.threshold
+}
+
+// track adds the data to the exact set if it's sampled.
+func (a *AuditedFilter) track(data []byte) {
+	if a.sampled(data) {
+		a.set[string(data)] = struct{}{}
+	}
+}
+
+// audit checks the result of a test of the data against the exact set if it's
+// sampled.
+func (a *AuditedFilter) audit(data []byte, member bool) {
+	if !a.sampled(data) {
+		return
+	}
+
+	if _, ok := a.set[string(data)]; ok {
+		a.stats.Positives++
+		if !member {
+			a.stats.FalseNegatives++
+		}
+		return
+	}
+
+	a.stats.Negatives++
+	if member {
+		a.stats.FalsePositives++
+	}
+}
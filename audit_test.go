@@ -0,0 +1,77 @@
+package boom
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Ensures that AuditedFilter measures the false-positive rate of the wrapped
+// filter on the sampled keys.
+func TestAuditedFilter(t *testing.T) {
+	f := NewAuditedFilter(NewBloomFilter(1000, 0.01), 0.2)
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	for i := 0; i < 100000; i++ {
+		if member := f.Test([]byte(strconv.Itoa(i))); i < 1000 && !member {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+
+	stats := f.Stats()
+	if stats.Tracked < 150 || stats.Tracked > 250 {
+		t.Errorf("Expected about 200 tracked keys, got %d", stats.Tracked)
+	}
+	if stats.Positives != uint64(stats.Tracked) || stats.FalseNegatives != 0 || stats.FNRate() != 0 {
+		t.Errorf("Expected %d positives without false negatives, got %d and %d",
+			stats.Tracked, stats.Positives, stats.FalseNegatives)
+	}
+	if stats.Negatives < 15000 || stats.Negatives > 25000 {
+		t.Errorf("Expected about 20000 negatives, got %d", stats.Negatives)
+	}
+	if rate := stats.FPRate(); rate < 0.005 || rate > 0.02 {
+		t.Errorf("Expected false-positive rate near 0.01, got %f", rate)
+	}
+
+	if err := f.Reset(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stats := f.Stats(); stats.Tracked != 0 || stats.Negatives == 0 {
+		t.Errorf("Expected no tracked keys and the measurements kept, got %+v", stats)
+	}
+
+	if rate := (AuditStats{}).FPRate(); rate != 0 {
+		t.Errorf("Expected 0, got %f", rate)
+	}
+	if stats := NewAuditedFilter(NewBloomFilter(10, 0.01), 0).Add([]byte(`a`)).(*AuditedFilter).Stats(); stats.Tracked != 0 {
+		t.Errorf("Expected no tracked keys, got %d", stats.Tracked)
+	}
+}
+
+// Ensures that AuditedFilter measures the false negatives of a Stable Bloom
+// Filter evicting keys and follows removals.
+func TestAuditedFilterFalseNegatives(t *testing.T) {
+	f := NewAuditedFilter(NewStableBloomFilter(1000, 1, 0.01), 1)
+	for i := 0; i < 10000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	for i := 0; i < 1000; i++ {
+		f.Test([]byte(strconv.Itoa(i)))
+	}
+	if stats := f.Stats(); stats.Positives != 1000 || stats.FNRate() < 0.5 {
+		t.Errorf("Expected most of the 1000 oldest keys evicted, got %d of %d", stats.FalseNegatives, stats.Positives)
+	}
+
+	if f.TestAndRemove([]byte(`1`)) {
+		t.Error("Expected no removal from a Stable Bloom Filter")
+	}
+
+	d := NewAuditedFilter(NewDeletableBloomFilter(100, 10, 0.01), 1)
+	d.Add([]byte(`a`)).Add([]byte(`b`))
+	if !d.TestAndRemove([]byte(`a`)) {
+		t.Error("Expected a to be removed")
+	}
+	if stats := d.Stats(); stats.Tracked != 1 || stats.Positives != 1 {
+		t.Errorf("Expected 1 tracked key and 1 positive, got %d and %d", stats.Tracked, stats.Positives)
+	}
+}
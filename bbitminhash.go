@@ -0,0 +1,167 @@
+package boom
+
+import (
+	"errors"
+	"io"
+	"math"
+	"unsafe"
+)
+
+// BBitMinHash is a MinHashSignature compressed to the lowest b bits of each
+// minimum, as described by Li and König in b-Bit Minwise Hashing:
+//
+// https://arxiv.org/abs/0910.3349
+//
+// With b = 2 it takes 32 times less space than the signature, for storing the
+// signatures of many documents to detect near-duplicates. Unrelated minimums
+// also share their lowest b bits with a probability of 1/2^b, so the fraction
+// of values two compressed signatures share, P, overestimates the similarity,
+// and the similarity is estimated as (P - 1/2^b) / (1 - 1/2^b). This assumes
+// the sets are small relative to the hash space, which always holds for 64-bit
+// hashes. Its variance is 1/(1 - 1/2^b)^2 times that of the fraction, which
+// StandardError accounts for, so b = 1 needs about 4 times the hash functions
+// of an uncompressed signature with the same accuracy but takes 16 times less
+// space still.
+type BBitMinHash struct {
+	values *Buckets // lowest b bits of each minimum
+	seed   uint64   // hash seed of the signature
+}
+
+// Compress returns the b-bit MinHash of the signature, keeping the lowest b
+// bits of each minimum. Returns an error if b isn't between 1 and 8.
+func (s *MinHashSignature) Compress(b uint) (*BBitMinHash, error) {
+	if b == 0 || b > 8 {
+		return nil, errors.New("b must be between 1 and 8")
+	}
+
+	values := NewBuckets(uint(len(s.mins)), uint8(b))
+	for i, v := range s.mins {
+		values.Set(uint(i), uint8(v&(1<<b-1)))
+	}
+	return &BBitMinHash{values: values, seed: s.seed}, nil
+}
+
+// K returns the number of hash functions of the signature.
+func (m *BBitMinHash) K() uint {
+	return m.values.Count()
+}
+
+// B returns the number of bits kept of each minimum.
+func (m *BBitMinHash) B() uint {
+	return uint(m.values.bucketSize)
+}
+
+// Similarity returns the approximated Jaccard similarity of the sets of the
+// signatures, correcting the fraction of values the compressed signatures
+// share for those which match by chance. It's between zero and one. The
+// compressed signatures of empty sets aren't told apart from those of
+// identical sets. Returns an error if the signatures have a different number
+// of hash functions, bits, or hash seed.
+func (m *BBitMinHash) Similarity(other *BBitMinHash) (float64, error) {
+	if m.K() != other.K() {
+		return 0, errors.New("number of hash functions must match")
+	}
+
+	if m.B() != other.B() {
+		return 0, errors.New("number of bits must match")
+	}
+
+	if m.seed != other.seed {
+		return 0, errors.New("hash seed must match")
+	}
+
+	matches := 0
+	for i := uint(0); i < m.K(); i++ {
+		if m.values.Get(i) == other.values.Get(i) {
+			matches++
+		}
+	}
+
+	var (
+		c          = m.chance()
+		similarity = (float64(matches)/float64(m.K()) - c) / (1 - c)
+	)
+	return math.Max(0, math.Min(1, similarity)), nil
+}
+
+// StandardError returns the standard error of a similarity estimated by
+// compressed signatures like this one.
+func (m *BBitMinHash) StandardError(similarity float64) float64 {
+	var (
+		c = m.chance()
+		p = c + (1-c)*similarity
+	)
+	return math.Sqrt(p*(1-p)/float64(m.K())) / (1 - c)
+}
+
+// chance returns the probability that the values of unrelated minimums match.
+func (m *BBitMinHash) chance() float64 {
+	return math.Ldexp(1, -int(m.B()))
+}
+
+// Equal returns true if the other BBitMinHash has the same number of hash
+// functions, bits, hash seed, and values.
+func (m *BBitMinHash) Equal(other *BBitMinHash) bool {
+	return m.seed == other.seed && m.values.Equal(other.values)
+}
+
+// ByteSize returns the number of bytes used by the values and metadata of the
+// compressed signature.
+func (m *BBitMinHash) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*m)) + m.values.ByteSize()
+}
+
+// WriteTo writes a binary representation of the BBitMinHash to an I/O stream.
+// It returns the number of bytes written.
+func (m *BBitMinHash) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(m.seed)
+	e.writeTo(m.values)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a BBitMinHash (such as might have
+// been written by WriteTo()) from an I/O stream. Returns ErrUnsupportedVersion
+// if the data was written by an incompatible version of the package. It
+// returns the number of bytes read.
+func (m *BBitMinHash) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d      = decoder{r: payload}
+		seed   uint64
+		values = &Buckets{}
+	)
+	d.read(&seed)
+	d.readFrom(values)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if values.bucketSize == 0 || values.bucketSize > 8 || values.count == 0 {
+		return n, errors.New("invalid signature parameters")
+	}
+
+	m.values = values
+	m.seed = seed
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (m *BBitMinHash) MarshalBinary() ([]byte, error) {
+	return marshalBinary(m)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (m *BBitMinHash) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(m, data)
+}
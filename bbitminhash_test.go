@@ -0,0 +1,77 @@
+package boom
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// Ensures that BBitMinHash corrects for values matching by chance, estimating
+// about the similarity of the uncompressed signatures.
+func TestBBitMinHash(t *testing.T) {
+	a, b := NewMinHashSignature(1024), NewMinHashSignature(1024)
+	for i := 0; i < 3000; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+		b.Add([]byte(strconv.Itoa(i + 1000)))
+	}
+	if _, err := a.Compress(9); err == nil {
+		t.Error("Expected an error for b more than 8")
+	}
+
+	for _, bits := range []uint{1, 2, 8} {
+		x, err := a.Compress(bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		y, _ := b.Compress(bits)
+		if x.K() != 1024 || x.B() != bits {
+			t.Errorf("Expected 1024 and %d, got %d and %d", bits, x.K(), x.B())
+		}
+
+		similarity, err := x.Similarity(y)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tolerance := 4 * x.StandardError(0.5); math.Abs(similarity-0.5) > tolerance {
+			t.Errorf("Expected similarity within %f of 0.5 for b = %d, got %f", tolerance, bits, similarity)
+		}
+		if similarity, _ := x.Similarity(x); similarity != 1 {
+			t.Errorf("Expected similarity 1, got %f", similarity)
+		}
+	}
+
+	x, _ := a.Compress(1)
+	y, _ := a.Compress(2)
+	if _, err := x.Similarity(y); err == nil {
+		t.Error("Expected an error for different numbers of bits")
+	}
+	if x.StandardError(0.5) <= y.StandardError(0.5) {
+		t.Error("Expected fewer bits to have a larger standard error")
+	}
+}
+
+// Ensures that a BBitMinHash read from its written representation into the
+// zero value equals the original.
+func TestBBitMinHashWriteToReadFrom(t *testing.T) {
+	s := NewMinHashSignature(100, WithSeed(5))
+	for i := 0; i < 100; i++ {
+		s.Add([]byte(strconv.Itoa(i)))
+	}
+	expected, err := s.Compress(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := expected.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var read BBitMinHash
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !read.Equal(expected) {
+		t.Error("Expected the read signature to equal the original")
+	}
+}
@@ -0,0 +1,223 @@
+package boom
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+)
+
+// NewBitsAndBloomsFilter creates a new Bloom filter optimized to store n items
+// with a specified target false-positive rate which is interchangeable with a
+// filter of the bits-and-blooms/bloom package (formerly willf/bloom). It uses
+// that package's parameter estimation and derives its k indices the same way,
+// from the 128-bit MurmurHash3 of the data and of the data followed by a one
+// byte. Use WriteBitsAndBloomsTo and ReadBitsAndBloomsFrom, or their JSON
+// counterparts, to exchange filters with it. SetHash and the seed have no
+// effect on a filter using this scheme.
+func NewBitsAndBloomsFilter(n uint, fpRate float64) *BloomFilter {
+	var (
+		m = uint(math.Ceil(-1 * float64(n) * math.Log(fpRate) / math.Pow(math.Log(2), 2)))
+		k = uint(math.Ceil(math.Log(2) * float64(m) / float64(n)))
+	)
+	return newBitsAndBloomsFilter(m, k)
+}
+
+// newBitsAndBloomsFilter returns an empty Bloom filter with m bits and k hash
+// functions using the bits-and-blooms hashing scheme.
+func newBitsAndBloomsFilter(m, k uint) *BloomFilter {
+	if m < 1 {
+		m = 1
+	}
+	if k < 1 {
+		k = 1
+	}
+
+	return &BloomFilter{
+		buckets: NewBuckets(m, 1),
+		hash:    newFNV64(),
+		m:       m,
+		k:       k,
+		scheme:  schemeBitsAndBlooms,
+	}
+}
+
+// WriteBitsAndBloomsTo writes the binary representation used by the
+// bits-and-blooms/bloom package's WriteTo to an I/O stream: m and k followed by
+// the bit set's length and 64-bit words, all big-endian. Returns an error if
+// the filter wasn't created with NewBitsAndBloomsFilter or read with
+// ReadBitsAndBloomsFrom, since the bits would be meaningless to that package.
+// It returns the number of bytes written.
+func (b *BloomFilter) WriteBitsAndBloomsTo(stream io.Writer) (int64, error) {
+	if b.scheme != schemeBitsAndBlooms {
+		return 0, errors.New("filter doesn't use the bits-and-blooms hashing scheme")
+	}
+
+	words := make([]uint64, (b.m+63)/64)
+	for i := uint(0); i < b.m; i++ {
+		if b.buckets.Get(i) == 1 {
+			words[i/64] |= 1 << (i % 64)
+		}
+	}
+
+	var e encoder
+	e.write(uint64(b.m))
+	e.write(uint64(b.k))
+	e.write(uint64(b.m))
+	e.write(words)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	n, err := stream.Write(e.buf.Bytes())
+	return int64(n), err
+}
+
+// ReadBitsAndBloomsFrom reads a binary representation written by the
+// bits-and-blooms/bloom package's WriteTo (or by WriteBitsAndBloomsTo) from an
+// I/O stream, replacing the filter's contents. The filter adopts the
+// bits-and-blooms hashing scheme, so Test agrees with that package. Since the
+// representation doesn't include the number of items added, Count returns the
+// number estimated from the set bits. It returns the number of bytes read.
+func (b *BloomFilter) ReadBitsAndBloomsFrom(stream io.Reader) (int64, error) {
+	var (
+		counter      = &countingReader{r: stream}
+		d            = decoder{r: counter}
+		m, k, length uint64
+	)
+	d.read(&m)
+	d.read(&k)
+	d.read(&length)
+	if d.err != nil {
+		return counter.n, d.err
+	}
+
+	if length != m || m == 0 || k == 0 || k > m {
+		return counter.n, errors.New("invalid filter parameters")
+	}
+
+	// Read the words in chunks so a corrupted length can't cause a huge
+	// allocation before the stream runs out.
+	var words []uint64
+	for remaining := (length + 63) / 64; remaining > 0 && d.err == nil; {
+		chunk := make([]uint64, minUint64(remaining, 1<<16))
+		d.read(chunk)
+		words = append(words, chunk...)
+		remaining -= uint64(len(chunk))
+	}
+	if d.err != nil {
+		return counter.n, d.err
+	}
+
+	buckets := NewBuckets(uint(m), 1)
+	for i := uint(0); i < uint(m); i++ {
+		if words[i/64]&(1<<(i%64)) != 0 {
+			buckets.Set(i, 1)
+		}
+	}
+
+	b.buckets = buckets
+	b.m = uint(m)
+	b.k = uint(k)
+	b.count = uint(estimateCardinality(b.m, b.k, b.popCount()) + 0.5)
+	b.seed = 0
+	b.fpRate = 0
+	b.scheme = schemeBitsAndBlooms
+	return counter.n, nil
+}
+
+// bitsAndBloomsJSON is the JSON representation of a bits-and-blooms/bloom
+// filter. The bit set is the base64 encoding of its binary representation.
+type bitsAndBloomsJSON struct {
+	M uint   `json:"m"`
+	K uint   `json:"k"`
+	B string `json:"b"`
+}
+
+// MarshalBitsAndBloomsJSON returns the JSON representation used by the
+// bits-and-blooms/bloom package's MarshalJSON. Returns an error under the same
+// conditions as WriteBitsAndBloomsTo.
+func (b *BloomFilter) MarshalBitsAndBloomsJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := b.WriteBitsAndBloomsTo(&buf); err != nil {
+		return nil, err
+	}
+
+	// The bit set's binary representation follows m and k.
+	return json.Marshal(bitsAndBloomsJSON{
+		M: b.m,
+		K: b.k,
+		B: base64.URLEncoding.EncodeToString(buf.Bytes()[16:]),
+	})
+}
+
+// UnmarshalBitsAndBloomsJSON reads the JSON representation produced by the
+// bits-and-blooms/bloom package's MarshalJSON (or by MarshalBitsAndBloomsJSON),
+// replacing the filter's contents as ReadBitsAndBloomsFrom does.
+func (b *BloomFilter) UnmarshalBitsAndBloomsJSON(data []byte) error {
+	var j bitsAndBloomsJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+
+	bits, err := base64.URLEncoding.DecodeString(j.B)
+	if err != nil {
+		return err
+	}
+
+	var header [16]byte
+	binary.BigEndian.PutUint64(header[:], uint64(j.M))
+	binary.BigEndian.PutUint64(header[8:], uint64(j.K))
+	_, err = b.ReadBitsAndBloomsFrom(io.MultiReader(bytes.NewReader(header[:]), bytes.NewReader(bits)))
+	return err
+}
+
+// bitsAndBloomsIndices fills indices with the bit indices of the data for a
+// filter of m bits using the bits-and-blooms hashing scheme.
+func bitsAndBloomsIndices(data []byte, m uint, indices []uint) {
+	h := bitsAndBloomsHashes(data)
+	for i := range indices {
+		indices[i] = bitsAndBloomsLocation(h, uint(i), m)
+	}
+}
+
+// bitsAndBloomsHashes returns the four base hash values of the data used by
+// the bits-and-blooms hashing scheme: the two halves of the 128-bit
+// MurmurHash3 of the data followed by the two halves of that of the data with
+// a one byte appended.
+func bitsAndBloomsHashes(data []byte) [4]uint64 {
+	var h [4]uint64
+	h[0], h[1] = murmur3Sum128(data, 0)
+	h[2], h[3] = murmur3Sum128(append(data[:len(data):len(data)], 1), 0)
+	return h
+}
+
+// bitsAndBloomsLocation returns the i-th bit index of the base hash values
+// for a filter of m bits.
+func bitsAndBloomsLocation(h [4]uint64, i, m uint) uint {
+	ii := uint64(i)
+	return uint((h[ii%2] + ii*h[2+(((ii+ii%2)%4)/2)]) % uint64(m))
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// minUint64 returns the smaller of a and b.
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
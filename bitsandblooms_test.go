@@ -0,0 +1,158 @@
+package boom
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+// Ensures that NewBitsAndBloomsFilter uses the parameter estimation of the
+// bits-and-blooms/bloom package.
+func TestNewBitsAndBloomsFilter(t *testing.T) {
+	f := NewBitsAndBloomsFilter(1000, 0.01)
+
+	if m := f.Capacity(); m != 9586 {
+		t.Errorf("Expected 9586, got %d", m)
+	}
+
+	if k := f.K(); k != 7 {
+		t.Errorf("Expected 7, got %d", k)
+	}
+
+	if f.TestAndAdd([]byte(`a`)) {
+		t.Error("`a` should not be a member")
+	}
+
+	if !f.Test([]byte(`a`)) {
+		t.Error("`a` should be a member")
+	}
+}
+
+// Ensures that WriteBitsAndBloomsTo writes m, k, the bit set length, and the
+// 64-bit words with bit i at position i % 64 of word i / 64.
+func TestBitsAndBloomsWriteTo(t *testing.T) {
+	f := NewBitsAndBloomsFilter(100, 0.01)
+	f.Add([]byte(`a`))
+
+	var buf bytes.Buffer
+	n, err := f.WriteBitsAndBloomsTo(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var (
+		data  = buf.Bytes()
+		words = (f.Capacity() + 63) / 64
+	)
+	if n != int64(len(data)) || len(data) != 24+8*int(words) {
+		t.Fatalf("Expected %d bytes, got %d", 24+8*words, n)
+	}
+
+	if m := binary.BigEndian.Uint64(data); m != uint64(f.Capacity()) {
+		t.Errorf("Expected %d, got %d", f.Capacity(), m)
+	}
+	if k := binary.BigEndian.Uint64(data[8:]); k != uint64(f.K()) {
+		t.Errorf("Expected %d, got %d", f.K(), k)
+	}
+	if length := binary.BigEndian.Uint64(data[16:]); length != uint64(f.Capacity()) {
+		t.Errorf("Expected %d, got %d", f.Capacity(), length)
+	}
+
+	h := bitsAndBloomsHashes([]byte(`a`))
+	for i := uint(0); i < f.K(); i++ {
+		loc := bitsAndBloomsLocation(h, i, f.Capacity())
+		word := binary.BigEndian.Uint64(data[24+8*(loc/64):])
+		if word&(1<<(loc%64)) == 0 {
+			t.Errorf("Expected bit %d to be set", loc)
+		}
+	}
+
+	if _, err := NewBloomFilter(100, 0.01).WriteBitsAndBloomsTo(&buf); err == nil {
+		t.Error("Expected error for filter using a different hashing scheme")
+	}
+}
+
+// Ensures that a filter read with ReadBitsAndBloomsFrom has the same members.
+func TestBitsAndBloomsReadFrom(t *testing.T) {
+	f := NewBitsAndBloomsFilter(1000, 0.01)
+	for i := 0; i < 500; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	var buf bytes.Buffer
+	wn, err := f.WriteBitsAndBloomsTo(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	restored := NewBloomFilter(10, 0.1)
+	rn, err := restored.ReadBitsAndBloomsFrom(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if wn != rn {
+		t.Errorf("Expected %d bytes read, got %d", wn, rn)
+	}
+
+	if restored.Capacity() != f.Capacity() || restored.K() != f.K() {
+		t.Errorf("Expected %d, %d, got %d, %d", f.Capacity(), f.K(), restored.Capacity(), restored.K())
+	}
+
+	if count := restored.Count(); count < 480 || count > 520 {
+		t.Errorf("Expected approximately 500, got %d", count)
+	}
+
+	for i := 0; i < 2000; i++ {
+		data := []byte(strconv.Itoa(i))
+		if restored.Test(data) != f.Test(data) {
+			t.Errorf("Expected %t for %s, got %t", f.Test(data), data, restored.Test(data))
+		}
+	}
+
+	if _, err := restored.ReadBitsAndBloomsFrom(bytes.NewReader(make([]byte, 24))); err == nil {
+		t.Error("Expected error for invalid parameters")
+	}
+}
+
+// Ensures that the JSON representation matches that of the bits-and-blooms
+// package and survives a round trip.
+func TestBitsAndBloomsJSON(t *testing.T) {
+	f := NewBitsAndBloomsFilter(100, 0.01)
+	f.Add([]byte(`a`)).Add([]byte(`b`))
+
+	data, err := f.MarshalBitsAndBloomsJSON()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var j struct {
+		M uint   `json:"m"`
+		K uint   `json:"k"`
+		B string `json:"b"`
+	}
+	if err := json.Unmarshal(data, &j); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if j.M != f.Capacity() || j.K != f.K() {
+		t.Errorf("Expected %d, %d, got %d, %d", f.Capacity(), f.K(), j.M, j.K)
+	}
+
+	var buf bytes.Buffer
+	f.WriteBitsAndBloomsTo(&buf)
+	if bits, _ := base64.URLEncoding.DecodeString(j.B); !bytes.Equal(bits, buf.Bytes()[16:]) {
+		t.Error("Expected the bit set's binary representation")
+	}
+
+	restored := &BloomFilter{}
+	if err := restored.UnmarshalBitsAndBloomsJSON(data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !restored.Test([]byte(`a`)) || !restored.Test([]byte(`b`)) {
+		t.Error("Expected `a` and `b` to be members")
+	}
+}
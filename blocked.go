@@ -0,0 +1,374 @@
+package boom
+
+import (
+	"context"
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"math/bits"
+	"unsafe"
+)
+
+const (
+	blockBits  = 512            // bits per block, one 64-byte cache line
+	blockWords = blockBits / 64 // 64-bit words per block
+	blockBytes = blockWords * 8 // bytes per block
+	blockMask  = uint(blockBits - 1)
+)
+
+// BlockedBloomFilter implements a cache-blocked Bloom filter as described by
+// Putze, Sanders, and Singler in Cache-, Hash- and Space-Efficient Bloom
+// Filters:
+//
+// http://algo2.iti.kit.edu/documents/cacheefficientbloomfilters-jea.pdf
+//
+// The bit array is divided into 512-bit blocks aligned to 64-byte cache lines.
+// The hash of an item selects a block and all k bits of the item are set within
+// it, so adding or testing an item touches a single cache line instead of k
+// random ones. Since items aren't spread evenly across blocks, the
+// false-positive rate is slightly higher than that of a classic Bloom filter
+// with the same number of bits, increasingly so at very low target rates.
+//
+// Blocked Bloom filters are useful for very large filters which don't fit in
+// the CPU caches, where a classic Bloom filter spends most of its time waiting
+// on memory.
+type BlockedBloomFilter struct {
+	blocks []uint64    // filter data, blockWords words per block
+	hash   hash.Hash64 // hash function (kernel for all k functions)
+	m      uint        // filter size, a multiple of blockBits
+	k      uint        // number of hash functions
+	count  uint        // number of items added
+	seed   uint64      // hash seed (zero means unseeded)
+}
+
+// NewBlockedBloomFilter creates a new blocked Bloom filter optimized to store
+// n items with a specified target false-positive rate. The size of a classic
+// Bloom filter for the same parameters is rounded up to a whole number of
+// blocks.
+func NewBlockedBloomFilter(n uint, fpRate float64, opts ...Option) *BlockedBloomFilter {
+	var (
+		blocks = (OptimalM(n, fpRate) + blockBits - 1) / blockBits
+		o      = applyOptions(opts)
+	)
+	if blocks == 0 {
+		blocks = 1
+	}
+
+	return &BlockedBloomFilter{
+		blocks: newAlignedBlocks(blocks),
+		hash:   o.newHash64(newFNV64),
+		m:      blocks * blockBits,
+		k:      OptimalK(fpRate),
+		seed:   o.seed,
+	}
+}
+
+// Capacity returns the Bloom filter capacity, m.
+func (b *BlockedBloomFilter) Capacity() uint {
+	return b.m
+}
+
+// K returns the number of hash functions.
+func (b *BlockedBloomFilter) K() uint {
+	return b.k
+}
+
+// Count returns the number of items added to the filter.
+func (b *BlockedBloomFilter) Count() uint {
+	return b.count
+}
+
+// Blocks returns the number of 512-bit blocks.
+func (b *BlockedBloomFilter) Blocks() uint {
+	return uint(len(b.blocks) / blockWords)
+}
+
+// EstimatedFillRatio returns the current estimated ratio of set bits.
+func (b *BlockedBloomFilter) EstimatedFillRatio() float64 {
+	return 1 - math.Exp((-float64(b.count)*float64(b.k))/float64(b.m))
+}
+
+// FillRatio returns the ratio of set bits.
+func (b *BlockedBloomFilter) FillRatio() float64 {
+	set := 0
+	for _, word := range b.blocks {
+		set += bits.OnesCount64(word)
+	}
+	return float64(set) / float64(b.m)
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. This is a probabilistic test, meaning there is a
+// non-zero probability of false positives but a zero probability of false
+// negatives.
+func (b *BlockedBloomFilter) Test(data []byte) bool {
+	return b.test(b.hashKernel(data))
+}
+
+// Add will add the data to the Bloom filter. It returns the filter to allow
+// for chaining.
+func (b *BlockedBloomFilter) Add(data []byte) Filter {
+	b.add(b.hashKernel(data))
+	return b
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data is a member, false if not.
+func (b *BlockedBloomFilter) TestAndAdd(data []byte) bool {
+	var (
+		block, lower, upper = b.hashKernel(data)
+		words               = b.blocks[block : block+blockWords]
+		member              = true
+	)
+	for i := uint(0); i < b.k; i++ {
+		bit := (lower + upper*i) & blockMask
+		if words[bit/64]&(1<<(bit%64)) == 0 {
+			member = false
+			words[bit/64] |= 1 << (bit % 64)
+		}
+	}
+
+	b.count++
+	return member
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (b *BlockedBloomFilter) TestString(data string) bool {
+	return b.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (b *BlockedBloomFilter) AddString(data string) Filter {
+	return b.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (b *BlockedBloomFilter) TestAndAddString(data string) bool {
+	return b.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter. It returns the filter to allow
+// for chaining.
+func (b *BlockedBloomFilter) AddMany(data [][]byte) Filter {
+	for _, d := range data {
+		b.add(b.hashKernel(d))
+	}
+	return b
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+func (b *BlockedBloomFilter) TestMany(data [][]byte) []bool {
+	return testMany(data, b.Test)
+}
+
+// AddAllContext adds each item of data to the filter, checking the context for
+// cancellation periodically. It returns the number of items added, which are
+// the first ones in data, and the context's error if it was cancelled before
+// every item was added.
+func (b *BlockedBloomFilter) AddAllContext(ctx context.Context, data [][]byte) (int, error) {
+	return addAllContext(ctx, data, func(d []byte) error {
+		b.Add(d)
+		return nil
+	})
+}
+
+// Reset restores the Bloom filter to its original state. It returns the filter
+// to allow for chaining.
+func (b *BlockedBloomFilter) Reset() *BlockedBloomFilter {
+	for i := range b.blocks {
+		b.blocks[i] = 0
+	}
+	b.count = 0
+	return b
+}
+
+// Union merges the other blocked Bloom filter into this one, such that this
+// filter contains the union of the two sets. The filters must have the same
+// capacity and number of hash functions and use the same seed. Returns an
+// error if they are incompatible.
+func (b *BlockedBloomFilter) Union(other *BlockedBloomFilter) error {
+	if b.m != other.m {
+		return errors.New("filter capacity must match")
+	}
+
+	if b.k != other.k {
+		return errors.New("number of hash functions must match")
+	}
+
+	if b.seed != other.seed {
+		return errors.New("hash seed must match")
+	}
+
+	for i, word := range other.blocks {
+		b.blocks[i] |= word
+	}
+
+	b.count += other.count
+	return nil
+}
+
+// Equal returns true if the other blocked Bloom filter has the same
+// parameters, hash seed, number of items added, and bits. The hash functions
+// aren't compared.
+func (b *BlockedBloomFilter) Equal(other *BlockedBloomFilter) bool {
+	if b.m != other.m || b.k != other.k || b.count != other.count || b.seed != other.seed {
+		return false
+	}
+
+	for i, word := range b.blocks {
+		if word != other.blocks[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the blocked Bloom filter, which can be
+// used concurrently with the original.
+func (b *BlockedBloomFilter) Clone() *BlockedBloomFilter {
+	c := *b
+	c.blocks = newAlignedBlocks(b.Blocks())
+	copy(c.blocks, b.blocks)
+	c.hash = cloneHash64(b.hash)
+	return &c
+}
+
+// ByteSize returns the number of bytes used by the blocks and metadata of the
+// filter, excluding the hash function.
+func (b *BlockedBloomFilter) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*b)) + uint64(cap(b.blocks))*8
+}
+
+// SetHash sets the hashing function used in the filter.
+// For the effect on false positive rates see: https://github.com/tylertreat/BoomFilters/pull/1
+func (b *BlockedBloomFilter) SetHash(h hash.Hash64) {
+	b.hash = h
+}
+
+// WriteTo writes a binary representation of the BlockedBloomFilter to an I/O
+// stream. The hash function is not written, but the seed is. It returns the
+// number of bytes written.
+func (b *BlockedBloomFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(b.m))
+	e.write(uint64(b.k))
+	e.write(uint64(b.count))
+	e.write(b.seed)
+	e.write(b.blocks)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a BlockedBloomFilter (such as might
+// have been written by WriteTo()) from an I/O stream. The filter keeps its
+// current hash function, which must match the one used by the filter that was
+// written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (b *BlockedBloomFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                 = decoder{r: payload}
+		m, k, count, seed uint64
+	)
+	d.read(&m)
+	d.read(&k)
+	d.read(&count)
+	d.read(&seed)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if m == 0 || k == 0 || k > m || m%blockBits != 0 || m/8 > uint64(payload.Len()) {
+		return n, errors.New("blocks don't match filter size")
+	}
+
+	blocks := newAlignedBlocks(uint(m / blockBits))
+	d.read(blocks)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if b.hash == nil {
+		b.hash = newFNV64()
+	}
+	b.blocks = blocks
+	b.m = uint(m)
+	b.k = uint(k)
+	b.count = uint(count)
+	b.seed = seed
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (b *BlockedBloomFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(b)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (b *BlockedBloomFilter) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(b, data)
+}
+
+// hashKernel returns the offset of the first word of the data's block and the
+// base hash values from which the k bits within the block are derived. The
+// block is picked by the 64-bit hash and the bits by a remix of it, so that
+// items sharing a block don't necessarily share bits.
+func (b *BlockedBloomFilter) hashKernel(data []byte) (uint, uint, uint) {
+	lower, upper := seededHashKernel(data, b.hash, b.seed)
+	var (
+		sum   = uint64(upper)<<32 | uint64(lower)
+		block = uint(sum%uint64(len(b.blocks)/blockWords)) * blockWords
+		mixed = mix64(sum)
+	)
+
+	// An odd step visits k distinct bits of the block.
+	return block, uint(uint32(mixed)), uint(uint32(mixed>>32)) | 1
+}
+
+// test returns true if all of the K bits derived from the base hash values are
+// set in the block.
+func (b *BlockedBloomFilter) test(block, lower, upper uint) bool {
+	words := b.blocks[block : block+blockWords]
+	for i := uint(0); i < b.k; i++ {
+		bit := (lower + upper*i) & blockMask
+		if words[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// add sets the K bits derived from the base hash values in the block.
+func (b *BlockedBloomFilter) add(block, lower, upper uint) {
+	words := b.blocks[block : block+blockWords]
+	for i := uint(0); i < b.k; i++ {
+		bit := (lower + upper*i) & blockMask
+		words[bit/64] |= 1 << (bit % 64)
+	}
+	b.count++
+}
+
+// newAlignedBlocks returns the words of the number of blocks, with the first
+// block starting on a 64-byte boundary so that no block straddles two cache
+// lines.
+func newAlignedBlocks(blocks uint) []uint64 {
+	var (
+		n      = blocks * blockWords
+		words  = make([]uint64, n+blockWords-1)
+		offset = uint(blockBytes-uintptr(unsafe.Pointer(&words[0]))%blockBytes) % blockBytes / 8
+	)
+	return words[offset : offset+n : offset+n]
+}
@@ -0,0 +1,212 @@
+package boom
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+	"unsafe"
+)
+
+// Ensures that the blocked Bloom filter is sized to a whole number of aligned
+// blocks.
+func TestBlockedCapacity(t *testing.T) {
+	f := NewBlockedBloomFilter(100, 0.1)
+
+	if capacity := f.Capacity(); capacity != 512 {
+		t.Errorf("Expected 512, got %d", capacity)
+	}
+	if blocks := f.Blocks(); blocks != 1 {
+		t.Errorf("Expected 1, got %d", blocks)
+	}
+	if k := f.K(); k != 4 {
+		t.Errorf("Expected 4, got %d", k)
+	}
+
+	f = NewBlockedBloomFilter(10000, 0.01)
+	if capacity := f.Capacity(); capacity%blockBits != 0 || capacity < OptimalM(10000, 0.01) {
+		t.Errorf("Expected a multiple of %d of at least %d, got %d", blockBits, OptimalM(10000, 0.01), capacity)
+	}
+	if addr := uintptr(unsafe.Pointer(&f.blocks[0])); addr%blockBytes != 0 {
+		t.Errorf("Expected blocks aligned to %d bytes, got address %x", blockBytes, addr)
+	}
+}
+
+// Ensures that Test, Add, and TestAndAdd behave correctly and that every bit
+// of an item is set in the same block.
+func TestBlockedTestAndAdd(t *testing.T) {
+	f := NewBlockedBloomFilter(1000, 0.01)
+
+	if f.Test([]byte(`a`)) {
+		t.Error("`a` should not be a member")
+	}
+
+	if f.Add([]byte(`a`)) != f {
+		t.Error("Returned BlockedBloomFilter should be the same instance")
+	}
+
+	if !f.Test([]byte(`a`)) || !f.TestAndAdd([]byte(`a`)) {
+		t.Error("`a` should be a member")
+	}
+
+	if f.TestAndAdd([]byte(`b`)) {
+		t.Error("`b` should not be a member")
+	}
+
+	if !f.Test([]byte(`b`)) {
+		t.Error("`b` should be a member")
+	}
+
+	if count := f.Count(); count != 3 {
+		t.Errorf("Expected 3, got %d", count)
+	}
+
+	f.Reset()
+	block, _, _ := f.hashKernel([]byte(`c`))
+	f.Add([]byte(`c`))
+	for i, word := range f.blocks {
+		if inBlock := uint(i) >= block && uint(i) < block+blockWords; !inBlock && word != 0 {
+			t.Errorf("Expected only block %d to be set, got word %d", block/blockWords, i)
+		}
+	}
+	if ratio := f.FillRatio(); ratio == 0 || ratio > float64(f.K())/float64(f.Capacity()) {
+		t.Errorf("Expected at most %d bits set, got ratio %f", f.K(), ratio)
+	}
+}
+
+// Ensures that the false-positive rate of the blocked Bloom filter stays close
+// to the target rate and that it has no false negatives.
+func TestBlockedFalsePositiveRate(t *testing.T) {
+	f := NewBlockedBloomFilter(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	for i := 0; i < 10000; i++ {
+		if !f.Test([]byte(strconv.Itoa(i))) {
+			t.Fatalf("Expected %d to be a member", i)
+		}
+	}
+
+	fp := 0
+	for i := 10000; i < 110000; i++ {
+		if f.Test([]byte(strconv.Itoa(i))) {
+			fp++
+		}
+	}
+
+	if rate := float64(fp) / 100000; rate > 0.02 {
+		t.Errorf("Expected false-positive rate close to 0.01, got %f", rate)
+	}
+
+	if math.Abs(f.FillRatio()-f.EstimatedFillRatio()) > 0.01 {
+		t.Errorf("Expected fill ratio close to %f, got %f", f.EstimatedFillRatio(), f.FillRatio())
+	}
+}
+
+// Ensures that a BlockedBloomFilter can be written and read back, keeping its
+// members and seed.
+func TestBlockedReadWrite(t *testing.T) {
+	f := NewBlockedBloomFilter(1000, 0.01, WithSeed(42))
+	for i := 0; i < 100; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	other := NewBlockedBloomFilter(10, 0.1)
+	if _, err := other.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if other.Capacity() != f.Capacity() || other.K() != f.K() ||
+		other.Count() != f.Count() || other.seed != f.seed {
+		t.Error("Expected filter parameters to match")
+	}
+
+	for i := 0; i < 100; i++ {
+		if !other.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(data, buf.Bytes()) {
+		t.Error("Expected MarshalBinary to match WriteTo")
+	}
+
+	var empty BlockedBloomFilter
+	if err := empty.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !empty.Test([]byte(`1`)) {
+		t.Error("Expected `1` to be a member")
+	}
+
+	if err := empty.UnmarshalBinary(data[:len(data)-8]); err == nil {
+		t.Error("Expected error for truncated data")
+	}
+}
+
+// Ensures that Union merges compatible filters and returns an error for
+// incompatible ones.
+func TestBlockedUnion(t *testing.T) {
+	a := NewBlockedBloomFilter(100, 0.01)
+	b := NewBlockedBloomFilter(100, 0.01)
+	a.Add([]byte(`foo`))
+	b.Add([]byte(`bar`))
+
+	if err := a.Union(b); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !a.Test([]byte(`foo`)) || !a.Test([]byte(`bar`)) {
+		t.Error("Expected `foo` and `bar` to be members")
+	}
+
+	if count := a.Count(); count != 2 {
+		t.Errorf("Expected 2, got %d", count)
+	}
+
+	if err := a.Union(NewBlockedBloomFilter(10000, 0.01)); err == nil {
+		t.Error("Expected error for mismatched capacity")
+	}
+
+	if err := a.Union(NewBlockedBloomFilter(100, 0.01, WithSeed(42))); err == nil {
+		t.Error("Expected error for mismatched seed")
+	}
+}
+
+func BenchmarkBlockedAdd(b *testing.B) {
+	b.StopTimer()
+	f := NewBlockedBloomFilter(100000, 0.1)
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.Add(data[n])
+	}
+}
+
+func BenchmarkBlockedTest(b *testing.B) {
+	b.StopTimer()
+	f := NewBlockedBloomFilter(100000, 0.1)
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.Test(data[n])
+	}
+}
@@ -0,0 +1,333 @@
+package boom
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"sort"
+	"unsafe"
+)
+
+// BloomierFilter is a static, read-only approximate map from keys to small
+// values, a Bloomier filter as introduced by Chazelle, Kilian, Rubinfeld, and
+// Tal in The Bloomier Filter: An Efficient Data Structure for Static Support
+// Lookup Tables:
+//
+// https://www.cs.princeton.edu/~chazelle/pubs/soda-rev04.pdf
+//
+// It's built like an XorFilter, with a table of about 1.23 entries per key
+// split into three blocks, but each entry holds a fingerprint followed by a
+// value, and the xor of a key's three entries is its fingerprint followed by
+// its value. Get returns the value of a key in the map, and reports an
+// unknown key as absent unless its fingerprint happens to match, which has a
+// probability of 2^-FingerprintBits, in which case an arbitrary value is
+// returned. A map takes about 1.23 * (FingerprintBits + ValueBits) bits per
+// key, whatever the size of the keys, so attaching a 4-bit shard to each of
+// 500 million keys with a false-positive rate of 1/256 takes about 920 MB.
+//
+// The keys themselves aren't stored, so a BloomierFilter can't be enumerated
+// or modified once built.
+type BloomierFilter struct {
+	entries     []uint64    // packed table of fingerprints and values
+	blockLength uint32      // number of entries in each of the three blocks
+	valueBits   uint        // length of values in bits
+	fpBits      uint        // length of fingerprints in bits
+	n           uint64      // number of distinct keys
+	xorSeed     uint64      // seed mixed into key hashes by the construction
+	hash        hash.Hash64 // hash function of keys
+	seed        uint64      // hash seed (zero means unseeded)
+}
+
+// bloomierPair is a key's hash and value during construction.
+type bloomierPair struct {
+	hash  uint64
+	value uint16
+}
+
+// bloomierPairs sorts pairs by hash.
+type bloomierPairs []bloomierPair
+
+func (p bloomierPairs) Len() int           { return len(p) }
+func (p bloomierPairs) Less(i, j int) bool { return p[i].hash < p[j].hash }
+func (p bloomierPairs) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// NewBloomierFilter creates a new BloomierFilter mapping each key to the value
+// at the same index, values of valueBits bits, from 1 to 16, with a specified
+// target false-positive rate for unknown keys, which is rounded down to a power
+// of 1/2. Fingerprints and values take at most 32 bits together. A key may
+// appear more than once with the same value. Returns an error if the keys and
+// values differ in number, if a value doesn't fit, if a key has different
+// values or its hash collides with another key's, if there are too many keys,
+// or in the unlikely event that no table can be built for the keys' hashes.
+func NewBloomierFilter(keys [][]byte, values []uint16, valueBits uint, fpRate float64, opts ...Option) (*BloomierFilter, error) {
+	if len(keys) != len(values) {
+		return nil, errors.New("number of keys and values must match")
+	}
+	if valueBits == 0 || valueBits > 16 {
+		return nil, errors.New("value bits must be between 1 and 16")
+	}
+
+	o := applyOptions(opts)
+	b := &BloomierFilter{
+		valueBits: valueBits,
+		fpBits:    bloomierBits(fpRate, 32-valueBits),
+		hash:      o.newHash64(newFNV64),
+		seed:      o.seed,
+	}
+
+	pairs := make([]bloomierPair, len(keys))
+	for i, key := range keys {
+		if values[i]>>valueBits != 0 {
+			return nil, errors.New("value doesn't fit value bits")
+		}
+		pairs[i] = bloomierPair{hash: b.keyHash(key), value: values[i]}
+	}
+	sort.Sort(bloomierPairs(pairs))
+	var (
+		hashes      = make([]uint64, 0, len(pairs))
+		valuesByKey = make([]uint16, 0, len(pairs))
+	)
+	for i, pair := range pairs {
+		if i > 0 && pair.hash == pairs[i-1].hash {
+			if pair.value != pairs[i-1].value {
+				return nil, errors.New("conflicting values for key hash")
+			}
+			continue
+		}
+		hashes = append(hashes, pair.hash)
+		valuesByKey = append(valuesByKey, pair.value)
+	}
+
+	// Peeling identifies keys by a 32-bit index.
+	if uint64(len(hashes)) > math.MaxUint32 {
+		return nil, errors.New("too many keys")
+	}
+	capacity := 32 + math.Ceil(1.23*float64(len(hashes)))
+	b.blockLength = uint32(capacity / 3)
+	b.n = uint64(len(hashes))
+
+	xorSeed := mix64(o.seed)
+	for attempt := 0; attempt < xorMaxAttempts; attempt++ {
+		if stack := xorPeel(hashes, xorSeed, b.blockLength); stack != nil {
+			b.xorSeed = xorSeed
+			b.assign(stack, valuesByKey)
+			return b, nil
+		}
+		xorSeed = mix64(xorSeed + 0x9e3779b97f4a7c15)
+	}
+	return nil, errors.New("couldn't build filter")
+}
+
+// Count returns the number of distinct keys in the map.
+func (b *BloomierFilter) Count() uint {
+	return uint(b.n)
+}
+
+// ValueBits returns the length of values in bits.
+func (b *BloomierFilter) ValueBits() uint {
+	return b.valueBits
+}
+
+// FingerprintBits returns the length of fingerprints in bits.
+func (b *BloomierFilter) FingerprintBits() uint {
+	return b.fpBits
+}
+
+// EstimatedFPRate returns the probability that an unknown key's fingerprint
+// matches the xor of its three entries, 2^-FingerprintBits, in which case Get
+// reports it as present.
+func (b *BloomierFilter) EstimatedFPRate() float64 {
+	return math.Ldexp(1, -int(b.fpBits))
+}
+
+// ByteSize returns the number of bytes used by the table and the metadata of
+// the map, excluding the hash function.
+func (b *BloomierFilter) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*b)) + uint64(cap(b.entries))*8
+}
+
+// Get returns the value of the key and true if it's in the map, or zero and
+// false if not. An unknown key is reported as present, with an arbitrary
+// value, with a probability of EstimatedFPRate.
+func (b *BloomierFilter) Get(key []byte) (uint16, bool) {
+	h := mix64(b.keyHash(key) + b.xorSeed)
+	h0, h1, h2 := xorPositions(h, b.blockLength)
+	e := b.entry(h0) ^ b.entry(h1) ^ b.entry(h2)
+	if e&(1<<b.fpBits-1) != b.fingerprint(h) {
+		return 0, false
+	}
+	return uint16(e >> b.fpBits), true
+}
+
+// GetString is equivalent to Get for a string, which is hashed without being
+// copied.
+func (b *BloomierFilter) GetString(key string) (uint16, bool) {
+	return b.Get(stringBytes(key))
+}
+
+// Test returns true if the key is in the map, false if not. This is a
+// probabilistic test, meaning there is a non-zero probability of false
+// positives but a zero probability of false negatives.
+func (b *BloomierFilter) Test(key []byte) bool {
+	_, ok := b.Get(key)
+	return ok
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (b *BloomierFilter) TestString(key string) bool {
+	return b.Test(stringBytes(key))
+}
+
+// SetHash sets the hashing function used to hash keys, which must be the one
+// the map was created with.
+func (b *BloomierFilter) SetHash(h hash.Hash64) {
+	b.hash = h
+}
+
+// assign sets the entries in the reverse of the order the keys were peeled, so
+// each key's entry is set after the other entries it hashes to and can make
+// their xor equal its fingerprint and value.
+func (b *BloomierFilter) assign(stack []xorStackEntry, values []uint16) {
+	b.entries = make([]uint64, (3*uint64(b.blockLength)*uint64(b.entryBits())+63)/64)
+	for i := len(stack) - 1; i >= 0; i-- {
+		var (
+			h          = stack[i].hash
+			h0, h1, h2 = xorPositions(h, b.blockLength)
+			target     = uint32(values[stack[i].key])<<b.fpBits | b.fingerprint(h)
+		)
+		// The key's own entry is still zero, so it drops out of the xor.
+		b.setEntry(stack[i].index, target^b.entry(h0)^b.entry(h1)^b.entry(h2))
+	}
+}
+
+// keyHash returns the 64-bit hash of the key.
+func (b *BloomierFilter) keyHash(key []byte) uint64 {
+	lower, upper := seededHashKernel(key, b.hash, b.seed)
+	return uint64(upper)<<32 | uint64(lower)
+}
+
+// fingerprint returns the fingerprint of the mixed hash.
+func (b *BloomierFilter) fingerprint(h uint64) uint32 {
+	return uint32(h^h>>32) & (1<<b.fpBits - 1)
+}
+
+// entryBits returns the length of an entry, a fingerprint followed by a value.
+func (b *BloomierFilter) entryBits() uint {
+	return b.fpBits + b.valueBits
+}
+
+// entry returns the i-th entry of the table.
+func (b *BloomierFilter) entry(i uint32) uint32 {
+	return getWordBits(b.entries, uint(i)*b.entryBits(), b.entryBits())
+}
+
+// setEntry sets the i-th entry of the table.
+func (b *BloomierFilter) setEntry(i uint32, e uint32) {
+	setWordBits(b.entries, uint(i)*b.entryBits(), b.entryBits(), e)
+}
+
+// bloomierBits returns the number of fingerprint bits for the target
+// false-positive rate, at most max.
+func bloomierBits(fpRate float64, max uint) uint {
+	r := math.Ceil(math.Log2(1 / fpRate))
+	if r < 0 || math.IsNaN(r) {
+		return 0
+	}
+	if r > float64(max) {
+		return max
+	}
+	return uint(r)
+}
+
+// WriteTo writes a binary representation of the BloomierFilter to an I/O
+// stream. The hash function is not written, but the seed is. It returns the
+// number of bytes written.
+func (b *BloomierFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(b.valueBits))
+	e.write(uint64(b.fpBits))
+	e.write(b.n)
+	e.write(b.blockLength)
+	e.write(b.xorSeed)
+	e.write(b.seed)
+	e.write(b.entries)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a BloomierFilter (such as might
+// have been written by WriteTo()) from an I/O stream. The map keeps its
+// current hash function, which must match the one used by the map that was
+// written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (b *BloomierFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                        = decoder{r: payload}
+		valueBits, fpBits, count uint64
+		blockLength              uint32
+		xorSeed, seed            uint64
+		decoded                  BloomierFilter
+		h                        = b.hash
+	)
+	d.read(&valueBits)
+	d.read(&fpBits)
+	d.read(&count)
+	d.read(&blockLength)
+	d.read(&xorSeed)
+	d.read(&seed)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if valueBits == 0 || valueBits > 16 || valueBits+fpBits > 32 || blockLength == 0 {
+		return n, errors.New("invalid filter parameters")
+	}
+	decoded.valueBits = uint(valueBits)
+	decoded.fpBits = uint(fpBits)
+	words := (3*uint64(blockLength)*uint64(decoded.entryBits()) + 63) / 64
+	if words > uint64(payload.Len())/8 {
+		return n, io.ErrUnexpectedEOF
+	}
+	entries := make([]uint64, words)
+	d.read(entries)
+	if d.err != nil {
+		return n, d.err
+	}
+	if h == nil {
+		h = newFNV64()
+	}
+
+	b.entries = entries
+	b.blockLength = blockLength
+	b.valueBits = uint(valueBits)
+	b.fpBits = uint(fpBits)
+	b.n = count
+	b.xorSeed = xorSeed
+	b.hash = h
+	b.seed = seed
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (b *BloomierFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(b)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo. If the map
+// has no hash function, such as when it's the zero value, the default hash
+// function is used.
+func (b *BloomierFilter) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(b, data)
+}
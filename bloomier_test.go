@@ -0,0 +1,149 @@
+package boom
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+// Ensures that a BloomierFilter returns the value of every key, has roughly
+// the expected false-positive rate, and takes the expected space.
+func TestBloomierFilter(t *testing.T) {
+	keys := make([][]byte, 10000)
+	values := make([]uint16, len(keys))
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+		values[i] = uint16(i % 16)
+	}
+
+	b, err := NewBloomierFilter(keys, values, 4, 1.0/256)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if b.Count() != 10000 || b.ValueBits() != 4 || b.FingerprintBits() != 8 {
+		t.Errorf("Expected 10000 keys with 4-bit values and 8-bit fingerprints, got %d, %d, and %d",
+			b.Count(), b.ValueBits(), b.FingerprintBits())
+	}
+
+	for i, key := range keys {
+		if value, ok := b.Get(key); !ok || value != values[i] {
+			t.Fatalf("Expected %d for %s, got %d, %t", values[i], key, value, ok)
+		}
+	}
+
+	fp := 0
+	for i := 10000; i < 110000; i++ {
+		if _, ok := b.GetString(strconv.Itoa(i)); ok {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 100000; rate > 2*b.EstimatedFPRate() {
+		t.Errorf("Expected false-positive rate near %f, got %f", b.EstimatedFPRate(), rate)
+	}
+
+	// 1.23 entries of 12 bits per key.
+	if bits := float64(len(b.entries)*64) / 10000; bits > 1.25*12 {
+		t.Errorf("Expected about %f bits per key, got %f", 1.23*12, bits)
+	}
+}
+
+// Ensures that NewBloomierFilter accepts repeated keys with the same value, an
+// empty map, and no false-positive protection, and rejects invalid input.
+func TestNewBloomierFilter(t *testing.T) {
+	keys := [][]byte{[]byte(`a`), []byte(`b`), []byte(`a`)}
+	b, err := NewBloomierFilter(keys, []uint16{1, 2, 1}, 2, 0.01, WithSeed(7))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if value, ok := b.GetString(`b`); b.Count() != 2 || !ok || value != 2 {
+		t.Errorf("Expected 2 keys with b mapped to 2, got %d keys and %d, %t", b.Count(), value, ok)
+	}
+
+	empty, err := NewBloomierFilter(nil, nil, 8, 0.01)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if empty.Count() != 0 || empty.Test([]byte(`a`)) {
+		t.Error("Expected an empty map")
+	}
+
+	retrieval, err := NewBloomierFilter(keys[:2], []uint16{300, 400}, 16, 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if value, _ := retrieval.GetString(`a`); retrieval.FingerprintBits() != 0 || value != 300 {
+		t.Errorf("Expected no fingerprint and 300 for a, got %d bits and %d", retrieval.FingerprintBits(), value)
+	}
+	if !retrieval.TestString(`x`) {
+		t.Error("Expected every key to be present without fingerprints")
+	}
+
+	for _, c := range []struct {
+		values    []uint16
+		valueBits uint
+	}{
+		{[]uint16{1, 2}, 2},
+		{[]uint16{1, 2, 3}, 2},
+		{[]uint16{1, 4, 1}, 2},
+		{[]uint16{1, 2, 1}, 0},
+		{[]uint16{1, 2, 1}, 17},
+	} {
+		if _, err := NewBloomierFilter(keys, c.values, c.valueBits, 0.01); err == nil {
+			t.Errorf("Expected error for values %v of %d bits", c.values, c.valueBits)
+		}
+	}
+}
+
+// Ensures that a BloomierFilter read by ReadFrom returns the same values as
+// the one written by WriteTo.
+func TestBloomierFilterReadWrite(t *testing.T) {
+	keys := make([][]byte, 1000)
+	values := make([]uint16, len(keys))
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+		values[i] = uint16(i)
+	}
+	b, err := NewBloomierFilter(keys, values, 10, 0.001, WithSeed(3))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data := append([]byte(nil), buf.Bytes()...)
+
+	restored := &BloomierFilter{}
+	if _, err := restored.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 0; i < 5000; i++ {
+		key := []byte(strconv.Itoa(i))
+		value, ok := b.Get(key)
+		if restoredValue, restoredOK := restored.Get(key); restoredValue != value || restoredOK != ok {
+			t.Errorf("Expected restored value of %d to match original", i)
+		}
+	}
+
+	if err := restored.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Error("Expected error for truncated data")
+	}
+}
+
+func BenchmarkBloomierFilterGet(b *testing.B) {
+	keys := make([][]byte, 100000)
+	values := make([]uint16, len(keys))
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+		values[i] = uint16(i)
+	}
+	f, err := NewBloomierFilter(keys, values, 16, 0.01)
+	if err != nil {
+		b.Fatalf("Unexpected error: %v", err)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		f.Get(keys[n%len(keys)])
+	}
+}
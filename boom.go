@@ -37,13 +37,25 @@ into a bag of words.
 package boom
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding"
 	"encoding/binary"
 	"hash"
+	"io"
 	"math"
+	"math/bits"
+	"reflect"
+	"unsafe"
 )
 
 const fillRatio = 0.5
 
+// contextCheckInterval is the number of items AddAllContext adds between
+// checks for cancellation.
+const contextCheckInterval = 1024
+
 // Filter is a probabilistic data structure which is used to test the
 // membership of an element in a set.
 type Filter interface {
@@ -60,6 +72,12 @@ type Filter interface {
 	TestAndAdd([]byte) bool
 }
 
+// Iterator calls fn with every key of a set, such as the rows of the table a
+// filter was built from, so the filter can be rebuilt from them. It stops at
+// and returns the first error fn returns, or returns its own error if reading
+// the keys fails. A key may be reused by the iterator once fn returns.
+type Iterator func(fn func(key []byte) error) error
+
 // OptimalM calculates the optimal Bloom filter size, m, based on the number of
 // items and the desired rate of false positives.
 func OptimalM(n uint, fpRate float64) uint {
@@ -74,10 +92,328 @@ func OptimalK(fpRate float64) uint {
 }
 
 // hashKernel returns the upper and lower base hash values from which the k
-// hashes are derived.
+// hashes are derived. The default hash function is computed without its state
+// and others through Sum64, so hashing doesn't allocate.
 func hashKernel(data []byte, hash hash.Hash64) (uint32, uint32) {
+	if _, ok := hash.(*fnv64); ok {
+		return fnvHashKernel(data, 0)
+	}
+
+	hash.Write(data)
+	sum := hash.Sum64()
+	hash.Reset()
+	return uint32(sum), uint32(sum >> 32)
+}
+
+// seededHashKernel is like hashKernel but the base hash values depend on the
+// seed. The seed is written to the hash ahead of the data and then mixed into
+// the resulting sum so that keys which collide under one seed don't
+// necessarily collide under another. A zero seed leaves the hash values
+// unchanged from hashKernel.
+func seededHashKernel(data []byte, hash hash.Hash64, seed uint64) (uint32, uint32) {
+	if seed == 0 {
+		return hashKernel(data, hash)
+	}
+	if _, ok := hash.(*fnv64); ok {
+		return fnvHashKernel(data, seed)
+	}
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], seed)
+	hash.Write(buf[:])
 	hash.Write(data)
-	sum := hash.Sum(nil)
+	sum := mix64(hash.Sum64() ^ seed)
 	hash.Reset()
-	return binary.BigEndian.Uint32(sum[4:8]), binary.BigEndian.Uint32(sum[0:4])
+	return uint32(sum), uint32(sum >> 32)
+}
+
+// sizedHashKernel is like seededHashKernel for a structure of m bits or
+// cells. Deriving indices from the two 32-bit halves of a 64-bit hash can only
+// address 2^32 distinct base values and correlates the indices of very large
+// structures, so once m exceeds 2^32 the base hash values are instead the two
+// 64-bit halves of a 128-bit MurmurHash3 of the data, and the hash function is
+// unused.
+func sizedHashKernel(data []byte, hash hash.Hash64, seed uint64, m uint) (uint64, uint64) {
+	if uint64(m) > 1<<32 {
+		return murmur3Sum128(data, seed)
+	}
+	lower, upper := seededHashKernel(data, hash, seed)
+	return uint64(lower), uint64(upper)
+}
+
+// sizedIndex returns the i-th index in [0, m) derived from the base hash
+// values returned by sizedHashKernel.
+func sizedIndex(lower, upper uint64, i, m uint) uint {
+	if uint64(m) > 1<<32 {
+		if m&(m-1) == 0 {
+			return uint(lower+upper*uint64(i)) & (m - 1)
+		}
+		return uint((lower + upper*uint64(i)) % uint64(m))
+	}
+	if m&(m-1) == 0 {
+		// A power of two reduces with a bitmask rather than a division,
+		// giving the same index.
+		return (uint(lower) + uint(upper)*i) & (m - 1)
+	}
+	return (uint(lower) + uint(upper)*i) % m
+}
+
+// reducedIndex is like sizedIndex, but unless modulo is set it reduces the
+// hash with fastRange rather than a modulo. Structures written by earlier
+// versions of the package reduce with a modulo.
+func reducedIndex(lower, upper uint64, i, m uint, modulo bool) uint {
+	if modulo {
+		return sizedIndex(lower, upper, i, m)
+	}
+	if uint64(m) > 1<<32 {
+		return fastRange(lower+upper*uint64(i), m)
+	}
+	return fastRange32(uint32(lower)+uint32(upper)*uint32(i), m)
+}
+
+// fastRange reduces the 64-bit hash to [0, m) with Lemire's multiply-shift,
+// taking the high 64 bits of the product of the hash and m, which is as
+// uniform as the modulo without its division.
+func fastRange(hash uint64, m uint) uint {
+	hi, _ := bits.Mul64(hash, uint64(m))
+	return uint(hi)
+}
+
+// fastRange32 is like fastRange for a 32-bit hash, which is first mixed with
+// the finalizer of MurmurHash3. The reduction only uses the high bits of the
+// hash, whereas the last byte of the data only reaches the low bits of an FNV
+// hash, so without mixing keys differing in their last byte would share their
+// indices. Mixing each index also decorrelates the indices of such keys,
+// which a modulo only shifts by the same offset.
+func fastRange32(hash uint32, m uint) uint {
+	hash ^= hash >> 16
+	hash *= 0x85ebca6b
+	hash ^= hash >> 13
+	hash *= 0xc2b2ae35
+	hash ^= hash >> 16
+	return fastRange(uint64(hash)<<32, m)
+}
+
+// writeReduction writes how a structure reduces its hashes to indices
+// following its other fields.
+func writeReduction(e *encoder, modulo bool) {
+	var fast uint8
+	if !modulo {
+		fast = 1
+	}
+	e.write(fast)
+}
+
+// readReduction reads how a structure reduces its hashes to indices following
+// its other fields, returning true for a modulo, such as for structures
+// written before the reduction was, or the decoder's error if reading failed.
+func readReduction(d *decoder, payload *bytes.Reader) (bool, error) {
+	var fast uint8
+	if payload.Len() > 0 {
+		// Written by format version 2.6 or later (2.8 for some filters).
+		d.read(&fast)
+	}
+	return fast == 0, d.err
+}
+
+// Sizes of the slice headers, pointers, and integers held by the structures,
+// used to compute their ByteSize.
+const (
+	sliceSize   = uint64(unsafe.Sizeof([]byte(nil)))
+	pointerSize = uint64(unsafe.Sizeof(uintptr(0)))
+	uintSize    = uint64(unsafe.Sizeof(uint(0)))
+)
+
+// stringBytes returns the bytes of the string without copying them, so hashing
+// a string doesn't allocate. The bytes are immutable and must never be
+// modified, though they may be kept as long as they're only read.
+func stringBytes(s string) []byte {
+	// A string header followed by the capacity has the layout of a slice.
+	return *(*[]byte)(unsafe.Pointer(&struct {
+		string
+		int
+	}{s, len(s)}))
+}
+
+// mix64 is the 64-bit finalizer from MurmurHash3 which causes every bit of the
+// input to affect every bit of the output.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// streamHint returns the number of items to size a filter built from the
+// delimited keys of the stream for. If the stream is an io.Seeker, such as an
+// *os.File, the keys are counted in a first pass, after which the stream is
+// rewound. Otherwise it's the hint set with WithHint, or 10000.
+func streamHint(stream io.Reader, delim byte, opts []Option) (uint, error) {
+	seeker, ok := stream.(io.Seeker)
+	if !ok {
+		n, _, err := applyOptions(opts).sizing()
+		return n, err
+	}
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	n := uint(0)
+	if err := readKeys(stream, delim, func([]byte) error {
+		n++
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		n = 1
+	}
+	return n, nil
+}
+
+// readKeys calls fn with each key of the stream, delimited by delim, until
+// the end of the stream or until fn returns an error. Empty keys are skipped.
+// The key is only valid until fn returns. It returns the error of fn or of
+// reading the stream, if any.
+func readKeys(stream io.Reader, delim byte, fn func([]byte) error) error {
+	var (
+		r   = bufio.NewReaderSize(stream, 64*1024)
+		buf []byte
+	)
+	for {
+		key, err := r.ReadSlice(delim)
+		if err == bufio.ErrBufferFull {
+			// The key is longer than the buffer, so accumulate it.
+			buf = append(buf[:0], key...)
+			for err == bufio.ErrBufferFull {
+				key, err = r.ReadSlice(delim)
+				buf = append(buf, key...)
+			}
+			key = buf
+		}
+
+		if len(key) > 0 && key[len(key)-1] == delim && err == nil {
+			key = key[:len(key)-1]
+		}
+		if len(key) > 0 {
+			if err := fn(key); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// addAllContext adds each item of data using the add function, checking the
+// context for cancellation every contextCheckInterval items. It returns the
+// number of items added and the context's error if it was cancelled, or the
+// error returned by add, if any.
+func addAllContext(ctx context.Context, data [][]byte, add func([]byte) error) (int, error) {
+	for i, d := range data {
+		if i%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return i, err
+			}
+		}
+		if err := add(d); err != nil {
+			return i, err
+		}
+	}
+	return len(data), nil
+}
+
+// BatchFilter is a Filter with batch operations, which amortize the overhead
+// of each call, such as locking, across many items.
+type BatchFilter interface {
+	Filter
+
+	// AddMany adds each item of data to the filter. It returns the filter to
+	// allow for chaining.
+	AddMany([][]byte) Filter
+
+	// TestMany returns whether each item of data is a member, in the same
+	// order.
+	TestMany([][]byte) []bool
+}
+
+// batchSize is the number of items whose base hash values batch operations
+// compute ahead of probing the filter, so the memory accesses of consecutive
+// items overlap rather than being interleaved with hashing.
+const batchSize = 64
+
+// testMany returns whether each item of data is a member using the test
+// function.
+func testMany(data [][]byte, test func([]byte) bool) []bool {
+	members := make([]bool, len(data))
+	for i, d := range data {
+		members[i] = test(d)
+	}
+	return members
+}
+
+// cloneHash64 returns a copy of the hash function for a cloned structure, so
+// the clone can be used concurrently with the original. Hash functions which
+// can't be copied, because they don't implement encoding.BinaryMarshaler and
+// encoding.BinaryUnmarshaler like those of the standard library, are shared.
+func cloneHash64(h hash.Hash64) hash.Hash64 {
+	switch h.(type) {
+	case nil:
+		return nil
+	case *fnv64:
+		return newFNV64()
+	}
+	if c, ok := cloneHash(h).(hash.Hash64); ok {
+		return c
+	}
+	return h
+}
+
+// cloneHash32 is like cloneHash64 for 32-bit hash functions.
+func cloneHash32(h hash.Hash32) hash.Hash32 {
+	switch h.(type) {
+	case nil:
+		return nil
+	case *fnv32:
+		return newFNV32()
+	}
+	if c, ok := cloneHash(h).(hash.Hash32); ok {
+		return c
+	}
+	return h
+}
+
+// cloneHash returns a new hash function of the same type as the one passed
+// with its state restored from the one passed, or nil if it can't be copied.
+func cloneHash(h hash.Hash) hash.Hash {
+	m, ok := h.(encoding.BinaryMarshaler)
+	if t := reflect.TypeOf(h); !ok || t.Kind() != reflect.Ptr {
+		return nil
+	}
+	state, err := m.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+
+	c, ok := reflect.New(reflect.TypeOf(h).Elem()).Interface().(hash.Hash)
+	if !ok {
+		return nil
+	}
+	if u, ok := c.(encoding.BinaryUnmarshaler); !ok || u.UnmarshalBinary(state) != nil {
+		return nil
+	}
+	return c
 }
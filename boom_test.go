@@ -0,0 +1,447 @@
+package boom
+
+import (
+	"hash/fnv"
+	"strconv"
+	"testing"
+)
+
+// stringFilter is a filter with the string APIs.
+type stringFilter interface {
+	Filter
+	TestString(string) bool
+	AddString(string) Filter
+	TestAndAddString(string) bool
+}
+
+// Ensures that the string APIs of every filter are equivalent to their []byte
+// counterparts.
+func TestStringAPIs(t *testing.T) {
+	filters := map[string]stringFilter{
+		"BloomFilter":                NewBloomFilter(100, 0.01),
+		"BlockedBloomFilter":         NewBlockedBloomFilter(100, 0.01),
+		"PartitionedBloomFilter":     NewPartitionedBloomFilter(100, 0.01),
+		"ScalableBloomFilter":        NewDefaultScalableBloomFilter(0.01),
+		"CountingBloomFilter":        NewDefaultCountingBloomFilter(100, 0.01),
+		"StableBloomFilter":          NewUnstableBloomFilter(1000, 0.01),
+		"InverseBloomFilter":         NewInverseBloomFilter(100),
+		"AtomicBloomFilter":          NewAtomicBloomFilter(100, 0.01),
+		"SafeFilter":                 NewSafeFilter(NewBloomFilter(100, 0.01)),
+		"ShardedScalableBloomFilter": NewShardedScalableBloomFilter(4, 100, 0.01, 0.8),
+	}
+
+	for name, f := range filters {
+		if f.TestAndAddString(`a`) {
+			t.Errorf("%s: `a` should not be a member", name)
+		}
+		if !f.Test([]byte(`a`)) || !f.TestString(`a`) {
+			t.Errorf("%s: `a` should be a member", name)
+		}
+
+		f.AddString(`b`)
+		if !f.Test([]byte(`b`)) || !f.TestAndAddString(`b`) {
+			t.Errorf("%s: `b` should be a member", name)
+		}
+
+		f.Add([]byte(`c`))
+		if !f.TestString(`c`) {
+			t.Errorf("%s: `c` should be a member", name)
+		}
+	}
+
+	c := NewCuckooFilter(100, 0.01)
+	if member, err := c.TestAndAddString(`a`); member || err != nil {
+		t.Errorf("Expected `a` to be added, got %t, %v", member, err)
+	}
+	if err := c.AddString(`b`); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !c.TestString(`a`) || !c.Test([]byte(`b`)) {
+		t.Error("Expected `a` and `b` to be members")
+	}
+
+	a := NewAdaptiveCuckooFilter(100, 0.01)
+	if member, err := a.TestAndAddString(`a`); member || err != nil {
+		t.Errorf("Expected `a` to be added, got %t, %v", member, err)
+	}
+	if err := a.AddString(`b`); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !a.TestString(`a`) || !a.Test([]byte(`b`)) {
+		t.Error("Expected `a` and `b` to be members")
+	}
+}
+
+// Ensures that the string APIs don't allocate.
+func TestStringAPIsAllocs(t *testing.T) {
+	var (
+		f    = NewBloomFilter(1000, 0.01)
+		keys = make([]string, 100)
+	)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+
+	n := 0
+	allocs := testing.AllocsPerRun(100, func() {
+		key := keys[n%len(keys)]
+		f.AddString(key)
+		f.TestString(key)
+		f.TestAndAddString(key)
+		n++
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations, got %v", allocs)
+	}
+
+	if len(stringBytes("")) != 0 {
+		t.Error("Expected no bytes for the empty string")
+	}
+}
+
+// Ensures that the batch operations of every filter are equivalent to adding
+// and testing each item.
+func TestBatchAPIs(t *testing.T) {
+	filters := map[string]BatchFilter{
+		"BloomFilter":                NewBloomFilter(1000, 0.01),
+		"BloomFilterBitsAndBlooms":   NewBitsAndBloomsFilter(1000, 0.01),
+		"BlockedBloomFilter":         NewBlockedBloomFilter(1000, 0.01),
+		"PartitionedBloomFilter":     NewPartitionedBloomFilter(1000, 0.01),
+		"ScalableBloomFilter":        NewScalableBloomFilter(10, 0.01, 0.8),
+		"CountingBloomFilter":        NewDefaultCountingBloomFilter(1000, 0.01),
+		"StableBloomFilter":          NewUnstableBloomFilter(10000, 0.01),
+		"InverseBloomFilter":         NewInverseBloomFilter(1000),
+		"AtomicBloomFilter":          NewAtomicBloomFilter(1000, 0.01),
+		"SafeFilter":                 NewSafeFilter(NewBloomFilter(1000, 0.01)),
+		"ShardedScalableBloomFilter": NewShardedScalableBloomFilter(4, 1000, 0.01, 0.8),
+	}
+
+	var added, tested [][]byte
+	for i := 0; i < 200; i++ {
+		added = append(added, []byte(strconv.Itoa(i)))
+		tested = append(tested, []byte(strconv.Itoa(i*2)))
+	}
+
+	for name, f := range filters {
+		if f.AddMany(added) != f {
+			t.Errorf("%s: Expected AddMany to return the filter", name)
+		}
+
+		members := f.TestMany(tested)
+		if len(members) != len(tested) {
+			t.Fatalf("%s: Expected %d results, got %d", name, len(tested), len(members))
+		}
+		for i, data := range tested {
+			if members[i] != f.Test(data) {
+				t.Errorf("%s: Expected %t for %s, got %t", name, f.Test(data), data, members[i])
+			}
+			// The Inverse Bloom Filter may evict the added items.
+			if i < 100 && !members[i] && name != "InverseBloomFilter" {
+				t.Errorf("%s: %s should be a member", name, data)
+			}
+		}
+	}
+
+	c := NewCuckooFilter(1000, 0.01)
+	if n, err := c.AddMany(added); n != len(added) || err != nil {
+		t.Errorf("Expected %d items added, got %d, %v", len(added), n, err)
+	}
+	for i, member := range c.TestMany(tested) {
+		if member != c.Test(tested[i]) {
+			t.Errorf("Expected %t for %s, got %t", c.Test(tested[i]), tested[i], member)
+		}
+	}
+
+	if members := NewBloomFilter(100, 0.01).TestMany(nil); len(members) != 0 {
+		t.Errorf("Expected no results, got %d", len(members))
+	}
+}
+
+// Ensures that Equal reports whether two filters of every type converged to the
+// same state.
+func TestEqual(t *testing.T) {
+	type filter struct {
+		add   func([]byte)
+		equal func(interface{}) bool
+		value interface{}
+	}
+	filters := map[string]func(seed uint64) filter{
+		"BloomFilter": func(seed uint64) filter {
+			f := NewBloomFilter(100, 0.01, WithSeed(seed))
+			return filter{func(d []byte) { f.Add(d) }, func(o interface{}) bool { return f.Equal(o.(*BloomFilter)) }, f}
+		},
+		"PartitionedBloomFilter": func(seed uint64) filter {
+			f := NewPartitionedBloomFilter(100, 0.01, WithSeed(seed))
+			return filter{func(d []byte) { f.Add(d) }, func(o interface{}) bool { return f.Equal(o.(*PartitionedBloomFilter)) }, f}
+		},
+		"BlockedBloomFilter": func(seed uint64) filter {
+			f := NewBlockedBloomFilter(100, 0.01, WithSeed(seed))
+			return filter{func(d []byte) { f.Add(d) }, func(o interface{}) bool { return f.Equal(o.(*BlockedBloomFilter)) }, f}
+		},
+		"ScalableBloomFilter": func(seed uint64) filter {
+			f := NewScalableBloomFilter(10, 0.01, 0.8, WithSeed(seed))
+			return filter{func(d []byte) { f.Add(d) }, func(o interface{}) bool { return f.Equal(o.(*ScalableBloomFilter)) }, f}
+		},
+		"CountingBloomFilter": func(seed uint64) filter {
+			f := NewDefaultCountingBloomFilter(100, 0.01, WithSeed(seed))
+			return filter{func(d []byte) { f.Add(d) }, func(o interface{}) bool { return f.Equal(o.(*CountingBloomFilter)) }, f}
+		},
+		"StableBloomFilter": func(seed uint64) filter {
+			f := NewDefaultStableBloomFilter(1000, 0.01, WithSeed(seed))
+			return filter{func(d []byte) { f.Add(d) }, func(o interface{}) bool { return f.Equal(o.(*StableBloomFilter)) }, f}
+		},
+		"InverseBloomFilter": func(seed uint64) filter {
+			f := NewInverseBloomFilter(100, WithSeed(seed))
+			return filter{func(d []byte) { f.Add(d) }, func(o interface{}) bool { return f.Equal(o.(*InverseBloomFilter)) }, f}
+		},
+		"CuckooFilter": func(seed uint64) filter {
+			f := NewCuckooFilter(100, 0.01, WithSeed(seed))
+			return filter{func(d []byte) { f.Add(d) }, func(o interface{}) bool { return f.Equal(o.(*CuckooFilter)) }, f}
+		},
+		"AdaptiveCuckooFilter": func(seed uint64) filter {
+			f := NewAdaptiveCuckooFilter(100, 0.01, WithSeed(seed))
+			return filter{func(d []byte) { f.Add(d) }, func(o interface{}) bool { return f.Equal(o.(*AdaptiveCuckooFilter)) }, f}
+		},
+		"AtomicBloomFilter": func(seed uint64) filter {
+			f := NewAtomicBloomFilter(100, 0.01, WithSeed(seed))
+			return filter{func(d []byte) { f.Add(d) }, func(o interface{}) bool { return f.Equal(o.(*AtomicBloomFilter)) }, f}
+		},
+		"ShardedScalableBloomFilter": func(seed uint64) filter {
+			f := NewShardedScalableBloomFilter(4, 100, 0.01, 0.8, WithSeed(seed))
+			return filter{func(d []byte) { f.Add(d) }, func(o interface{}) bool { return f.Equal(o.(*ShardedScalableBloomFilter)) }, f}
+		},
+	}
+
+	for name, newFilter := range filters {
+		a, b := newFilter(1), newFilter(1)
+		for i := 0; i < 50; i++ {
+			a.add([]byte(strconv.Itoa(i)))
+			b.add([]byte(strconv.Itoa(i)))
+		}
+		if !a.equal(b.value) || !b.equal(a.value) {
+			t.Errorf("%s: Expected filters to be equal", name)
+		}
+
+		b.add([]byte(`foo`))
+		if a.equal(b.value) {
+			t.Errorf("%s: Expected filters with different items not to be equal", name)
+		}
+
+		if a.equal(newFilter(2).value) {
+			t.Errorf("%s: Expected filters with different seeds not to be equal", name)
+		}
+	}
+
+	var (
+		cms   = NewCountMinSketch(0.001, 0.99)
+		other = NewCountMinSketch(0.001, 0.99)
+	)
+	cms.Add([]byte(`a`))
+	other.Add([]byte(`a`))
+	if !cms.Equal(other) {
+		t.Error("Expected sketches to be equal")
+	}
+	other.Add([]byte(`b`))
+	if cms.Equal(other) {
+		t.Error("Expected sketches not to be equal")
+	}
+
+	h, err := NewHyperLogLog(16)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	h2, _ := NewHyperLogLog(16)
+	h.Add([]byte(`a`))
+	h2.Add([]byte(`a`))
+	if !h.Equal(h2) {
+		t.Error("Expected HyperLogLogs to be equal")
+	}
+	for i := 0; i < 100; i++ {
+		h2.Add([]byte(strconv.Itoa(i)))
+	}
+	if h.Equal(h2) {
+		t.Error("Expected HyperLogLogs not to be equal")
+	}
+}
+
+// Ensures that Clone returns an independent copy of every structure.
+func TestClone(t *testing.T) {
+	type structure struct {
+		add    func([]byte)
+		clone  func() interface{}
+		equal  func(interface{}) bool
+		member func(interface{}, []byte) bool
+	}
+	structures := map[string]func() structure{
+		"BloomFilter": func() structure {
+			f := NewBloomFilter(100, 0.01, WithHasher(fnv.New64a))
+			return structure{func(d []byte) { f.Add(d) }, func() interface{} { return f.Clone() },
+				func(o interface{}) bool { return f.Equal(o.(*BloomFilter)) },
+				func(o interface{}, d []byte) bool { return o.(*BloomFilter).Test(d) }}
+		},
+		"PartitionedBloomFilter": func() structure {
+			f := NewPartitionedBloomFilter(100, 0.01)
+			return structure{func(d []byte) { f.Add(d) }, func() interface{} { return f.Clone() },
+				func(o interface{}) bool { return f.Equal(o.(*PartitionedBloomFilter)) },
+				func(o interface{}, d []byte) bool { return o.(*PartitionedBloomFilter).Test(d) }}
+		},
+		"BlockedBloomFilter": func() structure {
+			f := NewBlockedBloomFilter(100, 0.01)
+			return structure{func(d []byte) { f.Add(d) }, func() interface{} { return f.Clone() },
+				func(o interface{}) bool { return f.Equal(o.(*BlockedBloomFilter)) },
+				func(o interface{}, d []byte) bool { return o.(*BlockedBloomFilter).Test(d) }}
+		},
+		"ScalableBloomFilter": func() structure {
+			f := NewScalableBloomFilter(10, 0.01, 0.8)
+			return structure{func(d []byte) { f.Add(d) }, func() interface{} { return f.Clone() },
+				func(o interface{}) bool { return f.Equal(o.(*ScalableBloomFilter)) },
+				func(o interface{}, d []byte) bool { return o.(*ScalableBloomFilter).Test(d) }}
+		},
+		"CountingBloomFilter": func() structure {
+			f := NewDefaultCountingBloomFilter(100, 0.01)
+			return structure{func(d []byte) { f.Add(d) }, func() interface{} { return f.Clone() },
+				func(o interface{}) bool { return f.Equal(o.(*CountingBloomFilter)) },
+				func(o interface{}, d []byte) bool { return o.(*CountingBloomFilter).Test(d) }}
+		},
+		"StableBloomFilter": func() structure {
+			f := NewDefaultStableBloomFilter(1000, 0.01, WithSeed(1))
+			return structure{func(d []byte) { f.Add(d) }, func() interface{} { return f.Clone() },
+				func(o interface{}) bool { return f.Equal(o.(*StableBloomFilter)) },
+				func(o interface{}, d []byte) bool { return o.(*StableBloomFilter).Test(d) }}
+		},
+		"InverseBloomFilter": func() structure {
+			f := NewInverseBloomFilter(1000)
+			return structure{func(d []byte) { f.Add(d) }, func() interface{} { return f.Clone() },
+				func(o interface{}) bool { return f.Equal(o.(*InverseBloomFilter)) },
+				func(o interface{}, d []byte) bool { return o.(*InverseBloomFilter).Test(d) }}
+		},
+		"CuckooFilter": func() structure {
+			f := NewCuckooFilter(100, 0.01)
+			return structure{func(d []byte) { f.Add(d) }, func() interface{} { return f.Clone() },
+				func(o interface{}) bool { return f.Equal(o.(*CuckooFilter)) },
+				func(o interface{}, d []byte) bool { return o.(*CuckooFilter).Test(d) }}
+		},
+		"AdaptiveCuckooFilter": func() structure {
+			f := NewAdaptiveCuckooFilter(100, 0.01)
+			return structure{func(d []byte) { f.Add(d) }, func() interface{} { return f.Clone() },
+				func(o interface{}) bool { return f.Equal(o.(*AdaptiveCuckooFilter)) },
+				func(o interface{}, d []byte) bool { return o.(*AdaptiveCuckooFilter).Test(d) }}
+		},
+		"AtomicBloomFilter": func() structure {
+			f := NewAtomicBloomFilter(100, 0.01)
+			return structure{func(d []byte) { f.Add(d) }, func() interface{} { return f.Clone() },
+				func(o interface{}) bool { return f.Equal(o.(*AtomicBloomFilter)) },
+				func(o interface{}, d []byte) bool { return o.(*AtomicBloomFilter).Test(d) }}
+		},
+		"ShardedScalableBloomFilter": func() structure {
+			f := NewShardedScalableBloomFilter(4, 100, 0.01, 0.8)
+			return structure{func(d []byte) { f.Add(d) }, func() interface{} { return f.Clone() },
+				func(o interface{}) bool { return f.Equal(o.(*ShardedScalableBloomFilter)) },
+				func(o interface{}, d []byte) bool { return o.(*ShardedScalableBloomFilter).Test(d) }}
+		},
+		"CountMinSketch": func() structure {
+			f := NewCountMinSketch(0.001, 0.99)
+			return structure{func(d []byte) { f.Add(d) }, func() interface{} { return f.Clone() },
+				func(o interface{}) bool { return f.Equal(o.(*CountMinSketch)) },
+				func(o interface{}, d []byte) bool { return o.(*CountMinSketch).Count(d) > 0 }}
+		},
+	}
+
+	for name, newStructure := range structures {
+		s := newStructure()
+		for i := 0; i < 50; i++ {
+			s.add([]byte(strconv.Itoa(i)))
+		}
+
+		c := s.clone()
+		if !s.equal(c) {
+			t.Errorf("%s: Expected clone to be equal", name)
+		}
+
+		s.add([]byte(`foo`))
+		if s.member(c, []byte(`foo`)) || s.equal(c) {
+			t.Errorf("%s: Expected clone not to be modified", name)
+		}
+		if !s.member(c, []byte(`49`)) {
+			t.Errorf("%s: Expected `49` to be a member of the clone", name)
+		}
+	}
+
+	h, err := NewHyperLogLog(16)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	h.Add([]byte(`a`))
+	c := h.Clone()
+	for i := 0; i < 100; i++ {
+		h.Add([]byte(strconv.Itoa(i)))
+	}
+	if c.Equal(h) || c.Count() != 1 {
+		t.Errorf("Expected clone to count 1, got %d", c.Count())
+	}
+
+	topk := NewTopK(0.001, 0.99, 1)
+	topk.Add([]byte(`a`)).Add([]byte(`a`))
+	clone := topk.Clone()
+	topk.Add([]byte(`b`)).Add([]byte(`b`)).Add([]byte(`b`))
+	if elements := clone.Elements(); len(elements) != 1 || string(elements[0]) != `a` {
+		t.Errorf("Expected clone to hold only `a`, got %q", elements)
+	}
+
+	// Hash functions which can be copied aren't shared.
+	f := NewBloomFilter(100, 0.01, WithHasher(fnv.New64a))
+	if f.Clone().hash == f.hash {
+		t.Error("Expected clone to have its own hash function")
+	}
+}
+
+// Ensures that ByteSize accounts for at least the data of every structure and
+// grows with the data held by structures which keep it.
+func TestByteSize(t *testing.T) {
+	hll, _ := NewHyperLogLog(1024)
+	hybrid, _ := NewHybridCardinality(1000, 0.01)
+	sizes := map[string]struct {
+		size func() uint64
+		data uint64
+	}{
+		"BloomFilter":                {NewBloomFilter(1000, 0.01).ByteSize, ClassicMemory(1000, 0.01)},
+		"PartitionedBloomFilter":     {NewPartitionedBloomFilter(1000, 0.01).ByteSize, PartitionedMemory(1000, 0.01)},
+		"BlockedBloomFilter":         {NewBlockedBloomFilter(1000, 0.01).ByteSize, ClassicMemory(1000, 0.01)},
+		"ScalableBloomFilter":        {NewScalableBloomFilter(1000, 0.01, 0.8).ByteSize, PartitionedMemory(1000, 0.01)},
+		"CountingBloomFilter":        {NewDefaultCountingBloomFilter(1000, 0.01).ByteSize, CountingMemory(1000, 4, 0.01)},
+		"StableBloomFilter":          {NewStableBloomFilter(1000, 2, 0.01).ByteSize, 250},
+		"InverseBloomFilter":         {NewInverseBloomFilter(1000).ByteSize, 1000 * pointerSize},
+		"CuckooFilter":               {NewCuckooFilter(1000, 0.01).ByteSize, 0},
+		"AdaptiveCuckooFilter":       {NewAdaptiveCuckooFilter(1000, 0.01).ByteSize, 0},
+		"AtomicBloomFilter":          {NewAtomicBloomFilter(1000, 0.01).ByteSize, ClassicMemory(1000, 0.01)},
+		"CountMinSketch":             {NewCountMinSketch(0.01, 0.99).ByteSize, 272 * 8},
+		"HyperLogLog":                {hll.ByteSize, 1024},
+		"TopK":                       {NewTopK(0.01, 0.99, 10).ByteSize, 272 * 8},
+		"HybridCardinality":          {hybrid.ByteSize, 1000 / 8},
+		"FrequencyClassifier":        {NewFrequencyClassifier(0.01, 0.99, 0.1, 0.5).ByteSize, 272 * 8},
+		"ShardedScalableBloomFilter": {NewShardedScalableBloomFilter(4, 1000, 0.01, 0.8).ByteSize, 0},
+	}
+	for name, s := range sizes {
+		if size := s.size(); size < s.data || size == 0 {
+			t.Errorf("Expected %s to use at least %d bytes, got %d", name, s.data, size)
+		}
+	}
+
+	var (
+		inverse  = NewInverseBloomFilter(1000)
+		cuckoo   = NewCuckooFilter(1000, 0.01)
+		scalable = NewScalableBloomFilter(10, 0.01, 0.8)
+		before   = []uint64{inverse.ByteSize(), cuckoo.ByteSize(), scalable.ByteSize()}
+	)
+	for i := 0; i < 100; i++ {
+		data := []byte(strconv.Itoa(i))
+		inverse.Add(data)
+		cuckoo.Add(data)
+		scalable.Add(data)
+	}
+	for i, size := range []uint64{inverse.ByteSize(), cuckoo.ByteSize(), scalable.ByteSize()} {
+		if size <= before[i] {
+			t.Errorf("Expected size %d to grow from %d", size, before[i])
+		}
+	}
+}
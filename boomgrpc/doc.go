@@ -0,0 +1,21 @@
+// Package boomgrpc holds the gRPC service definition, sketch.proto, exposing
+// Bloom filters, Count-Min Sketches, HyperLogLogs, and Top-Ks of the boom
+// package to non-Go clients, with streaming bulk inserts.
+//
+// The boom package has no dependencies outside the standard library, so the
+// Go bindings aren't checked in. Generate them with protoc and the
+// protoc-gen-go and protoc-gen-go-grpc plugins, which add a dependency on
+// google.golang.org/grpc and google.golang.org/protobuf:
+//
+//	go generate github.com/tylertreat/BoomFilters/boomgrpc
+//
+// Server implements the generated SketchesServer interface over sketches held
+// in memory, the same way the boomhttp package exposes filters over HTTP.
+// Create one with NewServer and register it with a grpc.Server. It's built
+// only with the grpc build tag, once the bindings are generated, so the
+// package builds without them:
+//
+//	go build -tags grpc github.com/tylertreat/BoomFilters/boomgrpc
+package boomgrpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative sketch.proto
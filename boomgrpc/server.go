@@ -0,0 +1,304 @@
+//go:build grpc
+// +build grpc
+
+package boomgrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	boom "github.com/tylertreat/BoomFilters"
+)
+
+// Server implements the Sketches service over a registry of named sketches
+// held in memory. Each sketch has its own lock, so requests for different
+// sketches are served concurrently. Register it with a grpc.Server:
+//
+//	RegisterSketchesServer(grpcServer, boomgrpc.NewServer())
+type Server struct {
+	UnimplementedSketchesServer
+
+	mu       sync.RWMutex
+	sketches map[string]*sketch
+}
+
+// sketch is a named sketch of one of the kinds of the service. Only the field
+// of its kind is set.
+type sketch struct {
+	mu    sync.Mutex
+	kind  Kind
+	bloom *boom.BloomFilter
+	cms   *boom.CountMinSketch
+	hll   *boom.HyperLogLog
+	topK  *boom.TopK
+}
+
+// NewServer creates a new Server with no sketches.
+func NewServer() *Server {
+	return &Server{sketches: make(map[string]*sketch)}
+}
+
+// Create creates a named sketch with the parameters of the request. Returns
+// AlreadyExists if a sketch has the name, or InvalidArgument if the kind or
+// parameters are invalid.
+func (s *Server) Create(ctx context.Context, req *CreateRequest) (*CreateResponse, error) {
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+
+	sk, err := newSketch(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sketches[req.GetName()]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "sketch %q already exists", req.GetName())
+	}
+	s.sketches[req.GetName()] = sk
+	return &CreateResponse{}, nil
+}
+
+// newSketch creates the sketch described by the request.
+func newSketch(req *CreateRequest) (*sketch, error) {
+	sk := &sketch{kind: req.GetKind()}
+	switch req.GetKind() {
+	case Kind_BLOOM_FILTER:
+		if req.GetN() == 0 || req.GetFpRate() <= 0 || req.GetFpRate() >= 1 {
+			return nil, errors.New("a Bloom filter needs n > 0 and 0 < fp_rate < 1")
+		}
+		sk.bloom = boom.NewBloomFilter(uint(req.GetN()), req.GetFpRate())
+	case Kind_COUNT_MIN_SKETCH:
+		if !validAccuracy(req) {
+			return nil, errors.New("a Count-Min Sketch needs 0 < epsilon, delta < 1")
+		}
+		sk.cms = boom.NewCountMinSketch(req.GetEpsilon(), req.GetDelta())
+	case Kind_HYPER_LOG_LOG:
+		hll, err := boom.NewHyperLogLog(uint(req.GetRegisters()))
+		if err != nil {
+			return nil, err
+		}
+		sk.hll = hll
+	case Kind_TOP_K:
+		if !validAccuracy(req) || req.GetK() == 0 {
+			return nil, errors.New("a Top-K needs 0 < epsilon, delta < 1 and k > 0")
+		}
+		sk.topK = boom.NewTopK(req.GetEpsilon(), req.GetDelta(), uint(req.GetK()))
+	default:
+		return nil, fmt.Errorf("unknown kind %v", req.GetKind())
+	}
+	return sk, nil
+}
+
+// validAccuracy returns true if the epsilon and delta of the request are in
+// (0, 1).
+func validAccuracy(req *CreateRequest) bool {
+	return req.GetEpsilon() > 0 && req.GetEpsilon() < 1 &&
+		req.GetDelta() > 0 && req.GetDelta() < 1
+}
+
+// Add adds the keys to the named sketch. Returns NotFound if there's no sketch
+// with the name.
+func (s *Server) Add(ctx context.Context, req *AddRequest) (*AddResponse, error) {
+	sk, err := s.sketch(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+
+	sk.add(req.GetKeys())
+	return &AddResponse{Added: uint64(len(req.GetKeys()))}, nil
+}
+
+// AddStream adds the keys of every request in the stream, which may name
+// different sketches, and responds with the number of keys added once the
+// stream ends. Keys of requests before one naming a missing sketch stay
+// added.
+func (s *Server) AddStream(stream Sketches_AddStreamServer) error {
+	var added uint64
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&AddResponse{Added: added})
+		}
+		if err != nil {
+			return err
+		}
+
+		sk, err := s.sketch(req.GetName())
+		if err != nil {
+			return err
+		}
+		sk.add(req.GetKeys())
+		added += uint64(len(req.GetKeys()))
+	}
+}
+
+// Test tests the keys for membership in the named Bloom filter. Returns
+// NotFound if there's no sketch with the name, or FailedPrecondition if it
+// isn't a Bloom filter.
+func (s *Server) Test(ctx context.Context, req *TestRequest) (*TestResponse, error) {
+	sk, err := s.sketch(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if sk.kind != Kind_BLOOM_FILTER {
+		return nil, status.Errorf(codes.FailedPrecondition, "sketch %q isn't a Bloom filter", req.GetName())
+	}
+
+	sk.mu.Lock()
+	members := sk.bloom.TestMany(req.GetKeys())
+	sk.mu.Unlock()
+	return &TestResponse{Members: members}, nil
+}
+
+// Estimate returns the estimated frequencies of the keys in the named
+// Count-Min Sketch, the cardinality of the named HyperLogLog, or the elements
+// of the named Top-K from the most to the least frequent. Returns NotFound if
+// there's no sketch with the name, or FailedPrecondition if it's a Bloom
+// filter.
+func (s *Server) Estimate(ctx context.Context, req *EstimateRequest) (*EstimateResponse, error) {
+	sk, err := s.sketch(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+	resp := &EstimateResponse{}
+	switch sk.kind {
+	case Kind_COUNT_MIN_SKETCH:
+		resp.Counts = make([]uint64, len(req.GetKeys()))
+		for i, key := range req.GetKeys() {
+			resp.Counts[i] = sk.cms.Count(key)
+		}
+	case Kind_HYPER_LOG_LOG:
+		resp.Cardinality = sk.hll.Count()
+	case Kind_TOP_K:
+		estimates := sk.topK.Estimates()
+		resp.Elements = make([]*Element, len(estimates))
+		for i, estimate := range estimates {
+			resp.Elements[len(estimates)-1-i] = &Element{Data: estimate.Data, Freq: estimate.Count}
+		}
+	default:
+		return nil, status.Errorf(codes.FailedPrecondition, "sketch %q has no estimates", req.GetName())
+	}
+	return resp, nil
+}
+
+// Merge merges the snapshot, as returned by Snapshot, into the named sketch.
+// Returns NotFound if there's no sketch with the name, InvalidArgument if the
+// snapshot can't be read as a sketch of its kind, or FailedPrecondition if
+// the sketches' parameters don't match, in which case the sketch is
+// unchanged.
+func (s *Server) Merge(ctx context.Context, req *MergeRequest) (*MergeResponse, error) {
+	sk, err := s.sketch(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+	switch sk.kind {
+	case Kind_BLOOM_FILTER:
+		other := &boom.BloomFilter{}
+		if err = other.UnmarshalBinary(req.GetSnapshot()); err == nil {
+			err = mergeError(sk.bloom.Union(other))
+		}
+	case Kind_COUNT_MIN_SKETCH:
+		other := &boom.CountMinSketch{}
+		if err = other.UnmarshalBinary(req.GetSnapshot()); err == nil {
+			err = mergeError(sk.cms.Merge(other))
+		}
+	case Kind_HYPER_LOG_LOG:
+		other := &boom.HyperLogLog{}
+		if err = other.UnmarshalBinary(req.GetSnapshot()); err == nil {
+			err = mergeError(sk.hll.Merge(other))
+		}
+	case Kind_TOP_K:
+		other := &boom.TopK{}
+		if err = other.UnmarshalBinary(req.GetSnapshot()); err == nil {
+			err = mergeError(sk.topK.Merge(other))
+		}
+	}
+	if err != nil {
+		if _, ok := status.FromError(err); !ok {
+			err = status.Errorf(codes.InvalidArgument, "invalid snapshot: %v", err)
+		}
+		return nil, err
+	}
+	return &MergeResponse{}, nil
+}
+
+// mergeError returns the error of merging two sketches as a
+// FailedPrecondition status, or nil.
+func mergeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return status.Error(codes.FailedPrecondition, err.Error())
+}
+
+// Snapshot returns the binary representation written by the named sketch's
+// WriteTo method. Returns NotFound if there's no sketch with the name.
+func (s *Server) Snapshot(ctx context.Context, req *SnapshotRequest) (*SnapshotResponse, error) {
+	sk, err := s.sketch(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	sk.mu.Lock()
+	switch sk.kind {
+	case Kind_BLOOM_FILTER:
+		_, err = sk.bloom.WriteTo(&buf)
+	case Kind_COUNT_MIN_SKETCH:
+		_, err = sk.cms.WriteTo(&buf)
+	case Kind_HYPER_LOG_LOG:
+		_, err = sk.hll.WriteTo(&buf)
+	case Kind_TOP_K:
+		_, err = sk.topK.WriteTo(&buf)
+	}
+	sk.mu.Unlock()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &SnapshotResponse{Kind: sk.kind, Data: buf.Bytes()}, nil
+}
+
+// sketch returns the sketch with the name, or a NotFound status if there's
+// none.
+func (s *Server) sketch(name string) (*sketch, error) {
+	s.mu.RLock()
+	sk, ok := s.sketches[name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no sketch named %q", name)
+	}
+	return sk, nil
+}
+
+// add adds the keys to the sketch.
+func (sk *sketch) add(keys [][]byte) {
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+	for _, key := range keys {
+		switch sk.kind {
+		case Kind_BLOOM_FILTER:
+			sk.bloom.Add(key)
+		case Kind_COUNT_MIN_SKETCH:
+			sk.cms.Add(key)
+		case Kind_HYPER_LOG_LOG:
+			sk.hll.Add(key)
+		case Kind_TOP_K:
+			sk.topK.Add(key)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+//go:build grpc
+// +build grpc
+
+package boomgrpc
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// addStream is a Sketches_AddStreamServer receiving the requests in order.
+type addStream struct {
+	grpc.ServerStream
+	reqs []*AddRequest
+	resp *AddResponse
+}
+
+func (a *addStream) Recv() (*AddRequest, error) {
+	if len(a.reqs) == 0 {
+		return nil, io.EOF
+	}
+	req := a.reqs[0]
+	a.reqs = a.reqs[1:]
+	return req, nil
+}
+
+func (a *addStream) SendAndClose(resp *AddResponse) error {
+	a.resp = resp
+	return nil
+}
+
+// Ensures that keys added to a Bloom filter, one at a time or streamed, are
+// members, and that requests for missing sketches fail.
+func TestServerBloomFilter(t *testing.T) {
+	var (
+		ctx = context.Background()
+		s   = NewServer()
+	)
+	if _, err := s.Create(ctx, &CreateRequest{Name: "seen", Kind: Kind_BLOOM_FILTER, N: 100, FpRate: 0.01}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := s.Create(ctx, &CreateRequest{Name: "seen", Kind: Kind_BLOOM_FILTER, N: 100, FpRate: 0.01}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Expected AlreadyExists, got %v", err)
+	}
+
+	if resp, err := s.Add(ctx, &AddRequest{Name: "seen", Keys: [][]byte{[]byte(`a`)}}); err != nil || resp.Added != 1 {
+		t.Errorf("Expected 1 key added, got %v and %v", resp, err)
+	}
+	stream := &addStream{reqs: []*AddRequest{
+		{Name: "seen", Keys: [][]byte{[]byte(`b`), []byte(`c`)}},
+		{Name: "seen", Keys: [][]byte{[]byte(`d`)}},
+	}}
+	if err := s.AddStream(stream); err != nil || stream.resp.Added != 3 {
+		t.Errorf("Expected 3 keys added, got %v and %v", stream.resp, err)
+	}
+
+	resp, err := s.Test(ctx, &TestRequest{Name: "seen", Keys: [][]byte{[]byte(`a`), []byte(`d`), []byte(`e`)}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(resp.Members) != 3 || !resp.Members[0] || !resp.Members[1] || resp.Members[2] {
+		t.Errorf("Expected [true true false], got %v", resp.Members)
+	}
+
+	if _, err := s.Add(ctx, &AddRequest{Name: "missing"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound, got %v", err)
+	}
+	if err := s.AddStream(&addStream{reqs: []*AddRequest{{Name: "missing"}}}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound, got %v", err)
+	}
+	if _, err := s.Estimate(ctx, &EstimateRequest{Name: "seen"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition, got %v", err)
+	}
+}
+
+// Ensures that the sketches estimate what was added and merge the snapshots
+// of sketches with the same parameters.
+func TestServerEstimateMergeSnapshot(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		s    = NewServer()
+		keys [][]byte
+	)
+	for i := 0; i < 100; i++ {
+		for j := 0; j <= i%10; j++ {
+			keys = append(keys, []byte(strconv.Itoa(i%10)))
+		}
+	}
+	for _, req := range []*CreateRequest{
+		{Name: "cms", Kind: Kind_COUNT_MIN_SKETCH, Epsilon: 0.001, Delta: 0.01},
+		{Name: "hll", Kind: Kind_HYPER_LOG_LOG, Registers: 1024},
+		{Name: "topk", Kind: Kind_TOP_K, Epsilon: 0.001, Delta: 0.01, K: 3},
+	} {
+		if _, err := s.Create(ctx, req); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := s.Add(ctx, &AddRequest{Name: req.Name, Keys: keys}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	resp, err := s.Estimate(ctx, &EstimateRequest{Name: "cms", Keys: [][]byte{[]byte(`9`), []byte(`0`)}})
+	if err != nil || len(resp.Counts) != 2 || resp.Counts[0] != 100 || resp.Counts[1] != 10 {
+		t.Errorf("Expected counts [100 10], got %v and %v", resp, err)
+	}
+	if resp, err = s.Estimate(ctx, &EstimateRequest{Name: "hll"}); err != nil || resp.Cardinality != 10 {
+		t.Errorf("Expected a cardinality of 10, got %v and %v", resp, err)
+	}
+	resp, err = s.Estimate(ctx, &EstimateRequest{Name: "topk"})
+	if err != nil || len(resp.Elements) != 3 || string(resp.Elements[0].Data) != "9" || resp.Elements[0].Freq != 100 {
+		t.Errorf("Expected 9 to be the most frequent of 3 elements, got %v and %v", resp, err)
+	}
+
+	for _, name := range []string{"cms", "hll", "topk"} {
+		snapshot, err := s.Snapshot(ctx, &SnapshotRequest{Name: name})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := s.Merge(ctx, &MergeRequest{Name: name, Snapshot: snapshot.Data}); err != nil {
+			t.Errorf("Unexpected error merging %s: %v", name, err)
+		}
+		if _, err := s.Merge(ctx, &MergeRequest{Name: name, Snapshot: []byte(`invalid`)}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument, got %v", err)
+		}
+	}
+	if resp, err = s.Estimate(ctx, &EstimateRequest{Name: "cms", Keys: [][]byte{[]byte(`9`)}}); err != nil || resp.Counts[0] != 200 {
+		t.Errorf("Expected a merged count of 200, got %v and %v", resp, err)
+	}
+
+	if _, err := s.Create(ctx, &CreateRequest{Name: "other", Kind: Kind_COUNT_MIN_SKETCH, Epsilon: 0.1, Delta: 0.1}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	snapshot, _ := s.Snapshot(ctx, &SnapshotRequest{Name: "other"})
+	if _, err := s.Merge(ctx, &MergeRequest{Name: "cms", Snapshot: snapshot.Data}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition, got %v", err)
+	}
+	if _, err := s.Create(ctx, &CreateRequest{Name: "bad", Kind: Kind_HYPER_LOG_LOG, Registers: 3}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument, got %v", err)
+	}
+}
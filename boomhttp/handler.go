@@ -0,0 +1,293 @@
+// Package boomhttp serves named filters over HTTP, so that a shared
+// deduplication service can be backed directly by the boom package.
+//
+// A Handler manages a registry of filters and exposes these endpoints, whose
+// request and response bodies are JSON unless noted otherwise:
+//
+//	GET  /filters               names of the registered filters
+//	POST /filters/{name}/add    adds {"keys": [...]}, returns {"added": n}
+//	POST /filters/{name}/test   tests {"keys": [...]}, returns {"members": [...]}
+//	POST /filters/{name}/bulk   tests and adds {"keys": [...]}, returns {"members": [...]}
+//	GET  /filters/{name}/stats  parameters and fill of the filter
+//	GET  /filters/{name}/dump   binary representation written by the filter's WriteTo
+//
+// Errors are returned as {"error": "..."} with an appropriate status code.
+package boomhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	boom "github.com/tylertreat/BoomFilters"
+)
+
+// Handler is an http.Handler serving a registry of named filters. Each filter
+// is wrapped in a boom.SafeFilter, so requests for the same filter may be
+// served concurrently.
+type Handler struct {
+	// Create, if set, creates the filter for a name which isn't registered
+	// when keys are first added to it. Returning an error rejects the name.
+	Create func(name string) (boom.Filter, error)
+
+	mu      sync.RWMutex
+	filters map[string]*boom.SafeFilter
+}
+
+// NewHandler creates a new Handler with no filters registered.
+func NewHandler() *Handler {
+	return &Handler{filters: make(map[string]*boom.SafeFilter)}
+}
+
+// Register registers the filter under the name, replacing any filter
+// previously registered under it. The filter must not be used directly
+// afterwards.
+func (h *Handler) Register(name string, filter boom.Filter) {
+	h.mu.Lock()
+	h.filters[name] = boom.NewSafeFilter(filter)
+	h.mu.Unlock()
+}
+
+// Unregister removes the filter registered under the name, if any.
+func (h *Handler) Unregister(name string) {
+	h.mu.Lock()
+	delete(h.filters, name)
+	h.mu.Unlock()
+}
+
+// Filter returns the SafeFilter wrapping the filter registered under the name,
+// or nil if there is none.
+func (h *Handler) Filter(name string) *boom.SafeFilter {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.filters[name]
+}
+
+// Names returns the names of the registered filters in sorted order.
+func (h *Handler) Names() []string {
+	h.mu.RLock()
+	names := make([]string, 0, len(h.filters))
+	for name := range h.filters {
+		names = append(names, name)
+	}
+	h.mu.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// Stats describes a filter. Fields which the filter doesn't report are
+// omitted.
+type Stats struct {
+	Name            string   `json:"name"`
+	Type            string   `json:"type"`
+	Capacity        *uint    `json:"capacity,omitempty"`
+	Count           *uint    `json:"count,omitempty"`
+	FillRatio       *float64 `json:"fill_ratio,omitempty"`
+	EstimatedFPRate *float64 `json:"estimated_fp_rate,omitempty"`
+	ByteSize        *uint64  `json:"byte_size,omitempty"`
+}
+
+// keysRequest is the body of add, test, and bulk requests.
+type keysRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// ServeHTTP serves the endpoints described in the package documentation.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 0 || parts[0] != "filters" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string][]string{"filters": h.Names()})
+		return
+	}
+
+	if len(parts) != 3 || parts[1] == "" {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	name, action := parts[1], parts[2]
+	switch action {
+	case "add", "test", "bulk":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		h.serveKeys(w, r, name, action)
+	case "stats", "dump":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		filter := h.Filter(name)
+		if filter == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no filter named %q", name))
+			return
+		}
+		if action == "stats" {
+			writeJSON(w, http.StatusOK, stats(name, filter))
+		} else {
+			serveDump(w, filter)
+		}
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// serveKeys adds, tests, or tests and adds the keys of the request body.
+func (h *Handler) serveKeys(w http.ResponseWriter, r *http.Request, name, action string) {
+	var req keysRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid body: %v", err))
+		return
+	}
+
+	filter := h.Filter(name)
+	if filter == nil && action != "test" {
+		var err error
+		if filter, err = h.create(name); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if filter == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no filter named %q", name))
+		return
+	}
+
+	keys := make([][]byte, len(req.Keys))
+	for i, key := range req.Keys {
+		keys[i] = []byte(key)
+	}
+
+	switch action {
+	case "add":
+		filter.AddMany(keys)
+		writeJSON(w, http.StatusOK, map[string]int{"added": len(keys)})
+	case "test":
+		writeJSON(w, http.StatusOK, map[string][]bool{"members": filter.TestMany(keys)})
+	default:
+		members := make([]bool, len(keys))
+		filter.Do(func(f boom.Filter) {
+			for i, key := range keys {
+				members[i] = f.TestAndAdd(key)
+			}
+		})
+		writeJSON(w, http.StatusOK, map[string][]bool{"members": members})
+	}
+}
+
+// create creates and registers the filter for the name with Create, unless
+// another request registered one first.
+func (h *Handler) create(name string) (*boom.SafeFilter, error) {
+	if h.Create == nil {
+		return nil, fmt.Errorf("no filter named %q", name)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if filter, ok := h.filters[name]; ok {
+		return filter, nil
+	}
+
+	f, err := h.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	filter := boom.NewSafeFilter(f)
+	h.filters[name] = filter
+	return filter, nil
+}
+
+// stats returns the Stats of the filter, reading whichever properties it has.
+func stats(name string, filter *boom.SafeFilter) Stats {
+	s := Stats{Name: name}
+	filter.Do(func(f boom.Filter) {
+		s.Type = strings.TrimPrefix(fmt.Sprintf("%T", f), "*boom.")
+		if f, ok := f.(interface {
+			Capacity() uint
+		}); ok {
+			capacity := f.Capacity()
+			s.Capacity = &capacity
+		}
+		if f, ok := f.(interface {
+			Count() uint
+		}); ok {
+			count := f.Count()
+			s.Count = &count
+		}
+		if f, ok := f.(interface {
+			FillRatio() float64
+		}); ok {
+			ratio := f.FillRatio()
+			s.FillRatio = &ratio
+		}
+		if f, ok := f.(interface {
+			EstimatedFPRate() float64
+		}); ok {
+			rate := f.EstimatedFPRate()
+			s.EstimatedFPRate = &rate
+		}
+		if f, ok := f.(interface {
+			ByteSize() uint64
+		}); ok {
+			size := f.ByteSize()
+			s.ByteSize = &size
+		}
+	})
+	return s
+}
+
+// serveDump writes the binary representation of the filter, if it has one.
+func serveDump(w http.ResponseWriter, filter *boom.SafeFilter) {
+	var (
+		buf bytes.Buffer
+		err error
+		ok  bool
+	)
+	filter.Do(func(f boom.Filter) {
+		var writer io.WriterTo
+		if writer, ok = f.(io.WriterTo); ok {
+			_, err = writer.WriteTo(&buf)
+		}
+	})
+	if !ok {
+		writeError(w, http.StatusNotImplemented, errors.New("filter can't be dumped"))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// writeJSON writes the value as a JSON response with the status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes the error as a JSON response with the status code.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
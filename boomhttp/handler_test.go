@@ -0,0 +1,106 @@
+package boomhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	boom "github.com/tylertreat/BoomFilters"
+)
+
+// do serves the request and decodes the JSON response into v, returning the
+// status code.
+func do(t *testing.T, h http.Handler, method, path, body string, v interface{}) int {
+	var (
+		r = httptest.NewRequest(method, path, strings.NewReader(body))
+		w = httptest.NewRecorder()
+	)
+	h.ServeHTTP(w, r)
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: invalid response %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
+
+// Ensures that keys can be added to, tested in, and bulk-inserted into named
+// filters.
+func TestHandlerKeys(t *testing.T) {
+	h := NewHandler()
+	h.Register("seen", boom.NewBloomFilter(100, 0.01))
+
+	var added map[string]int
+	if code := do(t, h, "POST", "/filters/seen/add", `{"keys": ["a", "b"]}`, &added); code != http.StatusOK || added["added"] != 2 {
+		t.Errorf("Expected 2 keys added, got %d %v", code, added)
+	}
+
+	var test map[string][]bool
+	if code := do(t, h, "POST", "/filters/seen/test", `{"keys": ["a", "c"]}`, &test); code != http.StatusOK ||
+		len(test["members"]) != 2 || !test["members"][0] || test["members"][1] {
+		t.Errorf("Expected [true false], got %d %v", code, test)
+	}
+
+	var bulk map[string][]bool
+	if code := do(t, h, "POST", "/filters/seen/bulk", `{"keys": ["b", "d", "d"]}`, &bulk); code != http.StatusOK ||
+		len(bulk["members"]) != 3 || !bulk["members"][0] || bulk["members"][1] || !bulk["members"][2] {
+		t.Errorf("Expected [true false true], got %d %v", code, bulk)
+	}
+
+	var errResp map[string]string
+	if code := do(t, h, "POST", "/filters/other/add", `{"keys": ["a"]}`, &errResp); code != http.StatusBadRequest || errResp["error"] == "" {
+		t.Errorf("Expected error adding to an unknown filter, got %d %v", code, errResp)
+	}
+	if code := do(t, h, "POST", "/filters/seen/add", `{`, &errResp); code != http.StatusBadRequest {
+		t.Errorf("Expected error for an invalid body, got %d", code)
+	}
+	if code := do(t, h, "GET", "/filters/seen/add", ``, &errResp); code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected method not allowed, got %d", code)
+	}
+	if code := do(t, h, "POST", "/filters/other/test", `{"keys": ["a"]}`, &errResp); code != http.StatusNotFound {
+		t.Errorf("Expected not found, got %d", code)
+	}
+}
+
+// Ensures that filters are created on demand and can be listed, described,
+// and dumped.
+func TestHandlerRegistry(t *testing.T) {
+	h := NewHandler()
+	h.Create = func(name string) (boom.Filter, error) {
+		return boom.NewDefaultScalableBloomFilter(0.01), nil
+	}
+	do(t, h, "POST", "/filters/b/add", `{"keys": ["x"]}`, nil)
+	do(t, h, "POST", "/filters/a/bulk", `{"keys": ["y"]}`, nil)
+
+	var list map[string][]string
+	if do(t, h, "GET", "/filters", ``, &list); strings.Join(list["filters"], ",") != "a,b" {
+		t.Errorf("Expected filters a and b, got %v", list)
+	}
+
+	var stats Stats
+	if code := do(t, h, "GET", "/filters/b/stats", ``, &stats); code != http.StatusOK || stats.Name != "b" ||
+		stats.Type != "ScalableBloomFilter" || stats.FillRatio == nil || *stats.FillRatio == 0 || stats.ByteSize == nil {
+		t.Errorf("Unexpected stats %d %+v", code, stats)
+	}
+
+	var (
+		r = httptest.NewRequest("GET", "/filters/b/dump", nil)
+		w = httptest.NewRecorder()
+	)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected dump, got %d", w.Code)
+	}
+	f := boom.NewDefaultScalableBloomFilter(0.01)
+	if _, err := f.ReadFrom(bytes.NewReader(w.Body.Bytes())); err != nil || !f.Test([]byte(`x`)) {
+		t.Errorf("Expected dump to hold x, got error %v", err)
+	}
+
+	h.Unregister("b")
+	if code := do(t, h, "GET", "/filters/b/stats", ``, nil); code != http.StatusNotFound {
+		t.Errorf("Expected not found after unregistering, got %d", code)
+	}
+}
@@ -1,16 +1,48 @@
 package boom
 
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+	"unsafe"
+)
+
 // Buckets is a fast, space-efficient array of buckets where each bucket can
 // store up to a configured maximum value.
 type Buckets struct {
 	data       []byte
 	bucketSize uint8
-	max        uint8
+	max        uint32
 	count      uint
+	mmap       *mmapFile   // file backing data, if memory-mapped
+	store      BucketStore // storage used instead of data, if set
+}
+
+// BucketsFactory creates the Buckets used by a filter. The index identifies
+// the Buckets within the filter, so a factory can return the same storage each
+// time the filter is created with the same parameters.
+type BucketsFactory func(index int, count uint, bucketSize uint8) (*Buckets, error)
+
+// newFactoryBuckets creates Buckets with the factory and checks that they have
+// the requested number and size of buckets.
+func newFactoryBuckets(newBuckets BucketsFactory, index int, count uint, bucketSize uint8) (*Buckets, error) {
+	b, err := newBuckets(index, count, bucketSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.count != count || b.bucketSize != bucketSize {
+		b.Close()
+		return nil, errors.New("factory buckets don't match filter size")
+	}
+	return b, nil
 }
 
 // NewBuckets creates a new Buckets with the provided number of buckets where
-// each bucket is the specified number of bits.
+// each bucket is the specified number of bits, up to 31.
 func NewBuckets(count uint, bucketSize uint8) *Buckets {
 	return &Buckets{
 		count:      count,
@@ -20,8 +52,18 @@ func NewBuckets(count uint, bucketSize uint8) *Buckets {
 	}
 }
 
-// MaxBucketValue returns the maximum value that can be stored in a bucket.
+// MaxBucketValue returns the maximum value that can be stored in a bucket, or
+// 255 if buckets are wider than 8 bits. Use MaxBucketValueWide for those.
 func (b *Buckets) MaxBucketValue() uint8 {
+	if b.max > 255 {
+		return 255
+	}
+	return uint8(b.max)
+}
+
+// MaxBucketValueWide returns the maximum value that can be stored in a bucket,
+// including buckets wider than 8 bits.
+func (b *Buckets) MaxBucketValueWide() uint32 {
 	return b.max
 }
 
@@ -35,6 +77,11 @@ func (b *Buckets) Count() uint {
 // is clamped to zero and the maximum bucket value. Returns itself to allow for
 // chaining.
 func (b *Buckets) Increment(bucket uint, delta int32) *Buckets {
+	if b.store != nil {
+		b.incrementStored(bucket, delta)
+		return b
+	}
+
 	val := int32(b.getBits(bucket*uint(b.bucketSize), uint(b.bucketSize))) + delta
 	if val > int32(b.max) {
 		val = int32(b.max)
@@ -42,33 +89,391 @@ func (b *Buckets) Increment(bucket uint, delta int32) *Buckets {
 		val = 0
 	}
 
-	b.setBits(uint32(bucket)*uint32(b.bucketSize), uint32(b.bucketSize), uint32(val))
+	b.setBits(bucket*uint(b.bucketSize), uint(b.bucketSize), uint32(val))
 	return b
 }
 
 // Set will set the bucket value. The value is clamped to zero and the maximum
 // bucket value. Returns itself to allow for chaining.
 func (b *Buckets) Set(bucket uint, value uint8) *Buckets {
-	if value > b.max {
-		value = b.max
+	return b.SetWide(bucket, uint32(value))
+}
+
+// SetWide is equivalent to Set for values wider than 8 bits, which can be
+// stored in buckets wider than 8 bits. Returns itself to allow for chaining.
+func (b *Buckets) SetWide(bucket uint, val uint32) *Buckets {
+	if val > b.max {
+		val = b.max
 	}
 
-	b.setBits(uint32(bucket)*uint32(b.bucketSize), uint32(b.bucketSize), uint32(value))
+	if b.store != nil {
+		b.store.Set(bucket, val)
+		return b
+	}
+
+	if b.bucketSize == 1 {
+		// Fast path for bit arrays.
+		if val == 0 {
+			b.data[bucket/8] &^= 1 << (bucket % 8)
+		} else {
+			b.data[bucket/8] |= 1 << (bucket % 8)
+		}
+		return b
+	}
+
+	b.setBits(bucket*uint(b.bucketSize), uint(b.bucketSize), val)
 	return b
 }
 
 // Get returns the value in the specified bucket.
 func (b *Buckets) Get(bucket uint) uint32 {
+	if b.store != nil {
+		return b.store.Get(bucket)
+	}
+	if b.bucketSize == 1 {
+		return uint32(b.data[bucket/8]>>(bucket%8)) & 1
+	}
 	return b.getBits(bucket*uint(b.bucketSize), uint(b.bucketSize))
 }
 
 // Reset restores the Buckets to the original state. Returns itself to allow
 // for chaining.
 func (b *Buckets) Reset() *Buckets {
+	if b.store != nil {
+		b.store.Reset()
+		return b
+	}
+
+	if b.mmap != nil {
+		// Clear the mapping in place so the file is cleared too.
+		for i := range b.data {
+			b.data[i] = 0
+		}
+		return b
+	}
+
 	b.data = make([]byte, (b.count*uint(b.bucketSize)+7)/8)
 	return b
 }
 
+// union sets each bucket of a Bloom filter's single-bit Buckets which is set in
+// the other Buckets of the same size.
+func (b *Buckets) union(other *Buckets) {
+	if b.store != nil || other.store != nil {
+		for i := uint(0); i < b.count; i++ {
+			if other.Get(i) != 0 {
+				b.Set(i, 1)
+			}
+		}
+		return
+	}
+
+	for i, word := range other.data {
+		b.data[i] |= word
+	}
+}
+
+// intersect clears each bucket of a Bloom filter's single-bit Buckets which
+// isn't set in the other Buckets of the same size.
+func (b *Buckets) intersect(other *Buckets) {
+	if b.store != nil || other.store != nil {
+		for i := uint(0); i < b.count; i++ {
+			if other.Get(i) == 0 {
+				b.Set(i, 0)
+			}
+		}
+		return
+	}
+
+	for i, word := range other.data {
+		b.data[i] &= word
+	}
+}
+
+// ones returns the sum of the bucket values, which is the number of set bits of
+// a Bloom filter's single-bit Buckets. Single-bit Buckets held in memory are
+// counted a word at a time instead of bucket by bucket.
+func (b *Buckets) ones() uint {
+	if b.store != nil || b.bucketSize != 1 {
+		sum := uint(0)
+		for i := uint(0); i < b.count; i++ {
+			sum += uint(b.Get(i))
+		}
+		return sum
+	}
+
+	var (
+		n    = 0
+		data = b.data
+	)
+	for ; len(data) >= 8; data = data[8:] {
+		n += bits.OnesCount64(binary.LittleEndian.Uint64(data))
+	}
+	for _, v := range data {
+		n += bits.OnesCount8(v)
+	}
+	return uint(n)
+}
+
+// Equal returns true if the other Buckets have the same number and size of
+// buckets holding the same values.
+func (b *Buckets) Equal(other *Buckets) bool {
+	if b.count != other.count || b.bucketSize != other.bucketSize {
+		return false
+	}
+
+	if b.store == nil && other.store == nil {
+		return bytes.Equal(b.data, other.data)
+	}
+
+	for i := uint(0); i < b.count; i++ {
+		if b.Get(i) != other.Get(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the Buckets held in memory, even if
+// these are memory-mapped or use a BucketStore.
+func (b *Buckets) Clone() *Buckets {
+	c := &Buckets{
+		data:       make([]byte, (b.count*uint(b.bucketSize)+7)/8),
+		bucketSize: b.bucketSize,
+		max:        b.max,
+		count:      b.count,
+	}
+	if b.store == nil {
+		copy(c.data, b.data)
+		return c
+	}
+
+	for i := uint(0); i < b.count; i++ {
+		if v := b.Get(i); v != 0 {
+			c.SetWide(i, v)
+		}
+	}
+	return c
+}
+
+// ByteSize returns the number of bytes used by the Buckets, including
+// memory-mapped data but not the values kept by a BucketStore.
+func (b *Buckets) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*b)) + uint64(cap(b.data))
+}
+
+// Sync flushes the data of memory-mapped Buckets to the backing file. It does
+// nothing for in-memory Buckets.
+func (b *Buckets) Sync() error {
+	if b.mmap == nil {
+		return nil
+	}
+	return b.mmap.file.Sync()
+}
+
+// Close unmaps memory-mapped Buckets and closes the backing file, or closes the
+// BucketStore if it implements io.Closer. The Buckets must not be used
+// afterwards. It does nothing for in-memory Buckets.
+func (b *Buckets) Close() error {
+	if closer, ok := b.store.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	if b.mmap == nil {
+		return nil
+	}
+
+	err := munmap(b.data)
+	if cerr := b.mmap.file.Close(); err == nil {
+		err = cerr
+	}
+	b.data = nil
+	b.mmap = nil
+	return err
+}
+
+// WriteTo writes a binary representation of the Buckets to an I/O stream. The
+// data of sparse Buckets, such as those of a barely filled filter, is
+// run-length encoded, which ReadFrom decodes transparently. It returns the
+// number of bytes written.
+func (b *Buckets) WriteTo(stream io.Writer) (int64, error) {
+	data := b.data
+	if b.store != nil {
+		data = b.storedData()
+	}
+
+	length := uint64(len(data))
+	if len(data) <= maxCompressedBuckets {
+		if runs := compressRuns(data); runs != nil {
+			data = runs
+			length = uint64(len(runs)) | compressedBuckets
+		}
+	}
+
+	var e encoder
+	e.write(b.bucketSize)
+	e.write(uint64(b.count))
+	e.write(length)
+	e.write(data)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of Buckets (such as might have been
+// written by WriteTo()) from an I/O stream. It returns the number of bytes
+// read. Memory-mapped Buckets and Buckets using a BucketStore are overwritten
+// in place, so the data must have the same size.
+func (b *Buckets) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d          = decoder{r: payload}
+		bucketSize uint8
+		count      uint64
+		length     uint64
+	)
+	d.read(&bucketSize)
+	d.read(&count)
+	d.read(&length)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if bucketSize < 1 || bucketSize > 31 {
+		return n, errors.New("invalid bucket size")
+	}
+	if count > (math.MaxUint64-7)/uint64(bucketSize) {
+		return n, errors.New("invalid bucket count")
+	}
+
+	compressed := length&compressedBuckets != 0
+	length &^= compressedBuckets
+	size := (count*uint64(bucketSize) + 7) / 8
+	if (!compressed && length != size) || length > uint64(payload.Len()) {
+		return n, errors.New("invalid buckets data length")
+	}
+	// Compressed data can expand to any size, so it's bounded separately.
+	if compressed && size > maxCompressedBuckets {
+		return n, errors.New("invalid buckets data length")
+	}
+
+	data := make([]byte, length)
+	d.read(data)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if compressed {
+		runs := data
+		data = make([]byte, size)
+		if err := decompressRuns(runs, data); err != nil {
+			return n, err
+		}
+	}
+
+	if b.mmap != nil || b.store != nil {
+		if bucketSize != b.bucketSize || uint(count) != b.count {
+			return n, errors.New("buckets don't match existing buckets")
+		}
+		if b.store != nil {
+			b.loadStored(data)
+		} else {
+			copy(b.data, data)
+		}
+		return n, nil
+	}
+
+	b.bucketSize = bucketSize
+	b.max = (1 << bucketSize) - 1
+	b.count = uint(count)
+	b.data = data
+	return n, nil
+}
+
+// compressedBuckets is set in the data length written by Buckets.WriteTo when
+// the data is compressed by compressRuns.
+const compressedBuckets = 1 << 63
+
+// maxCompressedBuckets is the largest size in bytes of the data of Buckets
+// which WriteTo compresses, bounding what ReadFrom allocates to decompress it.
+const maxCompressedBuckets = 1 << 30
+
+// compressRuns returns the data encoded as alternating runs of zero bytes and
+// of literal bytes, each preceded by its length as a uvarint, with trailing
+// zero bytes omitted. It returns nil if the encoding would be more than half
+// the size of the data, so only sparse data, such as a barely filled filter,
+// is compressed.
+func compressRuns(data []byte) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+
+	var (
+		limit  = len(data) / 2
+		runs   = []byte{}
+		varint [binary.MaxVarintLen64]byte
+	)
+	for i := 0; i < len(data); {
+		start := i
+		for i < len(data) && data[i] == 0 {
+			i++
+		}
+		if i == len(data) {
+			break
+		}
+		zeros := i - start
+
+		// Single zero bytes are kept in the literal run, since ending it
+		// costs more than the byte.
+		start = i
+		for i < len(data) && (data[i] != 0 || (i+1 < len(data) && data[i+1] != 0)) {
+			i++
+		}
+
+		runs = append(runs, varint[:binary.PutUvarint(varint[:], uint64(zeros))]...)
+		runs = append(runs, varint[:binary.PutUvarint(varint[:], uint64(i-start))]...)
+		runs = append(runs, data[start:i]...)
+		if len(runs) > limit {
+			return nil
+		}
+	}
+	return runs
+}
+
+// decompressRuns decodes runs encoded by compressRuns into data, which must
+// be zeroed and the size of the original data.
+func decompressRuns(runs, data []byte) error {
+	var (
+		r   = bytes.NewReader(runs)
+		pos uint64
+	)
+	for r.Len() > 0 {
+		zeros, err := binary.ReadUvarint(r)
+		if err != nil {
+			return errors.New("invalid compressed buckets data")
+		}
+		literal, err := binary.ReadUvarint(r)
+		if err != nil {
+			return errors.New("invalid compressed buckets data")
+		}
+
+		rem := uint64(len(data)) - pos
+		if zeros > rem || literal > rem-zeros || literal > uint64(r.Len()) {
+			return errors.New("invalid compressed buckets data")
+		}
+		pos += zeros
+		r.Read(data[pos : pos+literal])
+		pos += literal
+	}
+	return nil
+}
+
 // getBits returns the bits at the specified offset and length.
 func (b *Buckets) getBits(offset, length uint) uint32 {
 	byteIndex := offset / 8
@@ -82,7 +487,7 @@ func (b *Buckets) getBits(offset, length uint) uint32 {
 }
 
 // setBits sets bits at the specified offset and length.
-func (b *Buckets) setBits(offset, length, bits uint32) {
+func (b *Buckets) setBits(offset, length uint, bits uint32) {
 	byteIndex := offset / 8
 	byteOffset := offset % 8
 	if byteOffset+length > 8 {
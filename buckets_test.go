@@ -1,6 +1,9 @@
 package boom
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+)
 
 // Ensures that MaxBucketValue returns the correct maximum based on the bucket
 // size.
@@ -12,6 +15,42 @@ func TestMaxBucketValue(t *testing.T) {
 	}
 }
 
+// Ensures that buckets wider than 8 bits can store values above 255.
+func TestBucketsWide(t *testing.T) {
+	b := NewBuckets(10, 10)
+
+	if max := b.MaxBucketValueWide(); max != 1023 {
+		t.Errorf("Expected 1023, got %d", max)
+	}
+	if max := b.MaxBucketValue(); max != 255 {
+		t.Errorf("Expected 255, got %d", max)
+	}
+
+	b.Increment(3, 1000)
+	if val := b.Get(3); val != 1000 {
+		t.Errorf("Expected 1000, got %d", val)
+	}
+
+	b.Increment(3, 100)
+	if val := b.Get(3); val != 1023 {
+		t.Errorf("Expected 1023, got %d", val)
+	}
+
+	if val := b.Get(2); val != 0 {
+		t.Errorf("Expected 0, got %d", val)
+	}
+
+	b.SetWide(2, 700)
+	if val := b.Get(2); val != 700 {
+		t.Errorf("Expected 700, got %d", val)
+	}
+
+	b.SetWide(2, 5000)
+	if val := b.Get(2); val != 1023 {
+		t.Errorf("Expected 1023, got %d", val)
+	}
+}
+
 // Ensures that Count returns the number of buckets.
 func TestBucketsCount(t *testing.T) {
 	b := NewBuckets(10, 2)
@@ -74,6 +113,111 @@ func TestBucketsReset(t *testing.T) {
 	}
 }
 
+// Ensures that WriteTo and ReadFrom round-trip the Buckets.
+func TestBucketsReadWrite(t *testing.T) {
+	b := NewBuckets(100, 3)
+	for i := uint(0); i < 100; i += 3 {
+		b.Set(i, uint8(i%8))
+	}
+
+	var buf bytes.Buffer
+	wn, err := b.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if wn != int64(buf.Len()) {
+		t.Errorf("Expected %d bytes written, got %d", buf.Len(), wn)
+	}
+
+	other := &Buckets{}
+	rn, err := other.ReadFrom(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rn != wn {
+		t.Errorf("Expected %d bytes read, got %d", wn, rn)
+	}
+
+	if other.Count() != 100 || other.MaxBucketValue() != 7 {
+		t.Errorf("Expected count 100 and max 7, got %d and %d", other.Count(), other.MaxBucketValue())
+	}
+
+	for i := uint(0); i < 100; i++ {
+		if other.Get(i) != b.Get(i) {
+			t.Errorf("Expected %d, got %d", b.Get(i), other.Get(i))
+		}
+	}
+}
+
+// Ensures that WriteTo run-length encodes sparse Buckets, writing the data of
+// dense Buckets as is, and that ReadFrom decodes both.
+func TestBucketsReadWriteSparse(t *testing.T) {
+	var (
+		sparse = NewBuckets(1000000, 1)
+		dense  = NewBuckets(1000, 1)
+	)
+	for i := uint(0); i < 1000000; i += 997 {
+		sparse.Set(i, 1)
+	}
+	sparse.Set(999999, 1)
+	for i := uint(0); i < 1000; i += 2 {
+		dense.Set(i, 1)
+	}
+
+	for _, b := range []*Buckets{sparse, dense, NewBuckets(1000, 4)} {
+		var buf bytes.Buffer
+		if _, err := b.WriteTo(&buf); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if size := len(b.data) / 2; b != dense && buf.Len() > size {
+			t.Errorf("Expected at most %d bytes, got %d", size, buf.Len())
+		} else if b == dense && buf.Len() < len(b.data) {
+			t.Errorf("Expected at least %d bytes, got %d", len(b.data), buf.Len())
+		}
+
+		other := &Buckets{}
+		if _, err := other.ReadFrom(&buf); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !other.Equal(b) {
+			t.Error("Expected read Buckets to equal written Buckets")
+		}
+	}
+
+	// Runs extending past the data are rejected.
+	if err := decompressRuns([]byte{10, 1, 1}, make([]byte, 10)); err == nil {
+		t.Error("Expected error for invalid runs")
+	}
+}
+
+// Ensures that ones counts the set bits of single-bit Buckets a word at a time
+// and sums the values of wider or stored Buckets.
+func TestBucketsOnes(t *testing.T) {
+	var (
+		b      = NewBuckets(1003, 1)
+		wide   = NewBuckets(100, 3)
+		stored = NewBucketsWithStore(&mapStore{values: make(map[uint]uint32), count: 100}, 1)
+	)
+	for i := uint(0); i < 1003; i += 3 {
+		b.Set(i, 1)
+	}
+	for i := uint(0); i < 100; i += 3 {
+		wide.Set(i, 2)
+		stored.Set(i, 1)
+	}
+
+	if n := b.ones(); n != 335 {
+		t.Errorf("Expected 335, got %d", n)
+	}
+	if n := wide.ones(); n != 68 {
+		t.Errorf("Expected 68, got %d", n)
+	}
+	if n := stored.ones(); n != 34 {
+		t.Errorf("Expected 34, got %d", n)
+	}
+}
+
 func BenchmarkBucketsIncrement(b *testing.B) {
 	buckets := NewBuckets(10000, 10)
 	for n := 0; n < b.N; n++ {
@@ -99,3 +243,44 @@ func BenchmarkBucketsGet(b *testing.B) {
 		buckets.Get(uint(n) % 10000)
 	}
 }
+
+func BenchmarkBucketsOnes(b *testing.B) {
+	buckets := NewBuckets(1<<20, 1)
+	for n := uint(0); n < 1<<20; n += 3 {
+		buckets.Set(n, 1)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		buckets.ones()
+	}
+}
+
+// Ensures that ReadFrom rejects bucket sizes Buckets can't have, counts whose
+// size overflows and compressed data expanding past the size WriteTo
+// compresses, instead of allocating them.
+func TestBucketsReadFromInvalid(t *testing.T) {
+	for _, params := range []struct {
+		bucketSize    uint8
+		count, length uint64
+	}{
+		{0, 8, 0},
+		{32, 8, 32},
+		{8, 1 << 63, 0},
+		{8, 1 << 62, compressedBuckets},
+		{1, maxCompressedBuckets*8 + 8, compressedBuckets},
+	} {
+		var e encoder
+		e.write(params.bucketSize)
+		e.write(params.count)
+		e.write(params.length)
+		e.write(make([]byte, 32))
+
+		var buf bytes.Buffer
+		if _, err := writeFrame(&buf, e.buf.Bytes()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := (&Buckets{}).ReadFrom(&buf); err == nil {
+			t.Errorf("Expected error for %+v", params)
+		}
+	}
+}
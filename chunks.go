@@ -0,0 +1,133 @@
+package boom
+
+import "errors"
+
+// chunkable is implemented by structures which can be dumped and restored in
+// chunks. The dump consists of a header describing the structure's parameters
+// followed by a fixed-size data section.
+type chunkable interface {
+	// chunkHeader returns the header.
+	chunkHeader() ([]byte, error)
+
+	// chunkHeaderDataSize validates the header and returns the size of the
+	// data section it describes, without allocating the structure.
+	chunkHeaderDataSize(header []byte) (uint64, error)
+
+	// loadChunkHeader replaces the structure with an empty one described by
+	// the header.
+	loadChunkHeader(header []byte) error
+
+	// chunkDataSize returns the size of the data section in bytes.
+	chunkDataSize() uint64
+
+	// readChunkData copies the data section starting at the offset into p.
+	readChunkData(offset uint64, p []byte)
+
+	// writeChunkData copies p into the data section starting at the offset.
+	writeChunkData(offset uint64, p []byte)
+}
+
+// scanDump returns the chunk following the iterator and the iterator to
+// load it with and to pass to the next call. The first chunk, returned for an
+// iterator of zero, is the header and the rest are at most maxChunkSize bytes
+// of the data section. An iterator of zero and a nil chunk are returned once
+// the dump is complete.
+func scanDump(c chunkable, iter uint64, maxChunkSize int) (uint64, []byte, error) {
+	if iter == 0 {
+		header, err := c.chunkHeader()
+		return 1, header, err
+	}
+
+	if maxChunkSize <= 0 {
+		return 0, nil, errors.New("chunk size must be positive")
+	}
+
+	var (
+		offset = iter - 1
+		size   = c.chunkDataSize()
+	)
+	if offset >= size {
+		return 0, nil, nil
+	}
+
+	chunk := make([]byte, minUint64(uint64(maxChunkSize), size-offset))
+	c.readChunkData(offset, chunk)
+	return iter + uint64(len(chunk)), chunk, nil
+}
+
+// chunkLoad is a dump being loaded by loadChunk.
+type chunkLoad struct {
+	header []byte // first chunk, describing the structure
+	size   uint64 // size of the data section described by the header
+	data   []byte // data section loaded so far
+}
+
+// loadChunk restores a chunk returned by scanDump along with the iterator. The
+// chunks are held in the load until the last one, at which point the structure
+// is replaced, so the memory used is bounded by the data actually loaded
+// rather than by the sizes in the header, which may be crafted. The chunks
+// must be loaded in order.
+func loadChunk(c chunkable, load **chunkLoad, iter uint64, chunk []byte) error {
+	if iter == 1 {
+		size, err := c.chunkHeaderDataSize(chunk)
+		if err != nil {
+			return err
+		}
+		*load = &chunkLoad{header: append([]byte(nil), chunk...), size: size}
+	} else {
+		l := *load
+		if l == nil || iter-1 < uint64(len(chunk)) || iter-1 > l.size ||
+			iter-1-uint64(len(chunk)) != uint64(len(l.data)) {
+			return errors.New("chunk doesn't match the dump")
+		}
+		l.data = append(l.data, chunk...)
+	}
+
+	l := *load
+	if uint64(len(l.data)) < l.size {
+		return nil
+	}
+	*load = nil
+	if err := c.loadChunkHeader(l.header); err != nil {
+		return err
+	}
+	c.writeChunkData(0, l.data)
+	return nil
+}
+
+// addChunkDataSize returns the size in bytes of count items of size bytes
+// added to total, and false if it overflows an int, so it can't be allocated.
+func addChunkDataSize(total, count, size uint64) (uint64, bool) {
+	const maxSize = uint64(^uint(0) >> 1)
+	if size > 0 && count > maxSize/size {
+		return 0, false
+	}
+	if total > maxSize-count*size {
+		return 0, false
+	}
+	return total + count*size, true
+}
+
+// copySlices copies between p and the concatenation of the slices starting at
+// the offset. If load is true, p is copied into the slices, otherwise the
+// slices are copied into p.
+func copySlices(slices [][]byte, offset uint64, p []byte, load bool) {
+	for _, s := range slices {
+		if len(p) == 0 {
+			return
+		}
+		if offset >= uint64(len(s)) {
+			offset -= uint64(len(s))
+			continue
+		}
+
+		var n int
+		if load {
+			n = copy(s[offset:], p)
+		} else {
+			n = copy(p, s[offset:])
+		}
+		p = p[n:]
+		offset = 0
+	}
+}
@@ -0,0 +1,22 @@
+package boom
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Ensures that copySlices copies across slice boundaries in both directions.
+func TestCopySlices(t *testing.T) {
+	slices := [][]byte{{1, 2}, {}, {3, 4, 5}}
+
+	p := make([]byte, 3)
+	copySlices(slices, 1, p, false)
+	if !bytes.Equal(p, []byte{2, 3, 4}) {
+		t.Errorf("Expected [2 3 4], got %v", p)
+	}
+
+	copySlices(slices, 3, []byte{9, 9, 9}, true)
+	if !bytes.Equal(slices[2], []byte{3, 9, 9}) {
+		t.Errorf("Expected [3 9 9], got %v", slices[2])
+	}
+}
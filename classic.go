@@ -1,9 +1,15 @@
 package boom
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"hash"
-	"hash/fnv"
+	"io"
 	"math"
+	"math/rand"
+	"unsafe"
 )
 
 // BloomFilter implements a classic Bloom filter. A Bloom filter has a non-zero
@@ -14,18 +20,160 @@ type BloomFilter struct {
 	m       uint        // filter size
 	k       uint        // number of hash functions
 	count   uint        // number of items added
+	seed    uint64      // hash seed (zero means unseeded)
+	rand    *rand.Rand  // source of randomness for reseeding
+	scheme  hashScheme  // hashing scheme used to derive the k indices
+	chosen  bool        // scheme chosen by the constructor rather than by size
+	set     uint        // number of set bits at the last checkpoint
+	fpRate  float64     // target false-positive rate
+	indices []uint      // buffer used to cache indices of compatibility schemes
+	key     sipKey      // SipHash key of the keyed scheme
+
+	transformer Transformer // normalizes keys before they're hashed, if set
+	modulo      bool        // reduce hashes to indices with a modulo
+	powerOfTwo  bool        // round the bit array up to a power of two
 }
 
 // NewBloomFilter creates a new Bloom filter optimized to store n items with a
 // specified target false-positive rate.
-func NewBloomFilter(n uint, fpRate float64) *BloomFilter {
-	m := OptimalM(n, fpRate)
+func NewBloomFilter(n uint, fpRate float64, opts ...Option) *BloomFilter {
+	var (
+		o = applyOptions(opts)
+		m = o.size(OptimalM(n, fpRate))
+	)
 	return &BloomFilter{
 		buckets: NewBuckets(m, 1),
-		hash:    fnv.New64(),
+		hash:    o.newHash64(newFNV64),
 		m:       m,
 		k:       OptimalK(fpRate),
+		seed:    o.seed,
+		rand:    o.rand(),
+		scheme:  defaultScheme(m),
+		fpRate:  fpRate,
+
+		transformer: o.transformer,
+		modulo:      o.modulo,
+		powerOfTwo:  o.powerOfTwo,
+	}
+}
+
+// NewBloomFilterFromReader creates a new Bloom filter with a specified target
+// false-positive rate holding the keys read from the stream, delimited by
+// delim, such as '\n' for a file of newline-delimited keys. Empty keys are
+// skipped. If the stream is an io.Seeker, such as an *os.File, the keys are
+// counted in a first pass so the filter is optimized to store them. Otherwise
+// it's optimized for the hint set with WithHint, or 10000 items. Returns an
+// error if reading the stream fails.
+func NewBloomFilterFromReader(stream io.Reader, delim byte, fpRate float64, opts ...Option) (*BloomFilter, error) {
+	n, err := streamHint(stream, delim, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	b := NewBloomFilter(n, fpRate, opts...)
+	if err := readKeys(stream, delim, func(key []byte) error {
+		b.Add(key)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// NewBloomFilterWithOptions creates a new Bloom filter configured entirely by
+// options, such as WithHint and WithFPRate, so new settings can be added
+// without changing its signature. If a factory is set with WithBucketsBackend,
+// it creates the bit array like NewBloomFilterWithBuckets. Returns an error if
+// a setting is out of range or the factory fails.
+func NewBloomFilterWithOptions(opts ...Option) (*BloomFilter, error) {
+	o := applyOptions(opts)
+	n, fpRate, err := o.sizing()
+	if err != nil {
+		return nil, err
 	}
+
+	if o.buckets != nil {
+		return NewBloomFilterWithBuckets(n, fpRate, o.buckets, opts...)
+	}
+	return NewBloomFilter(n, fpRate, opts...), nil
+}
+
+// NewBloomFilter128 creates a new Bloom filter optimized to store n items with
+// a specified target false-positive rate which derives its k indices from the
+// two 64-bit halves of a 128-bit MurmurHash3 rather than from a 64-bit hash.
+// This maintains a good distribution of indices for very large filters, where
+// m exceeds 2^32, at which point the 64-bit scheme can only address a fraction
+// of the bits. NewBloomFilter already uses 128-bit hashing for such filters.
+// SetHash has no effect on a filter using 128-bit hashing.
+func NewBloomFilter128(n uint, fpRate float64, opts ...Option) *BloomFilter {
+	b := NewBloomFilter(n, fpRate, opts...)
+	b.scheme = schemeMurmur128
+	b.chosen = true
+	return b
+}
+
+// NewBloomFilterXXHash creates a new Bloom filter optimized to store n items
+// with a specified target false-positive rate which derives its k indices from
+// the 64-bit xxHash of the data. xxHash is computed by a pure function rather
+// than through the hash.Hash64 interface and consumes eight bytes at a time, so
+// Add and Test don't allocate and are faster than with the default hash
+// function on long keys. Filters where m exceeds 2^32 use 128-bit hashing
+// instead, like NewBloomFilter. SetHash has no effect on a filter using xxHash.
+func NewBloomFilterXXHash(n uint, fpRate float64, opts ...Option) *BloomFilter {
+	b := NewBloomFilter(n, fpRate, opts...)
+	if b.scheme == schemeFNV {
+		b.scheme = schemeXXHash
+	}
+	return b
+}
+
+// NewKeyedBloomFilter creates a new Bloom filter optimized to store n items
+// with a specified target false-positive rate which derives its k indices from
+// the SipHash-2-4 of the data under a random 128-bit key. Since the indices
+// can't be predicted without the key, an attacker can't craft data which
+// produces false positives, making the filter suitable for untrusted input.
+// The key is included in the output of WriteTo, which must then be kept as
+// secret as the key itself. SetHash has no effect on a keyed filter.
+func NewKeyedBloomFilter(n uint, fpRate float64, opts ...Option) *BloomFilter {
+	b := NewBloomFilter(n, fpRate, opts...)
+	b.scheme = schemeSipHash
+	b.chosen = true
+	b.key = applyOptions(opts).sipKey()
+	return b
+}
+
+// NewBloomFilterWithBuckets creates a new Bloom filter like NewBloomFilter
+// whose bit array is created by the factory, such as one returned by
+// MmapBucketsFactory. If the factory reopens existing data, the number of items
+// added is estimated from the set bits. Returns an error if the factory fails.
+func NewBloomFilterWithBuckets(n uint, fpRate float64, newBuckets BucketsFactory, opts ...Option) (*BloomFilter, error) {
+	var (
+		o = applyOptions(opts)
+		m = o.size(OptimalM(n, fpRate))
+	)
+	buckets, err := newFactoryBuckets(newBuckets, 0, m, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &BloomFilter{
+		buckets: buckets,
+		hash:    o.newHash64(newFNV64),
+		m:       m,
+		k:       OptimalK(fpRate),
+		seed:    o.seed,
+		rand:    o.rand(),
+		scheme:  defaultScheme(m),
+		fpRate:  fpRate,
+
+		transformer: o.transformer,
+		modulo:      o.modulo,
+		powerOfTwo:  o.powerOfTwo,
+	}
+	if buckets.reopened() {
+		b.count = uint(estimateCardinality(b.m, b.k, buckets.ones()) + 0.5)
+	}
+	return b, nil
 }
 
 // Capacity returns the Bloom filter capacity, m.
@@ -50,11 +198,102 @@ func (b *BloomFilter) EstimatedFillRatio() float64 {
 
 // FillRatio returns the ratio of set bits.
 func (b *BloomFilter) FillRatio() float64 {
-	sum := uint32(0)
-	for i := uint(0); i < b.buckets.Count(); i++ {
-		sum += b.buckets.Get(i)
+	return float64(b.popCount()) / float64(b.m)
+}
+
+// EstimatedFPRate returns the current probability of a false positive derived
+// from the ratio of set bits, the probability that all k bits of an absent
+// item are set. It exceeds the target rate once the filter holds more items
+// than it was optimized for.
+func (b *BloomFilter) EstimatedFPRate() float64 {
+	return math.Pow(b.FillRatio(), float64(b.k))
+}
+
+// BitsPerElement returns the number of bits used per distinct element, which
+// is the capacity divided by the number of distinct elements estimated from the
+// set bits. For a correctly sized filter holding its designed number of items,
+// this is close to 1.44 * log2(1/fpRate). Returns +Inf if the filter is
+// empty.
+func (b *BloomFilter) BitsPerElement() float64 {
+	return float64(b.m) / estimateCardinality(b.m, b.k, b.popCount())
+}
+
+// ApproximatedSize returns the number of distinct items added to the filter
+// estimated from the number of set bits, X, as -m/k * ln(1 - X/m). Unlike
+// Count, it doesn't count repeated additions and remains accurate for filters
+// which were merged or restored. It's unbounded once every bit is set.
+func (b *BloomFilter) ApproximatedSize() uint {
+	return uint(estimateCardinality(b.m, b.k, b.popCount()) + 0.5)
+}
+
+// Checkpoint records the number of set bits so that NewSinceCheckpoint can
+// estimate the number of distinct items added after this point. It returns
+// the filter to allow for chaining.
+func (b *BloomFilter) Checkpoint() *BloomFilter {
+	b.set = b.popCount()
+	return b
+}
+
+// NewSinceCheckpoint returns the estimated number of new distinct items added
+// to the filter since the last call to Checkpoint, or since the filter was
+// created or reset if Checkpoint hasn't been called. The estimate is the
+// difference between the cardinalities derived from the current and
+// checkpointed number of set bits, so items which were already members don't
+// count.
+func (b *BloomFilter) NewSinceCheckpoint() uint {
+	diff := estimateCardinality(b.m, b.k, b.popCount()) - estimateCardinality(b.m, b.k, b.set)
+	if diff <= 0 {
+		return 0
 	}
-	return float64(sum) / float64(b.m)
+	return uint(diff + 0.5)
+}
+
+// FalsePositiveComparison returns the theoretical false-positive rate for the
+// number of items added to the filter alongside the observed rate of false
+// positives over the provided probes, which must not have been added to the
+// filter. The observed rate is zero if there are no probes.
+func (b *BloomFilter) FalsePositiveComparison(probes [][]byte) (float64, float64) {
+	theoretical := math.Pow(b.EstimatedFillRatio(), float64(b.k))
+	if len(probes) == 0 {
+		return theoretical, 0
+	}
+
+	positives := 0
+	for _, probe := range probes {
+		if b.Test(probe) {
+			positives++
+		}
+	}
+
+	return theoretical, float64(positives) / float64(len(probes))
+}
+
+// AssertFPRate measures the observed false-positive rate of the filter over the
+// absent items, which must not have been added to the filter, and returns an
+// error if it exceeds the target false-positive rate the filter was created
+// with by more than the relative tolerance, i.e. if it's greater than
+// fpRate * (1 + tolerance). This is intended for accuracy regression tests.
+// Returns an error if the target rate isn't known, as is the case for a filter
+// that was only read with ReadFrom, or if there are no absent items.
+func AssertFPRate(f *BloomFilter, absent [][]byte, tolerance float64) error {
+	if f.fpRate == 0 {
+		return errors.New("target false-positive rate is unknown")
+	}
+
+	if len(absent) == 0 {
+		return errors.New("no absent items to measure")
+	}
+
+	var (
+		_, observed = f.FalsePositiveComparison(absent)
+		limit       = f.fpRate * (1 + tolerance)
+	)
+	if observed > limit {
+		return fmt.Errorf("observed false-positive rate %f exceeds %f (target %f)",
+			observed, limit, f.fpRate)
+	}
+
+	return nil
 }
 
 // Test will test for membership of the data and returns true if it is a
@@ -62,41 +301,94 @@ func (b *BloomFilter) FillRatio() float64 {
 // non-zero probability of false positives but a zero probability of false
 // negatives.
 func (b *BloomFilter) Test(data []byte) bool {
-	lower, upper := hashKernel(data, b.hash)
-
-	// If any of the K bits are not set, then it's not a member.
-	for i := uint(0); i < b.k; i++ {
-		if b.buckets.Get((uint(lower)+uint(upper)*i)%b.m) == 0 {
-			return false
+	if b.scheme.compat() {
+		for _, idx := range b.compatIndices(data) {
+			if b.buckets.Get(idx) == 0 {
+				return false
+			}
 		}
+		return true
 	}
 
-	return true
+	lower, upper := b.hashKernel(data)
+	return b.test(lower, upper)
 }
 
 // Add will add the data to the Bloom filter. It returns the filter to allow
 // for chaining.
 func (b *BloomFilter) Add(data []byte) Filter {
-	lower, upper := hashKernel(data, b.hash)
+	if b.scheme.compat() {
+		for _, idx := range b.compatIndices(data) {
+			b.buckets.Set(idx, 1)
+		}
+		b.count++
+		return b
+	}
 
-	// Set the K bits.
-	for i := uint(0); i < b.k; i++ {
-		b.buckets.Set((uint(lower)+uint(upper)*i)%b.m, 1)
+	lower, upper := b.hashKernel(data)
+	b.add(lower, upper)
+	return b
+}
+
+// TestHashed is like Test but takes the 64-bit hash of the data rather than
+// the data itself, avoiding the cost of hashing. The hash bypasses the
+// filter's hash function, seed, and hashing scheme. For an unseeded filter
+// using the default hash function, the hash of the data is its 64-bit FNV-1
+// sum, which makes the hashed and byte-slice methods interchangeable.
+// Otherwise, a filter should only be used with one or the other.
+func (b *BloomFilter) TestHashed(hash uint64) bool {
+	return b.test(hash&0xffffffff, hash>>32)
+}
+
+// AddHashed is like Add but takes the 64-bit hash of the data rather than the
+// data itself, avoiding the cost of hashing. See TestHashed for how the hash
+// relates to the data. It returns the filter to allow for chaining.
+func (b *BloomFilter) AddHashed(hash uint64) *BloomFilter {
+	b.add(hash&0xffffffff, hash>>32)
+	return b
+}
+
+// TestHashedAll returns true if every one of the 64-bit hashes tests as a
+// member, false if not. See TestHashed for how the hashes relate to the data.
+func (b *BloomFilter) TestHashedAll(hashes []uint64) bool {
+	for _, hash := range hashes {
+		if !b.test(hash&0xffffffff, hash>>32) {
+			return false
+		}
 	}
+	return true
+}
 
-	b.count++
+// AddHashedAll adds each of the 64-bit hashes to the filter. See TestHashed
+// for how the hashes relate to the data. It returns the filter to allow for
+// chaining.
+func (b *BloomFilter) AddHashedAll(hashes []uint64) *BloomFilter {
+	for _, hash := range hashes {
+		b.add(hash&0xffffffff, hash>>32)
+	}
 	return b
 }
 
 // TestAndAdd is equivalent to calling Test followed by Add. It returns true if
 // the data is a member, false if not.
 func (b *BloomFilter) TestAndAdd(data []byte) bool {
-	lower, upper := hashKernel(data, b.hash)
 	member := true
+	if b.scheme.compat() {
+		for _, idx := range b.compatIndices(data) {
+			if b.buckets.Get(idx) == 0 {
+				member = false
+			}
+			b.buckets.Set(idx, 1)
+		}
+		b.count++
+		return member
+	}
+
+	lower, upper := b.hashKernel(data)
 
 	// If any of the K bits are not set, then it's not a member.
 	for i := uint(0); i < b.k; i++ {
-		idx := (uint(lower) + uint(upper)*i) % b.m
+		idx := b.index(lower, upper, i)
 		if b.buckets.Get(idx) == 0 {
 			member = false
 		}
@@ -107,15 +399,786 @@ func (b *BloomFilter) TestAndAdd(data []byte) bool {
 	return member
 }
 
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (b *BloomFilter) TestString(data string) bool {
+	return b.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (b *BloomFilter) AddString(data string) Filter {
+	return b.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (b *BloomFilter) TestAndAddString(data string) bool {
+	return b.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter. The base hash values of a
+// block of items are computed before any of their bits are set, so the memory
+// accesses of consecutive items overlap. It returns the filter to allow for
+// chaining.
+func (b *BloomFilter) AddMany(data [][]byte) Filter {
+	if b.scheme.compat() {
+		for _, d := range data {
+			b.Add(d)
+		}
+		return b
+	}
+
+	var lower, upper [batchSize]uint64
+	for len(data) > 0 {
+		n := len(data)
+		if n > batchSize {
+			n = batchSize
+		}
+		for i, d := range data[:n] {
+			lower[i], upper[i] = b.hashKernel(d)
+		}
+		for i := 0; i < n; i++ {
+			b.add(lower[i], upper[i])
+		}
+		data = data[n:]
+	}
+	return b
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+// Like AddMany, the base hash values of a block of items are computed before
+// any of their bits are tested.
+func (b *BloomFilter) TestMany(data [][]byte) []bool {
+	if b.scheme.compat() {
+		return testMany(data, b.Test)
+	}
+
+	var (
+		members      = make([]bool, len(data))
+		lower, upper [batchSize]uint64
+	)
+	for offset := 0; offset < len(data); offset += batchSize {
+		block := data[offset:]
+		if len(block) > batchSize {
+			block = block[:batchSize]
+		}
+		for i, d := range block {
+			lower[i], upper[i] = b.hashKernel(d)
+		}
+		for i := range block {
+			members[offset+i] = b.test(lower[i], upper[i])
+		}
+	}
+	return members
+}
+
+// AddAllContext adds each item of data to the filter, checking the context for
+// cancellation periodically. It returns the number of items added, which are
+// the first ones in data, and the context's error if it was cancelled before
+// every item was added.
+func (b *BloomFilter) AddAllContext(ctx context.Context, data [][]byte) (int, error) {
+	return addAllContext(ctx, data, func(d []byte) error {
+		b.Add(d)
+		return nil
+	})
+}
+
 // Reset restores the Bloom filter to its original state. It returns the filter
 // to allow for chaining.
 func (b *BloomFilter) Reset() *BloomFilter {
 	b.buckets.Reset()
+	b.set = 0
 	return b
 }
 
+// Sync flushes the filter's data to the file backing its buckets, if they're
+// memory-mapped.
+func (b *BloomFilter) Sync() error {
+	return b.buckets.Sync()
+}
+
+// Close releases the storage backing the filter's buckets, such as a
+// memory-mapped file. The filter must not be used afterwards.
+func (b *BloomFilter) Close() error {
+	return b.buckets.Close()
+}
+
+// ResetReseed restores the Bloom filter to its original state and picks a new
+// hash seed, invalidating any set of colliding keys learned against the
+// previous seed. The seed is generated using the source provided to SetRand,
+// or the default source if none was set. A keyed filter also picks a new
+// random key. It returns the filter to allow for chaining.
+func (b *BloomFilter) ResetReseed() *BloomFilter {
+	b.Reset()
+	old := b.seed
+	for b.seed == 0 || b.seed == old {
+		if b.rand != nil {
+			b.seed = uint64(b.rand.Int63())
+		} else {
+			b.seed = uint64(rand.Int63())
+		}
+	}
+	if b.scheme == schemeSipHash {
+		b.key = newSipKey()
+	}
+	return b
+}
+
+// Seed returns the hash seed. A seed of zero means the filter is unseeded.
+func (b *BloomFilter) Seed() uint64 {
+	return b.seed
+}
+
+// Key returns the SipHash key of a filter created with NewKeyedBloomFilter.
+func (b *BloomFilter) Key() [16]byte {
+	return b.key
+}
+
+// SetKey sets the SipHash key of a filter created with NewKeyedBloomFilter,
+// such as to restore a key stored separately from the filter. Items added
+// under a different key are no longer members.
+func (b *BloomFilter) SetKey(key [16]byte) {
+	b.key = key
+}
+
+// SetRand sets the source of randomness used to generate hash seeds.
+func (b *BloomFilter) SetRand(r *rand.Rand) {
+	b.rand = r
+}
+
+// WriteTo writes a binary representation of the BloomFilter to an I/O stream.
+// The hash function is not written, but the key of a keyed filter and the name
+// of the Transformer set with WithTransformer are. It returns the number of
+// bytes written.
+func (b *BloomFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(b.m))
+	e.write(uint64(b.k))
+	e.write(uint64(b.count))
+	e.write(b.seed)
+	e.write(uint8(b.scheme))
+	e.writeTo(b.buckets)
+	if b.scheme == schemeSipHash {
+		e.write(b.key)
+	}
+	e.writeBytes([]byte(transformerName(b.transformer)))
+	writeReduction(&e, b.modulo)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a BloomFilter (such as might have
+// been written by WriteTo()) from an I/O stream. The filter keeps its current
+// hash function, which must match the one used by the filter that was
+// written. Data written by a newer, compatible version of the package is read
+// with best effort, ignoring any fields this version doesn't know about, while
+// ErrUnsupportedVersion is returned for incompatible versions.
+// ErrTransformerMismatch is returned if the filter was written with a
+// different Transformer than the one it was created with. It returns the
+// number of bytes read.
+func (b *BloomFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                 = decoder{r: payload}
+		m, k, count, seed uint64
+		scheme            uint8
+		buckets           = &Buckets{}
+		key               sipKey
+	)
+	d.read(&m)
+	d.read(&k)
+	d.read(&count)
+	d.read(&seed)
+	d.read(&scheme)
+	d.readFrom(buckets)
+	if hashScheme(scheme) == schemeSipHash {
+		d.read(&key)
+	}
+	if err := readTransformerName(&d, payload, b.transformer); err != nil {
+		return n, err
+	}
+	modulo, err := readReduction(&d, payload)
+	if err != nil {
+		return n, err
+	}
+
+	if buckets.Count() != uint(m) || buckets.bucketSize != 1 || k == 0 || k > m {
+		return n, errors.New("buckets don't match filter size")
+	}
+
+	if hashScheme(scheme) > schemeSipHash {
+		return n, errors.New("unknown hashing scheme")
+	}
+
+	b.buckets = buckets
+	b.m = uint(m)
+	b.k = uint(k)
+	b.count = uint(count)
+	b.seed = seed
+	b.scheme = hashScheme(scheme)
+	b.key = key
+	b.modulo = modulo
+	return n, nil
+}
+
+// test returns true if all of the K bits derived from the base hash values are
+// set.
+func (b *BloomFilter) test(lower, upper uint64) bool {
+	// If any of the K bits are not set, then it's not a member.
+	for i := uint(0); i < b.k; i++ {
+		if b.buckets.Get(b.index(lower, upper, i)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// add sets the K bits derived from the base hash values.
+func (b *BloomFilter) add(lower, upper uint64) {
+	for i := uint(0); i < b.k; i++ {
+		b.buckets.Set(b.index(lower, upper, i), 1)
+	}
+
+	b.count++
+}
+
+// popCount returns the number of set bits.
+func (b *BloomFilter) popCount() uint {
+	return b.buckets.ones()
+}
+
+// hashScheme identifies how a BloomFilter derives the k indices of the data.
+type hashScheme uint8
+
+const (
+	// schemeFNV derives the indices from the two 32-bit halves of the
+	// (seeded) 64-bit hash function.
+	schemeFNV hashScheme = iota
+
+	// schemeMurmur128 derives the indices from the two 64-bit halves of a
+	// 128-bit MurmurHash3.
+	schemeMurmur128
+
+	// schemeBitsAndBlooms derives the indices like the bits-and-blooms/bloom
+	// package. This and the Guava schemes are compatibility schemes whose
+	// indices are computed by compatIndices.
+	schemeBitsAndBlooms
+
+	// schemeGuava32 derives the indices like Guava's MURMUR128_MITZ_32
+	// strategy.
+	schemeGuava32
+
+	// schemeGuava64 derives the indices like Guava's MURMUR128_MITZ_64
+	// strategy.
+	schemeGuava64
+
+	// schemeXXHash derives the indices from the two 32-bit halves of the
+	// seeded 64-bit xxHash.
+	schemeXXHash
+
+	// schemeSipHash derives the indices from the two 32-bit halves of the
+	// keyed SipHash-2-4.
+	schemeSipHash
+)
+
+// defaultScheme returns the hashing scheme of a filter of m bits, which is
+// 128-bit hashing once m exceeds 2^32 since the 32-bit halves of a 64-bit hash
+// can't address every bit.
+func defaultScheme(m uint) hashScheme {
+	if uint64(m) > 1<<32 {
+		return schemeMurmur128
+	}
+	return schemeFNV
+}
+
+// compat returns true for the compatibility schemes, whose indices are
+// computed by compatIndices.
+func (s hashScheme) compat() bool {
+	return s >= schemeBitsAndBlooms && s <= schemeGuava64
+}
+
+// compatIndices returns the k indices of the data for a filter using one of
+// the compatibility schemes. The returned slice is reused by the next call.
+func (b *BloomFilter) compatIndices(data []byte) []uint {
+	if uint(len(b.indices)) != b.k {
+		b.indices = make([]uint, b.k)
+	}
+
+	data = transformKey(b.transformer, data)
+	switch b.scheme {
+	case schemeBitsAndBlooms:
+		bitsAndBloomsIndices(data, b.m, b.indices)
+	case schemeGuava32:
+		guava32Indices(data, b.m, b.indices)
+	case schemeGuava64:
+		guava64Indices(data, b.m, b.indices)
+	}
+	return b.indices
+}
+
+// hashKernel returns the base hash values from which the k indices are
+// derived. These are 32-bit values unless the filter uses 128-bit hashing.
+func (b *BloomFilter) hashKernel(data []byte) (uint64, uint64) {
+	data = transformKey(b.transformer, data)
+	switch b.scheme {
+	case schemeMurmur128:
+		return murmur3Sum128(data, b.seed)
+	case schemeXXHash:
+		hash := xxhash64(data, b.seed)
+		return hash & 0xffffffff, hash >> 32
+	case schemeSipHash:
+		hash := siphash24(b.key, data)
+		return hash & 0xffffffff, hash >> 32
+	}
+	lower, upper := seededHashKernel(data, b.hash, b.seed)
+	return uint64(lower), uint64(upper)
+}
+
+// index returns the i-th bit index derived from the base hash values. The
+// reduction is a multiply-shift unless the filter was created
+// WithModuloReduction, in which case it's a bitmask when m is a power of two,
+// which gives the same index as the modulo without a division.
+func (b *BloomFilter) index(lower, upper uint64, i uint) uint {
+	if !b.modulo {
+		if b.scheme == schemeMurmur128 {
+			return fastRange(lower+upper*uint64(i), b.m)
+		}
+		return fastRange32(uint32(lower)+uint32(upper)*uint32(i), b.m)
+	}
+	if b.scheme == schemeMurmur128 {
+		if b.m&(b.m-1) == 0 {
+			return uint(lower+upper*uint64(i)) & (b.m - 1)
+		}
+		return uint((lower + upper*uint64(i)) % uint64(b.m))
+	}
+	if b.m&(b.m-1) == 0 {
+		return (uint(lower) + uint(upper)*i) & (b.m - 1)
+	}
+	return (uint(lower) + uint(upper)*i) % b.m
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (b *BloomFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(b)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo. If the filter
+// has no hash function, such as when it's the zero value, the default hash
+// function is used.
+func (b *BloomFilter) UnmarshalBinary(data []byte) error {
+	if b.hash == nil {
+		b.hash = newFNV64()
+	}
+	return unmarshalBinary(b, data)
+}
+
 // SetHash sets the hashing function used in the filter.
 // For the effect on false positive rates see: https://github.com/tylertreat/BoomFilters/pull/1
 func (b *BloomFilter) SetHash(h hash.Hash64) {
 	b.hash = h
 }
+
+// EstimateSymmetricDifference returns the approximate cardinality of the
+// symmetric difference of the sets represented by the two Bloom filters. The
+// number of set bits in the union is derived from the XOR of the two bit
+// arrays and mapped back to a cardinality, from which the estimated sizes of
+// the individual sets are subtracted. The filters must have the same capacity
+// and number of hash functions and use the same seed, hashing scheme, and
+// key. NaN is returned if they are incompatible.
+func EstimateSymmetricDifference(a, b *BloomFilter) float64 {
+	if !a.compatible(b) {
+		return math.NaN()
+	}
+
+	var setA, setB, xor uint
+	for i := uint(0); i < a.m; i++ {
+		x, y := a.buckets.Get(i), b.buckets.Get(i)
+		setA += uint(x)
+		setB += uint(y)
+		xor += uint(x ^ y)
+	}
+
+	// |a| + |b| = |a OR b| + |a AND b| and |a XOR b| = |a OR b| - |a AND b|.
+	union := (setA + setB + xor) / 2
+	diff := 2*estimateCardinality(a.m, a.k, union) -
+		estimateCardinality(a.m, a.k, setA) - estimateCardinality(a.m, a.k, setB)
+	return math.Max(diff, 0)
+}
+
+// EstimateIntersection returns the approximate cardinality of the
+// intersection of the sets represented by the two Bloom filters using
+// inclusion-exclusion: the estimated sizes of the individual sets minus the
+// estimated size of their union, which is derived from the OR of the two bit
+// arrays. The filters must have the same capacity and number of hash functions
+// and use the same seed, hashing scheme, and key. NaN is returned if they are
+// incompatible.
+func EstimateIntersection(a, b *BloomFilter) float64 {
+	if !a.compatible(b) {
+		return math.NaN()
+	}
+
+	var setA, setB, union uint
+	for i := uint(0); i < a.m; i++ {
+		x, y := a.buckets.Get(i), b.buckets.Get(i)
+		setA += uint(x)
+		setB += uint(y)
+		union += uint(x | y)
+	}
+
+	intersection := estimateCardinality(a.m, a.k, setA) +
+		estimateCardinality(a.m, a.k, setB) - estimateCardinality(a.m, a.k, union)
+	return math.Max(intersection, 0)
+}
+
+// EstimateUnion returns the approximate cardinality of the union of the sets
+// represented by the two Bloom filters, derived from the OR of the two bit
+// arrays. The filters must have the same capacity and number of hash functions
+// and use the same seed, hashing scheme, and key. NaN is returned if they are
+// incompatible.
+func EstimateUnion(a, b *BloomFilter) float64 {
+	if !a.compatible(b) {
+		return math.NaN()
+	}
+
+	var union uint
+	for i := uint(0); i < a.m; i++ {
+		union += uint(a.buckets.Get(i) | b.buckets.Get(i))
+	}
+
+	return estimateCardinality(a.m, a.k, union)
+}
+
+// EstimateDifference returns the approximate cardinality of the difference of
+// the sets represented by the two Bloom filters, the items of a which aren't
+// in b: the estimated size of their union minus the estimated size of b. The
+// filters must have the same capacity and number of hash functions and use the
+// same seed, hashing scheme, and key. NaN is returned if they are
+// incompatible.
+func EstimateDifference(a, b *BloomFilter) float64 {
+	if !a.compatible(b) {
+		return math.NaN()
+	}
+
+	var setB, union uint
+	for i := uint(0); i < a.m; i++ {
+		x, y := a.buckets.Get(i), b.buckets.Get(i)
+		setB += uint(y)
+		union += uint(x | y)
+	}
+
+	diff := estimateCardinality(a.m, a.k, union) - estimateCardinality(a.m, a.k, setB)
+	return math.Max(diff, 0)
+}
+
+// OverlapEstimate is the estimated overlap of the sets represented by two
+// Bloom filters, with the standard error of each estimate.
+type OverlapEstimate struct {
+	Union              float64 // cardinality of the union
+	Intersection       float64 // cardinality of the intersection
+	Jaccard            float64 // intersection divided by union
+	UnionStdErr        float64 // standard error of Union
+	IntersectionStdErr float64 // standard error of Intersection
+	JaccardStdErr      float64 // standard error of Jaccard
+}
+
+// EstimateOverlap returns the approximate cardinalities of the union and
+// intersection of the sets represented by the two Bloom filters and their
+// Jaccard similarity, like EstimateUnion and EstimateIntersection, so services
+// can compare the sets they've seen by exchanging filters only. The standard
+// errors are propagated from the variances and covariances of the numbers of
+// bits unset in each filter and in their union. They grow quickly as the
+// filters fill up, and are infinite if every bit of the union is set. The
+// filters must have the same capacity and number of hash functions and use the
+// same seed, hashing scheme, and key. Returns an error if they are
+// incompatible.
+func EstimateOverlap(a, b *BloomFilter) (OverlapEstimate, error) {
+	if !a.compatible(b) {
+		return OverlapEstimate{}, errors.New("filter capacity, number of hash functions, and hash seed, scheme, and key must match")
+	}
+
+	var setA, setB, union uint
+	for i := uint(0); i < a.m; i++ {
+		x, y := a.buckets.Get(i), b.buckets.Get(i)
+		setA += uint(x)
+		setB += uint(y)
+		union += uint(x | y)
+	}
+
+	var (
+		nA, nB, nU = estimateCardinality(a.m, a.k, setA), estimateCardinality(a.m, a.k, setB),
+			estimateCardinality(a.m, a.k, union)
+		m, k = float64(a.m), float64(a.k)
+
+		// Fractions of the bits unset in a, b, and their union, and the
+		// expected number of bits set per bit by the union and intersection.
+		pA, pB, pU = 1 - float64(setA)/m, 1 - float64(setB)/m, 1 - float64(union)/m
+		cU, cI     = -math.Log(pU), math.Log(pU) - math.Log(pA) - math.Log(pB)
+		scale      = m / (k * k)
+
+		varU  = scale * (1/pU - 1 - cU)
+		varI  = scale * (1/pU - 1/pA - 1/pB + 2*pU/(pA*pB) - 1 - cI)
+		covIU = scale * (1/pA + 1/pB - 1/pU - 1 - cI)
+	)
+	estimate := OverlapEstimate{
+		Union:              nU,
+		Intersection:       math.Max(nA+nB-nU, 0),
+		UnionStdErr:        math.Sqrt(varU),
+		IntersectionStdErr: math.Sqrt(math.Max(varI, 0)),
+	}
+	if nU > 0 {
+		i := estimate.Intersection
+		estimate.Jaccard = math.Min(i/nU, 1)
+		varJ := varI/(nU*nU) + i*i*varU/(nU*nU*nU*nU) - 2*i*covIU/(nU*nU*nU)
+		estimate.JaccardStdErr = math.Sqrt(math.Max(varJ, 0))
+	}
+	if pU == 0 {
+		estimate.UnionStdErr = math.Inf(1)
+		estimate.IntersectionStdErr = math.Inf(1)
+		estimate.JaccardStdErr = math.Inf(1)
+	}
+	return estimate, nil
+}
+
+// Intersect clears every bit of this Bloom filter which isn't set in the other
+// one, such that this filter contains the intersection of the two sets. Items
+// which were only in one of the sets may still test positive if all of their
+// bits were set by items of the other, so the false-positive rate is higher
+// than that of a filter to which only the intersection had been added. The
+// count is replaced by the number of items estimated from the remaining set
+// bits. The filters must have the same capacity and number of hash functions
+// and use the same seed, hashing scheme, and key. Returns an error if they are
+// incompatible.
+func (b *BloomFilter) Intersect(other *BloomFilter) error {
+	if !b.compatible(other) {
+		return errors.New("filter capacity, number of hash functions, and hash seed, scheme, and key must match")
+	}
+
+	b.buckets.intersect(other.buckets)
+	b.count = uint(estimateCardinality(b.m, b.k, b.popCount()) + 0.5)
+	return nil
+}
+
+// Equal returns true if the other Bloom filter has the same parameters, hash
+// seed, scheme, and key, number of items added, and bits. The hash functions
+// aren't compared.
+func (b *BloomFilter) Equal(other *BloomFilter) bool {
+	return b.compatible(other) && b.count == other.count && b.buckets.Equal(other.buckets)
+}
+
+// Clone returns an independent copy of the Bloom filter, which can be used
+// concurrently with the original, such as to serve a snapshot while the
+// original keeps accepting writes. The copy's bits are held in memory. Its
+// source of randomness for reseeding is seeded from the original's.
+func (b *BloomFilter) Clone() *BloomFilter {
+	c := *b
+	c.buckets = b.buckets.Clone()
+	c.hash = cloneHash64(b.hash)
+	c.rand = cloneRand(b.rand)
+	c.indices = nil
+	return &c
+}
+
+// Rebuild replaces the filter with one optimized to store the keys yielded by
+// the iterator at the target false-positive rate, such as once more items were
+// added than the filter was sized for and its false-positive rate degraded.
+// Membership can't be recovered from the bits, so the keys are re-fed from the
+// iterator, which must yield every key that should remain a member. It's called
+// twice, first to count the keys and then to add them. The filter keeps its
+// hash function, seed, hashing scheme, key, and sizing set with
+// WithPowerOfTwoSize, except that the default scheme switches between FNV and
+// 128-bit hashing as the new size crosses 2^32. Schemes chosen by a
+// constructor, such as NewBloomFilter128, are kept. The replacement is built
+// before being swapped in, so the filter is unchanged if an error is returned,
+// which happens if keys is nil, the rate isn't between 0 and 1, the iterator
+// fails, or the filter's buckets are memory-mapped or kept by a BucketStore. A
+// filter wrapped in a SafeFilter is rebuilt through Do.
+func (b *BloomFilter) Rebuild(fpRate float64, keys Iterator) error {
+	if keys == nil {
+		return errors.New("the keys are required to rebuild the filter")
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		return errors.New("false-positive rate must be in (0, 1)")
+	}
+	if b.buckets.mmap != nil || b.buckets.store != nil {
+		return errors.New("filters with memory-mapped or stored buckets can't be rebuilt")
+	}
+
+	n := uint(0)
+	if err := keys(func([]byte) error {
+		n++
+		return nil
+	}); err != nil {
+		return err
+	}
+	if n == 0 {
+		n = 1
+	}
+
+	var (
+		m       = options{powerOfTwo: b.powerOfTwo}.size(OptimalM(n, fpRate))
+		rebuilt = *b
+	)
+	rebuilt.buckets = NewBuckets(m, 1)
+	rebuilt.m = m
+	rebuilt.k = OptimalK(fpRate)
+	rebuilt.count = 0
+	rebuilt.set = 0
+	rebuilt.fpRate = fpRate
+	rebuilt.indices = nil
+	if !b.chosen && b.scheme == defaultScheme(b.m) {
+		rebuilt.scheme = defaultScheme(m)
+	}
+	if err := keys(func(key []byte) error {
+		rebuilt.Add(key)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	*b = rebuilt
+	return nil
+}
+
+// ByteSize returns the number of bytes used by the Bloom filter's bit array and
+// metadata. The hash function and source of randomness aren't included.
+func (b *BloomFilter) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*b)) + b.buckets.ByteSize() + uint64(cap(b.indices))*uintSize
+}
+
+// compatible returns true if the other Bloom filter has the same capacity and
+// number of hash functions and uses the same seed, hashing scheme, key,
+// Transformer, and reduction of hashes to indices, so that the same items set
+// the same bits in both.
+func (b *BloomFilter) compatible(other *BloomFilter) bool {
+	return b.m == other.m && b.k == other.k && b.seed == other.seed &&
+		b.scheme == other.scheme && b.key == other.key &&
+		transformerName(b.transformer) == transformerName(other.transformer) &&
+		b.modulo == other.modulo
+}
+
+// Union merges the other Bloom filter into this one, such that this filter
+// contains the union of the two sets. The filters must have the same capacity
+// and number of hash functions and use the same seed, hashing scheme, and key.
+// Returns an error if they are incompatible.
+func (b *BloomFilter) Union(other *BloomFilter) error {
+	if b.m != other.m {
+		return errors.New("filter capacity must match")
+	}
+
+	if b.k != other.k {
+		return errors.New("number of hash functions must match")
+	}
+
+	if b.seed != other.seed || b.scheme != other.scheme || b.key != other.key {
+		return errors.New("hash seed, scheme, and key must match")
+	}
+
+	if transformerName(b.transformer) != transformerName(other.transformer) {
+		return errors.New("transformers must match")
+	}
+
+	if b.modulo != other.modulo {
+		return errors.New("index reduction must match")
+	}
+
+	b.buckets.union(other.buckets)
+	b.count += other.count
+	return nil
+}
+
+// StreamUnion reads serialized Bloom filters (such as might have been written
+// by WriteTo()) from the channel of readers until it's closed and returns the
+// union of all of them. Only one decoded filter is held in memory alongside
+// the result at a time. The filters must be compatible with the first one
+// read and must use the default hash function. Returns an error if a filter
+// can't be read or is incompatible, or if the channel contains no readers.
+// On error the rest of the channel is still drained without being read, so
+// the producers sending to it don't block, and StreamUnion returns once it's
+// closed.
+func StreamUnion(readers <-chan io.Reader) (*BloomFilter, error) {
+	union, err := streamUnion(readers)
+	if err != nil {
+		for range readers {
+		}
+		return nil, err
+	}
+	return union, nil
+}
+
+// streamUnion returns the union of the filters read from the channel, stopping
+// at the first error.
+func streamUnion(readers <-chan io.Reader) (*BloomFilter, error) {
+	var union *BloomFilter
+	for r := range readers {
+		f := &BloomFilter{hash: newFNV64()}
+		if _, err := f.ReadFrom(r); err != nil {
+			return nil, err
+		}
+
+		if union == nil {
+			union = f
+			continue
+		}
+
+		if err := union.Union(f); err != nil {
+			return nil, err
+		}
+	}
+
+	if union == nil {
+		return nil, errors.New("no filters to union")
+	}
+
+	return union, nil
+}
+
+// VerifyUnion checks that every element which is a member of either input
+// filter is also a member of the filter resulting from their union, since a
+// correct union never introduces false negatives. Returns an error listing the
+// elements missing from the result, or nil if there are none.
+func VerifyUnion(a, b, result *BloomFilter, elements [][]byte) error {
+	var missing bytes.Buffer
+	count := 0
+	for _, element := range elements {
+		if (a.Test(element) || b.Test(element)) && !result.Test(element) {
+			if count > 0 {
+				missing.WriteString(", ")
+			}
+			fmt.Fprintf(&missing, "%q", element)
+			count++
+		}
+	}
+
+	if count > 0 {
+		return fmt.Errorf("%d elements missing from union: %s", count, missing.String())
+	}
+	return nil
+}
+
+// estimateCardinality returns the approximate number of distinct items added
+// to a Bloom filter with m bits and k hash functions given the number of set
+// bits.
+func estimateCardinality(m, k, set uint) float64 {
+	if set == 0 {
+		return 0
+	}
+	return -float64(m) / float64(k) * math.Log(1-float64(set)/float64(m))
+}
+
+// ClassicMemory returns the estimated number of bytes used by the bit array of
+// a BloomFilter optimized to store n items with the specified target
+// false-positive rate.
+func ClassicMemory(n uint, fpRate float64) uint64 {
+	return (uint64(OptimalM(n, fpRate)) + 7) / 8
+}
@@ -1,7 +1,16 @@
 package boom
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -59,6 +68,152 @@ func TestBloomFillRatio(t *testing.T) {
 	}
 }
 
+// Ensures that EstimatedFPRate is close to the target rate for a correctly
+// sized filter and grows once it's over-filled.
+func TestBloomEstimatedFPRate(t *testing.T) {
+	f := NewBloomFilter(1000, 0.01)
+	if rate := f.EstimatedFPRate(); rate != 0 {
+		t.Errorf("Expected 0, got %f", rate)
+	}
+
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	if rate := f.EstimatedFPRate(); rate < 0.005 || rate > 0.02 {
+		t.Errorf("Expected approximately 0.01, got %f", rate)
+	}
+
+	for i := 1000; i < 3000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	if rate := f.EstimatedFPRate(); rate < 0.1 {
+		t.Errorf("Expected more than 0.1, got %f", rate)
+	}
+}
+
+// Ensures that ApproximatedSize estimates the number of distinct items from
+// the set bits, ignoring repeated additions.
+func TestBloomApproximatedSize(t *testing.T) {
+	f := NewBloomFilter(1000, 0.01)
+	for i := 0; i < 2000; i++ {
+		f.Add([]byte(strconv.Itoa(i % 500)))
+	}
+
+	if size := f.ApproximatedSize(); size < 475 || size > 525 {
+		t.Errorf("Expected approximately 500, got %d", size)
+	}
+}
+
+// Ensures that NewBloomFilterFromReader adds every delimited key of the stream
+// and sizes the filter for them when the stream can be rewound.
+func TestNewBloomFilterFromReader(t *testing.T) {
+	var (
+		keys = make([]string, 1000)
+		long = strings.Repeat("x", 100000)
+	)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	data := strings.Join(keys, "\n") + "\n\n" + long
+
+	f, err := NewBloomFilterFromReader(strings.NewReader(data), '\n', 0.01)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Count() != 1001 || f.Capacity() != OptimalM(1001, 0.01) {
+		t.Errorf("Expected 1001 keys and capacity %d, got %d and %d", OptimalM(1001, 0.01), f.Count(), f.Capacity())
+	}
+	for _, key := range append(keys, long) {
+		if !f.TestString(key) {
+			t.Errorf("Expected %.10s to be a member", key)
+		}
+	}
+
+	// Streams which can't be rewound are sized by the hint.
+	f, err = NewBloomFilterFromReader(io.MultiReader(strings.NewReader(data)), '\n', 0.01, WithHint(100))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Count() != 1001 || f.Capacity() != OptimalM(100, 0.01) {
+		t.Errorf("Expected 1001 keys and capacity %d, got %d and %d", OptimalM(100, 0.01), f.Count(), f.Capacity())
+	}
+}
+
+// Ensures that BitsPerElement returns close to the expected number of bits per
+// element for a correctly sized filter.
+func TestBloomBitsPerElement(t *testing.T) {
+	f := NewBloomFilter(10000, 0.01)
+	if bits := f.BitsPerElement(); !math.IsInf(bits, 1) {
+		t.Errorf("Expected +Inf, got %f", bits)
+	}
+
+	for i := 0; i < 10000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	expected := 1.44 * math.Log2(1/0.01)
+	if bits := f.BitsPerElement(); math.Abs(bits-expected)/expected > 0.05 {
+		t.Errorf("Expected approximately %f, got %f", expected, bits)
+	}
+}
+
+// Ensures that NewSinceCheckpoint tracks the number of distinct items added
+// since the last checkpoint.
+func TestBloomCheckpoint(t *testing.T) {
+	f := NewBloomFilter(10000, 0.01)
+	for i := 0; i < 3000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	if n := f.NewSinceCheckpoint(); n < 2850 || n > 3150 {
+		t.Errorf("Expected approximately 3000, got %d", n)
+	}
+
+	if f.Checkpoint() != f {
+		t.Error("Returned BloomFilter should be the same instance")
+	}
+
+	if n := f.NewSinceCheckpoint(); n != 0 {
+		t.Errorf("Expected 0, got %d", n)
+	}
+
+	// Re-adding existing items doesn't count.
+	for i := 2000; i < 4000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	if n := f.NewSinceCheckpoint(); n < 950 || n > 1050 {
+		t.Errorf("Expected approximately 1000, got %d", n)
+	}
+}
+
+// Ensures that FalsePositiveComparison returns theoretical and observed
+// false-positive rates which are close for a correctly sized filter.
+func TestBloomFalsePositiveComparison(t *testing.T) {
+	f := NewBloomFilter(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	probes := make([][]byte, 100000)
+	for i := range probes {
+		probes[i] = []byte(strconv.Itoa(i + 10000))
+	}
+
+	theoretical, observed := f.FalsePositiveComparison(probes)
+	if math.Abs(theoretical-0.01) > 0.001 {
+		t.Errorf("Expected theoretical rate close to 0.01, got %f", theoretical)
+	}
+
+	if math.Abs(theoretical-observed) > 0.005 {
+		t.Errorf("Expected observed rate close to %f, got %f", theoretical, observed)
+	}
+
+	if _, observed := f.FalsePositiveComparison(nil); observed != 0 {
+		t.Errorf("Expected 0, got %f", observed)
+	}
+}
+
 // Ensures that Test, Add, and TestAndAdd behave correctly.
 func TestBloomTestAndAdd(t *testing.T) {
 	f := NewBloomFilter(100, 0.01)
@@ -130,6 +285,615 @@ func TestBloomReset(t *testing.T) {
 	}
 }
 
+// Ensures that a Bloom filter using 128-bit hashing behaves correctly and
+// maintains its false-positive rate.
+func TestBloom128(t *testing.T) {
+	f := NewBloomFilter128(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	for i := 0; i < 10000; i++ {
+		if !f.Test([]byte(strconv.Itoa(i))) {
+			t.Fatalf("Expected %d to be a member", i)
+		}
+	}
+
+	fp := 0
+	for i := 10000; i < 110000; i++ {
+		if f.Test([]byte(strconv.Itoa(i))) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 100000; rate > 0.015 {
+		t.Errorf("Expected false-positive rate near 0.01, got %f", rate)
+	}
+}
+
+// Ensures that a Bloom filter using xxHash behaves correctly, maintains its
+// false-positive rate, and keeps its scheme when serialized.
+func TestBloomXXHash(t *testing.T) {
+	f := NewBloomFilterXXHash(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	for i := 0; i < 10000; i++ {
+		if !f.Test([]byte(strconv.Itoa(i))) {
+			t.Fatalf("Expected %d to be a member", i)
+		}
+	}
+
+	fp := 0
+	for i := 10000; i < 110000; i++ {
+		if f.Test([]byte(strconv.Itoa(i))) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 100000; rate > 0.015 {
+		t.Errorf("Expected false-positive rate near 0.01, got %f", rate)
+	}
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var g BloomFilter
+	if err := g.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g.scheme != schemeXXHash || !g.Test([]byte(`1`)) {
+		t.Error("Expected xxHash scheme to be restored")
+	}
+}
+
+// Ensures that 128-bit hashing addresses the full range of a filter with more
+// than 2^32 bits, unlike the 64-bit scheme reduced with a modulo.
+func TestBloom128LargeIndices(t *testing.T) {
+	var (
+		m     = uint(1 << 40)
+		f64   = &BloomFilter{m: m, k: 7, hash: fnv.New64(), modulo: true}
+		f128  = &BloomFilter{m: m, k: 7, scheme: schemeMurmur128}
+		max64 = uint(0)
+		upper = uint(0)
+	)
+
+	if ^uint(0)>>32 == 0 {
+		t.Skip("uint is too small for filters larger than 2^32")
+	}
+
+	for n := 0; n < 10000; n++ {
+		data := []byte(strconv.Itoa(n))
+		l64, u64 := f64.hashKernel(data)
+		l128, u128 := f128.hashKernel(data)
+		for i := uint(0); i < f64.k; i++ {
+			if idx := f64.index(l64, u64, i); idx > max64 {
+				max64 = idx
+			}
+			if idx := f128.index(l128, u128, i); idx >= m/2 {
+				upper++
+			}
+		}
+	}
+
+	// The 64-bit scheme can't index beyond k * 2^32.
+	if max64 >= f64.k<<32 {
+		t.Errorf("Expected 64-bit indices below %d, got %d", f64.k<<32, max64)
+	}
+
+	// Roughly half of the 128-bit indices should fall in the upper half.
+	if total := uint(10000) * f128.k; upper < total*2/5 || upper > total*3/5 {
+		t.Errorf("Expected roughly half of %d indices in the upper half, got %d", total, upper)
+	}
+}
+
+// Ensures that a filter with more than 2^32 bits keeps its false-positive rate
+// near the target for the number of items added. Filling the filter to
+// capacity takes billions of items, so it's filled partially with a single
+// hash function, whose rate is then measurable.
+func TestBloom128LargeFalsePositives(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large filter in short mode")
+	}
+	if ^uint(0)>>32 == 0 {
+		t.Skip("uint is too small for filters larger than 2^32")
+	}
+
+	f := NewBloomFilter128(3000000000, 0.5)
+	if f.m <= 1<<32 || f.K() != 1 {
+		t.Fatalf("Expected more than 2^32 bits and 1 hash function, got %d and %d", f.m, f.K())
+	}
+
+	const (
+		n     = 20000000
+		tests = 1000000
+	)
+	for i := 0; i < n; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	fp := 0
+	for i := n; i < n+tests; i++ {
+		if f.Test([]byte(strconv.Itoa(i))) {
+			fp++
+		}
+	}
+
+	var (
+		target = math.Pow(1-math.Exp(-float64(f.K()*n)/float64(f.m)), float64(f.K()))
+		rate   = float64(fp) / tests
+	)
+	if math.Abs(rate-target) > target/10 {
+		t.Errorf("Expected false-positive rate near %f, got %f", target, rate)
+	}
+}
+
+// Ensures that filters with more than 2^32 bits or cells use 128-bit hashing
+// automatically and that their indices cover the full range.
+func TestSizedHashKernel(t *testing.T) {
+	if ^uint(0)>>32 == 0 {
+		t.Skip("uint is too small for filters larger than 2^32")
+	}
+
+	if defaultScheme(1<<32) != schemeFNV || defaultScheme(1<<32+1) != schemeMurmur128 {
+		t.Error("Expected 128-bit hashing only beyond 2^32 bits")
+	}
+
+	var (
+		m     = uint(1 << 40)
+		hash  = fnv.New64()
+		upper = uint(0)
+	)
+	for n := 0; n < 10000; n++ {
+		lower, up := sizedHashKernel([]byte(strconv.Itoa(n)), hash, 0, m)
+		for i := uint(0); i < 4; i++ {
+			idx := sizedIndex(lower, up, i, m)
+			if idx >= m {
+				t.Fatalf("Expected index below %d, got %d", m, idx)
+			}
+			if idx >= m/2 {
+				upper++
+			}
+		}
+	}
+
+	if upper < 40000*2/5 || upper > 40000*3/5 {
+		t.Errorf("Expected roughly half of the indices in the upper half, got %d", upper)
+	}
+
+	// Small structures keep the 64-bit scheme.
+	data := []byte(`a`)
+	l32, u32 := seededHashKernel(data, hash, 0)
+	if lower, up := sizedHashKernel(data, hash, 0, 1000); lower != uint64(l32) || up != uint64(u32) {
+		t.Error("Expected 64-bit hashing for small structures")
+	}
+}
+
+// Ensures that ResetReseed clears the filter and picks a new seed such that
+// keys colliding under the old seed no longer necessarily collide.
+func TestBloomResetReseed(t *testing.T) {
+	f := NewBloomFilter(10, 0.1)
+	f.SetRand(rand.New(rand.NewSource(42)))
+	f.Add([]byte(`a`))
+
+	// Find a key which is a false positive under the original seed.
+	var collision []byte
+	for i := 0; collision == nil; i++ {
+		if key := []byte(strconv.Itoa(i)); f.Test(key) {
+			collision = key
+		}
+	}
+
+	if f.ResetReseed() != f {
+		t.Error("Returned BloomFilter should be the same instance")
+	}
+
+	if f.Seed() == 0 {
+		t.Error("Expected non-zero seed")
+	}
+
+	if f.Test([]byte(`a`)) {
+		t.Error("`a` should not be a member")
+	}
+
+	f.Add([]byte(`a`))
+	if f.Test(collision) {
+		t.Errorf("`%s` should not collide with `a` after reseeding", collision)
+	}
+}
+
+// Ensures that the memory estimates for the different filter types are ordered
+// as expected for the same n and false-positive rate.
+func TestMemoryEstimates(t *testing.T) {
+	var (
+		n           = uint(10000)
+		fpRate      = 0.01
+		classic     = ClassicMemory(n, fpRate)
+		partitioned = PartitionedMemory(n, fpRate)
+		counting    = CountingMemory(n, 4, fpRate)
+		scalable    = ScalableMemory(n, n, fpRate, 0.8)
+		cuckoo      = CuckooMemory(n, fpRate)
+	)
+
+	if classic == 0 {
+		t.Error("Expected non-zero classic memory")
+	}
+
+	if expected := uint64(len(NewBloomFilter(n, fpRate).buckets.data)); classic != expected {
+		t.Errorf("Expected %d, got %d", expected, classic)
+	}
+
+	if partitioned < classic {
+		t.Errorf("Expected partitioned %d >= classic %d", partitioned, classic)
+	}
+
+	if counting > 4*classic || counting < 4*(classic-1) {
+		t.Errorf("Expected counting %d to be roughly 4x classic %d", counting, classic)
+	}
+
+	if scalable < partitioned {
+		t.Errorf("Expected scalable %d >= partitioned %d", scalable, partitioned)
+	}
+
+	if more := ScalableMemory(10*n, n, fpRate, 0.8); more <= scalable {
+		t.Errorf("Expected scalable memory to grow with n, got %d <= %d", more, scalable)
+	}
+
+	if cuckoo == 0 {
+		t.Error("Expected non-zero cuckoo memory")
+	}
+}
+
+// Ensures that WriteTo and ReadFrom round-trip the Bloom filter.
+func TestBloomReadWrite(t *testing.T) {
+	f := NewBloomFilter(100, 0.01)
+	f.SetRand(rand.New(rand.NewSource(1)))
+	f.ResetReseed()
+	for i := 0; i < 100; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	other := NewBloomFilter(10, 0.1)
+	if _, err := other.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if other.Capacity() != f.Capacity() || other.K() != f.K() ||
+		other.Count() != f.Count() || other.Seed() != f.Seed() {
+		t.Error("Expected filter parameters to match")
+	}
+
+	for i := 0; i < 100; i++ {
+		if !other.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+}
+
+// Ensures that ReadFrom rejects a number of hash functions which is zero or
+// exceeds the filter size, rather than probing that many bits on every Test.
+func TestBloomReadFromInvalidK(t *testing.T) {
+	for _, k := range []uint{0, 1 << 63} {
+		f := NewBloomFilter(100, 0.01)
+		f.k = k
+
+		var buf bytes.Buffer
+		if _, err := f.WriteTo(&buf); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := (&BloomFilter{}).ReadFrom(&buf); err == nil {
+			t.Errorf("Expected error for k=%d", k)
+		}
+	}
+}
+
+// Ensures that Rebuild replaces an overfilled filter with one sized for the
+// re-fed keys and leaves the filter unchanged on error.
+func TestBloomRebuild(t *testing.T) {
+	f := NewBloomFilter(100, 0.01, WithSeed(7))
+	for i := 0; i < 2000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	if rate := f.EstimatedFPRate(); rate < 0.5 {
+		t.Fatalf("Expected an overfilled filter, got a false-positive rate of %f", rate)
+	}
+
+	keys := func(fn func([]byte) error) error {
+		for i := 0; i < 2000; i++ {
+			if err := fn([]byte(strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := f.Rebuild(0.001, keys); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if f.Capacity() != OptimalM(2000, 0.001) || f.K() != OptimalK(0.001) || f.Count() != 2000 || f.Seed() != 7 {
+		t.Errorf("Expected %d bits, %d hash functions, 2000 items, and seed 7, got %d, %d, %d, and %d",
+			OptimalM(2000, 0.001), OptimalK(0.001), f.Capacity(), f.K(), f.Count(), f.Seed())
+	}
+	for i := 0; i < 2000; i++ {
+		if !f.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+	fp := 0
+	for i := 2000; i < 102000; i++ {
+		if f.Test([]byte(strconv.Itoa(i))) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 100000; rate > 0.002 {
+		t.Errorf("Expected false-positive rate near 0.001, got %f", rate)
+	}
+
+	m := f.Capacity()
+	if err := f.Rebuild(0.01, func(func([]byte) error) error {
+		return errors.New("read failed")
+	}); err == nil {
+		t.Error("Expected error from the iterator")
+	}
+	if err := f.Rebuild(0.01, nil); err == nil {
+		t.Error("Expected error without keys")
+	}
+	if err := f.Rebuild(1, keys); err == nil {
+		t.Error("Expected error for invalid rate")
+	}
+	if f.Capacity() != m || !f.Test([]byte(`0`)) {
+		t.Error("Expected the filter to be unchanged")
+	}
+}
+
+// Ensures that Rebuild keeps rounding the bit array of a filter created
+// WithPowerOfTwoSize up to a power of two.
+func TestBloomRebuildPowerOfTwo(t *testing.T) {
+	f := NewBloomFilter(100, 0.01, WithPowerOfTwoSize())
+	keys := func(fn func([]byte) error) error {
+		for i := 0; i < 2000; i++ {
+			if err := fn([]byte(strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := f.Rebuild(0.001, keys); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if m := f.Capacity(); m&(m-1) != 0 || m < OptimalM(2000, 0.001) {
+		t.Errorf("Expected a power of two of at least %d bits, got %d", OptimalM(2000, 0.001), m)
+	}
+	if !f.Test([]byte(`1999`)) {
+		t.Error("Expected 1999 to be a member")
+	}
+}
+
+// Ensures that Rebuild keeps 128-bit hashing chosen with NewBloomFilter128 when
+// shrinking a filter below 2^32 bits, but switches a filter which defaulted to
+// it back to FNV.
+func TestBloomRebuildScheme(t *testing.T) {
+	keys := func(fn func([]byte) error) error {
+		return fn([]byte(`a`))
+	}
+
+	// The filters are too large to allocate, so only their size is faked.
+	chosen := NewBloomFilter128(100, 0.01)
+	chosen.m = 1<<32 + 1
+	defaulted := NewBloomFilter(100, 0.01)
+	defaulted.m, defaulted.scheme = 1<<32+1, schemeMurmur128
+	for _, f := range []*BloomFilter{chosen, defaulted} {
+		if err := f.Rebuild(0.01, keys); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if chosen.scheme != schemeMurmur128 {
+		t.Errorf("Expected 128-bit hashing to be kept, got scheme %d", chosen.scheme)
+	}
+	if defaulted.scheme != schemeFNV {
+		t.Errorf("Expected the default scheme, got scheme %d", defaulted.scheme)
+	}
+}
+
+// Ensures that Union merges compatible filters and returns an error for
+// incompatible ones.
+func TestBloomUnion(t *testing.T) {
+	a := NewBloomFilter(100, 0.01)
+	b := NewBloomFilter(100, 0.01)
+	a.Add([]byte(`foo`))
+	b.Add([]byte(`bar`))
+
+	if err := a.Union(b); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !a.Test([]byte(`foo`)) || !a.Test([]byte(`bar`)) {
+		t.Error("Expected `foo` and `bar` to be members")
+	}
+
+	if count := a.Count(); count != 2 {
+		t.Errorf("Expected 2, got %d", count)
+	}
+
+	if err := a.Union(NewBloomFilter(1000, 0.01)); err == nil {
+		t.Error("Expected error for mismatched capacity")
+	}
+
+	if err := a.Union(NewBloomFilter128(100, 0.01)); err == nil {
+		t.Error("Expected error for mismatched hashing scheme")
+	}
+}
+
+// Ensures that StreamUnion merges the serialized filters read from the
+// channel.
+func TestStreamUnion(t *testing.T) {
+	readers := make(chan io.Reader, 3)
+	for shard := 0; shard < 3; shard++ {
+		f := NewBloomFilter(300, 0.01)
+		for i := shard * 100; i < (shard+1)*100; i++ {
+			f.Add([]byte(strconv.Itoa(i)))
+		}
+
+		var buf bytes.Buffer
+		if _, err := f.WriteTo(&buf); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		readers <- &buf
+	}
+	close(readers)
+
+	union, err := StreamUnion(readers)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i := 0; i < 300; i++ {
+		if !union.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+
+	empty := make(chan io.Reader)
+	close(empty)
+	if _, err := StreamUnion(empty); err == nil {
+		t.Error("Expected error for empty channel")
+	}
+
+	incompatible := make(chan io.Reader, 2)
+	for _, n := range []uint{100, 1000} {
+		var buf bytes.Buffer
+		NewBloomFilter(n, 0.01).WriteTo(&buf)
+		incompatible <- &buf
+	}
+	close(incompatible)
+	if _, err := StreamUnion(incompatible); err == nil {
+		t.Error("Expected error for incompatible filters")
+	}
+
+	// The channel is drained after the error, so the producer doesn't block.
+	unbuffered := make(chan io.Reader)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			unbuffered <- strings.NewReader("invalid")
+		}
+		close(unbuffered)
+		close(done)
+	}()
+	if _, err := StreamUnion(unbuffered); err == nil {
+		t.Error("Expected error for invalid filter")
+	}
+	<-done
+}
+
+// Ensures that VerifyUnion passes for a correct union and reports the missing
+// elements for a broken one.
+func TestVerifyUnion(t *testing.T) {
+	var (
+		a        = NewBloomFilter(100, 0.01)
+		b        = NewBloomFilter(100, 0.01)
+		union    = NewBloomFilter(100, 0.01)
+		broken   = NewBloomFilter(100, 0.01)
+		elements = [][]byte{[]byte(`foo`), []byte(`bar`), []byte(`baz`)}
+	)
+	a.Add(elements[0])
+	b.Add(elements[1])
+	union.Add(elements[0]).Add(elements[1])
+	broken.Add(elements[0])
+
+	if err := VerifyUnion(a, b, union, elements); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	err := VerifyUnion(a, b, broken, elements)
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if msg := err.Error(); msg != `1 elements missing from union: "bar"` {
+		t.Errorf("Unexpected error message: %s", msg)
+	}
+}
+
+// Ensures that AddHashedAll and TestHashedAll agree with the byte-slice
+// methods when given the FNV-1 hashes of the data.
+func TestBloomHashedAll(t *testing.T) {
+	var (
+		f      = NewBloomFilter(1000, 0.01)
+		g      = NewBloomFilter(1000, 0.01)
+		data   = make([][]byte, 500)
+		hashes = make([]uint64, len(data))
+	)
+	for i := range data {
+		data[i] = []byte(strconv.Itoa(i))
+		h := fnv.New64()
+		h.Write(data[i])
+		hashes[i] = h.Sum64()
+	}
+
+	if f.TestHashedAll(hashes) {
+		t.Error("Expected empty filter not to contain hashes")
+	}
+
+	if _, err := f.AddAllContext(context.Background(), data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g.AddHashedAll(hashes) != g {
+		t.Error("Returned BloomFilter should be the same instance")
+	}
+
+	if !reflect.DeepEqual(f.buckets, g.buckets) {
+		t.Error("Expected hashed and byte-slice filters to be equal")
+	}
+
+	if g.Count() != uint(len(hashes)) {
+		t.Errorf("Expected %d, got %d", len(hashes), g.Count())
+	}
+
+	if !f.TestHashedAll(hashes) || !g.TestHashedAll(hashes[:10]) {
+		t.Error("Expected hashes to be members")
+	}
+
+	if !g.Test(data[42]) || !f.TestHashed(hashes[42]) {
+		t.Error("Expected data to be a member")
+	}
+
+	h := fnv.New64()
+	h.Write([]byte(`absent`))
+	if f.TestHashedAll(append(hashes, h.Sum64())) {
+		t.Error("Expected absent hash not to be a member")
+	}
+}
+
+// Ensures that a Bloom filter rounded up to a power of two uses the same
+// indices as one of that size reduced by modulo, so they are interchangeable.
+func TestBloomPowerOfTwoSize(t *testing.T) {
+	f := NewBloomFilter(1000, 0.01, WithPowerOfTwoSize(), WithModuloReduction())
+	if m := f.Capacity(); m != 16384 {
+		t.Fatalf("Expected 16384, got %d", m)
+	}
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	for i := uint(0); i < 100; i++ {
+		lower, upper := f.hashKernel([]byte(strconv.Itoa(int(i))))
+		if idx := f.index(lower, upper, i); idx != (uint(lower)+uint(upper)*i)%f.m {
+			t.Errorf("Expected the modulo index, got %d", idx)
+		}
+		if !f.Test([]byte(strconv.Itoa(int(i)))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+}
+
 func BenchmarkBloomAdd(b *testing.B) {
 	b.StopTimer()
 	f := NewBloomFilter(100000, 0.1)
@@ -144,6 +908,34 @@ func BenchmarkBloomAdd(b *testing.B) {
 	}
 }
 
+func BenchmarkBloomXXHashAdd(b *testing.B) {
+	b.StopTimer()
+	f := NewBloomFilterXXHash(100000, 0.1)
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.Add(data[n])
+	}
+}
+
+func BenchmarkBloomAddHashedAll(b *testing.B) {
+	b.StopTimer()
+	f := NewBloomFilter(100000, 0.1)
+	hashes := make([]uint64, b.N)
+	for i := 0; i < b.N; i++ {
+		h := fnv.New64()
+		h.Write([]byte(strconv.Itoa(i)))
+		hashes[i] = h.Sum64()
+	}
+	b.StartTimer()
+
+	f.AddHashedAll(hashes)
+}
+
 func BenchmarkBloomTest(b *testing.B) {
 	b.StopTimer()
 	f := NewBloomFilter(100000, 0.1)
@@ -158,6 +950,34 @@ func BenchmarkBloomTest(b *testing.B) {
 	}
 }
 
+func BenchmarkBloomTestPowerOfTwo(b *testing.B) {
+	b.StopTimer()
+	f := NewBloomFilter(100000, 0.1, WithPowerOfTwoSize())
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.Test(data[n])
+	}
+}
+
+func BenchmarkBloomTestModulo(b *testing.B) {
+	b.StopTimer()
+	f := NewBloomFilter(100000, 0.1, WithModuloReduction())
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.Test(data[n])
+	}
+}
+
 func BenchmarkBloomTestAndAdd(b *testing.B) {
 	b.StopTimer()
 	f := NewBloomFilter(100000, 0.1)
@@ -171,3 +991,257 @@ func BenchmarkBloomTestAndAdd(b *testing.B) {
 		f.TestAndAdd(data[n])
 	}
 }
+
+// Ensures that EstimateSymmetricDifference returns an approximation of the
+// symmetric difference cardinality and NaN for incompatible filters.
+func TestBloomEstimateSymmetricDifference(t *testing.T) {
+	a := NewBloomFilter(2000, 0.01)
+	b := NewBloomFilter(2000, 0.01)
+	for i := 0; i < 1000; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+		b.Add([]byte(strconv.Itoa(i + 500)))
+	}
+
+	// The symmetric difference is [0, 500) and [1000, 1500).
+	if diff := EstimateSymmetricDifference(a, b); diff < 900 || diff > 1100 {
+		t.Errorf("Expected approximately 1000, got %f", diff)
+	}
+
+	if diff := EstimateSymmetricDifference(a, a); diff != 0 {
+		t.Errorf("Expected 0, got %f", diff)
+	}
+
+	if diff := EstimateSymmetricDifference(a, NewBloomFilter(100, 0.01)); !math.IsNaN(diff) {
+		t.Errorf("Expected NaN, got %f", diff)
+	}
+}
+
+// Ensures that EstimateIntersection returns an approximation of the
+// intersection cardinality and NaN for incompatible filters.
+func TestBloomEstimateIntersection(t *testing.T) {
+	a := NewBloomFilter(2000, 0.01)
+	b := NewBloomFilter(2000, 0.01)
+	for i := 0; i < 1000; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+		b.Add([]byte(strconv.Itoa(i + 700)))
+	}
+
+	// The intersection is [700, 1000).
+	if n := EstimateIntersection(a, b); n < 250 || n > 350 {
+		t.Errorf("Expected approximately 300, got %f", n)
+	}
+
+	c := NewBloomFilter(2000, 0.01)
+	for i := 0; i < 1000; i++ {
+		c.Add([]byte(strconv.Itoa(i + 5000)))
+	}
+
+	if n := EstimateIntersection(a, c); n < 0 || n > 50 {
+		t.Errorf("Expected approximately 0, got %f", n)
+	}
+
+	if n := EstimateIntersection(a, NewBloomFilter(100, 0.01)); !math.IsNaN(n) {
+		t.Errorf("Expected NaN, got %f", n)
+	}
+}
+
+// Ensures that EstimateUnion and EstimateDifference return approximations of
+// the union and difference cardinalities and NaN for incompatible filters.
+func TestBloomEstimateUnionDifference(t *testing.T) {
+	a := NewBloomFilter(2000, 0.01)
+	b := NewBloomFilter(2000, 0.01)
+	for i := 0; i < 1000; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+		b.Add([]byte(strconv.Itoa(i + 700)))
+	}
+
+	// The union is [0, 1700) and a - b is [0, 700).
+	if n := EstimateUnion(a, b); n < 1600 || n > 1800 {
+		t.Errorf("Expected approximately 1700, got %f", n)
+	}
+
+	if n := EstimateDifference(a, b); n < 630 || n > 770 {
+		t.Errorf("Expected approximately 700, got %f", n)
+	}
+
+	if n := EstimateDifference(a, a); n != 0 {
+		t.Errorf("Expected 0, got %f", n)
+	}
+
+	other := NewBloomFilter(100, 0.01)
+	if !math.IsNaN(EstimateUnion(a, other)) || !math.IsNaN(EstimateDifference(a, other)) {
+		t.Error("Expected NaN for incompatible filters")
+	}
+}
+
+// Ensures that EstimateOverlap approximates the union, intersection, and
+// Jaccard similarity within a few standard errors and returns an error for
+// incompatible filters.
+func TestBloomEstimateOverlap(t *testing.T) {
+	a := NewBloomFilter(2000, 0.01)
+	b := NewBloomFilter(2000, 0.01)
+	for i := 0; i < 1000; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+		b.Add([]byte(strconv.Itoa(i + 500)))
+	}
+
+	// The union is [0, 1500) and the intersection is [500, 1000).
+	e, err := EstimateOverlap(a, b)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, c := range []struct {
+		name                string
+		got, stdErr, actual float64
+	}{
+		{"union", e.Union, e.UnionStdErr, 1500},
+		{"intersection", e.Intersection, e.IntersectionStdErr, 500},
+		{"Jaccard", e.Jaccard, e.JaccardStdErr, 1.0 / 3},
+	} {
+		if !(c.stdErr > 0) || math.IsInf(c.stdErr, 1) {
+			t.Errorf("Expected a positive, finite %s standard error, got %f", c.name, c.stdErr)
+		}
+		if math.Abs(c.got-c.actual) > 4*c.stdErr {
+			t.Errorf("Expected %s of approximately %f, got %f ± %f", c.name, c.actual, c.got, c.stdErr)
+		}
+	}
+
+	if e, _ := EstimateOverlap(a, a); e.Jaccard != 1 || e.Intersection != e.Union {
+		t.Errorf("Expected a Jaccard similarity of 1 with itself, got %f", e.Jaccard)
+	}
+
+	if _, err := EstimateOverlap(a, NewBloomFilter(100, 0.01)); err == nil {
+		t.Error("Expected error for incompatible filters")
+	}
+}
+
+// Ensures that Intersect keeps the items of both filters and returns an error
+// for incompatible ones.
+func TestBloomIntersect(t *testing.T) {
+	a := NewBloomFilter(2000, 0.01)
+	b := NewBloomFilter(2000, 0.01)
+	for i := 0; i < 1000; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+		b.Add([]byte(strconv.Itoa(i + 700)))
+	}
+
+	if err := a.Intersect(b); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i := 700; i < 1000; i++ {
+		if !a.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+
+	members := 0
+	for i := 0; i < 700; i++ {
+		if a.Test([]byte(strconv.Itoa(i))) {
+			members++
+		}
+	}
+	if members > 70 {
+		t.Errorf("Expected few of [0, 700) to be members, got %d", members)
+	}
+
+	if count := a.Count(); count < 250 || count > 450 {
+		t.Errorf("Expected approximately 300, got %d", count)
+	}
+
+	if err := a.Intersect(NewBloomFilter(100, 0.01)); err == nil {
+		t.Error("Expected error for mismatched capacity")
+	}
+}
+
+// Ensures that AssertFPRate passes for a correctly sized filter and fails for
+// an over-filled one.
+func TestAssertFPRate(t *testing.T) {
+	var (
+		f      = NewBloomFilter(1000, 0.01)
+		absent = make([][]byte, 10000)
+	)
+	for i := range absent {
+		absent[i] = []byte("absent" + strconv.Itoa(i))
+	}
+
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	if err := AssertFPRate(f, absent, 0.5); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+
+	for i := 1000; i < 3000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	if err := AssertFPRate(f, absent, 0.5); err == nil {
+		t.Error("Expected error for over-filled filter")
+	}
+
+	if err := AssertFPRate(f, nil, 0.5); err == nil {
+		t.Error("Expected error for no absent items")
+	}
+
+	if err := AssertFPRate(&BloomFilter{}, absent, 0.5); err == nil {
+		t.Error("Expected error for unknown target rate")
+	}
+}
+
+// cancelAfterContext is a context which reports being cancelled once Err has
+// been called more than n times.
+type cancelAfterContext struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfterContext) Err() error {
+	if c.n == 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+// Ensures that AddAllContext stops adding once the context is cancelled and
+// returns the number of items added.
+func TestBloomAddAllContext(t *testing.T) {
+	var (
+		f    = NewBloomFilter(10000, 0.01)
+		data = make([][]byte, 5000)
+		ctx  = &cancelAfterContext{Context: context.Background(), n: 2}
+	)
+	for i := range data {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+
+	added, err := f.AddAllContext(ctx, data)
+	if err != context.Canceled {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+
+	if added != 2*contextCheckInterval {
+		t.Errorf("Expected %d, got %d", 2*contextCheckInterval, added)
+	}
+
+	if count := f.Count(); count != uint(added) {
+		t.Errorf("Expected %d, got %d", added, count)
+	}
+
+	for _, d := range data[:added] {
+		if !f.Test(d) {
+			t.Errorf("Expected %s to be a member", d)
+		}
+	}
+
+	added, err = f.AddAllContext(context.Background(), data)
+	if err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+
+	if added != len(data) {
+		t.Errorf("Expected %d, got %d", len(data), added)
+	}
+}
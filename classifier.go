@@ -0,0 +1,81 @@
+package boom
+
+import "unsafe"
+
+// FrequencyClassifier classifies the frequency of items in a stream into
+// coarse buckets rather than reporting exact counts. It's backed by a
+// Count-Min Sketch, and an item is classified by its estimated frequency
+// relative to the total number of items added. Since the sketch never
+// underestimates, an item may be classified into a higher bucket than its
+// true frequency warrants, but never into a lower one.
+type FrequencyClassifier struct {
+	cms    *CountMinSketch
+	medium float64 // minimum fraction of TotalCount classified as medium
+	heavy  float64 // minimum fraction of TotalCount classified as heavy
+}
+
+// NewFrequencyClassifier creates a new FrequencyClassifier backed by a
+// Count-Min Sketch whose relative accuracy is within a factor of epsilon with
+// probability delta. Items whose estimated frequency is at least the heavy
+// fraction of the total count are classified as "heavy", those at least the
+// medium fraction as "medium", and the rest as "light".
+func NewFrequencyClassifier(epsilon, delta, medium, heavy float64, opts ...Option) *FrequencyClassifier {
+	return &FrequencyClassifier{
+		cms:    NewCountMinSketch(epsilon, delta, opts...),
+		medium: medium,
+		heavy:  heavy,
+	}
+}
+
+// Add will add the data to the classifier. Returns the FrequencyClassifier to
+// allow for chaining.
+func (f *FrequencyClassifier) Add(data []byte) *FrequencyClassifier {
+	f.cms.Add(data)
+	return f
+}
+
+// Classify returns "heavy", "medium", or "light" depending on the estimated
+// frequency of the data relative to the total count. Every item is "light" if
+// nothing has been added.
+func (f *FrequencyClassifier) Classify(data []byte) string {
+	total := f.cms.TotalCount()
+	if total == 0 {
+		return "light"
+	}
+
+	ratio := float64(f.cms.Count(data)) / float64(total)
+	switch {
+	case ratio >= f.heavy:
+		return "heavy"
+	case ratio >= f.medium:
+		return "medium"
+	default:
+		return "light"
+	}
+}
+
+// TotalCount returns the number of items added to the classifier.
+func (f *FrequencyClassifier) TotalCount() uint64 {
+	return f.cms.TotalCount()
+}
+
+// Clone returns an independent copy of the FrequencyClassifier, which can be
+// used concurrently with the original.
+func (f *FrequencyClassifier) Clone() *FrequencyClassifier {
+	c := *f
+	c.cms = f.cms.Clone()
+	return &c
+}
+
+// ByteSize returns the number of bytes used by the FrequencyClassifier and its
+// Count-Min Sketch.
+func (f *FrequencyClassifier) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*f)) + f.cms.ByteSize()
+}
+
+// Reset restores the FrequencyClassifier to its original state. It returns
+// itself to allow for chaining.
+func (f *FrequencyClassifier) Reset() *FrequencyClassifier {
+	f.cms.Reset()
+	return f
+}
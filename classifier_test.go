@@ -0,0 +1,54 @@
+package boom
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Ensures that Classify returns the frequency bucket of items in a skewed
+// stream.
+func TestFrequencyClassifierClassify(t *testing.T) {
+	f := NewFrequencyClassifier(0.001, 0.99, 0.01, 0.1)
+
+	if c := f.Classify([]byte(`heavy`)); c != "light" {
+		t.Errorf("Expected light, got %s", c)
+	}
+
+	for i := 0; i < 1000; i++ {
+		if f.Add([]byte(strconv.Itoa(i))) != f {
+			t.Error("Returned FrequencyClassifier should be the same instance")
+		}
+	}
+	for i := 0; i < 500; i++ {
+		f.Add([]byte(`heavy`))
+	}
+	for i := 0; i < 50; i++ {
+		f.Add([]byte(`medium`))
+	}
+
+	if c := f.Classify([]byte(`heavy`)); c != "heavy" {
+		t.Errorf("Expected heavy, got %s", c)
+	}
+
+	if c := f.Classify([]byte(`medium`)); c != "medium" {
+		t.Errorf("Expected medium, got %s", c)
+	}
+
+	for _, key := range []string{"1", "42", "999", "missing"} {
+		if c := f.Classify([]byte(key)); c != "light" {
+			t.Errorf("Expected light for %s, got %s", key, c)
+		}
+	}
+
+	if total := f.TotalCount(); total != 1550 {
+		t.Errorf("Expected 1550, got %d", total)
+	}
+
+	if f.Reset() != f {
+		t.Error("Returned FrequencyClassifier should be the same instance")
+	}
+
+	if c := f.Classify([]byte(`heavy`)); c != "light" {
+		t.Errorf("Expected light, got %s", c)
+	}
+}
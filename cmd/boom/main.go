@@ -0,0 +1,354 @@
+// Command boom builds, queries, merges, and describes filters from the shell,
+// reading newline-delimited keys from files or standard input.
+//
+// Usage:
+//
+//	boom build [-type bloom|scalable|cuckoo] [-n items] [-fp rate] [-o file] [keys]
+//	boom test -f file [key...]
+//	boom merge -o file filter...
+//	boom stats filter
+//
+// build adds every key to a new filter and saves it to the output file, or
+// standard output. test prints each key followed by whether it's a member,
+// reading the keys from standard input if none are given. merge combines
+// filters of the same type and parameters into the output file, and stats
+// prints a filter's parameters and fill.
+//
+// A saved filter is the name of its type on a line of its own followed by the
+// filter's binary representation as written by its WriteTo method.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	boom "github.com/tylertreat/BoomFilters"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "boom:", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command with the arguments, excluding the program name.
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: boom build|test|merge|stats [arguments]")
+	}
+
+	var (
+		flags = flag.NewFlagSet(args[0], flag.ContinueOnError)
+		kind  = flags.String("type", "bloom", "filter type: bloom, scalable, or cuckoo")
+		n     = flags.Uint("n", 10000, "number of items to size the filter for")
+		fp    = flags.Float64("fp", 0.01, "target false-positive rate")
+		out   = flags.String("o", "", "output file (default standard output)")
+		file  = flags.String("f", "", "filter file")
+	)
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "build":
+		return build(*kind, *n, *fp, flags.Args(), stdin, output(*out, stdout))
+	case "test":
+		return test(*file, flags.Args(), stdin, stdout)
+	case "merge":
+		return merge(flags.Args(), output(*out, stdout))
+	case "stats":
+		if *file == "" && flags.NArg() == 1 {
+			*file = flags.Arg(0)
+		}
+		return stats(*file, stdout)
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// filter is a saved filter of one of the supported types.
+type filter struct {
+	kind     string
+	bloom    *boom.BloomFilter
+	scalable *boom.ScalableBloomFilter
+	cuckoo   *boom.CuckooFilter
+}
+
+// newFilter creates an empty filter of the type sized for n items with the
+// target false-positive rate.
+func newFilter(kind string, n uint, fpRate float64) (*filter, error) {
+	f := &filter{kind: kind}
+	switch kind {
+	case "bloom":
+		f.bloom = boom.NewBloomFilter(n, fpRate)
+	case "scalable":
+		f.scalable = boom.NewScalableBloomFilter(n, fpRate, 0.8)
+	case "cuckoo":
+		f.cuckoo = boom.NewCuckooFilter(n, fpRate)
+	default:
+		return nil, fmt.Errorf("unknown filter type %q", kind)
+	}
+	return f, nil
+}
+
+// add adds the key to the filter. Returns an error if a cuckoo filter is full.
+func (f *filter) add(key []byte) error {
+	switch {
+	case f.bloom != nil:
+		f.bloom.Add(key)
+	case f.scalable != nil:
+		f.scalable.Add(key)
+	default:
+		return f.cuckoo.Add(key)
+	}
+	return nil
+}
+
+// test returns true if the key is a member of the filter.
+func (f *filter) test(key []byte) bool {
+	switch {
+	case f.bloom != nil:
+		return f.bloom.Test(key)
+	case f.scalable != nil:
+		return f.scalable.Test(key)
+	}
+	return f.cuckoo.Test(key)
+}
+
+// merge merges the other filter of the same type into this one.
+func (f *filter) merge(other *filter) error {
+	if f.kind != other.kind {
+		return fmt.Errorf("can't merge %s filter into %s filter", other.kind, f.kind)
+	}
+
+	switch {
+	case f.bloom != nil:
+		return f.bloom.Union(other.bloom)
+	case f.scalable != nil:
+		return f.scalable.Merge(other.scalable)
+	}
+	return errors.New("cuckoo filters can't be merged")
+}
+
+// value returns the filter's value, which implements io.WriterTo and
+// io.ReaderFrom.
+func (f *filter) value() interface {
+	io.WriterTo
+	io.ReaderFrom
+} {
+	switch {
+	case f.bloom != nil:
+		return f.bloom
+	case f.scalable != nil:
+		return f.scalable
+	}
+	return f.cuckoo
+}
+
+// writeTo writes the filter's type and binary representation to the stream.
+func (f *filter) writeTo(stream io.Writer) error {
+	if _, err := io.WriteString(stream, f.kind+"\n"); err != nil {
+		return err
+	}
+	_, err := f.value().WriteTo(stream)
+	return err
+}
+
+// readFilter reads a filter saved by writeTo from the file.
+func readFilter(path string) (*filter, error) {
+	if path == "" {
+		return nil, errors.New("no filter file given")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	kind, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("%s: missing filter type", path)
+	}
+
+	f, err := newFilter(strings.TrimSuffix(kind, "\n"), 1, 0.5)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if _, err := f.value().ReadFrom(r); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return f, nil
+}
+
+// build adds the keys of the file, or of stdin if none is given, to a new
+// filter and writes it to the output.
+func build(kind string, n uint, fpRate float64, args []string, stdin io.Reader, out func() (io.WriteCloser, error)) error {
+	f, err := newFilter(kind, n, fpRate)
+	if err != nil {
+		return err
+	}
+
+	input := stdin
+	if len(args) > 0 {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	if err := readKeys(input, f.add); err != nil {
+		return err
+	}
+	return save(f, out)
+}
+
+// test prints each key, read from stdin if none are given, followed by
+// whether it's a member of the filter.
+func test(path string, keys []string, stdin io.Reader, stdout io.Writer) error {
+	f, err := readFilter(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(stdout)
+	report := func(key []byte) error {
+		_, err := fmt.Fprintf(w, "%s\t%t\n", key, f.test(key))
+		return err
+	}
+	if len(keys) > 0 {
+		for _, key := range keys {
+			if err := report([]byte(key)); err != nil {
+				return err
+			}
+		}
+	} else if err := readKeys(stdin, report); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// merge merges every filter into the first and writes it to the output.
+func merge(paths []string, out func() (io.WriteCloser, error)) error {
+	if len(paths) < 2 {
+		return errors.New("at least two filters must be given")
+	}
+
+	f, err := readFilter(paths[0])
+	if err != nil {
+		return err
+	}
+	for _, path := range paths[1:] {
+		other, err := readFilter(path)
+		if err != nil {
+			return err
+		}
+		if err := f.merge(other); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return save(f, out)
+}
+
+// stats prints the parameters and fill of the filter.
+func stats(path string, stdout io.Writer) error {
+	f, err := readFilter(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(stdout)
+	fmt.Fprintf(w, "type\t%s\n", f.kind)
+	switch {
+	case f.bloom != nil:
+		fmt.Fprintf(w, "capacity\t%d\n", f.bloom.Capacity())
+		fmt.Fprintf(w, "hash functions\t%d\n", f.bloom.K())
+		fmt.Fprintf(w, "count\t%d\n", f.bloom.Count())
+		fmt.Fprintf(w, "approximated size\t%d\n", f.bloom.ApproximatedSize())
+		fmt.Fprintf(w, "fill ratio\t%f\n", f.bloom.FillRatio())
+		fmt.Fprintf(w, "estimated fp rate\t%f\n", f.bloom.EstimatedFPRate())
+		fmt.Fprintf(w, "bytes\t%d\n", f.bloom.ByteSize())
+	case f.scalable != nil:
+		fmt.Fprintf(w, "capacity\t%d\n", f.scalable.Capacity())
+		fmt.Fprintf(w, "hash functions\t%d\n", f.scalable.K())
+		fmt.Fprintf(w, "filters\t%d\n", len(f.scalable.Stats()))
+		fmt.Fprintf(w, "approximated size\t%d\n", f.scalable.ApproximatedSize())
+		fmt.Fprintf(w, "fill ratio\t%f\n", f.scalable.FillRatio())
+		fmt.Fprintf(w, "estimated fp rate\t%f\n", f.scalable.EstimatedFPRate())
+		fmt.Fprintf(w, "bytes\t%d\n", f.scalable.ByteSize())
+	default:
+		fmt.Fprintf(w, "capacity\t%d\n", f.cuckoo.Capacity())
+		fmt.Fprintf(w, "buckets\t%d\n", f.cuckoo.Buckets())
+		fmt.Fprintf(w, "count\t%d\n", f.cuckoo.Count())
+		fmt.Fprintf(w, "bytes\t%d\n", f.cuckoo.ByteSize())
+	}
+	return w.Flush()
+}
+
+// readKeys calls fn with each newline-delimited key of the input, without the
+// trailing newline or carriage return. Empty lines are skipped. The key is
+// only valid until fn returns.
+func readKeys(input io.Reader, fn func([]byte) error) error {
+	r := bufio.NewReader(input)
+	for {
+		line, err := r.ReadBytes('\n')
+		if key := bytes.TrimRight(line, "\r\n"); len(key) > 0 {
+			if err := fn(key); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// output returns a function opening the output file, or stdout if the path is
+// empty, which is only created once the result is ready so that a failed
+// command doesn't truncate it.
+func output(path string, stdout io.Writer) func() (io.WriteCloser, error) {
+	return func() (io.WriteCloser, error) {
+		if path == "" {
+			return nopCloser{stdout}, nil
+		}
+		return os.Create(path)
+	}
+}
+
+// save writes the filter to the output.
+func save(f *filter, out func() (io.WriteCloser, error)) error {
+	w, err := out()
+	if err != nil {
+		return err
+	}
+
+	buf := bufio.NewWriter(w)
+	if err := f.writeTo(buf); err != nil {
+		w.Close()
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// nopCloser adds a no-op Close method to a Writer.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Ensures that filters can be built, tested, merged, and described.
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	for _, kind := range []string{"bloom", "scalable", "cuckoo"} {
+		var (
+			a   = filepath.Join(dir, kind+"-a")
+			b   = filepath.Join(dir, kind+"-b")
+			out bytes.Buffer
+		)
+		if err := run([]string{"build", "-type", kind, "-n", "100", "-o", a}, strings.NewReader("a\nb\r\n\nc"), &out); err != nil {
+			t.Fatalf("%s: unexpected error building: %v", kind, err)
+		}
+		if err := run([]string{"build", "-type", kind, "-n", "100", "-o", b}, strings.NewReader("d\n"), &out); err != nil {
+			t.Fatalf("%s: unexpected error building: %v", kind, err)
+		}
+
+		if err := run([]string{"test", "-f", a, "a", "c", "d"}, nil, &out); err != nil {
+			t.Fatalf("%s: unexpected error testing: %v", kind, err)
+		}
+		if got := out.String(); got != "a\ttrue\nc\ttrue\nd\tfalse\n" {
+			t.Errorf("%s: unexpected test output %q", kind, got)
+		}
+
+		out.Reset()
+		if err := run([]string{"test", "-f", a}, strings.NewReader("b\n"), &out); err != nil || out.String() != "b\ttrue\n" {
+			t.Errorf("%s: expected b to be a member, got %q, %v", kind, out.String(), err)
+		}
+
+		out.Reset()
+		if err := run([]string{"stats", a}, nil, &out); err != nil || !strings.HasPrefix(out.String(), "type\t"+kind+"\n") {
+			t.Errorf("%s: unexpected stats %q, %v", kind, out.String(), err)
+		}
+
+		merged := filepath.Join(dir, kind+"-merged")
+		err := run([]string{"merge", "-o", merged, a, b}, nil, &out)
+		if kind == "cuckoo" {
+			if err == nil {
+				t.Error("Expected error merging cuckoo filters")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error merging: %v", kind, err)
+		}
+
+		out.Reset()
+		if err := run([]string{"test", "-f", merged, "a", "d"}, nil, &out); err != nil || out.String() != "a\ttrue\nd\ttrue\n" {
+			t.Errorf("%s: expected merged members, got %q, %v", kind, out.String(), err)
+		}
+	}
+
+	if err := run([]string{"build", "-type", "unknown"}, strings.NewReader(""), &bytes.Buffer{}); err == nil {
+		t.Error("Expected error for an unknown filter type")
+	}
+}
@@ -0,0 +1,83 @@
+package boom
+
+import "time"
+
+// FilterComparison contains the estimated memory, observed false-positive rate,
+// and rough timings of a filter type built over a data set.
+type FilterComparison struct {
+	Memory      uint64  // estimated bytes used by the filter data
+	ObservedFP  float64 // fraction of probes reported as members
+	AddNsPerOp  float64 // average nanoseconds per add
+	TestNsPerOp float64 // average nanoseconds per test
+}
+
+// CompareFilters builds each filter type sized for the elements with the
+// target false-positive rate and reports its memory, accuracy, and timings.
+// The probes must not contain any of the elements. The results are keyed by
+// filter type: "classic", "partitioned", "scalable", "stable", "counting", and
+// "cuckoo". Timings are measured over a single pass and are only a rough
+// indication of relative performance.
+func CompareFilters(elements, probes [][]byte, fpRate float64) map[string]FilterComparison {
+	n := uint(len(elements))
+	if n == 0 {
+		n = 1
+	}
+
+	var (
+		cuckoo  = NewCuckooFilter(n, fpRate)
+		m       = OptimalM(n, fpRate)
+		results = map[string]FilterComparison{}
+		filters = map[string]struct {
+			filter Filter
+			memory uint64
+		}{
+			"classic":     {NewBloomFilter(n, fpRate), ClassicMemory(n, fpRate)},
+			"partitioned": {NewPartitionedBloomFilter(n, fpRate), PartitionedMemory(n, fpRate)},
+			"scalable":    {NewDefaultScalableBloomFilter(fpRate), ScalableMemory(n, 10000, fpRate, 0.8)},
+			"stable":      {NewDefaultStableBloomFilter(m, fpRate), (uint64(m) + 7) / 8},
+			"counting":    {NewDefaultCountingBloomFilter(n, fpRate), CountingMemory(n, 4, fpRate)},
+		}
+	)
+
+	for name, f := range filters {
+		filter := f.filter
+		results[name] = compareFilter(func(data []byte) {
+			filter.Add(data)
+		}, filter.Test, elements, probes, f.memory)
+	}
+
+	results["cuckoo"] = compareFilter(func(data []byte) {
+		cuckoo.Add(data)
+	}, cuckoo.Test, elements, probes, CuckooMemory(n, fpRate))
+
+	return results
+}
+
+// compareFilter adds the elements and tests the probes using the provided
+// functions, reporting the observed false-positive rate and timings.
+func compareFilter(add func([]byte), test func([]byte) bool, elements, probes [][]byte,
+	memory uint64) FilterComparison {
+	result := FilterComparison{Memory: memory}
+
+	start := time.Now()
+	for _, element := range elements {
+		add(element)
+	}
+	if len(elements) > 0 {
+		result.AddNsPerOp = float64(time.Since(start).Nanoseconds()) / float64(len(elements))
+	}
+
+	positives := 0
+	start = time.Now()
+	for _, probe := range probes {
+		if test(probe) {
+			positives++
+		}
+	}
+	if len(probes) > 0 {
+		result.TestNsPerOp = float64(time.Since(start).Nanoseconds()) / float64(len(probes))
+		result.ObservedFP = float64(positives) / float64(len(probes))
+	}
+
+	return result
+}
@@ -0,0 +1,46 @@
+package boom
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Ensures that CompareFilters reports plausible values for every filter type.
+func TestCompareFilters(t *testing.T) {
+	elements := make([][]byte, 1000)
+	for i := range elements {
+		elements[i] = []byte(strconv.Itoa(i))
+	}
+	probes := make([][]byte, 10000)
+	for i := range probes {
+		probes[i] = []byte(strconv.Itoa(i + len(elements)))
+	}
+
+	results := CompareFilters(elements, probes, 0.01)
+
+	for _, name := range []string{"classic", "partitioned", "scalable", "stable", "counting", "cuckoo"} {
+		result, ok := results[name]
+		if !ok {
+			t.Errorf("Expected %s in results", name)
+			continue
+		}
+
+		if result.Memory == 0 {
+			t.Errorf("Expected non-zero memory for %s", name)
+		}
+
+		if result.ObservedFP < 0 || result.ObservedFP > 0.05 {
+			t.Errorf("Expected false-positive rate near 0.01 for %s, got %f", name, result.ObservedFP)
+		}
+
+		if result.AddNsPerOp <= 0 || result.TestNsPerOp <= 0 {
+			t.Errorf("Expected positive timings for %s, got %f and %f", name,
+				result.AddNsPerOp, result.TestNsPerOp)
+		}
+	}
+
+	if results["counting"].Memory <= results["classic"].Memory {
+		t.Errorf("Expected counting memory %d > classic memory %d",
+			results["counting"].Memory, results["classic"].Memory)
+	}
+}
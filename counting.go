@@ -1,8 +1,11 @@
 package boom
 
 import (
+	"context"
+	"errors"
 	"hash"
-	"hash/fnv"
+	"io"
+	"unsafe"
 )
 
 // CountingBloomFilter implements a Counting Bloom Filter as described by Fan,
@@ -28,31 +31,124 @@ type CountingBloomFilter struct {
 	k           uint        // number of hash functions
 	count       uint        // number of items in the filter
 	indexBuffer []uint      // buffer used to cache indices
+	seed        uint64      // hash seed (zero means unseeded)
+	saturation  SaturationPolicy
+	overflows   uint // number of additions reaching a saturated bucket
+	modulo      bool // reduce hashes to indices with a modulo
 }
 
+// SaturationPolicy determines what a CountingBloomFilter does when an addition
+// increments a bucket which already holds the maximum bucket value.
+type SaturationPolicy uint8
+
+const (
+	// SaturateClamp leaves the bucket at the maximum value, and removals still
+	// decrement it. Once a bucket has saturated, it holds fewer than the
+	// number of items added to it, so removing them can clear it while other
+	// items remain, making them false negatives. It's the default.
+	SaturateClamp SaturationPolicy = iota
+
+	// SaturateSticky leaves the bucket at the maximum value for good: removals
+	// don't decrement it, so saturation never causes false negatives, but
+	// the bucket is only cleared by Reset.
+	SaturateSticky
+
+	// SaturateRefuse refuses the addition, leaving the filter unchanged, so
+	// no bucket ever saturates. TryAdd returns ErrCounterOverflow, and the
+	// other additions report nothing but the overflow count.
+	SaturateRefuse
+)
+
+// ErrCounterOverflow is returned by CountingBloomFilter.TryAdd when the
+// SaturateRefuse policy refuses an addition because a bucket is saturated.
+var ErrCounterOverflow = errors.New("counter overflow")
+
 // NewCountingBloomFilter creates a new Counting Bloom Filter optimized to
-// store n items with a specified target false-positive rate and bucket size.
-// If you don't know how many bits to use for buckets, use
-// NewDefaultCountingBloomFilter for a sensible default.
-func NewCountingBloomFilter(n uint, b uint8, fpRate float64) *CountingBloomFilter {
+// store n items with a specified target false-positive rate and bucket size,
+// from 1 to 31 bits. Sixteen-bit buckets suit keys added very often. If you
+// don't know how many bits to use for buckets, use
+// NewDefaultCountingBloomFilter for a sensible default, and WithSaturation to
+// choose what happens when a bucket saturates.
+func NewCountingBloomFilter(n uint, b uint8, fpRate float64, opts ...Option) *CountingBloomFilter {
 	var (
 		m = OptimalM(n, fpRate)
 		k = OptimalK(fpRate)
 	)
+	o := applyOptions(opts)
 	return &CountingBloomFilter{
 		buckets:     NewBuckets(m, b),
-		hash:        fnv.New64(),
+		hash:        o.newHash64(newFNV64),
 		m:           m,
 		k:           k,
 		indexBuffer: make([]uint, k),
+		seed:        o.seed,
+		saturation:  o.saturation,
+		modulo:      o.modulo,
+	}
+}
+
+// NewCountingBloomFilterWithOptions creates a new Counting Bloom Filter
+// configured entirely by options, like NewBloomFilterWithOptions, whose buckets
+// are allocated four bits. Returns an error if a setting is out of range or the
+// factory set with WithBucketsBackend fails.
+func NewCountingBloomFilterWithOptions(opts ...Option) (*CountingBloomFilter, error) {
+	o := applyOptions(opts)
+	n, fpRate, err := o.sizing()
+	if err != nil {
+		return nil, err
+	}
+
+	if o.buckets != nil {
+		return NewCountingBloomFilterWithBuckets(n, 4, fpRate, o.buckets, opts...)
+	}
+	return NewCountingBloomFilter(n, 4, fpRate, opts...), nil
+}
+
+// NewCountingBloomFilterWithBuckets creates a new Counting Bloom Filter like
+// NewCountingBloomFilter whose buckets are created by the factory, such as one
+// returning Buckets created by NewBucketsWithStore to share the filter between
+// processes. Returns an error if the factory fails.
+func NewCountingBloomFilterWithBuckets(n uint, b uint8, fpRate float64, newBuckets BucketsFactory, opts ...Option) (*CountingBloomFilter, error) {
+	var (
+		m = OptimalM(n, fpRate)
+		k = OptimalK(fpRate)
+	)
+	buckets, err := newFactoryBuckets(newBuckets, 0, m, b)
+	if err != nil {
+		return nil, err
 	}
+
+	o := applyOptions(opts)
+	return &CountingBloomFilter{
+		buckets:     buckets,
+		hash:        o.newHash64(newFNV64),
+		m:           m,
+		k:           k,
+		indexBuffer: make([]uint, k),
+		seed:        o.seed,
+		saturation:  o.saturation,
+		modulo:      o.modulo,
+	}, nil
 }
 
 // NewDefaultCountingBloomFilter creates a new Counting Bloom Filter optimized
 // to store n items with a specified target false-positive rate. Buckets are
 // allocated four bits.
-func NewDefaultCountingBloomFilter(n uint, fpRate float64) *CountingBloomFilter {
-	return NewCountingBloomFilter(n, 4, fpRate)
+func NewDefaultCountingBloomFilter(n uint, fpRate float64, opts ...Option) *CountingBloomFilter {
+	return NewCountingBloomFilter(n, 4, fpRate, opts...)
+}
+
+// NewCountingBloomFilterForMultiplicity creates a new Counting Bloom Filter
+// optimized to store n items with a specified target false-positive rate whose
+// buckets are wide enough to count up to maxMultiplicity additions of the same
+// item without saturating. Buckets are at most 31 bits wide, so a multiplicity
+// above 2^31 - 1 saturates at that value.
+func NewCountingBloomFilterForMultiplicity(n uint, fpRate float64, maxMultiplicity uint, opts ...Option) *CountingBloomFilter {
+	b := uint8(1)
+	for b < 31 && uint64(1)<<b-1 < uint64(maxMultiplicity) {
+		b++
+	}
+	return NewCountingBloomFilter(n, b, fpRate, opts...)
 }
 
 // Capacity returns the Bloom filter capacity, m.
@@ -70,15 +166,29 @@ func (c *CountingBloomFilter) Count() uint {
 	return c.count
 }
 
+// Saturation returns the policy for additions to saturated buckets, set with
+// WithSaturation.
+func (c *CountingBloomFilter) Saturation() SaturationPolicy {
+	return c.saturation
+}
+
+// OverflowCount returns the number of additions which incremented a bucket
+// already holding the maximum bucket value, including those refused by
+// SaturateRefuse. Any overflow means estimated counts may be too low and,
+// with SaturateClamp, that removals may cause false negatives.
+func (c *CountingBloomFilter) OverflowCount() uint {
+	return c.overflows
+}
+
 // Test will test for membership of the data and returns true if it is a
 // member, false if not. This is a probabilistic test, meaning there is a
 // non-zero probability of false positives and false negatives.
 func (c *CountingBloomFilter) Test(data []byte) bool {
-	lower, upper := hashKernel(data, c.hash)
+	lower, upper := sizedHashKernel(data, c.hash, c.seed, c.m)
 
 	// If any of the K bits are not set, then it's not a member.
 	for i := uint(0); i < c.k; i++ {
-		if c.buckets.Get((uint(lower)+uint(upper)*i)%c.m) == 0 {
+		if c.buckets.Get(reducedIndex(lower, upper, i, c.m, c.modulo)) == 0 {
 			return false
 		}
 	}
@@ -86,61 +196,178 @@ func (c *CountingBloomFilter) Test(data []byte) bool {
 	return true
 }
 
-// Add will add the data to the Bloom filter. It returns the filter to allow
-// for chaining.
-func (c *CountingBloomFilter) Add(data []byte) Filter {
-	lower, upper := hashKernel(data, c.hash)
+// EstimatedCount returns the estimated number of times the data has been added
+// to the filter, which is the minimum value of its k buckets. This doesn't
+// modify the filter. The estimate may be too high due to hash collisions, so
+// it's an upper bound on the data's multiplicity, but it's limited by the
+// maximum bucket value and may be too low once OverflowCount is nonzero. Count,
+// which takes no data, returns the number of items in the filter instead.
+func (c *CountingBloomFilter) EstimatedCount(data []byte) uint {
+	lower, upper := sizedHashKernel(data, c.hash, c.seed, c.m)
+	count := c.buckets.MaxBucketValueWide()
 
-	// Set the K bits.
 	for i := uint(0); i < c.k; i++ {
-		c.buckets.Increment((uint(lower)+uint(upper)*i)%c.m, 1)
+		if val := c.buckets.Get(reducedIndex(lower, upper, i, c.m, c.modulo)); val < count {
+			count = val
+		}
 	}
 
-	c.count++
+	return uint(count)
+}
+
+// EstimatedCountString is equivalent to EstimatedCount for a string, which is
+// hashed without being copied.
+func (c *CountingBloomFilter) EstimatedCountString(data string) uint {
+	return c.EstimatedCount(stringBytes(data))
+}
+
+// SeenExactlyOnce returns true if the data appears to have been added to the
+// filter exactly once, meaning its estimated count is one. Hash collisions can
+// cause over-counting, so this may return false for data which was only added
+// once.
+func (c *CountingBloomFilter) SeenExactlyOnce(data []byte) bool {
+	return c.EstimatedCount(data) == 1
+}
+
+// Add will add the data to the Bloom filter, unless the SaturateRefuse policy
+// refuses it. It returns the filter to allow for chaining.
+func (c *CountingBloomFilter) Add(data []byte) Filter {
+	c.indices(data)
+	c.add()
 	return c
 }
 
+// TryAdd is equivalent to Add, but returns ErrCounterOverflow if the
+// SaturateRefuse policy refuses the addition.
+func (c *CountingBloomFilter) TryAdd(data []byte) error {
+	c.indices(data)
+	if !c.add() {
+		return ErrCounterOverflow
+	}
+	return nil
+}
+
 // TestAndAdd is equivalent to calling Test followed by Add. It returns true if
 // the data is a member, false if not.
 func (c *CountingBloomFilter) TestAndAdd(data []byte) bool {
-	lower, upper := hashKernel(data, c.hash)
-	member := true
+	c.indices(data)
+	member := c.minimum() > 0
+	c.add()
+	return member
+}
 
-	// If any of the K bits are not set, then it's not a member.
-	for i := uint(0); i < c.k; i++ {
-		idx := (uint(lower) + uint(upper)*i) % c.m
-		if c.buckets.Get(idx) == 0 {
-			member = false
-		}
-		c.buckets.Increment(idx, 1)
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (c *CountingBloomFilter) TestString(data string) bool {
+	return c.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (c *CountingBloomFilter) AddString(data string) Filter {
+	return c.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (c *CountingBloomFilter) TestAndAddString(data string) bool {
+	return c.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter. It returns the filter to allow
+// for chaining.
+func (c *CountingBloomFilter) AddMany(data [][]byte) Filter {
+	for _, d := range data {
+		c.Add(d)
 	}
+	return c
+}
 
-	c.count++
-	return member
+// TestMany returns whether each item of data is a member, in the same order.
+func (c *CountingBloomFilter) TestMany(data [][]byte) []bool {
+	return testMany(data, c.Test)
+}
+
+// TestAndAddCount is equivalent to calling Test followed by Add and then
+// EstimatedCount. It returns true if the data was a member before it was
+// added, false if not, along with the estimated number of times the data has
+// been added including this time.
+func (c *CountingBloomFilter) TestAndAddCount(data []byte) (bool, uint) {
+	c.indices(data)
+	member := c.minimum() > 0
+	c.add()
+	return member, uint(c.minimum())
+}
+
+// AddAllContext adds each item of data to the filter, checking the context for
+// cancellation periodically. It returns the number of items added, which are
+// the first ones in data, and the context's error if it was cancelled before
+// every item was added.
+func (c *CountingBloomFilter) AddAllContext(ctx context.Context, data [][]byte) (int, error) {
+	return addAllContext(ctx, data, func(d []byte) error {
+		c.Add(d)
+		return nil
+	})
 }
 
 // TestAndRemove will test for membership of the data and remove it from the
 // filter if it exists. Returns true if the data was a member, false if not.
+// With the SaturateSticky policy, saturated buckets aren't decremented.
 func (c *CountingBloomFilter) TestAndRemove(data []byte) bool {
-	lower, upper := hashKernel(data, c.hash)
-	member := true
+	c.indices(data)
+	if c.minimum() == 0 {
+		return false
+	}
+
+	max := c.buckets.MaxBucketValueWide()
+	for _, idx := range c.indexBuffer {
+		if c.saturation != SaturateSticky || c.buckets.Get(idx) != max {
+			c.buckets.Increment(idx, -1)
+		}
+	}
+	c.count--
+	return true
+}
 
-	// Set the K bits.
+// indices stores the buckets of the data in the index buffer.
+func (c *CountingBloomFilter) indices(data []byte) {
+	lower, upper := sizedHashKernel(data, c.hash, c.seed, c.m)
 	for i := uint(0); i < c.k; i++ {
-		c.indexBuffer[i] = (uint(lower) + uint(upper)*i) % c.m
-		if c.buckets.Get(c.indexBuffer[i]) == 0 {
-			member = false
+		c.indexBuffer[i] = reducedIndex(lower, upper, i, c.m, c.modulo)
+	}
+}
+
+// minimum returns the smallest value of the buckets in the index buffer.
+func (c *CountingBloomFilter) minimum() uint32 {
+	min := c.buckets.MaxBucketValueWide()
+	for _, idx := range c.indexBuffer {
+		if val := c.buckets.Get(idx); val < min {
+			min = val
 		}
 	}
+	return min
+}
 
-	if member {
-		for _, idx := range c.indexBuffer {
-			c.buckets.Increment(idx, -1)
+// add increments the buckets in the index buffer, counting an overflow if one
+// is saturated. It returns false if the saturation policy refused the
+// addition.
+func (c *CountingBloomFilter) add() bool {
+	max := c.buckets.MaxBucketValueWide()
+	for _, idx := range c.indexBuffer {
+		if c.buckets.Get(idx) == max {
+			c.overflows++
+			if c.saturation == SaturateRefuse {
+				return false
+			}
+			break
 		}
-		c.count--
 	}
 
-	return member
+	for _, idx := range c.indexBuffer {
+		c.buckets.Increment(idx, 1)
+	}
+	c.count++
+	return true
 }
 
 // Reset restores the Bloom filter to its original state. It returns the filter
@@ -148,11 +375,206 @@ func (c *CountingBloomFilter) TestAndRemove(data []byte) bool {
 func (c *CountingBloomFilter) Reset() *CountingBloomFilter {
 	c.buckets.Reset()
 	c.count = 0
+	c.overflows = 0
 	return c
 }
 
+// Close releases the storage backing the filter's buckets, such as a
+// BucketStore implementing io.Closer. The filter must not be used afterwards.
+func (c *CountingBloomFilter) Close() error {
+	return c.buckets.Close()
+}
+
+// WriteTo writes a binary representation of the CountingBloomFilter to an I/O
+// stream. The hash function is not written, but the seed is. It returns the
+// number of bytes written.
+func (c *CountingBloomFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(c.m))
+	e.write(uint64(c.k))
+	e.write(uint64(c.count))
+	e.writeTo(c.buckets)
+	e.write(c.seed)
+	e.write(uint8(c.saturation))
+	e.write(uint64(c.overflows))
+	writeReduction(&e, c.modulo)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a CountingBloomFilter (such as
+// might have been written by WriteTo()) from an I/O stream. The filter keeps
+// its current hash function, which must match the one used by the filter that
+// was written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (c *CountingBloomFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d           = decoder{r: payload}
+		m, k, count uint64
+		buckets     = &Buckets{}
+	)
+	d.read(&m)
+	d.read(&k)
+	d.read(&count)
+	d.readFrom(buckets)
+	var (
+		seed       uint64
+		saturation uint8
+		overflows  uint64
+	)
+	if payload.Len() > 0 {
+		// Written by format version 2.3 or later.
+		d.read(&seed)
+	}
+	if payload.Len() > 0 {
+		// Written with a saturation policy.
+		d.read(&saturation)
+		d.read(&overflows)
+	}
+	modulo, err := readReduction(&d, payload)
+	if err != nil {
+		return n, err
+	}
+
+	if buckets.Count() != uint(m) || k > m {
+		return n, errors.New("buckets don't match filter size")
+	}
+	if SaturationPolicy(saturation) > SaturateRefuse {
+		return n, errors.New("unknown saturation policy")
+	}
+
+	c.buckets = buckets
+	c.m = uint(m)
+	c.k = uint(k)
+	c.count = uint(count)
+	c.indexBuffer = make([]uint, k)
+	c.seed = seed
+	c.saturation = SaturationPolicy(saturation)
+	c.overflows = uint(overflows)
+	c.modulo = modulo
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (c *CountingBloomFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(c)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo. If the filter
+// has no hash function, such as when it's the zero value, the default hash
+// function is used.
+func (c *CountingBloomFilter) UnmarshalBinary(data []byte) error {
+	if c.hash == nil {
+		c.hash = newFNV64()
+	}
+	return unmarshalBinary(c, data)
+}
+
+// Equal returns true if the other Counting Bloom Filter has the same
+// parameters, hash seed, reduction of hashes to indices, saturation policy,
+// number of items, and bucket values. The hash functions and overflow counts
+// aren't compared.
+func (c *CountingBloomFilter) Equal(other *CountingBloomFilter) bool {
+	return c.m == other.m && c.k == other.k && c.count == other.count &&
+		c.seed == other.seed && c.modulo == other.modulo && c.saturation == other.saturation &&
+		c.buckets.Equal(other.buckets)
+}
+
+// Clone returns an independent copy of the Counting Bloom Filter, which can be
+// used concurrently with the original. The copy's buckets are held in memory.
+func (c *CountingBloomFilter) Clone() *CountingBloomFilter {
+	clone := *c
+	clone.buckets = c.buckets.Clone()
+	clone.hash = cloneHash64(c.hash)
+	clone.indexBuffer = make([]uint, len(c.indexBuffer))
+	return &clone
+}
+
+// Flatten returns a classic Bloom filter with a bit set for each nonzero
+// bucket, which tests the same as the Counting Bloom Filter in an eighth or
+// less of the memory, for distribution to consumers which don't remove items.
+// It has the same size, hash functions, seed, reduction of hashes to indices,
+// and count, and the hash function is cloned. It's independent of the Counting
+// Bloom Filter, so later changes to either aren't reflected in the other.
+func (c *CountingBloomFilter) Flatten() *BloomFilter {
+	buckets := NewBuckets(c.m, 1)
+	for i := uint(0); i < c.m; i++ {
+		if c.buckets.Get(i) != 0 {
+			buckets.Set(i, 1)
+		}
+	}
+	return &BloomFilter{
+		buckets: buckets,
+		hash:    cloneHash64(c.hash),
+		m:       c.m,
+		k:       c.k,
+		count:   c.count,
+		seed:    c.seed,
+		scheme:  defaultScheme(c.m),
+		modulo:  c.modulo,
+	}
+}
+
+// NewCountingBloomFilterFromBloomFilter creates a new Counting Bloom Filter
+// with buckets of b bits holding the items of the classic Bloom filter, with a
+// bucket of one for each set bit. It has the same size, hash functions, seed,
+// reduction of hashes to indices, and count, and the hash function is cloned,
+// so it tests the same as the Bloom filter and items can be added and removed
+// as usual. The items already in the Bloom filter mustn't be removed, since
+// their buckets don't count how many of them share a bit, and removing one may
+// clear a bucket another relies on. The saturation policy is set with
+// WithSaturation, and other options are ignored. Returns an error if the Bloom
+// filter uses a hashing scheme other than the default, such as one created by
+// NewBloomFilterXXHash or NewGuavaBloomFilter.
+func NewCountingBloomFilterFromBloomFilter(filter *BloomFilter, b uint8, opts ...Option) (*CountingBloomFilter, error) {
+	if filter.scheme != defaultScheme(filter.m) {
+		return nil, errors.New("bloom filter doesn't use the default hashing scheme")
+	}
+
+	buckets := NewBuckets(filter.m, b)
+	for i := uint(0); i < filter.m; i++ {
+		if filter.buckets.Get(i) != 0 {
+			buckets.Set(i, 1)
+		}
+	}
+	return &CountingBloomFilter{
+		buckets:     buckets,
+		hash:        cloneHash64(filter.hash),
+		m:           filter.m,
+		k:           filter.k,
+		count:       filter.count,
+		indexBuffer: make([]uint, filter.k),
+		seed:        filter.seed,
+		saturation:  applyOptions(opts).saturation,
+		modulo:      filter.modulo,
+	}, nil
+}
+
+// ByteSize returns the number of bytes used by the buckets and metadata of the
+// Counting Bloom Filter, excluding the hash function.
+func (c *CountingBloomFilter) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*c)) + c.buckets.ByteSize() + uint64(cap(c.indexBuffer))*uintSize
+}
+
 // SetHash sets the hashing function used in the filter.
 // For the effect on false positive rates see: https://github.com/tylertreat/BoomFilters/pull/1
 func (c *CountingBloomFilter) SetHash(h hash.Hash64) {
 	c.hash = h
 }
+
+// CountingMemory returns the estimated number of bytes used by the buckets of
+// a CountingBloomFilter optimized to store n items with the specified bucket
+// size and target false-positive rate.
+func CountingMemory(n uint, b uint8, fpRate float64) uint64 {
+	return (uint64(OptimalM(n, fpRate))*uint64(b) + 7) / 8
+}
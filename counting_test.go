@@ -1,10 +1,57 @@
 package boom
 
 import (
+	"bytes"
 	"strconv"
 	"testing"
 )
 
+// Ensures that NewCountingBloomFilterForMultiplicity creates a filter whose
+// buckets don't saturate below the maximum multiplicity.
+func TestNewCountingBloomFilterForMultiplicity(t *testing.T) {
+	f := NewCountingBloomFilterForMultiplicity(100, 0.01, 1000)
+
+	if max := f.buckets.MaxBucketValueWide(); max < 1000 || max > 2047 {
+		t.Errorf("Expected max bucket value in [1000, 2047], got %d", max)
+	}
+
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(`a`))
+	}
+
+	if count := f.EstimatedCount([]byte(`a`)); count != 1000 {
+		t.Errorf("Expected 1000, got %d", count)
+	}
+
+	for i := 0; i < 999; i++ {
+		f.TestAndRemove([]byte(`a`))
+	}
+
+	if !f.Test([]byte(`a`)) {
+		t.Error("`a` should be a member")
+	}
+}
+
+// Ensures that NewCountingBloomFilterForMultiplicity caps buckets at 31 bits,
+// whose values still count correctly.
+func TestNewCountingBloomFilterForMultiplicityMax(t *testing.T) {
+	for _, multiplicity := range []uint64{1<<31 - 1, 1 << 31, 1 << 32, 1<<64 - 1} {
+		if uint64(uint(multiplicity)) != multiplicity {
+			continue
+		}
+
+		f := NewCountingBloomFilterForMultiplicity(100, 0.01, uint(multiplicity))
+		if max := f.buckets.MaxBucketValueWide(); max != 1<<31-1 {
+			t.Errorf("Expected max bucket value %d for %d, got %d", 1<<31-1, multiplicity, max)
+		}
+
+		f.Add([]byte(`a`))
+		if count := f.EstimatedCount([]byte(`a`)); count != 1 {
+			t.Errorf("Expected 1 for %d, got %d", multiplicity, count)
+		}
+	}
+}
+
 // Ensures that Capacity returns the number of bits, m, in the Bloom filter.
 func TestCountingCapacity(t *testing.T) {
 	f := NewDefaultCountingBloomFilter(100, 0.1)
@@ -39,6 +86,77 @@ func TestCountingCount(t *testing.T) {
 	}
 }
 
+// Ensures that EstimatedCount returns the number of times the data was added
+// without modifying the filter.
+func TestCountingEstimatedCount(t *testing.T) {
+	f := NewDefaultCountingBloomFilter(100, 0.01)
+	for i := 0; i < 10; i++ {
+		f.Add([]byte(`a`))
+	}
+
+	if count := f.EstimatedCount([]byte(`a`)); count != 10 {
+		t.Errorf("Expected 10, got %d", count)
+	}
+
+	if count := f.EstimatedCount([]byte(`a`)); count != 10 {
+		t.Errorf("Expected 10, got %d", count)
+	}
+
+	if count := f.EstimatedCountString("a"); count != 10 {
+		t.Errorf("Expected 10, got %d", count)
+	}
+
+	if count := f.EstimatedCount([]byte(`b`)); count != 0 {
+		t.Errorf("Expected 0, got %d", count)
+	}
+
+	// Counts are limited by the maximum bucket value.
+	for i := 0; i < 10; i++ {
+		f.Add([]byte(`a`))
+	}
+	if count := f.EstimatedCount([]byte(`a`)); count != 15 {
+		t.Errorf("Expected 15, got %d", count)
+	}
+
+	if count := f.Count(); count != 20 {
+		t.Errorf("Expected 20, got %d", count)
+	}
+}
+
+// Ensures that SeenExactlyOnce identifies data which was added once.
+func TestCountingSeenExactlyOnce(t *testing.T) {
+	f := NewDefaultCountingBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+		if i%2 == 0 {
+			f.Add([]byte(strconv.Itoa(i)))
+		}
+	}
+
+	once, twice := 0, 0
+	for i := 0; i < 1000; i++ {
+		if f.SeenExactlyOnce([]byte(strconv.Itoa(i))) {
+			if i%2 == 0 {
+				twice++
+			} else {
+				once++
+			}
+		}
+	}
+
+	if once < 450 {
+		t.Errorf("Expected most of the 500 once-seen keys to be identified, got %d", once)
+	}
+
+	if twice != 0 {
+		t.Errorf("Expected no twice-seen keys to be identified, got %d", twice)
+	}
+
+	if f.SeenExactlyOnce([]byte(`x`)) {
+		t.Error("`x` should not have been seen")
+	}
+}
+
 // Ensures that Test, Add, and TestAndAdd behave correctly.
 func TestCountingTestAndAdd(t *testing.T) {
 	f := NewDefaultCountingBloomFilter(100, 0.1)
@@ -191,3 +309,176 @@ func BenchmarkCountingTestAndRemove(b *testing.B) {
 		f.TestAndRemove(data[n])
 	}
 }
+
+// Ensures that 16-bit buckets count beyond the default bucket size without
+// overflowing.
+func TestCountingWideBuckets(t *testing.T) {
+	f := NewCountingBloomFilter(100, 16, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(`a`))
+	}
+
+	if count := f.EstimatedCount([]byte(`a`)); count != 1000 {
+		t.Errorf("Expected 1000, got %d", count)
+	}
+	if overflows := f.OverflowCount(); overflows != 0 {
+		t.Errorf("Expected 0, got %d", overflows)
+	}
+}
+
+// Ensures that each saturation policy keeps or refuses saturated buckets and
+// counts the overflows.
+func TestCountingSaturation(t *testing.T) {
+	clamp := NewCountingBloomFilter(100, 2, 0.01)
+	sticky := NewCountingBloomFilter(100, 2, 0.01, WithSaturation(SaturateSticky))
+	refuse := NewCountingBloomFilter(100, 2, 0.01, WithSaturation(SaturateRefuse))
+
+	for i := 0; i < 5; i++ {
+		clamp.Add([]byte(`a`))
+		sticky.Add([]byte(`a`))
+		if err := refuse.TryAdd([]byte(`a`)); (err != nil) != (i >= 3) {
+			t.Errorf("Unexpected error %v at %d", err, i)
+		}
+	}
+
+	for _, f := range []*CountingBloomFilter{clamp, sticky, refuse} {
+		if overflows := f.OverflowCount(); overflows != 2 {
+			t.Errorf("Expected 2, got %d", overflows)
+		}
+	}
+	if count := refuse.Count(); count != 3 {
+		t.Errorf("Expected 3, got %d", count)
+	}
+
+	// Three removals clear `a` unless its buckets are sticky.
+	for i := 0; i < 3; i++ {
+		clamp.TestAndRemove([]byte(`a`))
+		sticky.TestAndRemove([]byte(`a`))
+		refuse.TestAndRemove([]byte(`a`))
+	}
+	if clamp.Test([]byte(`a`)) {
+		t.Error("`a` should not be a member")
+	}
+	if !sticky.Test([]byte(`a`)) {
+		t.Error("`a` should be a member")
+	}
+	if refuse.Test([]byte(`a`)) {
+		t.Error("`a` should not be a member")
+	}
+
+	var buf bytes.Buffer
+	if _, err := sticky.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var read CountingBloomFilter
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !read.Equal(sticky) || read.OverflowCount() != 2 {
+		t.Error("Expected the policy and overflow count to be read")
+	}
+}
+
+// Ensures that Flatten returns a Bloom filter testing the same as the Counting
+// Bloom Filter, and that a Counting Bloom Filter promoted from it does too.
+func TestCountingFlatten(t *testing.T) {
+	f := NewDefaultCountingBloomFilter(1000, 0.01, WithSeed(7))
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	for i := 0; i < 500; i++ {
+		f.TestAndRemove([]byte(strconv.Itoa(i)))
+		f.TestAndRemove([]byte(strconv.Itoa(i)))
+	}
+
+	b := f.Flatten()
+	promoted, err := NewCountingBloomFilterFromBloomFilter(b, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2000; i++ {
+		data := []byte(strconv.Itoa(i))
+		if member := f.Test(data); b.Test(data) != member || promoted.Test(data) != member {
+			t.Errorf("Expected %t for %d", member, i)
+		}
+	}
+	if b.Count() != f.Count() || promoted.Count() != f.Count() {
+		t.Errorf("Expected %d, got %d and %d", f.Count(), b.Count(), promoted.Count())
+	}
+
+	// New items can be added to and removed from the promoted filter.
+	promoted.Add([]byte(`new`))
+	if !promoted.TestAndRemove([]byte(`new`)) || promoted.Test([]byte(`new`)) {
+		t.Error("`new` should have been removed")
+	}
+
+	if _, err := NewCountingBloomFilterFromBloomFilter(NewBloomFilterXXHash(100, 0.01), 4); err == nil {
+		t.Error("Expected an error for the xxHash scheme")
+	}
+}
+
+// Ensures that TestAndAddCount returns the membership before the add and the
+// estimated count after it.
+func TestCountingTestAndAddCount(t *testing.T) {
+	f := NewDefaultCountingBloomFilter(100, 0.01)
+
+	for i := uint(1); i <= 5; i++ {
+		member, count := f.TestAndAddCount([]byte(`a`))
+		if member != (i > 1) {
+			t.Errorf("Expected %t, got %t", i > 1, member)
+		}
+		if count != i {
+			t.Errorf("Expected %d, got %d", i, count)
+		}
+	}
+
+	if member, count := f.TestAndAddCount([]byte(`b`)); member || count != 1 {
+		t.Errorf("Expected false, 1, got %t, %d", member, count)
+	}
+
+	if count := f.Count(); count != 6 {
+		t.Errorf("Expected 6, got %d", count)
+	}
+}
+
+// Ensures that a CountingBloomFilter read from its written representation has
+// the same counts.
+func TestCountingWriteToReadFrom(t *testing.T) {
+	f := NewDefaultCountingBloomFilter(1000, 0.01)
+	for i := 0; i < 500; i++ {
+		f.Add([]byte(strconv.Itoa(i % 100)))
+	}
+
+	restored := NewCountingBloomFilter(10, 1, 0.1)
+	var buf bytes.Buffer
+	wn, err := f.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	rn, err := restored.ReadFrom(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if wn != rn {
+		t.Errorf("Expected %d bytes read, got %d", wn, rn)
+	}
+
+	if restored.Capacity() != f.Capacity() || restored.K() != f.K() || restored.Count() != f.Count() {
+		t.Errorf("Expected %d, %d, %d, got %d, %d, %d", f.Capacity(), f.K(), f.Count(),
+			restored.Capacity(), restored.K(), restored.Count())
+	}
+
+	for i := 0; i < 200; i++ {
+		data := []byte(strconv.Itoa(i))
+		if restored.EstimatedCount(data) != f.EstimatedCount(data) {
+			t.Errorf("Expected %d for %s, got %d", f.EstimatedCount(data), data, restored.EstimatedCount(data))
+		}
+	}
+
+	if !restored.TestAndRemove([]byte(`1`)) {
+		t.Error("`1` should be a member")
+	}
+}
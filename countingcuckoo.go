@@ -0,0 +1,326 @@
+package boom
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"unsafe"
+)
+
+// CountingCuckooFilter is a Cuckoo Filter which counts the multiplicity of
+// each item. A Cuckoo Filter stores a fingerprint for every insertion, so an
+// item inserted more times than its two buckets have entries fills them and
+// fails, removing another item to make room. A Counting Cuckoo Filter instead
+// keeps a counter alongside each fingerprint, which repeated insertions of
+// the item increment and removals decrement, so duplicates take no extra
+// entries and an item remains a member until it has been removed as many
+// times as it was added.
+//
+// Count returns the multiplicity of an item, which is approximate since
+// other items may share its fingerprint and buckets, in which case their
+// counts are included. An insertion which fails because the filter is full
+// doesn't remove any item.
+type CountingCuckooFilter struct {
+	filter *CuckooFilter // fingerprints, parameters, and hash function
+	counts [][]uint32    // multiplicity of each entry
+	total  uint64        // number of insertions not yet removed
+}
+
+// NewCountingCuckooFilter creates a new Counting Cuckoo Filter optimized to
+// store n distinct items with a specified target false-positive rate.
+func NewCountingCuckooFilter(n uint, fpRate float64, opts ...Option) *CountingCuckooFilter {
+	c := &CountingCuckooFilter{filter: NewCuckooFilter(n, fpRate, opts...)}
+	c.counts = newCuckooCounts(c.filter.m, c.filter.b)
+	return c
+}
+
+// Buckets returns the number of buckets.
+func (c *CountingCuckooFilter) Buckets() uint {
+	return c.filter.m
+}
+
+// Capacity returns the number of distinct items the filter can store.
+func (c *CountingCuckooFilter) Capacity() uint {
+	return c.filter.n
+}
+
+// Entries returns the number of occupied entries, which is the number of
+// distinct items in the filter, less those sharing a fingerprint and bucket.
+func (c *CountingCuckooFilter) Entries() uint {
+	return c.filter.count
+}
+
+// TotalCount returns the number of insertions which haven't been removed.
+func (c *CountingCuckooFilter) TotalCount() uint64 {
+	return c.total
+}
+
+// Count returns the approximate number of times the data has been added and
+// not removed, which is zero if it's not a member. The count may include
+// other items sharing the data's fingerprint and one of its buckets.
+func (c *CountingCuckooFilter) Count(data []byte) uint64 {
+	var (
+		buf       [4]byte
+		i1, i2, f = c.filter.components(data, &buf)
+		count     uint64
+	)
+	for _, i := range c.buckets(i1, i2) {
+		if idx := c.filter.buckets[i].indexOf(f); idx != -1 {
+			count += uint64(c.counts[i][idx])
+		}
+	}
+	return count
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. This is a probabilistic test, meaning there is a
+// non-zero probability of false positives.
+func (c *CountingCuckooFilter) Test(data []byte) bool {
+	return c.filter.Test(data)
+}
+
+// Add will add the data to the filter, incrementing its count if it's already
+// a member. It returns an error, leaving the filter unchanged, if the filter
+// is full or the count would overflow.
+func (c *CountingCuckooFilter) Add(data []byte) error {
+	var buf [4]byte
+	i1, i2, f := c.filter.components(data, &buf)
+	for _, i := range c.buckets(i1, i2) {
+		if idx := c.filter.buckets[i].indexOf(f); idx != -1 {
+			if c.counts[i][idx] == math.MaxUint32 {
+				return errors.New("count overflow")
+			}
+			c.counts[i][idx]++
+			c.total++
+			return nil
+		}
+	}
+
+	if !c.insert(i1, i2, append([]byte(nil), f...)) {
+		return errors.New("full")
+	}
+	c.total++
+	return nil
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data was a member, false if not, and an error if it couldn't be added.
+func (c *CountingCuckooFilter) TestAndAdd(data []byte) (bool, error) {
+	member := c.Test(data)
+	return member, c.Add(data)
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (c *CountingCuckooFilter) TestString(data string) bool {
+	return c.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied.
+func (c *CountingCuckooFilter) AddString(data string) error {
+	return c.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (c *CountingCuckooFilter) TestAndAddString(data string) (bool, error) {
+	return c.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter, stopping at the first error
+// returned by Add. It returns the number of items added, which are the first
+// ones in data, and the error, if any.
+func (c *CountingCuckooFilter) AddMany(data [][]byte) (int, error) {
+	return addAllContext(context.Background(), data, c.Add)
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+func (c *CountingCuckooFilter) TestMany(data [][]byte) []bool {
+	return testMany(data, c.Test)
+}
+
+// TestAndRemove will test for membership of the data and decrement its count,
+// removing it from the filter once the count reaches zero. Returns true if the
+// data was a member, false if not.
+func (c *CountingCuckooFilter) TestAndRemove(data []byte) bool {
+	var buf [4]byte
+	i1, i2, f := c.filter.components(data, &buf)
+	for _, i := range c.buckets(i1, i2) {
+		b := c.filter.buckets[i]
+		if idx := b.indexOf(f); idx != -1 {
+			c.total--
+			if c.counts[i][idx]--; c.counts[i][idx] == 0 {
+				b[idx] = nil
+				c.filter.count--
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Reset restores the filter to its original state. It returns the filter to
+// allow for chaining.
+func (c *CountingCuckooFilter) Reset() *CountingCuckooFilter {
+	c.filter.Reset()
+	c.counts = newCuckooCounts(c.filter.m, c.filter.b)
+	c.total = 0
+	return c
+}
+
+// ByteSize returns the number of bytes used by the buckets, including their
+// fingerprints and counts, and metadata of the Counting Cuckoo Filter. The
+// hash function and source of randomness aren't included.
+func (c *CountingCuckooFilter) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*c)) + c.filter.ByteSize() + uint64(cap(c.counts))*sliceSize
+	for _, counts := range c.counts {
+		size += uint64(cap(counts)) * 4
+	}
+	return size
+}
+
+// buckets returns the distinct buckets of the hash values.
+func (c *CountingCuckooFilter) buckets(i1, i2 uint) []uint {
+	buckets := [2]uint{i1 % c.filter.m, i2 % c.filter.m}
+	if buckets[0] == buckets[1] {
+		return buckets[:1]
+	}
+	return buckets[:]
+}
+
+// insert inserts the fingerprint with a count of one into one of its buckets,
+// relocating existing entries along with their counts if both are full.
+// Returns false if the filter is full, in which case the relocations are
+// undone.
+func (c *CountingCuckooFilter) insert(i1, i2 uint, f []byte) bool {
+	cf := c.filter
+	for _, i := range [2]uint{i1 % cf.m, i2 % cf.m} {
+		if idx, err := cf.buckets[i].getEmptyEntry(); err == nil {
+			cf.buckets[i][idx] = f
+			c.counts[i][idx] = 1
+			cf.count++
+			return true
+		}
+	}
+
+	var (
+		path  []uint // entries swapped
+		count = uint32(1)
+		i     = i1
+	)
+	for n := 0; n < maxNumKicks; n++ {
+		bucketIdx := i % cf.m
+		entryIdx := cf.intn(int(cf.b))
+		f, cf.buckets[bucketIdx][entryIdx] = cf.buckets[bucketIdx][entryIdx], f
+		count, c.counts[bucketIdx][entryIdx] = c.counts[bucketIdx][entryIdx], count
+		path = append(path, bucketIdx*cf.b+uint(entryIdx))
+
+		i = i ^ uint(cf.computeHash(f))
+		if idx, err := cf.buckets[i%cf.m].getEmptyEntry(); err == nil {
+			cf.buckets[i%cf.m][idx] = f
+			c.counts[i%cf.m][idx] = count
+			cf.count++
+			return true
+		}
+	}
+
+	// Swap the entries back in reverse order.
+	for j := len(path) - 1; j >= 0; j-- {
+		bucket, entry := path[j]/cf.b, path[j]%cf.b
+		f, cf.buckets[bucket][entry] = cf.buckets[bucket][entry], f
+		count, c.counts[bucket][entry] = c.counts[bucket][entry], count
+	}
+	return false
+}
+
+// WriteTo writes a binary representation of the CountingCuckooFilter to an I/O
+// stream, the underlying Cuckoo Filter followed by the count of every entry.
+// The hash function is not written, but the seed is. It returns the number of
+// bytes written.
+func (c *CountingCuckooFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.writeTo(c.filter)
+	e.write(c.total)
+	for _, counts := range c.counts {
+		e.write(counts)
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a CountingCuckooFilter (such as
+// might have been written by WriteTo()) from an I/O stream. The filter keeps
+// its current hash function, which must match the one used by the filter that
+// was written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (c *CountingCuckooFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d      = decoder{r: payload}
+		filter = &CuckooFilter{hash: newFNV32()}
+		total  uint64
+	)
+	if c.filter != nil && c.filter.hash != nil {
+		filter.hash = c.filter.hash
+	}
+	d.readFrom(filter)
+	d.read(&total)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	// Every entry takes four bytes for its count.
+	if uint64(filter.m) > uint64(payload.Len())/4/uint64(filter.b) {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	counts := newCuckooCounts(filter.m, filter.b)
+	for i := range counts {
+		d.read(counts[i])
+		if d.err != nil {
+			return n, d.err
+		}
+		for j, count := range counts[i] {
+			if (count == 0) != (filter.buckets[i][j] == nil) {
+				return n, errors.New("counts don't match filter")
+			}
+		}
+	}
+
+	c.filter = filter
+	c.counts = counts
+	c.total = total
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (c *CountingCuckooFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(c)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo. If the filter
+// has no hash function, such as when it's the zero value, the default hash
+// function is used.
+func (c *CountingCuckooFilter) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(c, data)
+}
+
+// newCuckooCounts returns the counts of m empty buckets with b entries each.
+func newCuckooCounts(m, b uint) [][]uint32 {
+	counts := make([][]uint32, m)
+	for i := range counts {
+		counts[i] = make([]uint32, b)
+	}
+	return counts
+}
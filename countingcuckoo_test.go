@@ -0,0 +1,100 @@
+package boom
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Ensures that duplicate insertions are counted in one entry and that an item
+// remains a member until removed as many times as it was added.
+func TestCountingCuckooDuplicates(t *testing.T) {
+	f := NewCountingCuckooFilter(100, 0.001)
+	for i := 0; i < 20; i++ {
+		if err := f.Add([]byte(`a`)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	f.Add([]byte(`b`))
+
+	if count := f.Count([]byte(`a`)); count != 20 {
+		t.Errorf("Expected count 20, got %d", count)
+	}
+	if f.Entries() != 2 || f.TotalCount() != 21 {
+		t.Errorf("Expected 2 entries and 21 insertions, got %d and %d", f.Entries(), f.TotalCount())
+	}
+
+	for i := 0; i < 19; i++ {
+		if !f.TestAndRemove([]byte(`a`)) {
+			t.Fatalf("Expected `a` to be removed")
+		}
+	}
+	if !f.Test([]byte(`a`)) || f.Count([]byte(`a`)) != 1 {
+		t.Errorf("Expected `a` to remain a member with count 1, got %d", f.Count([]byte(`a`)))
+	}
+	f.TestAndRemove([]byte(`a`))
+	if f.Test([]byte(`a`)) || f.Count([]byte(`a`)) != 0 || f.Entries() != 1 {
+		t.Error("Expected `a` to be removed")
+	}
+	if f.TestAndRemove([]byte(`c`)) {
+		t.Error("Expected `c` not to be removed")
+	}
+}
+
+// Ensures that a full filter returns an error without removing any item.
+func TestCountingCuckooFull(t *testing.T) {
+	f := NewCountingCuckooFilter(10, 0.5, WithSeed(1))
+	added := 0
+	for ; added < 10000; added++ {
+		if err := f.Add([]byte(strconv.Itoa(added))); err != nil {
+			break
+		}
+	}
+	if added == 10000 {
+		t.Fatal("Expected the filter to become full")
+	}
+
+	for i := 0; i < added; i++ {
+		if !f.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+	if f.TotalCount() != uint64(added) {
+		t.Errorf("Expected %d insertions, got %d", added, f.TotalCount())
+	}
+}
+
+// Ensures that MarshalBinary and UnmarshalBinary round-trip the filter and its
+// counts.
+func TestCountingCuckooReadWrite(t *testing.T) {
+	f := NewCountingCuckooFilter(1000, 0.01)
+	for i := 0; i < 500; i++ {
+		for j := 0; j <= i%3; j++ {
+			f.Add([]byte(strconv.Itoa(i)))
+		}
+	}
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restored := &CountingCuckooFilter{}
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if restored.TotalCount() != f.TotalCount() || restored.Entries() != f.Entries() {
+		t.Errorf("Expected %d insertions and %d entries, got %d and %d",
+			f.TotalCount(), f.Entries(), restored.TotalCount(), restored.Entries())
+	}
+	for i := 0; i < 1000; i++ {
+		data := []byte(strconv.Itoa(i))
+		if restored.Count(data) != f.Count(data) {
+			t.Errorf("Expected count %d for %s, got %d", f.Count(data), data, restored.Count(data))
+		}
+	}
+
+	f.Reset()
+	if f.TotalCount() != 0 || f.Entries() != 0 || f.Test([]byte(`1`)) {
+		t.Error("Expected empty filter after reset")
+	}
+}
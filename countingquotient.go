@@ -0,0 +1,424 @@
+package boom
+
+import (
+	"context"
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"math/bits"
+	"unsafe"
+)
+
+// Metadata of a CountingQuotientFilter slot: the bits of a QuotientFilter slot
+// followed by one marking slots which hold digits of a counter.
+const (
+	cqfCounterBit   = qfMetadataBits
+	cqfCounter      = 1 << cqfCounterBit
+	cqfMetadataBits = qfMetadataBits + 1
+)
+
+// CountingQuotientFilter is a quotient filter which counts the multiplicity of
+// each item, answering both whether an item has been seen and approximately
+// how many times, inspired by the counting quotient filter described by
+// Pandey, Bender, Johnson, and Patro in A General-Purpose Counting Filter:
+// Making Every Bit Count:
+//
+// https://dl.acm.org/doi/10.1145/3035918.3035963
+//
+// Like a QuotientFilter, it stores a remainder per item in the sorted run of
+// its quotient, but a repeated item is stored once, followed in its run by a
+// variable-length counter: its count less one in digits of r bits, each in
+// its own slot marked by a fourth metadata bit. An item seen once takes a
+// single slot and an item seen a million times only a few more, so skewed
+// distributions, where most items are rare and a few are very frequent, take
+// little more space than their distinct items. The paper instead encodes
+// counters with the remainders themselves, saving the fourth metadata bit.
+//
+// Count returns the multiplicity of an item, which is approximate since other
+// items may share its fingerprint, in which case their counts are included.
+// Like a QuotientFilter, it grows once 90% of its slots are used.
+type CountingQuotientFilter struct {
+	quotientTable
+	used    uint        // number of non-empty slots
+	entries uint        // number of distinct fingerprints
+	total   uint64      // number of insertions not yet removed
+	hash    hash.Hash64 // hash function
+	seed    uint64      // hash seed (zero means unseeded)
+}
+
+// NewCountingQuotientFilter creates a new Counting Quotient Filter optimized
+// to store n distinct items with a specified target false-positive rate before
+// it grows.
+func NewCountingQuotientFilter(n uint, fpRate float64, opts ...Option) *CountingQuotientFilter {
+	var (
+		o    = applyOptions(opts)
+		q, r = quotientParams(n, fpRate, cqfMetadataBits)
+	)
+	return &CountingQuotientFilter{
+		quotientTable: newQuotientTable(q, r, cqfMetadataBits),
+		hash:          o.newHash64(newFNV64),
+		seed:          o.seed,
+	}
+}
+
+// Capacity returns the number of slots the filter can use before it grows.
+// Each distinct item takes a slot, plus those holding the digits of its count.
+func (c *CountingQuotientFilter) Capacity() uint {
+	return uint(quotientMaxLoad * float64(uint64(1)<<c.q))
+}
+
+// Entries returns the number of distinct items in the filter, less those
+// sharing a fingerprint.
+func (c *CountingQuotientFilter) Entries() uint {
+	return c.entries
+}
+
+// TotalCount returns the number of insertions which haven't been removed.
+func (c *CountingQuotientFilter) TotalCount() uint64 {
+	return c.total
+}
+
+// QuotientBits returns the number of quotient bits, the base-2 logarithm of
+// the number of slots.
+func (c *CountingQuotientFilter) QuotientBits() uint {
+	return c.q
+}
+
+// RemainderBits returns the number of remainder bits stored in each slot.
+func (c *CountingQuotientFilter) RemainderBits() uint {
+	return c.r
+}
+
+// EstimatedFPRate returns the estimated false-positive rate for the current
+// number of distinct items, the probability that one of their fingerprints of
+// q+r bits matches an absent item's.
+func (c *CountingQuotientFilter) EstimatedFPRate() float64 {
+	return 1 - math.Exp(-float64(c.entries)/math.Ldexp(1, int(c.q+c.r)))
+}
+
+// Count returns the approximate number of times the data has been added and
+// not removed, which is zero if it's not a member. The count may include
+// other items sharing the data's fingerprint.
+func (c *CountingQuotientFilter) Count(data []byte) uint64 {
+	_, count, _ := c.lookup(c.fingerprint(data))
+	return count
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. This is a probabilistic test, meaning there is a
+// non-zero probability of false positives but a zero probability of false
+// negatives.
+func (c *CountingQuotientFilter) Test(data []byte) bool {
+	return c.Count(data) > 0
+}
+
+// Add will add the data to the filter, incrementing its count if it's already
+// a member and growing the filter if 90% of its slots are used. It returns an
+// error, leaving the filter unchanged, if the count would overflow or the
+// filter is full and can't grow, because its remainders are down to a single
+// bit.
+func (c *CountingQuotientFilter) Add(data []byte) error {
+	fingerprint := c.fingerprint(data)
+	s, count, digits := c.lookup(fingerprint)
+	if count == math.MaxUint64 {
+		return errors.New("count overflow")
+	}
+
+	// A slot is always left empty, so some cluster starts after it. Growing
+	// moves the item, so it's looked up again.
+	if c.used >= c.Capacity() {
+		if c.Grow() == nil {
+			s, count, digits = c.lookup(fingerprint)
+		} else if uint64(c.used)+1 >= 1<<c.q {
+			return errors.New("full")
+		}
+	}
+
+	if count == 0 {
+		c.insertCount(fingerprint, 1)
+	} else {
+		quotient, _ := c.split(fingerprint)
+		c.setCount(quotient, s, digits, count+1)
+	}
+	c.total++
+	return nil
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data was a member, false if not, and an error if it couldn't be added.
+func (c *CountingQuotientFilter) TestAndAdd(data []byte) (bool, error) {
+	member := c.Test(data)
+	return member, c.Add(data)
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (c *CountingQuotientFilter) TestString(data string) bool {
+	return c.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied.
+func (c *CountingQuotientFilter) AddString(data string) error {
+	return c.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (c *CountingQuotientFilter) TestAndAddString(data string) (bool, error) {
+	return c.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter, stopping at the first error
+// returned by Add. It returns the number of items added, which are the first
+// ones in data, and the error, if any.
+func (c *CountingQuotientFilter) AddMany(data [][]byte) (int, error) {
+	return addAllContext(context.Background(), data, c.Add)
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+func (c *CountingQuotientFilter) TestMany(data [][]byte) []bool {
+	return testMany(data, c.Test)
+}
+
+// TestAndRemove will test for membership of the data and decrement its count,
+// removing it from the filter once the count reaches zero. Returns true if the
+// data was a member, false if not.
+func (c *CountingQuotientFilter) TestAndRemove(data []byte) bool {
+	fingerprint := c.fingerprint(data)
+	s, count, digits := c.lookup(fingerprint)
+	if count == 0 {
+		return false
+	}
+
+	quotient, _ := c.split(fingerprint)
+	if count == 1 {
+		c.remove(s, quotient)
+		c.used--
+		c.entries--
+	} else {
+		c.setCount(quotient, s, digits, count-1)
+	}
+	c.total--
+	return true
+}
+
+// Grow doubles the number of slots, moving the leading bit of every remainder
+// to its quotient, so the items keep their counts without being added again.
+// The false-positive rate at full load roughly doubles. Returns an error if
+// the remainders are down to a single bit.
+func (c *CountingQuotientFilter) Grow() error {
+	if c.r <= 1 {
+		return errors.New("remainders can't shrink")
+	}
+
+	grown := &CountingQuotientFilter{quotientTable: newQuotientTable(c.q+1, c.r-1, c.meta)}
+	c.iterate(func(fingerprint, count uint64) {
+		grown.insertCount(fingerprint, count)
+	})
+	c.quotientTable = grown.quotientTable
+	c.used = grown.used
+	return nil
+}
+
+// Reset restores the filter to its original state, keeping its current number
+// of slots. It returns the filter to allow for chaining.
+func (c *CountingQuotientFilter) Reset() *CountingQuotientFilter {
+	c.quotientTable = newQuotientTable(c.q, c.r, c.meta)
+	c.used = 0
+	c.entries = 0
+	c.total = 0
+	return c
+}
+
+// ByteSize returns the number of bytes used by the slots and metadata of the
+// Counting Quotient Filter, excluding the hash function.
+func (c *CountingQuotientFilter) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*c)) + uint64(cap(c.slots))*8
+}
+
+// SetHash sets the hashing function used in the filter, which must be the one
+// the items were added with.
+func (c *CountingQuotientFilter) SetHash(h hash.Hash64) {
+	c.hash = h
+}
+
+// fingerprint returns the q+r bit fingerprint of the data.
+func (c *CountingQuotientFilter) fingerprint(data []byte) uint64 {
+	return quotientFingerprint(data, c.hash, c.seed, c.q+c.r)
+}
+
+// lookup returns the slot holding the remainder of the fingerprint, its count,
+// and the number of slots following it which hold the digits of the count.
+// The count is zero if the fingerprint isn't in the filter.
+func (c *CountingQuotientFilter) lookup(fingerprint uint64) (uint64, uint64, uint) {
+	quotient, remainder := c.split(fingerprint)
+	if c.get(quotient)&qfOccupied == 0 {
+		return 0, 0, 0
+	}
+
+	s := c.runStart(quotient)
+	for {
+		var (
+			rem    = c.remainder(c.get(s))
+			value  uint64
+			digits uint
+			i      = c.next(s)
+		)
+		for ; c.get(i)&qfContinuation != 0 && c.isCounter(c.get(i)); i = c.next(i) {
+			value |= c.remainder(c.get(i)) << (digits * c.r)
+			digits++
+		}
+		if rem == remainder {
+			return s, value + 1, digits
+		}
+		if rem > remainder || c.get(i)&qfContinuation == 0 {
+			return 0, 0, 0
+		}
+		s = i
+	}
+}
+
+// insertCount adds the fingerprint, which must not be in the filter, with the
+// count.
+func (c *CountingQuotientFilter) insertCount(fingerprint, count uint64) {
+	quotient, _ := c.split(fingerprint)
+	s := c.insertSlot(fingerprint)
+	c.used++
+	c.entries++
+	c.setCount(quotient, s, 0, count)
+}
+
+// setCount sets the count of the remainder in slot s of the quotient's run,
+// followed by the given number of digit slots, inserting or removing digit
+// slots as needed.
+func (c *CountingQuotientFilter) setCount(quotient, s uint64, digits uint, count uint64) {
+	var (
+		value = count - 1
+		k     = uint(0)
+		mask  = uint64(1)<<c.r - 1
+	)
+	if value > 0 {
+		k = (uint(bits.Len64(value)) + c.r - 1) / c.r
+	}
+
+	i := c.next(s)
+	for d := uint(0); d < k; d++ {
+		digit := value >> (d * c.r) & mask
+		if d < digits {
+			// Keep the metadata bits, which belong to the slot.
+			c.set(i, c.get(i)&(1<<c.meta-1)|digit<<c.meta)
+		} else {
+			c.insertAt(quotient, i, digit<<c.meta|qfContinuation|cqfCounter)
+			c.used++
+		}
+		i = c.next(i)
+	}
+	for d := k; d < digits; d++ {
+		// Removing the slot shifts the next digit into it.
+		c.remove(i, quotient)
+		c.used--
+	}
+}
+
+// iterate calls fn with the fingerprint and count of every distinct item in
+// the filter.
+func (c *CountingQuotientFilter) iterate(fn func(fingerprint, count uint64)) {
+	var (
+		fingerprint, value uint64
+		digits             uint
+		pending            bool
+	)
+	c.iterateSlots(c.used, func(quotient, slot uint64) {
+		if c.isCounter(slot) {
+			value |= c.remainder(slot) << (digits * c.r)
+			digits++
+			return
+		}
+		if pending {
+			fn(fingerprint, value+1)
+		}
+		fingerprint, value, digits, pending = quotient<<c.r|c.remainder(slot), 0, 0, true
+	})
+	if pending {
+		fn(fingerprint, value+1)
+	}
+}
+
+// WriteTo writes a binary representation of the CountingQuotientFilter to an
+// I/O stream. The hash function is not written, but the seed is. It returns
+// the number of bytes written.
+func (c *CountingQuotientFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(c.q))
+	e.write(uint64(c.r))
+	e.write(uint64(c.used))
+	e.write(uint64(c.entries))
+	e.write(c.total)
+	e.write(c.seed)
+	e.write(c.slots)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a CountingQuotientFilter (such as
+// might have been written by WriteTo()) from an I/O stream. The filter keeps
+// its current hash function, which must match the one used by the filter that
+// was written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (c *CountingQuotientFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                                = decoder{r: payload}
+		q, r, used, entries, total, seed uint64
+	)
+	d.read(&q)
+	d.read(&r)
+	d.read(&used)
+	d.read(&entries)
+	d.read(&total)
+	d.read(&seed)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	table, err := readQuotientTable(&d, payload, q, r, cqfMetadataBits)
+	if err != nil {
+		return n, err
+	}
+	if used >= 1<<q || entries > used || total < entries || !table.valid(used) {
+		return n, errors.New("counts don't match filter")
+	}
+
+	c.quotientTable = table
+	c.used = uint(used)
+	c.entries = uint(entries)
+	c.total = total
+	c.seed = seed
+	if c.hash == nil {
+		c.hash = newFNV64()
+	}
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (c *CountingQuotientFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(c)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo. If the
+// filter has no hash function, such as when it's the zero value, the default
+// hash function is used.
+func (c *CountingQuotientFilter) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(c, data)
+}
@@ -0,0 +1,147 @@
+package boom
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// Ensures that a Counting Quotient Filter matches the counts of a multiset of
+// fingerprints through random insertions and removals with a skewed
+// distribution, including counts spanning several digits and growth.
+func TestCountingQuotientFilterRandom(t *testing.T) {
+	for _, params := range []struct {
+		n      uint
+		fpRate float64
+	}{{8, 1.0 / 4096}, {256, 1.0 / 8}} {
+		var (
+			c     = NewCountingQuotientFilter(params.n, params.fpRate, WithSeed(1))
+			model = make(map[uint64]uint64)
+			rng   = rand.New(rand.NewSource(1))
+			total uint64
+		)
+		for i := 0; i < 20000; i++ {
+			// Low keys are far more frequent than high ones.
+			key := []byte(strconv.Itoa(rng.Intn(1 + rng.Intn(400))))
+			fingerprint := c.fingerprint(key)
+			if rng.Intn(3) == 0 {
+				if removed := c.TestAndRemove(key); removed != (model[fingerprint] > 0) {
+					t.Fatalf("Expected removal of %s to be %t at step %d", key, !removed, i)
+				}
+				if model[fingerprint] > 0 {
+					model[fingerprint]--
+					total--
+				}
+			} else {
+				if err := c.Add(key); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				model[fingerprint]++
+				total++
+			}
+
+			if count := c.Count(key); count != model[c.fingerprint(key)] {
+				t.Fatalf("Expected count %d of %s at step %d, got %d", model[c.fingerprint(key)], key, i, count)
+			}
+		}
+
+		var entries, used uint
+		c.iterate(func(fingerprint, count uint64) {
+			if model[fingerprint] != count {
+				t.Errorf("Expected count %d of fingerprint %d, got %d", model[fingerprint], fingerprint, count)
+			}
+			entries++
+			used++
+			for value := count - 1; value > 0; value >>= c.RemainderBits() {
+				used++
+			}
+		})
+		distinct := uint(0)
+		for _, count := range model {
+			if count > 0 {
+				distinct++
+			}
+		}
+		if entries != distinct || c.Entries() != distinct || used != c.used || c.TotalCount() != total {
+			t.Errorf("Expected %d entries in %d slots with total %d, got %d and %d in %d slots with total %d",
+				distinct, used, total, entries, c.Entries(), c.used, c.TotalCount())
+		}
+	}
+}
+
+// Ensures that a Counting Quotient Filter counts frequent items in few slots
+// and keeps their counts when it grows.
+func TestCountingQuotientFilterCount(t *testing.T) {
+	c := NewCountingQuotientFilter(100, 0.0001)
+	for i := 0; i < 100000; i++ {
+		c.Add([]byte(`a`))
+	}
+	// The count takes 17 bits, two digits of 14 bits.
+	if c.Count([]byte(`a`)) != 100000 || c.used != 3 || c.Entries() != 1 {
+		t.Errorf("Expected count 100000 in 3 slots, got %d in %d", c.Count([]byte(`a`)), c.used)
+	}
+
+	q := c.QuotientBits()
+	for i := 0; i < 1000; i++ {
+		if err := c.Add([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if c.QuotientBits() <= q {
+		t.Errorf("Expected the filter to have grown beyond %d quotient bits", q)
+	}
+	if c.Count([]byte(`a`)) != 100000 || c.Count([]byte(`1`)) != 1 || c.Count([]byte(`x`)) != 0 {
+		t.Errorf("Expected counts to be kept, got %d, %d, and %d",
+			c.Count([]byte(`a`)), c.Count([]byte(`1`)), c.Count([]byte(`x`)))
+	}
+
+	if !c.TestAndRemove([]byte(`a`)) || c.Count([]byte(`a`)) != 99999 || c.TotalCount() != 100999 {
+		t.Errorf("Expected count 99999 of total 100999, got %d of %d", c.Count([]byte(`a`)), c.TotalCount())
+	}
+	if c.Reset(); c.Test([]byte(`a`)) || c.TotalCount() != 0 || c.Entries() != 0 {
+		t.Error("Expected reset filter to be empty")
+	}
+}
+
+// Ensures that a Counting Quotient Filter read by ReadFrom reports the same
+// counts as the one written by WriteTo.
+func TestCountingQuotientFilterReadWrite(t *testing.T) {
+	c := NewCountingQuotientFilter(100, 0.01, WithSeed(2))
+	for i := 0; i < 1000; i++ {
+		c.Add([]byte(strconv.Itoa(i % 50)))
+	}
+
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data := append([]byte(nil), buf.Bytes()...)
+
+	restored := &CountingQuotientFilter{}
+	if _, err := restored.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		key := []byte(strconv.Itoa(i))
+		if restored.Count(key) != c.Count(key) {
+			t.Errorf("Expected count %d of %d, got %d", c.Count(key), i, restored.Count(key))
+		}
+	}
+
+	if err := restored.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Error("Expected error for truncated data")
+	}
+}
+
+func BenchmarkCountingQuotientFilterAdd(b *testing.B) {
+	c := NewCountingQuotientFilter(uint(b.N), 0.001)
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i % 1000))
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		c.Add(data[n])
+	}
+}
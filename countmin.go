@@ -1,10 +1,14 @@
 package boom
 
 import (
+	"bytes"
+	"container/heap"
 	"errors"
 	"hash"
-	"hash/fnv"
+	"io"
 	"math"
+	"sort"
+	"unsafe"
 )
 
 // CountMinSketch implements a Count-Min Sketch as described by Cormode and
@@ -27,19 +31,32 @@ import (
 // processing requires fast, space-efficient solutions like the CMS. For
 // approximating set cardinality, refer to the HyperLogLog.
 type CountMinSketch struct {
-	matrix  [][]uint64  // count matrix
-	width   uint        // matrix width
-	depth   uint        // matrix depth
-	count   uint64      // number of items added
-	epsilon float64     // relative-accuracy factor
-	delta   float64     // relative-accuracy probability
-	hash    hash.Hash64 // hash function (kernel for all depth functions)
+	matrix       [][]uint64   // count matrix
+	width        uint         // matrix width
+	depth        uint         // matrix depth
+	count        uint64       // number of items added
+	epsilon      float64      // relative-accuracy factor
+	delta        float64      // relative-accuracy probability
+	hash         hash.Hash64  // hash function (kernel for all depth functions)
+	conservative bool         // only increment the smallest counters
+	seed         uint64       // hash seed (zero means unseeded)
+	hitters      *elementHeap // tracked heavy hitters, nil when not tracking
+	hitterLimit  uint         // maximum number of heavy hitters
+	hitterMin    uint64       // minimum estimate of a heavy hitter
+	modulo       bool         // reduce hashes to columns with a modulo
+}
+
+// HeavyHitter is an item tracked by a CountMinSketch along with its estimated
+// count.
+type HeavyHitter struct {
+	Data  []byte
+	Count uint64
 }
 
 // NewCountMinSketch creates a new Count-Min Sketch whose relative accuracy is
 // within a factor of epsilon with probability delta. Both of these parameters
 // affect the space and time complexity.
-func NewCountMinSketch(epsilon, delta float64) *CountMinSketch {
+func NewCountMinSketch(epsilon, delta float64, opts ...Option) *CountMinSketch {
 	var (
 		width  = uint(math.Ceil(math.E / epsilon))
 		depth  = uint(math.Ceil(math.Log(1 / delta)))
@@ -50,16 +67,69 @@ func NewCountMinSketch(epsilon, delta float64) *CountMinSketch {
 		matrix[i] = make([]uint64, width)
 	}
 
+	o := applyOptions(opts)
 	return &CountMinSketch{
 		matrix:  matrix,
 		width:   width,
 		depth:   depth,
 		epsilon: epsilon,
 		delta:   delta,
-		hash:    fnv.New64(),
+		hash:    o.newHash64(newFNV64),
+		seed:    o.seed,
+		modulo:  o.modulo,
 	}
 }
 
+// NewConservativeCountMinSketch creates a new Count-Min Sketch like
+// NewCountMinSketch using conservative update, which only increments an
+// item's counters holding its current estimate, raising them to the new
+// estimate, since the others already count other items. Estimates are never
+// lower than with the standard update and still never underestimate, but are
+// much closer to the true counts for skewed streams, where a few heavy
+// hitters inflate the counters of everything sharing them.
+func NewConservativeCountMinSketch(epsilon, delta float64, opts ...Option) *CountMinSketch {
+	c := NewCountMinSketch(epsilon, delta, opts...)
+	c.conservative = true
+	return c
+}
+
+// Conservative returns true if the sketch uses conservative update.
+func (c *CountMinSketch) Conservative() bool {
+	return c.conservative
+}
+
+// TrackHeavyHitters makes the sketch track the heavy hitters of the items added
+// from now on, the at most n items with the highest estimates which are at
+// least threshold, in a min-heap updated on each Add. This identifies the heavy
+// hitters in the same pass as estimating frequencies, without a separate TopK.
+// Each Add then also scans the heap, so n should be small. Calling it again
+// changes the limits, dropping the items which no longer qualify. It returns
+// the CountMinSketch to allow for chaining.
+func (c *CountMinSketch) TrackHeavyHitters(n uint, threshold uint64) *CountMinSketch {
+	if c.hitters == nil {
+		c.hitters = &elementHeap{}
+	}
+	c.hitterLimit = n
+	c.hitterMin = threshold
+	c.refreshHitters()
+	return c
+}
+
+// HeavyHitters returns the tracked heavy hitters with their current estimates,
+// from highest to lowest estimate, or nil if the sketch isn't tracking them.
+func (c *CountMinSketch) HeavyHitters() []HeavyHitter {
+	if c.hitters == nil {
+		return nil
+	}
+
+	hitters := make(heavyHitters, len(*c.hitters))
+	for i, element := range *c.hitters {
+		hitters[i] = HeavyHitter{Data: element.data, Count: c.Count(element.data)}
+	}
+	sort.Sort(hitters)
+	return hitters
+}
+
 // Epsilon returns the relative-accuracy factor, epsilon.
 func (c *CountMinSketch) Epsilon() float64 {
 	return c.epsilon
@@ -78,35 +148,65 @@ func (c *CountMinSketch) TotalCount() uint64 {
 // Add will add the data to the set. Returns the CountMinSketch to allow for
 // chaining.
 func (c *CountMinSketch) Add(data []byte) *CountMinSketch {
-	lower, upper := hashKernel(data, c.hash)
+	lower, upper := seededHashKernel(data, c.hash, c.seed)
 
-	// Increment count in each row.
-	for i := uint(0); i < c.depth; i++ {
-		c.matrix[i][(uint(lower)+uint(upper)*i)%c.width]++
+	if c.conservative {
+		// Raise the counters below the new estimate to it.
+		estimate := c.minimum(lower, upper) + 1
+		for i := uint(0); i < c.depth; i++ {
+			if counter := &c.matrix[i][c.column(lower, upper, i)]; *counter < estimate {
+				*counter = estimate
+			}
+		}
+	} else {
+		// Increment count in each row.
+		for i := uint(0); i < c.depth; i++ {
+			c.matrix[i][c.column(lower, upper, i)]++
+		}
 	}
 
 	c.count++
+	if c.hitters != nil {
+		c.track(data, c.minimum(lower, upper))
+	}
 	return c
 }
 
 // Count returns the approximate count for the specified item, correct within
 // epsilon * total count with a probability of delta.
 func (c *CountMinSketch) Count(data []byte) uint64 {
-	var (
-		lower, upper = hashKernel(data, c.hash)
-		count        = uint64(math.MaxUint64)
-	)
+	lower, upper := seededHashKernel(data, c.hash, c.seed)
+	return c.minimum(lower, upper)
+}
 
+// minimum returns the smallest counter of the item with the base hash values.
+func (c *CountMinSketch) minimum(lower, upper uint32) uint64 {
+	count := uint64(math.MaxUint64)
 	for i := uint(0); i < c.depth; i++ {
-		count = uint64(math.Min(float64(count),
-			float64(c.matrix[i][(uint(lower)+uint(upper)*i)%c.width])))
+		if counter := c.matrix[i][c.column(lower, upper, i)]; counter < count {
+			count = counter
+		}
 	}
 
 	return count
 }
 
-// Merge combines this CountMinSketch with another. Returns an error if the
-// matrix width and depth are not equal.
+// column returns the column of the row i derived from the base hash values,
+// reduced with a multiply-shift unless the sketch was created
+// WithModuloReduction.
+func (c *CountMinSketch) column(lower, upper uint32, i uint) uint {
+	if c.modulo {
+		return (uint(lower) + uint(upper)*i) % c.width
+	}
+	return fastRange32(lower+upper*uint32(i), c.width)
+}
+
+// Merge combines this CountMinSketch with another by summing their counters,
+// such as to combine sketches built on different shards into a global view.
+// The sketches must have the same epsilon, delta, hash seed, and reduction of
+// hashes to columns, and should use the same hash function. Returns an error
+// if they are incompatible, in which case this sketch is unchanged. Merging a
+// sketch using conservative update still never underestimates.
 func (c *CountMinSketch) Merge(other *CountMinSketch) error {
 	if c.depth != other.depth {
 		return errors.New("matrix depth must match")
@@ -116,6 +216,18 @@ func (c *CountMinSketch) Merge(other *CountMinSketch) error {
 		return errors.New("matrix width must match")
 	}
 
+	if c.epsilon != other.epsilon || c.delta != other.delta {
+		return errors.New("epsilon and delta must match")
+	}
+
+	if c.seed != other.seed {
+		return errors.New("hash seed must match")
+	}
+
+	if c.modulo != other.modulo {
+		return errors.New("index reduction must match")
+	}
+
 	for i := uint(0); i < c.depth; i++ {
 		for j := uint(0); j < c.width; j++ {
 			c.matrix[i][j] += other.matrix[i][j]
@@ -123,9 +235,96 @@ func (c *CountMinSketch) Merge(other *CountMinSketch) error {
 	}
 
 	c.count += other.count
+	if c.hitters != nil {
+		c.refreshHitters()
+		if other.hitters != nil {
+			for _, element := range *other.hitters {
+				c.track(element.data, c.Count(element.data))
+			}
+		}
+	}
 	return nil
 }
 
+// Equal returns true if the other CountMinSketch has the same parameters,
+// update mode, hash seed, reduction of hashes to columns, number of items
+// added, and counters. The hash functions and tracked heavy hitters aren't
+// compared.
+func (c *CountMinSketch) Equal(other *CountMinSketch) bool {
+	if c.width != other.width || c.depth != other.depth || c.count != other.count ||
+		c.epsilon != other.epsilon || c.delta != other.delta ||
+		c.conservative != other.conservative || c.seed != other.seed || c.modulo != other.modulo {
+		return false
+	}
+
+	for i, row := range c.matrix {
+		for j, counter := range row {
+			if counter != other.matrix[i][j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the CountMinSketch, which can be used
+// concurrently with the original.
+func (c *CountMinSketch) Clone() *CountMinSketch {
+	clone := *c
+	clone.hash = cloneHash64(c.hash)
+	clone.matrix = make([][]uint64, len(c.matrix))
+	for i, row := range c.matrix {
+		clone.matrix[i] = append([]uint64(nil), row...)
+	}
+	if c.hitters != nil {
+		hitters := make(elementHeap, len(*c.hitters))
+		for i, e := range *c.hitters {
+			hitters[i] = &element{data: append([]byte(nil), e.data...), freq: e.freq}
+		}
+		clone.hitters = &hitters
+	}
+	return &clone
+}
+
+// ByteSize returns the number of bytes used by the count matrix and metadata
+// of the sketch, excluding the hash function.
+func (c *CountMinSketch) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*c)) + uint64(cap(c.matrix))*sliceSize
+	for _, row := range c.matrix {
+		size += uint64(cap(row)) * 8
+	}
+	if c.hitters != nil {
+		size += sliceSize + uint64(cap(*c.hitters))*pointerSize
+		for _, e := range *c.hitters {
+			size += uint64(unsafe.Sizeof(*e)) + uint64(cap(e.data))
+		}
+	}
+	return size
+}
+
+// ApproxEqual indicates if two CountMinSketches approximately represent the
+// same multiset by comparing their matrices cell by cell. Cells are considered
+// equal if they differ by at most the tolerance relative to the larger of the
+// two. This is a quick equivalence check for replicated pipelines. Both
+// sketches must have the same matrix width and depth and use the same hash
+// function, otherwise false is returned.
+func ApproxEqual(a, b *CountMinSketch, tolerance float64) bool {
+	if a.width != b.width || a.depth != b.depth {
+		return false
+	}
+
+	for i := uint(0); i < a.depth; i++ {
+		for j := uint(0); j < a.width; j++ {
+			x, y := float64(a.matrix[i][j]), float64(b.matrix[i][j])
+			if math.Abs(x-y) > tolerance*math.Max(x, y) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
 // Reset restores the CountMinSketch to its original state. It returns itself
 // to allow for chaining.
 func (c *CountMinSketch) Reset() *CountMinSketch {
@@ -136,10 +335,322 @@ func (c *CountMinSketch) Reset() *CountMinSketch {
 
 	c.matrix = matrix
 	c.count = 0
+	if c.hitters != nil {
+		*c.hitters = (*c.hitters)[:0]
+	}
+	return c
+}
+
+// Decay halves every counter and the total count the number of times,
+// rounding down, so the sketch forgets old items exponentially, such as at a
+// fixed interval so estimates reflect recent traffic. Estimates still never
+// fall below the decayed true counts. Tracked heavy hitters whose estimates
+// fall below the threshold are dropped. It returns itself to allow for
+// chaining.
+func (c *CountMinSketch) Decay(halvings uint) *CountMinSketch {
+	if halvings == 0 {
+		return c
+	}
+
+	for _, row := range c.matrix {
+		for j := range row {
+			row[j] >>= halvings
+		}
+	}
+	c.count >>= halvings
+	c.refreshHitters()
+	return c
+}
+
+// Compact zeroes all but the keepFraction of counters with the highest values
+// across the matrix, making the sketch cheaper to ship in a sparse encoding.
+// This introduces a bias: the Count-Min Sketch normally never underestimates,
+// but the estimate for an item with any zeroed counter drops to zero, so
+// infrequent items are underestimated while heavy hitters, whose counters are
+// high in every row, are unaffected. TotalCount is unchanged, and tracked heavy
+// hitters whose estimates fall below the threshold are dropped. It returns
+// itself to allow for chaining.
+func (c *CountMinSketch) Compact(keepFraction float64) *CountMinSketch {
+	var (
+		cells  = c.width * c.depth
+		keep   = uint(math.Ceil(math.Max(0, math.Min(1, keepFraction)) * float64(cells)))
+		values = make(uint64Slice, 0, cells)
+	)
+
+	if keep == cells {
+		return c
+	}
+
+	for _, row := range c.matrix {
+		values = append(values, row...)
+	}
+	sort.Sort(sort.Reverse(values))
+
+	var threshold uint64
+	if keep > 0 {
+		threshold = values[keep-1]
+	} else {
+		threshold = math.MaxUint64
+	}
+
+	// Keep every counter above the threshold and as many counters equal to
+	// it as are needed to retain keep cells.
+	ties := keep
+	for _, value := range values[:keep] {
+		if value > threshold {
+			ties--
+		}
+	}
+
+	for _, row := range c.matrix {
+		for j, value := range row {
+			if value < threshold {
+				row[j] = 0
+			} else if value == threshold {
+				if ties > 0 {
+					ties--
+				} else {
+					row[j] = 0
+				}
+			}
+		}
+	}
+
+	c.refreshHitters()
 	return c
 }
 
+// WriteTo writes a binary representation of the CountMinSketch to an I/O
+// stream. The hash function is not written, but the seed is. It returns the
+// number of bytes written.
+func (c *CountMinSketch) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(c.width))
+	e.write(uint64(c.depth))
+	e.write(c.count)
+	e.write(c.epsilon)
+	e.write(c.delta)
+	for _, row := range c.matrix {
+		e.write(row)
+	}
+	var conservative uint8
+	if c.conservative {
+		conservative = 1
+	}
+	e.write(conservative)
+	e.write(c.seed)
+	var hitters elementHeap
+	if c.hitters != nil {
+		hitters = *c.hitters
+		e.write(uint64(c.hitterLimit))
+	} else {
+		e.write(uint64(0))
+	}
+	e.write(c.hitterMin)
+	e.write(uint64(len(hitters)))
+	for _, element := range hitters {
+		e.write(element.freq)
+		e.writeBytes(element.data)
+	}
+	writeReduction(&e, c.modulo)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a CountMinSketch (such as might
+// have been written by WriteTo()) from an I/O stream, restoring its counters,
+// parameters, update mode, seed, and heavy hitters exactly. The sketch keeps
+// its current hash function, which must match the one used by the sketch that
+// was written, or uses the default one if it has none, such as when it's the
+// zero value. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (c *CountMinSketch) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                   = decoder{r: payload}
+		width, depth, count uint64
+		epsilon, delta      float64
+	)
+	d.read(&width)
+	d.read(&depth)
+	d.read(&count)
+	d.read(&epsilon)
+	d.read(&delta)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if width == 0 || depth == 0 {
+		return n, errors.New("invalid sketch parameters")
+	}
+	// Check the product by division, which can't overflow.
+	if limit := uint64(payload.Len()) / 8; width > limit || depth > limit/width {
+		return n, errors.New("matrix doesn't match sketch size")
+	}
+
+	matrix := make([][]uint64, depth)
+	for i := range matrix {
+		matrix[i] = make([]uint64, width)
+		d.read(matrix[i])
+	}
+	var (
+		conservative uint8
+		seed         uint64
+	)
+	if payload.Len() > 0 {
+		// Written with an update mode and seed.
+		d.read(&conservative)
+		d.read(&seed)
+	}
+	if d.err != nil {
+		return n, d.err
+	}
+	if conservative > 1 {
+		return n, errors.New("unknown update mode")
+	}
+
+	var (
+		limit, threshold, l uint64
+		hitters             *elementHeap
+	)
+	if payload.Len() > 0 {
+		// Written with heavy hitters, tracked if the limit isn't zero.
+		d.read(&limit)
+		d.read(&threshold)
+		d.read(&l)
+		if d.err != nil {
+			return n, d.err
+		}
+		if limit > math.MaxInt32 {
+			return n, errors.New("heavy hitter limit out of range")
+		}
+		if l > limit {
+			return n, errors.New("heavy hitters exceed limit")
+		}
+		// Every heavy hitter takes at least 16 bytes for its frequency and
+		// length.
+		if l > uint64(payload.Len())/16 {
+			return n, io.ErrUnexpectedEOF
+		}
+		if limit > 0 {
+			elements := make(elementHeap, 0, l)
+			for i := uint64(0); i < l && d.err == nil; i++ {
+				var freq uint64
+				d.read(&freq)
+				elements = append(elements, &element{data: d.readBytes(), freq: freq})
+			}
+			if d.err != nil {
+				return n, d.err
+			}
+			heap.Init(&elements)
+			hitters = &elements
+		}
+	}
+	modulo, err := readReduction(&d, payload)
+	if err != nil {
+		return n, err
+	}
+
+	if c.hash == nil {
+		c.hash = newFNV64()
+	}
+	c.matrix = matrix
+	c.width = uint(width)
+	c.depth = uint(depth)
+	c.count = count
+	c.epsilon = epsilon
+	c.delta = delta
+	c.conservative = conservative == 1
+	c.seed = seed
+	c.hitters = hitters
+	c.hitterLimit = uint(limit)
+	c.hitterMin = threshold
+	c.modulo = modulo
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (c *CountMinSketch) MarshalBinary() ([]byte, error) {
+	return marshalBinary(c)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo. If the sketch
+// has no hash function, such as when it's the zero value, the default hash
+// function is used.
+func (c *CountMinSketch) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(c, data)
+}
+
 // SetHash sets the hashing function used.
 func (c *CountMinSketch) SetHash(h hash.Hash64) {
 	c.hash = h
 }
+
+// track adds the data with the estimate to the heavy hitters if it qualifies,
+// replacing the one with the lowest estimate if there are already the maximum
+// number of them.
+func (c *CountMinSketch) track(data []byte, freq uint64) {
+	if freq < c.hitterMin || c.hitterLimit == 0 {
+		return
+	}
+
+	for i, element := range *c.hitters {
+		if bytes.Equal(data, element.data) {
+			element.freq = freq
+			heap.Fix(c.hitters, i)
+			return
+		}
+	}
+
+	if uint(c.hitters.Len()) >= c.hitterLimit {
+		if freq <= (*c.hitters)[0].freq {
+			return
+		}
+		heap.Pop(c.hitters)
+	}
+	heap.Push(c.hitters, &element{data: append([]byte(nil), data...), freq: freq})
+}
+
+// refreshHitters re-estimates the heavy hitters, such as after their counters
+// changed other than by Add, dropping those which no longer qualify.
+func (c *CountMinSketch) refreshHitters() {
+	if c.hitters == nil {
+		return
+	}
+
+	hitters := (*c.hitters)[:0]
+	for _, element := range *c.hitters {
+		if element.freq = c.Count(element.data); element.freq >= c.hitterMin {
+			hitters = append(hitters, element)
+		}
+	}
+	heap.Init(&hitters)
+	for uint(hitters.Len()) > c.hitterLimit {
+		heap.Pop(&hitters)
+	}
+	*c.hitters = hitters
+}
+
+// heavyHitters attaches the methods of sort.Interface to []HeavyHitter, from
+// highest to lowest count.
+type heavyHitters []HeavyHitter
+
+func (h heavyHitters) Len() int           { return len(h) }
+func (h heavyHitters) Less(i, j int) bool { return h[i].Count > h[j].Count }
+func (h heavyHitters) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+// uint64Slice attaches the methods of sort.Interface to []uint64.
+type uint64Slice []uint64
+
+func (u uint64Slice) Len() int           { return len(u) }
+func (u uint64Slice) Less(i, j int) bool { return u[i] < u[j] }
+func (u uint64Slice) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
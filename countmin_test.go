@@ -1,6 +1,8 @@
 package boom
 
 import (
+	"bytes"
+	"math"
 	"strconv"
 	"testing"
 )
@@ -54,6 +56,54 @@ func TestCMSAddAndCount(t *testing.T) {
 	}
 }
 
+// Ensures that conservative update never underestimates a skewed stream and
+// has a smaller total error than the standard update.
+func TestCMSConservative(t *testing.T) {
+	var (
+		standard     = NewCountMinSketch(0.01, 0.01)
+		conservative = NewConservativeCountMinSketch(0.01, 0.01)
+		counts       = make(map[string]uint64)
+	)
+	for i := 0; i < 2000; i++ {
+		// Item i is added about 2000/(i+1) times.
+		key := strconv.Itoa(i)
+		for j := 0; j < 2000/(i+1); j++ {
+			standard.Add([]byte(key))
+			conservative.Add([]byte(key))
+			counts[key]++
+		}
+	}
+
+	var standardError, conservativeError uint64
+	for key, count := range counts {
+		s, c := standard.Count([]byte(key)), conservative.Count([]byte(key))
+		if c < count || c > s {
+			t.Fatalf("Expected a count between %d and %d for %s, got %d", count, s, key, c)
+		}
+		standardError += s - count
+		conservativeError += c - count
+	}
+	if conservativeError*4 > standardError*3 {
+		t.Errorf("Expected conservative error below 3/4 of %d, got %d", standardError, conservativeError)
+	}
+	if !conservative.Conservative() || standard.Conservative() ||
+		conservative.TotalCount() != standard.TotalCount() {
+		t.Error("Expected the same total count with each update mode")
+	}
+
+	var buf bytes.Buffer
+	if _, err := conservative.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read := NewCountMinSketch(0.01, 0.01)
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !read.Equal(conservative) || !read.Conservative() {
+		t.Error("Expected the update mode to be read")
+	}
+}
+
 // Ensures that Merge combines the two sketches.
 func TestCMSMerge(t *testing.T) {
 	cms := NewCountMinSketch(0.001, 0.99)
@@ -93,6 +143,122 @@ func TestCMSMerge(t *testing.T) {
 	}
 }
 
+// Ensures that merging sketches built on shards equals a sketch of all the
+// data, and that sketches with different parameters or seeds aren't merged.
+func TestCMSMergeShards(t *testing.T) {
+	var (
+		all    = NewCountMinSketch(0.01, 0.01, WithSeed(5))
+		shards = []*CountMinSketch{
+			NewCountMinSketch(0.01, 0.01, WithSeed(5)),
+			NewCountMinSketch(0.01, 0.01, WithSeed(5)),
+			NewCountMinSketch(0.01, 0.01, WithSeed(5)),
+		}
+	)
+	for i := 0; i < 3000; i++ {
+		data := []byte(strconv.Itoa(i % 700))
+		all.Add(data)
+		shards[i%len(shards)].Add(data)
+	}
+	for _, shard := range shards[1:] {
+		if err := shards[0].Merge(shard); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !shards[0].Equal(all) {
+		t.Error("Expected the merged sketch to equal the sketch of all the data")
+	}
+
+	for _, other := range []*CountMinSketch{
+		NewCountMinSketch(0.02, 0.01, WithSeed(5)),
+		NewCountMinSketch(0.01, 0.001, WithSeed(5)),
+		NewCountMinSketch(0.01, 0.01, WithSeed(6)),
+		NewCountMinSketch(0.01, 0.01),
+	} {
+		other.Add([]byte(`a`))
+		if err := all.Merge(other); err == nil {
+			t.Error("Expected an error")
+		}
+	}
+	if all.TotalCount() != 3000 {
+		t.Errorf("Expected 3000, got %d", all.TotalCount())
+	}
+}
+
+// Ensures that a sketch tracking heavy hitters finds the most frequent items
+// above the threshold in one pass, and keeps them when restored.
+func TestCMSHeavyHitters(t *testing.T) {
+	cms := NewCountMinSketch(0.001, 0.01, WithSeed(2))
+	if cms.HeavyHitters() != nil {
+		t.Error("Expected no heavy hitters when not tracking")
+	}
+
+	cms.TrackHeavyHitters(3, 50)
+	for i := 0; i < 5000; i++ {
+		cms.Add([]byte(strconv.Itoa(i % 1000)))
+		if i%5 == 0 {
+			cms.Add([]byte(`a`)).Add([]byte(`a`)).Add([]byte(`b`))
+		}
+		if i%50 == 0 {
+			cms.Add([]byte(`c`))
+		}
+		if i%100 == 0 {
+			cms.Add([]byte(`d`))
+		}
+	}
+
+	hitters := cms.HeavyHitters()
+	if len(hitters) != 3 {
+		t.Fatalf("Expected 3 heavy hitters, got %d", len(hitters))
+	}
+	for i, expected := range []string{"a", "b", "c"} {
+		if data := string(hitters[i].Data); data != expected || hitters[i].Count != cms.Count(hitters[i].Data) {
+			t.Errorf("Expected %s with count %d, got %s with count %d", expected,
+				cms.Count([]byte(expected)), data, hitters[i].Count)
+		}
+	}
+
+	cms.TrackHeavyHitters(3, 1500)
+	if hitters := cms.HeavyHitters(); len(hitters) != 1 || string(hitters[0].Data) != "a" {
+		t.Errorf("Expected only a, got %v", hitters)
+	}
+
+	data, err := cms.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored CountMinSketch
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	restored.Add([]byte(`b`))
+	if hitters := restored.HeavyHitters(); len(hitters) != 1 || string(hitters[0].Data) != "a" {
+		t.Errorf("Expected only a, got %v", hitters)
+	}
+
+	cms.Reset()
+	if hitters := cms.HeavyHitters(); hitters == nil || len(hitters) != 0 {
+		t.Errorf("Expected no heavy hitters, got %v", hitters)
+	}
+}
+
+// Ensures that a huge heavy hitter limit isn't allocated up front, and that
+// ReadFrom rejects a limit out of range instead of tracking with it.
+func TestCMSHeavyHittersLimit(t *testing.T) {
+	cms := NewCountMinSketch(0.01, 0.01).TrackHeavyHitters(1<<63, 1)
+	cms.Add([]byte(`a`)).Add([]byte(`b`))
+	if hitters := cms.HeavyHitters(); len(hitters) != 2 {
+		t.Errorf("Expected 2 heavy hitters, got %v", hitters)
+	}
+
+	var buf bytes.Buffer
+	if _, err := cms.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := (&CountMinSketch{}).ReadFrom(&buf); err == nil {
+		t.Error("Expected error for a heavy hitter limit out of range")
+	}
+}
+
 // Ensures that Reset restores the sketch to its original state.
 func TestCMSReset(t *testing.T) {
 	cms := NewCountMinSketch(0.001, 0.99)
@@ -115,6 +281,52 @@ func TestCMSReset(t *testing.T) {
 	}
 }
 
+// Ensures that Compact retains the counters of heavy hitters and zeroes the
+// counters of infrequent items.
+func TestCMSCompact(t *testing.T) {
+	cms := NewCountMinSketch(0.01, 0.01)
+	for i := 0; i < 100; i++ {
+		cms.Add([]byte(`heavy`))
+	}
+	for i := 0; i < 100; i++ {
+		cms.Add([]byte(strconv.Itoa(i)))
+	}
+
+	if cms.Compact(0.01) != cms {
+		t.Error("Returned CountMinSketch should be the same instance")
+	}
+
+	if count := cms.Count([]byte(`heavy`)); count < 100 {
+		t.Errorf("expected at least 100, got %d", count)
+	}
+
+	cold := 0
+	for i := 0; i < 100; i++ {
+		if cms.Count([]byte(strconv.Itoa(i))) > 0 {
+			cold++
+		}
+	}
+	if cold > 10 {
+		t.Errorf("expected at most 10 cold items with non-zero counts, got %d", cold)
+	}
+
+	if count := cms.TotalCount(); count != 200 {
+		t.Errorf("expected 200, got %d", count)
+	}
+
+	nonZero := 0
+	for _, row := range cms.matrix {
+		for _, value := range row {
+			if value != 0 {
+				nonZero++
+			}
+		}
+	}
+	if max := int(math.Ceil(0.01 * float64(cms.width*cms.depth))); nonZero > max {
+		t.Errorf("expected at most %d non-zero counters, got %d", max, nonZero)
+	}
+}
+
 func BenchmarkCMSAdd(b *testing.B) {
 	b.StopTimer()
 	cms := NewCountMinSketch(0.001, 0.99)
@@ -129,6 +341,20 @@ func BenchmarkCMSAdd(b *testing.B) {
 	}
 }
 
+func BenchmarkCMSAddModulo(b *testing.B) {
+	b.StopTimer()
+	cms := NewCountMinSketch(0.001, 0.99, WithModuloReduction())
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		cms.Add(data[n])
+	}
+}
+
 func BenchmarkCMSCount(b *testing.B) {
 	b.StopTimer()
 	cms := NewCountMinSketch(0.001, 0.99)
@@ -143,3 +369,127 @@ func BenchmarkCMSCount(b *testing.B) {
 		cms.Count(data[n])
 	}
 }
+
+// Ensures that a CountMinSketch read from its written representation has the
+// same counts.
+func TestCMSWriteToReadFrom(t *testing.T) {
+	var (
+		cms = NewCountMinSketch(0.001, 0.99)
+		buf bytes.Buffer
+	)
+	cms.Add([]byte(`a`)).Add([]byte(`b`)).Add([]byte(`b`))
+
+	wn, err := cms.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	restored := NewCountMinSketch(0.1, 0.5)
+	rn, err := restored.ReadFrom(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if wn != rn {
+		t.Errorf("Expected %d bytes read, got %d", wn, rn)
+	}
+
+	if count := restored.Count([]byte(`b`)); count != 2 {
+		t.Errorf("Expected 2, got %d", count)
+	}
+
+	if count := restored.TotalCount(); count != 3 {
+		t.Errorf("Expected 3, got %d", count)
+	}
+
+	if restored.Epsilon() != 0.001 || restored.Delta() != 0.99 {
+		t.Errorf("Expected 0.001, 0.99, got %f, %f", restored.Epsilon(), restored.Delta())
+	}
+}
+
+// Ensures that ReadFrom rejects dimensions whose product overflows instead of
+// allocating them.
+func TestCMSReadFromOverflowingDimensions(t *testing.T) {
+	var e encoder
+	e.write(uint64(1 << 32))
+	e.write(uint64(1 << 32))
+	e.write(uint64(0))
+	e.write(0.1)
+	e.write(0.1)
+
+	var buf bytes.Buffer
+	if _, err := writeFrame(&buf, e.buf.Bytes()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := (&CountMinSketch{}).ReadFrom(&buf); err == nil {
+		t.Error("Expected error for overflowing dimensions")
+	}
+}
+
+// Ensures that a checkpointed sketch is restored exactly into the zero value,
+// including its update mode and seed, so it keeps counting like the original.
+func TestCMSCheckpoint(t *testing.T) {
+	cms := NewConservativeCountMinSketch(0.01, 0.01, WithSeed(9))
+	for i := 0; i < 1000; i++ {
+		cms.Add([]byte(strconv.Itoa(i % 300)))
+	}
+
+	data, err := cms.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored CountMinSketch
+	if _, err := restored.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.Equal(cms) {
+		t.Fatal("Expected the restored sketch to equal the original")
+	}
+
+	cms.Add([]byte(`a`))
+	restored.Add([]byte(`a`))
+	if !restored.Equal(cms) || restored.Count([]byte(`a`)) != cms.Count([]byte(`a`)) {
+		t.Error("Expected the restored sketch to keep counting like the original")
+	}
+	if err := restored.UnmarshalBinary(data[:len(data)-8]); err == nil {
+		t.Error("Expected an error for truncated data")
+	}
+}
+
+// Ensures that ApproxEqual returns true for sketches of identical streams and
+// false for diverged streams or mismatched dimensions.
+func TestCMSApproxEqual(t *testing.T) {
+	var (
+		a = NewCountMinSketch(0.01, 0.99)
+		b = NewCountMinSketch(0.01, 0.99)
+	)
+	for i := 0; i < 1000; i++ {
+		data := []byte(strconv.Itoa(i % 100))
+		a.Add(data)
+		b.Add(data)
+	}
+
+	if !ApproxEqual(a, b, 0) {
+		t.Error("Expected identical streams to be equal")
+	}
+
+	b.Add([]byte(`0`))
+	if ApproxEqual(a, b, 0) {
+		t.Error("Expected diverged streams not to be equal")
+	}
+
+	if !ApproxEqual(a, b, 0.1) {
+		t.Error("Expected slightly diverged streams to be equal within tolerance")
+	}
+
+	for i := 0; i < 100; i++ {
+		b.Add([]byte(`diverged`))
+	}
+	if ApproxEqual(a, b, 0.1) {
+		t.Error("Expected diverged streams not to be equal")
+	}
+
+	if ApproxEqual(a, NewCountMinSketch(0.1, 0.99), 1) {
+		t.Error("Expected mismatched dimensions not to be equal")
+	}
+}
@@ -0,0 +1,303 @@
+package boom
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"unsafe"
+)
+
+// CountSketch implements a Count Sketch as described by Charikar, Chen, and
+// Farach-Colton in Finding Frequent Items in Data Streams:
+//
+// https://www.cs.princeton.edu/courses/archive/spring04/cos598B/bib/CharikarCF.pdf
+//
+// Like a Count-Min Sketch, a Count Sketch hashes items to a counter in each
+// row of a matrix, but each row also hashes an item to a sign, +1 or -1, which
+// its counter is incremented by. The other items sharing the counter cancel
+// out on average, so multiplying the counter by the item's sign estimates its
+// frequency without bias, and the frequency is estimated by the median of the
+// rows' estimates. Errors are two-sided, unlike those of a Count-Min Sketch,
+// which always overestimates, so rankings of items by estimate aren't skewed
+// towards those sharing counters with heavy hitters.
+type CountSketch struct {
+	matrix  [][]int64   // count matrix
+	width   uint        // matrix width
+	depth   uint        // matrix depth, always odd
+	count   uint64      // number of items added
+	epsilon float64     // relative-accuracy factor
+	delta   float64     // relative-accuracy probability
+	hash    hash.Hash64 // hash function (kernel for all depth functions)
+	seed    uint64      // hash seed (zero means unseeded)
+}
+
+// NewCountSketch creates a new Count Sketch with the same matrix width and
+// depth as a Count-Min Sketch of epsilon and delta, rounding the depth up to
+// an odd number so each estimate is the median of the rows. Each row's
+// estimate has an expected error of zero and a standard deviation of at most
+// the L2 norm of the frequencies divided by the square root of the width.
+func NewCountSketch(epsilon, delta float64, opts ...Option) *CountSketch {
+	var (
+		width = uint(math.Ceil(math.E / epsilon))
+		depth = uint(math.Ceil(math.Log(1 / delta)))
+		o     = applyOptions(opts)
+	)
+	if depth%2 == 0 {
+		depth++
+	}
+
+	matrix := make([][]int64, depth)
+	for i := range matrix {
+		matrix[i] = make([]int64, width)
+	}
+
+	return &CountSketch{
+		matrix:  matrix,
+		width:   width,
+		depth:   depth,
+		epsilon: epsilon,
+		delta:   delta,
+		hash:    o.newHash64(newFNV64),
+		seed:    o.seed,
+	}
+}
+
+// Epsilon returns the relative-accuracy factor, epsilon.
+func (c *CountSketch) Epsilon() float64 {
+	return c.epsilon
+}
+
+// Delta returns the relative-accuracy probability, delta.
+func (c *CountSketch) Delta() float64 {
+	return c.delta
+}
+
+// TotalCount returns the number of items added to the sketch.
+func (c *CountSketch) TotalCount() uint64 {
+	return c.count
+}
+
+// Add will add the data to the set. Returns the CountSketch to allow for
+// chaining.
+func (c *CountSketch) Add(data []byte) *CountSketch {
+	lower, upper := seededHashKernel(data, c.hash, c.seed)
+	for i := uint(0); i < c.depth; i++ {
+		j, sign := c.cell(lower, upper, i)
+		c.matrix[i][j] += sign
+	}
+
+	c.count++
+	return c
+}
+
+// Count returns the estimated count for the specified item, the median of
+// each row's unbiased estimate. The estimate may be above or below the true
+// count, including below zero for rare items.
+func (c *CountSketch) Count(data []byte) int64 {
+	var (
+		lower, upper = seededHashKernel(data, c.hash, c.seed)
+		estimates    = make([]int64, c.depth)
+	)
+	for i := uint(0); i < c.depth; i++ {
+		j, sign := c.cell(lower, upper, i)
+		estimate := c.matrix[i][j] * sign
+
+		// Insert the estimate in order.
+		k := int(i)
+		for ; k > 0 && estimates[k-1] > estimate; k-- {
+			estimates[k] = estimates[k-1]
+		}
+		estimates[k] = estimate
+	}
+
+	return estimates[c.depth/2]
+}
+
+// cell returns the counter and sign of the item with the base hash values in
+// the row. Both are taken from a mix of the hash values and row, so that the
+// sign is independent of the counter.
+func (c *CountSketch) cell(lower, upper uint32, row uint) (uint, int64) {
+	x := mix64((uint64(upper)<<32 | uint64(lower)) + uint64(row)*0x9e3779b97f4a7c15)
+	return uint(x>>1) % c.width, int64(x&1)*2 - 1
+}
+
+// Merge combines this CountSketch with another by summing their counters,
+// such as to combine sketches built on different shards into a global view.
+// The sketches must have the same epsilon, delta, and hash seed, and should
+// use the same hash function. Returns an error if they are incompatible, in
+// which case this sketch is unchanged.
+func (c *CountSketch) Merge(other *CountSketch) error {
+	if c.depth != other.depth {
+		return errors.New("matrix depth must match")
+	}
+
+	if c.width != other.width {
+		return errors.New("matrix width must match")
+	}
+
+	if c.epsilon != other.epsilon || c.delta != other.delta {
+		return errors.New("epsilon and delta must match")
+	}
+
+	if c.seed != other.seed {
+		return errors.New("hash seed must match")
+	}
+
+	for i := uint(0); i < c.depth; i++ {
+		for j := uint(0); j < c.width; j++ {
+			c.matrix[i][j] += other.matrix[i][j]
+		}
+	}
+
+	c.count += other.count
+	return nil
+}
+
+// Equal returns true if the other CountSketch has the same parameters, hash
+// seed, number of items added, and counters. The hash functions aren't
+// compared.
+func (c *CountSketch) Equal(other *CountSketch) bool {
+	if c.width != other.width || c.depth != other.depth || c.count != other.count ||
+		c.epsilon != other.epsilon || c.delta != other.delta || c.seed != other.seed {
+		return false
+	}
+
+	for i, row := range c.matrix {
+		for j, counter := range row {
+			if counter != other.matrix[i][j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the CountSketch, which can be used
+// concurrently with the original.
+func (c *CountSketch) Clone() *CountSketch {
+	clone := *c
+	clone.hash = cloneHash64(c.hash)
+	clone.matrix = make([][]int64, len(c.matrix))
+	for i, row := range c.matrix {
+		clone.matrix[i] = append([]int64(nil), row...)
+	}
+	return &clone
+}
+
+// ByteSize returns the number of bytes used by the count matrix and metadata
+// of the sketch, excluding the hash function.
+func (c *CountSketch) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*c)) + uint64(cap(c.matrix))*sliceSize
+	for _, row := range c.matrix {
+		size += uint64(cap(row)) * 8
+	}
+	return size
+}
+
+// Reset restores the CountSketch to its original state. It returns itself to
+// allow for chaining.
+func (c *CountSketch) Reset() *CountSketch {
+	for _, row := range c.matrix {
+		for j := range row {
+			row[j] = 0
+		}
+	}
+	c.count = 0
+	return c
+}
+
+// SetHash sets the hashing function used.
+func (c *CountSketch) SetHash(h hash.Hash64) {
+	c.hash = h
+}
+
+// WriteTo writes a binary representation of the CountSketch to an I/O stream.
+// The hash function is not written, but the seed is. It returns the number of
+// bytes written.
+func (c *CountSketch) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(c.width))
+	e.write(uint64(c.depth))
+	e.write(c.count)
+	e.write(c.epsilon)
+	e.write(c.delta)
+	e.write(c.seed)
+	for _, row := range c.matrix {
+		e.write(row)
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a CountSketch (such as might have
+// been written by WriteTo()) from an I/O stream. The sketch keeps its current
+// hash function, which must match the one used by the sketch that was written,
+// or uses the default one if it has none, such as when it's the zero value.
+// Returns ErrUnsupportedVersion if the data was written by an incompatible
+// version of the package. It returns the number of bytes read.
+func (c *CountSketch) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                         = decoder{r: payload}
+		width, depth, count, seed uint64
+		epsilon, delta            float64
+	)
+	d.read(&width)
+	d.read(&depth)
+	d.read(&count)
+	d.read(&epsilon)
+	d.read(&delta)
+	d.read(&seed)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if width == 0 || depth%2 == 0 {
+		return n, errors.New("invalid sketch parameters")
+	}
+	// Check the product by division, which can't overflow.
+	if limit := uint64(payload.Len()) / 8; width > limit || depth > limit/width {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	matrix := make([][]int64, depth)
+	for i := range matrix {
+		matrix[i] = make([]int64, width)
+		d.read(matrix[i])
+	}
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if c.hash == nil {
+		c.hash = newFNV64()
+	}
+	c.matrix = matrix
+	c.width = uint(width)
+	c.depth = uint(depth)
+	c.count = count
+	c.epsilon = epsilon
+	c.delta = delta
+	c.seed = seed
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (c *CountSketch) MarshalBinary() ([]byte, error) {
+	return marshalBinary(c)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (c *CountSketch) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(c, data)
+}
@@ -0,0 +1,114 @@
+package boom
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+// Ensures that the Count Sketch estimates are about unbiased, unlike those of
+// a Count-Min Sketch of the same size, and exact without collisions.
+func TestCountSketchUnbiased(t *testing.T) {
+	var (
+		cs     = NewCountSketch(0.05, 0.01, WithSeed(8))
+		cms    = NewCountMinSketch(0.05, 0.01, WithSeed(8))
+		counts = make(map[string]int64)
+	)
+	for i := 1; i <= 500; i++ {
+		data := []byte(strconv.Itoa(i))
+		for j := 0; j < 1000/i+1; j++ {
+			cs.Add(data)
+			cms.Add(data)
+			counts[string(data)]++
+		}
+	}
+	if depth := len(cs.matrix); depth%2 != 1 {
+		t.Errorf("Expected an odd depth, got %d", depth)
+	}
+
+	var csBias, cmsBias int64
+	for data, count := range counts {
+		csBias += cs.Count([]byte(data)) - count
+		cmsBias += int64(cms.Count([]byte(data))) - count
+	}
+	if csBias < 0 {
+		csBias = -csBias
+	}
+	if cmsBias <= 0 || csBias*4 > cmsBias {
+		t.Errorf("Expected a bias well below %d, got %d", cmsBias, csBias)
+	}
+
+	single := NewCountSketch(0.001, 0.01)
+	single.Add([]byte(`a`)).Add([]byte(`a`)).Add([]byte(`b`))
+	if count := single.Count([]byte(`a`)); count != 2 {
+		t.Errorf("Expected 2, got %d", count)
+	}
+	if count := single.Count([]byte(`c`)); count != 0 {
+		t.Errorf("Expected 0, got %d", count)
+	}
+	if single.Reset().TotalCount() != 0 || single.Count([]byte(`a`)) != 0 {
+		t.Error("Expected an empty sketch")
+	}
+}
+
+// Ensures that merged sketches equal a sketch of all the data, that sketches
+// of different seeds aren't merged, and that a sketch read from its written
+// representation is equal to it.
+func TestCountSketchMergeWriteTo(t *testing.T) {
+	var (
+		all    = NewCountSketch(0.01, 0.01, WithSeed(2))
+		shard  = NewCountSketch(0.01, 0.01, WithSeed(2))
+		shard2 = NewCountSketch(0.01, 0.01, WithSeed(2))
+	)
+	for i := 0; i < 1000; i++ {
+		data := []byte(strconv.Itoa(i % 100))
+		all.Add(data)
+		if i%2 == 0 {
+			shard.Add(data)
+		} else {
+			shard2.Add(data)
+		}
+	}
+	if err := shard.Merge(shard2); err != nil {
+		t.Fatal(err)
+	}
+	if !shard.Equal(all) {
+		t.Error("Expected the merged sketch to equal the sketch of all the data")
+	}
+	if err := shard.Merge(NewCountSketch(0.01, 0.01)); err == nil {
+		t.Error("Expected an error for different seeds")
+	}
+
+	var buf bytes.Buffer
+	if _, err := all.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var read CountSketch
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !read.Equal(all) || read.Count([]byte(`7`)) != all.Count([]byte(`7`)) {
+		t.Error("Expected the sketches to be equal")
+	}
+}
+
+// Ensures that ReadFrom rejects dimensions whose product overflows instead of
+// allocating them.
+func TestCountSketchReadFromOverflowingDimensions(t *testing.T) {
+	var e encoder
+	e.write(uint64(1<<64/3 + 1)) // times 3 wraps to 2
+	e.write(uint64(3))
+	e.write(uint64(0))
+	e.write(0.1)
+	e.write(0.1)
+	e.write(uint64(0))
+	e.write(make([]int64, 2))
+
+	var buf bytes.Buffer
+	if _, err := writeFrame(&buf, e.buf.Bytes()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := (&CountSketch{}).ReadFrom(&buf); err == nil {
+		t.Error("Expected error for overflowing dimensions")
+	}
+}
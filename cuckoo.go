@@ -2,12 +2,14 @@ package boom
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"hash"
-	"hash/fnv"
+	"io"
 	"math"
 	"math/rand"
+	"unsafe"
 )
 
 // maxNumKicks is the maximum number of relocations to attempt when inserting
@@ -61,18 +63,31 @@ func (b bucket) getEmptyEntry() (int, error) {
 // false-positive rates, cuckoo filters have lower space overhead than
 // space-optimized Bloom filters.
 type CuckooFilter struct {
-	buckets []bucket
-	hash    hash.Hash32 // hash function (used for fingerprint and hash)
-	m       uint        // number of buckets
-	b       uint        // number of entries per bucket
-	f       uint        // length of fingerprints (in bytes)
-	count   uint        // number of items in the filter
-	n       uint        // filter capacity
+	buckets  []bucket
+	hash     hash.Hash32  // hash function (used for fingerprint and hash)
+	m        uint         // number of buckets
+	b        uint         // number of entries per bucket
+	f        uint         // length of fingerprints (in bytes)
+	bits     uint         // length of fingerprints (in bits, zero means 8*f)
+	count    uint         // number of items in the filter
+	n        uint         // filter capacity
+	seed     uint64       // hash seed (zero means unseeded)
+	rand     *rand.Rand   // source of randomness for relocations, if seeded
+	stash    []stashEntry // items which didn't fit in their buckets
+	maxStash uint         // maximum number of stashed items
+	chunks   *chunkLoad   // dump being loaded by LoadChunk
+}
+
+// stashEntry is an item which didn't fit in the buckets of a CuckooFilter,
+// kept in its stash.
+type stashEntry struct {
+	bucket      uint   // one of the item's buckets
+	fingerprint []byte // fingerprint of the item
 }
 
 // NewCuckooFilter creates a new Cuckoo Bloom filter optimized to store n items
 // with a specified target false-positive rate.
-func NewCuckooFilter(n uint, fpRate float64) *CuckooFilter {
+func NewCuckooFilter(n uint, fpRate float64, opts ...Option) *CuckooFilter {
 	var (
 		b       = uint(4)
 		f       = calculateF(b, fpRate)
@@ -84,14 +99,82 @@ func NewCuckooFilter(n uint, fpRate float64) *CuckooFilter {
 		buckets[i] = make(bucket, b)
 	}
 
+	o := applyOptions(opts)
+	return &CuckooFilter{
+		buckets:  buckets,
+		hash:     o.newHash32(newFNV32),
+		m:        m,
+		b:        b,
+		f:        uint(f),
+		bits:     8 * uint(f),
+		n:        n,
+		seed:     o.seed,
+		rand:     o.rand(),
+		maxStash: o.stash,
+	}
+}
+
+// NewCuckooFilterWithParams creates a new Cuckoo Filter optimized to store n
+// items with fingerprints of the given number of bits, up to 32, and the given
+// number of entries per bucket, so memory can be traded for false-positive
+// rate. The false-positive rate is at most CuckooFPRate(fingerprintBits,
+// entriesPerBucket), and each item takes about fingerprintBits/load bits of
+// fingerprint, where the load the filter reaches before it's full is about
+// 50%, 84%, 95%, and 98% with 1, 2, 4, and 8 entries per bucket. Returns an
+// error if either parameter is zero or the fingerprints have more than 32
+// bits.
+func NewCuckooFilterWithParams(n, fingerprintBits, entriesPerBucket uint, opts ...Option) (*CuckooFilter, error) {
+	if fingerprintBits == 0 || fingerprintBits > 32 {
+		return nil, errors.New("fingerprint bits must be between 1 and 32")
+	}
+	if entriesPerBucket == 0 {
+		return nil, errors.New("entries per bucket must be positive")
+	}
+
+	var (
+		b       = entriesPerBucket
+		m       = power2(uint(math.Ceil(float64(n) / (float64(b) * cuckooLoad(b)))))
+		buckets []bucket
+	)
+	if m == 0 {
+		m = 1
+	}
+	buckets = make([]bucket, m)
+	for i := uint(0); i < m; i++ {
+		buckets[i] = make(bucket, b)
+	}
+
+	o := applyOptions(opts)
 	return &CuckooFilter{
-		buckets: buckets,
-		hash:    fnv.New32(),
-		m:       m,
-		b:       b,
-		f:       uint(f),
-		n:       n,
+		buckets:  buckets,
+		hash:     o.newHash32(newFNV32),
+		m:        m,
+		b:        b,
+		f:        (fingerprintBits + 7) / 8,
+		bits:     fingerprintBits,
+		n:        n,
+		seed:     o.seed,
+		rand:     o.rand(),
+		maxStash: o.stash,
+	}, nil
+}
+
+// NewCuckooFilterFromReader creates a new Cuckoo Filter with a specified
+// target false-positive rate holding the keys read from the stream, delimited
+// by delim, like NewBloomFilterFromReader. Returns an error if reading the
+// stream fails or if the filter is full, which is more likely if the stream
+// isn't an io.Seeker and holds more keys than the hint.
+func NewCuckooFilterFromReader(stream io.Reader, delim byte, fpRate float64, opts ...Option) (*CuckooFilter, error) {
+	n, err := streamHint(stream, delim, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewCuckooFilter(n, fpRate, opts...)
+	if err := readKeys(stream, delim, c.Add); err != nil {
+		return nil, err
 	}
+	return c, nil
 }
 
 // Buckets returns the number of buckets.
@@ -109,23 +192,49 @@ func (c *CuckooFilter) Count() uint {
 	return c.count
 }
 
+// Stashed returns the number of items in the stash set with WithStash.
+func (c *CuckooFilter) Stashed() uint {
+	return uint(len(c.stash))
+}
+
+// FingerprintBits returns the length of fingerprints in bits.
+func (c *CuckooFilter) FingerprintBits() uint {
+	if c.bits == 0 {
+		return 8 * c.f
+	}
+	return c.bits
+}
+
+// EntriesPerBucket returns the number of entries per bucket.
+func (c *CuckooFilter) EntriesPerBucket() uint {
+	return c.b
+}
+
+// EstimatedFPRate returns the estimated false-positive rate for the current
+// number of items. A lookup compares the fingerprint of an item to the
+// occupied entries of its two buckets, 2*b*load of them on average, each of
+// which matches with probability 2^-bits.
+func (c *CuckooFilter) EstimatedFPRate() float64 {
+	load := float64(c.count) / float64(c.m*c.b)
+	return 1 - math.Pow(1-math.Exp2(-float64(c.FingerprintBits())), 2*float64(c.b)*load)
+}
+
 // Test will test for membership of the data and returns true if it is a
 // member, false if not. This is a probabilistic test, meaning there is a
 // non-zero probability of false positives.
 func (c *CuckooFilter) Test(data []byte) bool {
-	i1, i2, f := c.components(data)
-
-	// If either bucket contains f, it's a member.
-	return c.buckets[i1%c.m].contains(f) || c.buckets[i2%c.m].contains(f)
+	var buf [4]byte
+	return c.contains(c.components(data, &buf))
 }
 
 // Add will add the data to the Cuckoo Filter. It returns an error if the
 // filter is full. If the filter is full, an item is removed to make room for
 // the new item. This introduces a possibility for false negatives. To avoid
 // this, use Count and Capacity to check if the filter is full before adding an
-// item.
+// item, or WithStash to keep the items which don't fit.
 func (c *CuckooFilter) Add(data []byte) error {
-	return c.add(c.components(data))
+	var buf [4]byte
+	return c.add(c.components(data, &buf))
 }
 
 // TestAndAdd is equivalent to calling Test followed by Add. It returns true if
@@ -134,20 +243,60 @@ func (c *CuckooFilter) Add(data []byte) error {
 // item. This introduces a possibility for false negatives. To avoid this, use
 // Count and Capacity to check if the filter is full before adding an item.
 func (c *CuckooFilter) TestAndAdd(data []byte) (bool, error) {
-	i1, i2, f := c.components(data)
+	var buf [4]byte
+	i1, i2, f := c.components(data, &buf)
 
-	// If either bucket contains f, it's a member.
-	if c.buckets[i1%c.m].contains(f) || c.buckets[i2%c.m].contains(f) {
+	if c.contains(i1, i2, f) {
 		return true, nil
 	}
 
 	return false, c.add(i1, i2, f)
 }
 
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (c *CuckooFilter) TestString(data string) bool {
+	return c.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied.
+func (c *CuckooFilter) AddString(data string) error {
+	return c.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (c *CuckooFilter) TestAndAddString(data string) (bool, error) {
+	return c.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter, stopping at the first error
+// returned by Add. It returns the number of items added, which are the first
+// ones in data, and the error, if any.
+func (c *CuckooFilter) AddMany(data [][]byte) (int, error) {
+	return addAllContext(context.Background(), data, c.Add)
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+func (c *CuckooFilter) TestMany(data [][]byte) []bool {
+	return testMany(data, c.Test)
+}
+
+// AddAllContext adds each item of data to the filter, checking the context for
+// cancellation periodically. It returns the number of items added, which are
+// the first ones in data, and the context's error if it was cancelled before
+// every item was added. If the filter becomes full, it stops and returns the
+// error from Add.
+func (c *CuckooFilter) AddAllContext(ctx context.Context, data [][]byte) (int, error) {
+	return addAllContext(ctx, data, c.Add)
+}
+
 // TestAndRemove will test for membership of the data and remove it from the
 // filter if it exists. Returns true if the data was a member, false if not.
 func (c *CuckooFilter) TestAndRemove(data []byte) bool {
-	i1, i2, f := c.components(data)
+	var buf [4]byte
+	i1, i2, f := c.components(data, &buf)
 
 	// Try to remove from bucket[i1].
 	b1 := c.buckets[i1%c.m]
@@ -165,9 +314,57 @@ func (c *CuckooFilter) TestAndRemove(data []byte) bool {
 		return true
 	}
 
+	// Try to remove from the stash.
+	if idx := c.stashIndex(i1, i2, f); idx != -1 {
+		last := len(c.stash) - 1
+		c.stash[idx] = c.stash[last]
+		c.stash[last] = stashEntry{}
+		c.stash = c.stash[:last]
+		c.count--
+		return true
+	}
+
 	return false
 }
 
+// contains returns true if either bucket or the stash contains the
+// fingerprint.
+func (c *CuckooFilter) contains(i1, i2 uint, f []byte) bool {
+	return c.buckets[i1%c.m].contains(f) || c.buckets[i2%c.m].contains(f) ||
+		c.stashIndex(i1, i2, f) != -1
+}
+
+// stashIndex returns the index in the stash of the item with the buckets and
+// fingerprint or -1 if it's not stashed.
+func (c *CuckooFilter) stashIndex(i1, i2 uint, f []byte) int {
+	for i, entry := range c.stash {
+		if (entry.bucket == i1%c.m || entry.bucket == i2%c.m) && bytes.Equal(entry.fingerprint, f) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Range calls fn for each item in the filter, including stashed ones, with
+// the bucket holding it and its fingerprint, until fn returns false. An item
+// is stored in one of two buckets determined by its key, so the pair
+// identifies it. The fingerprint must not be modified, and the filter must not
+// be modified while Range is running.
+func (c *CuckooFilter) Range(fn func(bucket uint, fingerprint []byte) bool) {
+	for i, b := range c.buckets {
+		for _, fingerprint := range b {
+			if fingerprint != nil && !fn(uint(i), fingerprint) {
+				return
+			}
+		}
+	}
+	for _, entry := range c.stash {
+		if !fn(entry.bucket, entry.fingerprint) {
+			return
+		}
+	}
+}
+
 // Reset restores the Bloom filter to its original state. It returns the filter
 // to allow for chaining.
 func (c *CuckooFilter) Reset() *CuckooFilter {
@@ -177,18 +374,30 @@ func (c *CuckooFilter) Reset() *CuckooFilter {
 	}
 	c.buckets = buckets
 	c.count = 0
+	c.stash = nil
 	return c
 }
 
 // add will insert the fingerprint into the filter returning an error if the
 // filter is full.
 func (c *CuckooFilter) add(i1, i2 uint, f []byte) error {
+	if !c.insert(i1, i2, f, false) {
+		return errors.New("full")
+	}
+	return nil
+}
+
+// insert inserts the fingerprint into one of its buckets, relocating existing
+// items if both are full. If no item can be relocated, the last item relocated
+// is stashed if the stash has room. Otherwise false is returned, and the
+// relocations are undone if restore is set, or the item is removed.
+func (c *CuckooFilter) insert(i1, i2 uint, f []byte, restore bool) bool {
 	// Try to insert into bucket[i1].
 	b1 := c.buckets[i1%c.m]
 	if idx, err := b1.getEmptyEntry(); err == nil {
 		b1[idx] = f
 		c.count++
-		return nil
+		return true
 	}
 
 	// Try to insert into bucket[i2].
@@ -196,48 +405,636 @@ func (c *CuckooFilter) add(i1, i2 uint, f []byte) error {
 	if idx, err := b2.getEmptyEntry(); err == nil {
 		b2[idx] = f
 		c.count++
-		return nil
+		return true
 	}
 
 	// Must relocate existing items.
+	var path []uint // entries swapped, if restoring
 	i := i1
 	for n := 0; n < maxNumKicks; n++ {
 		bucketIdx := i % c.m
-		entryIdx := rand.Intn(int(c.b))
+		entryIdx := c.intn(int(c.b))
 		f, c.buckets[bucketIdx][entryIdx] = c.buckets[bucketIdx][entryIdx], f
-		i = i ^ uint(binary.BigEndian.Uint32(c.computeHash(f)))
+		if restore {
+			path = append(path, bucketIdx*c.b+uint(entryIdx))
+		}
+		i = i ^ uint(c.computeHash(f))
 		b := c.buckets[i%c.m]
 		if idx, err := b.getEmptyEntry(); err == nil {
 			b[idx] = f
 			c.count++
-			return nil
+			return true
+		}
+	}
+
+	if uint(len(c.stash)) < c.maxStash {
+		c.stash = append(c.stash, stashEntry{bucket: i % c.m, fingerprint: f})
+		c.count++
+		return true
+	}
+
+	// Swap the fingerprints back in reverse order.
+	for j := len(path) - 1; j >= 0; j-- {
+		bucket, entry := path[j]/c.b, path[j]%c.b
+		f, c.buckets[bucket][entry] = c.buckets[bucket][entry], f
+	}
+	return false
+}
+
+// intn returns a random number in [0, n) from the filter's source of
+// randomness, falling back to the default source if there is none.
+func (c *CuckooFilter) intn(n int) int {
+	if c.rand != nil {
+		return c.rand.Intn(n)
+	}
+	return rand.Intn(n)
+}
+
+// WriteTo writes a binary representation of the CuckooFilter to an I/O
+// stream. The hash function is not written, but the seed is. It returns the
+// number of bytes written.
+func (c *CuckooFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(c.m))
+	e.write(uint64(c.b))
+	e.write(uint64(c.f))
+	e.write(uint64(c.count))
+	e.write(uint64(c.n))
+	for _, b := range c.buckets {
+		for _, fingerprint := range b {
+			// Fingerprints are never empty, so an empty entry is written
+			// with a length of zero.
+			e.writeBytes(fingerprint)
+		}
+	}
+	e.write(c.seed)
+	e.write(uint64(c.FingerprintBits()))
+	c.writeStash(&e)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a CuckooFilter (such as might have
+// been written by WriteTo()) from an I/O stream. The filter keeps its current
+// hash function, which must match the one used by the filter that was written.
+// Returns ErrUnsupportedVersion if the data was written by an incompatible
+// version of the package. It returns the number of bytes read.
+func (c *CuckooFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                    = decoder{r: payload}
+		m, b, f, count, size uint64
+	)
+	d.read(&m)
+	d.read(&b)
+	d.read(&f)
+	d.read(&count)
+	d.read(&size)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	// Every entry takes at least eight bytes for its length.
+	if b == 0 || m > uint64(payload.Len())/8/b {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	buckets := make([]bucket, m)
+	for i := range buckets {
+		buckets[i] = make(bucket, b)
+		for j := range buckets[i] {
+			fingerprint := d.readBytes()
+			if d.err != nil {
+				return n, d.err
+			}
+			if len(fingerprint) == 0 {
+				continue
+			}
+			if uint64(len(fingerprint)) != f {
+				return n, errors.New("fingerprint doesn't match filter")
+			}
+			buckets[i][j] = fingerprint
+		}
+	}
+
+	var (
+		seed, bits, maxStash uint64
+		stash                []stashEntry
+	)
+	if payload.Len() > 0 {
+		// Written by format version 2.3 or later.
+		d.read(&seed)
+	}
+	if payload.Len() > 0 {
+		// Written by format version 2.4 or later.
+		d.read(&bits)
+		maxStash, stash = readStash(&d, m, f)
+	}
+	if d.err != nil {
+		return n, d.err
+	}
+	if bits, err = fingerprintBits(bits, f); err != nil {
+		return n, err
+	}
+
+	c.buckets = buckets
+	c.m = uint(m)
+	c.b = uint(b)
+	c.f = uint(f)
+	c.bits = uint(bits)
+	c.count = uint(count)
+	c.n = uint(size)
+	c.seed = seed
+	c.stash = stash
+	c.maxStash = uint(maxStash)
+	return n, nil
+}
+
+// WriteCompactTo writes a compact binary representation of the CuckooFilter
+// to an I/O stream, which ReadCompactFrom reads. Unlike WriteTo, which writes
+// every entry with its length, it writes a bit per entry indicating whether
+// it's occupied followed by the fingerprint packed into FingerprintBits bits,
+// so it's suited to sending a filter to another process. The hash function is
+// not written, but the seed is. It returns the number of bytes written.
+func (c *CuckooFilter) WriteCompactTo(stream io.Writer) (int64, error) {
+	var (
+		e    encoder
+		w    bitWriter
+		bits = c.FingerprintBits()
+	)
+	e.write(uint64(c.m))
+	e.write(uint64(c.b))
+	e.write(uint64(c.f))
+	e.write(uint64(bits))
+	e.write(uint64(c.count))
+	e.write(uint64(c.n))
+	e.write(c.seed)
+	for _, b := range c.buckets {
+		for _, fingerprint := range b {
+			if fingerprint == nil {
+				w.writeBit(0)
+				continue
+			}
+			w.writeBit(1)
+			w.writeBits(c.fingerprintValue(fingerprint), bits)
+		}
+	}
+	e.writeBytes(w.data)
+	c.writeStash(&e)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadCompactFrom reads a compact binary representation of a CuckooFilter
+// written by WriteCompactTo from an I/O stream. The filter keeps its current
+// hash function, which must match the one used by the filter that was written.
+// Returns ErrUnsupportedVersion if the data was written by an incompatible
+// version of the package. It returns the number of bytes read.
+func (c *CuckooFilter) ReadCompactFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                                = decoder{r: payload}
+		m, b, f, bits, count, size, seed uint64
+	)
+	d.read(&m)
+	d.read(&b)
+	d.read(&f)
+	d.read(&bits)
+	d.read(&count)
+	d.read(&size)
+	d.read(&seed)
+	if d.err != nil {
+		return n, d.err
+	}
+	if f == 0 || f > 4 || bits == 0 {
+		return n, errors.New("fingerprint bits don't match filter")
+	}
+	if bits, err = fingerprintBits(bits, f); err != nil {
+		return n, err
+	}
+
+	// Every entry takes at least a bit.
+	if b == 0 || m > uint64(payload.Len())*8/b {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	var (
+		r       = bitReader{data: d.readBytes()}
+		buckets = make([]bucket, m)
+		entries uint64
+	)
+	if d.err != nil {
+		return n, d.err
+	}
+	for i := range buckets {
+		buckets[i] = make(bucket, b)
+		for j := range buckets[i] {
+			occupied, ok := r.readBit()
+			if !ok {
+				return n, io.ErrUnexpectedEOF
+			}
+			if occupied == 0 {
+				continue
+			}
+			v, ok := r.readBits(uint(bits))
+			if !ok {
+				return n, io.ErrUnexpectedEOF
+			}
+			buckets[i][j] = valueFingerprint(v, uint(f), uint(bits))
+			entries++
 		}
 	}
 
-	return errors.New("full")
+	maxStash, stash := readStash(&d, m, f)
+	if d.err != nil {
+		return n, d.err
+	}
+	if entries+uint64(len(stash)) != count {
+		return n, errors.New("count doesn't match filter")
+	}
+
+	c.buckets = buckets
+	c.m = uint(m)
+	c.b = uint(b)
+	c.f = uint(f)
+	c.bits = uint(bits)
+	c.count = uint(count)
+	c.n = uint(size)
+	c.seed = seed
+	c.stash = stash
+	c.maxStash = uint(maxStash)
+	return n, nil
+}
+
+// ScanDump returns the chunk of the filter following the iterator, along with
+// the iterator to load the chunk with and to pass to the next call, like
+// RedisBloom's CF.SCANDUMP. Start with an iterator of zero. The first chunk
+// holds the filter's parameters and each following chunk at most maxChunkSize
+// bytes of its buckets, so large filters can be stored or sent in pieces of
+// bounded size. An iterator of zero and a nil chunk are returned once the dump
+// is complete. The filter must not be modified until then.
+func (c *CuckooFilter) ScanDump(iter uint64, maxChunkSize int) (uint64, []byte, error) {
+	return scanDump(c, iter, maxChunkSize)
+}
+
+// LoadChunk restores a chunk returned by ScanDump along with its iterator,
+// like RedisBloom's CF.LOADCHUNK. The chunks must be loaded in the order they
+// were dumped, starting with the first. They're held until the last one is
+// loaded, which replaces the filter, so a header describing more data than is
+// loaded doesn't allocate it. The filter keeps its current hash function,
+// which must match the one used by the filter that was dumped.
+func (c *CuckooFilter) LoadChunk(iter uint64, chunk []byte) error {
+	return loadChunk(c, &c.chunks, iter, chunk)
+}
+
+// chunkHeader returns the parameters of the filter.
+func (c *CuckooFilter) chunkHeader() ([]byte, error) {
+	var e encoder
+	e.write(uint64(c.m))
+	e.write(uint64(c.b))
+	e.write(uint64(c.f))
+	e.write(uint64(c.count))
+	e.write(uint64(c.n))
+	e.write(c.seed)
+	e.write(uint64(c.FingerprintBits()))
+	c.writeStash(&e)
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	var buf bytes.Buffer
+	_, err := writeFrame(&buf, e.buf.Bytes())
+	return buf.Bytes(), err
+}
+
+// cuckooChunkHeader is the header of a CuckooFilter's dump.
+type cuckooChunkHeader struct {
+	m, b, f, count, size uint64
+	seed, bits, maxStash uint64
+	stash                []stashEntry
+}
+
+// readCuckooChunkHeader reads and validates the header, returning the size of
+// the buckets' data it describes.
+func readCuckooChunkHeader(header []byte) (cuckooChunkHeader, uint64, error) {
+	var h cuckooChunkHeader
+	payload, _, err := readFrame(bytes.NewReader(header))
+	if err != nil {
+		return h, 0, err
+	}
+
+	d := decoder{r: payload}
+	d.read(&h.m)
+	d.read(&h.b)
+	d.read(&h.f)
+	d.read(&h.count)
+	d.read(&h.size)
+	if payload.Len() > 0 {
+		// Written by format version 2.3 or later.
+		d.read(&h.seed)
+	}
+	if payload.Len() > 0 {
+		// Written by format version 2.4 or later.
+		d.read(&h.bits)
+		h.maxStash, h.stash = readStash(&d, h.m, h.f)
+	}
+	if d.err != nil {
+		return h, 0, d.err
+	}
+
+	if h.b == 0 || h.f == 0 {
+		return h, 0, errors.New("invalid filter parameters")
+	}
+	if h.bits, err = fingerprintBits(h.bits, h.f); err != nil {
+		return h, 0, err
+	}
+
+	// Each entry takes a byte indicating whether it's present followed by its
+	// fingerprint, and a slice header once allocated.
+	entries, ok := addChunkDataSize(0, h.m, h.b)
+	if ok {
+		_, ok = addChunkDataSize(0, entries, h.f+1+sliceSize)
+	}
+	if !ok {
+		return h, 0, errors.New("invalid filter parameters")
+	}
+	return h, entries * (h.f + 1), nil
+}
+
+// chunkHeaderDataSize validates the header and returns the size of the
+// buckets' data it describes.
+func (c *CuckooFilter) chunkHeaderDataSize(header []byte) (uint64, error) {
+	_, size, err := readCuckooChunkHeader(header)
+	return size, err
+}
+
+// loadChunkHeader replaces the filter with an empty one described by the
+// header.
+func (c *CuckooFilter) loadChunkHeader(header []byte) error {
+	h, _, err := readCuckooChunkHeader(header)
+	if err != nil {
+		return err
+	}
+
+	c.m = uint(h.m)
+	c.b = uint(h.b)
+	c.f = uint(h.f)
+	c.bits = uint(h.bits)
+	c.n = uint(h.size)
+	c.seed = h.seed
+	c.Reset()
+	c.count = uint(h.count)
+	c.stash = h.stash
+	c.maxStash = uint(h.maxStash)
+	return nil
+}
+
+// writeStash writes the maximum number of stashed items and the stash to the
+// payload.
+func (c *CuckooFilter) writeStash(e *encoder) {
+	e.write(uint64(c.maxStash))
+	e.write(uint64(len(c.stash)))
+	for _, entry := range c.stash {
+		e.write(uint64(entry.bucket))
+		e.writeBytes(entry.fingerprint)
+	}
+}
+
+// readStash reads the maximum number of stashed items and the stash written
+// by writeStash for a filter with m buckets and fingerprints of f bytes.
+func readStash(d *decoder, m, f uint64) (uint64, []stashEntry) {
+	var maxStash, length uint64
+	d.read(&maxStash)
+	d.read(&length)
+	if d.err == nil && length > maxStash {
+		d.err = errors.New("stash doesn't match filter")
+	}
+
+	var stash []stashEntry
+	for i := uint64(0); i < length && d.err == nil; i++ {
+		var bucket uint64
+		d.read(&bucket)
+		fingerprint := d.readBytes()
+		if d.err == nil && (bucket >= m || uint64(len(fingerprint)) != f) {
+			d.err = errors.New("stash doesn't match filter")
+		}
+		stash = append(stash, stashEntry{bucket: uint(bucket), fingerprint: fingerprint})
+	}
+	return maxStash, stash
+}
+
+// chunkDataSize returns the size of the buckets in bytes. Each entry takes a
+// byte indicating whether it's present followed by its fingerprint.
+func (c *CuckooFilter) chunkDataSize() uint64 {
+	return uint64(c.m) * uint64(c.b) * uint64(c.f+1)
+}
+
+// readChunkData copies the buckets starting at the offset into p.
+func (c *CuckooFilter) readChunkData(offset uint64, p []byte) {
+	var (
+		size  = uint64(c.f + 1)
+		entry = make([]byte, size)
+	)
+	for len(p) > 0 {
+		i := offset / size
+		for j := range entry {
+			entry[j] = 0
+		}
+		if fingerprint := c.buckets[i/uint64(c.b)][i%uint64(c.b)]; fingerprint != nil {
+			entry[0] = 1
+			copy(entry[1:], fingerprint)
+		}
+
+		n := copy(p, entry[offset%size:])
+		p = p[n:]
+		offset += uint64(n)
+	}
+}
+
+// writeChunkData copies p into the buckets starting at the offset. An entry
+// is allocated when the byte indicating it's present is written, so its
+// fingerprint can span chunks.
+func (c *CuckooFilter) writeChunkData(offset uint64, p []byte) {
+	size := uint64(c.f + 1)
+	for _, v := range p {
+		var (
+			i     = offset / size
+			j     = offset % size
+			b     = c.buckets[i/uint64(c.b)]
+			entry = i % uint64(c.b)
+		)
+		if j == 0 {
+			b[entry] = nil
+			if v == 1 {
+				b[entry] = make([]byte, c.f)
+			}
+		} else if b[entry] != nil {
+			b[entry][j-1] = v
+		}
+		offset++
+	}
 }
 
 // components returns the two hash values used to index into the buckets and
-// the fingerprint for the given element.
-func (c *CuckooFilter) components(data []byte) (uint, uint, []byte) {
+// the fingerprint for the given element, which is stored in buf.
+func (c *CuckooFilter) components(data []byte, buf *[4]byte) (uint, uint, []byte) {
+	hash := c.computeHash(data)
+	binary.BigEndian.PutUint32(buf[:], hash)
+
 	var (
-		hash = c.computeHash(data)
-		f    = hash[0:c.f]
-		i1   = uint(binary.BigEndian.Uint32(hash))
-		i2   = i1 ^ uint(binary.BigEndian.Uint32(c.computeHash(f)))
+		f     = buf[:c.f]
+		fhash uint32
 	)
+	if r := c.FingerprintBits() % 8; r != 0 {
+		// Keep only the leading bits of the last byte.
+		f[c.f-1] &= byte(0xff) << (8 - r)
+	}
+	if _, ok := c.hash.(*fnv32); ok {
+		fhash = fnv32Hash(c.seed, f)
+	} else {
+		// Hashing a copy keeps buf from escaping through the hash function.
+		fhash = c.computeHash(append([]byte(nil), f...))
+	}
 
-	return i1, i2, f
+	i1 := uint(hash)
+	return i1, i1 ^ uint(fhash), f
 }
 
-// computeHash returns a 32-bit hash value for the given data.
-func (c *CuckooFilter) computeHash(data []byte) []byte {
+// fingerprintValue returns the fingerprint as an integer of FingerprintBits
+// bits.
+func (c *CuckooFilter) fingerprintValue(fingerprint []byte) uint64 {
+	var buf [8]byte
+	copy(buf[8-len(fingerprint):], fingerprint)
+	return binary.BigEndian.Uint64(buf[:]) >> (8*c.f - c.FingerprintBits())
+}
+
+// valueFingerprint returns the fingerprint of f bytes whose leading bits are
+// the integer v, as returned by fingerprintValue.
+func valueFingerprint(v uint64, f, bits uint) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v<<(8*f-bits))
+	return append([]byte(nil), buf[8-f:]...)
+}
+
+// computeHash returns a 32-bit hash value for the given data. The default hash
+// function is computed without its state, so it doesn't allocate.
+func (c *CuckooFilter) computeHash(data []byte) uint32 {
+	if _, ok := c.hash.(*fnv32); ok {
+		return fnv32Hash(c.seed, data)
+	}
+
+	if c.seed != 0 {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], c.seed)
+		c.hash.Write(buf[:])
+	}
 	c.hash.Write(data)
-	hash := c.hash.Sum(nil)
+	hash := c.hash.Sum32()
 	c.hash.Reset()
 	return hash
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (c *CuckooFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(c)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo. If the filter
+// has no hash function, such as when it's the zero value, the default hash
+// function is used.
+func (c *CuckooFilter) UnmarshalBinary(data []byte) error {
+	if c.hash == nil {
+		c.hash = newFNV32()
+	}
+	return unmarshalBinary(c, data)
+}
+
+// Equal returns true if the other Cuckoo Filter has the same parameters, hash
+// seed, and number of items, and holds the same fingerprints in the same
+// entries. The hash functions aren't compared.
+func (c *CuckooFilter) Equal(other *CuckooFilter) bool {
+	if c.m != other.m || c.b != other.b || c.f != other.f || c.n != other.n ||
+		c.count != other.count || c.seed != other.seed ||
+		c.FingerprintBits() != other.FingerprintBits() ||
+		c.maxStash != other.maxStash || len(c.stash) != len(other.stash) {
+		return false
+	}
+
+	for i, entry := range c.stash {
+		if entry.bucket != other.stash[i].bucket || !bytes.Equal(entry.fingerprint, other.stash[i].fingerprint) {
+			return false
+		}
+	}
+
+	for i, bucket := range c.buckets {
+		for j, fingerprint := range bucket {
+			if !bytes.Equal(fingerprint, other.buckets[i][j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the Cuckoo Filter, which can be used
+// concurrently with the original. The copy's source of randomness for
+// relocations, if seeded, is seeded from the original's.
+func (c *CuckooFilter) Clone() *CuckooFilter {
+	clone := *c
+	clone.hash = cloneHash32(c.hash)
+	clone.rand = cloneRand(c.rand)
+	clone.chunks = nil
+	clone.buckets = make([]bucket, len(c.buckets))
+	for i, b := range c.buckets {
+		clone.buckets[i] = make(bucket, len(b))
+		for j, fingerprint := range b {
+			if fingerprint != nil {
+				clone.buckets[i][j] = append([]byte(nil), fingerprint...)
+			}
+		}
+	}
+	clone.stash = nil
+	for _, entry := range c.stash {
+		entry.fingerprint = append([]byte(nil), entry.fingerprint...)
+		clone.stash = append(clone.stash, entry)
+	}
+	return &clone
+}
+
+// ByteSize returns the number of bytes used by the buckets, including the
+// fingerprints they hold, and metadata of the Cuckoo Filter. The hash function
+// and source of randomness aren't included.
+func (c *CuckooFilter) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*c)) + uint64(cap(c.buckets))*sliceSize
+	for _, b := range c.buckets {
+		size += uint64(cap(b)) * sliceSize
+		for _, fingerprint := range b {
+			size += uint64(cap(fingerprint))
+		}
+	}
+	size += uint64(cap(c.stash)) * uint64(unsafe.Sizeof(stashEntry{}))
+	for _, entry := range c.stash {
+		size += uint64(cap(entry.fingerprint))
+	}
+	return size
+}
+
 // SetHash sets the hashing function used in the filter.
 // For the effect on false positive rates see: https://github.com/tylertreat/BoomFilters/pull/1
 func (c *CuckooFilter) SetHash(h hash.Hash32) {
@@ -255,6 +1052,40 @@ func calculateF(b uint, epsilon float64) uint {
 	return f
 }
 
+// fingerprintBits returns the length in bits of fingerprints of f bytes read
+// from a payload, which is 8*f if the payload didn't have one. Returns an error
+// if the length doesn't fit f bytes.
+func fingerprintBits(bits, f uint64) (uint64, error) {
+	if bits == 0 {
+		return 8 * f, nil
+	}
+	if bits > 8*f || bits <= 8*(f-1) {
+		return 0, errors.New("fingerprint bits don't match filter")
+	}
+	return bits, nil
+}
+
+// cuckooLoad returns the load a CuckooFilter with b entries per bucket is
+// expected to reach before an insertion fails, as measured in the paper.
+func cuckooLoad(b uint) float64 {
+	switch {
+	case b == 1:
+		return 0.5
+	case b < 4:
+		return 0.84
+	case b < 8:
+		return 0.95
+	}
+	return 0.98
+}
+
+// CuckooFPRate returns the upper bound on the false-positive rate of a
+// CuckooFilter with fingerprints of the given number of bits and the given
+// number of entries per bucket, 2*b/2^bits, which it approaches as it fills.
+func CuckooFPRate(fingerprintBits, entriesPerBucket uint) float64 {
+	return math.Min(1, 2*float64(entriesPerBucket)/math.Exp2(float64(fingerprintBits)))
+}
+
 // power2 calculates the next power of two for the given value.
 func power2(x uint) uint {
 	x--
@@ -267,3 +1098,15 @@ func power2(x uint) uint {
 	x++
 	return x
 }
+
+// CuckooMemory returns the estimated number of bytes used to store the
+// fingerprints of a CuckooFilter optimized to store n items with the specified
+// target false-positive rate.
+func CuckooMemory(n uint, fpRate float64) uint64 {
+	var (
+		b = uint(4)
+		f = calculateF(b, fpRate)
+		m = power2(n / uint(f) * 8)
+	)
+	return uint64(m) * uint64(b) * uint64(f)
+}
@@ -1,7 +1,11 @@
 package boom
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -101,6 +105,30 @@ func TestCuckooTestAndAdd(t *testing.T) {
 	}
 }
 
+// Ensures that NewCuckooFilterFromReader adds every delimited key of the
+// stream and returns an error once the filter is full.
+func TestNewCuckooFilterFromReader(t *testing.T) {
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	data := strings.Join(keys, "\n")
+
+	f, err := NewCuckooFilterFromReader(strings.NewReader(data), '\n', 0.01)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, key := range keys {
+		if !f.TestString(key) {
+			t.Errorf("Expected %s to be a member", key)
+		}
+	}
+
+	if _, err := NewCuckooFilterFromReader(io.MultiReader(strings.NewReader(data)), '\n', 0.01, WithHint(10)); err == nil {
+		t.Error("Expected error once the filter is full")
+	}
+}
+
 // Ensures that TestAndRemove behaves correctly.
 func TestCuckooTestAndRemove(t *testing.T) {
 	f := NewCuckooFilter(100, 0.1)
@@ -202,3 +230,383 @@ func BenchmarkCuckooTestAndRemove(b *testing.B) {
 		f.TestAndRemove(data[n])
 	}
 }
+
+// Ensures that AddAllContext returns the number of items added before the
+// context was cancelled.
+func TestCuckooAddAllContext(t *testing.T) {
+	var (
+		f         = NewCuckooFilter(10000, 0.01)
+		data      = make([][]byte, 3000)
+		ctx, stop = context.WithCancel(context.Background())
+	)
+	for i := range data {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+
+	added, err := f.AddAllContext(ctx, data)
+	if err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+
+	if added != len(data) || f.Count() != uint(len(data)) {
+		t.Errorf("Expected %d, got %d", len(data), added)
+	}
+
+	stop()
+	f.Reset()
+	added, err = f.AddAllContext(ctx, data)
+	if err != context.Canceled {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+
+	if added != 0 || f.Count() != 0 {
+		t.Errorf("Expected 0, got %d", added)
+	}
+}
+
+// Ensures that a CuckooFilter read from its written representation has the
+// same members.
+func TestCuckooWriteToReadFrom(t *testing.T) {
+	f := NewCuckooFilter(1000, 0.01)
+	for i := 0; i < 500; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	restored := NewCuckooFilter(10, 0.1)
+	var buf bytes.Buffer
+	wn, err := f.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	rn, err := restored.ReadFrom(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if wn != rn {
+		t.Errorf("Expected %d bytes read, got %d", wn, rn)
+	}
+
+	if restored.Buckets() != f.Buckets() || restored.Capacity() != f.Capacity() || restored.Count() != f.Count() {
+		t.Errorf("Expected %d, %d, %d, got %d, %d, %d", f.Buckets(), f.Capacity(), f.Count(),
+			restored.Buckets(), restored.Capacity(), restored.Count())
+	}
+
+	for i := 0; i < 2000; i++ {
+		data := []byte(strconv.Itoa(i))
+		if restored.Test(data) != f.Test(data) {
+			t.Errorf("Expected %t for %s, got %t", f.Test(data), data, restored.Test(data))
+		}
+	}
+
+	if !restored.TestAndRemove([]byte(`1`)) || restored.Test([]byte(`1`)) {
+		t.Error("Expected `1` to be removed")
+	}
+}
+
+// Ensures that NewCuckooFilterWithParams creates a filter with the given
+// fingerprint length and bucket size whose false-positive rate stays below
+// the derived bound, and that the parameters are serialized.
+func TestNewCuckooFilterWithParams(t *testing.T) {
+	for _, params := range [][2]uint{{12, 2}, {7, 4}, {20, 8}} {
+		f, err := NewCuckooFilterWithParams(1000, params[0], params[1], WithSeed(1))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if f.FingerprintBits() != params[0] || f.EntriesPerBucket() != params[1] {
+			t.Errorf("Expected %d bits and %d entries, got %d and %d",
+				params[0], params[1], f.FingerprintBits(), f.EntriesPerBucket())
+		}
+
+		for i := 0; i < 1000; i++ {
+			if err := f.Add([]byte(strconv.Itoa(i))); err != nil {
+				t.Fatalf("Unexpected error adding %d: %v", i, err)
+			}
+		}
+
+		fp := 0
+		for i := 1000; i < 101000; i++ {
+			if f.Test([]byte(strconv.Itoa(i))) {
+				fp++
+			}
+		}
+		bound := CuckooFPRate(params[0], params[1])
+		if rate := float64(fp) / 100000; rate > bound {
+			t.Errorf("Expected false-positive rate below %f, got %f", bound, rate)
+		}
+		if rate := f.EstimatedFPRate(); rate <= 0 || rate > bound {
+			t.Errorf("Expected estimated false-positive rate below %f, got %f", bound, rate)
+		}
+
+		data, err := f.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		restored := &CuckooFilter{}
+		if err := restored.UnmarshalBinary(data); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !restored.Equal(f) {
+			t.Error("Expected restored filter to equal original")
+		}
+		for i := 0; i < 2000; i++ {
+			data := []byte(strconv.Itoa(i))
+			if restored.Test(data) != f.Test(data) {
+				t.Errorf("Expected %t for %s, got %t", f.Test(data), data, restored.Test(data))
+			}
+		}
+	}
+
+	if _, err := NewCuckooFilterWithParams(1000, 33, 4); err == nil {
+		t.Error("Expected error for 33-bit fingerprints")
+	}
+	if _, err := NewCuckooFilterWithParams(1000, 8, 0); err == nil {
+		t.Error("Expected error for empty buckets")
+	}
+}
+
+// Ensures that an insertion into a full filter which is restored doesn't
+// remove an item.
+func TestCuckooInsertRestore(t *testing.T) {
+	f := NewCuckooFilter(1, 0.5)
+	added := 0
+	var buf [4]byte
+	for i := 0; ; i++ {
+		i1, i2, fp := f.components([]byte(strconv.Itoa(i)), &buf)
+		if !f.insert(i1, i2, append([]byte(nil), fp...), true) {
+			break
+		}
+		added = i + 1
+	}
+
+	for i := 0; i < added; i++ {
+		if !f.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+	if f.Count() != uint(added) {
+		t.Errorf("Expected %d items, got %d", added, f.Count())
+	}
+}
+
+// Ensures that insertions which fail once no item can be relocated are
+// stashed until the stash is full, without removing any item.
+func TestCuckooStash(t *testing.T) {
+	f, err := NewCuckooFilterWithParams(64, 8, 1, WithStash(4), WithSeed(2))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	added := 0
+	for ; added < 1000 && f.Stashed() < 4; added++ {
+		if err := f.Add([]byte(strconv.Itoa(added))); err != nil {
+			t.Fatalf("Unexpected error adding %d: %v", added, err)
+		}
+	}
+	if f.Stashed() != 4 || f.Count() != uint(added) {
+		t.Fatalf("Expected 4 stashed items of %d, got %d of %d", added, f.Stashed(), f.Count())
+	}
+
+	for i := 0; i < added; i++ {
+		if !f.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restored := &CuckooFilter{}
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !restored.Equal(f) || !f.Clone().Equal(f) {
+		t.Error("Expected restored and cloned filters to equal original")
+	}
+
+	for i := 0; i < added && f.Stashed() > 0; i++ {
+		var (
+			data      = []byte(strconv.Itoa(i))
+			buf       [4]byte
+			i1, i2, p = f.components(data, &buf)
+		)
+		if f.stashIndex(i1, i2, p) != -1 {
+			stashed := f.Stashed()
+			if !f.TestAndRemove(data) || f.Stashed() != stashed-1 {
+				t.Errorf("Expected %d to be removed from the stash", i)
+			}
+		}
+	}
+	if f.Stashed() != 0 {
+		t.Errorf("Expected every stashed item to be removed, got %d", f.Stashed())
+	}
+
+	f.Add([]byte(`a`))
+	if f.Reset(); f.Stashed() != 0 || f.Count() != 0 {
+		t.Errorf("Expected empty filter, got %d stashed of %d", f.Stashed(), f.Count())
+	}
+}
+
+// Ensures that Range visits every item, including stashed ones, and stops
+// when the callback returns false.
+func TestCuckooRange(t *testing.T) {
+	f, err := NewCuckooFilterWithParams(64, 12, 1, WithStash(2), WithSeed(3))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 0; i < 1000 && f.Stashed() < 2; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	var visited, stashed uint
+	f.Range(func(bucket uint, fingerprint []byte) bool {
+		if bucket >= f.Buckets() || len(fingerprint) != 2 {
+			t.Errorf("Unexpected bucket %d with fingerprint %v", bucket, fingerprint)
+		}
+		if visited++; visited > f.Count()-f.Stashed() {
+			stashed++
+		}
+		return true
+	})
+	if visited != f.Count() || stashed != 2 {
+		t.Errorf("Expected %d items with 2 stashed, got %d with %d", f.Count(), visited, stashed)
+	}
+
+	visited = 0
+	f.Range(func(uint, []byte) bool {
+		visited++
+		return visited < 3
+	})
+	if visited != 3 {
+		t.Errorf("Expected 3 items visited, got %d", visited)
+	}
+}
+
+// Ensures that a filter read by ReadCompactFrom matches the one written by
+// WriteCompactTo, which is smaller than WriteTo's representation.
+func TestCuckooWriteCompactToReadCompactFrom(t *testing.T) {
+	for _, bits := range []uint{8, 12, 20} {
+		f, err := NewCuckooFilterWithParams(500, bits, 4, WithStash(1), WithSeed(5))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for i := 0; i < 300; i++ {
+			f.Add([]byte(strconv.Itoa(i)))
+		}
+
+		var compact, full bytes.Buffer
+		n, err := f.WriteCompactTo(&compact)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if n != int64(compact.Len()) {
+			t.Errorf("Expected %d bytes written, got %d", compact.Len(), n)
+		}
+		if _, err := f.WriteTo(&full); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if compact.Len() >= full.Len() {
+			t.Errorf("Expected compact size below %d, got %d", full.Len(), compact.Len())
+		}
+
+		restored := NewCuckooFilter(1, 0.1)
+		if _, err := restored.ReadCompactFrom(&compact); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !restored.Equal(f) {
+			t.Errorf("Expected restored filter with %d-bit fingerprints to equal original", bits)
+		}
+		for i := 0; i < 300; i++ {
+			if !restored.Test([]byte(strconv.Itoa(i))) {
+				t.Errorf("Expected %d to be a member", i)
+			}
+		}
+	}
+
+	if _, err := NewCuckooFilter(1, 0.1).ReadCompactFrom(bytes.NewReader(nil)); err == nil {
+		t.Error("Expected error reading empty stream")
+	}
+}
+
+// Ensures that a filter restored from the chunks returned by ScanDump matches
+// the original, including fingerprints spanning chunks.
+func TestCuckooScanDumpLoadChunk(t *testing.T) {
+	f := NewCuckooFilter(1000, 0.01)
+	for i := 0; i < 500; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	restored := NewCuckooFilter(10, 0.1)
+	for iter := uint64(0); ; {
+		next, chunk, err := f.ScanDump(iter, 7)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if next == 0 {
+			break
+		}
+		if err := restored.LoadChunk(next, chunk); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		iter = next
+	}
+
+	if restored.Buckets() != f.Buckets() || restored.Count() != f.Count() {
+		t.Errorf("Expected %d buckets and %d items, got %d and %d",
+			f.Buckets(), f.Count(), restored.Buckets(), restored.Count())
+	}
+
+	for i := 0; i < 2000; i++ {
+		data := []byte(strconv.Itoa(i))
+		if restored.Test(data) != f.Test(data) {
+			t.Errorf("Expected %t for %s, got %t", f.Test(data), data, restored.Test(data))
+		}
+	}
+
+	if _, _, err := f.ScanDump(1, 0); err == nil {
+		t.Error("Expected error for chunk size of zero")
+	}
+}
+
+// Ensures that LoadChunk doesn't allocate the buckets described by a crafted
+// header until their data has been loaded, and rejects sizes that overflow.
+func TestCuckooLoadChunkCraftedHeader(t *testing.T) {
+	header := func(m, b, f uint64) []byte {
+		var e encoder
+		e.write(m)
+		e.write(b)
+		e.write(f)
+		e.write(uint64(0))
+		e.write(uint64(0))
+		var buf bytes.Buffer
+		if _, err := writeFrame(&buf, e.buf.Bytes()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	f := NewCuckooFilter(100, 0.01)
+	f.Add([]byte("a"))
+	buckets := f.Buckets()
+
+	if err := f.LoadChunk(1, header(1<<62, 4, 1)); err == nil {
+		t.Error("Expected error for overflowing size")
+	}
+
+	// The header is valid but describes terabytes of buckets, which are only
+	// allocated once that much data has been loaded.
+	if err := f.LoadChunk(1, header(1<<40, 4, 1)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := f.LoadChunk(1+8, make([]byte, 8)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := f.LoadChunk(1+8, make([]byte, 8)); err == nil {
+		t.Error("Expected error for out of order chunk")
+	}
+	if f.Buckets() != buckets || !f.Test([]byte("a")) {
+		t.Error("Expected incomplete dump to leave the filter unchanged")
+	}
+}
@@ -0,0 +1,241 @@
+package boom
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"time"
+	"unsafe"
+)
+
+// DecayingCountMinSketch is a Count-Min Sketch whose counts decay over time,
+// halving every half-life, so its estimates reflect recent traffic rather
+// than everything since it was created. An item added n half-lives ago counts
+// for 1/2^n of an item added now.
+//
+// The sketch decays in whole half-lives from the first timestamp passed to
+// Add: once a half-life has elapsed, the next Add halves every counter before
+// counting the item, rounding down. Count and TotalCount apply the decay for
+// the half-lives elapsed at the time they're given without changing the
+// sketch. Timestamps needn't be in order, but an item can't be added to a
+// half-life which has already decayed, so one timestamped earlier is counted
+// as of the current half-life.
+type DecayingCountMinSketch struct {
+	cms      *CountMinSketch // sketch of the current half-life
+	halfLife int64           // half-life in nanoseconds
+	epoch    int64           // start of the current half-life in Unix nanoseconds
+	started  bool            // the epoch has been set by the first addition
+}
+
+// NewDecayingCountMinSketch creates a new Decaying Count-Min Sketch whose
+// relative accuracy is within a factor of epsilon with probability delta, and
+// whose counts halve every half-life.
+func NewDecayingCountMinSketch(epsilon, delta float64, halfLife time.Duration, opts ...Option) *DecayingCountMinSketch {
+	if halfLife <= 0 {
+		halfLife = 1
+	}
+	return &DecayingCountMinSketch{
+		cms:      NewCountMinSketch(epsilon, delta, opts...),
+		halfLife: int64(halfLife),
+	}
+}
+
+// HalfLife returns the time after which the counts halve.
+func (d *DecayingCountMinSketch) HalfLife() time.Duration {
+	return time.Duration(d.halfLife)
+}
+
+// Epsilon returns the relative-accuracy factor, epsilon.
+func (d *DecayingCountMinSketch) Epsilon() float64 {
+	return d.cms.Epsilon()
+}
+
+// Delta returns the relative-accuracy probability, delta.
+func (d *DecayingCountMinSketch) Delta() float64 {
+	return d.cms.Delta()
+}
+
+// Add will add the data at the time, first decaying the counts for the
+// half-lives elapsed since the last decay. Returns the DecayingCountMinSketch
+// to allow for chaining.
+func (d *DecayingCountMinSketch) Add(data []byte, t time.Time) *DecayingCountMinSketch {
+	if !d.started {
+		d.epoch = t.UnixNano()
+		d.started = true
+	}
+	if halvings := d.halvings(t); halvings > 0 {
+		d.cms.Decay(halvings)
+		d.epoch += int64(halvings) * d.halfLife
+	}
+
+	d.cms.Add(data)
+	return d
+}
+
+// Count returns the approximate decayed count for the specified item at the
+// time, correct within epsilon * decayed total count with a probability of
+// delta.
+func (d *DecayingCountMinSketch) Count(data []byte, t time.Time) uint64 {
+	return d.cms.Count(data) >> d.halvings(t)
+}
+
+// TotalCount returns the decayed number of items added at the time.
+func (d *DecayingCountMinSketch) TotalCount(t time.Time) uint64 {
+	return d.cms.TotalCount() >> d.halvings(t)
+}
+
+// Merge combines this DecayingCountMinSketch with another with the same
+// half-life, epsilon, delta, and hash seed, such as to combine sketches built
+// on different shards. The sketch whose epoch is earlier is decayed to the
+// other's first, rounding down to whole half-lives, and the other sketch is
+// unchanged. Returns an error if they are incompatible, in which case this
+// sketch is unchanged.
+func (d *DecayingCountMinSketch) Merge(other *DecayingCountMinSketch) error {
+	if d.halfLife != other.halfLife {
+		return errors.New("half-life must match")
+	}
+
+	var (
+		cms   = other.cms
+		epoch = other.epoch
+	)
+	if !other.started {
+		epoch = d.epoch
+	} else if d.started && epoch < d.epoch {
+		// Decay a copy of the other sketch to this one's epoch.
+		cms = cms.Clone().Decay(uint((d.epoch - epoch) / d.halfLife))
+		epoch = d.epoch
+	}
+
+	merged := d.cms.Clone()
+	if d.started && epoch > d.epoch {
+		merged.Decay(uint((epoch - d.epoch) / d.halfLife))
+	}
+	if err := merged.Merge(cms); err != nil {
+		return err
+	}
+
+	d.cms = merged
+	if other.started {
+		d.epoch = epoch
+		d.started = true
+	}
+	return nil
+}
+
+// Reset restores the DecayingCountMinSketch to its original state. It returns
+// itself to allow for chaining.
+func (d *DecayingCountMinSketch) Reset() *DecayingCountMinSketch {
+	d.cms.Reset()
+	d.epoch = 0
+	d.started = false
+	return d
+}
+
+// Clone returns an independent copy of the DecayingCountMinSketch, which can
+// be used concurrently with the original.
+func (d *DecayingCountMinSketch) Clone() *DecayingCountMinSketch {
+	clone := *d
+	clone.cms = d.cms.Clone()
+	return &clone
+}
+
+// ByteSize returns the number of bytes used by the count matrix and metadata
+// of the sketch, excluding the hash function.
+func (d *DecayingCountMinSketch) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*d)) + d.cms.ByteSize()
+}
+
+// SetHash sets the hashing function used.
+func (d *DecayingCountMinSketch) SetHash(h hash.Hash64) {
+	d.cms.SetHash(h)
+}
+
+// halvings returns the number of half-lives elapsed at the time since the
+// last decay, which is zero before it.
+func (d *DecayingCountMinSketch) halvings(t time.Time) uint {
+	if !d.started {
+		return 0
+	}
+	elapsed := t.UnixNano() - d.epoch
+	if elapsed <= 0 {
+		return 0
+	}
+	return uint(elapsed / d.halfLife)
+}
+
+// WriteTo writes a binary representation of the DecayingCountMinSketch to an
+// I/O stream. The hash function is not written, but the seed is. It returns
+// the number of bytes written.
+func (d *DecayingCountMinSketch) WriteTo(stream io.Writer) (int64, error) {
+	var started uint8
+	if d.started {
+		started = 1
+	}
+
+	var e encoder
+	e.write(d.halfLife)
+	e.write(d.epoch)
+	e.write(started)
+	e.writeTo(d.cms)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a DecayingCountMinSketch (such as
+// might have been written by WriteTo()) from an I/O stream. The sketch keeps
+// its current hash function, which must match the one used by the sketch that
+// was written, or uses the default one if it has none. Returns
+// ErrUnsupportedVersion if the data was written by an incompatible version of
+// the package. It returns the number of bytes read.
+func (d *DecayingCountMinSketch) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		dec             = decoder{r: payload}
+		halfLife, epoch int64
+		started         uint8
+		cms             = &CountMinSketch{}
+	)
+	if d.cms != nil {
+		cms.hash = d.cms.hash
+	}
+	dec.read(&halfLife)
+	dec.read(&epoch)
+	dec.read(&started)
+	if dec.err != nil {
+		return n, dec.err
+	}
+
+	if halfLife <= 0 || started > 1 {
+		return n, errors.New("invalid sketch parameters")
+	}
+	dec.readFrom(cms)
+	if dec.err != nil {
+		return n, dec.err
+	}
+
+	d.cms = cms
+	d.halfLife = halfLife
+	d.epoch = epoch
+	d.started = started == 1
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (d *DecayingCountMinSketch) MarshalBinary() ([]byte, error) {
+	return marshalBinary(d)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (d *DecayingCountMinSketch) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(d, data)
+}
@@ -0,0 +1,87 @@
+package boom
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// Ensures that counts halve every half-life, both when read and once items are
+// added, so recent items outweigh old ones.
+func TestDecayingCountMinSketch(t *testing.T) {
+	var (
+		d     = NewDecayingCountMinSketch(0.001, 0.01, time.Minute)
+		start = time.Unix(1000, 0)
+	)
+	for i := 0; i < 64; i++ {
+		d.Add([]byte(`old`), start)
+	}
+	if count := d.Count([]byte(`old`), start.Add(59*time.Second)); count != 64 {
+		t.Errorf("Expected 64, got %d", count)
+	}
+	if count := d.Count([]byte(`old`), start.Add(2*time.Minute)); count != 16 {
+		t.Errorf("Expected 16, got %d", count)
+	}
+
+	now := start.Add(3 * time.Minute)
+	for i := 0; i < 10; i++ {
+		d.Add([]byte(`new`), now)
+	}
+	if old, recent := d.Count([]byte(`old`), now), d.Count([]byte(`new`), now); old != 8 || recent != 10 {
+		t.Errorf("Expected 8 and 10, got %d and %d", old, recent)
+	}
+	if total := d.TotalCount(now.Add(time.Minute)); total != 9 {
+		t.Errorf("Expected 9, got %d", total)
+	}
+
+	// Items timestamped before the current half-life count as of it.
+	d.Add([]byte(`new`), start)
+	if count := d.Count([]byte(`new`), now); count != 11 {
+		t.Errorf("Expected 11, got %d", count)
+	}
+
+	var buf bytes.Buffer
+	if _, err := d.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var read DecayingCountMinSketch
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if count := read.Count([]byte(`old`), now.Add(time.Minute)); count != 4 {
+		t.Errorf("Expected 4, got %d", count)
+	}
+	if read.Reset().TotalCount(now) != 0 {
+		t.Error("Expected an empty sketch")
+	}
+}
+
+// Ensures that merging decays the sketch with the earlier epoch to the other's.
+func TestDecayingCountMinSketchMerge(t *testing.T) {
+	var (
+		a     = NewDecayingCountMinSketch(0.001, 0.01, time.Minute)
+		b     = NewDecayingCountMinSketch(0.001, 0.01, time.Minute)
+		start = time.Unix(1000, 0)
+	)
+	for i := 0; i < 8; i++ {
+		a.Add([]byte(`x`), start)
+		b.Add([]byte(`x`), start.Add(2*time.Minute))
+	}
+
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if count := a.Count([]byte(`x`), start.Add(2*time.Minute)); count != 10 {
+		t.Errorf("Expected 10, got %d", count)
+	}
+	if count := b.Count([]byte(`x`), start.Add(2*time.Minute)); count != 8 {
+		t.Errorf("Expected the other sketch to be unchanged, got %d", count)
+	}
+
+	if err := a.Merge(NewDecayingCountMinSketch(0.001, 0.01, time.Hour)); err == nil {
+		t.Error("Expected an error for different half-lives")
+	}
+	if err := a.Merge(NewDecayingCountMinSketch(0.01, 0.01, time.Minute)); err == nil {
+		t.Error("Expected an error for different epsilons")
+	}
+}
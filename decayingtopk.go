@@ -0,0 +1,218 @@
+package boom
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"time"
+	"unsafe"
+)
+
+// DecayingTopK tracks the top-k most frequent elements of recent traffic, the
+// trending elements, using a DecayingCountMinSketch and min-heap. Counts halve
+// every half-life, so the top-k can be queried continuously instead of
+// resetting a TopK at arbitrary boundaries and losing its state. Since the
+// heap's frequencies halve with the sketch, its order is kept as it decays.
+type DecayingTopK struct {
+	cms      *DecayingCountMinSketch
+	k        uint
+	elements *elementHeap
+}
+
+// NewDecayingTopK creates a new DecayingTopK backed by a Decaying Count-Min
+// Sketch whose relative accuracy is within a factor of epsilon with
+// probability delta, and whose counts halve every half-life. It tracks the
+// k-most frequent elements.
+func NewDecayingTopK(epsilon, delta float64, k uint, halfLife time.Duration, opts ...Option) *DecayingTopK {
+	elements := make(elementHeap, 0, k)
+	return &DecayingTopK{
+		cms:      NewDecayingCountMinSketch(epsilon, delta, halfLife, opts...),
+		k:        k,
+		elements: &elements,
+	}
+}
+
+// HalfLife returns the time after which the counts halve.
+func (d *DecayingTopK) HalfLife() time.Duration {
+	return d.cms.HalfLife()
+}
+
+// Add will add the data at the time to the sketch, decaying it and the heap
+// for the half-lives elapsed, and update the top-k heap if applicable. Returns
+// the DecayingTopK to allow for chaining.
+func (d *DecayingTopK) Add(data []byte, t time.Time) *DecayingTopK {
+	started, epoch := d.cms.started, d.cms.epoch
+	d.cms.Add(data, t)
+	if started && d.cms.epoch != epoch {
+		halvings := uint((d.cms.epoch - epoch) / d.cms.halfLife)
+		for _, element := range *d.elements {
+			element.freq >>= halvings
+		}
+	}
+
+	freq := d.cms.cms.Count(data)
+	if d.isTop(freq) {
+		d.insert(data, freq)
+	}
+	return d
+}
+
+// Elements returns the top-k elements from lowest to highest decayed
+// frequency.
+func (d *DecayingTopK) Elements() [][]byte {
+	elements := make(elementHeap, len(*d.elements))
+	copy(elements, *d.elements)
+	heap.Init(&elements)
+
+	topK := make([][]byte, 0, len(elements))
+	for elements.Len() > 0 {
+		topK = append(topK, heap.Pop(&elements).(*element).data)
+	}
+	return topK
+}
+
+// Estimates returns the top-k elements with their estimated frequencies
+// decayed to the time and the error bound, from lowest to highest estimate.
+// The error bound is epsilon times the decayed number of items added.
+func (d *DecayingTopK) Estimates(t time.Time) []TopKElement {
+	var (
+		bound     = uint64(math.Ceil(d.cms.Epsilon() * float64(d.cms.TotalCount(t))))
+		estimates = make(topKElements, 0, d.elements.Len())
+	)
+	for _, data := range d.Elements() {
+		estimates = append(estimates, TopKElement{Data: data, Count: d.cms.Count(data, t), Error: bound})
+	}
+	sort.Stable(estimates)
+	return estimates
+}
+
+// Reset restores the DecayingTopK to its original state. It returns itself to
+// allow for chaining.
+func (d *DecayingTopK) Reset() *DecayingTopK {
+	d.cms.Reset()
+	*d.elements = (*d.elements)[:0]
+	return d
+}
+
+// ByteSize returns the number of bytes used by the DecayingTopK's sketch, the
+// elements it tracks, and its metadata.
+func (d *DecayingTopK) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*d)) + d.cms.ByteSize() + sliceSize + uint64(cap(*d.elements))*pointerSize
+	for _, e := range *d.elements {
+		size += uint64(unsafe.Sizeof(*e)) + uint64(cap(e.data))
+	}
+	return size
+}
+
+// isTop indicates if the given frequency falls within the top-k heap.
+func (d *DecayingTopK) isTop(freq uint64) bool {
+	if d.elements.Len() < int(d.k) {
+		return true
+	}
+
+	return freq >= (*d.elements)[0].freq
+}
+
+// insert adds a copy of the data to the top-k heap. If the data is already an
+// element, the frequency is updated. If the heap already has k elements, the
+// element with the minimum frequency is removed.
+func (d *DecayingTopK) insert(data []byte, freq uint64) {
+	for i, element := range *d.elements {
+		if bytes.Equal(data, element.data) {
+			element.freq = freq
+			heap.Fix(d.elements, i)
+			return
+		}
+	}
+
+	if d.elements.Len() == int(d.k) {
+		heap.Pop(d.elements)
+	}
+	heap.Push(d.elements, &element{data: append([]byte(nil), data...), freq: freq})
+}
+
+// WriteTo writes a binary representation of the DecayingTopK to an I/O
+// stream, including the underlying sketch and the elements of the top-k heap
+// with their frequencies. The hash function is not written. It returns the
+// number of bytes written.
+func (d *DecayingTopK) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(d.k))
+	e.writeTo(d.cms)
+	e.write(uint64(d.elements.Len()))
+	for _, element := range *d.elements {
+		e.write(element.freq)
+		e.writeBytes(element.data)
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a DecayingTopK (such as might
+// have been written by WriteTo()) from an I/O stream. The underlying sketch
+// keeps its current hash function, or uses the default one if it has none.
+// Returns ErrUnsupportedVersion if the data was written by an incompatible
+// version of the package. It returns the number of bytes read.
+func (d *DecayingTopK) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		dec  = decoder{r: payload}
+		k, l uint64
+		cms  = &DecayingCountMinSketch{}
+	)
+	if d.cms != nil {
+		cms.cms = &CountMinSketch{hash: d.cms.cms.hash}
+	}
+	dec.read(&k)
+	dec.readFrom(cms)
+	dec.read(&l)
+	if dec.err != nil {
+		return n, dec.err
+	}
+
+	if l > k {
+		return n, errors.New("heap exceeds k elements")
+	}
+	// Every element takes at least 16 bytes for its frequency and length.
+	if l > uint64(payload.Len())/16 {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	elements := make(elementHeap, 0, l)
+	for i := uint64(0); i < l && dec.err == nil; i++ {
+		var freq uint64
+		dec.read(&freq)
+		elements = append(elements, &element{data: dec.readBytes(), freq: freq})
+	}
+	if dec.err != nil {
+		return n, dec.err
+	}
+	heap.Init(&elements)
+
+	d.cms = cms
+	d.k = uint(k)
+	d.elements = &elements
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (d *DecayingTopK) MarshalBinary() ([]byte, error) {
+	return marshalBinary(d)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (d *DecayingTopK) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(d, data)
+}
@@ -0,0 +1,82 @@
+package boom
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Ensures that a DecayingTopK ranks recent items above items which were
+// frequent long ago, and that its estimates decay.
+func TestDecayingTopK(t *testing.T) {
+	var (
+		topk  = NewDecayingTopK(0.001, 0.01, 2, time.Minute)
+		start = time.Unix(1000, 0)
+	)
+	for i := 0; i < 100; i++ {
+		topk.Add([]byte(`old`), start)
+		topk.Add([]byte(strconv.Itoa(i)), start)
+	}
+	now := start.Add(5 * time.Minute)
+	for i := 0; i < 10; i++ {
+		topk.Add([]byte(`new`), now).Add([]byte(`newer`), now).Add([]byte(`newer`), now)
+	}
+
+	expected := []string{"new", "newer"}
+	actual := topk.Elements()
+	if l := len(actual); l != 2 {
+		t.Fatalf("Expected len 2, got %d", l)
+	}
+	for i, element := range actual {
+		if e := string(element); e != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], e)
+		}
+	}
+
+	estimates := topk.Estimates(now.Add(time.Minute))
+	if estimates[0].Count != 5 || estimates[1].Count != 10 {
+		t.Errorf("Expected 5 and 10, got %d and %d", estimates[0].Count, estimates[1].Count)
+	}
+
+	var buf bytes.Buffer
+	if _, err := topk.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var read DecayingTopK
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range read.Estimates(now.Add(time.Minute)) {
+		if !bytes.Equal(e.Data, estimates[i].Data) || e.Count != estimates[i].Count {
+			t.Errorf("Expected %v, got %v", estimates[i], e)
+		}
+	}
+
+	if topk.Reset() != topk || len(topk.Elements()) != 0 {
+		t.Error("Expected no elements")
+	}
+}
+
+// Ensures that ReadFrom allocates for the elements read rather than for k,
+// which is unchecked, and rejects more elements than the data can hold.
+func TestDecayingTopKReadFromLargeK(t *testing.T) {
+	for _, l := range []uint64{0, 1 << 59} {
+		var e encoder
+		e.write(uint64(1 << 60))
+		e.writeTo(NewDecayingCountMinSketch(0.1, 0.1, time.Minute))
+		e.write(l)
+
+		var buf bytes.Buffer
+		if _, err := writeFrame(&buf, e.buf.Bytes()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		read := &DecayingTopK{}
+		_, err := read.ReadFrom(&buf)
+		if l == 0 && (err != nil || cap(*read.elements) != 0) {
+			t.Errorf("Expected no error and capacity 0, got %v", err)
+		} else if l != 0 && err == nil {
+			t.Error("Expected error for more elements than the data holds")
+		}
+	}
+}
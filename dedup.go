@@ -0,0 +1,118 @@
+package boom
+
+import (
+	"bytes"
+	"io"
+)
+
+// Deduplicate passes along every item received from in which the filter
+// doesn't report as a member, in order, adding every item to the filter, so
+// duplicates are dropped from the stream. The returned channel is closed once
+// in is closed, and must be drained until then, since the goroutine
+// deduplicating the stream blocks until each item is received. Items are
+// passed along without being copied, so their senders mustn't reuse them.
+//
+// A filter with false positives, such as a ScalableBloomFilter, drops some
+// items which aren't duplicates, whereas an InverseBloomFilter lets some
+// duplicates through but never drops an item seen for the first time. The
+// filter is used by the goroutine until in is closed, so it must be wrapped in
+// a SafeFilter to be used elsewhere meanwhile.
+func Deduplicate(in <-chan []byte, f Filter) <-chan []byte {
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+		for data := range in {
+			if !f.TestAndAdd(data) {
+				out <- data
+			}
+		}
+	}()
+	return out
+}
+
+// DedupWriter is an io.Writer which writes each line written to it to an
+// underlying writer unless the filter reports it as a member, so duplicate
+// lines are dropped, such as when piping logs or keys through a process. Lines
+// end with a newline, which is written with them but isn't part of the key
+// added to the filter. A line may span several writes, and is held until its
+// newline is written or Flush is called.
+type DedupWriter struct {
+	w       io.Writer
+	filter  Filter
+	partial []byte // start of a line awaiting its newline
+	dropped uint   // number of duplicate lines dropped
+	err     error  // first error of the underlying writer
+}
+
+// NewDedupWriter creates a new DedupWriter writing the lines which the filter
+// doesn't report as members to w.
+func NewDedupWriter(w io.Writer, f Filter) *DedupWriter {
+	return &DedupWriter{w: w, filter: f}
+}
+
+// Write writes every complete line of p which isn't a duplicate to the
+// underlying writer and holds the rest of p until its newline is written. It
+// returns len(p) unless the underlying writer fails, in which case it returns
+// the number of bytes of p before the line which failed, and every later write
+// returns the same error.
+func (d *DedupWriter) Write(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	n := 0
+	for {
+		i := bytes.IndexByte(p[n:], '\n')
+		if i < 0 {
+			break
+		}
+
+		line := p[n : n+i+1]
+		if len(d.partial) > 0 {
+			line = append(d.partial, line...)
+		}
+		if err := d.writeLine(line); err != nil {
+			return n, err
+		}
+		d.partial = d.partial[:0]
+		n += i + 1
+	}
+
+	d.partial = append(d.partial, p[n:]...)
+	return len(p), nil
+}
+
+// Flush writes the line held since the last newline, unless it's a duplicate,
+// such as the last line of a stream which doesn't end with a newline. Returns
+// the error of the underlying writer, if it failed.
+func (d *DedupWriter) Flush() error {
+	if d.err != nil || len(d.partial) == 0 {
+		return d.err
+	}
+
+	err := d.writeLine(d.partial)
+	d.partial = d.partial[:0]
+	return err
+}
+
+// Dropped returns the number of duplicate lines dropped.
+func (d *DedupWriter) Dropped() uint {
+	return d.dropped
+}
+
+// writeLine writes the line to the underlying writer unless the filter
+// reports it as a member without its newline, recording the writer's error.
+// The key is copied, since filters such as InverseBloomFilter retain it, and
+// the line belongs to the caller or to the buffer of the partial line.
+func (d *DedupWriter) writeLine(line []byte) error {
+	key := append([]byte(nil), bytes.TrimSuffix(line, []byte{'\n'})...)
+	if d.filter.TestAndAdd(key) {
+		d.dropped++
+		return nil
+	}
+
+	if _, err := d.w.Write(line); err != nil {
+		d.err = err
+	}
+	return d.err
+}
@@ -0,0 +1,129 @@
+package boom
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Ensures that Deduplicate passes along the first occurrence of every item in
+// order and closes its channel once the input is closed.
+func TestDeduplicate(t *testing.T) {
+	in := make(chan []byte)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			in <- []byte(strconv.Itoa(i % 100))
+		}
+		close(in)
+	}()
+
+	var out []string
+	for data := range Deduplicate(in, NewInverseBloomFilter(1000)) {
+		out = append(out, string(data))
+	}
+
+	if len(out) > 1000 || len(out) < 100 {
+		t.Fatalf("Expected between 100 and 1000 items, got %d", len(out))
+	}
+	for i := 0; i < 100; i++ {
+		if out[i] != strconv.Itoa(i) {
+			t.Errorf("Expected %d at %d, got %s", i, i, out[i])
+		}
+	}
+
+	in = make(chan []byte, 3)
+	in <- []byte(`a`)
+	in <- []byte(`a`)
+	in <- []byte(`b`)
+	close(in)
+	out = out[:0]
+	for data := range Deduplicate(in, NewBloomFilter(100, 0.01)) {
+		out = append(out, string(data))
+	}
+	if len(out) != 2 || out[0] != "a" || out[1] != "b" {
+		t.Errorf("Expected [a b], got %v", out)
+	}
+}
+
+// Ensures that a DedupWriter drops duplicate lines, including lines spanning
+// several writes and a final line flushed without its newline.
+func TestDedupWriter(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		w   = NewDedupWriter(&buf, NewBloomFilter(100, 0.01))
+	)
+	for _, p := range []string{"a\nb\n", "a", "\nc\nb", "\nd", ""} {
+		if n, err := w.Write([]byte(p)); err != nil || n != len(p) {
+			t.Fatalf("Expected %d bytes written, got %d and error %v", len(p), n, err)
+		}
+	}
+	if got := buf.String(); got != "a\nb\nc\n" {
+		t.Errorf("Expected %q, got %q", "a\nb\nc\n", got)
+	}
+
+	if _, err := w.Write([]byte("\n")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	w.Write([]byte("c"))
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := buf.String(); got != "a\nb\nc\nd\n" {
+		t.Errorf("Expected %q, got %q", "a\nb\nc\nd\n", got)
+	}
+	if dropped := w.Dropped(); dropped != 3 {
+		t.Errorf("Expected 3 lines dropped, got %d", dropped)
+	}
+}
+
+// Ensures that a DedupWriter doesn't retain the lines written to it, so a
+// filter keeping its keys, such as an InverseBloomFilter, still tells
+// distinct lines apart when the caller reuses its buffer.
+func TestDedupWriterReusedBuffer(t *testing.T) {
+	var (
+		buf  bytes.Buffer
+		w    = NewDedupWriter(&buf, NewInverseBloomFilter(16))
+		line = make([]byte, 0, 16)
+	)
+	for i := 0; i < 200; i++ {
+		line = append(strconv.AppendInt(line[:0], int64(i), 10), '\n')
+		if _, err := w.Write(line); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if dropped := w.Dropped(); dropped != 0 {
+		t.Errorf("Expected no distinct lines dropped, got %d", dropped)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 200 {
+		t.Errorf("Expected 200 lines, got %d", lines)
+	}
+}
+
+// Ensures that a DedupWriter reports the underlying writer's error on every
+// later write.
+func TestDedupWriterError(t *testing.T) {
+	failure := errors.New("write failed")
+	w := NewDedupWriter(failingWriter{failure}, NewBloomFilter(100, 0.01))
+
+	if n, err := w.Write([]byte("a\nb\n")); err != failure || n != 0 {
+		t.Errorf("Expected 0 bytes and the writer's error, got %d and %v", n, err)
+	}
+	if _, err := w.Write([]byte("c\n")); err != failure {
+		t.Errorf("Expected the writer's error, got %v", err)
+	}
+	if err := w.Flush(); err != failure {
+		t.Errorf("Expected the writer's error, got %v", err)
+	}
+}
+
+// failingWriter is an io.Writer which always fails.
+type failingWriter struct {
+	err error
+}
+
+func (f failingWriter) Write(p []byte) (int, error) {
+	return 0, f.err
+}
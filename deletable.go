@@ -0,0 +1,398 @@
+package boom
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"unsafe"
+)
+
+// DeletableBloomFilter implements a Deletable Bloom Filter as described by
+// Rothenberg, Macapuna, Verdi, and Magalhães in The Deletable Bloom filter: A
+// new member of the Bloom family:
+//
+// http://arxiv.org/pdf/1005.0352.pdf
+//
+// It removes items without counters by dividing its bit array into regions and
+// keeping one more bit per region, set once two items have set the same bit in
+// it. Bits in regions without such a collision belong to a single item, so
+// removing an item clears its bits in collision-free regions and leaves the
+// others. Clearing any one of an item's bits removes it, so an item can be
+// removed unless all of its bits are in regions with collisions, and removal
+// never causes a false negative for another item.
+//
+// Regions fill with collisions as items are added, so fewer items can be
+// removed over time. DeletableRatio reports the fraction of regions still
+// collision-free, and Rebuild re-adds the current items with more regions.
+// Removing an item which was never added, such as a false positive, may
+// remove others.
+type DeletableBloomFilter struct {
+	buckets    *Buckets    // filter data
+	collisions *Buckets    // whether each region has a collision
+	hash       hash.Hash64 // hash function (kernel for all k functions)
+	m          uint        // filter size
+	k          uint        // number of hash functions
+	regions    uint        // number of regions
+	regionSize uint        // number of bits in each region but the last
+	count      uint        // number of items added less those removed
+	failed     uint        // number of members which couldn't be removed
+	seed       uint64      // hash seed (zero means unseeded)
+	modulo     bool        // reduce hashes to indices with a modulo
+}
+
+// NewDeletableBloomFilter creates a new Deletable Bloom Filter optimized to
+// store n items with a specified target false-positive rate, whose bits are
+// divided into a number of regions, at least 1 and at most one per bit. More
+// regions take more memory but let more items be removed.
+func NewDeletableBloomFilter(n, regions uint, fpRate float64, opts ...Option) *DeletableBloomFilter {
+	var (
+		m = OptimalM(n, fpRate)
+		o = applyOptions(opts)
+	)
+	d := &DeletableBloomFilter{
+		buckets: NewBuckets(m, 1),
+		hash:    o.newHash64(newFNV64),
+		m:       m,
+		k:       OptimalK(fpRate),
+		seed:    o.seed,
+		modulo:  o.modulo,
+	}
+	d.setRegions(regions)
+	return d
+}
+
+// NewTunedDeletableBloomFilter creates a new Deletable Bloom Filter like
+// NewDeletableBloomFilter with the fewest regions such that, once it holds n
+// items, an item can be removed with at least the probability deletability,
+// or with one region per bit if no number of regions achieves it.
+func NewTunedDeletableBloomFilter(n uint, fpRate, deletability float64, opts ...Option) *DeletableBloomFilter {
+	var (
+		m    = OptimalM(n, fpRate)
+		k    = OptimalK(fpRate)
+		size = uint(1)
+	)
+	if deletability <= 0 {
+		size = m
+	}
+	for size < m && deletableProbability(n, m, k, size+1) >= deletability {
+		size++
+	}
+	return NewDeletableBloomFilter(n, (m+size-1)/size, fpRate, opts...)
+}
+
+// deletableProbability returns the probability that an item can be removed
+// from a filter of m bits and k hash functions holding n items, whose regions
+// have the size. One of the item's bits is collision-free unless another of
+// the nk bits set hits it, and any other bit unless two or more do, and the
+// item can be removed if any of its k bits is in a region of collision-free
+// bits.
+func deletableProbability(n, m, k, size uint) float64 {
+	var (
+		load = float64(n) * float64(k) / float64(m)
+		free = math.Exp(-load) * (1 + load)
+		own  = math.Exp(-load) * math.Pow(free, float64(size-1))
+	)
+	return 1 - math.Pow(1-own, float64(k))
+}
+
+// Capacity returns the Bloom filter capacity, m.
+func (d *DeletableBloomFilter) Capacity() uint {
+	return d.m
+}
+
+// K returns the number of hash functions.
+func (d *DeletableBloomFilter) K() uint {
+	return d.k
+}
+
+// Count returns the number of items in the filter, those added less those
+// removed, excluding members which couldn't be removed.
+func (d *DeletableBloomFilter) Count() uint {
+	return d.count
+}
+
+// Regions returns the number of regions the bits are divided into.
+func (d *DeletableBloomFilter) Regions() uint {
+	return d.regions
+}
+
+// DeletableRatio returns the fraction of regions without a collision, whose
+// bits can be cleared by removals. It starts at one and falls as items are
+// added, and once it's low, few items can be removed.
+func (d *DeletableBloomFilter) DeletableRatio() float64 {
+	return 1 - float64(d.collisions.ones())/float64(d.regions)
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. This is a probabilistic test, meaning there is a
+// non-zero probability of false positives but a zero probability of false
+// negatives.
+func (d *DeletableBloomFilter) Test(data []byte) bool {
+	lower, upper := sizedHashKernel(data, d.hash, d.seed, d.m)
+	for i := uint(0); i < d.k; i++ {
+		if d.buckets.Get(reducedIndex(lower, upper, i, d.m, d.modulo)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Add will add the data to the Bloom filter, marking a collision in the region
+// of each of its bits which was already set. It returns the filter to allow
+// for chaining.
+func (d *DeletableBloomFilter) Add(data []byte) Filter {
+	lower, upper := sizedHashKernel(data, d.hash, d.seed, d.m)
+	for i := uint(0); i < d.k; i++ {
+		idx := reducedIndex(lower, upper, i, d.m, d.modulo)
+		if d.buckets.Get(idx) != 0 {
+			d.collisions.Set(idx/d.regionSize, 1)
+		} else {
+			d.buckets.Set(idx, 1)
+		}
+	}
+	d.count++
+	return d
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data is a member, false if not.
+func (d *DeletableBloomFilter) TestAndAdd(data []byte) bool {
+	member := d.Test(data)
+	d.Add(data)
+	return member
+}
+
+// TestAndRemove will test for membership of the data and remove it from the
+// filter if it exists, clearing its bits in regions without a collision.
+// Returns true if the data was a member, false if not. A member whose bits
+// are all in regions with collisions remains a member, which TestAndRemoveCount
+// reports.
+func (d *DeletableBloomFilter) TestAndRemove(data []byte) bool {
+	member, _ := d.TestAndRemoveCount(data)
+	return member
+}
+
+// TestAndRemoveCount is equivalent to TestAndRemove, but also returns the
+// number of the data's k bits which couldn't be cleared because they're in
+// regions with collisions. The data was removed if that's less than K, so that
+// at least one of its bits was cleared. Otherwise it remains a member, isn't
+// subtracted from Count, and is counted by FailedRemovals, and callers may
+// record it in another structure instead. Zero is returned for data which
+// isn't a member.
+func (d *DeletableBloomFilter) TestAndRemoveCount(data []byte) (bool, uint) {
+	if !d.Test(data) {
+		return false, 0
+	}
+
+	var (
+		lower, upper = sizedHashKernel(data, d.hash, d.seed, d.m)
+		blocked      uint
+	)
+	for i := uint(0); i < d.k; i++ {
+		idx := reducedIndex(lower, upper, i, d.m, d.modulo)
+		if d.collisions.Get(idx/d.regionSize) == 0 {
+			d.buckets.Set(idx, 0)
+		} else {
+			blocked++
+		}
+	}
+	if blocked == d.k {
+		d.failed++
+	} else {
+		d.count--
+	}
+	return true, blocked
+}
+
+// FailedRemovals returns the number of removals of members which couldn't be
+// removed because all of their bits are in regions with collisions. It rises
+// as DeletableRatio falls, a sign the filter should be rebuilt with more
+// regions.
+func (d *DeletableBloomFilter) FailedRemovals() uint {
+	return d.failed
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (d *DeletableBloomFilter) TestString(data string) bool {
+	return d.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (d *DeletableBloomFilter) AddString(data string) Filter {
+	return d.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (d *DeletableBloomFilter) TestAndAddString(data string) bool {
+	return d.TestAndAdd(stringBytes(data))
+}
+
+// TestAndRemoveString is equivalent to TestAndRemove for a string, which is
+// hashed without being copied.
+func (d *DeletableBloomFilter) TestAndRemoveString(data string) bool {
+	return d.TestAndRemove(stringBytes(data))
+}
+
+// Rebuild clears the filter, divides its bits into a number of regions, and
+// adds the data, which should be the items currently in the filter since they
+// can't be recovered from its bits. Rebuilding with more regions restores the
+// ability to remove items once DeletableRatio has fallen. It returns the
+// filter to allow for chaining.
+func (d *DeletableBloomFilter) Rebuild(regions uint, data [][]byte) *DeletableBloomFilter {
+	d.buckets.Reset()
+	d.count = 0
+	d.failed = 0
+	d.setRegions(regions)
+	for _, item := range data {
+		d.Add(item)
+	}
+	return d
+}
+
+// Reset restores the Bloom filter to its original state. It returns the filter
+// to allow for chaining.
+func (d *DeletableBloomFilter) Reset() *DeletableBloomFilter {
+	d.buckets.Reset()
+	d.collisions.Reset()
+	d.count = 0
+	d.failed = 0
+	return d
+}
+
+// setRegions divides the bits into the number of regions, clamped to between
+// 1 and m, without collisions. The regions are rounded so they have the same
+// size but the last may be smaller.
+func (d *DeletableBloomFilter) setRegions(regions uint) {
+	if regions == 0 {
+		regions = 1
+	}
+	if regions > d.m {
+		regions = d.m
+	}
+	d.regionSize = (d.m + regions - 1) / regions
+	d.regions = (d.m + d.regionSize - 1) / d.regionSize
+	d.collisions = NewBuckets(d.regions, 1)
+}
+
+// Equal returns true if the other Deletable Bloom Filter has the same
+// parameters, hash seed, reduction of hashes to indices, number of items,
+// bits, and collisions. The hash functions and failed removals aren't
+// compared.
+func (d *DeletableBloomFilter) Equal(other *DeletableBloomFilter) bool {
+	return d.m == other.m && d.k == other.k && d.regions == other.regions &&
+		d.count == other.count && d.seed == other.seed && d.modulo == other.modulo &&
+		d.buckets.Equal(other.buckets) && d.collisions.Equal(other.collisions)
+}
+
+// Clone returns an independent copy of the Deletable Bloom Filter, which can be
+// used concurrently with the original.
+func (d *DeletableBloomFilter) Clone() *DeletableBloomFilter {
+	clone := *d
+	clone.buckets = d.buckets.Clone()
+	clone.collisions = d.collisions.Clone()
+	clone.hash = cloneHash64(d.hash)
+	return &clone
+}
+
+// ByteSize returns the number of bytes used by the bits, collisions, and
+// metadata of the filter, excluding the hash function.
+func (d *DeletableBloomFilter) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*d)) + d.buckets.ByteSize() + d.collisions.ByteSize()
+}
+
+// SetHash sets the hashing function used in the filter.
+func (d *DeletableBloomFilter) SetHash(h hash.Hash64) {
+	d.hash = h
+}
+
+// WriteTo writes a binary representation of the DeletableBloomFilter to an I/O
+// stream. The hash function is not written, but the seed is. It returns the
+// number of bytes written.
+func (d *DeletableBloomFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(d.m))
+	e.write(uint64(d.k))
+	e.write(uint64(d.regions))
+	e.write(uint64(d.count))
+	e.write(uint64(d.failed))
+	e.write(d.seed)
+	e.writeTo(d.buckets)
+	e.writeTo(d.collisions)
+	writeReduction(&e, d.modulo)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a DeletableBloomFilter (such as
+// might have been written by WriteTo()) from an I/O stream. The filter keeps
+// its current hash function, which must match the one used by the filter that
+// was written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (d *DeletableBloomFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		dec                                = decoder{r: payload}
+		m, k, regions, count, failed, seed uint64
+		buckets                            = &Buckets{}
+		collisions                         = &Buckets{}
+	)
+	dec.read(&m)
+	dec.read(&k)
+	dec.read(&regions)
+	dec.read(&count)
+	dec.read(&failed)
+	dec.read(&seed)
+	dec.readFrom(buckets)
+	dec.readFrom(collisions)
+	modulo, err := readReduction(&dec, payload)
+	if err != nil {
+		return n, err
+	}
+
+	if m == 0 || k == 0 || k > m || regions == 0 || regions > m || buckets.Count() != uint(m) ||
+		collisions.Count() != uint(regions) {
+		return n, errors.New("bits don't match filter size")
+	}
+	size := (m + regions - 1) / regions
+	if (m+size-1)/size != regions {
+		return n, errors.New("regions don't match filter size")
+	}
+
+	if d.hash == nil {
+		d.hash = newFNV64()
+	}
+	d.buckets = buckets
+	d.collisions = collisions
+	d.m = uint(m)
+	d.k = uint(k)
+	d.regions = uint(regions)
+	d.regionSize = uint(size)
+	d.count = uint(count)
+	d.failed = uint(failed)
+	d.seed = seed
+	d.modulo = modulo
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (d *DeletableBloomFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(d)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (d *DeletableBloomFilter) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(d, data)
+}
@@ -0,0 +1,131 @@
+package boom
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+// Ensures that removals never cause false negatives for the remaining items,
+// and that most items are removed while regions are mostly collision-free.
+func TestDeletableTestAndRemove(t *testing.T) {
+	d := NewDeletableBloomFilter(1000, 4096, 0.01)
+	for i := 0; i < 1000; i++ {
+		d.AddString(strconv.Itoa(i))
+	}
+	if ratio := d.DeletableRatio(); ratio <= 0 || ratio >= 1 {
+		t.Errorf("Expected a ratio between 0 and 1, got %f", ratio)
+	}
+
+	removed := 0
+	for i := 0; i < 500; i++ {
+		if !d.TestAndRemoveString(strconv.Itoa(i)) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+		if !d.TestString(strconv.Itoa(i)) {
+			removed++
+		}
+	}
+	for i := 500; i < 1000; i++ {
+		if !d.TestString(strconv.Itoa(i)) {
+			t.Fatalf("Expected %d to be a member", i)
+		}
+	}
+	if removed < 400 {
+		t.Errorf("Expected at least 400 removals, got %d", removed)
+	}
+	if count := d.Count(); count != 1000-uint(removed) || d.FailedRemovals() != 500-uint(removed) {
+		t.Errorf("Expected %d, got %d", 1000-removed, count)
+	}
+}
+
+// Ensures that TestAndRemoveCount reports the bits which couldn't be cleared
+// and counts the members which couldn't be removed.
+func TestDeletableTestAndRemoveCount(t *testing.T) {
+	d := NewDeletableBloomFilter(1000, 1, 0.01)
+	if member, blocked := d.TestAndRemoveCount([]byte(`a`)); member || blocked != 0 {
+		t.Errorf("Expected false and 0, got %t and %d", member, blocked)
+	}
+
+	d.Add([]byte(`a`))
+	if member, blocked := d.TestAndRemoveCount([]byte(`a`)); !member || blocked != 0 {
+		t.Errorf("Expected true and 0, got %t and %d", member, blocked)
+	}
+
+	// With a single region, one collision prevents every removal.
+	d.Add([]byte(`a`)).Add([]byte(`a`))
+	if member, blocked := d.TestAndRemoveCount([]byte(`a`)); !member || blocked != d.K() {
+		t.Errorf("Expected true and %d, got %t and %d", d.K(), member, blocked)
+	}
+	if !d.Test([]byte(`a`)) {
+		t.Error("`a` should still be a member")
+	}
+	if failed, count := d.FailedRemovals(), d.Count(); failed != 1 || count != 2 {
+		t.Errorf("Expected 1 and 2, got %d and %d", failed, count)
+	}
+}
+
+// Ensures that a tuned filter meets its target deletability, and that
+// Rebuild with more regions restores the ratio of collision-free regions.
+func TestDeletableTuned(t *testing.T) {
+	d := NewTunedDeletableBloomFilter(1000, 0.01, 0.9, WithSeed(11))
+	if regions := d.Regions(); regions == 0 || regions >= d.Capacity() {
+		t.Fatalf("Expected between 1 and %d regions, got %d", d.Capacity(), regions)
+	}
+	items := make([][]byte, 1000)
+	for i := range items {
+		items[i] = []byte(strconv.Itoa(i))
+		d.Add(items[i])
+	}
+
+	removed := 0
+	for _, item := range items {
+		clone := d.Clone()
+		clone.TestAndRemove(item)
+		if !clone.Test(item) {
+			removed++
+		}
+	}
+	if removed < 850 {
+		t.Errorf("Expected at least 850 removable items, got %d", removed)
+	}
+
+	ratio := d.DeletableRatio()
+	d.Rebuild(d.Regions()*4, items)
+	if d.DeletableRatio() <= ratio || d.Count() != 1000 {
+		t.Errorf("Expected a ratio above %f, got %f", ratio, d.DeletableRatio())
+	}
+	for _, item := range items {
+		if !d.Test(item) {
+			t.Fatalf("Expected %s to be a member", item)
+		}
+	}
+
+	if regions := NewTunedDeletableBloomFilter(1000, 0.01, 0).Regions(); regions != 1 {
+		t.Errorf("Expected 1 region, got %d", regions)
+	}
+}
+
+// Ensures that a DeletableBloomFilter read from its written representation is
+// equal to it.
+func TestDeletableWriteToReadFrom(t *testing.T) {
+	d := NewDeletableBloomFilter(100, 1000, 0.01, WithSeed(3))
+	for i := 0; i < 100; i++ {
+		d.AddString(strconv.Itoa(i))
+	}
+
+	var buf bytes.Buffer
+	if _, err := d.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var read DeletableBloomFilter
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !read.Equal(d) {
+		t.Error("Expected the filters to be equal")
+	}
+	if !read.TestAndRemoveString("7") || read.Equal(d) {
+		t.Error("Expected 7 to be removed from the read filter only")
+	}
+}
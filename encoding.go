@@ -0,0 +1,222 @@
+package boom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// The binary format version written by WriteTo. Every encoded structure is
+// framed by a header containing magic bytes identifying the format, the major
+// and minor version, the length of the payload, and a CRC-32 (IEEE) checksum
+// of the payload. The payload holds the structure's parameters followed by its
+// data.
+//
+// Compatibility between a reader and data written with version major.minor is
+// as follows:
+//
+//	same major, same or older minor: read fully
+//	same major, newer minor:         read with best effort; newer minor
+//	                                 versions only append fields to the
+//	                                 payload, which are skipped
+//	different major:                 ErrUnsupportedVersion
+//
+// Version 1 frames had no magic bytes or checksum and are no longer read.
+// Version 2.1 appended the hashing scheme to HyperLogLog payloads, version 2.2
+// the SipHash key to InverseBloomFilter payloads, and version 2.3 the hash seed
+// to the payloads of filters other than BloomFilter, which always had one.
+// Version 2.4 run-length encodes the data of sparse Buckets, flagged in the
+// high bit of its length, which older readers reject as an invalid length, and
+// appends the fingerprint length in bits and the stash to CuckooFilter
+// payloads. Version 2.5 appends the name of the Transformer to BloomFilter and
+// ScalableBloomFilter payloads, and version 2.6 how BloomFilter,
+// CountingBloomFilter, StableBloomFilter, and CountMinSketch payloads reduce
+// hashes to indices, which is with a modulo when it's absent. Version 2.7
+// appends the growth factor and tightening schedule to ScalableBloomFilter
+// payloads, and version 2.8 how PartitionedBloomFilter, ScalableBloomFilter,
+// DeletableBloomFilter, SpectralBloomFilter, ShiftingBloomFilter,
+// ExpiringBloomFilter, and AgePartitionedBloomFilter payloads reduce hashes to
+// indices, like version 2.6.
+const (
+	formatMajor = 2
+	formatMinor = 8
+)
+
+// formatMagic identifies the start of a frame.
+const formatMagic = "BOOM"
+
+var (
+	// ErrUnsupportedVersion is returned when reading data written with a
+	// binary format version that is incompatible with this version of the
+	// package.
+	ErrUnsupportedVersion = errors.New("unsupported format version")
+
+	// ErrInvalidFormat is returned when reading data which doesn't start
+	// with the magic bytes of the binary format.
+	ErrInvalidFormat = errors.New("invalid format")
+
+	// ErrChecksumMismatch is returned when reading data whose payload doesn't
+	// match the checksum in its header, meaning it has been corrupted.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// Offsets of the frame header fields and the size of the frame header in
+// bytes.
+const (
+	majorOffset    = 4 // after formatMagic
+	minorOffset    = majorOffset + 1
+	lengthOffset   = minorOffset + 1
+	checksumOffset = lengthOffset + 8
+	headerSize     = checksumOffset + 4
+)
+
+// writeFrame writes the payload to the stream preceded by the frame header.
+// Returns the number of bytes written.
+func writeFrame(stream io.Writer, payload []byte) (int64, error) {
+	var header [headerSize]byte
+	copy(header[:], formatMagic)
+	header[majorOffset] = formatMajor
+	header[minorOffset] = formatMinor
+	binary.BigEndian.PutUint64(header[lengthOffset:], uint64(len(payload)))
+	binary.BigEndian.PutUint32(header[checksumOffset:], crc32.ChecksumIEEE(payload))
+
+	n, err := stream.Write(header[:])
+	if err != nil {
+		return int64(n), err
+	}
+
+	m, err := stream.Write(payload)
+	return int64(n + m), err
+}
+
+// readFrame reads a frame from the stream and returns its payload. Returns
+// ErrInvalidFormat if the frame doesn't start with the magic bytes,
+// ErrUnsupportedVersion if the frame was written with an incompatible format
+// version, and ErrChecksumMismatch if the payload is corrupted. Payload fields
+// added by newer minor versions are left at the end of the returned payload
+// for the caller to ignore.
+func readFrame(stream io.Reader) (*bytes.Reader, int64, error) {
+	var header [headerSize]byte
+	n, err := io.ReadFull(stream, header[:])
+	if err != nil {
+		return nil, int64(n), err
+	}
+
+	if string(header[:majorOffset]) != formatMagic {
+		return nil, int64(n), ErrInvalidFormat
+	}
+
+	if header[majorOffset] != formatMajor {
+		return nil, int64(n), ErrUnsupportedVersion
+	}
+
+	var (
+		length  = binary.BigEndian.Uint64(header[lengthOffset:])
+		payload bytes.Buffer
+	)
+	m, err := io.CopyN(&payload, stream, int64(length))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, int64(n) + m, err
+	}
+
+	if crc32.ChecksumIEEE(payload.Bytes()) != binary.BigEndian.Uint32(header[checksumOffset:]) {
+		return nil, int64(n) + m, ErrChecksumMismatch
+	}
+
+	return bytes.NewReader(payload.Bytes()), int64(n) + m, nil
+}
+
+// marshalBinary returns the representation written by the value's WriteTo.
+func marshalBinary(w io.WriterTo) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshalBinary reads the value from a representation written by its
+// WriteTo.
+func unmarshalBinary(r io.ReaderFrom, data []byte) error {
+	_, err := r.ReadFrom(bytes.NewReader(data))
+	return err
+}
+
+// encoder writes fixed-size values to a payload, retaining the first error
+// encountered.
+type encoder struct {
+	buf bytes.Buffer
+	err error
+}
+
+// write writes the binary representation of data to the payload.
+func (e *encoder) write(data interface{}) {
+	if e.err == nil {
+		e.err = binary.Write(&e.buf, binary.BigEndian, data)
+	}
+}
+
+// writeBytes writes the length of data followed by data to the payload.
+func (e *encoder) writeBytes(data []byte) {
+	e.write(uint64(len(data)))
+	e.write(data)
+}
+
+// writeTo writes the value's framed encoding to the payload.
+func (e *encoder) writeTo(w io.WriterTo) {
+	if e.err == nil {
+		_, e.err = w.WriteTo(&e.buf)
+	}
+}
+
+// decoder reads fixed-size values from a payload, retaining the first error
+// encountered.
+type decoder struct {
+	r   io.Reader
+	err error
+}
+
+// read reads the binary representation of data from the payload.
+func (d *decoder) read(data interface{}) {
+	if d.err == nil {
+		d.err = binary.Read(d.r, binary.BigEndian, data)
+		if d.err == io.EOF {
+			d.err = io.ErrUnexpectedEOF
+		}
+	}
+}
+
+// readBytes reads a byte slice written by writeBytes from the payload. A
+// length exceeding the rest of the payload is reported as
+// io.ErrUnexpectedEOF rather than allocated.
+func (d *decoder) readBytes() []byte {
+	var length uint64
+	d.read(&length)
+	if d.err != nil {
+		return nil
+	}
+
+	if r, ok := d.r.(*bytes.Reader); ok && length > uint64(r.Len()) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+
+	data := make([]byte, length)
+	d.read(data)
+	return data
+}
+
+// readFrom reads the value's framed encoding from the payload.
+func (d *decoder) readFrom(r io.ReaderFrom) {
+	if d.err == nil {
+		_, d.err = r.ReadFrom(d.r)
+		if d.err == io.EOF {
+			d.err = io.ErrUnexpectedEOF
+		}
+	}
+}
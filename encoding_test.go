@@ -0,0 +1,183 @@
+package boom
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Ensures that data written by a newer minor version is read with best effort
+// and data written by a newer major version is rejected.
+func TestReadFromNewerVersion(t *testing.T) {
+	f := NewBloomFilter(100, 0.01)
+	for i := 0; i < 100; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data := buf.Bytes()
+
+	// A newer minor version appends fields to the payload.
+	newer := append([]byte{}, data...)
+	newer[minorOffset] = formatMinor + 1
+	newer = append(newer, 1, 2, 3, 4)
+	binary.BigEndian.PutUint64(newer[lengthOffset:], uint64(len(newer)-headerSize))
+	binary.BigEndian.PutUint32(newer[checksumOffset:], crc32.ChecksumIEEE(newer[headerSize:]))
+
+	other := &BloomFilter{}
+	other.SetHash(f.hash)
+	n, err := other.ReadFrom(bytes.NewReader(newer))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != int64(len(newer)) {
+		t.Errorf("Expected %d bytes read, got %d", len(newer), n)
+	}
+	for i := 0; i < 100; i++ {
+		if !other.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+
+	// A newer major version is incompatible.
+	incompatible := append([]byte{}, data...)
+	incompatible[majorOffset] = formatMajor + 1
+	if _, err := other.ReadFrom(bytes.NewReader(incompatible)); err != ErrUnsupportedVersion {
+		t.Errorf("Expected ErrUnsupportedVersion, got %v", err)
+	}
+
+	// Corrupted data is rejected.
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)-1] ^= 1
+	if _, err := other.ReadFrom(bytes.NewReader(corrupted)); err != ErrChecksumMismatch {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+
+	// Data without the magic bytes is rejected.
+	invalid := append([]byte{}, data...)
+	invalid[0] = 0
+	if _, err := other.ReadFrom(bytes.NewReader(invalid)); err != ErrInvalidFormat {
+		t.Errorf("Expected ErrInvalidFormat, got %v", err)
+	}
+
+	// Truncated data is rejected.
+	if _, err := other.ReadFrom(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Error("Expected error for truncated data")
+	}
+}
+
+// Ensures that filters and sketches embedded in a struct survive a round trip
+// through gob, which uses their MarshalBinary and UnmarshalBinary methods.
+func TestBinaryMarshalerGob(t *testing.T) {
+	type sketches struct {
+		Bloom    *BloomFilter
+		Counting *CountingBloomFilter
+		Cuckoo   *CuckooFilter
+		CMS      *CountMinSketch
+		HLL      *HyperLogLog
+		TopK     *TopK
+	}
+
+	hll, err := NewHyperLogLog(64)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	s := sketches{
+		Bloom:    NewBloomFilter(100, 0.01),
+		Counting: NewDefaultCountingBloomFilter(100, 0.01),
+		Cuckoo:   NewCuckooFilter(100, 0.01),
+		CMS:      NewCountMinSketch(0.01, 0.99),
+		HLL:      hll,
+		TopK:     NewTopK(0.01, 0.99, 2),
+	}
+	for i := 0; i < 50; i++ {
+		data := []byte(strconv.Itoa(i % 10))
+		s.Bloom.Add(data)
+		s.Counting.Add(data)
+		s.Cuckoo.Add(data)
+		s.CMS.Add(data)
+		s.HLL.Add(data)
+		s.TopK.Add(data)
+	}
+	s.TopK.Add([]byte(`0`))
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var decoded sketches
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data := []byte(`3`)
+	if !decoded.Bloom.Test(data) || !decoded.Cuckoo.Test(data) {
+		t.Error("Expected `3` to be a member")
+	}
+
+	if count := decoded.Counting.EstimatedCount(data); count != 5 {
+		t.Errorf("Expected 5, got %d", count)
+	}
+
+	if count := decoded.CMS.Count(data); count != 5 {
+		t.Errorf("Expected 5, got %d", count)
+	}
+
+	if decoded.HLL.Count() != s.HLL.Count() {
+		t.Errorf("Expected %d, got %d", s.HLL.Count(), decoded.HLL.Count())
+	}
+
+	if top := decoded.TopK.Elements(); len(top) != 2 || string(top[1]) != "0" {
+		t.Errorf("Expected 0 to be the most frequent, got %q", top)
+	}
+
+	var u encoding.BinaryUnmarshaler = &BloomFilter{}
+	if err := u.UnmarshalBinary([]byte(formatMagic)); err == nil {
+		t.Error("Expected error for invalid data")
+	}
+}
+
+// Ensures that the filters reject a number of hash functions exceeding their
+// size when read, rather than probing that many bits on every Add and Test.
+func TestReadFromExcessiveK(t *testing.T) {
+	var (
+		blocked   = NewBlockedBloomFilter(100, 0.01)
+		deletable = NewDeletableBloomFilter(100, 10, 0.01)
+		expiring  = NewExpiringBloomFilter(100, 0.01, time.Minute)
+		shifting  = NewShiftingBloomFilter(100, 0.01)
+	)
+	blocked.k = 1 << 63
+	deletable.k = 1 << 63
+	expiring.k = 1 << 63
+	shifting.k = 1 << 63
+
+	for _, c := range []struct {
+		name    string
+		written io.WriterTo
+		read    io.ReaderFrom
+	}{
+		{"BlockedBloomFilter", blocked, NewBlockedBloomFilter(100, 0.01)},
+		{"DeletableBloomFilter", deletable, NewDeletableBloomFilter(100, 10, 0.01)},
+		{"ExpiringBloomFilter", expiring, NewExpiringBloomFilter(100, 0.01, time.Minute)},
+		{"ShiftingBloomFilter", shifting, NewShiftingBloomFilter(100, 0.01)},
+	} {
+		var buf bytes.Buffer
+		if _, err := c.written.WriteTo(&buf); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := c.read.ReadFrom(&buf); err == nil {
+			t.Errorf("Expected error reading %s with k=2^63", c.name)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package boom
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"time"
+	"unsafe"
+)
+
+// expiringTicks is the number of ticks in the default TTL of an
+// ExpiringBloomFilter, the precision with which expiry times are kept.
+const expiringTicks = 256
+
+// ExpiringBloomFilter is a Bloom filter whose items expire individually,
+// a time-decaying Bloom filter. Instead of a bit, each of its cells holds the
+// latest expiry time of the items hashed to it, and an item is a member until
+// the earliest of its k cells expires. Adding an item again extends its
+// expiry. Expiry times are kept as 32-bit ticks since the first addition, of
+// a resolution of the default TTL divided by 256, and are rounded up, so an
+// item may outlive its TTL by up to one tick but never expires early.
+//
+// Expired cells are treated as empty, and Compact clears them so they no
+// longer count towards the fill ratio. The filter takes 32 times the memory of
+// a classic Bloom filter with the same false-positive rate for the items which
+// haven't expired.
+type ExpiringBloomFilter struct {
+	cells      []uint32    // expiry tick of each cell, zero when empty
+	hash       hash.Hash64 // hash function (kernel for all k functions)
+	m          uint        // number of cells
+	k          uint        // number of hash functions
+	count      uint        // number of items added
+	ttl        int64       // default TTL in nanoseconds
+	resolution int64       // length of a tick in nanoseconds
+	epoch      int64       // time of tick zero in Unix nanoseconds
+	started    bool        // the epoch has been set by the first addition
+	seed       uint64      // hash seed (zero means unseeded)
+	modulo     bool        // reduce hashes to indices with a modulo
+}
+
+// NewExpiringBloomFilter creates a new Expiring Bloom Filter optimized to store
+// n items which haven't expired with a specified target false-positive rate,
+// whose items expire after the TTL unless added with another.
+func NewExpiringBloomFilter(n uint, fpRate float64, ttl time.Duration, opts ...Option) *ExpiringBloomFilter {
+	var (
+		m = OptimalM(n, fpRate)
+		o = applyOptions(opts)
+	)
+	resolution := int64(ttl) / expiringTicks
+	if resolution <= 0 {
+		resolution = 1
+	}
+	return &ExpiringBloomFilter{
+		cells:      make([]uint32, m),
+		hash:       o.newHash64(newFNV64),
+		m:          m,
+		k:          OptimalK(fpRate),
+		ttl:        int64(ttl),
+		resolution: resolution,
+		seed:       o.seed,
+		modulo:     o.modulo,
+	}
+}
+
+// Capacity returns the number of cells, m.
+func (e *ExpiringBloomFilter) Capacity() uint {
+	return e.m
+}
+
+// K returns the number of hash functions.
+func (e *ExpiringBloomFilter) K() uint {
+	return e.k
+}
+
+// Count returns the number of items added to the filter, including those which
+// have expired.
+func (e *ExpiringBloomFilter) Count() uint {
+	return e.count
+}
+
+// TTL returns the default time after which items expire.
+func (e *ExpiringBloomFilter) TTL() time.Duration {
+	return time.Duration(e.ttl)
+}
+
+// Resolution returns the length of a tick, to which expiry times are rounded
+// up.
+func (e *ExpiringBloomFilter) Resolution() time.Duration {
+	return time.Duration(e.resolution)
+}
+
+// Test will test for membership of the data at the time and returns true if
+// it is a member which hasn't expired, false if not. This is a probabilistic
+// test, meaning there is a non-zero probability of false positives, which
+// includes an expired item reported until its last cell expires, but a zero
+// probability of false negatives.
+func (e *ExpiringBloomFilter) Test(data []byte, t time.Time) bool {
+	if !e.started {
+		return false
+	}
+
+	var (
+		now          = e.tick(t)
+		lower, upper = sizedHashKernel(data, e.hash, e.seed, e.m)
+	)
+	for i := uint(0); i < e.k; i++ {
+		if int64(e.cells[reducedIndex(lower, upper, i, e.m, e.modulo)]) <= now {
+			return false
+		}
+	}
+	return true
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (e *ExpiringBloomFilter) TestString(data string, t time.Time) bool {
+	return e.Test(stringBytes(data), t)
+}
+
+// Add will add the data at the time, expiring after the default TTL. It
+// returns the filter to allow for chaining.
+func (e *ExpiringBloomFilter) Add(data []byte, t time.Time) *ExpiringBloomFilter {
+	return e.AddWithTTL(data, t, time.Duration(e.ttl))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (e *ExpiringBloomFilter) AddString(data string, t time.Time) *ExpiringBloomFilter {
+	return e.Add(stringBytes(data), t)
+}
+
+// AddWithTTL will add the data at the time, expiring after the TTL, unless it
+// was already added with a later expiry. Expiry times more than 2^32 ticks
+// after the first addition are kept as the latest one. It returns the filter
+// to allow for chaining.
+func (e *ExpiringBloomFilter) AddWithTTL(data []byte, t time.Time, ttl time.Duration) *ExpiringBloomFilter {
+	if !e.started {
+		e.epoch = t.UnixNano()
+		e.started = true
+	}
+
+	// Expiry is rounded up to the next tick.
+	expiry := e.tick(t.Add(ttl).Add(time.Duration(e.resolution - 1)))
+	if expiry <= 0 {
+		return e
+	}
+	if expiry > math.MaxUint32 {
+		expiry = math.MaxUint32
+	}
+
+	lower, upper := sizedHashKernel(data, e.hash, e.seed, e.m)
+	for i := uint(0); i < e.k; i++ {
+		idx := reducedIndex(lower, upper, i, e.m, e.modulo)
+		if int64(e.cells[idx]) < expiry {
+			e.cells[idx] = uint32(expiry)
+		}
+	}
+	e.count++
+	return e
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data is a member, false if not.
+func (e *ExpiringBloomFilter) TestAndAdd(data []byte, t time.Time) bool {
+	member := e.Test(data, t)
+	e.Add(data, t)
+	return member
+}
+
+// Compact clears the cells which have expired at the time and returns their
+// number.
+func (e *ExpiringBloomFilter) Compact(t time.Time) uint {
+	if !e.started {
+		return 0
+	}
+
+	var (
+		now     = e.tick(t)
+		cleared uint
+	)
+	for i, cell := range e.cells {
+		if cell != 0 && int64(cell) <= now {
+			e.cells[i] = 0
+			cleared++
+		}
+	}
+	return cleared
+}
+
+// FillRatio returns the ratio of cells which aren't empty, including those
+// which have expired but haven't been cleared by Compact.
+func (e *ExpiringBloomFilter) FillRatio() float64 {
+	set := 0
+	for _, cell := range e.cells {
+		if cell != 0 {
+			set++
+		}
+	}
+	return float64(set) / float64(e.m)
+}
+
+// Reset restores the filter to its original state. It returns the filter to
+// allow for chaining.
+func (e *ExpiringBloomFilter) Reset() *ExpiringBloomFilter {
+	for i := range e.cells {
+		e.cells[i] = 0
+	}
+	e.count = 0
+	e.epoch = 0
+	e.started = false
+	return e
+}
+
+// ByteSize returns the number of bytes used by the cells and metadata of the
+// filter, excluding the hash function.
+func (e *ExpiringBloomFilter) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*e)) + uint64(cap(e.cells))*4
+}
+
+// SetHash sets the hashing function used in the filter.
+func (e *ExpiringBloomFilter) SetHash(h hash.Hash64) {
+	e.hash = h
+}
+
+// tick returns the number of the tick holding the time, which is negative
+// before the epoch.
+func (e *ExpiringBloomFilter) tick(t time.Time) int64 {
+	d := t.UnixNano() - e.epoch
+	tick := d / e.resolution
+	if d%e.resolution < 0 {
+		tick--
+	}
+	return tick
+}
+
+// WriteTo writes a binary representation of the ExpiringBloomFilter to an I/O
+// stream. The hash function is not written, but the seed is. It returns the
+// number of bytes written.
+func (e *ExpiringBloomFilter) WriteTo(stream io.Writer) (int64, error) {
+	var started uint8
+	if e.started {
+		started = 1
+	}
+
+	var enc encoder
+	enc.write(uint64(e.m))
+	enc.write(uint64(e.k))
+	enc.write(uint64(e.count))
+	enc.write(e.ttl)
+	enc.write(e.resolution)
+	enc.write(e.epoch)
+	enc.write(started)
+	enc.write(e.seed)
+	enc.write(e.cells)
+	writeReduction(&enc, e.modulo)
+	if enc.err != nil {
+		return 0, enc.err
+	}
+
+	return writeFrame(stream, enc.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of an ExpiringBloomFilter (such as
+// might have been written by WriteTo()) from an I/O stream. The filter keeps
+// its current hash function, which must match the one used by the filter that
+// was written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (e *ExpiringBloomFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                      = decoder{r: payload}
+		m, k, count, seed      uint64
+		ttl, resolution, epoch int64
+		started                uint8
+	)
+	d.read(&m)
+	d.read(&k)
+	d.read(&count)
+	d.read(&ttl)
+	d.read(&resolution)
+	d.read(&epoch)
+	d.read(&started)
+	d.read(&seed)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if m == 0 || k == 0 || k > m || resolution <= 0 || started > 1 {
+		return n, errors.New("invalid filter parameters")
+	}
+	if m > uint64(payload.Len())/4 {
+		return n, io.ErrUnexpectedEOF
+	}
+	cells := make([]uint32, m)
+	d.read(cells)
+	modulo, err := readReduction(&d, payload)
+	if err != nil {
+		return n, err
+	}
+
+	if e.hash == nil {
+		e.hash = newFNV64()
+	}
+	e.cells = cells
+	e.m = uint(m)
+	e.k = uint(k)
+	e.count = uint(count)
+	e.ttl = ttl
+	e.resolution = resolution
+	e.epoch = epoch
+	e.started = started == 1
+	e.seed = seed
+	e.modulo = modulo
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (e *ExpiringBloomFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(e)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (e *ExpiringBloomFilter) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(e, data)
+}
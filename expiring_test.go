@@ -0,0 +1,120 @@
+package boom
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Ensures that items expire individually after their TTL, never before.
+func TestExpiringBloomFilterTTL(t *testing.T) {
+	var (
+		e     = NewExpiringBloomFilter(1000, 0.01, 15*time.Minute)
+		start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+	if e.Resolution() != 15*time.Minute/expiringTicks || e.TTL() != 15*time.Minute {
+		t.Errorf("Expected resolution of %s, got %s", 15*time.Minute/expiringTicks, e.Resolution())
+	}
+
+	// Item i is added i minutes after the start.
+	for i := 0; i < 60; i++ {
+		e.AddString(strconv.Itoa(i), start.Add(time.Duration(i)*time.Minute))
+	}
+	e.AddWithTTL([]byte(`long`), start, 2*time.Hour)
+
+	now := start.Add(60 * time.Minute)
+	for i := 0; i < 60; i++ {
+		member := e.TestString(strconv.Itoa(i), now)
+		if expired := i <= 45; member == expired {
+			t.Errorf("Expected membership of %d to be %t, got %t", i, !expired, member)
+		}
+	}
+	if !e.TestString("long", now) || e.TestString("long", start.Add(2*time.Hour+e.Resolution())) {
+		t.Error("Expected long to expire after 2 hours")
+	}
+
+	// Adding an item again extends its expiry.
+	if e.TestAndAdd([]byte(`0`), now) || !e.TestString("0", now.Add(14*time.Minute)) {
+		t.Error("Expected 0 to be added again")
+	}
+	if e.Count() != 62 {
+		t.Errorf("Expected 62 items, got %d", e.Count())
+	}
+}
+
+// Ensures that Compact clears expired cells without affecting members.
+func TestExpiringBloomFilterCompact(t *testing.T) {
+	var (
+		e     = NewExpiringBloomFilter(1000, 0.01, time.Minute)
+		start = time.Unix(1000, 0)
+	)
+	if e.Compact(start) != 0 || e.TestString("a", start) {
+		t.Error("Expected empty filter before the first addition")
+	}
+	for i := 0; i < 1000; i++ {
+		e.AddString(strconv.Itoa(i), start.Add(time.Duration(i%2)*time.Minute))
+	}
+
+	before := e.FillRatio()
+	if cleared := e.Compact(start.Add(time.Minute + e.Resolution())); cleared == 0 || e.FillRatio() >= before {
+		t.Errorf("Expected expired cells to be cleared, cleared %d", cleared)
+	}
+	for i := 1; i < 1000; i += 2 {
+		if !e.TestString(strconv.Itoa(i), start.Add(time.Minute+e.Resolution())) {
+			t.Fatalf("Expected %d to be a member", i)
+		}
+	}
+
+	e.Reset()
+	if e.FillRatio() != 0 || e.Count() != 0 {
+		t.Error("Expected empty filter after reset")
+	}
+}
+
+// Ensures that an Expiring Bloom Filter read by ReadFrom matches the one
+// written by WriteTo.
+func TestExpiringBloomFilterWriteToReadFrom(t *testing.T) {
+	var (
+		e     = NewExpiringBloomFilter(100, 0.01, time.Hour, WithSeed(2))
+		start = time.Unix(0, 0)
+	)
+	for i := 0; i < 100; i++ {
+		e.AddString(strconv.Itoa(i), start.Add(time.Duration(i)*time.Minute))
+	}
+
+	var buf bytes.Buffer
+	if _, err := e.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restored := &ExpiringBloomFilter{}
+	if _, err := restored.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	now := start.Add(100 * time.Minute)
+	for i := 0; i < 200; i++ {
+		key := strconv.Itoa(i)
+		if restored.TestString(key, now) != e.TestString(key, now) {
+			t.Errorf("Expected %t for %s, got %t", e.TestString(key, now), key, restored.TestString(key, now))
+		}
+	}
+	if restored.TTL() != e.TTL() || restored.Count() != e.Count() {
+		t.Errorf("Expected TTL %s and %d items, got %s and %d", e.TTL(), e.Count(), restored.TTL(), restored.Count())
+	}
+}
+
+func BenchmarkExpiringBloomFilterAdd(b *testing.B) {
+	var (
+		e    = NewExpiringBloomFilter(100000, 0.01, time.Minute)
+		now  = time.Now()
+		data = make([][]byte, b.N)
+	)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		e.Add(data[n], now)
+	}
+}
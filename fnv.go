@@ -0,0 +1,112 @@
+package boom
+
+import "hash"
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+)
+
+// fnv64 is the 64-bit FNV-1 hash function, equivalent to the one returned by
+// fnv.New64. It's the default hash function of structures hashing to 64 bits,
+// which recognize it and compute the hash with fnv64Sum instead, so their
+// operations don't allocate.
+type fnv64 uint64
+
+// newFNV64 returns a new 64-bit FNV-1 hash function.
+func newFNV64() hash.Hash64 {
+	h := fnv64(fnvOffset64)
+	return &h
+}
+
+func (h *fnv64) Write(data []byte) (int, error) {
+	*h = fnv64(fnv64Sum(uint64(*h), data))
+	return len(data), nil
+}
+
+func (h *fnv64) Sum(in []byte) []byte {
+	v := uint64(*h)
+	return append(in, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+		byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (h *fnv64) Reset()         { *h = fnvOffset64 }
+func (h *fnv64) Sum64() uint64  { return uint64(*h) }
+func (h *fnv64) Size() int      { return 8 }
+func (h *fnv64) BlockSize() int { return 1 }
+
+// fnv32 is the 32-bit FNV-1 hash function, equivalent to the one returned by
+// fnv.New32, which structures hashing to 32 bits recognize like fnv64.
+type fnv32 uint32
+
+// newFNV32 returns a new 32-bit FNV-1 hash function.
+func newFNV32() hash.Hash32 {
+	h := fnv32(fnvOffset32)
+	return &h
+}
+
+func (h *fnv32) Write(data []byte) (int, error) {
+	*h = fnv32(fnv32Sum(uint32(*h), data))
+	return len(data), nil
+}
+
+func (h *fnv32) Sum(in []byte) []byte {
+	v := uint32(*h)
+	return append(in, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (h *fnv32) Reset()         { *h = fnvOffset32 }
+func (h *fnv32) Sum32() uint32  { return uint32(*h) }
+func (h *fnv32) Size() int      { return 4 }
+func (h *fnv32) BlockSize() int { return 1 }
+
+// fnv64Sum continues the 64-bit FNV-1 hash with the given state over the data.
+func fnv64Sum(hash uint64, data []byte) uint64 {
+	for _, c := range data {
+		hash *= fnvPrime64
+		hash ^= uint64(c)
+	}
+	return hash
+}
+
+// fnv32Sum continues the 32-bit FNV-1 hash with the given state over the data.
+func fnv32Sum(hash uint32, data []byte) uint32 {
+	for _, c := range data {
+		hash *= fnvPrime32
+		hash ^= uint32(c)
+	}
+	return hash
+}
+
+// fnv32Hash returns the 32-bit FNV-1 of the big-endian bytes of the seed,
+// unless it's zero, followed by the data, like writing both to a hash function
+// returned by newFNV32.
+func fnv32Hash(seed uint64, data []byte) uint32 {
+	hash := uint32(fnvOffset32)
+	if seed != 0 {
+		for i := 56; i >= 0; i -= 8 {
+			hash *= fnvPrime32
+			hash ^= uint32(seed>>uint(i)) & 0xff
+		}
+	}
+	return fnv32Sum(hash, data)
+}
+
+// fnvHashKernel is like seededHashKernel using 64-bit FNV-1, computed without
+// the shared state of a hash.Hash64.
+func fnvHashKernel(data []byte, seed uint64) (uint32, uint32) {
+	hash := uint64(fnvOffset64)
+	if seed != 0 {
+		for i := 56; i >= 0; i -= 8 {
+			hash *= fnvPrime64
+			hash ^= (seed >> uint(i)) & 0xff
+		}
+	}
+	hash = fnv64Sum(hash, data)
+	if seed != 0 {
+		hash = mix64(hash ^ seed)
+	}
+	return uint32(hash), uint32(hash >> 32)
+}
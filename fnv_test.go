@@ -0,0 +1,78 @@
+package boom
+
+import (
+	"bytes"
+	"hash/fnv"
+	"strconv"
+	"testing"
+)
+
+// Ensures that the embedded FNV-1 hash functions match hash/fnv.
+func TestFNV(t *testing.T) {
+	var (
+		h64 = newFNV64()
+		h32 = newFNV32()
+		s64 = fnv.New64()
+		s32 = fnv.New32()
+	)
+	for i := 0; i < 100; i++ {
+		data := []byte(strconv.Itoa(i))
+		h64.Write(data)
+		s64.Write(data)
+		h32.Write(data)
+		s32.Write(data)
+
+		if h64.Sum64() != s64.Sum64() || !bytes.Equal(h64.Sum(nil), s64.Sum(nil)) {
+			t.Fatalf("Expected 64-bit hash %x, got %x", s64.Sum64(), h64.Sum64())
+		}
+		if h32.Sum32() != s32.Sum32() || !bytes.Equal(h32.Sum(nil), s32.Sum(nil)) {
+			t.Fatalf("Expected 32-bit hash %x, got %x", s32.Sum32(), h32.Sum32())
+		}
+	}
+
+	h64.Reset()
+	s64.Reset()
+	if h64.Sum64() != s64.Sum64() {
+		t.Error("Expected reset hash to match")
+	}
+
+	// The kernels computed without state match those of the standard hash
+	// function.
+	data := []byte(`a`)
+	for _, seed := range []uint64{0, 42} {
+		l1, u1 := fnvHashKernel(data, seed)
+		l2, u2 := seededHashKernel(data, fnv.New64(), seed)
+		if l1 != l2 || u1 != u2 {
+			t.Errorf("Expected kernel to match for seed %d", seed)
+		}
+	}
+}
+
+// Ensures that adding and testing with the default hash functions doesn't
+// allocate.
+func TestHashingAllocs(t *testing.T) {
+	var (
+		data        = []byte(`a`)
+		bloom       = NewBloomFilter(100, 0.01, WithSeed(42))
+		partitioned = NewPartitionedBloomFilter(100, 0.01)
+		counting    = NewDefaultCountingBloomFilter(100, 0.01)
+		stable      = NewDefaultStableBloomFilter(1000, 0.01)
+		cms         = NewCountMinSketch(0.001, 0.99)
+		cuckoo      = NewCuckooFilter(100, 0.01)
+	)
+	cuckoo.Add(data)
+
+	tests := map[string]func(){
+		"BloomFilter":            func() { bloom.Add(data); bloom.Test(data) },
+		"PartitionedBloomFilter": func() { partitioned.Add(data); partitioned.Test(data) },
+		"CountingBloomFilter":    func() { counting.Add(data); counting.TestAndRemove(data) },
+		"StableBloomFilter":      func() { stable.Add(data); stable.Test(data) },
+		"CountMinSketch":         func() { cms.Add(data); cms.Count(data) },
+		"CuckooFilter":           func() { cuckoo.Test(data) },
+	}
+	for name, test := range tests {
+		if allocs := testing.AllocsPerRun(100, test); allocs != 0 {
+			t.Errorf("Expected no allocations for %s, got %v", name, allocs)
+		}
+	}
+}
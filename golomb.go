@@ -0,0 +1,439 @@
+package boom
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+	"unsafe"
+)
+
+// golombIndexInterval is the number of values between the entries of a
+// GolombSet's index, bounding the number of values Test decodes.
+const golombIndexInterval = 64
+
+// Sources of the values of a GolombSet.
+const (
+	golombKeys   uint8 = iota // values are hashes of the keys
+	golombCuckoo              // values are the entries of a CuckooFilter
+)
+
+// GolombSet is a static, read-only set compressed with Golomb-Rice coding, as
+// described by Putze, Sanders, and Singler in Cache-, Hash- and
+// Space-Efficient Bloom Filters and used by Chrome to distribute Safe Browsing
+// lists:
+//
+// http://algo2.iti.kit.edu/documents/cacheefficientbloomfilters-jea.pdf
+//
+// Each item is hashed to a value in a range n/fpRate times the number of
+// items, n, and the sorted values are stored as the Golomb-Rice coded
+// differences between them. This takes about log2(1/fpRate) + 1.5 bits per
+// item, less than the 1.44 * log2(1/fpRate) bits of a Bloom filter for the
+// same false-positive rate, which makes it well suited to distributing
+// filters which don't change. Testing decodes a few dozen values, so it's
+// slower than testing a Bloom filter.
+//
+// A GolombSet is created from the items with NewGolombSet, or from the
+// entries of a CuckooFilter with its GolombSet method, since a Bloom filter
+// doesn't keep enough information about its items to recover them.
+type GolombSet struct {
+	data     []byte        // Golomb-Rice coded differences between the values
+	index    []golombEntry // every golombIndexInterval-th value and its position
+	n        uint64        // number of values
+	p        uint8         // number of remainder bits of each difference
+	universe uint64        // values are in [0, universe)
+	source   uint8         // source of the values
+	hash     hash.Hash64   // hash function of keys
+	seed     uint64        // hash seed (zero means unseeded)
+	cuckoo   *CuckooFilter // computes the values of a CuckooFilter's items, without buckets
+}
+
+// golombEntry is an entry of a GolombSet's index: a value and the bit offset
+// of the difference following it.
+type golombEntry struct {
+	value  uint64
+	offset uint64
+}
+
+// NewGolombSet creates a new GolombSet holding the keys with a specified
+// target false-positive rate. Duplicate keys are stored once.
+func NewGolombSet(keys [][]byte, fpRate float64, opts ...Option) *GolombSet {
+	var (
+		o = applyOptions(opts)
+		g = &GolombSet{
+			source: golombKeys,
+			hash:   o.newHash64(newFNV64),
+			seed:   o.seed,
+		}
+		n = uint64(len(keys))
+	)
+	if n == 0 {
+		n = 1
+	}
+	g.universe = n * uint64(math.Ceil(1/fpRate))
+
+	values := make([]uint64, len(keys))
+	for i, key := range keys {
+		values[i] = g.keyValue(key)
+	}
+	g.encode(values)
+	return g
+}
+
+// GolombSet returns a GolombSet holding the entries of the Cuckoo Filter,
+// which reports the same items as members as the filter does. Each entry is
+// identified by the lesser of its two buckets and its fingerprint. The set
+// uses the filter's hash function and seed.
+func (c *CuckooFilter) GolombSet() *GolombSet {
+	g := &GolombSet{
+		source:   golombCuckoo,
+		universe: uint64(c.m) << c.FingerprintBits(),
+		seed:     c.seed,
+		cuckoo:   &CuckooFilter{hash: cloneHash32(c.hash), m: c.m, f: c.f, bits: c.FingerprintBits(), seed: c.seed},
+	}
+
+	values := make([]uint64, 0, c.count)
+	for i, b := range c.buckets {
+		for _, fingerprint := range b {
+			if fingerprint != nil {
+				values = append(values, g.cuckoo.entryValue(uint(i), fingerprint))
+			}
+		}
+	}
+	for _, entry := range c.stash {
+		values = append(values, g.cuckoo.entryValue(entry.bucket, entry.fingerprint))
+	}
+	g.encode(values)
+	return g
+}
+
+// Count returns the number of distinct values in the set, which is the number
+// of distinct items unless some of their hashes collide.
+func (g *GolombSet) Count() uint {
+	return uint(g.n)
+}
+
+// ByteSize returns the number of bytes used by the compressed values, their
+// index, and the metadata of the set, excluding the hash function.
+func (g *GolombSet) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*g)) + uint64(cap(g.data)) +
+		uint64(cap(g.index))*uint64(unsafe.Sizeof(golombEntry{}))
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. This is a probabilistic test, meaning there is a
+// non-zero probability of false positives but a zero probability of false
+// negatives.
+func (g *GolombSet) Test(data []byte) bool {
+	if g.source == golombCuckoo {
+		var buf [4]byte
+		i1, i2, f := g.cuckoo.components(data, &buf)
+		return g.contains(g.cuckoo.canonicalValue(i1%g.cuckoo.m, i2%g.cuckoo.m, f))
+	}
+	return g.contains(g.keyValue(data))
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (g *GolombSet) TestString(data string) bool {
+	return g.Test(stringBytes(data))
+}
+
+// SetHash sets the hashing function used to hash keys, which must be the one
+// the set was created with. It has no effect on a set created from a
+// CuckooFilter.
+func (g *GolombSet) SetHash(h hash.Hash64) {
+	g.hash = h
+}
+
+// WriteTo writes a binary representation of the GolombSet to an I/O stream.
+// The hash function is not written, but the seed is. The index isn't written
+// either, so the representation is only as large as the compressed values. It
+// returns the number of bytes written.
+func (g *GolombSet) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(g.source)
+	e.write(g.n)
+	e.write(g.p)
+	e.write(g.universe)
+	e.write(g.seed)
+	if g.source == golombCuckoo {
+		e.write(uint64(g.cuckoo.m))
+		e.write(uint64(g.cuckoo.FingerprintBits()))
+	}
+	e.writeBytes(g.data)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a GolombSet (such as might have
+// been written by WriteTo()) from an I/O stream and rebuilds its index. The
+// set keeps its current hash function, which must match the one used by the
+// set that was written. Returns ErrUnsupportedVersion if the data was written
+// by an incompatible version of the package. It returns the number of bytes
+// read.
+func (g *GolombSet) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                  = decoder{r: payload}
+		source, p          uint8
+		count, universe, m uint64
+		seed, bits         uint64
+		cuckoo             *CuckooFilter
+		data               []byte
+		h                  = g.hash
+	)
+	d.read(&source)
+	d.read(&count)
+	d.read(&p)
+	d.read(&universe)
+	d.read(&seed)
+	if source == golombCuckoo {
+		d.read(&m)
+		d.read(&bits)
+	}
+	data = d.readBytes()
+	if d.err != nil {
+		return n, d.err
+	}
+
+	switch source {
+	case golombKeys:
+		if h == nil {
+			h = newFNV64()
+		}
+	case golombCuckoo:
+		if m == 0 || m&(m-1) != 0 || bits == 0 || bits > 32 || universe != m<<bits {
+			return n, errors.New("cuckoo parameters don't match set")
+		}
+		cuckoo = &CuckooFilter{hash: newFNV32(), m: uint(m), f: uint(bits+7) / 8, bits: uint(bits), seed: seed}
+		if g.cuckoo != nil {
+			cuckoo.hash = g.cuckoo.hash
+		}
+	default:
+		return n, errors.New("unknown set source")
+	}
+	if p > 63 || count > uint64(len(data))*8 {
+		return n, errors.New("values don't match set size")
+	}
+
+	decoded := &GolombSet{data: data, n: count, p: p, universe: universe}
+	if err := decoded.buildIndex(); err != nil {
+		return n, err
+	}
+
+	g.data = data
+	g.index = decoded.index
+	g.n = count
+	g.p = p
+	g.universe = universe
+	g.source = source
+	g.hash = h
+	g.seed = seed
+	g.cuckoo = cuckoo
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (g *GolombSet) MarshalBinary() ([]byte, error) {
+	return marshalBinary(g)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (g *GolombSet) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(g, data)
+}
+
+// keyValue returns the value of the key, its hash reduced to the universe.
+func (g *GolombSet) keyValue(key []byte) uint64 {
+	lower, upper := seededHashKernel(key, g.hash, g.seed)
+	return (uint64(upper)<<32 | uint64(lower)) % g.universe
+}
+
+// entryValue returns the value of the entry in the bucket, which is the same
+// for both buckets of the entry's item.
+func (c *CuckooFilter) entryValue(bucket uint, fingerprint []byte) uint64 {
+	alt := (bucket ^ uint(c.computeHash(fingerprint))) % c.m
+	return c.canonicalValue(bucket, alt, fingerprint)
+}
+
+// canonicalValue returns the value identifying an item by the lesser of its
+// buckets followed by its fingerprint.
+func (c *CuckooFilter) canonicalValue(b1, b2 uint, fingerprint []byte) uint64 {
+	if b2 < b1 {
+		b1 = b2
+	}
+	return uint64(b1)<<c.FingerprintBits() | c.fingerprintValue(fingerprint)
+}
+
+// encode sorts the values, removing duplicates, and Golomb-Rice codes their
+// differences, choosing the number of remainder bits closest to optimal for
+// the density of the values.
+func (g *GolombSet) encode(values []uint64) {
+	sort.Sort(uint64Slice(values))
+	distinct := values[:0]
+	for i, v := range values {
+		if i == 0 || v != values[i-1] {
+			distinct = append(distinct, v)
+		}
+	}
+
+	g.n = uint64(len(distinct))
+	g.p = 0
+	if g.n > 0 && g.universe/g.n > 1 {
+		g.p = uint8(bits.Len64(g.universe/g.n) - 1)
+	}
+
+	var (
+		w    bitWriter
+		prev uint64
+	)
+	g.index = make([]golombEntry, 0, (len(distinct)+golombIndexInterval-1)/golombIndexInterval)
+	for i, v := range distinct {
+		delta := v - prev
+		for q := delta >> g.p; q > 0; q-- {
+			w.writeBit(1)
+		}
+		w.writeBit(0)
+		w.writeBits(delta, uint(g.p))
+		prev = v
+
+		if i%golombIndexInterval == 0 {
+			g.index = append(g.index, golombEntry{value: v, offset: w.n})
+		}
+	}
+	g.data = w.data
+}
+
+// buildIndex decodes every value to rebuild the index. Returns an error if
+// the data holds fewer values than the set.
+func (g *GolombSet) buildIndex() error {
+	var (
+		r    = bitReader{data: g.data}
+		prev uint64
+	)
+	g.index = make([]golombEntry, 0, (g.n+golombIndexInterval-1)/golombIndexInterval)
+	for i := uint64(0); i < g.n; i++ {
+		delta, ok := g.readDelta(&r)
+		if !ok {
+			return errors.New("values don't match set size")
+		}
+		prev += delta
+		if i%golombIndexInterval == 0 {
+			g.index = append(g.index, golombEntry{value: prev, offset: r.n})
+		}
+	}
+	return nil
+}
+
+// contains returns true if the value is in the set, decoding the values
+// following the last index entry not exceeding it.
+func (g *GolombSet) contains(value uint64) bool {
+	k := sort.Search(len(g.index), func(i int) bool { return g.index[i].value > value }) - 1
+	if k < 0 {
+		return false
+	}
+
+	entry := g.index[k]
+	if entry.value == value {
+		return true
+	}
+
+	var (
+		r         = bitReader{data: g.data, n: entry.offset}
+		v         = entry.value
+		remaining = g.n - uint64(k)*golombIndexInterval - 1
+	)
+	if remaining > golombIndexInterval-1 {
+		remaining = golombIndexInterval - 1
+	}
+	for ; remaining > 0; remaining-- {
+		delta, _ := g.readDelta(&r)
+		v += delta
+		if v >= value {
+			return v == value
+		}
+	}
+	return false
+}
+
+// readDelta decodes the next difference. It returns false if the data ends
+// first.
+func (g *GolombSet) readDelta(r *bitReader) (uint64, bool) {
+	var q uint64
+	for {
+		bit, ok := r.readBit()
+		if !ok {
+			return 0, false
+		}
+		if bit == 0 {
+			break
+		}
+		q++
+	}
+
+	rem, ok := r.readBits(uint(g.p))
+	return q<<g.p | rem, ok
+}
+
+// bitWriter appends bits to a byte slice, most significant bit first.
+type bitWriter struct {
+	data []byte
+	n    uint64 // number of bits written
+}
+
+func (w *bitWriter) writeBit(bit uint64) {
+	if w.n%8 == 0 {
+		w.data = append(w.data, 0)
+	}
+	if bit != 0 {
+		w.data[w.n/8] |= 0x80 >> (w.n % 8)
+	}
+	w.n++
+}
+
+// writeBits writes the low count bits of v, most significant bit first.
+func (w *bitWriter) writeBits(v uint64, count uint) {
+	for i := count; i > 0; i-- {
+		w.writeBit(v >> (i - 1) & 1)
+	}
+}
+
+// bitReader reads bits from a byte slice, most significant bit first.
+type bitReader struct {
+	data []byte
+	n    uint64 // number of bits read
+}
+
+func (r *bitReader) readBit() (uint64, bool) {
+	if r.n >= uint64(len(r.data))*8 {
+		return 0, false
+	}
+	bit := uint64(r.data[r.n/8]>>(7-r.n%8)) & 1
+	r.n++
+	return bit, true
+}
+
+// readBits reads count bits as the low bits of the result.
+func (r *bitReader) readBits(count uint) (uint64, bool) {
+	var v uint64
+	for i := uint(0); i < count; i++ {
+		bit, ok := r.readBit()
+		if !ok {
+			return 0, false
+		}
+		v = v<<1 | bit
+	}
+	return v, true
+}
@@ -0,0 +1,116 @@
+package boom
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+// Ensures that a GolombSet holds every key, has roughly the target
+// false-positive rate, and is smaller than a Bloom filter.
+func TestGolombSet(t *testing.T) {
+	keys := make([][]byte, 10000)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+	}
+	g := NewGolombSet(keys, 0.01)
+
+	for _, key := range keys {
+		if !g.Test(key) {
+			t.Fatalf("Expected %s to be a member", key)
+		}
+	}
+
+	fp := 0
+	for i := 10000; i < 20000; i++ {
+		if g.TestString(strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 10000; rate > 0.015 {
+		t.Errorf("Expected false-positive rate near 0.01, got %f", rate)
+	}
+
+	if size := uint64(len(g.data)); size >= ClassicMemory(10000, 0.01) {
+		t.Errorf("Expected fewer than %d bytes, got %d", ClassicMemory(10000, 0.01), size)
+	}
+
+	if empty := NewGolombSet(nil, 0.01); empty.Count() != 0 || empty.Test([]byte(`a`)) {
+		t.Error("Expected an empty set")
+	}
+}
+
+// Ensures that a GolombSet exported from a Cuckoo Filter reports the same
+// members as the filter.
+func TestCuckooGolombSet(t *testing.T) {
+	odd, err := NewCuckooFilterWithParams(1000, 12, 4)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, f := range []*CuckooFilter{NewCuckooFilter(1000, 0.01, WithSeed(42)), odd} {
+		for i := 0; i < 1000; i++ {
+			f.Add([]byte(strconv.Itoa(i)))
+		}
+		g := f.GolombSet()
+
+		if g.Count() > f.Count() {
+			t.Errorf("Expected at most %d values, got %d", f.Count(), g.Count())
+		}
+		for i := 0; i < 5000; i++ {
+			data := []byte(strconv.Itoa(i))
+			if g.Test(data) != f.Test(data) {
+				t.Errorf("Expected %s to be a member of both or neither", data)
+			}
+		}
+	}
+}
+
+// Ensures that WriteTo and ReadFrom round-trip a GolombSet and rebuild its
+// index.
+func TestGolombSetReadWrite(t *testing.T) {
+	keys := make([][]byte, 1000)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+	}
+	c := NewCuckooFilter(1000, 0.01)
+	c.AddMany(keys)
+
+	for _, g := range []*GolombSet{NewGolombSet(keys, 0.001, WithSeed(7)), c.GolombSet()} {
+		var buf bytes.Buffer
+		if _, err := g.WriteTo(&buf); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		other := &GolombSet{}
+		if _, err := other.ReadFrom(&buf); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if other.Count() != g.Count() || len(other.index) != len(g.index) {
+			t.Errorf("Expected %d values, got %d", g.Count(), other.Count())
+		}
+		for i := 0; i < 2000; i++ {
+			data := []byte(strconv.Itoa(i))
+			if other.Test(data) != g.Test(data) {
+				t.Errorf("Expected %s to be a member of both or neither", data)
+			}
+		}
+	}
+
+	data, _ := NewGolombSet(keys, 0.01).MarshalBinary()
+	if err := (&GolombSet{}).UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Error("Expected error for truncated data")
+	}
+}
+
+func BenchmarkGolombSetTest(b *testing.B) {
+	keys := make([][]byte, 100000)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+	}
+	g := NewGolombSet(keys, 0.01)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		g.Test(keys[n%len(keys)])
+	}
+}
@@ -0,0 +1,185 @@
+package boom
+
+import (
+	"errors"
+	"io"
+	"math"
+)
+
+// Ordinals of Guava's BloomFilterStrategies.
+const (
+	guavaMurmur128Mitz32 = 0
+	guavaMurmur128Mitz64 = 1
+)
+
+// NewGuavaBloomFilter creates a new Bloom filter optimized to store n items
+// with a specified target false-positive rate which is interchangeable with a
+// Guava BloomFilter using the default MURMUR128_MITZ_64 strategy. It uses
+// Guava's parameter estimation and derives its k indices the same way, from
+// the 128-bit MurmurHash3 of the data. The data corresponds to the bytes put
+// into the Guava filter's funnel, such as by Funnels.byteArrayFunnel or, for
+// strings, Funnels.stringFunnel with UTF-8. Use WriteGuavaTo and ReadGuavaFrom
+// to exchange filters with Guava's writeTo and readFrom. SetHash and the seed
+// have no effect on a filter using this scheme.
+func NewGuavaBloomFilter(n uint, fpRate float64) *BloomFilter {
+	if n == 0 {
+		n = 1
+	}
+	if fpRate == 0 {
+		fpRate = math.SmallestNonzeroFloat64
+	}
+
+	var (
+		bits = math.Floor(-float64(n) * math.Log(fpRate) / (math.Log(2) * math.Log(2)))
+		k    = uint(math.Max(1, math.Floor(bits/float64(n)*math.Log(2)+0.5)))
+	)
+	return newGuavaBloomFilter((uint(bits)+63)/64, k, schemeGuava64)
+}
+
+// newGuavaBloomFilter returns an empty Bloom filter with the given number of
+// 64-bit words and k hash functions using one of the Guava hashing schemes.
+func newGuavaBloomFilter(words, k uint, scheme hashScheme) *BloomFilter {
+	return &BloomFilter{
+		buckets: NewBuckets(words*64, 1),
+		hash:    newFNV64(),
+		m:       words * 64,
+		k:       k,
+		scheme:  scheme,
+	}
+}
+
+// WriteGuavaTo writes the representation used by Guava's BloomFilter.writeTo
+// to an I/O stream: the strategy ordinal and number of hash functions as
+// bytes, the number of 64-bit words as a 32-bit integer, and the words, all
+// big-endian. Returns an error if the filter wasn't created with
+// NewGuavaBloomFilter or read with ReadGuavaFrom. It returns the number of
+// bytes written.
+func (b *BloomFilter) WriteGuavaTo(stream io.Writer) (int64, error) {
+	var strategy uint8
+	switch b.scheme {
+	case schemeGuava32:
+		strategy = guavaMurmur128Mitz32
+	case schemeGuava64:
+		strategy = guavaMurmur128Mitz64
+	default:
+		return 0, errors.New("filter doesn't use a Guava hashing scheme")
+	}
+
+	if b.k > math.MaxUint8 || b.m/64 > math.MaxInt32 {
+		return 0, errors.New("filter is too large for Guava")
+	}
+
+	words := make([]uint64, b.m/64)
+	for i := uint(0); i < b.m; i++ {
+		if b.buckets.Get(i) == 1 {
+			words[i/64] |= 1 << (i % 64)
+		}
+	}
+
+	var e encoder
+	e.write(strategy)
+	e.write(uint8(b.k))
+	e.write(int32(len(words)))
+	e.write(words)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	n, err := stream.Write(e.buf.Bytes())
+	return int64(n), err
+}
+
+// ReadGuavaFrom reads the representation written by Guava's
+// BloomFilter.writeTo (or by WriteGuavaTo) from an I/O stream, replacing the
+// filter's contents. Both the MURMUR128_MITZ_32 and MURMUR128_MITZ_64
+// strategies are supported, and the filter adopts the strategy that was
+// written, so Test agrees with the Guava filter. Since the representation
+// doesn't include the number of items added, Count returns the number
+// estimated from the set bits. It returns the number of bytes read.
+func (b *BloomFilter) ReadGuavaFrom(stream io.Reader) (int64, error) {
+	var (
+		counter     = &countingReader{r: stream}
+		d           = decoder{r: counter}
+		strategy, k uint8
+		length      int32
+		scheme      hashScheme
+	)
+	d.read(&strategy)
+	d.read(&k)
+	d.read(&length)
+	if d.err != nil {
+		return counter.n, d.err
+	}
+
+	switch strategy {
+	case guavaMurmur128Mitz32:
+		scheme = schemeGuava32
+	case guavaMurmur128Mitz64:
+		scheme = schemeGuava64
+	default:
+		return counter.n, errors.New("unsupported Guava strategy")
+	}
+
+	if length <= 0 || k == 0 {
+		return counter.n, errors.New("invalid filter parameters")
+	}
+
+	// Read the words in chunks so a corrupted length can't cause a huge
+	// allocation before the stream runs out.
+	var words []uint64
+	for remaining := uint64(length); remaining > 0 && d.err == nil; {
+		chunk := make([]uint64, minUint64(remaining, 1<<16))
+		d.read(chunk)
+		words = append(words, chunk...)
+		remaining -= uint64(len(chunk))
+	}
+	if d.err != nil {
+		return counter.n, d.err
+	}
+
+	f := newGuavaBloomFilter(uint(length), uint(k), scheme)
+	for i := uint(0); i < f.m; i++ {
+		if words[i/64]&(1<<(i%64)) != 0 {
+			f.buckets.Set(i, 1)
+		}
+	}
+
+	b.buckets = f.buckets
+	b.m = f.m
+	b.k = f.k
+	b.count = uint(estimateCardinality(b.m, b.k, b.popCount()) + 0.5)
+	b.seed = 0
+	b.fpRate = 0
+	b.scheme = scheme
+	return counter.n, nil
+}
+
+// guava32Indices fills indices with the bit indices of the data for a filter
+// of m bits using Guava's MURMUR128_MITZ_32 strategy, which combines the two
+// 32-bit halves of the lower 64 bits of the MurmurHash3 using 32-bit
+// arithmetic.
+func guava32Indices(data []byte, m uint, indices []uint) {
+	var (
+		hash64, _ = murmur3Sum128(data, 0)
+		hash1     = int32(hash64)
+		hash2     = int32(hash64 >> 32)
+	)
+	for i := range indices {
+		combined := hash1 + int32(i+1)*hash2
+		if combined < 0 {
+			combined = ^combined
+		}
+		indices[i] = uint(combined) % m
+	}
+}
+
+// guava64Indices fills indices with the bit indices of the data for a filter
+// of m bits using Guava's MURMUR128_MITZ_64 strategy, which combines the two
+// 64-bit halves of the MurmurHash3.
+func guava64Indices(data []byte, m uint, indices []uint) {
+	combined, hash2 := murmur3Sum128(data, 0)
+	for i := range indices {
+		indices[i] = uint((combined & math.MaxInt64) % uint64(m))
+		combined += hash2
+	}
+}
@@ -0,0 +1,132 @@
+package boom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"testing"
+)
+
+// Ensures that NewGuavaBloomFilter uses Guava's parameter estimation, with the
+// bits rounded up to whole 64-bit words.
+func TestNewGuavaBloomFilter(t *testing.T) {
+	f := NewGuavaBloomFilter(1000, 0.01)
+
+	if m := f.Capacity(); m != 9600 {
+		t.Errorf("Expected 9600, got %d", m)
+	}
+
+	if k := f.K(); k != 7 {
+		t.Errorf("Expected 7, got %d", k)
+	}
+
+	if f.TestAndAdd([]byte(`a`)) {
+		t.Error("`a` should not be a member")
+	}
+
+	if !f.Test([]byte(`a`)) {
+		t.Error("`a` should be a member")
+	}
+}
+
+// Ensures that WriteGuavaTo writes the strategy, number of hash functions,
+// number of words, and the words, and that ReadGuavaFrom restores them.
+func TestGuavaWriteToReadFrom(t *testing.T) {
+	f := NewGuavaBloomFilter(1000, 0.01)
+	for i := 0; i < 500; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	var buf bytes.Buffer
+	wn, err := f.WriteGuavaTo(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data := buf.Bytes()
+	if wn != int64(len(data)) || len(data) != 6+9600/8 {
+		t.Fatalf("Expected %d bytes, got %d", 6+9600/8, wn)
+	}
+
+	if data[0] != guavaMurmur128Mitz64 || data[1] != 7 || binary.BigEndian.Uint32(data[2:]) != 150 {
+		t.Errorf("Expected 1, 7, 150, got %d, %d, %d", data[0], data[1], binary.BigEndian.Uint32(data[2:]))
+	}
+
+	restored := NewBloomFilter(10, 0.1)
+	rn, err := restored.ReadGuavaFrom(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if wn != rn {
+		t.Errorf("Expected %d bytes read, got %d", wn, rn)
+	}
+
+	if count := restored.Count(); count < 480 || count > 520 {
+		t.Errorf("Expected approximately 500, got %d", count)
+	}
+
+	for i := 0; i < 2000; i++ {
+		data := []byte(strconv.Itoa(i))
+		if restored.Test(data) != f.Test(data) {
+			t.Errorf("Expected %t for %s, got %t", f.Test(data), data, restored.Test(data))
+		}
+	}
+
+	if _, err := NewBloomFilter(100, 0.01).WriteGuavaTo(&buf); err == nil {
+		t.Error("Expected error for filter using a different hashing scheme")
+	}
+
+	if _, err := restored.ReadGuavaFrom(bytes.NewReader([]byte{2, 7, 0, 0, 0, 1})); err == nil {
+		t.Error("Expected error for unsupported strategy")
+	}
+}
+
+// Ensures that a filter using the MURMUR128_MITZ_32 strategy is read and
+// written with that strategy.
+func TestGuavaMitz32(t *testing.T) {
+	// An empty filter of 2 words with 3 hash functions.
+	f := &BloomFilter{}
+	if _, err := f.ReadGuavaFrom(bytes.NewReader(make([]byte, 6+16))); err == nil {
+		t.Error("Expected error for zero hash functions")
+	}
+
+	data := append([]byte{guavaMurmur128Mitz32, 3, 0, 0, 0, 2}, make([]byte, 16)...)
+	if _, err := f.ReadGuavaFrom(bytes.NewReader(data)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if f.Capacity() != 128 || f.K() != 3 || f.Count() != 0 {
+		t.Errorf("Expected 128, 3, 0, got %d, %d, %d", f.Capacity(), f.K(), f.Count())
+	}
+
+	f.Add([]byte(`a`))
+	if !f.Test([]byte(`a`)) {
+		t.Error("`a` should be a member")
+	}
+
+	// The indices are derived from the lower 64 bits of the hash.
+	var (
+		lower, _ = murmur3Sum128([]byte(`a`), 0)
+		hash1    = int32(lower)
+		hash2    = int32(lower >> 32)
+	)
+	for i := int32(1); i <= 3; i++ {
+		combined := hash1 + i*hash2
+		if combined < 0 {
+			combined = ^combined
+		}
+		if f.buckets.Get(uint(combined)%128) != 1 {
+			t.Errorf("Expected bit %d to be set", uint(combined)%128)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteGuavaTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if strategy := buf.Bytes()[0]; strategy != guavaMurmur128Mitz32 {
+		t.Errorf("Expected %d, got %d", guavaMurmur128Mitz32, strategy)
+	}
+}
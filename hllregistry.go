@@ -0,0 +1,147 @@
+package boom
+
+import (
+	"container/list"
+	"errors"
+	"sort"
+	"unsafe"
+)
+
+// registryEntry is a key of a HyperLogLogRegistry and its HyperLogLog.
+type registryEntry struct {
+	key string
+	hll *HyperLogLog
+}
+
+// HyperLogLogRegistry keeps a HyperLogLog per key to count the distinct items
+// of each, such as the distinct IPs per domain. Each HyperLogLog is created in
+// the sparse representation the first time an item is added to its key, so
+// the many keys with few items take little memory. The registry can be
+// bounded to a number of keys, in which case adding to a new key once it's
+// full evicts the least recently used key and its counts.
+type HyperLogLogRegistry struct {
+	entries map[string]*list.Element // entries by key
+	lru     *list.List               // entries from most to least recently used
+	m       uint                     // number of registers of each HyperLogLog
+	maxKeys uint                     // most keys kept, zero if unbounded
+	opts    []Option                 // options each HyperLogLog is created with
+}
+
+// NewHyperLogLogRegistry creates a new HyperLogLogRegistry whose HyperLogLogs
+// have m registers and are created with the options. It keeps at most maxKeys
+// keys, evicting the least recently used, or any number if maxKeys is zero.
+// Returns an error if m isn't a power of two or is more than 2^24.
+func NewHyperLogLogRegistry(m, maxKeys uint, opts ...Option) (*HyperLogLogRegistry, error) {
+	if m == 0 {
+		return nil, errors.New("m must be a power of two")
+	}
+	if _, err := NewSparseHyperLogLog(m, opts...); err != nil {
+		return nil, err
+	}
+
+	return &HyperLogLogRegistry{
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		m:       m,
+		maxKeys: maxKeys,
+		opts:    opts,
+	}, nil
+}
+
+// MaxKeys returns the most keys kept, zero if unbounded.
+func (r *HyperLogLogRegistry) MaxKeys() uint {
+	return r.maxKeys
+}
+
+// Len returns the number of keys.
+func (r *HyperLogLogRegistry) Len() int {
+	return len(r.entries)
+}
+
+// Add will add the data to the set of the key, creating its HyperLogLog if the
+// key is new and evicting the least recently used key if that exceeds the
+// most keys kept. Returns the HyperLogLogRegistry to allow for chaining.
+func (r *HyperLogLogRegistry) Add(key string, data []byte) *HyperLogLogRegistry {
+	if e, ok := r.entries[key]; ok {
+		r.lru.MoveToFront(e)
+		e.Value.(*registryEntry).hll.Add(data)
+		return r
+	}
+
+	if r.maxKeys > 0 && uint(len(r.entries)) >= r.maxKeys {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.entries, oldest.Value.(*registryEntry).key)
+	}
+
+	// The parameters were checked when the registry was created.
+	hll, _ := NewSparseHyperLogLog(r.m, r.opts...)
+	r.entries[key] = r.lru.PushFront(&registryEntry{key: key, hll: hll.Add(data)})
+	return r
+}
+
+// Count returns the approximated number of distinct items added to the key,
+// zero if it has none or was evicted. Counting marks the key as recently
+// used.
+func (r *HyperLogLogRegistry) Count(key string) uint64 {
+	if hll := r.Get(key); hll != nil {
+		return hll.Count()
+	}
+	return 0
+}
+
+// Get returns the HyperLogLog of the key, such as to merge it with another or
+// write it to a stream, or nil if it has none. Getting marks the key as
+// recently used.
+func (r *HyperLogLogRegistry) Get(key string) *HyperLogLog {
+	e, ok := r.entries[key]
+	if !ok {
+		return nil
+	}
+
+	r.lru.MoveToFront(e)
+	return e.Value.(*registryEntry).hll
+}
+
+// Remove removes the key and its HyperLogLog. Returns true if the registry had
+// the key.
+func (r *HyperLogLogRegistry) Remove(key string) bool {
+	e, ok := r.entries[key]
+	if !ok {
+		return false
+	}
+
+	r.lru.Remove(e)
+	delete(r.entries, key)
+	return true
+}
+
+// Keys returns the keys in sorted order.
+func (r *HyperLogLogRegistry) Keys() []string {
+	keys := make([]string, 0, len(r.entries))
+	for key := range r.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Reset restores the HyperLogLogRegistry to its original state, with no keys.
+// It returns itself to allow for chaining.
+func (r *HyperLogLogRegistry) Reset() *HyperLogLogRegistry {
+	r.entries = make(map[string]*list.Element)
+	r.lru.Init()
+	return r
+}
+
+// ByteSize returns the number of bytes used by the keys, their HyperLogLogs,
+// and the metadata of the registry, excluding the overhead of the map and list
+// of keys and the hash functions.
+func (r *HyperLogLogRegistry) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*r))
+	for e := r.lru.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*registryEntry)
+		size += uint64(unsafe.Sizeof(*entry)) + uint64(len(entry.key)) + entry.hll.ByteSize()
+	}
+	return size
+}
@@ -0,0 +1,50 @@
+package boom
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// Ensures that HyperLogLogRegistry counts the distinct items of each key and
+// evicts the least recently used key once it's full.
+func TestHyperLogLogRegistry(t *testing.T) {
+	if _, err := NewHyperLogLogRegistry(1000, 0); err == nil {
+		t.Error("Expected an error for m not a power of two")
+	}
+	r, err := NewHyperLogLogRegistry(1024, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mix the items, which FNV would cluster in the registers otherwise.
+	for i := 0; i < 2000; i++ {
+		r.Add("a.com", []byte(strconv.FormatUint(mix64(uint64(i%1000)), 16)))
+		r.Add("b.com", []byte(strconv.FormatUint(mix64(uint64(i%10)), 16)))
+	}
+	if count := r.Count("a.com"); math.Abs(float64(count)-1000) > 100 {
+		t.Errorf("Expected about 1000, got %d", count)
+	}
+	if count := r.Count("b.com"); count != 10 {
+		t.Errorf("Expected 10, got %d", count)
+	}
+	if !r.Get("b.com").Sparse() {
+		t.Error("Expected a key with few items to stay sparse")
+	}
+
+	// a.com was used least recently, after b.com was counted.
+	r.Add("c.com", []byte(`x`)).Add("d.com", []byte(`x`))
+	if r.Len() != 3 || r.Count("a.com") != 0 || r.Get("a.com") != nil {
+		t.Errorf("Expected a.com to be evicted, got keys %v", r.Keys())
+	}
+	if keys := r.Keys(); len(keys) != 3 || keys[0] != "b.com" {
+		t.Errorf("Expected b.com, c.com, and d.com, got %v", keys)
+	}
+
+	if !r.Remove("b.com") || r.Remove("b.com") || r.Len() != 2 {
+		t.Error("Expected to remove b.com once")
+	}
+	if r.Reset().Len() != 0 {
+		t.Error("Expected no keys")
+	}
+}
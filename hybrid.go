@@ -0,0 +1,108 @@
+package boom
+
+import (
+	"hash"
+	"hash/fnv"
+	"math"
+	"unsafe"
+)
+
+// HybridCardinality estimates the number of distinct elements in a multiset
+// across the full range of cardinalities. HyperLogLog has a high relative
+// error for small sets, so while the estimated cardinality is below a
+// threshold, HybridCardinality uses linear counting over a bitmap of
+// 8 bits per expected element, which is the Bloom filter cardinality estimate
+// with a single hash function. Past the threshold, the HyperLogLog estimate
+// is used. Every item is added to both, so switching over requires no replay.
+type HybridCardinality struct {
+	bits      *Buckets     // linear counting bitmap
+	m         uint         // number of bits in the bitmap
+	threshold uint         // cardinality at which to switch to HyperLogLog
+	hll       *HyperLogLog // estimator for large cardinalities
+	hash      hash.Hash64  // hash function (used for the bitmap)
+}
+
+// NewHybridCardinality creates a new HybridCardinality which uses linear
+// counting for cardinalities up to the threshold and a HyperLogLog optimized
+// for the specified standard error beyond it. Returns an error if the number
+// of HyperLogLog registers can't be calculated for the provided accuracy.
+func NewHybridCardinality(threshold uint, e float64, opts ...Option) (*HybridCardinality, error) {
+	hll, err := NewDefaultHyperLogLog(e, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// FNV-1a spreads similar keys across the registers far better than FNV-1.
+	hll.SetHash(applyOptions(opts).newHash32(fnv.New32a))
+
+	m := threshold * 8
+	if m == 0 {
+		m = 8
+	}
+
+	return &HybridCardinality{
+		bits:      NewBuckets(m, 1),
+		m:         m,
+		threshold: threshold,
+		hll:       hll,
+		hash:      applyOptions(opts).newHash64(fnv.New64a),
+	}, nil
+}
+
+// Add will add the data to the set. Returns the HybridCardinality to allow for
+// chaining.
+func (h *HybridCardinality) Add(data []byte) *HybridCardinality {
+	lower, _ := hashKernel(data, h.hash)
+	h.bits.Set(uint(lower)%h.m, 1)
+	h.hll.Add(data)
+	return h
+}
+
+// Count returns the approximated cardinality of the set. This is the linear
+// counting estimate if it's within the threshold, otherwise the HyperLogLog
+// estimate.
+func (h *HybridCardinality) Count() uint64 {
+	set := uint(0)
+	for i := uint(0); i < h.m; i++ {
+		set += uint(h.bits.Get(i))
+	}
+
+	if set < h.m {
+		estimate := -float64(h.m) * math.Log(1-float64(set)/float64(h.m))
+		if estimate <= float64(h.threshold) {
+			return uint64(estimate + 0.5)
+		}
+	}
+
+	return h.hll.Count()
+}
+
+// Clone returns an independent copy of the HybridCardinality, including its
+// bitmap and HyperLogLog, which can be used concurrently with the original. The
+// copy's bitmap is held in memory.
+func (h *HybridCardinality) Clone() *HybridCardinality {
+	c := *h
+	c.bits = h.bits.Clone()
+	c.hll = h.hll.Clone()
+	c.hash = cloneHash64(h.hash)
+	return &c
+}
+
+// ByteSize returns the number of bytes used by the bitmap, the HyperLogLog,
+// and the metadata of the HybridCardinality, excluding the hash functions.
+func (h *HybridCardinality) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*h)) + h.bits.ByteSize() + h.hll.ByteSize()
+}
+
+// Reset restores the HybridCardinality to its original state. It returns
+// itself to allow for chaining.
+func (h *HybridCardinality) Reset() *HybridCardinality {
+	h.bits.Reset()
+	h.hll.Reset()
+	return h
+}
+
+// SetHash sets the hashing function used for linear counting.
+func (h *HybridCardinality) SetHash(ha hash.Hash64) {
+	h.hash = ha
+}
@@ -0,0 +1,49 @@
+package boom
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// Ensures that Count has a low relative error for both small and large
+// cardinalities.
+func TestHybridCardinalityCount(t *testing.T) {
+	h, err := NewHybridCardinality(10000, 0.01)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, n := range []int{100, 10000000} {
+		h.Reset()
+		for i := 0; i < n; i++ {
+			if h.Add([]byte(strconv.Itoa(i))) != h {
+				t.Fatal("Returned HybridCardinality should be the same instance")
+			}
+		}
+
+		// Duplicates don't affect the count.
+		h.Add([]byte(`0`))
+
+		count := h.Count()
+		if e := math.Abs(float64(count)-float64(n)) / float64(n); e > 0.05 {
+			t.Errorf("Expected relative error within 0.05 for %d, got %f (%d)", n, e, count)
+		}
+	}
+}
+
+// Ensures that Count for a small set is more accurate than HyperLogLog.
+func TestHybridCardinalitySmall(t *testing.T) {
+	h, err := NewHybridCardinality(10000, 0.1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		h.Add([]byte(strconv.Itoa(i)))
+	}
+
+	if count := h.Count(); count < 99 || count > 101 {
+		t.Errorf("Expected 100, got %d", count)
+	}
+}
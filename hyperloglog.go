@@ -17,14 +17,29 @@ included in all copies or substantial portions of the Software.
 package boom
 
 import (
+	"bytes"
 	"errors"
 	"hash"
-	"hash/fnv"
+	"io"
 	"math"
+	"sort"
+	"unsafe"
 )
 
 var exp32 = math.Pow(2, 32)
 
+// hllAlphaInf is the bias-correction constant of Ertl's estimator, 1/(2 ln 2),
+// the limit of alpha as the number of registers grows.
+const hllAlphaInf = 0.721347520444481703680
+
+// hllSparse is set in the number of registers written for a HyperLogLog in
+// the sparse representation, which isn't a power of two.
+const hllSparse = 1 << 63
+
+// hllMaxSparseRegisters is the largest number of registers of a sparse
+// HyperLogLog, whose pairs hold the index of a register in 24 bits.
+const hllMaxSparseRegisters = 1 << 24
+
 // HyperLogLog implements the HyperLogLog cardinality estimation algorithm as
 // described by Flajolet, Fusy, Gandouet, and Meunier in HyperLogLog: the
 // analysis of a near-optimal cardinality estimation algorithm:
@@ -50,11 +65,18 @@ type HyperLogLog struct {
 	b         uint32      // number of bits to calculate register
 	alpha     float64     // bias-correction constant
 	hash      hash.Hash32 // hash function
+	redis     bool        // hash and count data the way Redis does
+
+	// The sparse representation keeps the set registers as index<<8 | rho
+	// pairs sorted by index, and registers is nil until it's converted to the
+	// dense one.
+	pairs       []uint32
+	sparseLimit uint // pairs kept before converting, zero if created dense
 }
 
 // NewHyperLogLog creates a new HyperLogLog with m registers. Returns an error
 // if m isn't a power of two.
-func NewHyperLogLog(m uint) (*HyperLogLog, error) {
+func NewHyperLogLog(m uint, opts ...Option) (*HyperLogLog, error) {
 	if (m & (m - 1)) != 0 {
 		return nil, errors.New("m must be a power of two")
 	}
@@ -64,21 +86,66 @@ func NewHyperLogLog(m uint) (*HyperLogLog, error) {
 		m:         m,
 		b:         uint32(math.Ceil(math.Log2(float64(m)))),
 		alpha:     calculateAlpha(m),
-		hash:      fnv.New32(),
+		hash:      applyOptions(opts).newHash32(newFNV32),
 	}, nil
 }
 
+// NewSparseHyperLogLog creates a new HyperLogLog with m registers like
+// NewHyperLogLog, but in the sparse representation described by Heule,
+// Nunkesser, and Hall in HyperLogLog in Practice:
+//
+// https://research.google/pubs/pub40671/
+//
+// It only keeps the registers which are set, as pairs of their index and
+// value taking 4 bytes each, and converts to the dense representation once
+// m/4 are, when the pairs would take as much memory as the registers. This
+// saves memory for many HyperLogLogs of mostly small cardinalities, such as
+// one per key, without changing their estimates. Returns an error if m isn't
+// a power of two or is more than 2^24.
+func NewSparseHyperLogLog(m uint, opts ...Option) (*HyperLogLog, error) {
+	if m > hllMaxSparseRegisters {
+		return nil, errors.New("m must be at most 2^24")
+	}
+	h, err := NewHyperLogLog(m, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	h.registers = nil
+	h.sparseLimit = m / 4
+	return h, nil
+}
+
+// Precision returns the number of bits of the hash used as register index,
+// log2 of the number of registers.
+func (h *HyperLogLog) Precision() uint {
+	return uint(h.b)
+}
+
+// Sparse returns true if the HyperLogLog is in the sparse representation.
+func (h *HyperLogLog) Sparse() bool {
+	return h.registers == nil
+}
+
 // NewDefaultHyperLogLog creates a new HyperLogLog optimized for the specified
 // standard error. Returns an error if the number of registers can't be
 // calculated for the provided accuracy.
-func NewDefaultHyperLogLog(e float64) (*HyperLogLog, error) {
+func NewDefaultHyperLogLog(e float64, opts ...Option) (*HyperLogLog, error) {
 	m := math.Pow(1.04/e, 2)
-	return NewHyperLogLog(uint(math.Pow(2, math.Ceil(math.Log2(m)))))
+	return NewHyperLogLog(uint(math.Pow(2, math.Ceil(math.Log2(m)))), opts...)
 }
 
 // Add will add the data to the set. Returns the HyperLogLog to allow for
 // chaining.
 func (h *HyperLogLog) Add(data []byte) *HyperLogLog {
+	if h.redis {
+		j, r := redisIndex(data)
+		if r > h.registers[j] {
+			h.registers[j] = r
+		}
+		return h
+	}
+
 	var (
 		hash = h.calculateHash(data)
 		k    = 32 - h.b
@@ -86,30 +153,94 @@ func (h *HyperLogLog) Add(data []byte) *HyperLogLog {
 		j    = hash >> uint(k)
 	)
 
-	if r > h.registers[j] {
+	h.set(j, r)
+	return h
+}
+
+// set raises the register to the value if it's larger, converting a sparse
+// HyperLogLog to the dense representation if it has too many pairs.
+func (h *HyperLogLog) set(j uint32, r uint8) {
+	if h.registers != nil {
+		if r > h.registers[j] {
+			h.registers[j] = r
+		}
+		return
+	}
+
+	i := sort.Search(len(h.pairs), func(i int) bool { return h.pairs[i]>>8 >= j })
+	if i < len(h.pairs) && h.pairs[i]>>8 == j {
+		if r > uint8(h.pairs[i]) {
+			h.pairs[i] = j<<8 | uint32(r)
+		}
+		return
+	}
+
+	if uint(len(h.pairs)) >= h.sparseLimit {
+		h.densify()
 		h.registers[j] = r
+		return
 	}
+	h.pairs = append(h.pairs, 0)
+	copy(h.pairs[i+1:], h.pairs[i:])
+	h.pairs[i] = j<<8 | uint32(r)
+}
 
-	return h
+// densify converts a sparse HyperLogLog to the dense representation.
+func (h *HyperLogLog) densify() {
+	h.registers = h.dense()
+	h.pairs = nil
+}
+
+// dense returns the registers, allocating them from the pairs if the
+// HyperLogLog is sparse.
+func (h *HyperLogLog) dense() []uint8 {
+	if h.registers != nil {
+		return h.registers
+	}
+
+	registers := make([]uint8, h.m)
+	for _, pair := range h.pairs {
+		registers[pair>>8] = uint8(pair)
+	}
+	return registers
 }
 
-// Count returns the approximated cardinality of the set.
+// Count returns the approximated cardinality of the set, using the improved
+// estimator described by Ertl in New cardinality estimation algorithms for
+// HyperLogLog sketches:
+//
+// https://arxiv.org/abs/1702.01284
+//
+// It's derived from the distribution of the register values as a whole, so
+// unlike RawCount it has no systematic error for small to mid cardinalities,
+// nor a discontinuity where the raw estimator switches to linear counting.
 func (h *HyperLogLog) Count() uint64 {
-	sum := 0.0
-	m := float64(h.m)
-	for _, val := range h.registers {
-		sum += 1.0 / math.Pow(2.0, float64(val))
+	if h.redis {
+		return h.redisCount()
+	}
+	return ertlEstimate(h.histogram(), float64(h.m))
+}
+
+// RawCount returns the approximated cardinality of the set using the raw
+// HyperLogLog estimator with the small and large range corrections of
+// Flajolet et al., which Count returned in earlier versions of the package.
+func (h *HyperLogLog) RawCount() uint64 {
+	if h.redis {
+		return h.redisCount()
+	}
+
+	var (
+		histogram = h.histogram()
+		sum       = 0.0
+		m         = float64(h.m)
+	)
+	for val, count := range histogram {
+		sum += float64(count) / math.Pow(2.0, float64(val))
 	}
 	estimate := h.alpha * m * m / sum
 	if estimate <= 5.0/2.0*m {
 		// Small range correction
-		v := 0
-		for _, r := range h.registers {
-			if r == 0 {
-				v++
-			}
-		}
-		if v > 0 {
+		if v := histogram[0]; v > 0 {
 			estimate = m * math.Log(m/float64(v))
 		}
 	} else if estimate > 1.0/30.0*exp32 {
@@ -119,13 +250,111 @@ func (h *HyperLogLog) Count() uint64 {
 	return uint64(estimate)
 }
 
+// histogram returns the number of registers of each value, from zero to one
+// more than the bits of the hash used to count zeros.
+func (h *HyperLogLog) histogram() []int {
+	histogram := make([]int, 32-h.b+2)
+	if h.registers == nil {
+		// The registers without a pair are zero.
+		histogram[0] = int(h.m) - len(h.pairs)
+		for _, pair := range h.pairs {
+			histogram[hllBucket(uint8(pair), len(histogram))]++
+		}
+		return histogram
+	}
+
+	for _, val := range h.registers {
+		histogram[hllBucket(val, len(histogram))]++
+	}
+	return histogram
+}
+
+// hllBucket returns the bucket of the histogram of length l counting the
+// register value, the last for values which the hash can't produce, such as
+// those read from a corrupted representation.
+func hllBucket(val uint8, l int) int {
+	if int(val) >= l {
+		return l - 1
+	}
+	return int(val)
+}
+
+// ertlEstimate returns the cardinality estimated by Ertl's improved estimator
+// from the histogram of the values of m registers, of which the last is the
+// value of a register whose hash bits were all zero.
+func ertlEstimate(histogram []int, m float64) uint64 {
+	q := len(histogram) - 2
+	z := m * ertlTau((m-float64(histogram[q+1]))/m)
+	for j := q; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * ertlSigma(float64(histogram[0])/m)
+	return uint64(math.Floor(hllAlphaInf*m*m/z + 0.5))
+}
+
+// ertlSigma is the sigma function of Ertl's estimator.
+func ertlSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+
+	var (
+		y = 1.0
+		z = x
+	)
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+// ertlTau is the tau function of Ertl's estimator.
+func ertlTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+
+	var (
+		y = 1.0
+		z = 1 - x
+	)
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
+
 // Merge combines this HyperLogLog with another. Returns an error if the number
-// of registers in the two HyperLogLogs are not equal.
+// of registers or the hashing schemes of the two HyperLogLogs are not equal.
 func (h *HyperLogLog) Merge(other *HyperLogLog) error {
 	if h.m != other.m {
 		return errors.New("number of registers must match")
 	}
 
+	if h.redis != other.redis {
+		return errors.New("hashing schemes must match")
+	}
+
+	if other.registers == nil {
+		for _, pair := range other.pairs {
+			h.set(pair>>8, uint8(pair))
+		}
+		return nil
+	}
+	if h.registers == nil {
+		h.densify()
+	}
 	for j, r := range other.registers {
 		if r > h.registers[j] {
 			h.registers[j] = r
@@ -135,13 +364,179 @@ func (h *HyperLogLog) Merge(other *HyperLogLog) error {
 	return nil
 }
 
-// Reset restores the HyperLogLog to its original state. It returns itself to
-// allow for chaining.
+// Equal returns true if the other HyperLogLog has the same number of registers
+// and hashing scheme and the same register values, whether it's in the same
+// representation or not. The hash functions aren't compared.
+func (h *HyperLogLog) Equal(other *HyperLogLog) bool {
+	return h.m == other.m && h.redis == other.redis && bytes.Equal(h.dense(), other.dense())
+}
+
+// Clone returns an independent copy of the HyperLogLog, which can be used
+// concurrently with the original.
+func (h *HyperLogLog) Clone() *HyperLogLog {
+	c := *h
+	c.hash = cloneHash32(h.hash)
+	c.registers = append([]uint8(nil), h.registers...)
+	c.pairs = append([]uint32(nil), h.pairs...)
+	return &c
+}
+
+// ByteSize returns the number of bytes used by the registers or pairs and
+// metadata of the HyperLogLog, excluding the hash function.
+func (h *HyperLogLog) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*h)) + uint64(cap(h.registers)) + uint64(cap(h.pairs))*4
+}
+
+// Reset restores the HyperLogLog to its original state, in the sparse
+// representation if it was created in it. It returns itself to allow for
+// chaining.
 func (h *HyperLogLog) Reset() *HyperLogLog {
+	if h.sparseLimit > 0 {
+		h.registers = nil
+		h.pairs = h.pairs[:0]
+		return h
+	}
 	h.registers = make([]uint8, h.m)
 	return h
 }
 
+// WriteTo writes a binary representation of the HyperLogLog to an I/O stream,
+// which for a sparse HyperLogLog only holds its pairs. The hash function is
+// not written. It returns the number of bytes written.
+func (h *HyperLogLog) WriteTo(stream io.Writer) (int64, error) {
+	var redis uint8
+	if h.redis {
+		redis = 1
+	}
+
+	var e encoder
+	if h.registers == nil {
+		e.write(uint64(h.m) | hllSparse)
+		e.write(uint64(h.sparseLimit))
+		e.write(uint64(len(h.pairs)))
+		e.write(h.pairs)
+	} else {
+		e.write(uint64(h.m))
+		e.write(h.registers)
+		e.write(redis)
+		e.write(uint64(h.sparseLimit))
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a HyperLogLog (such as might have
+// been written by WriteTo()) from an I/O stream, restoring its registers,
+// precision, representation, and hashing scheme, so a checkpointed HyperLogLog
+// keeps counting as if it had never been written. The HyperLogLog keeps its
+// current hash function, which must match the one used by the HyperLogLog that
+// was written, or uses the default one if it has none, such as when it's the
+// zero value. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (h *HyperLogLog) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d = decoder{r: payload}
+		m uint64
+	)
+	d.read(&m)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if h.hash == nil {
+		h.hash = newFNV32()
+	}
+	if m&hllSparse != 0 {
+		return h.readSparse(&d, payload, m&^hllSparse, n)
+	}
+	if m == 0 || (m&(m-1)) != 0 {
+		return n, errors.New("m must be a power of two")
+	}
+
+	if m > uint64(payload.Len()) {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	var (
+		registers   = make([]uint8, m)
+		redis       uint8
+		sparseLimit uint64
+	)
+	d.read(registers)
+	if payload.Len() > 0 {
+		// Written by format version 2.1 or later.
+		d.read(&redis)
+	}
+	if payload.Len() > 0 {
+		// Written with the sparse representation's limit.
+		d.read(&sparseLimit)
+	}
+	if d.err != nil {
+		return n, d.err
+	}
+	if redis == 1 && m != redisHLLRegisters {
+		return n, errors.New("invalid Redis HyperLogLog")
+	}
+
+	h.registers = registers
+	h.pairs = nil
+	h.sparseLimit = uint(sparseLimit)
+	h.m = uint(m)
+	h.b = uint32(math.Ceil(math.Log2(float64(m))))
+	h.alpha = calculateAlpha(uint(m))
+	h.redis = redis == 1
+	return n, nil
+}
+
+// readSparse reads the rest of the binary representation of a sparse
+// HyperLogLog of m registers from the payload, of which n bytes were read.
+func (h *HyperLogLog) readSparse(d *decoder, payload *bytes.Reader, m uint64, n int64) (int64, error) {
+	if m == 0 || (m&(m-1)) != 0 || m > hllMaxSparseRegisters {
+		return n, errors.New("m must be a power of two")
+	}
+
+	var sparseLimit, l uint64
+	d.read(&sparseLimit)
+	d.read(&l)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if l > sparseLimit || sparseLimit > m {
+		return n, errors.New("invalid sparse representation")
+	}
+	if l > uint64(payload.Len())/4 {
+		return n, io.ErrUnexpectedEOF
+	}
+	pairs := make([]uint32, l)
+	d.read(pairs)
+	if d.err != nil {
+		return n, d.err
+	}
+	for i, pair := range pairs {
+		if uint64(pair>>8) >= m || (i > 0 && pair>>8 <= pairs[i-1]>>8) {
+			return n, errors.New("invalid sparse representation")
+		}
+	}
+
+	h.registers = nil
+	h.pairs = pairs
+	h.sparseLimit = uint(sparseLimit)
+	h.m = uint(m)
+	h.b = uint32(math.Ceil(math.Log2(float64(m))))
+	h.alpha = calculateAlpha(uint(m))
+	h.redis = false
+	return n, nil
+}
+
 // calculateHash calculates the 32-bit hash value for the provided data.
 func (h *HyperLogLog) calculateHash(data []byte) uint32 {
 	h.hash.Write(data)
@@ -150,7 +545,22 @@ func (h *HyperLogLog) calculateHash(data []byte) uint32 {
 	return sum
 }
 
-// SetHash sets the hashing function used.
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (h *HyperLogLog) MarshalBinary() ([]byte, error) {
+	return marshalBinary(h)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo. If the
+// HyperLogLog has no hash function, such as when it's the zero value, the
+// default hash function is used.
+func (h *HyperLogLog) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(h, data)
+}
+
+// SetHash sets the hashing function used. It has no effect on a HyperLogLog
+// created with NewRedisHyperLogLog.
 func (h *HyperLogLog) SetHash(ha hash.Hash32) {
 	h.hash = ha
 }
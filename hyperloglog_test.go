@@ -18,10 +18,15 @@ package boom
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"hash"
+	"hash/fnv"
 	"io"
 	"math"
+	"math/rand"
 	"os"
+	"strconv"
 	"testing"
 )
 
@@ -107,6 +112,40 @@ func TestNewDefaultHyperLogLog(t *testing.T) {
 	}
 }
 
+// Ensures that NewDefaultHyperLogLog applies its options, so it hashes like a
+// HyperLogLog created with the same hash function and number of registers.
+func TestNewDefaultHyperLogLogOptions(t *testing.T) {
+	created := 0
+	newHash := func() hash.Hash32 {
+		created++
+		return fnv.New32a()
+	}
+	if _, err := NewDefaultHyperLogLog(0.1, WithHasher32(newHash)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if created != 1 {
+		t.Errorf("Expected the hash function to be created once, got %d", created)
+	}
+
+	h, err := NewDefaultHyperLogLog(0.1, WithHasher32(fnv.New32a))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected, _ := NewHyperLogLog(128, WithHasher32(fnv.New32a))
+	unhashed, _ := NewHyperLogLog(128)
+	for i := 0; i < 100; i++ {
+		h.Add([]byte(strconv.Itoa(i)))
+		expected.Add([]byte(strconv.Itoa(i)))
+		unhashed.Add([]byte(strconv.Itoa(i)))
+	}
+	if !bytes.Equal(h.registers, expected.registers) {
+		t.Error("Expected the registers of a HyperLogLog with the same hash function")
+	}
+	if bytes.Equal(h.registers, unhashed.registers) {
+		t.Error("Expected the hash function to change the registers")
+	}
+}
+
 func benchmarkCount(b *testing.B, registers int) {
 	words := dictionary(0)
 	m := uint(math.Pow(2, float64(registers)))
@@ -153,3 +192,165 @@ func BenchmarkHLLCount9(b *testing.B) {
 func BenchmarkHLLCount10(b *testing.B) {
 	benchmarkCount(b, 10)
 }
+
+// Ensures that a HyperLogLog read from its written representation has the
+// same count and can be merged with the original.
+func TestHyperLogLogWriteToReadFrom(t *testing.T) {
+	f, err := NewDefaultHyperLogLog(0.01)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 0; i < 10000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	restored, err := NewHyperLogLog(16)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	wn, err := f.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	rn, err := restored.ReadFrom(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if wn != rn {
+		t.Errorf("Expected %d bytes read, got %d", wn, rn)
+	}
+
+	if restored.Count() != f.Count() {
+		t.Errorf("Expected %d, got %d", f.Count(), restored.Count())
+	}
+
+	if err := restored.Merge(f); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+}
+
+// Ensures that a sparse HyperLogLog has the same counts as a dense one while
+// using less memory, and converts to the dense representation once m/4
+// registers are set.
+func TestSparseHyperLogLog(t *testing.T) {
+	sparse, err := NewSparseHyperLogLog(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dense, _ := NewHyperLogLog(1024)
+	sparse.SetHash(fnv.New32a())
+	dense.SetHash(fnv.New32a())
+	if !sparse.Sparse() || dense.Sparse() {
+		t.Fatal("Expected only the first HyperLogLog to be sparse")
+	}
+
+	for i := 0; i < 100; i++ {
+		sparse.Add([]byte(strconv.Itoa(i)))
+		dense.Add([]byte(strconv.Itoa(i)))
+	}
+	if !sparse.Sparse() || sparse.Count() != dense.Count() || !sparse.Equal(dense) {
+		t.Errorf("Expected a sparse count of %d, got %d", dense.Count(), sparse.Count())
+	}
+	if sparse.ByteSize() >= dense.ByteSize() {
+		t.Errorf("Expected fewer than %d bytes, got %d", dense.ByteSize(), sparse.ByteSize())
+	}
+
+	var buf bytes.Buffer
+	if _, err := sparse.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() >= 1024 {
+		t.Errorf("Expected fewer than 1024 bytes, got %d", buf.Len())
+	}
+	var read HyperLogLog
+	if err := read.UnmarshalBinary(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if !read.Sparse() || !read.Equal(sparse) {
+		t.Error("Expected the read HyperLogLog to be sparse and equal")
+	}
+
+	other, _ := NewSparseHyperLogLog(1024)
+	other.SetHash(fnv.New32a())
+	for i := 100; i < 1000; i++ {
+		other.Add([]byte(strconv.Itoa(i)))
+		dense.Add([]byte(strconv.Itoa(i)))
+	}
+	if other.Sparse() {
+		t.Error("Expected the HyperLogLog to be dense")
+	}
+	if err := sparse.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+	if sparse.Sparse() || !sparse.Equal(dense) || sparse.Count() != dense.Count() {
+		t.Errorf("Expected a dense count of %d, got %d", dense.Count(), sparse.Count())
+	}
+	if !sparse.Reset().Sparse() || sparse.Count() != 0 {
+		t.Error("Expected an empty sparse HyperLogLog")
+	}
+
+	if _, err := NewSparseHyperLogLog(1 << 25); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+// Ensures that Count has less systematic error than RawCount around the
+// cardinality where the raw estimator switches from linear counting.
+func TestHyperLogLogImprovedEstimator(t *testing.T) {
+	var (
+		rng       = rand.New(rand.NewSource(3))
+		data      = make([]byte, 8)
+		raw, ertl float64
+	)
+	for trial := 0; trial < 200; trial++ {
+		h, _ := NewHyperLogLog(256)
+		for i := 0; i < 640; i++ {
+			rng.Read(data)
+			h.Add(data)
+		}
+		raw += geterror(640, h.RawCount()) / 200
+		ertl += geterror(640, h.Count()) / 200
+	}
+	if math.Abs(ertl) > 0.01 || math.Abs(ertl) > math.Abs(raw)/2 {
+		t.Errorf("Expected a bias below 1%% and half of %f, got %f", raw, ertl)
+	}
+
+	empty, _ := NewHyperLogLog(256)
+	if count := empty.Count(); count != 0 {
+		t.Errorf("Expected 0, got %d", count)
+	}
+}
+
+// Ensures that a HyperLogLog checkpointed and restored into the zero value has
+// the same precision and keeps counting like the original.
+func TestHyperLogLogCheckpoint(t *testing.T) {
+	for _, newHLL := range []func(uint, ...Option) (*HyperLogLog, error){NewHyperLogLog, NewSparseHyperLogLog} {
+		h, _ := newHLL(4096)
+		for i := 0; i < 500; i++ {
+			h.Add([]byte(strconv.Itoa(i)))
+		}
+
+		var buf bytes.Buffer
+		if _, err := h.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		var restored HyperLogLog
+		if _, err := restored.ReadFrom(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if restored.Precision() != 12 || restored.Sparse() != h.Sparse() || !restored.Equal(h) {
+			t.Errorf("Expected precision 12 and an equal HyperLogLog, got %d", restored.Precision())
+		}
+
+		for i := 500; i < 3000; i++ {
+			h.Add([]byte(strconv.Itoa(i)))
+			restored.Add([]byte(strconv.Itoa(i)))
+		}
+		if !restored.Equal(h) || restored.Count() != h.Count() {
+			t.Errorf("Expected %d, got %d", h.Count(), restored.Count())
+		}
+	}
+}
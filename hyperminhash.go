@@ -0,0 +1,333 @@
+package boom
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"math/bits"
+	"unsafe"
+)
+
+// hmhMantissaBits is the number of bits of the hash following a HyperMinHash
+// register's leading zeros which it keeps to tell the minimums apart.
+const hmhMantissaBits = 10
+
+// HyperMinHash implements the HyperMinHash sketch as described by Yu and
+// Weber in HyperMinHash: MinHash in LogLog space:
+//
+// https://arxiv.org/abs/1710.08436
+//
+// Like a HyperLogLog, it hashes items to a number of registers and keeps the
+// maximum number of leading zeros of the hashes in each, so it estimates the
+// number of distinct items added and sketches merge into the sketch of the
+// union. Each register also keeps the 10 bits of the hash following the
+// leading zeros, so together they identify the minimum hash of the register,
+// as in a MinHash. Two sketches' registers then match about as often as the
+// sets share items, estimating the Jaccard similarity of the sets and, with
+// the cardinality of the union, the number of items in both, such as the users
+// in two segments. Registers take 16 bits each.
+type HyperMinHash struct {
+	registers []uint16    // rho<<hmhMantissaBits | ^mantissa, the largest the minimum hash
+	m         uint        // number of registers
+	p         uint        // number of bits of the hash indexing the register
+	hash      hash.Hash64 // hash function
+	seed      uint64      // hash seed (zero means unseeded)
+}
+
+// NewHyperMinHash creates a new HyperMinHash with m registers. The standard
+// error of its cardinalities is about 1.04/sqrt(m) and that of its
+// similarities about sqrt(J(1-J)/m) for a similarity J. Returns an error if m
+// isn't a power of two.
+func NewHyperMinHash(m uint, opts ...Option) (*HyperMinHash, error) {
+	if m == 0 || (m&(m-1)) != 0 {
+		return nil, errors.New("m must be a power of two")
+	}
+
+	o := applyOptions(opts)
+	return &HyperMinHash{
+		registers: make([]uint16, m),
+		m:         m,
+		p:         uint(bits.TrailingZeros64(uint64(m))),
+		hash:      o.newHash64(newFNV64),
+		seed:      o.seed,
+	}, nil
+}
+
+// Precision returns the number of bits of the hash used as register index,
+// log2 of the number of registers.
+func (h *HyperMinHash) Precision() uint {
+	return h.p
+}
+
+// Add will add the data to the set. Returns the HyperMinHash to allow for
+// chaining.
+func (h *HyperMinHash) Add(data []byte) *HyperMinHash {
+	lower, upper := seededHashKernel(data, h.hash, h.seed)
+	var (
+		x = mix64(uint64(upper)<<32 | uint64(lower))
+		j = x >> (64 - h.p)
+		v = x << h.p
+		w = h.zeroBits()
+	)
+
+	// Count the leading zeros within the bits which leave room for the
+	// mantissa, which follows the leading one.
+	lz := uint(bits.LeadingZeros64(v))
+	if lz > w {
+		lz = w
+	}
+	shift := lz + 1
+	if shift > w {
+		shift = w
+	}
+	mantissa := uint16((v << shift) >> (64 - hmhMantissaBits))
+
+	r := uint16(lz+1)<<hmhMantissaBits | (^mantissa & (1<<hmhMantissaBits - 1))
+	if r > h.registers[j] {
+		h.registers[j] = r
+	}
+	return h
+}
+
+// zeroBits returns the number of bits of the hash in which leading zeros are
+// counted, those which aren't used for the index or the mantissa.
+func (h *HyperMinHash) zeroBits() uint {
+	return 64 - h.p - hmhMantissaBits
+}
+
+// Count returns the approximated cardinality of the set using Ertl's improved
+// estimator, like HyperLogLog.
+func (h *HyperMinHash) Count() uint64 {
+	histogram := make([]int, h.zeroBits()+2)
+	for _, r := range h.registers {
+		histogram[hllBucket(uint8(r>>hmhMantissaBits), len(histogram))]++
+	}
+	return ertlEstimate(histogram, float64(h.m))
+}
+
+// Similarity returns the approximated Jaccard similarity of this set and the
+// other, the number of items in both divided by the number in either. It's
+// the fraction of the registers set in either sketch which are the same in
+// both, less the fraction expected to match by chance between sets with no
+// items in common, and is zero if both sets are empty. Returns an error if the
+// sketches have a different number of registers or hash seed.
+func (h *HyperMinHash) Similarity(other *HyperMinHash) (float64, error) {
+	if err := h.compatible(other); err != nil {
+		return 0, err
+	}
+
+	var matches, union int
+	for j, r := range h.registers {
+		o := other.registers[j]
+		if r == 0 && o == 0 {
+			continue
+		}
+		union++
+		if r == o {
+			matches++
+		}
+	}
+	if union == 0 {
+		return 0, nil
+	}
+
+	expected := h.expectedCollisions(float64(h.Count()), float64(other.Count()))
+	similarity := (float64(matches) - expected) / float64(union)
+	if similarity < 0 {
+		similarity = 0
+	}
+	return similarity, nil
+}
+
+// Intersection returns the approximated number of items in both this set and
+// the other, the similarity of the sets times the cardinality of their union.
+// Returns an error if the sketches have a different number of registers or
+// hash seed.
+func (h *HyperMinHash) Intersection(other *HyperMinHash) (uint64, error) {
+	similarity, err := h.Similarity(other)
+	if err != nil {
+		return 0, err
+	}
+
+	union := h.Clone()
+	union.Merge(other)
+	return uint64(math.Floor(similarity*float64(union.Count()) + 0.5)), nil
+}
+
+// expectedCollisions returns the expected number of registers which match
+// between sketches of independent sets of cardinalities n1 and n2. Each
+// register of a set of cardinality n holds the minimum of about n/m uniform
+// hashes, and two registers match when both minimums fall into the same
+// interval of a number of leading zeros and mantissa.
+func (h *HyperMinHash) expectedCollisions(n1, n2 float64) float64 {
+	var (
+		m          = float64(h.m)
+		l1, l2     = n1 / m, n2 / m
+		mantissas  = 1 << hmhMantissaBits
+		collisions = 0.0
+	)
+	for k := uint(1); k <= h.zeroBits(); k++ {
+		var (
+			start = math.Ldexp(1, -int(k))
+			width = math.Ldexp(1, -int(k+hmhMantissaBits))
+			p     = (1 - math.Exp(-l1*width)) * (1 - math.Exp(-l2*width))
+		)
+		if p == 0 {
+			continue
+		}
+		for i := 0; i < mantissas; i++ {
+			collisions += math.Exp(-(l1+l2)*(start+float64(i)*width)) * p
+		}
+	}
+	return m * collisions
+}
+
+// compatible returns an error if the other HyperMinHash has a different
+// number of registers or hash seed.
+func (h *HyperMinHash) compatible(other *HyperMinHash) error {
+	if h.m != other.m {
+		return errors.New("number of registers must match")
+	}
+
+	if h.seed != other.seed {
+		return errors.New("hash seed must match")
+	}
+	return nil
+}
+
+// Merge combines this HyperMinHash with another, so it's the sketch of the
+// union of the sets, such as to combine sketches built on different shards.
+// The sketches should use the same hash function. Returns an error if they
+// have a different number of registers or hash seed, in which case this
+// sketch is unchanged.
+func (h *HyperMinHash) Merge(other *HyperMinHash) error {
+	if err := h.compatible(other); err != nil {
+		return err
+	}
+
+	for j, r := range other.registers {
+		if r > h.registers[j] {
+			h.registers[j] = r
+		}
+	}
+	return nil
+}
+
+// Equal returns true if the other HyperMinHash has the same number of
+// registers, hash seed, and register values. The hash functions aren't
+// compared.
+func (h *HyperMinHash) Equal(other *HyperMinHash) bool {
+	if h.m != other.m || h.seed != other.seed {
+		return false
+	}
+
+	for j, r := range h.registers {
+		if r != other.registers[j] {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the HyperMinHash, which can be used
+// concurrently with the original.
+func (h *HyperMinHash) Clone() *HyperMinHash {
+	c := *h
+	c.hash = cloneHash64(h.hash)
+	c.registers = append([]uint16(nil), h.registers...)
+	return &c
+}
+
+// ByteSize returns the number of bytes used by the registers and metadata of
+// the HyperMinHash, excluding the hash function.
+func (h *HyperMinHash) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*h)) + uint64(cap(h.registers))*2
+}
+
+// Reset restores the HyperMinHash to its original state. It returns itself to
+// allow for chaining.
+func (h *HyperMinHash) Reset() *HyperMinHash {
+	for j := range h.registers {
+		h.registers[j] = 0
+	}
+	return h
+}
+
+// SetHash sets the hashing function used.
+func (h *HyperMinHash) SetHash(ha hash.Hash64) {
+	h.hash = ha
+}
+
+// WriteTo writes a binary representation of the HyperMinHash to an I/O
+// stream. The hash function is not written, but the seed is. It returns the
+// number of bytes written.
+func (h *HyperMinHash) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(h.m))
+	e.write(h.seed)
+	e.write(h.registers)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a HyperMinHash (such as might
+// have been written by WriteTo()) from an I/O stream. The HyperMinHash keeps
+// its current hash function, which must match the one used by the
+// HyperMinHash that was written, or uses the default one if it has none, such
+// as when it's the zero value. Returns ErrUnsupportedVersion if the data was
+// written by an incompatible version of the package. It returns the number of
+// bytes read.
+func (h *HyperMinHash) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d       = decoder{r: payload}
+		m, seed uint64
+	)
+	d.read(&m)
+	d.read(&seed)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if m == 0 || (m&(m-1)) != 0 {
+		return n, errors.New("m must be a power of two")
+	}
+	if m > uint64(payload.Len())/2 {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	registers := make([]uint16, m)
+	d.read(registers)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if h.hash == nil {
+		h.hash = newFNV64()
+	}
+	h.registers = registers
+	h.m = uint(m)
+	h.p = uint(bits.TrailingZeros64(m))
+	h.seed = seed
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (h *HyperMinHash) MarshalBinary() ([]byte, error) {
+	return marshalBinary(h)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (h *HyperMinHash) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(h, data)
+}
@@ -0,0 +1,99 @@
+package boom
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// Ensures that HyperMinHash estimates the cardinalities, similarity, and
+// intersection of overlapping, disjoint, and identical sets.
+func TestHyperMinHash(t *testing.T) {
+	if _, err := NewHyperMinHash(1000); err == nil {
+		t.Error("Expected an error for m not a power of two")
+	}
+
+	newSet := func(start, end int) *HyperMinHash {
+		h, err := NewHyperMinHash(1024, WithSeed(42))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := start; i < end; i++ {
+			h.Add([]byte(strconv.Itoa(i)))
+		}
+		return h
+	}
+
+	a, b := newSet(0, 30000), newSet(20000, 50000)
+	if count := a.Count(); math.Abs(float64(count)-30000) > 3000 {
+		t.Errorf("Expected about 30000, got %d", count)
+	}
+	similarity, err := a.Similarity(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(similarity-0.2) > 0.04 {
+		t.Errorf("Expected similarity about 0.2, got %f", similarity)
+	}
+	intersection, err := a.Intersection(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(float64(intersection)-10000) > 2000 {
+		t.Errorf("Expected intersection about 10000, got %d", intersection)
+	}
+
+	if similarity, _ := a.Similarity(newSet(30000, 60000)); similarity > 0.02 {
+		t.Errorf("Expected similarity about 0 for disjoint sets, got %f", similarity)
+	}
+	if similarity, _ := a.Similarity(newSet(0, 30000)); similarity < 0.99 {
+		t.Errorf("Expected similarity about 1 for identical sets, got %f", similarity)
+	}
+
+	union := a.Clone()
+	if err := union.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if count := union.Count(); math.Abs(float64(count)-50000) > 5000 {
+		t.Errorf("Expected about 50000, got %d", count)
+	}
+	if !union.Equal(newSet(0, 50000)) {
+		t.Error("Expected the merged sketch to equal the sketch of the union")
+	}
+
+	other, _ := NewHyperMinHash(1024)
+	if _, err := a.Similarity(other); err == nil {
+		t.Error("Expected an error for different seeds")
+	}
+	if a.Reset().Count() != 0 {
+		t.Error("Expected an empty set")
+	}
+}
+
+// Ensures that a HyperMinHash read from its written representation into the
+// zero value equals the original.
+func TestHyperMinHashWriteToReadFrom(t *testing.T) {
+	expected, err := NewHyperMinHash(256, WithSeed(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		expected.Add([]byte(strconv.Itoa(i)))
+	}
+
+	var buf bytes.Buffer
+	if _, err := expected.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var read HyperMinHash
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !read.Equal(expected) || read.Precision() != 8 {
+		t.Error("Expected the read sketch to equal the original")
+	}
+	if read.Add([]byte(`x`)); !read.Equal(expected.Add([]byte(`x`))) {
+		t.Error("Expected the read sketch to keep adding like the original")
+	}
+}
@@ -0,0 +1,228 @@
+package boom
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+)
+
+// Observer receives the events of an InstrumentedFilter, such as to update
+// Prometheus counters or to log suspected false positives. Its methods are
+// called synchronously by the operation reporting the event, so they should be
+// cheap, and must be safe for concurrent use if the filter is used
+// concurrently.
+type Observer interface {
+	// ObserveAdd is called after the data is added to the filter.
+	ObserveAdd(data []byte)
+
+	// ObserveTest is called after the data is tested for membership with the
+	// result of the test.
+	ObserveTest(data []byte, member bool)
+
+	// ObserveFalsePositive is called when the data is reported as a false
+	// positive with ReportFalsePositive.
+	ObserveFalsePositive(data []byte)
+
+	// ObserveReset is called after the filter is reset.
+	ObserveReset()
+}
+
+// FilterMetrics is a snapshot of the counters and gauges of an
+// InstrumentedFilter.
+type FilterMetrics struct {
+	Adds           uint64  `json:"adds"`            // number of additions
+	Tests          uint64  `json:"tests"`           // number of membership tests
+	Hits           uint64  `json:"hits"`            // number of tests reporting a member
+	FalsePositives uint64  `json:"false_positives"` // number of reported false positives
+	Resets         uint64  `json:"resets"`          // number of resets
+	FillRatio      float64 `json:"fill_ratio"`      // ratio of set bits, if the filter has one
+	Layers         int     `json:"layers"`          // number of filters in a Scalable Bloom Filter's series, otherwise 1
+}
+
+// InstrumentedFilter wraps a Filter, counting its additions, tests, reported
+// false positives, and resets and notifying an optional Observer of each, so
+// per-filter metrics can be exported without instrumenting every call site.
+// The counters are updated atomically, so an InstrumentedFilter is safe for
+// concurrent use if the wrapped filter is, such as a SafeFilter.
+//
+// InstrumentedFilter implements expvar.Var, so it can be published with
+// expvar.Publish to export its metrics as JSON.
+type InstrumentedFilter struct {
+	adds           uint64 // updated atomically
+	tests          uint64 // updated atomically
+	hits           uint64 // updated atomically
+	falsePositives uint64 // updated atomically
+	resets         uint64 // updated atomically
+	filter         Filter
+	observer       Observer
+}
+
+// NewInstrumentedFilter creates a new InstrumentedFilter wrapping the filter.
+// The observer may be nil if only the counters are needed.
+func NewInstrumentedFilter(filter Filter, observer Observer) *InstrumentedFilter {
+	return &InstrumentedFilter{filter: filter, observer: observer}
+}
+
+// Filter returns the wrapped filter.
+func (i *InstrumentedFilter) Filter() Filter {
+	return i.filter
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not.
+func (i *InstrumentedFilter) Test(data []byte) bool {
+	member := i.filter.Test(data)
+	i.observeTest(data, member)
+	return member
+}
+
+// Add will add the data to the filter. It returns the InstrumentedFilter to
+// allow for chaining.
+func (i *InstrumentedFilter) Add(data []byte) Filter {
+	i.filter.Add(data)
+	i.observeAdd(data)
+	return i
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add and is observed as
+// both. It returns true if the data is a member, false if not.
+func (i *InstrumentedFilter) TestAndAdd(data []byte) bool {
+	member := i.filter.TestAndAdd(data)
+	i.observeTest(data, member)
+	i.observeAdd(data)
+	return member
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (i *InstrumentedFilter) TestString(data string) bool {
+	return i.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (i *InstrumentedFilter) AddString(data string) Filter {
+	return i.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (i *InstrumentedFilter) TestAndAddString(data string) bool {
+	return i.TestAndAdd(stringBytes(data))
+}
+
+// ReportFalsePositive records that the data was reported as a member but
+// turned out not to be one, such as after checking an authoritative store.
+func (i *InstrumentedFilter) ReportFalsePositive(data []byte) {
+	atomic.AddUint64(&i.falsePositives, 1)
+	if i.observer != nil {
+		i.observer.ObserveFalsePositive(data)
+	}
+}
+
+// Reset restores the wrapped filter to its original state and records the
+// reset. The counters aren't cleared. Returns an error if the filter can't be
+// reset, such as a TieredFilter.
+func (i *InstrumentedFilter) Reset() error {
+	if !resetFilter(i.filter) {
+		return errors.New("filter can't be reset")
+	}
+
+	atomic.AddUint64(&i.resets, 1)
+	if i.observer != nil {
+		i.observer.ObserveReset()
+	}
+	return nil
+}
+
+// Metrics returns the current counters and gauges. The gauges are read from
+// the wrapped filter, so Metrics must not be called concurrently with its
+// operations unless the filter is safe for concurrent use.
+func (i *InstrumentedFilter) Metrics() FilterMetrics {
+	m := FilterMetrics{
+		Adds:           atomic.LoadUint64(&i.adds),
+		Tests:          atomic.LoadUint64(&i.tests),
+		Hits:           atomic.LoadUint64(&i.hits),
+		FalsePositives: atomic.LoadUint64(&i.falsePositives),
+		Resets:         atomic.LoadUint64(&i.resets),
+		Layers:         1,
+	}
+	readGauges(i.filter, &m)
+	return m
+}
+
+// String returns the metrics as JSON, implementing expvar.Var.
+func (i *InstrumentedFilter) String() string {
+	data, err := json.Marshal(i.Metrics())
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// observeAdd counts an addition and notifies the observer.
+func (i *InstrumentedFilter) observeAdd(data []byte) {
+	atomic.AddUint64(&i.adds, 1)
+	if i.observer != nil {
+		i.observer.ObserveAdd(data)
+	}
+}
+
+// observeTest counts a test and notifies the observer.
+func (i *InstrumentedFilter) observeTest(data []byte, member bool) {
+	atomic.AddUint64(&i.tests, 1)
+	if member {
+		atomic.AddUint64(&i.hits, 1)
+	}
+	if i.observer != nil {
+		i.observer.ObserveTest(data, member)
+	}
+}
+
+// readGauges sets the fill ratio and number of layers of the metrics from the
+// filter, holding the lock of a SafeFilter.
+func readGauges(filter Filter, m *FilterMetrics) {
+	switch f := filter.(type) {
+	case *SafeFilter:
+		f.Do(func(filter Filter) { readGauges(filter, m) })
+		return
+	case *ScalableBloomFilter:
+		m.Layers = len(f.filters)
+	}
+
+	if f, ok := filter.(interface {
+		FillRatio() float64
+	}); ok {
+		m.FillRatio = f.FillRatio()
+	}
+}
+
+// resetFilter resets the filter, holding the lock of a SafeFilter. It returns
+// false if the filter can't be reset.
+func resetFilter(filter Filter) bool {
+	switch f := filter.(type) {
+	case *SafeFilter:
+		reset := false
+		f.Do(func(filter Filter) { reset = resetFilter(filter) })
+		return reset
+	case *BloomFilter:
+		f.Reset()
+	case *PartitionedBloomFilter:
+		f.Reset()
+	case *BlockedBloomFilter:
+		f.Reset()
+	case *ScalableBloomFilter:
+		f.Reset()
+	case *ShardedScalableBloomFilter:
+		f.Reset()
+	case *CountingBloomFilter:
+		f.Reset()
+	case *StableBloomFilter:
+		f.Reset()
+	case *AtomicBloomFilter:
+		f.Reset()
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package boom
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+// countingObserver is an Observer counting the events it receives.
+type countingObserver struct {
+	adds, tests, members, falsePositives, resets int
+}
+
+func (c *countingObserver) ObserveAdd(data []byte) { c.adds++ }
+
+func (c *countingObserver) ObserveTest(data []byte, member bool) {
+	c.tests++
+	if member {
+		c.members++
+	}
+}
+
+func (c *countingObserver) ObserveFalsePositive(data []byte) { c.falsePositives++ }
+func (c *countingObserver) ObserveReset()                    { c.resets++ }
+
+// Ensures that InstrumentedFilter counts operations, notifies the observer,
+// and reads the gauges of the wrapped filter.
+func TestInstrumentedFilter(t *testing.T) {
+	var (
+		observer = &countingObserver{}
+		f        = NewInstrumentedFilter(NewScalableBloomFilter(10, 0.01, 0.8), observer)
+	)
+	for i := 0; i < 100; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	f.Test([]byte(`1`))
+	f.TestString(`a`)
+	if !f.TestAndAdd([]byte(`2`)) {
+		t.Error("2 should be a member")
+	}
+	f.ReportFalsePositive([]byte(`b`))
+
+	m := f.Metrics()
+	if m.Adds != 101 || m.Tests != 3 || m.Hits < 2 || m.FalsePositives != 1 || m.Resets != 0 {
+		t.Errorf("Unexpected counters %+v", m)
+	}
+	if m.Layers < 2 || m.FillRatio <= 0 {
+		t.Errorf("Expected several layers and a fill ratio, got %+v", m)
+	}
+	if observer.adds != 101 || observer.tests != 3 || uint64(observer.members) != m.Hits || observer.falsePositives != 1 {
+		t.Errorf("Unexpected observed events %+v", observer)
+	}
+
+	if err := f.Reset(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if m := f.Metrics(); m.Resets != 1 || m.Layers != 1 || m.FillRatio != 0 || observer.resets != 1 {
+		t.Errorf("Expected a reset empty filter, got %+v", m)
+	}
+
+	var decoded FilterMetrics
+	if err := json.Unmarshal([]byte(f.String()), &decoded); err != nil || decoded != f.Metrics() {
+		t.Errorf("Expected String to encode the metrics, got %s", f.String())
+	}
+}
+
+// Ensures that InstrumentedFilter resets a SafeFilter's filter and works
+// without an observer.
+func TestInstrumentedFilterReset(t *testing.T) {
+	f := NewInstrumentedFilter(NewSafeFilter(NewBloomFilter(100, 0.01)), nil)
+	f.Add([]byte(`a`))
+	if m := f.Metrics(); m.FillRatio == 0 {
+		t.Error("Expected a fill ratio through the SafeFilter")
+	}
+	if err := f.Reset(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Test([]byte(`a`)) {
+		t.Error("a should not be a member after reset")
+	}
+
+	tiered := NewInstrumentedFilter(NewTieredFilter(NewBloomFilter(100, 0.01)), nil)
+	if err := tiered.Reset(); err == nil {
+		t.Error("Expected error resetting a TieredFilter")
+	}
+}
@@ -33,8 +33,12 @@ package boom
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"hash"
-	"hash/fnv"
+	"io"
+	"math"
+	"sync"
 	"sync/atomic"
 	"unsafe"
 )
@@ -54,21 +58,46 @@ import (
 // An example use case is deduplicating events while processing a stream of
 // data. Ideally, duplicate events are relatively close together.
 type InverseBloomFilter struct {
+	// Statistics, accessed atomically.
+	additions  uint64 // number of additions
+	evictions  uint64 // number of additions replacing other data
+	collisions uint64 // number of tests finding other data
+	occupied   uint64 // number of slots holding data
+
 	array    []*[]byte
 	hash     hash.Hash32
 	capacity uint
+	keyed    bool   // index with SipHash under key rather than hash
+	key      sipKey // SipHash key
+	seed     uint64 // hash seed (zero means unseeded)
 }
 
 // NewInverseBloomFilter creates and returns a new InverseBloomFilter with the
 // specified capacity.
-func NewInverseBloomFilter(capacity uint) *InverseBloomFilter {
+func NewInverseBloomFilter(capacity uint, opts ...Option) *InverseBloomFilter {
+	o := applyOptions(opts)
 	return &InverseBloomFilter{
 		array:    make([]*[]byte, capacity),
-		hash:     fnv.New32(),
+		hash:     o.newHash32(newFNV32),
 		capacity: capacity,
+		seed:     o.seed,
 	}
 }
 
+// NewKeyedInverseBloomFilter creates and returns a new InverseBloomFilter with
+// the specified capacity which indexes data by its SipHash-2-4 under a random
+// 128-bit key. Since the index can't be predicted without the key, an attacker
+// can't craft data which evicts chosen items, making the filter suitable for
+// untrusted input. Unlike the hash function, keyed hashing has no shared state.
+// The key is included in the output of WriteTo, which must then be kept as
+// secret as the key itself. SetHash has no effect on a keyed filter.
+func NewKeyedInverseBloomFilter(capacity uint, opts ...Option) *InverseBloomFilter {
+	i := NewInverseBloomFilter(capacity, opts...)
+	i.keyed = true
+	i.key = applyOptions(opts).sipKey()
+	return i
+}
+
 // Test will test for membership of the data and returns true if it is a
 // member, false if not. This is a probabilistic test, meaning there is a
 // non-zero probability of false negatives but a zero probability of false
@@ -81,22 +110,77 @@ func (i *InverseBloomFilter) Test(data []byte) bool {
 	if val == nil {
 		return false
 	}
-	return bytes.Equal(*val, data)
+	if !bytes.Equal(*val, data) {
+		atomic.AddUint64(&i.collisions, 1)
+		return false
+	}
+	return true
 }
 
 // Add will add the data to the filter. It returns the filter to allow for
 // chaining.
 func (i *InverseBloomFilter) Add(data []byte) Filter {
-	index := i.index(data)
-	i.getAndSet(index, data)
+	i.TestAndAdd(data)
 	return i
 }
 
 // TestAndAdd is equivalent to calling Test followed by Add atomically. It
 // returns true if the data is a member, false if not.
 func (i *InverseBloomFilter) TestAndAdd(data []byte) bool {
-	oldID := i.getAndSet(i.index(data), data)
-	return bytes.Equal(oldID, data)
+	oldID, ok := i.getAndSet(i.index(data), data)
+	atomic.AddUint64(&i.additions, 1)
+	if !ok {
+		atomic.AddUint64(&i.occupied, 1)
+		return false
+	}
+	if !bytes.Equal(oldID, data) {
+		atomic.AddUint64(&i.evictions, 1)
+		return false
+	}
+	return true
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (i *InverseBloomFilter) TestString(data string) bool {
+	return i.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed and stored
+// without being copied. It returns the filter to allow for chaining.
+func (i *InverseBloomFilter) AddString(data string) Filter {
+	return i.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// and stored without being copied.
+func (i *InverseBloomFilter) TestAndAddString(data string) bool {
+	return i.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter. It returns the filter to allow
+// for chaining.
+func (i *InverseBloomFilter) AddMany(data [][]byte) Filter {
+	for _, d := range data {
+		i.Add(d)
+	}
+	return i
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+func (i *InverseBloomFilter) TestMany(data [][]byte) []bool {
+	return testMany(data, i.Test)
+}
+
+// AddAllContext adds each item of data to the filter, checking the context for
+// cancellation periodically. It returns the number of items added, which are
+// the first ones in data, and the context's error if it was cancelled before
+// every item was added.
+func (i *InverseBloomFilter) AddAllContext(ctx context.Context, data [][]byte) (int, error) {
+	return addAllContext(ctx, data, func(d []byte) error {
+		i.Add(d)
+		return nil
+	})
 }
 
 // Capacity returns the filter capacity.
@@ -104,35 +188,416 @@ func (i *InverseBloomFilter) Capacity() uint {
 	return i.capacity
 }
 
+// Additions returns the number of additions to the filter. It may be called
+// concurrently with other operations, like the other statistics.
+func (i *InverseBloomFilter) Additions() uint64 {
+	return atomic.LoadUint64(&i.additions)
+}
+
+// Evictions returns the number of additions which replaced other data, making
+// it a false negative. Evictions which rise with the additions of repeated
+// data suggest the capacity is too small for the working set, and Resize can
+// enlarge it.
+func (i *InverseBloomFilter) Evictions() uint64 {
+	return atomic.LoadUint64(&i.evictions)
+}
+
+// Collisions returns the number of calls to Test which found other data at
+// the index of the data, so the data may have been a false negative.
+func (i *InverseBloomFilter) Collisions() uint64 {
+	return atomic.LoadUint64(&i.collisions)
+}
+
+// EstimatedRecallWindow returns the estimated mean number of additions after
+// which an item is evicted, from the rate at which occupied slots have been
+// overwritten. An item repeated after d more additions is recognized with a
+// probability of about exp(-d/window), so it guides sizing the filter for a
+// stream's distance between duplicates. Returns +Inf if nothing has been
+// evicted.
+func (i *InverseBloomFilter) EstimatedRecallWindow() float64 {
+	evictions := i.Evictions()
+	if evictions == 0 {
+		return math.Inf(1)
+	}
+	return float64(atomic.LoadUint64(&i.occupied)) * float64(i.Additions()) / float64(evictions)
+}
+
+// Resize changes the capacity of the filter, at least 1, moving the data it
+// holds to its index in the new array. It returns the number of items dropped
+// because another item moved to the same index. None are dropped when growing
+// by a whole multiple, such as doubling, since items at different indices
+// stay at different indices. It must not be called concurrently with other
+// methods.
+func (i *InverseBloomFilter) Resize(capacity uint) uint {
+	if capacity == 0 {
+		capacity = 1
+	}
+	var (
+		old               = i.array
+		occupied, dropped uint
+	)
+	i.array = make([]*[]byte, capacity)
+	i.capacity = capacity
+	for _, val := range old {
+		if val == nil {
+			continue
+		}
+		index := i.index(*val)
+		if i.array[index] != nil {
+			dropped++
+		} else {
+			occupied++
+		}
+		i.array[index] = val
+	}
+	i.occupied = uint64(occupied)
+	return dropped
+}
+
+// Key returns the SipHash key of a filter created with
+// NewKeyedInverseBloomFilter.
+func (i *InverseBloomFilter) Key() [16]byte {
+	return i.key
+}
+
+// SetKey sets the SipHash key of a filter created with
+// NewKeyedInverseBloomFilter, such as to restore a key stored separately from
+// the filter. It must not be called concurrently with other methods.
+func (i *InverseBloomFilter) SetKey(key [16]byte) {
+	i.key = key
+}
+
+// WriteTo writes a binary representation of the InverseBloomFilter to an I/O
+// stream. Each slot is loaded atomically, but concurrent adds may or may not be
+// reflected in the written data. The hash function is not written, but the seed
+// and the key of a keyed filter are. It returns the number of bytes written.
+func (i *InverseBloomFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(i.capacity))
+	for index := range i.array {
+		indexPtr := (*unsafe.Pointer)(unsafe.Pointer(&i.array[index]))
+		val := (*[]byte)(atomic.LoadPointer(indexPtr))
+		if val == nil {
+			e.write(uint8(0))
+			continue
+		}
+		e.write(uint8(1))
+		e.writeBytes(*val)
+	}
+
+	var keyed uint8
+	if i.keyed {
+		keyed = 1
+	}
+	e.write(keyed)
+	e.write(i.key)
+	e.write(i.seed)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of an InverseBloomFilter (such as
+// might have been written by WriteTo()) from an I/O stream. It must not be
+// called concurrently with other methods. The filter keeps its current hash
+// function, which must match the one used by the filter that was written.
+// Returns ErrUnsupportedVersion if the data was written by an incompatible
+// version of the package. It returns the number of bytes read.
+func (i *InverseBloomFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d        = decoder{r: payload}
+		capacity uint64
+	)
+	d.read(&capacity)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if capacity > uint64(payload.Len()) {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	var (
+		array    = make([]*[]byte, capacity)
+		occupied uint64
+	)
+	for index := range array {
+		var present uint8
+		d.read(&present)
+		if present == 1 {
+			data := d.readBytes()
+			array[index] = &data
+			occupied++
+		}
+		if d.err != nil {
+			return n, d.err
+		}
+	}
+
+	var (
+		keyed uint8
+		key   sipKey
+		seed  uint64
+	)
+	if payload.Len() > 0 {
+		// Written by format version 2.2 or later.
+		d.read(&keyed)
+		d.read(&key)
+	}
+	if payload.Len() > 0 {
+		// Written by format version 2.3 or later.
+		d.read(&seed)
+	}
+	if d.err != nil {
+		return n, d.err
+	}
+
+	i.array = array
+	i.occupied = occupied
+	i.capacity = uint(capacity)
+	i.keyed = keyed == 1
+	i.key = key
+	i.seed = seed
+	return n, nil
+}
+
 // getAndSet returns the data that was in the slice at the given index after
-// putting the new data in the slice at that index, atomically.
-func (i *InverseBloomFilter) getAndSet(index uint32, data []byte) []byte {
+// putting the new data in the slice at that index, atomically, and whether
+// there was any.
+func (i *InverseBloomFilter) getAndSet(index uint32, data []byte) ([]byte, bool) {
 	indexPtr := (*unsafe.Pointer)(unsafe.Pointer(&i.array[index]))
 	keyUnsafe := unsafe.Pointer(&data)
-	var oldKey []byte
+	var (
+		oldKey []byte
+		ok     bool
+	)
 	for {
 		oldKeyUnsafe := atomic.LoadPointer(indexPtr)
 		if atomic.CompareAndSwapPointer(indexPtr, oldKeyUnsafe, keyUnsafe) {
 			oldKeyPtr := (*[]byte)(oldKeyUnsafe)
 			if oldKeyPtr != nil {
-				oldKey = *oldKeyPtr
+				oldKey, ok = *oldKeyPtr, true
 			}
 			break
 		}
 	}
-	return oldKey
+	return oldKey, ok
 }
 
 // index returns the array index for the given data.
 func (i *InverseBloomFilter) index(data []byte) uint32 {
+	if i.keyed {
+		return uint32(siphash24(i.key, data) % uint64(i.capacity))
+	}
+	if _, ok := i.hash.(*fnv32); ok {
+		// The default hash is computed without its state, so concurrent
+		// additions and tests don't race on it.
+		return fnv32Hash(i.seed, data) % uint32(i.capacity)
+	}
+
+	if i.seed != 0 {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], i.seed)
+		i.hash.Write(buf[:])
+	}
 	i.hash.Write(data)
 	index := i.hash.Sum32() % uint32(i.capacity)
 	i.hash.Reset()
 	return index
 }
 
+// Equal returns true if the other InverseBloomFilter has the same capacity,
+// hash seed, and SipHash key, if it's keyed, and holds the same data at every
+// index. The hash functions aren't compared.
+func (i *InverseBloomFilter) Equal(other *InverseBloomFilter) bool {
+	if i.capacity != other.capacity || i.keyed != other.keyed || i.seed != other.seed ||
+		(i.keyed && i.key != other.key) {
+		return false
+	}
+
+	for index := range i.array {
+		var (
+			x = (*[]byte)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&i.array[index]))))
+			y = (*[]byte)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&other.array[index]))))
+		)
+		if (x == nil) != (y == nil) || (x != nil && !bytes.Equal(*x, *y)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the InverseBloomFilter. It may be
+// called concurrently with other operations, in which case the copy holds the
+// data at each index as it was read.
+func (i *InverseBloomFilter) Clone() *InverseBloomFilter {
+	c := InverseBloomFilter{
+		additions:  i.Additions(),
+		evictions:  i.Evictions(),
+		collisions: i.Collisions(),
+		occupied:   atomic.LoadUint64(&i.occupied),
+		hash:       cloneHash32(i.hash),
+		capacity:   i.capacity,
+		keyed:      i.keyed,
+		key:        i.key,
+		seed:       i.seed,
+	}
+	c.array = make([]*[]byte, len(i.array))
+	for index := range i.array {
+		val := (*[]byte)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&i.array[index]))))
+		if val != nil {
+			data := append([]byte(nil), *val...)
+			c.array[index] = &data
+		}
+	}
+	return &c
+}
+
+// ByteSize returns the number of bytes used by the InverseBloomFilter's array,
+// including the data it holds, and metadata, excluding the hash function. It
+// may be called concurrently with other operations.
+func (i *InverseBloomFilter) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*i)) + uint64(cap(i.array))*pointerSize
+	for index := range i.array {
+		if data := (*[]byte)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&i.array[index])))); data != nil {
+			size += sliceSize + uint64(cap(*data))
+		}
+	}
+	return size
+}
+
 // SetHash sets the hashing function used in the filter.
 // For the effect on false positive rates see: https://github.com/tylertreat/BoomFilters/pull/1
 func (i *InverseBloomFilter) SetHash(h hash.Hash32) {
 	i.hash = h
 }
+
+// GrowingInverseBloomFilter is an InverseBloomFilter which doubles its
+// capacity when too many additions evict other data, so recall doesn't
+// collapse as the working set grows. Over each window of as many additions as
+// the capacity, it measures the ratio of additions which evicted other data,
+// and if it exceeds the threshold, the capacity is doubled with Resize, which
+// keeps every item. Additions and tests of the underlying filter are
+// concurrent as usual, but a doubling blocks them while it rehashes.
+//
+// A stream of mostly new data evicts steadily whatever the capacity, since
+// every new item replaces an old one once the array is full, so the maximum
+// capacity bounds the growth.
+type GrowingInverseBloomFilter struct {
+	additions   uint64 // number of additions in the window, accessed atomically
+	evictions   uint64 // evictions of the filter at the start of the window
+	mu          sync.RWMutex
+	filter      *InverseBloomFilter
+	threshold   float64
+	maxCapacity uint
+}
+
+// NewGrowingInverseBloomFilter creates and returns a new
+// GrowingInverseBloomFilter with the specified initial capacity, which doubles
+// when the ratio of additions evicting other data exceeds the threshold, up to
+// the maximum capacity.
+func NewGrowingInverseBloomFilter(capacity, maxCapacity uint, threshold float64, opts ...Option) *GrowingInverseBloomFilter {
+	return &GrowingInverseBloomFilter{
+		filter:      NewInverseBloomFilter(capacity, opts...),
+		threshold:   threshold,
+		maxCapacity: maxCapacity,
+	}
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. This is a probabilistic test, meaning there is a
+// non-zero probability of false negatives but a zero probability of false
+// positives.
+func (g *GrowingInverseBloomFilter) Test(data []byte) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.filter.Test(data)
+}
+
+// Add will add the data to the filter, doubling its capacity at the end of a
+// window with too many evictions. It returns the filter to allow for
+// chaining.
+func (g *GrowingInverseBloomFilter) Add(data []byte) Filter {
+	g.TestAndAdd(data)
+	return g
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add atomically. It
+// returns true if the data is a member, false if not.
+func (g *GrowingInverseBloomFilter) TestAndAdd(data []byte) bool {
+	g.mu.RLock()
+	var (
+		member    = g.filter.TestAndAdd(data)
+		additions = atomic.AddUint64(&g.additions, 1)
+		end       = additions >= uint64(g.filter.capacity)
+	)
+	g.mu.RUnlock()
+
+	if end {
+		g.grow()
+	}
+	return member
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (g *GrowingInverseBloomFilter) TestString(data string) bool {
+	return g.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed and stored
+// without being copied. It returns the filter to allow for chaining.
+func (g *GrowingInverseBloomFilter) AddString(data string) Filter {
+	return g.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// and stored without being copied.
+func (g *GrowingInverseBloomFilter) TestAndAddString(data string) bool {
+	return g.TestAndAdd(stringBytes(data))
+}
+
+// Capacity returns the current filter capacity.
+func (g *GrowingInverseBloomFilter) Capacity() uint {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.filter.capacity
+}
+
+// Evictions returns the number of additions which replaced other data.
+func (g *GrowingInverseBloomFilter) Evictions() uint64 {
+	return g.filter.Evictions()
+}
+
+// ByteSize returns the number of bytes used by the underlying filter, including
+// the data it holds, and metadata, excluding the hash function.
+func (g *GrowingInverseBloomFilter) ByteSize() uint64 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return uint64(unsafe.Sizeof(*g)) + g.filter.ByteSize()
+}
+
+// grow ends the window if no other addition has, doubling the capacity if the
+// ratio of evictions exceeded the threshold and the maximum capacity allows.
+func (g *GrowingInverseBloomFilter) grow() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	capacity := g.filter.capacity
+	if g.additions < uint64(capacity) {
+		return
+	}
+	evicted := g.filter.Evictions() - g.evictions
+	if float64(evicted)/float64(g.additions) > g.threshold && capacity*2 <= g.maxCapacity {
+		g.filter.Resize(capacity * 2)
+	}
+	g.additions = 0
+	g.evictions = g.filter.Evictions()
+}
@@ -1,6 +1,8 @@
 package boom
 
 import (
+	"bytes"
+	"math"
 	"strconv"
 	"testing"
 )
@@ -14,6 +16,110 @@ func TestInverseCapacity(t *testing.T) {
 	}
 }
 
+// Ensures that Resize keeps every item when doubling and that evictions are
+// counted.
+func TestInverseResize(t *testing.T) {
+	f := NewInverseBloomFilter(3)
+	f.Add([]byte(`a`)).Add([]byte(`a`))
+	if evictions := f.Evictions(); evictions != 0 {
+		t.Errorf("Expected 0, got %d", evictions)
+	}
+
+	// `d` hashes to the same index as `a`.
+	f.Add([]byte(`d`))
+	if evictions := f.Evictions(); evictions != 1 {
+		t.Errorf("Expected 1, got %d", evictions)
+	}
+
+	f = NewInverseBloomFilter(100)
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	var held [][]byte
+	for i := 0; i < 1000; i++ {
+		if data := []byte(strconv.Itoa(i)); f.Test(data) {
+			held = append(held, data)
+		}
+	}
+
+	if dropped := f.Resize(200); dropped != 0 {
+		t.Errorf("Expected 0, got %d", dropped)
+	}
+	if c := f.Capacity(); c != 200 {
+		t.Errorf("Expected 200, got %d", c)
+	}
+	for _, data := range held {
+		if !f.Test(data) {
+			t.Errorf("Expected %s to be a member", data)
+		}
+	}
+}
+
+// Ensures that the statistics count additions, evictions, and collisions, and
+// that the estimated recall window of a stream of distinct items is about the
+// capacity.
+func TestInverseStatistics(t *testing.T) {
+	f := NewInverseBloomFilter(1000)
+	if window := f.EstimatedRecallWindow(); !math.IsInf(window, 1) {
+		t.Errorf("Expected +Inf, got %f", window)
+	}
+
+	for i := 0; i < 10000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	misses := 0
+	for i := 0; i < 10000; i++ {
+		if !f.Test([]byte(strconv.Itoa(i))) {
+			misses++
+		}
+	}
+
+	if additions := f.Additions(); additions != 10000 {
+		t.Errorf("Expected 10000, got %d", additions)
+	}
+	if evictions := f.Evictions(); evictions < 8000 || evictions > 9500 {
+		t.Errorf("Expected about 9000 evictions, got %d", evictions)
+	}
+	if collisions := f.Collisions(); collisions == 0 || collisions > uint64(misses) {
+		t.Errorf("Expected between 1 and %d collisions, got %d", misses, collisions)
+	}
+	if window := f.EstimatedRecallWindow(); window < 800 || window > 1300 {
+		t.Errorf("Expected a window of about 1000, got %f", window)
+	}
+}
+
+// Ensures that a GrowingInverseBloomFilter grows with a repeated working set
+// until most of it is retained, but not beyond the maximum capacity.
+func TestGrowingInverse(t *testing.T) {
+	f := NewGrowingInverseBloomFilter(100, 1<<14, 0.5)
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 1000; i++ {
+			f.AddString(strconv.Itoa(i))
+		}
+	}
+	if c := f.Capacity(); c < 1000 || c > 1<<14 {
+		t.Errorf("Expected a capacity between 1000 and %d, got %d", 1<<14, c)
+	}
+
+	members := 0
+	for i := 0; i < 1000; i++ {
+		if f.TestString(strconv.Itoa(i)) {
+			members++
+		}
+	}
+	if members < 500 {
+		t.Errorf("Expected at least 500 members, got %d", members)
+	}
+
+	capped := NewGrowingInverseBloomFilter(100, 200, 0.5)
+	for i := 0; i < 10000; i++ {
+		capped.AddString(strconv.Itoa(i))
+	}
+	if c := capped.Capacity(); c != 200 {
+		t.Errorf("Expected 200, got %d", c)
+	}
+}
+
 // Ensures that TestAndAdd behaves correctly.
 func TestInverseTestAndAdd(t *testing.T) {
 	f := NewInverseBloomFilter(3)
@@ -109,3 +215,44 @@ func BenchmarkInverseTestAndAdd(b *testing.B) {
 		f.TestAndAdd(data[n])
 	}
 }
+
+// Ensures that an InverseBloomFilter read from its written representation has
+// the same members.
+func TestInverseBloomWriteToReadFrom(t *testing.T) {
+	f := NewInverseBloomFilter(100)
+	f.Add([]byte{})
+	for i := 0; i < 50; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	restored := NewInverseBloomFilter(10)
+	var buf bytes.Buffer
+	wn, err := f.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	rn, err := restored.ReadFrom(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if wn != rn {
+		t.Errorf("Expected %d bytes read, got %d", wn, rn)
+	}
+
+	if restored.Capacity() != f.Capacity() {
+		t.Errorf("Expected %d, got %d", f.Capacity(), restored.Capacity())
+	}
+
+	for i := 0; i < 100; i++ {
+		data := []byte(strconv.Itoa(i))
+		if restored.Test(data) != f.Test(data) {
+			t.Errorf("Expected %t for %s, got %t", f.Test(data), data, restored.Test(data))
+		}
+	}
+
+	if restored.Test([]byte{}) != f.Test([]byte{}) {
+		t.Errorf("Expected %t for empty data, got %t", f.Test([]byte{}), restored.Test([]byte{}))
+	}
+}
@@ -0,0 +1,199 @@
+package boom
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// MinHashLSH is a locality-sensitive hashing index of MinHashSignatures, as
+// described by Leskovec, Rajaraman, and Ullman in Mining of Massive Datasets,
+// chapter 3:
+//
+// http://infolab.stanford.edu/~ullman/mmds/ch3.pdf
+//
+// The k minimums of a signature are split into b bands of r rows, and a key
+// is filed under the hash of each of its signature's bands. A query returns
+// the keys which share at least one band with its signature, the candidates,
+// without comparing it with every signature in the index. Sets of similarity
+// J are candidates with a probability of 1 - (1 - J^r)^b, an S-curve which
+// rises steeply around the threshold (1/b)^(1/r), so similar sets are found
+// with high probability and dissimilar ones are rarely candidates. Candidates
+// can be verified by comparing their signatures.
+type MinHashLSH struct {
+	tables []map[uint64][]string // keys by band hash, one table per band
+	keys   map[string][]uint64   // band hashes of each key
+	bands  uint                  // number of bands
+	rows   uint                  // number of minimums per band
+	seed   uint64                // hash seed of the signatures
+}
+
+// NewMinHashLSH creates a new MinHashLSH indexing signatures of bands * rows
+// hash functions created with the same options. Bands and rows are at least
+// 1.
+func NewMinHashLSH(bands, rows uint, opts ...Option) *MinHashLSH {
+	if bands == 0 {
+		bands = 1
+	}
+	if rows == 0 {
+		rows = 1
+	}
+
+	tables := make([]map[uint64][]string, bands)
+	for i := range tables {
+		tables[i] = make(map[uint64][]string)
+	}
+	return &MinHashLSH{
+		tables: tables,
+		keys:   make(map[string][]uint64),
+		bands:  bands,
+		rows:   rows,
+		seed:   applyOptions(opts).seed,
+	}
+}
+
+// NewMinHashLSHForThreshold creates a new MinHashLSH indexing signatures of k
+// hash functions, choosing the bands and rows whose threshold is closest to
+// the similarity threshold. Some of the hash functions may be unused if k
+// isn't the product of the bands and rows.
+func NewMinHashLSHForThreshold(k uint, threshold float64, opts ...Option) *MinHashLSH {
+	bands, rows := uint(1), uint(1)
+	best := math.Inf(1)
+	for r := uint(1); r <= k; r++ {
+		b := k / r
+		if d := math.Abs(lshThreshold(b, r) - threshold); d < best {
+			bands, rows, best = b, r, d
+		}
+	}
+	return NewMinHashLSH(bands, rows, opts...)
+}
+
+// lshThreshold returns the similarity at which the probability of being a
+// candidate rises most steeply for b bands of r rows.
+func lshThreshold(b, r uint) float64 {
+	return math.Pow(1/float64(b), 1/float64(r))
+}
+
+// Bands returns the number of bands.
+func (l *MinHashLSH) Bands() uint {
+	return l.bands
+}
+
+// Rows returns the number of minimums per band.
+func (l *MinHashLSH) Rows() uint {
+	return l.rows
+}
+
+// Threshold returns the approximate similarity above which sets are likely to
+// be candidates, (1/b)^(1/r).
+func (l *MinHashLSH) Threshold() float64 {
+	return lshThreshold(l.bands, l.rows)
+}
+
+// Probability returns the probability that a set with the similarity to the
+// query is a candidate.
+func (l *MinHashLSH) Probability(similarity float64) float64 {
+	return 1 - math.Pow(1-math.Pow(similarity, float64(l.rows)), float64(l.bands))
+}
+
+// Len returns the number of keys in the index.
+func (l *MinHashLSH) Len() int {
+	return len(l.keys)
+}
+
+// Insert adds the key to the index with the signature, replacing the
+// signature it was inserted with before. Returns an error if the signature
+// has fewer than bands * rows hash functions or a different hash seed.
+func (l *MinHashLSH) Insert(key string, signature *MinHashSignature) error {
+	hashes, err := l.bandHashes(signature)
+	if err != nil {
+		return err
+	}
+
+	l.Remove(key)
+	for i, h := range hashes {
+		l.tables[i][h] = append(l.tables[i][h], key)
+	}
+	l.keys[key] = hashes
+	return nil
+}
+
+// Remove removes the key from the index. Returns true if it was in the index.
+func (l *MinHashLSH) Remove(key string) bool {
+	hashes, ok := l.keys[key]
+	if !ok {
+		return false
+	}
+
+	for i, h := range hashes {
+		bucket := l.tables[i][h]
+		for j, k := range bucket {
+			if k == key {
+				bucket = append(bucket[:j], bucket[j+1:]...)
+				break
+			}
+		}
+		if len(bucket) == 0 {
+			delete(l.tables[i], h)
+		} else {
+			l.tables[i][h] = bucket
+		}
+	}
+	delete(l.keys, key)
+	return true
+}
+
+// Query returns the keys whose signatures share a band with the signature, in
+// sorted order. Returns an error if the signature has fewer than bands * rows
+// hash functions or a different hash seed.
+func (l *MinHashLSH) Query(signature *MinHashSignature) ([]string, error) {
+	hashes, err := l.bandHashes(signature)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for i, h := range hashes {
+		for _, key := range l.tables[i][h] {
+			seen[key] = true
+		}
+	}
+
+	candidates := make([]string, 0, len(seen))
+	for key := range seen {
+		candidates = append(candidates, key)
+	}
+	sort.Strings(candidates)
+	return candidates, nil
+}
+
+// Reset restores the MinHashLSH to an empty index. It returns itself to allow
+// for chaining.
+func (l *MinHashLSH) Reset() *MinHashLSH {
+	for i := range l.tables {
+		l.tables[i] = make(map[uint64][]string)
+	}
+	l.keys = make(map[string][]uint64)
+	return l
+}
+
+// bandHashes returns the hash of each band of the signature's minimums.
+func (l *MinHashLSH) bandHashes(signature *MinHashSignature) ([]uint64, error) {
+	if uint(len(signature.mins)) < l.bands*l.rows {
+		return nil, errors.New("signature has too few hash functions")
+	}
+
+	if signature.seed != l.seed {
+		return nil, errors.New("hash seed must match")
+	}
+
+	hashes := make([]uint64, l.bands)
+	for i := range hashes {
+		h := uint64(i)
+		for _, v := range signature.mins[uint(i)*l.rows : uint(i+1)*l.rows] {
+			h = mix64((h ^ v) + 0x9e3779b97f4a7c15)
+		}
+		hashes[i] = h
+	}
+	return hashes, nil
+}
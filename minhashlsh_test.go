@@ -0,0 +1,71 @@
+package boom
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// Ensures that MinHashLSH returns the keys of similar signatures as candidates
+// and rarely those of dissimilar ones.
+func TestMinHashLSH(t *testing.T) {
+	newSignature := func(start, end int) *MinHashSignature {
+		s := NewMinHashSignature(128)
+		for i := start; i < end; i++ {
+			s.Add([]byte(strconv.Itoa(i)))
+		}
+		return s
+	}
+
+	l := NewMinHashLSH(32, 4)
+	for i := 0; i < 1000; i++ {
+		if err := l.Insert(strconv.Itoa(i), newSignature(i*100, i*100+100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l.Len() != 1000 {
+		t.Errorf("Expected 1000 keys, got %d", l.Len())
+	}
+
+	// Similar to key 7, whose set is 700 to 799, with a similarity of 0.82.
+	candidates, err := l.Query(newSignature(710, 810))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0] != "7" {
+		t.Errorf("Expected [7], got %v", candidates)
+	}
+	if threshold := l.Threshold(); math.Abs(threshold-0.42) > 0.01 {
+		t.Errorf("Expected a threshold of about 0.42, got %f", threshold)
+	}
+	if p := l.Probability(0.82); p < 0.99 {
+		t.Errorf("Expected a probability of at least 0.99, got %f", p)
+	}
+
+	if !l.Remove("7") || l.Remove("7") {
+		t.Error("Expected to remove key 7 once")
+	}
+	if candidates, _ := l.Query(newSignature(710, 810)); len(candidates) != 0 {
+		t.Errorf("Expected no candidates, got %v", candidates)
+	}
+
+	if err := l.Insert("short", NewMinHashSignature(64)); err == nil {
+		t.Error("Expected an error for too few hash functions")
+	}
+	if _, err := l.Query(NewMinHashSignature(128, WithSeed(1))); err == nil {
+		t.Error("Expected an error for a different seed")
+	}
+	if l.Reset().Len() != 0 {
+		t.Error("Expected an empty index")
+	}
+}
+
+// Ensures that NewMinHashLSHForThreshold chooses bands and rows with a
+// threshold near the target.
+func TestMinHashLSHForThreshold(t *testing.T) {
+	l := NewMinHashLSHForThreshold(128, 0.8)
+	if l.Bands()*l.Rows() > 128 || math.Abs(l.Threshold()-0.8) > 0.05 {
+		t.Errorf("Expected a threshold near 0.8 for at most 128 hash functions, got %f with %d bands of %d rows",
+			l.Threshold(), l.Bands(), l.Rows())
+	}
+}
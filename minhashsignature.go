@@ -0,0 +1,227 @@
+package boom
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"unsafe"
+)
+
+// MinHashSignature is a MinHash signature of a set, built incrementally by
+// adding its items, so a signature of each document can be computed as it's
+// streamed and stored to be compared with others later, unlike MinHash, which
+// compares two bags in memory. It keeps the minimum of k hash functions over
+// the items, and the fraction of the minimums two signatures share estimates
+// the Jaccard similarity of their sets with a standard error of about
+// sqrt(J(1-J)/k) for a similarity J. Signatures merge into the signature of
+// the union of their sets.
+type MinHashSignature struct {
+	mins []uint64    // minimum of each hash function
+	hash hash.Hash64 // hash function (kernel for all k functions)
+	seed uint64      // hash seed (zero means unseeded)
+}
+
+// NewMinHashSignature creates a new empty MinHashSignature of k hash
+// functions, at least 1.
+func NewMinHashSignature(k uint, opts ...Option) *MinHashSignature {
+	if k == 0 {
+		k = 1
+	}
+
+	o := applyOptions(opts)
+	s := &MinHashSignature{
+		mins: make([]uint64, k),
+		hash: o.newHash64(newFNV64),
+		seed: o.seed,
+	}
+	return s.Reset()
+}
+
+// K returns the number of hash functions.
+func (s *MinHashSignature) K() uint {
+	return uint(len(s.mins))
+}
+
+// Add will add the data to the set. Returns the MinHashSignature to allow for
+// chaining.
+func (s *MinHashSignature) Add(data []byte) *MinHashSignature {
+	lower, upper := seededHashKernel(data, s.hash, s.seed)
+	x := uint64(upper)<<32 | uint64(lower)
+	for i := range s.mins {
+		if v := minHashValue(x, i); v < s.mins[i] {
+			s.mins[i] = v
+		}
+	}
+	return s
+}
+
+// minHashValue returns the value of the ith hash function of a signature for
+// the base hash x.
+func minHashValue(x uint64, i int) uint64 {
+	return mix64(x + uint64(i)*0x9e3779b97f4a7c15)
+}
+
+// Similarity returns the approximated Jaccard similarity of this set and the
+// other, the fraction of the hash functions whose minimums are the same in
+// both signatures. It's zero if either set is empty. Returns an error if the
+// signatures have a different number of hash functions or hash seed.
+func (s *MinHashSignature) Similarity(other *MinHashSignature) (float64, error) {
+	if err := s.compatible(other); err != nil {
+		return 0, err
+	}
+
+	matches := 0
+	for i, v := range s.mins {
+		if v == other.mins[i] && v != math.MaxUint64 {
+			matches++
+		}
+	}
+	return float64(matches) / float64(len(s.mins)), nil
+}
+
+// compatible returns an error if the other MinHashSignature has a different
+// number of hash functions or hash seed.
+func (s *MinHashSignature) compatible(other *MinHashSignature) error {
+	if len(s.mins) != len(other.mins) {
+		return errors.New("number of hash functions must match")
+	}
+
+	if s.seed != other.seed {
+		return errors.New("hash seed must match")
+	}
+	return nil
+}
+
+// Merge combines this MinHashSignature with another, so it's the signature of
+// the union of the sets, such as to combine the signatures of the parts of a
+// document. The signatures should use the same hash function. Returns an
+// error if they have a different number of hash functions or hash seed, in
+// which case this signature is unchanged.
+func (s *MinHashSignature) Merge(other *MinHashSignature) error {
+	if err := s.compatible(other); err != nil {
+		return err
+	}
+
+	for i, v := range other.mins {
+		if v < s.mins[i] {
+			s.mins[i] = v
+		}
+	}
+	return nil
+}
+
+// Equal returns true if the other MinHashSignature has the same number of
+// hash functions, hash seed, and minimums. The hash functions aren't
+// compared.
+func (s *MinHashSignature) Equal(other *MinHashSignature) bool {
+	if s.compatible(other) != nil {
+		return false
+	}
+
+	for i, v := range s.mins {
+		if v != other.mins[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the MinHashSignature, which can be used
+// concurrently with the original.
+func (s *MinHashSignature) Clone() *MinHashSignature {
+	c := *s
+	c.hash = cloneHash64(s.hash)
+	c.mins = append([]uint64(nil), s.mins...)
+	return &c
+}
+
+// ByteSize returns the number of bytes used by the minimums and metadata of
+// the signature, excluding the hash function.
+func (s *MinHashSignature) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*s)) + uint64(cap(s.mins))*8
+}
+
+// Reset restores the MinHashSignature to the signature of the empty set. It
+// returns itself to allow for chaining.
+func (s *MinHashSignature) Reset() *MinHashSignature {
+	for i := range s.mins {
+		s.mins[i] = math.MaxUint64
+	}
+	return s
+}
+
+// SetHash sets the hashing function used.
+func (s *MinHashSignature) SetHash(h hash.Hash64) {
+	s.hash = h
+}
+
+// WriteTo writes a binary representation of the MinHashSignature to an I/O
+// stream. The hash function is not written, but the seed is. It returns the
+// number of bytes written.
+func (s *MinHashSignature) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(len(s.mins)))
+	e.write(s.seed)
+	e.write(s.mins)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a MinHashSignature (such as might
+// have been written by WriteTo()) from an I/O stream. The signature keeps its
+// current hash function, which must match the one used by the signature that
+// was written, or uses the default one if it has none, such as when it's the
+// zero value. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (s *MinHashSignature) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d       = decoder{r: payload}
+		k, seed uint64
+	)
+	d.read(&k)
+	d.read(&seed)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if k == 0 {
+		return n, errors.New("invalid signature parameters")
+	}
+	if k > uint64(payload.Len())/8 {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	mins := make([]uint64, k)
+	d.read(mins)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if s.hash == nil {
+		s.hash = newFNV64()
+	}
+	s.mins = mins
+	s.seed = seed
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (s *MinHashSignature) MarshalBinary() ([]byte, error) {
+	return marshalBinary(s)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (s *MinHashSignature) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(s, data)
+}
@@ -0,0 +1,76 @@
+package boom
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// Ensures that MinHashSignature estimates the similarity of overlapping,
+// disjoint, identical, and empty sets, and merges into the signature of the
+// union.
+func TestMinHashSignature(t *testing.T) {
+	newSignature := func(start, end int) *MinHashSignature {
+		s := NewMinHashSignature(256)
+		for i := start; i < end; i++ {
+			s.Add([]byte(strconv.Itoa(i)))
+		}
+		return s
+	}
+
+	a, b := newSignature(0, 600), newSignature(200, 800)
+	similarity, err := a.Similarity(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(similarity-0.5) > 0.1 {
+		t.Errorf("Expected similarity about 0.5, got %f", similarity)
+	}
+	if similarity, _ := a.Similarity(newSignature(600, 1200)); similarity > 0.02 {
+		t.Errorf("Expected similarity about 0 for disjoint sets, got %f", similarity)
+	}
+	if similarity, _ := a.Similarity(newSignature(0, 600)); similarity != 1 {
+		t.Errorf("Expected similarity 1 for identical sets, got %f", similarity)
+	}
+	if similarity, _ := NewMinHashSignature(256).Similarity(NewMinHashSignature(256)); similarity != 0 {
+		t.Errorf("Expected similarity 0 for empty sets, got %f", similarity)
+	}
+
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Equal(newSignature(0, 800)) {
+		t.Error("Expected the merged signature to equal the signature of the union")
+	}
+	if err := a.Merge(NewMinHashSignature(128)); err == nil {
+		t.Error("Expected an error for different numbers of hash functions")
+	}
+	if _, err := a.Similarity(NewMinHashSignature(256, WithSeed(1))); err == nil {
+		t.Error("Expected an error for different seeds")
+	}
+}
+
+// Ensures that a MinHashSignature read from its written representation into
+// the zero value equals the original and keeps adding like it.
+func TestMinHashSignatureWriteToReadFrom(t *testing.T) {
+	expected := NewMinHashSignature(64, WithSeed(3))
+	for i := 0; i < 100; i++ {
+		expected.Add([]byte(strconv.Itoa(i)))
+	}
+
+	var buf bytes.Buffer
+	if _, err := expected.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var read MinHashSignature
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !read.Equal(expected) || read.K() != 64 {
+		t.Error("Expected the read signature to equal the original")
+	}
+	if read.Add([]byte(`x`)); !read.Equal(expected.Add([]byte(`x`))) {
+		t.Error("Expected the read signature to keep adding like the original")
+	}
+}
@@ -0,0 +1,78 @@
+package boom
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// mmapFile is the file backing memory-mapped Buckets.
+type mmapFile struct {
+	file     *os.File // backing file
+	reopened bool     // the file existed with data of the right size
+}
+
+// NewMmapBuckets creates Buckets like NewBuckets whose data is memory-mapped
+// from the file at path. A missing or empty file is created and sized to fit
+// the buckets, while an existing file is mapped as is, so reopening it with
+// the same count and bucket size restores the buckets without decoding them.
+// Writes go straight to the shared mapping, which makes them visible to other
+// processes mapping the same file and lets the operating system persist them.
+// Use Sync to flush them and Close to release the mapping. Returns an error if
+// the file's size doesn't match the buckets or memory mapping isn't supported
+// on this platform.
+func NewMmapBuckets(path string, count uint, bucketSize uint8) (*Buckets, error) {
+	size := int64((count*uint(bucketSize) + 7) / 8)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	reopened := info.Size() > 0
+	if !reopened {
+		err = file.Truncate(size)
+	} else if info.Size() != size {
+		err = fmt.Errorf("file size %d doesn't match buckets size %d", info.Size(), size)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	data, err := mmap(file, int(size))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &Buckets{
+		count:      count,
+		data:       data,
+		bucketSize: bucketSize,
+		max:        (1 << bucketSize) - 1,
+		mmap:       &mmapFile{file: file, reopened: reopened},
+	}, nil
+}
+
+// MmapBucketsFactory returns a BucketsFactory which memory-maps each Buckets
+// from a file in the directory, named after the Buckets' index within the
+// filter. Creating a filter with the same parameters and directory again
+// reopens its data.
+func MmapBucketsFactory(dir string) BucketsFactory {
+	return func(index int, count uint, bucketSize uint8) (*Buckets, error) {
+		path := filepath.Join(dir, fmt.Sprintf("%06d.buckets", index))
+		return NewMmapBuckets(path, count, bucketSize)
+	}
+}
+
+// reopened returns true if the Buckets are memory-mapped from a file which
+// already held their data.
+func (b *Buckets) reopened() bool {
+	return b.mmap != nil && b.mmap.reopened
+}
@@ -0,0 +1,23 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package boom
+
+import (
+	"errors"
+	"os"
+)
+
+// errMmapUnsupported is returned by NewMmapBuckets on platforms without
+// memory mapping.
+var errMmapUnsupported = errors.New("memory-mapped buckets are not supported on this platform")
+
+// mmap returns an error since memory mapping isn't supported on this platform.
+func mmap(file *os.File, size int) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+// munmap does nothing since memory mapping isn't supported on this platform.
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,170 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package boom
+
+import (
+	"math/bits"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// Ensures that memory-mapped Buckets persist their data in the file and can
+// be reopened.
+func TestMmapBuckets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buckets")
+	b, err := NewMmapBuckets(path, 100, 10)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if b.reopened() {
+		t.Error("New file should not be reopened")
+	}
+
+	b.Set(1, 200).Increment(99, 1000)
+	if err := b.Sync(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	b, err = NewMmapBuckets(path, 100, 10)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer b.Close()
+
+	if !b.reopened() {
+		t.Error("Existing file should be reopened")
+	}
+
+	if val := b.Get(1); val != 200 {
+		t.Errorf("Expected 200, got %d", val)
+	}
+
+	if val := b.Get(99); val != 1000 {
+		t.Errorf("Expected 1000, got %d", val)
+	}
+
+	b.Reset()
+	if val := b.Get(99); val != 0 {
+		t.Errorf("Expected 0, got %d", val)
+	}
+
+	if _, err := NewMmapBuckets(path, 200, 10); err == nil {
+		t.Error("Expected error for mismatched file size")
+	}
+}
+
+// Ensures that buckets past the first 2^32 bits are set where they're read
+// rather than at a truncated offset. The buckets are memory-mapped from a
+// sparse file, so only the pages touched take memory.
+func TestMmapBucketsLargeOffsets(t *testing.T) {
+	if bits.UintSize < 64 {
+		t.Skip("Offsets past 2^32 bits need 64-bit integers")
+	}
+	b, err := NewMmapBuckets(filepath.Join(t.TempDir(), "buckets"), 1<<29+8, 8)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer b.Close()
+
+	b.Set(1<<29, 7).SetWide(1<<29+1, 9).Increment(1<<29+2, 11)
+	for bucket, expected := range map[uint]uint32{0: 0, 1: 0, 2: 0, 1 << 29: 7, 1<<29 + 1: 9, 1<<29 + 2: 11} {
+		if val := b.Get(bucket); val != expected {
+			t.Errorf("Expected %d at bucket %d, got %d", expected, bucket, val)
+		}
+	}
+}
+
+// Ensures that a BloomFilter created with MmapBucketsFactory restores its
+// members when it's created again.
+func TestBloomFilterWithMmapBuckets(t *testing.T) {
+	dir := t.TempDir()
+	f, err := NewBloomFilterWithBuckets(100, 0.01, MmapBucketsFactory(dir))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i := 0; i < 50; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	f, err = NewBloomFilterWithBuckets(100, 0.01, MmapBucketsFactory(dir))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer f.Close()
+
+	for i := 0; i < 50; i++ {
+		if !f.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+
+	if count := f.Count(); count < 45 || count > 55 {
+		t.Errorf("Expected count of about 50, got %d", count)
+	}
+}
+
+// Ensures that a ScalableBloomFilter created with MmapBucketsFactory restores
+// every filter in the series when it's created again, and that Reset clears
+// the files.
+func TestScalableBloomFilterWithMmapBuckets(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewScalableBloomFilterWithBuckets(10, 0.1, 0.8, MmapBucketsFactory(dir))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		s.Add([]byte(strconv.Itoa(i)))
+	}
+	stages := len(s.filters)
+	if stages < 2 {
+		t.Fatalf("Expected several filters, got %d", stages)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	s, err = NewScalableBloomFilterWithBuckets(10, 0.1, 0.8, MmapBucketsFactory(dir))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(s.filters) < stages {
+		t.Errorf("Expected at least %d filters, got %d", stages, len(s.filters))
+	}
+
+	for i := 0; i < 100; i++ {
+		if !s.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+
+	s.Reset()
+	if err := s.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	s, err = NewScalableBloomFilterWithBuckets(10, 0.1, 0.8, MmapBucketsFactory(dir))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	if len(s.filters) != 1 {
+		t.Errorf("Expected 1 filter, got %d", len(s.filters))
+	}
+
+	if s.Test([]byte(`0`)) {
+		t.Error("`0` should not be a member")
+	}
+}
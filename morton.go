@@ -0,0 +1,585 @@
+package boom
+
+import (
+	"context"
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"math/bits"
+	"math/rand"
+	"unsafe"
+)
+
+// Parameters of the blocks of a MortonFilter. Each block fills a 64-byte cache
+// line with a 2-bit counter per bucket, the overflow bits, and the
+// fingerprints of its buckets packed in bucket order.
+const (
+	mortonBlockBits      = 512
+	mortonBlockWords     = mortonBlockBits / 64
+	mortonOverflowBits   = 16
+	mortonBucketCapacity = 3 // largest 2-bit counter
+
+	// mortonSlotsPerBucket is the ratio of fingerprint slots to buckets in a
+	// block. There are fewer slots than buckets can hold, since most buckets
+	// aren't full.
+	mortonSlotsPerBucket = 0.72
+)
+
+// MortonFilter implements a compressed Cuckoo Filter as described by Breslow
+// and Jayasena in Morton Filters: Faster, Space-Efficient Cuckoo Filters via
+// Biasing, Compression, and Decoupled Logical Sparsity:
+//
+// http://www.vldb.org/pvldb/vol11/p1041-breslow.pdf
+//
+// Items hash to two of many logical buckets of up to three fingerprints, like
+// in a Cuckoo Filter, but the buckets are grouped in 64-byte blocks which
+// store only the fingerprints present, packed one after the other, with a
+// 2-bit count per bucket to find them. A block has slots for about 0.72
+// fingerprints per bucket, so buckets are sparse while the fingerprints are
+// dense. Insertions prefer an item's first bucket, and an overflow bit in its
+// block records when an item had to go to its second bucket, so most
+// lookups, and nearly all negative lookups, only read the cache line of the
+// first bucket. TestMany probes the first blocks of a batch before any second
+// block.
+//
+// The gain over a CuckooFilter is largest on filters far larger than the CPU
+// caches, where most lookups miss the cache once rather than twice. Finding a
+// bucket in a block counts the fingerprints before it, which costs a few
+// population counts, and insertions move the fingerprints after it.
+type MortonFilter struct {
+	words   []uint64    // blocks of 8 words
+	hash    hash.Hash64 // hash function (used for fingerprint and bucket)
+	blocks  uint        // number of blocks
+	buckets uint        // number of buckets per block
+	slots   uint        // number of fingerprint slots per block
+	f       uint        // length of fingerprints (in bits)
+	count   uint        // number of items in the filter
+	n       uint        // filter capacity
+	seed    uint64      // hash seed (zero means unseeded)
+	rand    *rand.Rand  // source of randomness for relocations, if seeded
+}
+
+// NewMortonFilter creates a new Morton Filter optimized to store n items with
+// a specified target false-positive rate. Fingerprints take between 4 and 32
+// bits, so the false-positive rate is at most MortonFPRate(bits) for the
+// fingerprint length chosen.
+func NewMortonFilter(n uint, fpRate float64, opts ...Option) *MortonFilter {
+	f := uint(math.Ceil(math.Log2(2 * mortonSlotsPerBucket / fpRate)))
+	if f < 4 || math.IsNaN(fpRate) {
+		f = 4
+	} else if f > 32 {
+		f = 32
+	}
+
+	o := applyOptions(opts)
+	m := &MortonFilter{
+		hash: o.newHash64(newFNV64),
+		f:    f,
+		n:    n,
+		seed: o.seed,
+		rand: o.rand(),
+	}
+	m.buckets, m.slots = mortonLayout(f)
+	m.blocks = uint(math.Ceil(float64(n) / (float64(m.slots) * mortonLoad(m.slots))))
+	if m.blocks == 0 {
+		m.blocks = 1
+	}
+	m.words = make([]uint64, m.blocks*mortonBlockWords)
+	return m
+}
+
+// mortonLayout returns the number of buckets, an even number, and of
+// fingerprint slots of a block with fingerprints of f bits.
+func mortonLayout(f uint) (uint, uint) {
+	space := float64(mortonBlockBits - mortonOverflowBits)
+	buckets := uint(space/(mortonSlotsPerBucket*float64(f)+2)) &^ 1
+	return buckets, (mortonBlockBits - mortonOverflowBits - 2*buckets) / f
+}
+
+// mortonLoad returns the fraction of the slots of a MortonFilter with the
+// given number of slots per block it's expected to fill before an insertion
+// fails, as measured, from 93% with 4-bit fingerprints to 84% with 32 bits.
+func mortonLoad(slots uint) float64 {
+	return 1 - 0.6/math.Sqrt(float64(slots))
+}
+
+// Blocks returns the number of 64-byte blocks.
+func (m *MortonFilter) Blocks() uint {
+	return m.blocks
+}
+
+// Capacity returns the number of items the filter can store.
+func (m *MortonFilter) Capacity() uint {
+	return m.n
+}
+
+// Count returns the number of items in the filter.
+func (m *MortonFilter) Count() uint {
+	return m.count
+}
+
+// FingerprintBits returns the length of fingerprints in bits.
+func (m *MortonFilter) FingerprintBits() uint {
+	return m.f
+}
+
+// EstimatedFPRate returns the estimated false-positive rate for the current
+// number of items: the probability that one of the fingerprints in an absent
+// item's two buckets matches, assuming both are read.
+func (m *MortonFilter) EstimatedFPRate() float64 {
+	perBucket := float64(m.count) / float64(m.blocks*m.buckets)
+	return 1 - math.Pow(1-math.Exp2(-float64(m.f)), 2*perBucket)
+}
+
+// MortonFPRate returns the upper bound on the false-positive rate of a
+// MortonFilter with fingerprints of the given number of bits once it's full,
+// when both buckets of an item are read.
+func MortonFPRate(fingerprintBits uint) float64 {
+	return math.Min(1, 2*mortonSlotsPerBucket/math.Exp2(float64(fingerprintBits)))
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. This is a probabilistic test, meaning there is a
+// non-zero probability of false positives.
+func (m *MortonFilter) Test(data []byte) bool {
+	b1, b2, f := m.components(data)
+	if m.indexOf(b1, f) != -1 {
+		return true
+	}
+	return m.overflowed(b1, f) && m.indexOf(b2, f) != -1
+}
+
+// Add will add the data to the filter. It returns an error if the filter is
+// full. If the filter is full, an item is removed to make room for the new
+// item. This introduces a possibility for false negatives. To avoid this, use
+// Count and Capacity to check if the filter is full before adding an item.
+func (m *MortonFilter) Add(data []byte) error {
+	return m.add(m.components(data))
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data is a member, false if not. An error is returned if the filter is
+// full.
+func (m *MortonFilter) TestAndAdd(data []byte) (bool, error) {
+	b1, b2, f := m.components(data)
+	if m.indexOf(b1, f) != -1 || m.overflowed(b1, f) && m.indexOf(b2, f) != -1 {
+		return true, nil
+	}
+
+	return false, m.add(b1, b2, f)
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (m *MortonFilter) TestString(data string) bool {
+	return m.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied.
+func (m *MortonFilter) AddString(data string) error {
+	return m.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (m *MortonFilter) TestAndAddString(data string) (bool, error) {
+	return m.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter, stopping at the first error
+// returned by Add. It returns the number of items added, which are the first
+// ones in data, and the error, if any.
+func (m *MortonFilter) AddMany(data [][]byte) (int, error) {
+	return addAllContext(context.Background(), data, m.Add)
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+// The items are tested in two passes, first reading the block of every item's
+// first bucket and then those of the second buckets of the items which
+// weren't found and whose first bucket overflowed, which keeps the memory
+// accesses of each pass independent of each other.
+func (m *MortonFilter) TestMany(data [][]byte) []bool {
+	var (
+		results = make([]bool, len(data))
+		second  = make([]int, 0, len(data)/8)
+		alts    = make([]uint, 0, len(data)/8)
+		fps     = make([]uint32, 0, len(data)/8)
+	)
+	for i, item := range data {
+		b1, b2, f := m.components(item)
+		if m.indexOf(b1, f) != -1 {
+			results[i] = true
+		} else if m.overflowed(b1, f) {
+			second = append(second, i)
+			alts = append(alts, b2)
+			fps = append(fps, f)
+		}
+	}
+	for j, i := range second {
+		results[i] = m.indexOf(alts[j], fps[j]) != -1
+	}
+	return results
+}
+
+// TestAndRemove will test for membership of the data and remove it from the
+// filter if it exists. Returns true if the data was a member, false if not.
+// Overflow bits aren't cleared, since other items may depend on them.
+func (m *MortonFilter) TestAndRemove(data []byte) bool {
+	b1, b2, f := m.components(data)
+	if j := m.indexOf(b1, f); j != -1 {
+		m.remove(b1, j)
+		m.count--
+		return true
+	}
+	if !m.overflowed(b1, f) {
+		return false
+	}
+	if j := m.indexOf(b2, f); j != -1 {
+		m.remove(b2, j)
+		m.count--
+		return true
+	}
+	return false
+}
+
+// Reset restores the filter to its original state. It returns the filter to
+// allow for chaining.
+func (m *MortonFilter) Reset() *MortonFilter {
+	for i := range m.words {
+		m.words[i] = 0
+	}
+	m.count = 0
+	return m
+}
+
+// ByteSize returns the number of bytes used by the blocks and metadata of the
+// filter. The hash function and source of randomness aren't included.
+func (m *MortonFilter) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*m)) + uint64(cap(m.words))*8
+}
+
+// SetHash sets the hashing function used to compute fingerprints and bucket
+// indices.
+func (m *MortonFilter) SetHash(h hash.Hash64) {
+	m.hash = h
+}
+
+// WriteTo writes a binary representation of the MortonFilter to an I/O
+// stream, including its blocks. The hash function is not written, but the
+// seed is. It returns the number of bytes written.
+func (m *MortonFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(m.blocks))
+	e.write(uint64(m.f))
+	e.write(uint64(m.count))
+	e.write(uint64(m.n))
+	e.write(m.seed)
+	e.write(m.words)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a MortonFilter (such as might
+// have been written by WriteTo()) from an I/O stream. The filter keeps its
+// current hash function, which must match the one used by the filter that was
+// written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (m *MortonFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                      = decoder{r: payload}
+		blocks, f, count, size uint64
+		seed                   uint64
+		decoded                MortonFilter
+	)
+	d.read(&blocks)
+	d.read(&f)
+	d.read(&count)
+	d.read(&size)
+	d.read(&seed)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if blocks == 0 || f < 4 || f > 32 {
+		return n, errors.New("invalid filter parameters")
+	}
+	if blocks > uint64(payload.Len())/8/mortonBlockWords {
+		return n, io.ErrUnexpectedEOF
+	}
+	words := make([]uint64, blocks*mortonBlockWords)
+	d.read(words)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	decoded.words = words
+	decoded.f = uint(f)
+	decoded.buckets, decoded.slots = mortonLayout(uint(f))
+	var total uint64
+	for block := uint(0); block < uint(blocks); block++ {
+		used := decoded.bucketOffset(block, decoded.buckets)
+		if used > decoded.slots {
+			return n, errors.New("invalid block")
+		}
+		total += uint64(used)
+	}
+	if total != count {
+		return n, errors.New("count doesn't match blocks")
+	}
+
+	if m.hash == nil {
+		m.hash = newFNV64()
+	}
+	m.words = words
+	m.blocks = uint(blocks)
+	m.buckets = decoded.buckets
+	m.slots = decoded.slots
+	m.f = uint(f)
+	m.count = uint(count)
+	m.n = uint(size)
+	m.seed = seed
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (m *MortonFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(m)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo. If the filter
+// has no hash function, such as when it's the zero value, the default hash
+// function is used.
+func (m *MortonFilter) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(m, data)
+}
+
+// add will insert the fingerprint into its first bucket, or into its second
+// bucket after setting the overflow bit of the first, returning an error if
+// the filter is full.
+func (m *MortonFilter) add(b1, b2 uint, f uint32) error {
+	if m.insert(b1, f) {
+		m.count++
+		return nil
+	}
+	m.setOverflowed(b1, f)
+	if m.insert(b2, f) {
+		m.count++
+		return nil
+	}
+
+	// Must relocate existing items. The victim is in the bucket if it's full,
+	// or anywhere in its block if the block's slots are full, and one whose
+	// other bucket has room is preferred, which lets the blocks fill further
+	// than random relocations do.
+	b := b1
+	for n := 0; n < maxNumKicks; n++ {
+		var (
+			block       = b / m.buckets
+			first, size = uint(0), m.slots
+		)
+		if m.bucketCount(b) == mortonBucketCapacity {
+			first, size = m.bucketOffset(block, b%m.buckets), mortonBucketCapacity
+		}
+		start := uint(m.intn(int(size)))
+		slot := first + start
+		for k := uint(0); k < size; k++ {
+			candidate := first + (start+k)%size
+			v := getWordBits(m.words, m.slotPos(block, candidate), m.f)
+			if m.fits(m.altBucket(m.slotBucket(block, candidate), v)) {
+				slot = candidate
+				break
+			}
+		}
+
+		var (
+			victim = m.slotBucket(block, slot)
+			v      = getWordBits(m.words, m.slotPos(block, slot), m.f)
+		)
+		m.remove(victim, int(slot-m.bucketOffset(block, victim%m.buckets)))
+		m.insert(b, f)
+
+		// The victim moves to its other bucket, which is its second one if
+		// it leaves its first.
+		m.setOverflowed(victim, v)
+		f, b = v, m.altBucket(victim, v)
+		if m.insert(b, f) {
+			m.count++
+			return nil
+		}
+	}
+
+	return errors.New("full")
+}
+
+// insert appends the fingerprint to the bucket, moving the fingerprints of
+// the following buckets of its block up a slot. Returns false if the bucket or
+// its block is full.
+func (m *MortonFilter) insert(b uint, f uint32) bool {
+	var (
+		block = b / m.buckets
+		j     = b % m.buckets
+		count = m.bucketCount(b)
+		used  = m.bucketOffset(block, m.buckets)
+	)
+	if !m.fits(b) {
+		return false
+	}
+
+	end := m.bucketOffset(block, j) + uint(count)
+	for s := used; s > end; s-- {
+		setWordBits(m.words, m.slotPos(block, s), m.f, getWordBits(m.words, m.slotPos(block, s-1), m.f))
+	}
+	setWordBits(m.words, m.slotPos(block, end), m.f, f)
+	m.setBucketCount(b, count+1)
+	return true
+}
+
+// fits returns whether a fingerprint can be appended to the bucket, which
+// requires room in both the bucket and its block.
+func (m *MortonFilter) fits(b uint) bool {
+	return m.bucketCount(b) < mortonBucketCapacity && m.bucketOffset(b/m.buckets, m.buckets) < m.slots
+}
+
+// remove removes the i-th fingerprint of the bucket, moving the fingerprints
+// of the following buckets of its block down a slot.
+func (m *MortonFilter) remove(b uint, i int) {
+	var (
+		block = b / m.buckets
+		used  = m.bucketOffset(block, m.buckets)
+	)
+	for s := m.bucketOffset(block, b%m.buckets) + uint(i); s+1 < used; s++ {
+		setWordBits(m.words, m.slotPos(block, s), m.f, getWordBits(m.words, m.slotPos(block, s+1), m.f))
+	}
+	setWordBits(m.words, m.slotPos(block, used-1), m.f, 0)
+	m.setBucketCount(b, m.bucketCount(b)-1)
+}
+
+// indexOf returns the index of the fingerprint in the bucket or -1 if it's not
+// in the bucket.
+func (m *MortonFilter) indexOf(b uint, f uint32) int {
+	var (
+		block = b / m.buckets
+		start = m.bucketOffset(block, b%m.buckets)
+	)
+	for i := 0; i < m.bucketCount(b); i++ {
+		if getWordBits(m.words, m.slotPos(block, start+uint(i)), m.f) == f {
+			return i
+		}
+	}
+	return -1
+}
+
+// components returns the two buckets of the data and its fingerprint. The
+// hash is mixed first, since the bucket is taken from the high bits of its
+// lower half, which an unseeded FNV hash barely changes for similar data.
+func (m *MortonFilter) components(data []byte) (uint, uint, uint32) {
+	lower, upper := seededHashKernel(data, m.hash, m.seed)
+	h := mix64(uint64(upper)<<32 | uint64(lower))
+	f := uint32(h>>32) >> (32 - m.f)
+	b1 := uint(uint64(uint32(h)) * uint64(m.blocks*m.buckets) >> 32)
+	return b1, m.altBucket(b1, f), f
+}
+
+// altBucket returns the other bucket of an item with the fingerprint in the
+// bucket. The offset between the two is odd and the number of buckets even,
+// so the buckets have different parities, and the parity gives the direction
+// of the offset.
+func (m *MortonFilter) altBucket(b uint, f uint32) uint {
+	var (
+		total  = m.blocks * m.buckets
+		offset = (m.buckets + (uint(mix64(uint64(f))) % (2 * m.buckets)) | 1) % total
+	)
+	if b%2 == 0 {
+		return (b + offset) % total
+	}
+	return (b + total - offset) % total
+}
+
+// overflowed returns whether the overflow bit of the bucket for the
+// fingerprint is set, meaning an item with the fingerprint may have moved to
+// its other bucket.
+func (m *MortonFilter) overflowed(b uint, f uint32) bool {
+	return getWordBits(m.words, m.overflowPos(b, f), 1) != 0
+}
+
+// setOverflowed sets the overflow bit of the bucket for the fingerprint.
+func (m *MortonFilter) setOverflowed(b uint, f uint32) {
+	setWordBits(m.words, m.overflowPos(b, f), 1, 1)
+}
+
+// overflowPos returns the bit position of the overflow bit of the bucket for
+// the fingerprint.
+func (m *MortonFilter) overflowPos(b uint, f uint32) uint {
+	block := b / m.buckets
+	return block*mortonBlockBits + 2*m.buckets + (b%m.buckets+uint(f))%mortonOverflowBits
+}
+
+// bucketCount returns the number of fingerprints in the bucket.
+func (m *MortonFilter) bucketCount(b uint) int {
+	block := b / m.buckets
+	return int(getWordBits(m.words, block*mortonBlockBits+2*(b%m.buckets), 2))
+}
+
+// setBucketCount sets the number of fingerprints in the bucket.
+func (m *MortonFilter) setBucketCount(b uint, count int) {
+	block := b / m.buckets
+	setWordBits(m.words, block*mortonBlockBits+2*(b%m.buckets), 2, uint32(count))
+}
+
+// bucketOffset returns the slot of the first fingerprint of the j-th bucket
+// of the block, the sum of the counts of the buckets before it, which for j
+// equal to the number of buckets is the number of slots used.
+func (m *MortonFilter) bucketOffset(block, j uint) uint {
+	var (
+		words  = m.words[block*mortonBlockWords : (block+1)*mortonBlockWords]
+		offset int
+	)
+	for w, remaining := 0, 2*j; remaining > 0; w++ {
+		x := words[w]
+		if remaining < 64 {
+			x &= 1<<remaining - 1
+			remaining = 0
+		} else {
+			remaining -= 64
+		}
+		offset += bits.OnesCount64(x&0x5555555555555555) + 2*bits.OnesCount64(x&0xaaaaaaaaaaaaaaaa)
+	}
+	return uint(offset)
+}
+
+// slotBucket returns the bucket of the block holding the used slot.
+func (m *MortonFilter) slotBucket(block, slot uint) uint {
+	var offset uint
+	for j := uint(0); ; j++ {
+		offset += uint(m.bucketCount(block*m.buckets + j))
+		if slot < offset {
+			return block*m.buckets + j
+		}
+	}
+}
+
+// slotPos returns the bit position of the fingerprint slot of the block.
+func (m *MortonFilter) slotPos(block, slot uint) uint {
+	return block*mortonBlockBits + 2*m.buckets + mortonOverflowBits + slot*m.f
+}
+
+// intn returns a random number in [0, n) from the filter's source of
+// randomness, falling back to the default source if there is none.
+func (m *MortonFilter) intn(n int) int {
+	if m.rand != nil {
+		return m.rand.Intn(n)
+	}
+	return rand.Intn(n)
+}
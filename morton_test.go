@@ -0,0 +1,150 @@
+package boom
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Ensures that every block layout fits a cache line and has fewer slots than
+// its buckets can hold.
+func TestMortonLayout(t *testing.T) {
+	for f := uint(4); f <= 32; f++ {
+		buckets, slots := mortonLayout(f)
+		if buckets%2 != 0 || 2*buckets+mortonOverflowBits+slots*f > mortonBlockBits {
+			t.Errorf("Expected %d buckets and %d %d-bit slots to fit a block", buckets, slots, f)
+		}
+		if slots >= mortonBucketCapacity*buckets {
+			t.Errorf("Expected fewer than %d slots, got %d", mortonBucketCapacity*buckets, slots)
+		}
+	}
+}
+
+// Ensures that a MortonFilter holds every added item, removes them, and stays
+// below the false-positive rate bound.
+func TestMortonFilter(t *testing.T) {
+	for _, fpRate := range []float64{0.5, 0.01, 0.0001} {
+		f := NewMortonFilter(10000, fpRate, WithSeed(3))
+		for i := 0; i < 10000; i++ {
+			if err := f.AddString(strconv.Itoa(i)); err != nil {
+				t.Fatalf("Unexpected error adding %d: %v", i, err)
+			}
+		}
+		if f.Count() != 10000 {
+			t.Errorf("Expected 10000 items, got %d", f.Count())
+		}
+
+		for i := 0; i < 10000; i++ {
+			if !f.TestString(strconv.Itoa(i)) {
+				t.Fatalf("Expected %d to be a member", i)
+			}
+		}
+
+		fp := 0
+		for i := 10000; i < 110000; i++ {
+			if f.TestString(strconv.Itoa(i)) {
+				fp++
+			}
+		}
+		bound := MortonFPRate(f.FingerprintBits())
+		if rate := float64(fp) / 100000; rate > bound {
+			t.Errorf("Expected false-positive rate below %f, got %f", bound, rate)
+		}
+
+		for i := 0; i < 5000; i++ {
+			if !f.TestAndRemove([]byte(strconv.Itoa(i))) {
+				t.Fatalf("Expected %d to be removed", i)
+			}
+		}
+		for i := 5000; i < 10000; i++ {
+			if !f.TestString(strconv.Itoa(i)) {
+				t.Fatalf("Expected %d to remain a member", i)
+			}
+		}
+		if f.Count() != 5000 {
+			t.Errorf("Expected 5000 items, got %d", f.Count())
+		}
+
+		f.Reset()
+		if f.Count() != 0 || f.TestString("5000") {
+			t.Error("Expected empty filter after reset")
+		}
+	}
+}
+
+// Ensures that an unseeded MortonFilter fills to its capacity.
+func TestMortonFilterCapacity(t *testing.T) {
+	f := NewMortonFilter(100000, 0.001)
+	for i := 0; i < 100000; i++ {
+		if err := f.AddString(strconv.Itoa(i)); err != nil {
+			t.Fatalf("Unexpected error adding %d: %v", i, err)
+		}
+	}
+}
+
+// Ensures that TestMany's batched lookups match Test.
+func TestMortonFilterTestMany(t *testing.T) {
+	f := NewMortonFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	data := make([][]byte, 3000)
+	for i := range data {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	for i, member := range f.TestMany(data) {
+		if member != f.Test(data[i]) {
+			t.Errorf("Expected %t for %s, got %t", f.Test(data[i]), data[i], member)
+		}
+		if i < 1000 && !member {
+			t.Errorf("Expected %s to be a member", data[i])
+		}
+	}
+}
+
+// Ensures that MarshalBinary and UnmarshalBinary round-trip the filter.
+func TestMortonFilterReadWrite(t *testing.T) {
+	f := NewMortonFilter(1000, 0.01)
+	for i := 0; i < 800; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restored := &MortonFilter{}
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if restored.Count() != f.Count() || restored.Blocks() != f.Blocks() || restored.FingerprintBits() != f.FingerprintBits() {
+		t.Errorf("Expected %d items, %d blocks, and %d bits, got %d, %d, and %d",
+			f.Count(), f.Blocks(), f.FingerprintBits(), restored.Count(), restored.Blocks(), restored.FingerprintBits())
+	}
+	for i := 0; i < 2000; i++ {
+		data := []byte(strconv.Itoa(i))
+		if restored.Test(data) != f.Test(data) {
+			t.Errorf("Expected %t for %s, got %t", f.Test(data), data, restored.Test(data))
+		}
+	}
+
+	if err := restored.UnmarshalBinary(data[:len(data)-8]); err == nil {
+		t.Error("Expected error for truncated data")
+	}
+}
+
+func BenchmarkMortonFilterTest(b *testing.B) {
+	f := NewMortonFilter(100000, 0.001)
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+		if i < 90000 {
+			f.Add(data[i])
+		}
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		f.Test(data[n])
+	}
+}
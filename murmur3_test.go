@@ -0,0 +1,21 @@
+package boom
+
+import "testing"
+
+// Ensures that murmur3Sum128 matches the reference implementation.
+func TestMurmur3Sum128(t *testing.T) {
+	tests := []struct {
+		data   string
+		h1, h2 uint64
+	}{
+		{``, 0, 0},
+		{`hello`, 0xcbd8a7b341bd9b02, 0x5b1e906a48ae1d19},
+		{`The quick brown fox jumps over the lazy dog`, 0xe34bbc7bbc071b6c, 0x7a433ca9c49a9347},
+	}
+
+	for _, test := range tests {
+		if h1, h2 := murmur3Sum128([]byte(test.data), 0); h1 != test.h1 || h2 != test.h2 {
+			t.Errorf("Expected %x%x for %q, got %x%x", test.h1, test.h2, test.data, h1, h2)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package boom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"math/bits"
+	"math/rand"
+)
+
+// Option configures a data structure when it's created.
+type Option func(*options)
+
+// options holds the configuration set by Options.
+type options struct {
+	hash64  func() hash.Hash64 // creates 64-bit hash functions
+	hash32  func() hash.Hash32 // creates 32-bit hash functions
+	seed    uint64             // hash seed and seed of sources of randomness
+	seeded  bool               // whether a seed was set
+	hint    uint               // expected number of items, if set
+	fpRate  float64            // target false-positive rate, if set
+	r       float64            // tightening ratio, if set
+	buckets BucketsFactory     // creates the filter's buckets, if set
+	stash   uint               // maximum number of items in a CuckooFilter's stash
+
+	saturation SaturationPolicy // policy for saturated CountingBloomFilter buckets
+	powerOfTwo bool             // round bit arrays up to a power of two
+
+	maxFilters uint           // most filters in a ScalableBloomFilter's series
+	maxBytes   uint64         // most bytes of a ScalableBloomFilter's filters
+	overflow   OverflowPolicy // policy for a bounded ScalableBloomFilter
+	growth     uint           // growth factor of a ScalableBloomFilter's filters
+	schedule   []float64      // tightening ratios of a ScalableBloomFilter's first filters
+
+	transformer Transformer // normalizes keys before they're hashed
+	modulo      bool        // reduce hashes to indices with a modulo
+}
+
+// WithHasher sets the function creating the 64-bit hash function used by data
+// structures which hash data to 64 bits, such as murmur3 or xxhash in place of
+// the default FNV-1. It's equivalent to calling SetHash after creation, except
+// the hash function is in place before anything is allocated. Structures
+// hashing to 32 bits ignore it.
+func WithHasher(newHash func() hash.Hash64) Option {
+	return func(o *options) {
+		o.hash64 = newHash
+	}
+}
+
+// WithHasher32 sets the function creating the 32-bit hash function used by
+// data structures which hash data to 32 bits, such as CuckooFilter,
+// InverseBloomFilter, and HyperLogLog, and by AdaptiveCuckooFilter for
+// fingerprints. Structures hashing to 64 bits ignore it.
+func WithHasher32(newHash func() hash.Hash32) Option {
+	return func(o *options) {
+		o.hash32 = newHash
+	}
+}
+
+// WithSeed sets an explicit seed making a filter deterministic, so two
+// processes creating a filter with the same seed and parameters and adding the
+// same data produce bit-identical filters. The seed is mixed into the hash of
+// every item, except by compatibility filters such as NewGuavaBloomFilter
+// whose hashing is fixed, and seeds the filter's source of randomness, such as
+// for the cells a StableBloomFilter decrements or the entries a CuckooFilter
+// evicts. Keyed filters derive their key from the seed, which must then be
+// kept as secret as the key. Filters must use the same seed to be merged.
+func WithSeed(seed uint64) Option {
+	return func(o *options) {
+		o.seed = seed
+		o.seeded = true
+	}
+}
+
+// WithHint sets the number of items a filter created by a constructor such as
+// NewBloomFilterWithOptions is optimized to store, or the size of each filter
+// in the series of a Scalable Bloom Filter. It defaults to 10000. Constructors
+// taking the number of items as an argument ignore it.
+func WithHint(n uint) Option {
+	return func(o *options) {
+		o.hint = n
+	}
+}
+
+// WithFPRate sets the target false-positive rate of a filter created by a
+// constructor such as NewBloomFilterWithOptions. It defaults to 0.01.
+// Constructors taking the rate as an argument ignore it.
+func WithFPRate(fpRate float64) Option {
+	return func(o *options) {
+		o.fpRate = fpRate
+	}
+}
+
+// WithGrowthRatio sets the tightening ratio, r, by which the false-positive
+// rate of each filter added to a Scalable Bloom Filter created by
+// NewScalableBloomFilterWithOptions decreases. It defaults to 0.8.
+// Constructors taking the ratio as an argument ignore it.
+func WithGrowthRatio(r float64) Option {
+	return func(o *options) {
+		o.r = r
+	}
+}
+
+// WithBucketsBackend sets the factory creating the buckets of a filter created
+// by a constructor such as NewBloomFilterWithOptions, such as one returned by
+// MmapBucketsFactory, like the constructors taking a BucketsFactory as an
+// argument. Other constructors ignore it.
+func WithBucketsBackend(newBuckets BucketsFactory) Option {
+	return func(o *options) {
+		o.buckets = newBuckets
+	}
+}
+
+// WithStash gives a CuckooFilter a stash holding up to n items which don't fit
+// in their buckets once no item can be relocated, so insertions near capacity
+// succeed instead of removing an item until the stash is full. The stash is
+// checked by every lookup, so it should be small, such as 4. Other structures
+// ignore it.
+func WithStash(n uint) Option {
+	return func(o *options) {
+		o.stash = n
+	}
+}
+
+// WithSaturation sets the policy of a CountingBloomFilter for additions to
+// buckets which already hold the maximum bucket value. It defaults to
+// SaturateClamp. Other structures ignore it.
+func WithSaturation(policy SaturationPolicy) Option {
+	return func(o *options) {
+		o.saturation = policy
+	}
+}
+
+// WithPowerOfTwoSize rounds the bit array of a BloomFilter, or each partition
+// of a PartitionedBloomFilter, up to a power of two bits, so indices are
+// reduced with a bitmask instead of an integer division on every probe. Either
+// filter only divides once created WithModuloReduction, since it reduces with
+// a multiply-shift by default. This takes up to twice the memory, which lowers
+// the false-positive rate below the target. Other structures ignore it.
+func WithPowerOfTwoSize() Option {
+	return func(o *options) {
+		o.powerOfTwo = true
+	}
+}
+
+// WithMaxFilters bounds the series of a ScalableBloomFilter to n filters, so
+// once its last filter is full the overflow policy set with WithOverflow
+// applies instead of adding another. Other structures ignore it.
+func WithMaxFilters(n uint) Option {
+	return func(o *options) {
+		o.maxFilters = n
+	}
+}
+
+// WithMaxBytes bounds the bit arrays of the filters of a ScalableBloomFilter
+// to n bytes, as estimated by ScalableMemory, so once its last filter is full
+// the overflow policy set with WithOverflow applies instead of adding a filter
+// which would exceed the bound. The first filter is always added, even if it
+// exceeds the bound. Other structures ignore it.
+func WithMaxBytes(n uint64) Option {
+	return func(o *options) {
+		o.maxBytes = n
+	}
+}
+
+// WithOverflow sets the policy of a ScalableBloomFilter bounded by
+// WithMaxFilters or WithMaxBytes for additions once the bound is reached. It
+// defaults to OverflowDegrade. Other structures ignore it.
+func WithOverflow(policy OverflowPolicy) Option {
+	return func(o *options) {
+		o.overflow = policy
+	}
+}
+
+// WithGrowthFactor sizes each filter added to a ScalableBloomFilter for g times
+// the items of the filter before it, the geometric growth of the Almeida
+// paper, so a set growing well past the size hint takes logarithmically rather
+// than linearly many filters, and the false-positive rate tightens fewer
+// times. The paper suggests 2 for sets growing slowly and 4 for sets growing
+// quickly, with a tightening ratio between 0.8 and 0.9. It defaults to 1,
+// sizing every filter for the hint. Other structures ignore it.
+func WithGrowthFactor(g uint) Option {
+	return func(o *options) {
+		o.growth = g
+	}
+}
+
+// WithTighteningSchedule sets the ratios by which the false-positive rate of
+// each of the first filters added to a ScalableBloomFilter decreases: the
+// second filter's rate is the first's times ratios[0], the third's the
+// second's times ratios[1], and so on, after which the tightening ratio
+// applies. Ratios close to one keep the first filters from tightening while a
+// set is still near its size hint. Every ratio must be in (0, 1), which
+// NewScalableBloomFilterWithOptions checks. Other structures ignore it.
+func WithTighteningSchedule(ratios ...float64) Option {
+	return func(o *options) {
+		o.schedule = append([]float64(nil), ratios...)
+	}
+}
+
+// WithTransformer sets the Transformer normalizing every key added to or tested
+// against a BloomFilter, ScalableBloomFilter, or ShardedScalableBloomFilter
+// before it's hashed. Its name is recorded by WriteTo, and ReadFrom returns
+// ErrTransformerMismatch unless the filter read into was created with a
+// transformer of the same name. Other structures ignore it.
+func WithTransformer(t Transformer) Option {
+	return func(o *options) {
+		o.transformer = t
+	}
+}
+
+// WithModuloReduction makes a BloomFilter, AtomicBloomFilter,
+// CountingBloomFilter, StableBloomFilter, CountMinSketch,
+// PartitionedBloomFilter, ScalableBloomFilter, DeletableBloomFilter,
+// SpectralBloomFilter, ShiftingBloomFilter, ExpiringBloomFilter, or
+// AgePartitionedBloomFilter reduce each hash to an index with a modulo, like
+// earlier versions of the package, rather than with the faster multiply-shift
+// used by default, which derives different indices from the same hash. This is
+// needed for a filter which is read by an earlier version, since it would
+// ignore how the filter reduces its hashes, or which reopens Buckets written by
+// one. Filters read by ReadFrom reduce their hashes like the filter written.
+// Other structures ignore it.
+func WithModuloReduction() Option {
+	return func(o *options) {
+		o.modulo = true
+	}
+}
+
+// applyOptions returns the configuration set by the options.
+func applyOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// size returns the number of bits of a bit array of m bits, rounded up to a
+// power of two if set by WithPowerOfTwoSize.
+func (o options) size(m uint) uint {
+	if !o.powerOfTwo || m&(m-1) == 0 {
+		return m
+	}
+	return 1 << uint(bits.Len(m))
+}
+
+// partitions returns the total size, number of partitions, and partition size
+// of a PartitionedBloomFilter optimized to store n items with the target
+// false-positive rate, whose partitions are rounded up to a power of two if
+// set by WithPowerOfTwoSize.
+func (o options) partitions(n uint, fpRate float64) (uint, uint, uint) {
+	k, s := OptimalPartitions(n, fpRate)
+	if !o.powerOfTwo {
+		return OptimalM(n, fpRate), k, s
+	}
+	s = o.size(s)
+	return k * s, k, s
+}
+
+// sizing returns the number of items and the target false-positive rate set by
+// WithHint and WithFPRate, or their defaults. Returns an error if either is
+// out of range.
+func (o options) sizing() (uint, float64, error) {
+	var (
+		n      = o.hint
+		fpRate = o.fpRate
+	)
+	if n == 0 {
+		n = 10000
+	}
+	if fpRate == 0 {
+		fpRate = 0.01
+	}
+
+	if fpRate < 0 || fpRate >= 1 {
+		return 0, 0, errors.New("false-positive rate must be in (0, 1)")
+	}
+	return n, fpRate, nil
+}
+
+// newHash64 returns a hash function created by the function set with
+// WithHasher, or by def if none was set.
+func (o options) newHash64(def func() hash.Hash64) hash.Hash64 {
+	if o.hash64 != nil {
+		return o.hash64()
+	}
+	return def()
+}
+
+// newHash32 returns a hash function created by the function set with
+// WithHasher32, or by def if none was set.
+func (o options) newHash32(def func() hash.Hash32) hash.Hash32 {
+	if o.hash32 != nil {
+		return o.hash32()
+	}
+	return def()
+}
+
+// rand returns a source of randomness seeded by WithSeed, or nil if no seed
+// was set, in which case the default source should be used.
+func (o options) rand() *rand.Rand {
+	if !o.seeded {
+		return nil
+	}
+	return rand.New(o.source())
+}
+
+// source returns a splitMix64 seeded by WithSeed, or from the default source if
+// no seed was set.
+func (o options) source() *splitMix64 {
+	if !o.seeded {
+		return newSplitMix64()
+	}
+	return &splitMix64{state: o.seed}
+}
+
+// sipKey returns a SipHash key derived from the seed set by WithSeed, or a
+// random key if no seed was set.
+func (o options) sipKey() sipKey {
+	if !o.seeded {
+		return newSipKey()
+	}
+
+	var (
+		key    sipKey
+		source = o.source()
+	)
+	binary.LittleEndian.PutUint64(key[:8], source.Uint64())
+	binary.LittleEndian.PutUint64(key[8:], source.Uint64())
+	return key
+}
@@ -0,0 +1,379 @@
+package boom
+
+import (
+	"bytes"
+	"hash"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Ensures that WithHasher sets the hash function of structures hashing to 64
+// bits, which then hash like a structure given the same function by SetHash.
+func TestWithHasher(t *testing.T) {
+	var (
+		created = 0
+		newHash = func() hash.Hash64 {
+			created++
+			return fnv.New64a()
+		}
+		f = NewBloomFilter(100, 0.01, WithHasher(newHash))
+		g = NewBloomFilter(100, 0.01)
+	)
+	g.SetHash(fnv.New64a())
+
+	f.Add([]byte(`a`))
+	g.Add([]byte(`a`))
+	for i := uint(0); i < f.Capacity(); i++ {
+		if f.buckets.Get(i) != g.buckets.Get(i) {
+			t.Fatalf("Expected bit %d to match", i)
+		}
+	}
+
+	s := NewScalableBloomFilter(100, 0.01, 0.8, WithHasher(newHash))
+	if s.hash == nil || s.filters[0].hash != s.hash {
+		t.Error("Expected scalable filter to use the hash function")
+	}
+
+	a := NewAtomicBloomFilter(100, 0.01, WithHasher(newHash))
+	before := created
+	a.Add([]byte(`a`))
+	if !a.Test([]byte(`a`)) {
+		t.Error("`a` should be a member")
+	}
+	if created != before+2 {
+		t.Errorf("Expected a hash function per operation, got %d", created-before)
+	}
+
+	// Structures hashing to 32 bits ignore it.
+	if c := NewCuckooFilter(100, 0.01, WithHasher(newHash)); c.hash == nil {
+		t.Error("Expected default hash function")
+	}
+}
+
+// Ensures that WithHasher32 sets the hash function of structures hashing to 32
+// bits.
+func TestWithHasher32(t *testing.T) {
+	c := NewCuckooFilter(100, 0.01, WithHasher32(fnv.New32a))
+	c.Add([]byte(`a`))
+	if !c.Test([]byte(`a`)) {
+		t.Error("`a` should be a member")
+	}
+
+	h, err := NewHyperLogLog(16, WithHasher32(fnv.New32a))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	h.hash.Write([]byte(`a`))
+	if sum, expected := h.hash.Sum32(), fnv32a(`a`); sum != expected {
+		t.Errorf("Expected FNV-1a sum %d, got %d", expected, sum)
+	}
+}
+
+func fnv32a(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// Ensures that filters created with the same seed and fed the same data are
+// bit-identical, including the decrements of a Stable Bloom Filter and the
+// relocations of a full Cuckoo Filter, while another seed hashes differently.
+func TestWithSeed(t *testing.T) {
+	filters := map[string]func(uint64) (func([]byte), io.WriterTo){
+		"bloom": func(seed uint64) (func([]byte), io.WriterTo) {
+			f := NewBloomFilter(100, 0.01, WithSeed(seed))
+			return func(d []byte) { f.Add(d) }, f
+		},
+		"partitioned": func(seed uint64) (func([]byte), io.WriterTo) {
+			f := NewPartitionedBloomFilter(100, 0.01, WithSeed(seed))
+			return func(d []byte) { f.Add(d) }, f
+		},
+		"scalable": func(seed uint64) (func([]byte), io.WriterTo) {
+			f := NewScalableBloomFilter(10, 0.01, 0.8, WithSeed(seed))
+			return func(d []byte) { f.Add(d) }, f
+		},
+		"counting": func(seed uint64) (func([]byte), io.WriterTo) {
+			f := NewDefaultCountingBloomFilter(100, 0.01, WithSeed(seed))
+			return func(d []byte) { f.Add(d) }, f
+		},
+		"stable": func(seed uint64) (func([]byte), io.WriterTo) {
+			f := NewDefaultStableBloomFilter(1000, 0.01, WithSeed(seed))
+			return func(d []byte) { f.Add(d) }, f
+		},
+		"inverse": func(seed uint64) (func([]byte), io.WriterTo) {
+			f := NewInverseBloomFilter(100, WithSeed(seed))
+			return func(d []byte) { f.Add(d) }, f
+		},
+		"keyed": func(seed uint64) (func([]byte), io.WriterTo) {
+			f := NewKeyedBloomFilter(100, 0.01, WithSeed(seed))
+			return func(d []byte) { f.Add(d) }, f
+		},
+		"cuckoo": func(seed uint64) (func([]byte), io.WriterTo) {
+			f := NewCuckooFilter(10, 0.1, WithSeed(seed))
+			return func(d []byte) { f.Add(d) }, f
+		},
+		"adaptive": func(seed uint64) (func([]byte), io.WriterTo) {
+			f := NewAdaptiveCuckooFilter(10, 0.1, WithSeed(seed))
+			return func(d []byte) { f.Add(d) }, f
+		},
+	}
+
+	dump := func(newFilter func(uint64) (func([]byte), io.WriterTo), seed uint64) []byte {
+		add, f := newFilter(seed)
+		for i := 0; i < 200; i++ {
+			add([]byte(strconv.Itoa(i)))
+		}
+
+		var buf bytes.Buffer
+		if _, err := f.WriteTo(&buf); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	for name, newFilter := range filters {
+		a := dump(newFilter, 42)
+		if !bytes.Equal(a, dump(newFilter, 42)) {
+			t.Errorf("Expected %s filters with the same seed to match", name)
+		}
+		if bytes.Equal(a, dump(newFilter, 43)) {
+			t.Errorf("Expected %s filters with different seeds to differ", name)
+		}
+	}
+
+	f := NewAtomicBloomFilter(100, 0.01, WithSeed(42))
+	g := NewBloomFilter(100, 0.01, WithSeed(42))
+	f.Add([]byte(`a`))
+	g.Add([]byte(`a`))
+	for i := uint(0); i < f.Capacity(); i++ {
+		if f.loadBuckets().Get(i) != g.buckets.Get(i) {
+			t.Fatalf("Expected bit %d to match", i)
+		}
+	}
+}
+
+// Ensures that the seed of a filter is restored by ReadFrom.
+func TestWithSeedReadFrom(t *testing.T) {
+	var (
+		f   = NewPartitionedBloomFilter(100, 0.01, WithSeed(42))
+		buf bytes.Buffer
+	)
+	f.Add([]byte(`a`))
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	restored := NewPartitionedBloomFilter(100, 0.01)
+	if _, err := restored.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if restored.seed != 42 {
+		t.Errorf("Expected seed 42, got %d", restored.seed)
+	}
+
+	if !restored.Test([]byte(`a`)) {
+		t.Error("`a` should be a member")
+	}
+}
+
+// Ensures that structures created WithModuloReduction derive their indices
+// with a modulo, keep doing so once read by ReadFrom, and can't be merged with
+// structures reducing with a multiply-shift.
+func TestWithModuloReduction(t *testing.T) {
+	var (
+		f   = NewBloomFilter(1000, 0.01, WithModuloReduction())
+		buf bytes.Buffer
+	)
+	f.Add([]byte(`a`))
+	lower, upper := f.hashKernel([]byte(`a`))
+	for i := uint(0); i < f.k; i++ {
+		if idx := f.index(lower, upper, i); idx != (uint(lower)+uint(upper)*i)%f.m {
+			t.Errorf("Expected the modulo index, got %d", idx)
+		}
+	}
+
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restored := NewBloomFilter(1000, 0.01)
+	if _, err := restored.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !restored.Equal(f) || !restored.Test([]byte(`a`)) {
+		t.Error("Expected the modulo reduction to be restored")
+	}
+
+	if err := NewBloomFilter(1000, 0.01).Union(f); err == nil {
+		t.Error("Expected error for mismatched index reduction")
+	}
+
+	cms := NewCountMinSketch(0.001, 0.99, WithModuloReduction())
+	if err := NewCountMinSketch(0.001, 0.99).Merge(cms); err == nil {
+		t.Error("Expected error for mismatched index reduction")
+	}
+
+	s := NewStableBloomFilter(1000, 3, 0.01, WithModuloReduction())
+	s.Add([]byte(`a`))
+	buf.Reset()
+	if _, err := s.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restoredStable := NewStableBloomFilter(1000, 3, 0.01)
+	if _, err := restoredStable.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !restoredStable.Equal(s) || !restoredStable.Test([]byte(`a`)) {
+		t.Error("Expected the modulo reduction to be restored")
+	}
+}
+
+// Ensures that the other filters hashing with sizedHashKernel reduce with a
+// multiply-shift unless created WithModuloReduction, and restore the
+// reduction they were written with.
+func TestWithModuloReductionFilters(t *testing.T) {
+	now := time.Now()
+	for _, newFilter := range []func(...Option) (Filter, func(Filter) bool){
+		func(opts ...Option) (Filter, func(Filter) bool) {
+			f := NewPartitionedBloomFilter(1000, 0.01, opts...)
+			return f, func(o Filter) bool { return f.Equal(o.(*PartitionedBloomFilter)) }
+		},
+		func(opts ...Option) (Filter, func(Filter) bool) {
+			f := NewScalableBloomFilter(100, 0.01, 0.8, opts...)
+			return f, func(o Filter) bool { return f.Equal(o.(*ScalableBloomFilter)) }
+		},
+		func(opts ...Option) (Filter, func(Filter) bool) {
+			f := NewDeletableBloomFilter(1000, 10, 0.01, opts...)
+			return f, func(o Filter) bool { return f.Equal(o.(*DeletableBloomFilter)) }
+		},
+		func(opts ...Option) (Filter, func(Filter) bool) {
+			f := NewSpectralBloomFilter(1000, 4, 0.01, opts...)
+			return f, func(o Filter) bool { return f.Equal(o.(*SpectralBloomFilter)) }
+		},
+		func(opts ...Option) (Filter, func(Filter) bool) {
+			f := NewAgePartitionedBloomFilter(1000, 2, 0.01, opts...)
+			return f, func(o Filter) bool { return f.Equal(o.(*AgePartitionedBloomFilter)) }
+		},
+	} {
+		modulo, equalModulo := newFilter(WithModuloReduction())
+		fast, _ := newFilter()
+		for i := 0; i < 300; i++ {
+			modulo.Add([]byte(strconv.Itoa(i)))
+			fast.Add([]byte(strconv.Itoa(i)))
+		}
+		if equalModulo(fast) {
+			t.Errorf("Expected %T to reduce differently by default", fast)
+		}
+
+		var buf bytes.Buffer
+		if _, err := modulo.(io.WriterTo).WriteTo(&buf); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		restored, _ := newFilter()
+		if _, err := restored.(io.ReaderFrom).ReadFrom(&buf); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !equalModulo(restored) || !restored.Test([]byte(`0`)) {
+			t.Errorf("Expected %T to restore the modulo reduction", modulo)
+		}
+	}
+
+	shifting := NewShiftingBloomFilter(1000, 0.01, WithModuloReduction())
+	shifting.Add([]byte(`a`), ShiftingSetA)
+	data, err := shifting.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var restoredShifting ShiftingBloomFilter
+	if err := restoredShifting.UnmarshalBinary(data); err != nil || !restoredShifting.Equal(shifting) {
+		t.Errorf("Expected the modulo reduction to be restored, got %v", err)
+	}
+
+	expiring := NewExpiringBloomFilter(1000, 0.01, time.Minute, WithModuloReduction())
+	expiring.Add([]byte(`a`), now)
+	if data, err = expiring.MarshalBinary(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var restoredExpiring ExpiringBloomFilter
+	if err := restoredExpiring.UnmarshalBinary(data); err != nil || !restoredExpiring.modulo ||
+		!restoredExpiring.Test([]byte(`a`), now) {
+		t.Errorf("Expected the modulo reduction to be restored, got %v", err)
+	}
+
+	p := NewPartitionedBloomFilter(1000, 0.01, WithModuloReduction())
+	if err := NewPartitionedBloomFilter(1000, 0.01).Union(p); err == nil {
+		t.Error("Expected error for mismatched index reduction")
+	}
+}
+
+// Ensures that the constructors configured by options create the same filters
+// as the positional constructors and validate the settings.
+func TestWithOptions(t *testing.T) {
+	b, err := NewBloomFilterWithOptions(WithHint(100), WithFPRate(0.1), WithSeed(1))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !b.Equal(NewBloomFilter(100, 0.1, WithSeed(1))) {
+		t.Error("Expected filter to match NewBloomFilter")
+	}
+
+	b, err = NewBloomFilterWithOptions()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !b.Equal(NewBloomFilter(10000, 0.01)) {
+		t.Error("Expected filter to use the default settings")
+	}
+
+	p, err := NewPartitionedBloomFilterWithOptions(WithHint(100))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !p.Equal(NewPartitionedBloomFilter(100, 0.01)) {
+		t.Error("Expected filter to match NewPartitionedBloomFilter")
+	}
+
+	c, err := NewCountingBloomFilterWithOptions(WithHint(100), WithFPRate(0.05))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !c.Equal(NewDefaultCountingBloomFilter(100, 0.05)) {
+		t.Error("Expected filter to match NewDefaultCountingBloomFilter")
+	}
+
+	s, err := NewScalableBloomFilterWithOptions(WithHint(10), WithFPRate(0.1), WithGrowthRatio(0.5))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !s.Equal(NewScalableBloomFilter(10, 0.1, 0.5)) {
+		t.Error("Expected filter to match NewScalableBloomFilter")
+	}
+
+	dir := t.TempDir()
+	s, err = NewScalableBloomFilterWithOptions(WithHint(10), WithBucketsBackend(MmapBucketsFactory(dir)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	s.Add([]byte(`a`))
+	if err := s.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	s, err = NewScalableBloomFilterWithBuckets(10, 0.01, 0.8, MmapBucketsFactory(dir))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !s.Test([]byte(`a`)) {
+		t.Error("Expected `a` to be reopened from the backend")
+	}
+	s.Close()
+
+	if _, err := NewBloomFilterWithOptions(WithFPRate(1)); err == nil {
+		t.Error("Expected error for out of range false-positive rate")
+	}
+	if _, err := NewScalableBloomFilterWithOptions(WithGrowthRatio(1.5)); err == nil {
+		t.Error("Expected error for out of range tightening ratio")
+	}
+}
@@ -16,9 +16,14 @@ copies or substantial portions of the Software.
 package boom
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"hash"
-	"hash/fnv"
+	"io"
 	"math"
+	"sync"
+	"unsafe"
 )
 
 // PartitionedBloomFilter implements a variation of a classic Bloom filter as
@@ -38,16 +43,17 @@ type PartitionedBloomFilter struct {
 	k          uint        // number of hash functions (and partitions)
 	s          uint        // partition size (m / k)
 	count      uint        // number of items added
+	seed       uint64      // hash seed (zero means unseeded)
+	modulo     bool        // reduce hashes to indices with a modulo
 }
 
 // NewPartitionedBloomFilter creates a new partitioned Bloom filter optimized
 // to store n items with a specified target false-positive rate.
-func NewPartitionedBloomFilter(n uint, fpRate float64) *PartitionedBloomFilter {
+func NewPartitionedBloomFilter(n uint, fpRate float64, opts ...Option) *PartitionedBloomFilter {
 	var (
-		m          = OptimalM(n, fpRate)
-		k          = OptimalK(fpRate)
+		o          = applyOptions(opts)
+		m, k, s    = o.partitions(n, fpRate)
 		partitions = make([]*Buckets, k)
-		s          = uint(math.Ceil(float64(m) / float64(k)))
 	)
 
 	for i := uint(0); i < k; i++ {
@@ -56,13 +62,80 @@ func NewPartitionedBloomFilter(n uint, fpRate float64) *PartitionedBloomFilter {
 
 	return &PartitionedBloomFilter{
 		partitions: partitions,
-		hash:       fnv.New64(),
+		hash:       o.newHash64(newFNV64),
 		m:          m,
 		k:          k,
 		s:          s,
+		seed:       o.seed,
+		modulo:     o.modulo,
 	}
 }
 
+// NewPartitionedBloomFilterWithOptions creates a new partitioned Bloom filter
+// configured entirely by options, like NewBloomFilterWithOptions. Returns an
+// error if a setting is out of range or the factory set with
+// WithBucketsBackend fails.
+func NewPartitionedBloomFilterWithOptions(opts ...Option) (*PartitionedBloomFilter, error) {
+	o := applyOptions(opts)
+	n, fpRate, err := o.sizing()
+	if err != nil {
+		return nil, err
+	}
+
+	if o.buckets != nil {
+		return NewPartitionedBloomFilterWithBuckets(n, fpRate, o.buckets, opts...)
+	}
+	return NewPartitionedBloomFilter(n, fpRate, opts...), nil
+}
+
+// NewPartitionedBloomFilterWithBuckets creates a new partitioned Bloom filter
+// like NewPartitionedBloomFilter whose partitions are created by the factory,
+// such as one returned by MmapBucketsFactory. If the factory reopens existing
+// data, the number of items added is estimated from the set bits. Returns an
+// error if the factory fails.
+func NewPartitionedBloomFilterWithBuckets(n uint, fpRate float64, newBuckets BucketsFactory, opts ...Option) (*PartitionedBloomFilter, error) {
+	return newPartitionedBloomFilterWithBuckets(n, fpRate, newBuckets, 0, opts...)
+}
+
+// newPartitionedBloomFilterWithBuckets creates a partitioned Bloom filter whose
+// partitions are created by the factory, starting at the given index.
+func newPartitionedBloomFilterWithBuckets(n uint, fpRate float64, newBuckets BucketsFactory, index int, opts ...Option) (*PartitionedBloomFilter, error) {
+	var (
+		o          = applyOptions(opts)
+		m, k, s    = o.partitions(n, fpRate)
+		partitions = make([]*Buckets, k)
+		reopened   = true
+		ones       uint
+	)
+
+	for i := range partitions {
+		buckets, err := newFactoryBuckets(newBuckets, index+i, s, 1)
+		if err != nil {
+			for _, partition := range partitions[:i] {
+				partition.Close()
+			}
+			return nil, err
+		}
+		partitions[i] = buckets
+		reopened = reopened && buckets.reopened()
+		ones += buckets.ones()
+	}
+
+	p := &PartitionedBloomFilter{
+		partitions: partitions,
+		hash:       o.newHash64(newFNV64),
+		m:          m,
+		k:          k,
+		s:          s,
+		seed:       o.seed,
+		modulo:     o.modulo,
+	}
+	if reopened {
+		p.count = uint(estimateCardinality(s, 1, ones/k) + 0.5)
+	}
+	return p, nil
+}
+
 // Capacity returns the Bloom filter capacity, m.
 func (p *PartitionedBloomFilter) Capacity() uint {
 	return p.m
@@ -87,26 +160,46 @@ func (p *PartitionedBloomFilter) EstimatedFillRatio() float64 {
 func (p *PartitionedBloomFilter) FillRatio() float64 {
 	t := float64(0)
 	for i := uint(0); i < p.k; i++ {
-		sum := uint32(0)
-		for j := uint(0); j < p.partitions[i].Count(); j++ {
-			sum += p.partitions[i].Get(j)
-		}
-		t += (float64(sum) / float64(p.s))
+		t += (float64(p.partitions[i].ones()) / float64(p.s))
 	}
 	return t / float64(p.k)
 }
 
+// ApproximatedSize returns the number of distinct items added to the filter
+// estimated from the average number of set bits per partition, X, as
+// -s * ln(1 - X/s) for a partition size s. Unlike Count, it doesn't count
+// repeated additions and remains accurate for filters which were merged or
+// restored.
+func (p *PartitionedBloomFilter) ApproximatedSize() uint {
+	var set uint
+	for _, partition := range p.partitions {
+		set += partition.ones()
+	}
+	return uint(estimateCardinality(p.s, 1, set/p.k) + 0.5)
+}
+
+// EstimatedFPRate returns the current probability of a false positive derived
+// from the ratio of set bits in each partition, the probability that the bit
+// of an absent item is set in every partition.
+func (p *PartitionedBloomFilter) EstimatedFPRate() float64 {
+	rate := 1.0
+	for _, partition := range p.partitions {
+		rate *= float64(partition.ones()) / float64(p.s)
+	}
+	return rate
+}
+
 // Test will test for membership of the data and returns true if it is a
 // member, false if not. This is a probabilistic test, meaning there is a
 // non-zero probability of false positives but a zero probability of false
 // negatives. Due to the way the filter is partitioned, the probability of
 // false positives is uniformly distributed across all elements.
 func (p *PartitionedBloomFilter) Test(data []byte) bool {
-	lower, upper := hashKernel(data, p.hash)
+	lower, upper := sizedHashKernel(data, p.hash, p.seed, p.s)
 
 	// If any of the K partition bits are not set, then it's not a member.
 	for i := uint(0); i < p.k; i++ {
-		if p.partitions[i].Get((uint(lower)+uint(upper)*i)%p.s) == 0 {
+		if p.partitions[i].Get(reducedIndex(lower, upper, i, p.s, p.modulo)) == 0 {
 			return false
 		}
 	}
@@ -117,11 +210,11 @@ func (p *PartitionedBloomFilter) Test(data []byte) bool {
 // Add will add the data to the Bloom filter. It returns the filter to allow
 // for chaining.
 func (p *PartitionedBloomFilter) Add(data []byte) Filter {
-	lower, upper := hashKernel(data, p.hash)
+	lower, upper := sizedHashKernel(data, p.hash, p.seed, p.s)
 
 	// Set the K partition bits.
 	for i := uint(0); i < p.k; i++ {
-		p.partitions[i].Set((uint(lower)+uint(upper)*i)%p.s, 1)
+		p.partitions[i].Set(reducedIndex(lower, upper, i, p.s, p.modulo), 1)
 	}
 
 	p.count++
@@ -131,12 +224,12 @@ func (p *PartitionedBloomFilter) Add(data []byte) Filter {
 // TestAndAdd is equivalent to calling Test followed by Add. It returns true if
 // the data is a member, false if not.
 func (p *PartitionedBloomFilter) TestAndAdd(data []byte) bool {
-	lower, upper := hashKernel(data, p.hash)
+	lower, upper := sizedHashKernel(data, p.hash, p.seed, p.s)
 	member := true
 
 	// If any of the K partition bits are not set, then it's not a member.
 	for i := uint(0); i < p.k; i++ {
-		idx := (uint(lower) + uint(upper)*i) % p.s
+		idx := reducedIndex(lower, upper, i, p.s, p.modulo)
 		if p.partitions[i].Get(idx) == 0 {
 			member = false
 		}
@@ -147,6 +240,49 @@ func (p *PartitionedBloomFilter) TestAndAdd(data []byte) bool {
 	return member
 }
 
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (p *PartitionedBloomFilter) TestString(data string) bool {
+	return p.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (p *PartitionedBloomFilter) AddString(data string) Filter {
+	return p.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (p *PartitionedBloomFilter) TestAndAddString(data string) bool {
+	return p.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter. It returns the filter to allow
+// for chaining.
+func (p *PartitionedBloomFilter) AddMany(data [][]byte) Filter {
+	for _, d := range data {
+		p.Add(d)
+	}
+	return p
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+func (p *PartitionedBloomFilter) TestMany(data [][]byte) []bool {
+	return testMany(data, p.Test)
+}
+
+// AddAllContext adds each item of data to the filter, checking the context for
+// cancellation periodically. It returns the number of items added, which are
+// the first ones in data, and the context's error if it was cancelled before
+// every item was added.
+func (p *PartitionedBloomFilter) AddAllContext(ctx context.Context, data [][]byte) (int, error) {
+	return addAllContext(ctx, data, func(d []byte) error {
+		p.Add(d)
+		return nil
+	})
+}
+
 // Reset restores the Bloom filter to its original state. It returns the filter
 // to allow for chaining.
 func (p *PartitionedBloomFilter) Reset() *PartitionedBloomFilter {
@@ -156,8 +292,291 @@ func (p *PartitionedBloomFilter) Reset() *PartitionedBloomFilter {
 	return p
 }
 
+// Sync flushes the filter's data to the files backing its partitions, if
+// they're memory-mapped.
+func (p *PartitionedBloomFilter) Sync() error {
+	for _, partition := range p.partitions {
+		if err := partition.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the storage backing the filter's partitions, such as
+// memory-mapped files. The filter must not be used afterwards.
+func (p *PartitionedBloomFilter) Close() error {
+	var err error
+	for _, partition := range p.partitions {
+		if cerr := partition.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// WriteTo writes a binary representation of the PartitionedBloomFilter to an
+// I/O stream. The hash function is not written, but the seed is. It returns the
+// number of bytes written.
+func (p *PartitionedBloomFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(p.m))
+	e.write(uint64(p.k))
+	e.write(uint64(p.s))
+	e.write(uint64(p.count))
+	for _, partition := range p.partitions {
+		e.writeTo(partition)
+	}
+	e.write(p.seed)
+	writeReduction(&e, p.modulo)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a PartitionedBloomFilter (such as
+// might have been written by WriteTo()) from an I/O stream. The filter keeps
+// its current hash function, which must match the one used by the filter that
+// was written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (p *PartitionedBloomFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d              = decoder{r: payload}
+		m, k, s, count uint64
+	)
+	d.read(&m)
+	d.read(&k)
+	d.read(&s)
+	d.read(&count)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if k > uint64(payload.Len())/headerSize {
+		return n, errors.New("partitions don't match filter size")
+	}
+
+	partitions := make([]*Buckets, k)
+	for i := range partitions {
+		partitions[i] = &Buckets{}
+		d.readFrom(partitions[i])
+		if d.err != nil {
+			return n, d.err
+		}
+		if partitions[i].Count() != uint(s) || partitions[i].bucketSize != 1 {
+			return n, errors.New("partitions don't match filter size")
+		}
+	}
+
+	var seed uint64
+	if payload.Len() > 0 {
+		// Written by format version 2.3 or later.
+		d.read(&seed)
+	}
+	modulo, err := readReduction(&d, payload)
+	if err != nil {
+		return n, err
+	}
+
+	p.partitions = partitions
+	p.m = uint(m)
+	p.k = uint(k)
+	p.s = uint(s)
+	p.count = uint(count)
+	p.seed = seed
+	p.modulo = modulo
+	return n, nil
+}
+
+// Union merges the other partitioned Bloom filter into this one, partition by
+// partition, such that this filter contains the union of the two sets. The
+// filters must have the same capacity, number of partitions, and partition
+// size and use the same seed and reduction of hashes to indices. Returns an
+// error if they are incompatible.
+func (p *PartitionedBloomFilter) Union(other *PartitionedBloomFilter) error {
+	if err := p.compatible(other); err != nil {
+		return err
+	}
+
+	for i, partition := range p.partitions {
+		partition.union(other.partitions[i])
+	}
+
+	p.count += other.count
+	return nil
+}
+
+// compatible returns an error unless the other partitioned Bloom filter has
+// the same capacity, number of partitions, and partition size and uses the
+// same seed and reduction of hashes to indices, so that the same items set the
+// same bits in both.
+func (p *PartitionedBloomFilter) compatible(other *PartitionedBloomFilter) error {
+	if p.m != other.m || p.s != other.s {
+		return errors.New("filter capacity must match")
+	}
+
+	if p.k != other.k {
+		return errors.New("number of hash functions must match")
+	}
+
+	if p.seed != other.seed {
+		return errors.New("hash seed must match")
+	}
+
+	if p.modulo != other.modulo {
+		return errors.New("index reduction must match")
+	}
+	return nil
+}
+
+// UnionAll returns a new partitioned Bloom filter holding the union of every
+// filter, such as those filled by workers adding disjoint ranges of keys to
+// filters created with the same parameters and seed. Every filter is checked
+// against the first before any is merged, and the filters are left unchanged.
+// The result uses a clone of the first filter's hash function. Returns an
+// error identifying the first filter which disagrees with the first on
+// capacity, number of partitions, partition size, seed, or reduction of hashes
+// to indices, or if there are no filters.
+func UnionAll(filters []*PartitionedBloomFilter) (*PartitionedBloomFilter, error) {
+	if len(filters) == 0 {
+		return nil, errors.New("no filters to union")
+	}
+
+	for i, filter := range filters[1:] {
+		if err := filters[0].compatible(filter); err != nil {
+			return nil, fmt.Errorf("filter %d: %v", i+1, err)
+		}
+	}
+
+	union := filters[0].Clone()
+	for _, filter := range filters[1:] {
+		for i, partition := range union.partitions {
+			partition.union(filter.partitions[i])
+		}
+		union.count += filter.count
+	}
+	return union, nil
+}
+
+// BuildPartitioned creates a partitioned Bloom filter optimized to store n
+// items with a specified target false-positive rate holding the keys of every
+// shard. Each shard is added by its own goroutine to a filter created with
+// the same parameters and options, and the filters are combined by UnionAll,
+// so the result is the same as adding every key to a single filter, however
+// the keys are split between the shards. The options must make the filters
+// deterministic, which the default hash function is, and a hash function set
+// by WithHasher must be created afresh for each filter. Returns the first
+// error of a shard's iterator, once every shard has returned.
+func BuildPartitioned(n uint, fpRate float64, shards []Iterator, opts ...Option) (*PartitionedBloomFilter, error) {
+	if len(shards) == 0 {
+		return NewPartitionedBloomFilter(n, fpRate, opts...), nil
+	}
+
+	var (
+		filters = make([]*PartitionedBloomFilter, len(shards))
+		errs    = make([]error, len(shards))
+		wg      sync.WaitGroup
+	)
+	for i, keys := range shards {
+		filters[i] = NewPartitionedBloomFilter(n, fpRate, opts...)
+		wg.Add(1)
+		go func(filter *PartitionedBloomFilter, keys Iterator, err *error) {
+			defer wg.Done()
+			*err = keys(func(key []byte) error {
+				filter.Add(key)
+				return nil
+			})
+		}(filters[i], keys, &errs[i])
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return UnionAll(filters)
+}
+
+// Equal returns true if the other partitioned Bloom filter has the same
+// parameters, hash seed, reduction of hashes to indices, number of items
+// added, and bits in every partition. The hash functions aren't compared.
+func (p *PartitionedBloomFilter) Equal(other *PartitionedBloomFilter) bool {
+	if p.m != other.m || p.k != other.k || p.s != other.s ||
+		p.count != other.count || p.seed != other.seed || p.modulo != other.modulo {
+		return false
+	}
+
+	for i, partition := range p.partitions {
+		if !partition.Equal(other.partitions[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the partitioned Bloom filter, which can
+// be used concurrently with the original. The copy's partitions are held in
+// memory.
+func (p *PartitionedBloomFilter) Clone() *PartitionedBloomFilter {
+	return p.clone(cloneHash64(p.hash))
+}
+
+// ByteSize returns the number of bytes used by the partitions and metadata of
+// the filter, excluding the hash function.
+func (p *PartitionedBloomFilter) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*p)) + uint64(cap(p.partitions))*pointerSize
+	for _, partition := range p.partitions {
+		size += partition.ByteSize()
+	}
+	return size
+}
+
+// clone returns a copy of the partitioned Bloom filter using the hash
+// function.
+func (p *PartitionedBloomFilter) clone(hash hash.Hash64) *PartitionedBloomFilter {
+	c := *p
+	c.partitions = make([]*Buckets, len(p.partitions))
+	for i, partition := range p.partitions {
+		c.partitions[i] = partition.Clone()
+	}
+	c.hash = hash
+	return &c
+}
+
 // SetHash sets the hashing function used in the filter.
 // For the effect on false positive rates see: https://github.com/tylertreat/BoomFilters/pull/1
 func (p *PartitionedBloomFilter) SetHash(h hash.Hash64) {
 	p.hash = h
 }
+
+// PartitionedMemory returns the estimated number of bytes used by the
+// partitions of a PartitionedBloomFilter optimized to store n items with the
+// specified target false-positive rate. Each partition is rounded up to a
+// whole number of bytes, so this is never less than ClassicMemory.
+func PartitionedMemory(n uint, fpRate float64) uint64 {
+	k, s := OptimalPartitions(n, fpRate)
+	return uint64(k) * ((uint64(s) + 7) / 8)
+}
+
+// OptimalPartitions returns the number of partitions and the number of bits
+// per partition of a PartitionedBloomFilter optimized to store n items with
+// the specified target false-positive rate. There is one partition per hash
+// function, so partitioning doesn't change the optimal k, and the optimal m of
+// the classic layout is divided evenly between them. The only space cost of
+// partitioning is rounding the partition size up, which adds fewer than k bits
+// over the classic layout.
+func OptimalPartitions(n uint, fpRate float64) (partitions uint, bitsPerPartition uint) {
+	var (
+		m = OptimalM(n, fpRate)
+		k = OptimalK(fpRate)
+	)
+	return k, uint(math.Ceil(float64(m) / float64(k)))
+}
@@ -1,7 +1,10 @@
 package boom
 
 import (
+	"bytes"
+	"errors"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -59,6 +62,32 @@ func TestPartitionedFillRatio(t *testing.T) {
 	}
 }
 
+// Ensures that EstimatedFPRate is close to the target rate for a correctly
+// sized filter.
+func TestPartitionedEstimatedFPRate(t *testing.T) {
+	f := NewPartitionedBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	if rate := f.EstimatedFPRate(); rate < 0.005 || rate > 0.02 {
+		t.Errorf("Expected approximately 0.01, got %f", rate)
+	}
+}
+
+// Ensures that ApproximatedSize estimates the number of distinct items from
+// the set bits.
+func TestPartitionedApproximatedSize(t *testing.T) {
+	f := NewPartitionedBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i % 500)))
+	}
+
+	if size := f.ApproximatedSize(); size < 475 || size > 525 {
+		t.Errorf("Expected approximately 500, got %d", size)
+	}
+}
+
 // Ensures that Test, Add, and TestAndAdd behave correctly.
 func TestPartitionedBloomTestAndAdd(t *testing.T) {
 	f := NewPartitionedBloomFilter(100, 0.01)
@@ -132,6 +161,146 @@ func TestPartitionedBloomReset(t *testing.T) {
 	}
 }
 
+// Ensures that Union merges compatible filters and returns an error for
+// incompatible ones.
+func TestPartitionedBloomUnion(t *testing.T) {
+	a := NewPartitionedBloomFilter(100, 0.01)
+	b := NewPartitionedBloomFilter(100, 0.01)
+	for i := 0; i < 50; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+		b.Add([]byte(strconv.Itoa(i + 50)))
+	}
+
+	if err := a.Union(b); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if !a.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+
+	if count := a.Count(); count != 100 {
+		t.Errorf("Expected 100, got %d", count)
+	}
+
+	if err := a.Union(NewPartitionedBloomFilter(1000, 0.01)); err == nil {
+		t.Error("Expected error for mismatched capacity")
+	}
+
+	if err := a.Union(NewPartitionedBloomFilter(100, 0.01, WithSeed(42))); err == nil {
+		t.Error("Expected error for mismatched seed")
+	}
+}
+
+// Ensures that UnionAll combines every filter without changing them and
+// rejects filters disagreeing with the first.
+func TestPartitionedBloomUnionAll(t *testing.T) {
+	filters := make([]*PartitionedBloomFilter, 4)
+	for i := range filters {
+		filters[i] = NewPartitionedBloomFilter(1000, 0.01, WithSeed(7))
+		for j := 0; j < 100; j++ {
+			filters[i].Add([]byte(strconv.Itoa(i*100 + j)))
+		}
+	}
+
+	union, err := UnionAll(filters)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 0; i < 400; i++ {
+		if !union.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+	if count := union.Count(); count != 400 {
+		t.Errorf("Expected 400, got %d", count)
+	}
+	if count := filters[0].Count(); count != 100 {
+		t.Errorf("Expected the first filter to be unchanged, got count %d", count)
+	}
+
+	if _, err := UnionAll(nil); err == nil {
+		t.Error("Expected error for no filters")
+	}
+	mismatched := append(filters, NewPartitionedBloomFilter(1000, 0.01))
+	if _, err := UnionAll(mismatched); err == nil || !strings.Contains(err.Error(), "filter 4") {
+		t.Errorf("Expected error identifying filter 4, got %v", err)
+	}
+	mismatched[4] = NewPartitionedBloomFilter(2000, 0.01, WithSeed(7))
+	if _, err := UnionAll(mismatched); err == nil {
+		t.Error("Expected error for mismatched capacity")
+	}
+}
+
+// Ensures that BuildPartitioned produces the same filter as adding every key
+// of every shard to a single filter, and returns the error of a shard.
+func TestBuildPartitioned(t *testing.T) {
+	var (
+		shards = make([]Iterator, 8)
+		single = NewPartitionedBloomFilter(1000, 0.01, WithSeed(3))
+	)
+	for i := range shards {
+		start := i * 125
+		shards[i] = func(fn func(key []byte) error) error {
+			for j := start; j < start+125; j++ {
+				if err := fn([]byte(strconv.Itoa(j))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		single.Add([]byte(strconv.Itoa(i)))
+	}
+
+	built, err := BuildPartitioned(1000, 0.01, shards, WithSeed(3))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !built.Equal(single) {
+		t.Error("Expected the sharded build to match a single filter")
+	}
+
+	failure := errors.New("read failed")
+	shards[5] = func(fn func(key []byte) error) error {
+		return failure
+	}
+	if _, err := BuildPartitioned(1000, 0.01, shards); err != failure {
+		t.Errorf("Expected the shard's error, got %v", err)
+	}
+}
+
+// Ensures that WithPowerOfTwoSize rounds partitions up to a power of two
+// without false negatives, keeping the false-positive rate below the target.
+func TestPartitionedPowerOfTwoSize(t *testing.T) {
+	f := NewPartitionedBloomFilter(10000, 0.01, WithPowerOfTwoSize())
+	if f.s&(f.s-1) != 0 || f.s < 10000 {
+		t.Fatalf("Expected a power of two partition size, got %d", f.s)
+	}
+	if f.Capacity() != f.s*f.K() {
+		t.Errorf("Expected %d, got %d", f.s*f.K(), f.Capacity())
+	}
+
+	for i := 0; i < 10000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	fp := 0
+	for i := 0; i < 20000; i++ {
+		if !f.Test([]byte(strconv.Itoa(i))) && i < 10000 {
+			t.Fatalf("Expected %d to be a member", i)
+		}
+		if i >= 10000 && f.Test([]byte(strconv.Itoa(i))) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 10000; rate > 0.01 {
+		t.Errorf("Expected a false-positive rate below 0.01, got %f", rate)
+	}
+}
+
 func BenchmarkPartitionedBloomAdd(b *testing.B) {
 	b.StopTimer()
 	f := NewPartitionedBloomFilter(100000, 0.1)
@@ -173,3 +342,106 @@ func BenchmarkPartitionedBloomTestAndAdd(b *testing.B) {
 		f.TestAndAdd(data[n])
 	}
 }
+
+func BenchmarkPartitionedBloomTestModulo(b *testing.B) {
+	b.StopTimer()
+	f := NewPartitionedBloomFilter(100000, 0.1, WithModuloReduction())
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.Test(data[n])
+	}
+}
+
+func BenchmarkPartitionedBloomTestPowerOfTwo(b *testing.B) {
+	b.StopTimer()
+	f := NewPartitionedBloomFilter(100000, 0.1, WithPowerOfTwoSize())
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.Test(data[n])
+	}
+}
+
+func BenchmarkPartitionedBloomAddPowerOfTwo(b *testing.B) {
+	b.StopTimer()
+	f := NewPartitionedBloomFilter(100000, 0.1, WithPowerOfTwoSize())
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.Add(data[n])
+	}
+}
+
+// Ensures that OptimalPartitions returns the layout of a constructed filter
+// and only adds fewer than k bits over the classic layout.
+func TestOptimalPartitions(t *testing.T) {
+	for _, fp := range []float64{0.1, 0.01, 0.001} {
+		var (
+			f    = NewPartitionedBloomFilter(1000, fp)
+			k, s = OptimalPartitions(1000, fp)
+			m    = OptimalM(1000, fp)
+		)
+
+		if k != f.K() || k != uint(len(f.partitions)) {
+			t.Errorf("Expected %d partitions, got %d", f.K(), k)
+		}
+
+		if s != f.s || s != f.partitions[0].Count() {
+			t.Errorf("Expected %d bits per partition, got %d", f.s, s)
+		}
+
+		if k*s < m || k*s-m >= k {
+			t.Errorf("Expected between %d and %d bits, got %d", m, m+k-1, k*s)
+		}
+	}
+}
+
+// Ensures that a PartitionedBloomFilter read from its written representation
+// has the same members.
+func TestPartitionedBloomWriteToReadFrom(t *testing.T) {
+	f := NewPartitionedBloomFilter(1000, 0.01)
+	for i := 0; i < 500; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	restored := NewPartitionedBloomFilter(10, 0.1)
+	var buf bytes.Buffer
+	wn, err := f.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	rn, err := restored.ReadFrom(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if wn != rn {
+		t.Errorf("Expected %d bytes read, got %d", wn, rn)
+	}
+
+	if restored.Capacity() != f.Capacity() || restored.K() != f.K() || restored.Count() != f.Count() {
+		t.Errorf("Expected %d, %d, %d, got %d, %d, %d", f.Capacity(), f.K(), f.Count(),
+			restored.Capacity(), restored.K(), restored.Count())
+	}
+
+	for i := 0; i < 2000; i++ {
+		data := []byte(strconv.Itoa(i))
+		if restored.Test(data) != f.Test(data) {
+			t.Errorf("Expected %t for %s, got %t", f.Test(data), data, restored.Test(data))
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package boom
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// ReadProgress is the progress of a ReadFromContext.
+type ReadProgress struct {
+	Read  int64 // bytes read so far
+	Total int64 // bytes of the value, as given by its frame header
+}
+
+// Percent returns the percentage of the value read so far, between 0 and 100.
+func (p ReadProgress) Percent() float64 {
+	if p.Total <= 0 {
+		return 0
+	}
+	return 100 * float64(p.Read) / float64(p.Total)
+}
+
+// ReadFromContext is like the value's ReadFrom, such as a filter's, but checks
+// the context for cancellation before every read from the stream, so a long
+// restore of a large filter can be abandoned on shutdown, and calls progress,
+// if not nil, after every read, such as to report readiness. A cancelled read
+// returns the context's error and leaves the value unchanged. A read blocked on
+// the stream isn't interrupted, so a stream which may stall, such as a network
+// connection, should also have a deadline. It returns the number of bytes
+// read.
+func ReadFromContext(ctx context.Context, stream io.Reader, r io.ReaderFrom, progress func(ReadProgress)) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// Read the header first for the length of the value.
+	var header [headerSize]byte
+	n, err := io.ReadFull(stream, header[:])
+	if err != nil {
+		return int64(n), err
+	}
+	if string(header[:majorOffset]) != formatMagic {
+		return int64(n), ErrInvalidFormat
+	}
+
+	total := int64(math.MaxInt64)
+	if length := binary.BigEndian.Uint64(header[lengthOffset:]); length <= math.MaxInt64-headerSize {
+		total = headerSize + int64(length)
+	}
+	cr := &contextReader{
+		ctx:      ctx,
+		r:        stream,
+		progress: progress,
+		state:    ReadProgress{Read: headerSize, Total: total},
+	}
+	cr.report()
+	return r.ReadFrom(io.MultiReader(bytes.NewReader(header[:]), cr))
+}
+
+// contextReader reads from a stream until its context is cancelled, reporting
+// its progress.
+type contextReader struct {
+	ctx      context.Context
+	r        io.Reader
+	progress func(ReadProgress)
+	state    ReadProgress
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.state.Read += int64(n)
+		c.report()
+	}
+	return n, err
+}
+
+// report calls the progress callback, if set.
+func (c *contextReader) report() {
+	if c.progress != nil {
+		c.progress(c.state)
+	}
+}
@@ -0,0 +1,88 @@
+package boom
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+	"testing/iotest"
+)
+
+// Ensures that ReadFromContext reports its progress up to the whole value and
+// reads it like ReadFrom.
+func TestReadFromContext(t *testing.T) {
+	f := NewBloomFilter(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		f.AddString(strconv.Itoa(i))
+	}
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	size := int64(buf.Len())
+
+	var (
+		read  = NewBloomFilter(1, 0.5)
+		calls int
+		last  ReadProgress
+	)
+	n, err := ReadFromContext(context.Background(), iotest.HalfReader(&buf), read, func(p ReadProgress) {
+		if p.Read < last.Read || p.Total != size {
+			t.Errorf("Unexpected progress %+v after %+v", p, last)
+		}
+		calls++
+		last = p
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != size {
+		t.Errorf("Expected %d bytes read, got %d", size, n)
+	}
+	if calls < 10 || last.Read != size || last.Percent() != 100 {
+		t.Errorf("Expected the whole value reported over many calls, got %+v after %d", last, calls)
+	}
+	if !read.Equal(f) {
+		t.Error("Expected filters to be equal")
+	}
+
+	if percent := (ReadProgress{}).Percent(); percent != 0 {
+		t.Errorf("Expected 0, got %f", percent)
+	}
+}
+
+// Ensures that ReadFromContext stops once its context is cancelled, leaving
+// the value unchanged.
+func TestReadFromContextCancel(t *testing.T) {
+	f := NewBloomFilter(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		f.AddString(strconv.Itoa(i))
+	}
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data := buf.Bytes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	read := NewBloomFilter(1, 0.5)
+	_, err := ReadFromContext(ctx, iotest.HalfReader(bytes.NewReader(data)), read, func(p ReadProgress) {
+		if p.Percent() > 50 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if !read.Equal(NewBloomFilter(1, 0.5)) {
+		t.Error("Expected filter to be unchanged")
+	}
+
+	if _, err := ReadFromContext(ctx, bytes.NewReader(data), read, nil); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := ReadFromContext(context.Background(), bytes.NewReader([]byte("BAD!plus some more bytes")), read, nil); err != ErrInvalidFormat {
+		t.Errorf("Expected ErrInvalidFormat, got %v", err)
+	}
+}
@@ -0,0 +1,706 @@
+package boom
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"unsafe"
+)
+
+// quotientMaxLoad is the fraction of slots a QuotientFilter fills before Add
+// grows it, past which clusters and therefore lookups grow long.
+const quotientMaxLoad = 0.9
+
+// Metadata bits of a QuotientFilter slot, which precede the remainder.
+const (
+	qfOccupied     = 1 << iota // some item's quotient is the slot
+	qfContinuation             // the slot continues the run of the previous one
+	qfShifted                  // the slot holds a remainder of another quotient
+	qfMetadataBits = iota
+)
+
+// QuotientFilter implements a quotient filter as described by Bender et al.
+// in Don't Thrash: How to Cache Your Hash on Flash:
+//
+// http://vldb.org/pvldb/vol5/p1627_michaelabender_vldb2012.pdf
+//
+// Each item hashes to a fingerprint of q+r bits, split into a quotient of q
+// bits indexing one of 2^q slots and a remainder of r bits stored in the
+// table. The remainders of a quotient are kept sorted in a run of consecutive
+// slots, which is shifted along from the quotient's slot by the runs of lower
+// quotients, and three metadata bits per slot recover the quotient of every
+// remainder. So a lookup scans a few consecutive slots, which makes it cache
+// friendly, and items can be removed, like in a Counting Bloom Filter, using
+// about r+3 bits per slot instead of several counters per item.
+//
+// Since the filter stores its fingerprints, it can double its number of slots
+// without the original items by moving a bit of each remainder to its
+// quotient, which Add does once the filter is 90% full, and two filters with
+// the same fingerprint length can be merged. Repeated insertions of an item
+// store its fingerprint once per insertion, so it remains a member until it has
+// been removed as many times.
+type QuotientFilter struct {
+	quotientTable
+	count uint        // number of items in the filter
+	hash  hash.Hash64 // hash function
+	seed  uint64      // hash seed (zero means unseeded)
+}
+
+// NewQuotientFilter creates a new Quotient Filter optimized to store n items
+// with a specified target false-positive rate before it grows. Each time it
+// grows, its false-positive rate at full load roughly doubles.
+func NewQuotientFilter(n uint, fpRate float64, opts ...Option) *QuotientFilter {
+	var (
+		o    = applyOptions(opts)
+		q, r = quotientParams(n, fpRate, qfMetadataBits)
+	)
+	return &QuotientFilter{
+		quotientTable: newQuotientTable(q, r, qfMetadataBits),
+		hash:          o.newHash64(newFNV64),
+		seed:          o.seed,
+	}
+}
+
+// Capacity returns the number of items the filter can store before it grows.
+func (f *QuotientFilter) Capacity() uint {
+	return uint(quotientMaxLoad * float64(uint64(1)<<f.q))
+}
+
+// Count returns the number of items in the filter.
+func (f *QuotientFilter) Count() uint {
+	return f.count
+}
+
+// QuotientBits returns the number of quotient bits, the base-2 logarithm of
+// the number of slots.
+func (f *QuotientFilter) QuotientBits() uint {
+	return f.q
+}
+
+// RemainderBits returns the number of remainder bits stored in each slot.
+func (f *QuotientFilter) RemainderBits() uint {
+	return f.r
+}
+
+// EstimatedFPRate returns the estimated false-positive rate for the current
+// number of items, the probability that one of their fingerprints of q+r bits
+// matches an absent item's.
+func (f *QuotientFilter) EstimatedFPRate() float64 {
+	return 1 - math.Exp(-float64(f.count)/math.Ldexp(1, int(f.q+f.r)))
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. This is a probabilistic test, meaning there is a
+// non-zero probability of false positives but a zero probability of false
+// negatives.
+func (f *QuotientFilter) Test(data []byte) bool {
+	_, found := f.find(f.fingerprint(data))
+	return found
+}
+
+// Add will add the data to the filter, growing it if it's 90% full. It returns
+// an error if the filter is full and can't grow, because its remainders are
+// down to a single bit.
+func (f *QuotientFilter) Add(data []byte) error {
+	return f.insert(f.fingerprint(data))
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data was a member, false if not, and an error if it couldn't be added.
+func (f *QuotientFilter) TestAndAdd(data []byte) (bool, error) {
+	fingerprint := f.fingerprint(data)
+	_, member := f.find(fingerprint)
+	return member, f.insert(fingerprint)
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (f *QuotientFilter) TestString(data string) bool {
+	return f.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied.
+func (f *QuotientFilter) AddString(data string) error {
+	return f.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (f *QuotientFilter) TestAndAddString(data string) (bool, error) {
+	return f.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter, stopping at the first error
+// returned by Add. It returns the number of items added, which are the first
+// ones in data, and the error, if any.
+func (f *QuotientFilter) AddMany(data [][]byte) (int, error) {
+	return addAllContext(context.Background(), data, f.Add)
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+func (f *QuotientFilter) TestMany(data [][]byte) []bool {
+	return testMany(data, f.Test)
+}
+
+// TestAndRemove will test for membership of the data and remove it from the
+// filter if it exists. Returns true if the data was a member, false if not.
+func (f *QuotientFilter) TestAndRemove(data []byte) bool {
+	fingerprint := f.fingerprint(data)
+	s, found := f.find(fingerprint)
+	if !found {
+		return false
+	}
+
+	quotient, _ := f.split(fingerprint)
+	f.remove(s, quotient)
+	f.count--
+	return true
+}
+
+// Grow doubles the number of slots, moving the leading bit of every remainder
+// to its quotient, so the items remain members without being added again.
+// The false-positive rate at full load roughly doubles. Returns an error if
+// the remainders are down to a single bit.
+func (f *QuotientFilter) Grow() error {
+	if f.r <= 1 {
+		return errors.New("remainders can't shrink")
+	}
+
+	grown := newQuotientTable(f.q+1, f.r-1, f.meta)
+	f.iterate(func(fingerprint uint64) {
+		grown.insertSlot(fingerprint)
+	})
+	f.quotientTable = grown
+	return nil
+}
+
+// Merge adds the items of the other Quotient Filter to this one, such that
+// this filter contains the union of the two multisets, growing it as needed.
+// The filters may have grown a different number of times, but must have
+// fingerprints of the same length and use the same seed. Returns an error if
+// they are incompatible or this filter becomes full.
+func (f *QuotientFilter) Merge(other *QuotientFilter) error {
+	if f.q+f.r != other.q+other.r {
+		return errors.New("fingerprint length must match")
+	}
+	if f.seed != other.seed {
+		return errors.New("hash seed must match")
+	}
+
+	var err error
+	other.iterate(func(fingerprint uint64) {
+		if err == nil {
+			err = f.insert(fingerprint)
+		}
+	})
+	return err
+}
+
+// Reset restores the filter to its original state, keeping its current number
+// of slots. It returns the filter to allow for chaining.
+func (f *QuotientFilter) Reset() *QuotientFilter {
+	f.quotientTable = newQuotientTable(f.q, f.r, f.meta)
+	f.count = 0
+	return f
+}
+
+// ByteSize returns the number of bytes used by the slots and metadata of the
+// Quotient Filter, excluding the hash function.
+func (f *QuotientFilter) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*f)) + uint64(cap(f.slots))*8
+}
+
+// Equal returns true if the other Quotient Filter has the same parameters,
+// hash seed, number of items, and slots. The hash functions aren't compared.
+func (f *QuotientFilter) Equal(other *QuotientFilter) bool {
+	return f.count == other.count && f.seed == other.seed && f.equal(&other.quotientTable)
+}
+
+// Clone returns an independent copy of the Quotient Filter, which can be used
+// concurrently with the original.
+func (f *QuotientFilter) Clone() *QuotientFilter {
+	clone := *f
+	clone.slots = append([]uint64(nil), f.slots...)
+	clone.hash = cloneHash64(f.hash)
+	return &clone
+}
+
+// SetHash sets the hashing function used in the filter, which must be the one
+// the items were added with.
+func (f *QuotientFilter) SetHash(h hash.Hash64) {
+	f.hash = h
+}
+
+// fingerprint returns the q+r bit fingerprint of the data.
+func (f *QuotientFilter) fingerprint(data []byte) uint64 {
+	return quotientFingerprint(data, f.hash, f.seed, f.q+f.r)
+}
+
+// find returns the index of a slot holding the fingerprint, if any.
+func (f *QuotientFilter) find(fingerprint uint64) (uint64, bool) {
+	quotient, remainder := f.split(fingerprint)
+	if f.get(quotient)&qfOccupied == 0 {
+		return 0, false
+	}
+
+	s := f.runStart(quotient)
+	for {
+		rem := f.remainder(f.get(s))
+		if rem == remainder {
+			return s, true
+		}
+		if rem > remainder {
+			return 0, false
+		}
+		if s = f.next(s); f.get(s)&qfContinuation == 0 {
+			return 0, false
+		}
+	}
+}
+
+// insert adds the fingerprint to the filter, growing it first if it's at
+// capacity. Returns an error if it's full and can't grow. Growing keeps the
+// fingerprint length, so the fingerprint remains valid.
+func (f *QuotientFilter) insert(fingerprint uint64) error {
+	// A slot is always left empty, so some cluster starts after it.
+	if f.count >= f.Capacity() && f.Grow() != nil && uint64(f.count)+1 >= 1<<f.q {
+		return errors.New("full")
+	}
+	f.insertSlot(fingerprint)
+	f.count++
+	return nil
+}
+
+// iterate calls fn with the fingerprint of every item in the filter.
+func (f *QuotientFilter) iterate(fn func(fingerprint uint64)) {
+	f.iterateSlots(f.count, func(quotient, slot uint64) {
+		fn(quotient<<f.r | f.remainder(slot))
+	})
+}
+
+// quotientTable holds the slots of a quotient filter, each made of metadata
+// bits followed by a remainder, and implements the operations on their runs
+// and clusters.
+type quotientTable struct {
+	slots []uint64 // slots of metadata bits followed by the remainder
+	q     uint     // number of quotient bits
+	r     uint     // number of remainder bits
+	meta  uint     // number of metadata bits
+}
+
+// newQuotientTable returns a table of 2^q empty slots of r remainder bits and
+// the metadata bits.
+func newQuotientTable(q, r, meta uint) quotientTable {
+	return quotientTable{slots: make([]uint64, ((uint64(1)<<q)*uint64(r+meta)+63)/64), q: q, r: r, meta: meta}
+}
+
+// split returns the quotient and remainder of the fingerprint.
+func (t *quotientTable) split(fingerprint uint64) (uint64, uint64) {
+	return fingerprint >> t.r, fingerprint & (1<<t.r - 1)
+}
+
+// remainder returns the remainder held by the slot.
+func (t *quotientTable) remainder(slot uint64) uint64 {
+	return slot >> t.meta
+}
+
+// get returns the slot at the index.
+func (t *quotientTable) get(i uint64) uint64 {
+	width := t.r + t.meta
+	return uint64(getWordBits(t.slots, uint(i)*width, width))
+}
+
+// set sets the slot at the index.
+func (t *quotientTable) set(i, slot uint64) {
+	width := t.r + t.meta
+	setWordBits(t.slots, uint(i)*width, width, uint32(slot))
+}
+
+// next returns the index of the slot following i, wrapping around.
+func (t *quotientTable) next(i uint64) uint64 {
+	return (i + 1) & (1<<t.q - 1)
+}
+
+// prev returns the index of the slot preceding i, wrapping around.
+func (t *quotientTable) prev(i uint64) uint64 {
+	return (i - 1) & (1<<t.q - 1)
+}
+
+// runStart returns the index of the first slot of the quotient's run, which
+// must be occupied.
+func (t *quotientTable) runStart(quotient uint64) uint64 {
+	// Walk back to the start of the cluster, then forward counting runs
+	// until reaching the quotient's.
+	b := quotient
+	for t.get(b)&qfShifted != 0 {
+		b = t.prev(b)
+	}
+	s := b
+	for b != quotient {
+		for {
+			s = t.next(s)
+			if t.get(s)&qfContinuation == 0 {
+				break
+			}
+		}
+		for {
+			b = t.next(b)
+			if t.get(b)&qfOccupied != 0 {
+				break
+			}
+		}
+	}
+	return s
+}
+
+// insertSlot adds the remainder of the fingerprint to the run of its
+// quotient, keeping the run sorted, after any equal remainders. The table must
+// have an empty slot. It returns the slot the remainder was stored in.
+func (t *quotientTable) insertSlot(fingerprint uint64) uint64 {
+	var (
+		quotient, remainder = t.split(fingerprint)
+		canonical           = t.get(quotient)
+	)
+	if isQFEmpty(canonical) {
+		t.set(quotient, remainder<<t.meta|qfOccupied)
+		return quotient
+	}
+
+	if canonical&qfOccupied == 0 {
+		// Start a new run.
+		t.set(quotient, canonical|qfOccupied)
+		start := t.runStart(quotient)
+		t.insertAt(quotient, start, remainder<<t.meta)
+		return start
+	}
+
+	// Find the position in the sorted run, skipping counters.
+	start := t.runStart(quotient)
+	s := start
+	for {
+		if slot := t.get(s); !t.isCounter(slot) && t.remainder(slot) > remainder {
+			break
+		}
+		if s = t.next(s); t.get(s)&qfContinuation == 0 {
+			break
+		}
+	}
+
+	entry := remainder << t.meta
+	if s == start {
+		// The old start of the run becomes a continuation.
+		t.set(start, t.get(start)|qfContinuation)
+	} else {
+		entry |= qfContinuation
+	}
+	t.insertAt(quotient, s, entry)
+	return s
+}
+
+// insertAt stores the entry in slot s of the quotient's run, which it starts
+// unless its continuation bit is set, shifting the following slots of the
+// cluster along up to the first empty one.
+func (t *quotientTable) insertAt(quotient, s, entry uint64) {
+	if s != quotient {
+		entry |= qfShifted
+	}
+	for {
+		prev := t.get(s)
+		empty := isQFEmpty(prev)
+		if !empty {
+			// The occupied bit belongs to the slot, not its remainder.
+			prev |= qfShifted
+			if prev&qfOccupied != 0 {
+				entry |= qfOccupied
+				prev &^= qfOccupied
+			}
+		}
+		t.set(s, entry)
+		entry = prev
+		s = t.next(s)
+		if empty {
+			return
+		}
+	}
+}
+
+// remove removes slot s of the quotient's run, shifting the following slots of
+// the cluster back.
+func (t *quotientTable) remove(s, quotient uint64) {
+	var (
+		canonical = t.get(quotient)
+		runStart  = isQFRunStart(t.get(s))
+	)
+
+	// Removing the only slot of a run leaves its quotient unoccupied.
+	if runStart && t.get(t.next(s))&qfContinuation == 0 {
+		t.set(quotient, canonical&^qfOccupied)
+	}
+
+	t.deleteEntry(s, quotient)
+
+	if runStart {
+		// The following slot of the run, if any, becomes its start.
+		next := t.get(s)
+		updated := next
+		if updated&qfContinuation != 0 {
+			updated &^= qfContinuation
+		}
+		if s == quotient && isQFRunStart(updated) {
+			updated &^= qfShifted
+		}
+		if updated != next {
+			t.set(s, updated)
+		}
+	}
+}
+
+// deleteEntry removes slot s of the quotient's run, shifting the following
+// slots of the cluster back, without fixing up the start of the run.
+func (t *quotientTable) deleteEntry(s, quotient uint64) {
+	var (
+		curr = t.get(s)
+		sp   = t.next(s)
+		orig = s
+	)
+	for {
+		next := t.get(sp)
+		occupied := curr&qfOccupied != 0
+		if isQFEmpty(next) || isQFClusterStart(next) || sp == orig {
+			t.set(s, 0)
+			return
+		}
+
+		// Remainders sliding back into their quotient's slot are no longer
+		// shifted.
+		updated := next
+		if isQFRunStart(next) {
+			for {
+				quotient = t.next(quotient)
+				if t.get(quotient)&qfOccupied != 0 {
+					break
+				}
+			}
+			if occupied && quotient == s {
+				updated &^= qfShifted
+			}
+		}
+		if occupied {
+			updated |= qfOccupied
+		} else {
+			updated &^= qfOccupied
+		}
+		t.set(s, updated)
+		s = sp
+		sp = t.next(sp)
+		curr = next
+	}
+}
+
+// iterateSlots calls fn with the quotient of every one of the n non-empty
+// slots, in the order of their runs, and the slot.
+func (t *quotientTable) iterateSlots(n uint, fn func(quotient, slot uint64)) {
+	if n == 0 {
+		return
+	}
+
+	// Start at a cluster start, so the quotient of every run is known. One
+	// exists since a slot is always left empty.
+	var start uint64
+	for !isQFClusterStart(t.get(start)) {
+		start++
+	}
+
+	quotient := start
+	for visited, i := uint(0), start; visited < n; i = t.next(i) {
+		slot := t.get(i)
+		if isQFClusterStart(slot) {
+			quotient = i
+		} else if isQFRunStart(slot) {
+			for {
+				quotient = t.next(quotient)
+				if t.get(quotient)&qfOccupied != 0 {
+					break
+				}
+			}
+		}
+		if !isQFEmpty(slot) {
+			fn(quotient, slot)
+			visited++
+		}
+	}
+}
+
+// isCounter returns true if the slot holds a digit of a counter rather than a
+// remainder, which only tables with counters have.
+func (t *quotientTable) isCounter(slot uint64) bool {
+	return t.meta > cqfCounterBit && slot&cqfCounter != 0
+}
+
+// equal returns true if the other table has the same parameters and slots.
+func (t *quotientTable) equal(other *quotientTable) bool {
+	if t.q != other.q || t.r != other.r || t.meta != other.meta || len(t.slots) != len(other.slots) {
+		return false
+	}
+	for i, slot := range t.slots {
+		if slot != other.slots[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// readQuotientTable reads the slots of a table with the parameters from the
+// payload. Returns an error if the parameters are invalid.
+func readQuotientTable(d *decoder, payload *bytes.Reader, q, r uint64, meta uint) (quotientTable, error) {
+	if q == 0 || r == 0 || r > 32-uint64(meta) || q > 64-r {
+		return quotientTable{}, errors.New("slots don't match filter")
+	}
+	// Every slot takes r+meta bits.
+	if q >= 64 || uint64(1)<<q > uint64(payload.Len())*8/(r+uint64(meta)) {
+		return quotientTable{}, io.ErrUnexpectedEOF
+	}
+
+	table := newQuotientTable(uint(q), uint(r), meta)
+	d.read(table.slots)
+	return table, d.err
+}
+
+// valid returns true if exactly n slots hold remainders and, unless there are
+// none, one of them starts a cluster, so iterating the slots terminates.
+func (t *quotientTable) valid(n uint64) bool {
+	var used uint64
+	clusters := n == 0
+	for i := uint64(0); i < 1<<t.q; i++ {
+		slot := t.get(i)
+		if !isQFEmpty(slot) {
+			used++
+		}
+		clusters = clusters || isQFClusterStart(slot)
+	}
+	return used == n && clusters
+}
+
+// isQFEmpty returns true if the slot holds no remainder.
+func isQFEmpty(slot uint64) bool {
+	return slot&(qfOccupied|qfContinuation|qfShifted) == 0
+}
+
+// isQFClusterStart returns true if the slot holds the first remainder of a
+// cluster, a run in its quotient's slot.
+func isQFClusterStart(slot uint64) bool {
+	return slot&(qfOccupied|qfContinuation|qfShifted) == qfOccupied
+}
+
+// isQFRunStart returns true if the slot holds the first remainder of a run.
+func isQFRunStart(slot uint64) bool {
+	return slot&qfContinuation == 0 && slot&(qfOccupied|qfShifted) != 0
+}
+
+// quotientFingerprint returns the fingerprint of p bits of the data.
+func quotientFingerprint(data []byte, h hash.Hash64, seed uint64, p uint) uint64 {
+	lower, upper := seededHashKernel(data, h, seed)
+	fingerprint := uint64(upper)<<32 | uint64(lower)
+	if p < 64 {
+		fingerprint &= 1<<p - 1
+	}
+	return fingerprint
+}
+
+// quotientParams returns the quotient and remainder bits of a filter storing n
+// items with a false-positive rate before it grows, each slot having the
+// metadata bits.
+func quotientParams(n uint, fpRate float64, meta uint) (uint, uint) {
+	var (
+		q = uint(math.Ceil(math.Log2(float64(n) / quotientMaxLoad)))
+		r = uint(math.Ceil(math.Log2(1 / fpRate)))
+	)
+	if q < 1 || n == 0 {
+		q = 1
+	}
+	if r < 1 {
+		r = 1
+	}
+	if r > 32-meta {
+		r = 32 - meta
+	}
+	if q > 64-r {
+		q = 64 - r
+	}
+	return q, r
+}
+
+// WriteTo writes a binary representation of the QuotientFilter to an I/O
+// stream. The hash function is not written, but the seed is. It returns the
+// number of bytes written.
+func (f *QuotientFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(f.q))
+	e.write(uint64(f.r))
+	e.write(uint64(f.count))
+	e.write(f.seed)
+	e.write(f.slots)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a QuotientFilter (such as might
+// have been written by WriteTo()) from an I/O stream. The filter keeps its
+// current hash function, which must match the one used by the filter that was
+// written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (f *QuotientFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                 = decoder{r: payload}
+		q, r, count, seed uint64
+	)
+	d.read(&q)
+	d.read(&r)
+	d.read(&count)
+	d.read(&seed)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	table, err := readQuotientTable(&d, payload, q, r, qfMetadataBits)
+	if err != nil {
+		return n, err
+	}
+	if count >= 1<<q || !table.valid(count) {
+		return n, errors.New("count doesn't match filter")
+	}
+
+	f.quotientTable = table
+	f.count = uint(count)
+	f.seed = seed
+	if f.hash == nil {
+		f.hash = newFNV64()
+	}
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (f *QuotientFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(f)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo. If the
+// filter has no hash function, such as when it's the zero value, the default
+// hash function is used.
+func (f *QuotientFilter) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(f, data)
+}
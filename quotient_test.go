@@ -0,0 +1,216 @@
+package boom
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// quotientFingerprints returns the sorted fingerprints of the filter's items.
+func quotientFingerprints(f *QuotientFilter) []uint64 {
+	var fingerprints []uint64
+	f.iterate(func(fingerprint uint64) {
+		fingerprints = append(fingerprints, fingerprint)
+	})
+	sort.Sort(uint64Slice(fingerprints))
+	return fingerprints
+}
+
+// Ensures that a Quotient Filter matches a multiset of fingerprints through
+// random insertions and removals, including ones shifting runs across the end
+// of the table and growing it.
+func TestQuotientFilterRandom(t *testing.T) {
+	var (
+		f     = NewQuotientFilter(8, 1.0/4096, WithSeed(1))
+		model = make(map[uint64]int)
+		rng   = rand.New(rand.NewSource(1))
+	)
+	for i := 0; i < 20000; i++ {
+		key := []byte(strconv.Itoa(rng.Intn(300)))
+		fingerprint := f.fingerprint(key)
+		if rng.Intn(3) == 0 {
+			if removed := f.TestAndRemove(key); removed != (model[fingerprint] > 0) {
+				t.Fatalf("Expected removal of %s to be %t at step %d", key, !removed, i)
+			}
+			if model[fingerprint] > 0 {
+				model[fingerprint]--
+			}
+		} else {
+			if err := f.Add(key); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			model[fingerprint]++
+		}
+
+		if i%500 == 0 {
+			var expected []uint64
+			for fingerprint, count := range model {
+				for j := 0; j < count; j++ {
+					expected = append(expected, fingerprint)
+				}
+			}
+			sort.Sort(uint64Slice(expected))
+			actual := quotientFingerprints(f)
+			if len(actual) != len(expected) || uint(len(actual)) != f.Count() {
+				t.Fatalf("Expected %d items at step %d, got %d of %d", len(expected), i, len(actual), f.Count())
+			}
+			for j := range actual {
+				if actual[j] != expected[j] {
+					t.Fatalf("Expected fingerprint %d at step %d, got %d", expected[j], i, actual[j])
+				}
+			}
+		}
+	}
+
+	for i := 0; i < 300; i++ {
+		key := []byte(strconv.Itoa(i))
+		if f.Test(key) != (model[f.fingerprint(key)] > 0) {
+			t.Errorf("Expected membership of %d to match", i)
+		}
+	}
+}
+
+// Ensures that Add grows the filter once it's at capacity, keeping its items,
+// and returns an error once it's full and can't grow.
+func TestQuotientFilterGrow(t *testing.T) {
+	f := NewQuotientFilter(100, 0.01)
+	q, r := f.QuotientBits(), f.RemainderBits()
+	for i := 0; i < 1000; i++ {
+		if err := f.Add([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if f.QuotientBits() <= q || f.QuotientBits()+f.RemainderBits() != q+r {
+		t.Errorf("Expected more quotient bits than %d with %d bits in all, got %d and %d",
+			q, q+r, f.QuotientBits(), f.RemainderBits())
+	}
+	if f.Count() != 1000 || f.Count() > f.Capacity() {
+		t.Errorf("Expected 1000 items within capacity %d, got %d", f.Capacity(), f.Count())
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.Test([]byte(strconv.Itoa(i))) {
+			t.Fatalf("Expected %d to be a member", i)
+		}
+	}
+
+	small := NewQuotientFilter(2, 0.5)
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		err = small.Add([]byte(strconv.Itoa(i)))
+	}
+	if err == nil {
+		t.Error("Expected error adding to a full filter")
+	}
+	if err := small.Grow(); err == nil {
+		t.Error("Expected error growing single-bit remainders")
+	}
+}
+
+// Ensures that Merge adds the items of another filter, even one which has
+// grown a different number of times, and rejects incompatible filters.
+func TestQuotientFilterMerge(t *testing.T) {
+	var (
+		f     = NewQuotientFilter(1000, 0.001)
+		other = NewQuotientFilter(1000, 0.001)
+	)
+	for i := 0; i < 500; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	for i := 500; i < 3000; i++ {
+		other.Add([]byte(strconv.Itoa(i)))
+	}
+	if other.QuotientBits() == f.QuotientBits() {
+		t.Fatal("Expected the other filter to have grown")
+	}
+
+	if err := f.Merge(other); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Count() != 3000 {
+		t.Errorf("Expected 3000 items, got %d", f.Count())
+	}
+	for i := 0; i < 3000; i++ {
+		if !f.Test([]byte(strconv.Itoa(i))) {
+			t.Fatalf("Expected %d to be a member", i)
+		}
+	}
+
+	if err := f.Merge(NewQuotientFilter(1000, 0.1)); err == nil {
+		t.Error("Expected error merging filters with different fingerprints")
+	}
+	if err := f.Merge(NewQuotientFilter(1000, 0.001, WithSeed(1))); err == nil {
+		t.Error("Expected error merging filters with different seeds")
+	}
+}
+
+// Ensures that a Quotient Filter read by ReadFrom equals the one written by
+// WriteTo, and that cloned and reset filters behave independently.
+func TestQuotientFilterReadWrite(t *testing.T) {
+	f := NewQuotientFilter(1000, 0.01, WithSeed(5))
+	for i := 0; i < 800; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restored := &QuotientFilter{}
+	if _, err := restored.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !restored.Equal(f) || !restored.Test([]byte(`1`)) {
+		t.Error("Expected restored filter to equal original")
+	}
+
+	clone := f.Clone()
+	if f.Reset(); f.Count() != 0 || f.Test([]byte(`1`)) || !clone.Test([]byte(`1`)) {
+		t.Error("Expected reset filter to be empty and its clone unchanged")
+	}
+}
+
+// Ensures that ReadFrom rejects slots whose metadata doesn't match the count,
+// or which have no cluster start to iterate them from.
+func TestQuotientFilterReadFromInvalidSlots(t *testing.T) {
+	for _, count := range []uint{2, 3} {
+		f := NewQuotientFilter(10, 0.01)
+		f.set(0, 1<<f.meta|qfShifted)
+		f.set(1, 2<<f.meta|qfShifted)
+		f.count = count
+
+		var buf bytes.Buffer
+		if _, err := f.WriteTo(&buf); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := (&QuotientFilter{}).ReadFrom(&buf); err == nil {
+			t.Errorf("Expected error for %d items without a cluster start", count)
+		}
+	}
+}
+
+func BenchmarkQuotientFilterAdd(b *testing.B) {
+	f := NewQuotientFilter(uint(b.N), 0.001)
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		f.Add(data[n])
+	}
+}
+
+func BenchmarkQuotientFilterTest(b *testing.B) {
+	f := NewQuotientFilter(uint(b.N), 0.001)
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+		f.Add(data[i])
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		f.Test(data[n])
+	}
+}
@@ -0,0 +1,64 @@
+package boom
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+)
+
+// splitMix64 is a rand.Source implementing the SplitMix64 generator. Its state
+// is a single 64-bit word, so it can be checkpointed and restored to reproduce
+// a sequence of random numbers exactly.
+type splitMix64 struct {
+	state uint64
+}
+
+// newSplitMix64 returns a new splitMix64 seeded from the default source.
+func newSplitMix64() *splitMix64 {
+	return &splitMix64{state: uint64(rand.Int63())}
+}
+
+// Uint64 returns a pseudo-random 64-bit value.
+func (s *splitMix64) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// Int63 returns a non-negative pseudo-random 63-bit integer.
+func (s *splitMix64) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// Seed uses the provided seed value to initialize the generator to a
+// deterministic state.
+func (s *splitMix64) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+// MarshalBinary returns the generator state.
+func (s *splitMix64) MarshalBinary() ([]byte, error) {
+	state := make([]byte, 8)
+	binary.BigEndian.PutUint64(state, s.state)
+	return state, nil
+}
+
+// UnmarshalBinary restores the generator state returned by MarshalBinary.
+func (s *splitMix64) UnmarshalBinary(state []byte) error {
+	if len(state) != 8 {
+		return errors.New("invalid rand state length")
+	}
+	s.state = binary.BigEndian.Uint64(state)
+	return nil
+}
+
+// cloneRand returns a source of randomness for a cloned structure seeded from
+// the original's, or nil if there's none.
+func cloneRand(r *rand.Rand) *rand.Rand {
+	if r == nil {
+		return nil
+	}
+	return rand.New(&splitMix64{state: r.Uint64()})
+}
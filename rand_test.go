@@ -0,0 +1,32 @@
+package boom
+
+import "testing"
+
+// Ensures that splitMix64 reproduces the same sequence after restoring its
+// state.
+func TestSplitMix64State(t *testing.T) {
+	s := &splitMix64{}
+	s.Seed(42)
+	s.Uint64()
+
+	state, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []uint64{s.Uint64(), s.Uint64(), s.Uint64()}
+
+	restored := &splitMix64{}
+	if err := restored.UnmarshalBinary(state); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i, e := range expected {
+		if actual := restored.Uint64(); actual != e {
+			t.Errorf("Expected %d at %d, got %d", e, i, actual)
+		}
+	}
+
+	if v := s.Int63(); v < 0 {
+		t.Errorf("Expected non-negative value, got %d", v)
+	}
+}
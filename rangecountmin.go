@@ -0,0 +1,307 @@
+package boom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"unsafe"
+)
+
+// RangeCountMinSketch estimates the number of integer values added within a
+// range, and the quantiles of the values, using dyadic Count-Min Sketches as
+// described by Cormode and Muthukrishnan in An Improved Data Stream Summary:
+// The Count-Min Sketch and its Applications:
+//
+// http://dimacs.rutgers.edu/~graham/pubs/papers/cm-full.pdf
+//
+// The domain of values, [0, 2^bits), is divided into dyadic intervals, whose
+// lengths are powers of two and which start at a multiple of their length. A
+// Count-Min Sketch per length counts the values added within each interval of
+// that length, so any range is the union of at most 2*bits intervals, whose
+// estimates are summed. Like a Count-Min Sketch, range estimates never
+// underestimate, and are within epsilon times the number of values added for
+// each interval summed, with probability 1-delta.
+type RangeCountMinSketch struct {
+	levels []*CountMinSketch // sketch of the intervals of length 2^i at i
+	bits   uint              // number of bits of a value
+	count  uint64            // number of values added
+}
+
+// NewRangeCountMinSketch creates a new Range Count-Min Sketch of values which
+// have at most bits bits, between 1 and 64, whose sketches are accurate
+// within a factor of epsilon with probability delta.
+func NewRangeCountMinSketch(bits uint, epsilon, delta float64, opts ...Option) *RangeCountMinSketch {
+	if bits == 0 {
+		bits = 1
+	}
+	if bits > 64 {
+		bits = 64
+	}
+	levels := make([]*CountMinSketch, bits)
+	for i := range levels {
+		levels[i] = NewCountMinSketch(epsilon, delta, opts...)
+	}
+	return &RangeCountMinSketch{levels: levels, bits: bits}
+}
+
+// Bits returns the number of bits of the values in the domain.
+func (r *RangeCountMinSketch) Bits() uint {
+	return r.bits
+}
+
+// Epsilon returns the relative-accuracy factor of each sketch, epsilon.
+func (r *RangeCountMinSketch) Epsilon() float64 {
+	return r.levels[0].Epsilon()
+}
+
+// Delta returns the relative-accuracy probability of each sketch, delta.
+func (r *RangeCountMinSketch) Delta() float64 {
+	return r.levels[0].Delta()
+}
+
+// TotalCount returns the number of values added.
+func (r *RangeCountMinSketch) TotalCount() uint64 {
+	return r.count
+}
+
+// Add will add the value, which is truncated to the domain's bits. Returns the
+// RangeCountMinSketch to allow for chaining.
+func (r *RangeCountMinSketch) Add(value uint64) *RangeCountMinSketch {
+	value &= r.max()
+	for i, level := range r.levels {
+		level.Add(rangeKey(value >> uint(i)))
+	}
+	r.count++
+	return r
+}
+
+// Count returns the approximate number of times the value was added.
+func (r *RangeCountMinSketch) Count(value uint64) uint64 {
+	return r.EstimateRange(value, value)
+}
+
+// EstimateRange returns the approximate number of values added between lo and
+// hi inclusive, the sum of the estimates of at most 2*bits dyadic intervals,
+// so whose error is at most 2*bits*epsilon times the number of values added
+// with probability 1-delta. Bounds beyond the domain are clamped to it.
+func (r *RangeCountMinSketch) EstimateRange(lo, hi uint64) uint64 {
+	max := r.max()
+	if hi > max {
+		hi = max
+	}
+	if lo > hi {
+		return 0
+	}
+	if lo == 0 && hi == max {
+		return r.count
+	}
+
+	var sum uint64
+	for {
+		// Take the longest interval starting at lo within the range.
+		i := uint(0)
+		for i+1 < r.bits && lo&(1<<i) == 0 && lo+(1<<(i+1))-1 <= hi {
+			i++
+		}
+		sum += r.levels[i].Count(rangeKey(lo >> i))
+
+		if lo+(1<<i)-1 >= hi {
+			break
+		}
+		lo += 1 << i
+	}
+
+	if sum > r.count {
+		return r.count
+	}
+	return sum
+}
+
+// Quantile returns an approximate q-quantile of the values added, for q between
+// 0 and 1, the smallest value for which the number of values added up to it is
+// estimated at least q times the number of values added. Since the estimates
+// never underestimate, neither does the rank of the quantile returned, which is
+// within bits*epsilon times the number of values added of q times it with
+// probability 1-delta. Returns 0 if no values were added.
+func (r *RangeCountMinSketch) Quantile(q float64) uint64 {
+	rank := uint64(math.Ceil(math.Max(0, math.Min(1, q)) * float64(r.count)))
+	if rank == 0 {
+		rank = 1
+	}
+
+	// Descend from the whole domain into the half holding the rank.
+	var prefix uint64
+	for i := int(r.bits) - 1; i >= 0; i-- {
+		left := prefix << 1
+		if count := r.levels[i].Count(rangeKey(left)); rank > count {
+			rank -= count
+			prefix = left | 1
+		} else {
+			prefix = left
+		}
+	}
+	return prefix
+}
+
+// Merge combines this RangeCountMinSketch with another created with the same
+// bits, epsilon, delta, and seed, such as to combine sketches built on
+// different shards. Returns an error if they are incompatible, in which case
+// this sketch is unchanged.
+func (r *RangeCountMinSketch) Merge(other *RangeCountMinSketch) error {
+	if r.bits != other.bits {
+		return errors.New("bits must match")
+	}
+
+	// The sketches of every level have the same parameters, so if the first
+	// ones merge, so do the rest.
+	for i, level := range r.levels {
+		if err := level.Merge(other.levels[i]); err != nil {
+			return err
+		}
+	}
+	r.count += other.count
+	return nil
+}
+
+// Equal returns true if the other RangeCountMinSketch has the same bits,
+// number of values added, and sketches. The hash functions aren't compared.
+func (r *RangeCountMinSketch) Equal(other *RangeCountMinSketch) bool {
+	if r.bits != other.bits || r.count != other.count {
+		return false
+	}
+	for i, level := range r.levels {
+		if !level.Equal(other.levels[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the RangeCountMinSketch, which can be
+// used concurrently with the original.
+func (r *RangeCountMinSketch) Clone() *RangeCountMinSketch {
+	levels := make([]*CountMinSketch, len(r.levels))
+	for i, level := range r.levels {
+		levels[i] = level.Clone()
+	}
+	return &RangeCountMinSketch{levels: levels, bits: r.bits, count: r.count}
+}
+
+// ByteSize returns the number of bytes used by the sketches and metadata,
+// excluding the hash functions.
+func (r *RangeCountMinSketch) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*r)) + uint64(cap(r.levels))*pointerSize
+	for _, level := range r.levels {
+		size += level.ByteSize()
+	}
+	return size
+}
+
+// Reset restores the RangeCountMinSketch to its original state. It returns
+// itself to allow for chaining.
+func (r *RangeCountMinSketch) Reset() *RangeCountMinSketch {
+	for _, level := range r.levels {
+		level.Reset()
+	}
+	r.count = 0
+	return r
+}
+
+// SetHash sets the hashing function used by the sketches.
+func (r *RangeCountMinSketch) SetHash(h hash.Hash64) {
+	for _, level := range r.levels {
+		level.SetHash(h)
+	}
+}
+
+// max returns the largest value in the domain.
+func (r *RangeCountMinSketch) max() uint64 {
+	return math.MaxUint64 >> (64 - r.bits)
+}
+
+// rangeKey returns the data hashed for the interval.
+func rangeKey(interval uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], interval)
+	return buf[:]
+}
+
+// WriteTo writes a binary representation of the RangeCountMinSketch to an I/O
+// stream. The hash function is not written, but the seed is. It returns the
+// number of bytes written.
+func (r *RangeCountMinSketch) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(r.bits))
+	e.write(r.count)
+	for _, level := range r.levels {
+		e.writeTo(level)
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a RangeCountMinSketch (such as
+// might have been written by WriteTo()) from an I/O stream. The sketch keeps
+// its current hash function, which must match the one used by the sketch that
+// was written, or uses the default one if it has none. Returns
+// ErrUnsupportedVersion if the data was written by an incompatible version of
+// the package. It returns the number of bytes read.
+func (r *RangeCountMinSketch) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d           = decoder{r: payload}
+		bits, count uint64
+		hash        hash.Hash64
+	)
+	d.read(&bits)
+	d.read(&count)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if bits == 0 || bits > 64 {
+		return n, errors.New("invalid sketch parameters")
+	}
+	if len(r.levels) > 0 {
+		hash = r.levels[0].hash
+	}
+	if hash == nil {
+		hash = newFNV64()
+	}
+
+	levels := make([]*CountMinSketch, 0, bits)
+	for i := uint64(0); i < bits && d.err == nil; i++ {
+		level := &CountMinSketch{hash: hash}
+		d.readFrom(level)
+		levels = append(levels, level)
+	}
+	if d.err != nil {
+		return n, d.err
+	}
+
+	r.levels = levels
+	r.bits = uint(bits)
+	r.count = count
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (r *RangeCountMinSketch) MarshalBinary() ([]byte, error) {
+	return marshalBinary(r)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (r *RangeCountMinSketch) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(r, data)
+}
@@ -0,0 +1,98 @@
+package boom
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// Ensures that range estimates never underestimate and are within their error
+// bound, and that quantiles have about the expected rank.
+func TestRangeCountMinSketch(t *testing.T) {
+	var (
+		r      = NewRangeCountMinSketch(16, 0.001, 0.01, WithSeed(4))
+		rng    = rand.New(rand.NewSource(1))
+		counts = make([]uint64, 1<<16)
+	)
+	for i := 0; i < 20000; i++ {
+		value := uint64(rng.ExpFloat64() * 500)
+		if value >= 1<<16 {
+			value = 1<<16 - 1
+		}
+		r.Add(value)
+		counts[value]++
+	}
+	if r.TotalCount() != 20000 || r.Bits() != 16 {
+		t.Fatalf("Expected 20000 and 16, got %d and %d", r.TotalCount(), r.Bits())
+	}
+
+	bound := uint64(2 * 16 * 0.001 * 20000)
+	for _, bounds := range [][2]uint64{{0, 0}, {100, 200}, {0, 499}, {250, 1<<16 - 1}, {3, 70000}, {1000, 999}} {
+		var exact uint64
+		for v := bounds[0]; v <= bounds[1] && v < 1<<16; v++ {
+			exact += counts[v]
+		}
+		if estimate := r.EstimateRange(bounds[0], bounds[1]); estimate < exact || estimate > exact+bound {
+			t.Errorf("Expected between %d and %d for %v, got %d", exact, exact+bound, bounds, estimate)
+		}
+	}
+	if count := r.Count(7); count < counts[7] {
+		t.Errorf("Expected at least %d, got %d", counts[7], count)
+	}
+
+	for _, q := range []float64{0.1, 0.5, 0.99} {
+		var (
+			quantile = r.Quantile(q)
+			rank     uint64
+		)
+		for v := uint64(0); v <= quantile; v++ {
+			rank += counts[v]
+		}
+		if target := uint64(q * 20000); rank+bound/2 < target || rank > target+bound/2 {
+			t.Errorf("Expected a rank of about %d for %f, got %d", target, q, rank)
+		}
+	}
+}
+
+// Ensures that merged sketches equal a sketch of all the values, and that a
+// sketch read from its written representation is equal to it.
+func TestRangeCountMinSketchMergeWriteTo(t *testing.T) {
+	var (
+		all    = NewRangeCountMinSketch(8, 0.01, 0.01, WithSeed(1))
+		shards = []*RangeCountMinSketch{
+			NewRangeCountMinSketch(8, 0.01, 0.01, WithSeed(1)),
+			NewRangeCountMinSketch(8, 0.01, 0.01, WithSeed(1)),
+		}
+	)
+	for i := uint64(0); i < 1000; i++ {
+		all.Add(i % 300)
+		shards[i%2].Add(i % 300)
+	}
+	if err := shards[0].Merge(shards[1]); err != nil {
+		t.Fatal(err)
+	}
+	if !shards[0].Equal(all) {
+		t.Error("Expected the merged sketch to equal the sketch of all the values")
+	}
+	if err := all.Merge(NewRangeCountMinSketch(9, 0.01, 0.01, WithSeed(1))); err == nil {
+		t.Error("Expected an error for different bits")
+	}
+	if err := all.Merge(NewRangeCountMinSketch(8, 0.01, 0.01)); err == nil || all.TotalCount() != 1000 {
+		t.Error("Expected an error for different seeds")
+	}
+
+	var buf bytes.Buffer
+	if _, err := all.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var read RangeCountMinSketch
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !read.Equal(all) || read.EstimateRange(10, 20) != all.EstimateRange(10, 20) {
+		t.Error("Expected the sketches to be equal")
+	}
+	if read.Reset().TotalCount() != 0 || read.EstimateRange(0, 100) != 0 {
+		t.Error("Expected an empty sketch")
+	}
+}
@@ -0,0 +1,264 @@
+package boom
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Parameters of Redis's HyperLogLog implementation.
+const (
+	redisHLLMagic     = "HYLL"
+	redisHLLP         = 14             // bits of the hash used as register index
+	redisHLLQ         = 64 - redisHLLP // bits of the hash used to count zeros
+	redisHLLRegisters = 1 << redisHLLP // number of registers
+	redisHLLBits      = 6              // bits per register in the dense encoding
+	redisHLLMax       = 1<<redisHLLBits - 1
+	redisHLLHeader    = 16         // magic, encoding, unused, cached cardinality
+	redisHLLDense     = 0          // dense encoding
+	redisHLLSparse    = 1          // sparse encoding
+	redisHLLSeed      = 0xadc83b19 // seed of MurmurHash64A
+	redisHLLDenseSize = (redisHLLRegisters*redisHLLBits + 7) / 8
+)
+
+// NewRedisHyperLogLog creates a new HyperLogLog which hashes and counts data
+// the same way as Redis's PFADD and PFCOUNT. It has Redis's 16384 registers,
+// indexed by the MurmurHash64A of the data, and Count uses Redis's estimator,
+// so sketches built in Go and in Redis can be merged and compared directly.
+// Use WriteRedisTo and ReadRedisFrom to exchange sketches with Redis's GET and
+// SET. SetHash has no effect on a HyperLogLog using this scheme.
+func NewRedisHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{
+		registers: make([]uint8, redisHLLRegisters),
+		m:         redisHLLRegisters,
+		b:         redisHLLP,
+		alpha:     calculateAlpha(redisHLLRegisters),
+		hash:      newFNV32(),
+		redis:     true,
+	}
+}
+
+// WriteRedisTo writes Redis's dense HyperLogLog representation to an I/O
+// stream. Storing it in a Redis key with SET makes it usable with PFCOUNT and
+// PFMERGE. The cached cardinality is marked as stale, so Redis recomputes it.
+// Returns an error if the HyperLogLog wasn't created with NewRedisHyperLogLog
+// or read with ReadRedisFrom. It returns the number of bytes written.
+func (h *HyperLogLog) WriteRedisTo(stream io.Writer) (int64, error) {
+	if !h.redis {
+		return 0, errors.New("HyperLogLog doesn't use the Redis hashing scheme")
+	}
+
+	data := make([]byte, redisHLLHeader+redisHLLDenseSize)
+	copy(data, redisHLLMagic)
+	data[4] = redisHLLDense
+	data[15] = 1 << 7 // stale cached cardinality
+	dense := data[redisHLLHeader:]
+	for i, r := range h.registers {
+		setRedisRegister(dense, i, r)
+	}
+
+	n, err := stream.Write(data)
+	return int64(n), err
+}
+
+// ReadRedisFrom reads a HyperLogLog in Redis's dense or sparse representation,
+// such as returned by GET on a key written with PFADD, from an I/O stream. The
+// HyperLogLog is replaced by one using the Redis hashing scheme. It returns
+// the number of bytes read.
+func (h *HyperLogLog) ReadRedisFrom(stream io.Reader) (int64, error) {
+	var header [redisHLLHeader]byte
+	n, err := io.ReadFull(stream, header[:])
+	if err != nil {
+		return int64(n), err
+	}
+
+	if string(header[:4]) != redisHLLMagic {
+		return int64(n), ErrInvalidFormat
+	}
+
+	var (
+		registers = make([]uint8, redisHLLRegisters)
+		m         int
+	)
+	switch header[4] {
+	case redisHLLDense:
+		dense := make([]byte, redisHLLDenseSize)
+		m, err = io.ReadFull(stream, dense)
+		if err != nil {
+			return int64(n + m), err
+		}
+		for i := range registers {
+			registers[i] = redisRegister(dense, i)
+		}
+	case redisHLLSparse:
+		m, err = readRedisSparse(stream, registers)
+		if err != nil {
+			return int64(n + m), err
+		}
+	default:
+		return int64(n), ErrUnsupportedVersion
+	}
+
+	if h.hash == nil {
+		h.hash = newFNV32()
+	}
+	h.registers = registers
+	h.pairs = nil
+	h.sparseLimit = 0
+	h.m = redisHLLRegisters
+	h.b = redisHLLP
+	h.alpha = calculateAlpha(redisHLLRegisters)
+	h.redis = true
+	return int64(n + m), nil
+}
+
+// readRedisSparse decodes the opcodes of Redis's sparse representation into
+// the registers. It returns the number of bytes read.
+func readRedisSparse(stream io.Reader, registers []uint8) (int, error) {
+	var (
+		op [2]byte
+		n  = 0
+		i  = 0
+	)
+	for i < len(registers) {
+		m, err := io.ReadFull(stream, op[:1])
+		n += m
+		if err != nil {
+			return n, err
+		}
+
+		var run, value int
+		switch {
+		case op[0]&0xc0 == 0: // ZERO: 00xxxxxx
+			run = int(op[0]&0x3f) + 1
+		case op[0]&0xc0 == 0x40: // XZERO: 01xxxxxx yyyyyyyy
+			m, err = io.ReadFull(stream, op[1:])
+			n += m
+			if err != nil {
+				return n, err
+			}
+			run = (int(op[0]&0x3f)<<8 | int(op[1])) + 1
+		default: // VAL: 1vvvvvxx
+			value = int(op[0]>>2&0x1f) + 1
+			run = int(op[0]&0x3) + 1
+		}
+
+		if i+run > len(registers) {
+			return n, errors.New("sparse representation exceeds registers")
+		}
+		for ; run > 0; run-- {
+			registers[i] = uint8(value)
+			i++
+		}
+	}
+
+	return n, nil
+}
+
+// redisRegister returns register i of Redis's dense representation.
+func redisRegister(dense []byte, i int) uint8 {
+	var (
+		offset = i * redisHLLBits / 8
+		shift  = uint(i * redisHLLBits & 7)
+		v      = uint(dense[offset]) >> shift
+	)
+	if offset+1 < len(dense) {
+		v |= uint(dense[offset+1]) << (8 - shift)
+	}
+	return uint8(v & redisHLLMax)
+}
+
+// setRedisRegister sets register i of Redis's dense representation.
+func setRedisRegister(dense []byte, i int, r uint8) {
+	var (
+		offset = i * redisHLLBits / 8
+		shift  = uint(i * redisHLLBits & 7)
+		v      = uint(r) & redisHLLMax
+	)
+	dense[offset] &^= byte(redisHLLMax << shift)
+	dense[offset] |= byte(v << shift)
+	if offset+1 < len(dense) {
+		dense[offset+1] &^= byte(redisHLLMax >> (8 - shift))
+		dense[offset+1] |= byte(v >> (8 - shift))
+	}
+}
+
+// redisIndex returns the register index and count of zeros plus one for the
+// data, as computed by Redis's hllPatLen.
+func redisIndex(data []byte) (uint, uint8) {
+	hash := murmur64A(data, redisHLLSeed)
+	index := uint(hash & (redisHLLRegisters - 1))
+	hash >>= redisHLLP
+	hash |= 1 << redisHLLQ
+
+	count := uint8(1)
+	for hash&1 == 0 {
+		count++
+		hash >>= 1
+	}
+	return index, count
+}
+
+// redisCount returns the cardinality estimated by Redis's PFCOUNT, which uses
+// Ertl's improved estimator on the registers of Redis's 50-bit counts of
+// zeros.
+func (h *HyperLogLog) redisCount() uint64 {
+	var histogram [redisHLLQ + 2]int
+	for _, r := range h.registers {
+		if int(r) < len(histogram) {
+			histogram[r]++
+		}
+	}
+	return ertlEstimate(histogram[:], float64(h.m))
+}
+
+// murmur64A returns the 64-bit MurmurHash64A of the data using the given
+// seed, as used by Redis.
+func murmur64A(data []byte, seed uint64) uint64 {
+	const (
+		m = 0xc6a4a7935bd1e995
+		r = 47
+	)
+
+	h := seed ^ (uint64(len(data)) * m)
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		data = data[8:]
+
+		k *= m
+		k ^= k >> r
+		k *= m
+
+		h ^= k
+		h *= m
+	}
+
+	switch len(data) {
+	case 7:
+		h ^= uint64(data[6]) << 48
+		fallthrough
+	case 6:
+		h ^= uint64(data[5]) << 40
+		fallthrough
+	case 5:
+		h ^= uint64(data[4]) << 32
+		fallthrough
+	case 4:
+		h ^= uint64(data[3]) << 24
+		fallthrough
+	case 3:
+		h ^= uint64(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint64(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint64(data[0])
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
@@ -0,0 +1,180 @@
+package boom
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+// Ensures that a Redis HyperLogLog approximates the cardinality within
+// Redis's standard error of 0.81%.
+func TestRedisHyperLogLogCount(t *testing.T) {
+	hll := NewRedisHyperLogLog()
+	if count := hll.Count(); count != 0 {
+		t.Errorf("Expected 0, got %d", count)
+	}
+
+	for i := 0; i < 100000; i++ {
+		hll.Add([]byte(strconv.Itoa(i)))
+	}
+
+	if count := hll.Count(); count < 97000 || count > 103000 {
+		t.Errorf("Expected about 100000, got %d", count)
+	}
+}
+
+// Ensures that redisIndex derives registers from the MurmurHash64A of the data.
+func TestRedisIndex(t *testing.T) {
+	hash := murmur64A([]byte(`a`), redisHLLSeed)
+	index, count := redisIndex([]byte(`a`))
+
+	if index != uint(hash&(redisHLLRegisters-1)) {
+		t.Errorf("Expected %d, got %d", hash&(redisHLLRegisters-1), index)
+	}
+
+	if count < 1 || count > redisHLLQ+1 {
+		t.Errorf("Expected count between 1 and %d, got %d", redisHLLQ+1, count)
+	}
+}
+
+// Ensures that WriteRedisTo writes the dense representation and ReadRedisFrom
+// restores the same registers.
+func TestRedisHyperLogLogDense(t *testing.T) {
+	hll := NewRedisHyperLogLog()
+	for i := 0; i < 10000; i++ {
+		hll.Add([]byte(strconv.Itoa(i)))
+	}
+
+	var buf bytes.Buffer
+	n, err := hll.WriteRedisTo(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if n != redisHLLHeader+redisHLLDenseSize {
+		t.Errorf("Expected %d bytes, got %d", redisHLLHeader+redisHLLDenseSize, n)
+	}
+
+	data := buf.Bytes()
+	if string(data[:4]) != redisHLLMagic || data[4] != redisHLLDense {
+		t.Errorf("Expected dense HYLL header, got %v", data[:5])
+	}
+
+	// Register 1 occupies the upper two bits of byte 0 and the lower four of
+	// byte 1.
+	dense := data[redisHLLHeader:]
+	if r := (dense[0]>>6 | dense[1]<<2) & redisHLLMax; r != hll.registers[1] {
+		t.Errorf("Expected register 1 to be %d, got %d", hll.registers[1], r)
+	}
+
+	other, _ := NewHyperLogLog(16)
+	m, err := other.ReadRedisFrom(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if m != n {
+		t.Errorf("Expected %d bytes read, got %d", n, m)
+	}
+
+	if !bytes.Equal(other.registers, hll.registers) {
+		t.Error("Expected registers to match")
+	}
+
+	if other.Count() != hll.Count() {
+		t.Errorf("Expected %d, got %d", hll.Count(), other.Count())
+	}
+}
+
+// Ensures that ReadRedisFrom decodes the sparse representation written by
+// PFADD for small cardinalities.
+func TestRedisHyperLogLogSparse(t *testing.T) {
+	// VAL(3, 2) at registers 0 and 1, ZERO(3), VAL(1, 1), then XZERO for the
+	// remaining 16378 registers.
+	data := append([]byte(redisHLLMagic), redisHLLSparse, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	data = append(data, 0x80|2<<2|1, 0x02, 0x80, 0x40|16377>>8, 16377&0xff)
+
+	hll := NewRedisHyperLogLog()
+	if _, err := hll.ReadRedisFrom(bytes.NewReader(data)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[int]uint8{0: 3, 1: 3, 5: 1}
+	for i, r := range hll.registers {
+		if r != expected[i] {
+			t.Errorf("Expected register %d to be %d, got %d", i, expected[i], r)
+		}
+	}
+
+	if count := hll.Count(); count != 3 {
+		t.Errorf("Expected 3, got %d", count)
+	}
+
+	// A run past the last register is rejected.
+	data = append(data[:redisHLLHeader], 0x00, 0x7f, 0xff)
+	if _, err := hll.ReadRedisFrom(bytes.NewReader(data)); err == nil {
+		t.Error("Expected error for overlong sparse representation")
+	}
+}
+
+// Ensures that ReadRedisFrom rejects data without the HYLL magic and that
+// WriteRedisTo rejects HyperLogLogs using a different hashing scheme.
+func TestRedisHyperLogLogInvalid(t *testing.T) {
+	hll := NewRedisHyperLogLog()
+	data := make([]byte, redisHLLHeader)
+	if _, err := hll.ReadRedisFrom(bytes.NewReader(data)); err != ErrInvalidFormat {
+		t.Errorf("Expected ErrInvalidFormat, got %v", err)
+	}
+
+	plain, _ := NewHyperLogLog(redisHLLRegisters)
+	var buf bytes.Buffer
+	if _, err := plain.WriteRedisTo(&buf); err == nil {
+		t.Error("Expected error for non-Redis HyperLogLog")
+	}
+
+	if err := hll.Merge(plain); err == nil {
+		t.Error("Expected error for mismatched hashing schemes")
+	}
+}
+
+// Ensures that the Redis hashing scheme survives WriteTo and ReadFrom.
+func TestRedisHyperLogLogSerialization(t *testing.T) {
+	hll := NewRedisHyperLogLog()
+	hll.Add([]byte(`a`)).Add([]byte(`b`))
+
+	var buf bytes.Buffer
+	if _, err := hll.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	other, _ := NewHyperLogLog(16)
+	if _, err := other.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !other.redis {
+		t.Error("Expected Redis hashing scheme")
+	}
+
+	if other.Count() != 2 {
+		t.Errorf("Expected 2, got %d", other.Count())
+	}
+}
+
+// Ensures that ReadFrom rejects the Redis hashing scheme for a HyperLogLog
+// without Redis's number of registers, which it would index out of range.
+func TestRedisHyperLogLogReadFromInvalid(t *testing.T) {
+	var e encoder
+	e.write(uint64(16))
+	e.write(make([]uint8, 16))
+	e.write(uint8(1))
+	e.write(uint64(0))
+
+	var buf bytes.Buffer
+	if _, err := writeFrame(&buf, e.buf.Bytes()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := (&HyperLogLog{}).ReadFrom(&buf); err == nil {
+		t.Error("Expected error for a Redis HyperLogLog of 16 registers")
+	}
+}
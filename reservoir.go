@@ -0,0 +1,265 @@
+package boom
+
+import (
+	"container/heap"
+	"errors"
+	"io"
+	"math"
+	"unsafe"
+)
+
+// reservoirItem is an item kept by a ReservoirSample.
+type reservoirItem struct {
+	data []byte
+	key  float64 // log of the random key, the largest are kept
+}
+
+// A reservoirHeap is a min-heap of items by key.
+type reservoirHeap []*reservoirItem
+
+func (r reservoirHeap) Len() int           { return len(r) }
+func (r reservoirHeap) Less(i, j int) bool { return r[i].key < r[j].key }
+func (r reservoirHeap) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+func (r *reservoirHeap) Push(x interface{}) {
+	*r = append(*r, x.(*reservoirItem))
+}
+
+func (r *reservoirHeap) Pop() interface{} {
+	old := *r
+	n := len(old)
+	x := old[n-1]
+	*r = old[0 : n-1]
+	return x
+}
+
+// ReservoirSample keeps a random sample of k items from a stream of unknown
+// length, using the weighted sampling without replacement described by
+// Efraimidis and Spirakis in Weighted random sampling with a reservoir:
+//
+// https://doi.org/10.1016/j.ipl.2005.11.003
+//
+// Each item is given a random key u^(1/w) for a uniform u in (0, 1) and its
+// weight w, and the k items with the largest keys are kept, so the sample is
+// drawn with probabilities proportional to the weights. Items added with Add
+// have a weight of one, and the sample is uniform: every subset of k items is
+// equally likely, as with Knuth's Algorithm R. Unlike Algorithm R, samples of
+// different streams merge into a sample of the combined stream by keeping the
+// largest keys of both, such as to combine samples taken on different
+// shards.
+type ReservoirSample struct {
+	items  reservoirHeap // sampled items
+	k      uint          // number of items sampled
+	n      uint64        // number of items added
+	source *splitMix64   // source of randomness for the keys
+}
+
+// NewReservoirSample creates a new ReservoirSample of k items, at least 1. Its
+// keys are drawn from a source seeded by WithSeed, or from the default source
+// if no seed is set.
+func NewReservoirSample(k uint, opts ...Option) *ReservoirSample {
+	if k == 0 {
+		k = 1
+	}
+	return &ReservoirSample{
+		items:  make(reservoirHeap, 0, k),
+		k:      k,
+		source: applyOptions(opts).source(),
+	}
+}
+
+// K returns the number of items sampled.
+func (r *ReservoirSample) K() uint {
+	return r.k
+}
+
+// TotalCount returns the number of items added.
+func (r *ReservoirSample) TotalCount() uint64 {
+	return r.n
+}
+
+// Add will add the data to the stream with a weight of one, replacing the
+// sampled item with the smallest key if its key is larger. Returns the
+// ReservoirSample to allow for chaining.
+func (r *ReservoirSample) Add(data []byte) *ReservoirSample {
+	return r.AddWeighted(data, 1)
+}
+
+// AddWeighted will add the data to the stream with the weight, such that it's
+// sampled with a probability proportional to the weight. Items with a weight
+// of zero or less are counted but never sampled. Returns the ReservoirSample
+// to allow for chaining.
+func (r *ReservoirSample) AddWeighted(data []byte, weight float64) *ReservoirSample {
+	r.n++
+	if !(weight > 0) {
+		return r
+	}
+
+	// The log of u^(1/w) orders the keys the same without underflowing.
+	u := (float64(r.source.Uint64()>>11) + 0.5) / (1 << 53)
+	r.offer(data, math.Log(u)/weight, true)
+	return r
+}
+
+// offer keeps the item with the key if the sample isn't full or the key is
+// larger than the smallest, copying the data if needed.
+func (r *ReservoirSample) offer(data []byte, key float64, copyData bool) {
+	if uint(len(r.items)) == r.k && key <= r.items[0].key {
+		return
+	}
+	if copyData {
+		data = append([]byte(nil), data...)
+	}
+
+	if uint(len(r.items)) < r.k {
+		heap.Push(&r.items, &reservoirItem{data: data, key: key})
+		return
+	}
+	r.items[0] = &reservoirItem{data: data, key: key}
+	heap.Fix(&r.items, 0)
+}
+
+// Sample returns the sampled items in no particular order, fewer than k if
+// fewer were added.
+func (r *ReservoirSample) Sample() [][]byte {
+	sample := make([][]byte, len(r.items))
+	for i, item := range r.items {
+		sample[i] = item.data
+	}
+	return sample
+}
+
+// Merge combines this ReservoirSample with another of the same number of
+// items, so it's a sample of both streams, keeping the items with the largest
+// keys of both. The other sample is unchanged. Returns an error if they
+// sample a different number of items.
+func (r *ReservoirSample) Merge(other *ReservoirSample) error {
+	if r.k != other.k {
+		return errors.New("number of items must match")
+	}
+
+	for _, item := range other.items {
+		r.offer(item.data, item.key, true)
+	}
+	r.n += other.n
+	return nil
+}
+
+// Reset restores the ReservoirSample to its original state. It returns itself
+// to allow for chaining.
+func (r *ReservoirSample) Reset() *ReservoirSample {
+	r.items = r.items[:0]
+	r.n = 0
+	return r
+}
+
+// Clone returns an independent copy of the ReservoirSample, which can be used
+// concurrently with the original. The copy's source of randomness continues
+// from the same state, so it samples the same items as the original if they
+// are added the same items.
+func (r *ReservoirSample) Clone() *ReservoirSample {
+	source := *r.source
+	clone := &ReservoirSample{
+		items:  make(reservoirHeap, len(r.items), cap(r.items)),
+		k:      r.k,
+		n:      r.n,
+		source: &source,
+	}
+	for i, item := range r.items {
+		clone.items[i] = &reservoirItem{data: append([]byte(nil), item.data...), key: item.key}
+	}
+	return clone
+}
+
+// ByteSize returns the number of bytes used by the sampled items and metadata
+// of the ReservoirSample.
+func (r *ReservoirSample) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*r)) + uint64(unsafe.Sizeof(*r.source)) + uint64(cap(r.items))*pointerSize
+	for _, item := range r.items {
+		size += uint64(unsafe.Sizeof(*item)) + uint64(cap(item.data))
+	}
+	return size
+}
+
+// WriteTo writes a binary representation of the ReservoirSample to an I/O
+// stream, including the sampled items with their keys and the state of the
+// source of randomness. It returns the number of bytes written.
+func (r *ReservoirSample) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(r.k))
+	e.write(r.n)
+	e.write(r.source.state)
+	e.write(uint64(len(r.items)))
+	for _, item := range r.items {
+		e.write(item.key)
+		e.writeBytes(item.data)
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a ReservoirSample (such as might
+// have been written by WriteTo()) from an I/O stream, restoring the state of
+// its source of randomness, so adding to the sample afterwards updates it
+// exactly as if it had never been written. Returns ErrUnsupportedVersion if
+// the data was written by an incompatible version of the package. It returns
+// the number of bytes read.
+func (r *ReservoirSample) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                  = decoder{r: payload}
+		k, count, state, l uint64
+	)
+	d.read(&k)
+	d.read(&count)
+	d.read(&state)
+	d.read(&l)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if k == 0 || l > k {
+		return n, errors.New("invalid sample parameters")
+	}
+	// Every item takes at least 16 bytes for its key and length.
+	if l > uint64(payload.Len())/16 {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	items := make(reservoirHeap, 0, l)
+	for i := uint64(0); i < l && d.err == nil; i++ {
+		item := &reservoirItem{}
+		d.read(&item.key)
+		item.data = d.readBytes()
+		items = append(items, item)
+	}
+	if d.err != nil {
+		return n, d.err
+	}
+	heap.Init(&items)
+
+	r.items = items
+	r.k = uint(k)
+	r.n = count
+	r.source = &splitMix64{state: state}
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (r *ReservoirSample) MarshalBinary() ([]byte, error) {
+	return marshalBinary(r)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (r *ReservoirSample) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(r, data)
+}
@@ -0,0 +1,139 @@
+package boom
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// Ensures that ReservoirSample samples each item of a uniform stream about
+// equally often and weighted items in proportion to their weights.
+func TestReservoirSample(t *testing.T) {
+	counts := make(map[string]int)
+	for trial := uint64(1); trial <= 1000; trial++ {
+		r := NewReservoirSample(10, WithSeed(trial))
+		for i := 0; i < 100; i++ {
+			r.Add([]byte(strconv.Itoa(i)))
+		}
+		sample := r.Sample()
+		if len(sample) != 10 || r.TotalCount() != 100 {
+			t.Fatalf("Expected 10 of 100 items, got %d of %d", len(sample), r.TotalCount())
+		}
+		for _, data := range sample {
+			counts[string(data)]++
+		}
+	}
+	// Each item is sampled with a probability of 0.1, 100 times in 1000 on
+	// average.
+	for i := 0; i < 100; i++ {
+		if count := counts[strconv.Itoa(i)]; count < 60 || count > 140 {
+			t.Errorf("Expected %d to be sampled about 100 times, got %d", i, count)
+		}
+	}
+
+	heavy := 0
+	for trial := uint64(1); trial <= 2000; trial++ {
+		r := NewReservoirSample(1, WithSeed(trial))
+		r.AddWeighted([]byte(`heavy`), 99)
+		for i := 0; i < 99; i++ {
+			r.Add([]byte(strconv.Itoa(i)))
+		}
+		r.AddWeighted([]byte(`never`), 0)
+		if sample := r.Sample(); string(sample[0]) == "heavy" {
+			heavy++
+		}
+	}
+	if p := float64(heavy) / 2000; math.Abs(p-0.5) > 0.05 {
+		t.Errorf("Expected heavy to be sampled about half the time, got %f", p)
+	}
+}
+
+// Ensures that merging samples keeps k items of both streams.
+func TestReservoirSampleMerge(t *testing.T) {
+	a, b := NewReservoirSample(50, WithSeed(1)), NewReservoirSample(50, WithSeed(2))
+	for i := 0; i < 1000; i++ {
+		a.Add([]byte("a" + strconv.Itoa(i)))
+		b.Add([]byte("b" + strconv.Itoa(i)))
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if a.TotalCount() != 2000 || len(a.Sample()) != 50 {
+		t.Errorf("Expected 50 of 2000 items, got %d of %d", len(a.Sample()), a.TotalCount())
+	}
+
+	fromB := 0
+	for _, data := range a.Sample() {
+		if data[0] == 'b' {
+			fromB++
+		}
+	}
+	if fromB < 10 || fromB > 40 {
+		t.Errorf("Expected about half the items from b, got %d", fromB)
+	}
+	if err := a.Merge(NewReservoirSample(10)); err == nil {
+		t.Error("Expected an error for different numbers of items")
+	}
+	if a.Reset().TotalCount() != 0 || len(a.Sample()) != 0 {
+		t.Error("Expected an empty sample")
+	}
+}
+
+// Ensures that a ReservoirSample read from its written representation
+// continues to sample like one which was never written.
+func TestReservoirSampleWriteToReadFrom(t *testing.T) {
+	expected := NewReservoirSample(5, WithSeed(3))
+	for i := 0; i < 100; i++ {
+		expected.Add([]byte(strconv.Itoa(i)))
+	}
+
+	var buf bytes.Buffer
+	if _, err := expected.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var read ReservoirSample
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	clone := expected.Clone()
+	for i := 100; i < 200; i++ {
+		data := []byte(strconv.Itoa(i))
+		expected.Add(data)
+		read.Add(data)
+		clone.Add(data)
+	}
+
+	want := expected.Sample()
+	for _, actual := range [][][]byte{read.Sample(), clone.Sample()} {
+		for i, data := range actual {
+			if !bytes.Equal(data, want[i]) {
+				t.Errorf("Expected %s, got %s", want[i], data)
+			}
+		}
+	}
+}
+
+// Ensures that ReadFrom allocates for the items read rather than for k, which
+// is unchecked, and rejects more items than the data can hold.
+func TestReservoirSampleReadFromLargeK(t *testing.T) {
+	for _, l := range []uint64{0, 1 << 59} {
+		var e encoder
+		e.write(uint64(1 << 60))
+		e.write(uint64(0))
+		e.write(uint64(1))
+		e.write(l)
+
+		var buf bytes.Buffer
+		if _, err := writeFrame(&buf, e.buf.Bytes()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		read := &ReservoirSample{}
+		_, err := read.ReadFrom(&buf)
+		if l == 0 && (err != nil || cap(read.items) != 0) {
+			t.Errorf("Expected no error and capacity 0, got %v", err)
+		} else if l != 0 && err == nil {
+			t.Error("Expected error for more items than the data holds")
+		}
+	}
+}
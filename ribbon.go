@@ -0,0 +1,352 @@
+package boom
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+	"unsafe"
+)
+
+const (
+	// ribbonWidth is the number of consecutive rows of the solution each key's
+	// equation spans, the width of its coefficients.
+	ribbonWidth = 64
+
+	// ribbonOverhead is the fraction of rows beyond the number of keys that
+	// RibbonFilter allocates on its first attempt at solving the equations.
+	ribbonOverhead = 0.05
+
+	// ribbonMaxAttempts is the number of construction seeds, each with more
+	// rows than the last, to try before giving up on building a RibbonFilter.
+	ribbonMaxAttempts = 32
+)
+
+// RibbonFilter is a static, read-only filter as described by Dillinger and
+// Walzer in Ribbon filter: practically smaller than Bloom and Xor:
+//
+// https://arxiv.org/abs/2103.02515
+//
+// Each key hashes to a fingerprint of r bits and to a linear equation over
+// GF(2) whose coefficients cover a band of 64 consecutive rows of an r-bit wide
+// solution: the xor of the rows selected by the coefficients must equal the
+// fingerprint. The equations of every key are solved together by Gaussian
+// elimination, which the band structure makes nearly linear in time, and
+// testing a key xors its rows and compares the result to its fingerprint.
+//
+// The solution needs 5 to 10% more rows than there are keys, growing slowly
+// with their number, where an XorFilter needs 23%, so a Ribbon filter takes
+// about 1.1 * log2(1/fpRate) bits per key, close to the lower bound of
+// log2(1/fpRate), and its false-positive rate isn't restricted to powers of
+// 1/256. Construction is
+// slower than an XorFilter's, making it best suited to large sets which are
+// built once and tested often. Like an XorFilter, it can't be modified once
+// built.
+type RibbonFilter struct {
+	words      []uint64    // solution, r words of one bit per row for every 64 rows
+	rows       uint64      // number of rows, a multiple of 64
+	r          uint        // number of fingerprint bits and solution columns
+	n          uint64      // number of distinct key hashes
+	ribbonSeed uint64      // seed mixed into key hashes by the construction
+	hash       hash.Hash64 // hash function of keys
+	seed       uint64      // hash seed (zero means unseeded)
+}
+
+// NewRibbonFilter creates a new RibbonFilter holding the keys with a specified
+// target false-positive rate, which is rounded down to a power of 1/2.
+// Duplicate keys are stored once. Returns an error if there are too many keys
+// or in the unlikely event that the equations of the keys can't be solved.
+func NewRibbonFilter(keys [][]byte, fpRate float64, opts ...Option) (*RibbonFilter, error) {
+	o := applyOptions(opts)
+	f := &RibbonFilter{
+		r:    ribbonBits(fpRate),
+		hash: o.newHash64(newFNV64),
+		seed: o.seed,
+	}
+	if err := f.Build(keys); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Build replaces the contents of the filter with the keys, keeping its
+// false-positive rate, hash function, and seed, so a filter can be rebuilt
+// from each new version of a set. Duplicate keys are stored once. Returns an
+// error, leaving the filter unchanged, if there are too many keys or in the
+// unlikely event that the equations of the keys can't be solved.
+func (f *RibbonFilter) Build(keys [][]byte) error {
+	// Keys with the same hash have the same equation, so the hashes are
+	// deduplicated first.
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = f.keyHash(key)
+	}
+	sort.Sort(uint64Slice(hashes))
+	distinct := hashes[:0]
+	for i, h := range hashes {
+		if i == 0 || h != hashes[i-1] {
+			distinct = append(distinct, h)
+		}
+	}
+	hashes = distinct
+	if uint64(len(hashes)) > math.MaxUint32 {
+		return errors.New("too many keys")
+	}
+
+	ribbonSeed := mix64(f.seed)
+	for attempt := 0; attempt < ribbonMaxAttempts; attempt++ {
+		// Each failed attempt adds rows, which makes the next more likely to
+		// succeed.
+		var (
+			overhead = ribbonOverhead * (1 + float64(attempt)/4)
+			rows     = (uint64(math.Ceil(float64(len(hashes))*(1+overhead))) + 2*ribbonWidth - 1) / ribbonWidth * ribbonWidth
+		)
+		if words := f.solve(hashes, rows, ribbonSeed); words != nil {
+			f.words = words
+			f.rows = rows
+			f.n = uint64(len(hashes))
+			f.ribbonSeed = ribbonSeed
+			return nil
+		}
+		ribbonSeed = mix64(ribbonSeed + 0x9e3779b97f4a7c15)
+	}
+	return errors.New("couldn't build filter")
+}
+
+// Count returns the number of distinct key hashes in the filter, which is the
+// number of distinct keys unless some of their hashes collide.
+func (f *RibbonFilter) Count() uint {
+	return uint(f.n)
+}
+
+// FingerprintBits returns the length of fingerprints in bits.
+func (f *RibbonFilter) FingerprintBits() uint {
+	return f.r
+}
+
+// EstimatedFPRate returns the probability that an absent item's rows xor to
+// its fingerprint, 2^-r.
+func (f *RibbonFilter) EstimatedFPRate() float64 {
+	return math.Ldexp(1, -int(f.r))
+}
+
+// ByteSize returns the number of bytes used by the solution and the metadata
+// of the filter, excluding the hash function.
+func (f *RibbonFilter) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*f)) + uint64(cap(f.words))*8
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. This is a probabilistic test, meaning there is a
+// non-zero probability of false positives but a zero probability of false
+// negatives.
+func (f *RibbonFilter) Test(data []byte) bool {
+	if f.rows == 0 {
+		return false
+	}
+
+	start, coeff, fingerprint := f.equation(mix64(f.keyHash(data)+f.ribbonSeed), f.rows)
+	var (
+		block  = start / ribbonWidth * uint64(f.r)
+		offset = start % ribbonWidth
+	)
+	for j := uint(0); j < f.r; j++ {
+		window := f.words[block+uint64(j)] >> offset
+		if offset != 0 {
+			window |= f.words[block+uint64(f.r)+uint64(j)] << (ribbonWidth - offset)
+		}
+		if uint32(bits.OnesCount64(window&coeff)&1) != fingerprint>>j&1 {
+			return false
+		}
+	}
+	return true
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (f *RibbonFilter) TestString(data string) bool {
+	return f.Test(stringBytes(data))
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+func (f *RibbonFilter) TestMany(data [][]byte) []bool {
+	return testMany(data, f.Test)
+}
+
+// SetHash sets the hashing function used to hash keys, which must be the one
+// the filter was created with.
+func (f *RibbonFilter) SetHash(h hash.Hash64) {
+	f.hash = h
+}
+
+// solve returns the solution with the given number of rows of the keys'
+// equations with the construction seed, or nil if the equations are
+// inconsistent, which requires another seed or more rows.
+func (f *RibbonFilter) solve(hashes []uint64, rows, ribbonSeed uint64) []uint64 {
+	var (
+		coeffs  = make([]uint64, rows)
+		results = make([]uint32, rows)
+	)
+
+	// Banding: reduce the equations to upper triangular form, each stored in
+	// the row of its leading coefficient.
+	for _, key := range hashes {
+		start, coeff, result := f.equation(mix64(key+ribbonSeed), rows)
+		for {
+			if coeffs[start] == 0 {
+				coeffs[start] = coeff
+				results[start] = result
+				break
+			}
+			coeff ^= coeffs[start]
+			result ^= results[start]
+			if coeff == 0 {
+				// The equation is a combination of others, which is only
+				// consistent if its result is too.
+				if result != 0 {
+					return nil
+				}
+				break
+			}
+			shift := uint(bits.TrailingZeros64(coeff))
+			start += uint64(shift)
+			coeff >>= shift
+		}
+	}
+
+	// Back substitution: solve the rows from last to first, keeping a window
+	// of the 64 rows following each in every column.
+	var (
+		words  = make([]uint64, rows/ribbonWidth*uint64(f.r))
+		window = make([]uint64, f.r)
+	)
+	for i := int64(rows) - 1; i >= 0; i-- {
+		block := uint64(i) / ribbonWidth * uint64(f.r)
+		for j := uint(0); j < f.r; j++ {
+			window[j] <<= 1
+			// Rows without an equation are free, and left zero.
+			bit := uint64(bits.OnesCount64(window[j]&coeffs[i])&1) ^ uint64(results[i]>>j&1)
+			if coeffs[i] == 0 {
+				bit = 0
+			}
+			window[j] |= bit
+			words[block+uint64(j)] |= bit << (uint64(i) % ribbonWidth)
+		}
+	}
+	return words
+}
+
+// equation returns the first row, coefficients, and fingerprint of the mixed
+// hash for a solution with the given number of rows. The first coefficient is
+// always set, so the equation covers its first row.
+func (f *RibbonFilter) equation(h, rows uint64) (uint64, uint64, uint32) {
+	starts := rows - ribbonWidth + 1
+	start := uint64(uint32(h>>32)) * starts >> 32
+	coeff := mix64(h^0x9e3779b97f4a7c15) | 1
+	fingerprint := uint32(h & (1<<f.r - 1))
+	return start, coeff, fingerprint
+}
+
+// keyHash returns the 64-bit hash of the key.
+func (f *RibbonFilter) keyHash(key []byte) uint64 {
+	lower, upper := seededHashKernel(key, f.hash, f.seed)
+	return uint64(upper)<<32 | uint64(lower)
+}
+
+// ribbonBits returns the number of fingerprint bits for the target
+// false-positive rate, between 1 and 32.
+func ribbonBits(fpRate float64) uint {
+	r := math.Ceil(math.Log2(1 / fpRate))
+	if r < 1 || math.IsNaN(r) {
+		return 1
+	}
+	if r > 32 {
+		return 32
+	}
+	return uint(r)
+}
+
+// WriteTo writes a binary representation of the RibbonFilter to an I/O
+// stream. The hash function is not written, but the seed is. It returns the
+// number of bytes written.
+func (f *RibbonFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(f.r))
+	e.write(f.n)
+	e.write(f.rows)
+	e.write(f.ribbonSeed)
+	e.write(f.seed)
+	e.write(f.words)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a RibbonFilter (such as might have
+// been written by WriteTo()) from an I/O stream. The filter keeps its current
+// hash function, which must match the one used by the filter that was
+// written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (f *RibbonFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                                = decoder{r: payload}
+		r, count, rows, ribbonSeed, seed uint64
+		h                                = f.hash
+	)
+	d.read(&r)
+	d.read(&count)
+	d.read(&rows)
+	d.read(&ribbonSeed)
+	d.read(&seed)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if r == 0 || r > 32 || rows == 0 || rows%ribbonWidth != 0 {
+		return n, errors.New("solution doesn't match filter")
+	}
+	// Every row takes r bits.
+	if rows/ribbonWidth > uint64(payload.Len())/8/r {
+		return n, io.ErrUnexpectedEOF
+	}
+	words := make([]uint64, rows/ribbonWidth*r)
+	d.read(words)
+	if d.err != nil {
+		return n, d.err
+	}
+	if h == nil {
+		h = newFNV64()
+	}
+
+	f.words = words
+	f.rows = rows
+	f.r = uint(r)
+	f.n = count
+	f.ribbonSeed = ribbonSeed
+	f.hash = h
+	f.seed = seed
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (f *RibbonFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(f)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo. If the
+// filter has no hash function, such as when it's the zero value, the default
+// hash function is used.
+func (f *RibbonFilter) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(f, data)
+}
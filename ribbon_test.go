@@ -0,0 +1,118 @@
+package boom
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+// Ensures that a RibbonFilter holds every key, has roughly the target
+// false-positive rate, and is smaller than an XorFilter with the same rate.
+func TestRibbonFilter(t *testing.T) {
+	keys := make([][]byte, 10000)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+	}
+
+	f, err := NewRibbonFilter(keys, 1.0/256)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Count() != 10000 || f.FingerprintBits() != 8 {
+		t.Errorf("Expected 10000 keys with 8-bit fingerprints, got %d with %d", f.Count(), f.FingerprintBits())
+	}
+
+	for _, key := range keys {
+		if !f.Test(key) {
+			t.Fatalf("Expected %s to be a member", key)
+		}
+	}
+
+	fp := 0
+	for i := 10000; i < 110000; i++ {
+		if f.TestString(strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 100000; rate > 2*f.EstimatedFPRate() {
+		t.Errorf("Expected false-positive rate near %f, got %f", f.EstimatedFPRate(), rate)
+	}
+
+	x, err := NewXorFilter(keys, 8)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if size, xor := uint64(len(f.words))*8, uint64(len(x.fingerprints)); size >= xor {
+		t.Errorf("Expected fewer than %d bytes, got %d", xor, size)
+	}
+}
+
+// Ensures that Build replaces the keys of a RibbonFilter, deduplicating them.
+func TestRibbonFilterBuild(t *testing.T) {
+	f, err := NewRibbonFilter(nil, 0.01, WithSeed(9))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Count() != 0 {
+		t.Errorf("Expected an empty filter, got %d keys", f.Count())
+	}
+
+	if err := f.Build([][]byte{[]byte(`a`), []byte(`b`), []byte(`a`)}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Count() != 2 || !f.Test([]byte(`a`)) || !f.Test([]byte(`b`)) {
+		t.Errorf("Expected 2 members, got %d", f.Count())
+	}
+	if f.FingerprintBits() != 7 {
+		t.Errorf("Expected 7-bit fingerprints, got %d", f.FingerprintBits())
+	}
+}
+
+// Ensures that a RibbonFilter read by ReadFrom reports the same members as the
+// one written by WriteTo.
+func TestRibbonFilterReadWrite(t *testing.T) {
+	keys := make([][]byte, 1000)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+	}
+	f, err := NewRibbonFilter(keys, 0.001, WithSeed(3))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data := append([]byte(nil), buf.Bytes()...)
+
+	restored := &RibbonFilter{}
+	if _, err := restored.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 0; i < 5000; i++ {
+		key := []byte(strconv.Itoa(i))
+		if restored.Test(key) != f.Test(key) {
+			t.Errorf("Expected restored membership of %d to match original", i)
+		}
+	}
+
+	if err := restored.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Error("Expected error for truncated data")
+	}
+}
+
+func BenchmarkRibbonFilterTest(b *testing.B) {
+	keys := make([][]byte, 100000)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+	}
+	f, err := NewRibbonFilter(keys, 0.01)
+	if err != nil {
+		b.Fatalf("Unexpected error: %v", err)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		f.Test(keys[n%len(keys)])
+	}
+}
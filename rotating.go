@@ -0,0 +1,97 @@
+package boom
+
+// RotatingFilter maintains an active filter and a standby filter holding the
+// items of the previous period, the classic pattern for memory-bounded
+// deduplication. Items are added to the active filter and tested against both,
+// and Rotate, called by the caller on its own schedule, such as hourly or once
+// the active filter reaches a fill ratio, retires the standby filter and makes
+// the active filter the standby. An item is therefore remembered for at least
+// one full period and forgotten after two, and the false-positive rate is at
+// most the sum of those of the two filters.
+//
+// A RotatingFilter isn't safe for concurrent use. Unlike SafeFilter, Rotate
+// changes which filters are used, so calls must be serialized by the caller.
+type RotatingFilter struct {
+	active  Filter        // filter receiving additions
+	standby Filter        // filter of the previous period
+	factory func() Filter // creates new filters
+}
+
+// NewRotatingFilter creates a new RotatingFilter whose filters are created by
+// the factory, such as a function returning a new ScalableBloomFilter.
+func NewRotatingFilter(factory func() Filter) *RotatingFilter {
+	return &RotatingFilter{
+		active:  factory(),
+		standby: factory(),
+		factory: factory,
+	}
+}
+
+// Active returns the filter receiving additions.
+func (r *RotatingFilter) Active() Filter {
+	return r.active
+}
+
+// Standby returns the filter of the previous period.
+func (r *RotatingFilter) Standby() Filter {
+	return r.standby
+}
+
+// Test will test for membership of the data in the active filter, then in the
+// standby filter, and returns true if either reports it as a member, false if
+// not.
+func (r *RotatingFilter) Test(data []byte) bool {
+	return r.active.Test(data) || r.standby.Test(data)
+}
+
+// Add will add the data to the active filter. It returns the filter to allow
+// for chaining.
+func (r *RotatingFilter) Add(data []byte) Filter {
+	r.active.Add(data)
+	return r
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. The data is added
+// to the active filter even if the standby filter reports it as a member, so
+// that it's remembered after the next rotation. It returns true if the data
+// is a member, false if not.
+func (r *RotatingFilter) TestAndAdd(data []byte) bool {
+	if r.active.TestAndAdd(data) {
+		return true
+	}
+	return r.standby.Test(data)
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (r *RotatingFilter) TestString(data string) bool {
+	return r.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (r *RotatingFilter) AddString(data string) Filter {
+	return r.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (r *RotatingFilter) TestAndAddString(data string) bool {
+	return r.TestAndAdd(stringBytes(data))
+}
+
+// Rotate forgets the items of the standby filter and makes the active filter
+// the standby. The retired filter is reset and becomes the active filter if
+// it's a type which can be reset, such as a BloomFilter or
+// ScalableBloomFilter, and is replaced by a new filter from the factory
+// otherwise. It returns the filter to allow for chaining.
+func (r *RotatingFilter) Rotate() *RotatingFilter {
+	retired := r.standby
+	r.standby = r.active
+	if resetFilter(retired) {
+		r.active = retired
+	} else {
+		r.active = r.factory()
+	}
+	return r
+}
@@ -0,0 +1,59 @@
+package boom
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Ensures that a RotatingFilter remembers items for one rotation and forgets
+// them after two.
+func TestRotatingFilter(t *testing.T) {
+	var (
+		created = 0
+		r       = NewRotatingFilter(func() Filter {
+			created++
+			return NewDefaultScalableBloomFilter(0.01)
+		})
+	)
+	r.AddString("a")
+	if r.TestAndAddString("b") || !r.TestString("b") {
+		t.Error("Expected b to be added")
+	}
+
+	if !r.Rotate().TestString("a") || r.Active().Test([]byte(`a`)) {
+		t.Error("Expected a to be remembered by the standby filter after 1 rotation")
+	}
+	// Testing and adding b refreshes it in the active filter.
+	if !r.TestAndAddString("b") {
+		t.Error("Expected b to be a member")
+	}
+
+	r.Rotate()
+	if r.TestString("a") {
+		t.Error("Expected a to be forgotten after 2 rotations")
+	}
+	if !r.TestString("b") {
+		t.Error("Expected refreshed b to be remembered")
+	}
+	if created != 2 {
+		t.Errorf("Expected reset filters to be reused, created %d", created)
+	}
+}
+
+// Ensures that Rotate creates a new filter if the retired one can't be reset.
+func TestRotatingFilterFactory(t *testing.T) {
+	created := 0
+	r := NewRotatingFilter(func() Filter {
+		created++
+		return NewTieredFilter(NewBloomFilter(100, 0.01))
+	})
+	for i := 0; i < 3; i++ {
+		r.AddString(strconv.Itoa(i)).(*RotatingFilter).Rotate()
+	}
+	if created != 5 {
+		t.Errorf("Expected 5 filters to be created, got %d", created)
+	}
+	if r.TestString("0") || !r.TestString("2") {
+		t.Error("Expected only the last item to be remembered")
+	}
+}
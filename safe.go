@@ -0,0 +1,205 @@
+package boom
+
+import "sync"
+
+// SafeFilter wraps a Filter, such as a BloomFilter, ScalableBloomFilter, or
+// StableBloomFilter, making it safe for concurrent use. Every operation holds
+// a single mutex, since even testing for membership writes to the filter's
+// hash function.
+type SafeFilter struct {
+	mu     sync.Mutex
+	filter Filter
+}
+
+// NewSafeFilter creates a new SafeFilter wrapping the filter. The filter must
+// not be used directly afterwards except through Do.
+func NewSafeFilter(filter Filter) *SafeFilter {
+	return &SafeFilter{filter: filter}
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not.
+func (s *SafeFilter) Test(data []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.filter.Test(data)
+}
+
+// Add will add the data to the filter. It returns the SafeFilter to allow for
+// chaining.
+func (s *SafeFilter) Add(data []byte) Filter {
+	s.mu.Lock()
+	s.filter.Add(data)
+	s.mu.Unlock()
+	return s
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add, atomically. It
+// returns true if the data is a member, false if not.
+func (s *SafeFilter) TestAndAdd(data []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.filter.TestAndAdd(data)
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (s *SafeFilter) TestString(data string) bool {
+	return s.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (s *SafeFilter) AddString(data string) Filter {
+	return s.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (s *SafeFilter) TestAndAddString(data string) bool {
+	return s.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter, holding the lock once for the
+// whole batch. The wrapped filter's AddMany is used if it's a BatchFilter. It
+// returns the SafeFilter to allow for chaining.
+func (s *SafeFilter) AddMany(data [][]byte) Filter {
+	s.mu.Lock()
+	if batch, ok := s.filter.(BatchFilter); ok {
+		batch.AddMany(data)
+	} else {
+		for _, d := range data {
+			s.filter.Add(d)
+		}
+	}
+	s.mu.Unlock()
+	return s
+}
+
+// TestMany returns whether each item of data is a member, in the same order,
+// holding the lock once for the whole batch. The wrapped filter's TestMany is
+// used if it's a BatchFilter.
+func (s *SafeFilter) TestMany(data [][]byte) []bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if batch, ok := s.filter.(BatchFilter); ok {
+		return batch.TestMany(data)
+	}
+	return testMany(data, s.filter.Test)
+}
+
+// Do calls fn with the wrapped filter while holding the lock, for operations
+// such as Reset or WriteTo which aren't part of the Filter interface.
+func (s *SafeFilter) Do(fn func(Filter)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.filter)
+}
+
+// SafeCountMinSketch wraps a CountMinSketch, making it safe for concurrent use.
+// Every operation holds a single mutex, since counting writes to the sketch's
+// hash function.
+type SafeCountMinSketch struct {
+	mu  sync.Mutex
+	cms *CountMinSketch
+}
+
+// NewSafeCountMinSketch creates a new SafeCountMinSketch wrapping the sketch.
+// The sketch must not be used directly afterwards except through Do.
+func NewSafeCountMinSketch(cms *CountMinSketch) *SafeCountMinSketch {
+	return &SafeCountMinSketch{cms: cms}
+}
+
+// Add will add the data to the set. Returns the SafeCountMinSketch to allow
+// for chaining.
+func (s *SafeCountMinSketch) Add(data []byte) *SafeCountMinSketch {
+	s.mu.Lock()
+	s.cms.Add(data)
+	s.mu.Unlock()
+	return s
+}
+
+// Count returns the approximate count for the specified item, correct within
+// epsilon * total count with a probability of delta.
+func (s *SafeCountMinSketch) Count(data []byte) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cms.Count(data)
+}
+
+// TotalCount returns the number of items added to the sketch.
+func (s *SafeCountMinSketch) TotalCount() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cms.TotalCount()
+}
+
+// Merge combines this sketch with another. The other sketch must not be
+// modified concurrently. Returns an error if the matrix width and depth are
+// not equal.
+func (s *SafeCountMinSketch) Merge(other *CountMinSketch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cms.Merge(other)
+}
+
+// Reset restores the sketch to its original state. It returns itself to allow
+// for chaining.
+func (s *SafeCountMinSketch) Reset() *SafeCountMinSketch {
+	s.mu.Lock()
+	s.cms.Reset()
+	s.mu.Unlock()
+	return s
+}
+
+// Do calls fn with the wrapped sketch while holding the lock.
+func (s *SafeCountMinSketch) Do(fn func(*CountMinSketch)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.cms)
+}
+
+// SafeTopK wraps a TopK, making it safe for concurrent use. Every operation
+// holds a single mutex.
+type SafeTopK struct {
+	mu   sync.Mutex
+	topk *TopK
+}
+
+// NewSafeTopK creates a new SafeTopK wrapping the TopK. The TopK must not be
+// used directly afterwards except through Do.
+func NewSafeTopK(topk *TopK) *SafeTopK {
+	return &SafeTopK{topk: topk}
+}
+
+// Add will add the data to the Count-Min Sketch and update the top-k heap if
+// applicable. Returns the SafeTopK to allow for chaining.
+func (s *SafeTopK) Add(data []byte) *SafeTopK {
+	s.mu.Lock()
+	s.topk.Add(data)
+	s.mu.Unlock()
+	return s
+}
+
+// Elements returns the top-k elements from lowest to highest frequency.
+func (s *SafeTopK) Elements() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.topk.Elements()
+}
+
+// Reset restores the TopK to its original state. It returns itself to allow
+// for chaining.
+func (s *SafeTopK) Reset() *SafeTopK {
+	s.mu.Lock()
+	s.topk.Reset()
+	s.mu.Unlock()
+	return s
+}
+
+// Do calls fn with the wrapped TopK while holding the lock.
+func (s *SafeTopK) Do(fn func(*TopK)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.topk)
+}
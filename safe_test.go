@@ -0,0 +1,186 @@
+package boom
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Ensures that a SafeFilter can be used by several goroutines at once for
+// each of the supported filters.
+func TestSafeFilterConcurrent(t *testing.T) {
+	filters := map[string]Filter{
+		"bloom":    NewBloomFilter(10000, 0.01),
+		"scalable": NewDefaultScalableBloomFilter(0.01),
+		"stable":   NewUnstableBloomFilter(100000, 0.01),
+	}
+
+	for name, filter := range filters {
+		f := NewSafeFilter(filter)
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 500; i++ {
+					f.TestAndAdd([]byte(strconv.Itoa(g*500 + i)))
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		for i := 0; i < 4000; i++ {
+			if !f.Test([]byte(strconv.Itoa(i))) {
+				t.Errorf("%s: expected %d to be a member", name, i)
+				break
+			}
+		}
+
+		f.Do(func(filter Filter) {
+			if filter != filters[name] {
+				t.Errorf("%s: expected wrapped filter", name)
+			}
+		})
+	}
+}
+
+// Ensures that a SafeCountMinSketch counts every concurrent Add.
+func TestSafeCountMinSketchConcurrent(t *testing.T) {
+	cms := NewSafeCountMinSketch(NewCountMinSketch(0.001, 0.99))
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				cms.Add([]byte(`a`))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count := cms.Count([]byte(`a`)); count != 4000 {
+		t.Errorf("Expected 4000, got %d", count)
+	}
+
+	if count := cms.Reset().TotalCount(); count != 0 {
+		t.Errorf("Expected 0, got %d", count)
+	}
+}
+
+// Ensures that a SafeTopK tracks the most frequent elements added
+// concurrently.
+func TestSafeTopKConcurrent(t *testing.T) {
+	topk := NewSafeTopK(NewTopK(0.001, 0.99, 2))
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				topk.Add([]byte(`a`)).Add([]byte(`b`)).Add([]byte(`b`))
+				topk.Add([]byte(strconv.Itoa(i)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	elements := topk.Elements()
+	if len(elements) != 2 || string(elements[0]) != `a` || string(elements[1]) != `b` {
+		t.Errorf("Expected [a b], got %q", elements)
+	}
+}
+
+func BenchmarkSafeBloomAdd(b *testing.B) {
+	b.StopTimer()
+	f := NewSafeFilter(NewBloomFilter(100000, 0.1))
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.Add(data[n])
+	}
+}
+
+func BenchmarkSafeBloomAddParallel(b *testing.B) {
+	f := NewSafeFilter(NewBloomFilter(100000, 0.1))
+	data := []byte(`a`)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			f.Add(data)
+		}
+	})
+}
+
+func BenchmarkSafeBloomTest(b *testing.B) {
+	b.StopTimer()
+	f := NewSafeFilter(NewBloomFilter(100000, 0.1))
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.Test(data[n])
+	}
+}
+
+func BenchmarkSafeScalableBloomAdd(b *testing.B) {
+	b.StopTimer()
+	f := NewSafeFilter(NewDefaultScalableBloomFilter(0.1))
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.Add(data[n])
+	}
+}
+
+func BenchmarkSafeStableBloomAdd(b *testing.B) {
+	b.StopTimer()
+	f := NewSafeFilter(NewDefaultStableBloomFilter(10000, 0.01))
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.Add(data[n])
+	}
+}
+
+func BenchmarkSafeCMSAdd(b *testing.B) {
+	b.StopTimer()
+	cms := NewSafeCountMinSketch(NewCountMinSketch(0.001, 0.99))
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		cms.Add(data[n])
+	}
+}
+
+func BenchmarkSafeTopKAdd(b *testing.B) {
+	b.StopTimer()
+	topk := NewSafeTopK(NewTopK(0.001, 0.99, 5))
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		topk.Add(data[n])
+	}
+}
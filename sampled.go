@@ -0,0 +1,202 @@
+package boom
+
+import "unsafe"
+
+// SampleStats describes the sampling phase of a SampledFilter, from which its
+// filter is sized.
+type SampleStats struct {
+	Additions uint // number of additions in the sampling phase
+	Distinct  uint // number of distinct keys added
+	Gap       uint // most additions between two of the same key, zero if none repeated
+}
+
+// SampledFilter sizes its filter from the stream rather than from a static
+// hint, for when the number of items isn't known up front. It holds the keys
+// of its first additions, the sampling phase, exactly, so they're tested
+// without false positives, and once the phase ends creates the filter from
+// what it observed, such as the number of distinct keys or how far apart
+// duplicates arrived, and adds each sampled key to it once, in the order they
+// were last added. The sampling
+// phase takes memory proportional to the keys it holds, so it's bounded by a
+// number of additions.
+//
+// A SampledFilter isn't safe for concurrent use.
+type SampledFilter struct {
+	sample  uint                     // number of additions in the sampling phase
+	keys    [][]byte                 // keys of the sampling phase, in the order added
+	last    map[string]uint          // position each key was last added at
+	gap     uint                     // most additions between two of the same key
+	factory func(SampleStats) Filter // creates the filter once the phase ends
+	filter  Filter                   // filter sized from the sampling phase
+	stats   SampleStats              // statistics of the sampling phase
+}
+
+// NewSampledFilter creates a new SampledFilter whose sampling phase lasts for
+// sample additions, at least 1, after which its filter is created by the
+// factory from the statistics of the phase.
+func NewSampledFilter(sample uint, factory func(SampleStats) Filter) *SampledFilter {
+	if sample == 0 {
+		sample = 1
+	}
+	return &SampledFilter{
+		sample:  sample,
+		last:    make(map[string]uint),
+		factory: factory,
+	}
+}
+
+// NewSampledScalableBloomFilter creates a new SampledFilter of a Scalable Bloom
+// Filter with the target false-positive rate and tightening ratio, the size of
+// each filter of whose series is the number of distinct keys of the sampling
+// phase of sample additions. The options are passed to
+// NewScalableBloomFilter, except for WithHint.
+func NewSampledScalableBloomFilter(sample uint, fpRate, r float64, opts ...Option) *SampledFilter {
+	return NewSampledFilter(sample, func(stats SampleStats) Filter {
+		hint := stats.Distinct
+		if hint == 0 {
+			hint = 1
+		}
+		return NewScalableBloomFilter(hint, fpRate, r, opts...)
+	})
+}
+
+// NewSampledStableBloomFilter creates a new SampledFilter of a Stable Bloom
+// Filter tuned like NewTunedStableBloomFilter to detect duplicates arriving as
+// far apart as the farthest apart two of the same key were added during the
+// sampling phase of sample additions, at the target false-positive and
+// false-negative rates. If no key was added twice, the gap is the number of
+// distinct keys, so every key of a phase as long is remembered. Returns an
+// error if a rate isn't between 0 and 1.
+func NewSampledStableBloomFilter(sample uint, fpRate, fnRate float64, opts ...Option) (*SampledFilter, error) {
+	// Check the rates now rather than once the sampling phase ends.
+	if _, err := NewTunedStableBloomFilter(1, fpRate, fnRate, opts...); err != nil {
+		return nil, err
+	}
+
+	return NewSampledFilter(sample, func(stats SampleStats) Filter {
+		gap := stats.Gap
+		if gap == 0 {
+			gap = stats.Distinct
+		}
+		if gap == 0 {
+			gap = 1
+		}
+		// The rates were checked when the filter was created.
+		f, _ := NewTunedStableBloomFilter(gap, fpRate, fnRate, opts...)
+		return f
+	}), nil
+}
+
+// Sampling returns true while the sampling phase lasts.
+func (s *SampledFilter) Sampling() bool {
+	return s.filter == nil
+}
+
+// Filter returns the filter sized from the sampling phase, or nil while it
+// lasts.
+func (s *SampledFilter) Filter() Filter {
+	return s.filter
+}
+
+// Stats returns the statistics of the sampling phase so far, or of the whole
+// phase once it has ended.
+func (s *SampledFilter) Stats() SampleStats {
+	if s.filter != nil {
+		return s.stats
+	}
+	return SampleStats{Additions: uint(len(s.keys)), Distinct: uint(len(s.last)), Gap: s.gap}
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. During the sampling phase this is exact.
+func (s *SampledFilter) Test(data []byte) bool {
+	if s.filter != nil {
+		return s.filter.Test(data)
+	}
+
+	_, ok := s.last[string(data)]
+	return ok
+}
+
+// Add will add the data to the filter, ending the sampling phase if it's the
+// phase's last addition. It returns the filter to allow for chaining.
+func (s *SampledFilter) Add(data []byte) Filter {
+	if s.filter != nil {
+		s.filter.Add(data)
+		return s
+	}
+
+	pos := uint(len(s.keys))
+	if prev, ok := s.last[string(data)]; ok && pos-prev > s.gap {
+		s.gap = pos - prev
+	}
+	s.last[string(data)] = pos
+	s.keys = append(s.keys, append([]byte(nil), data...))
+	if uint(len(s.keys)) >= s.sample {
+		s.Finish()
+	}
+	return s
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data is a member, false if not.
+func (s *SampledFilter) TestAndAdd(data []byte) bool {
+	member := s.Test(data)
+	s.Add(data)
+	return member
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (s *SampledFilter) TestString(data string) bool {
+	return s.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (s *SampledFilter) AddString(data string) Filter {
+	return s.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (s *SampledFilter) TestAndAddString(data string) bool {
+	return s.TestAndAdd(stringBytes(data))
+}
+
+// Finish ends the sampling phase early, such as once the stream has been idle
+// for a while, creating the filter from the statistics so far and adding the
+// distinct sampled keys to it. It does nothing once the phase has ended. It
+// returns the filter created.
+func (s *SampledFilter) Finish() Filter {
+	if s.filter != nil {
+		return s.filter
+	}
+
+	s.stats = s.Stats()
+	s.filter = s.factory(s.stats)
+	for pos, key := range s.keys {
+		// Each key is added once, in the order of its last addition, so
+		// repeated keys don't count against the filter's capacity.
+		if s.last[string(key)] == uint(pos) {
+			s.filter.Add(key)
+		}
+	}
+	s.keys = nil
+	s.last = nil
+	return s.filter
+}
+
+// ByteSize returns the number of bytes used by the sampled keys and metadata
+// of the SampledFilter, excluding the overhead of the map of keys and the
+// filter once it's created.
+func (s *SampledFilter) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*s)) + uint64(cap(s.keys))*sliceSize
+	for _, key := range s.keys {
+		size += uint64(len(key))
+	}
+	for key := range s.last {
+		size += uint64(len(key))
+	}
+	return size
+}
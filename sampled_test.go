@@ -0,0 +1,85 @@
+package boom
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Ensures that a SampledFilter tests its sampling phase exactly and sizes its
+// filter from the phase's statistics, keeping the sampled keys.
+func TestSampledFilter(t *testing.T) {
+	var stats SampleStats
+	f := NewSampledFilter(100, func(s SampleStats) Filter {
+		stats = s
+		return NewBloomFilter(s.Distinct*10, 0.01)
+	})
+
+	// Each key is added twice, 10 additions apart.
+	for i := 0; i < 99; i++ {
+		f.Add([]byte(strconv.Itoa(i/20*10 + i%10)))
+	}
+	if !f.Sampling() || f.Filter() != nil {
+		t.Fatal("Expected the sampling phase to last 100 additions")
+	}
+	if f.TestString("50") || !f.TestString("0") {
+		t.Error("Expected the sampling phase to be tested exactly")
+	}
+
+	f.AddString("foo")
+	if f.Sampling() {
+		t.Fatal("Expected the sampling phase to have ended")
+	}
+	if stats.Additions != 100 || stats.Distinct != 51 || stats.Gap != 10 {
+		t.Errorf("Expected 100 additions, 51 distinct keys, and a gap of 10, got %+v", stats)
+	}
+	if f.Stats() != stats {
+		t.Errorf("Expected stats %+v, got %+v", stats, f.Stats())
+	}
+	if bf := f.Filter().(*BloomFilter); bf.Capacity() != OptimalM(510, 0.01) {
+		t.Errorf("Expected a filter sized for 510 items, got %d bits", bf.Capacity())
+	}
+	if !f.TestString("foo") || !f.TestString("0") || !f.TestString("49") {
+		t.Error("Expected the sampled keys to be added to the filter")
+	}
+
+	early := NewSampledFilter(100, func(s SampleStats) Filter {
+		return NewBloomFilter(s.Distinct+1, 0.01)
+	})
+	early.AddString("a")
+	if early.Finish() != early.Filter() || !early.TestString("a") {
+		t.Error("Expected Finish to create the filter with the keys added")
+	}
+}
+
+// Ensures that NewSampledScalableBloomFilter and NewSampledStableBloomFilter
+// size their filters from the sampling phase.
+func TestSampledBloomFilters(t *testing.T) {
+	scalable := NewSampledScalableBloomFilter(1000, 0.01, 0.8)
+	for i := 0; i < 1000; i++ {
+		scalable.Add([]byte(strconv.Itoa(i % 250)))
+	}
+	if sbf := scalable.Filter().(*ScalableBloomFilter); sbf.hint != 250 || sbf.ApproximatedSize() < 240 || sbf.ApproximatedSize() > 260 {
+		t.Errorf("Expected filters sized for 250 items holding about 250, got %d holding %d", sbf.hint, sbf.ApproximatedSize())
+	}
+
+	stable, err := NewSampledStableBloomFilter(200, 0.01, 0.01)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		stable.Add([]byte(strconv.Itoa(i % 50)))
+	}
+	tuned, _ := NewTunedStableBloomFilter(50, 0.01, 0.01)
+	if sbf := stable.Filter().(*StableBloomFilter); sbf.Cells() != tuned.Cells() {
+		t.Errorf("Expected %d cells, got %d", tuned.Cells(), sbf.Cells())
+	}
+	for i := 0; i < 50; i++ {
+		if !stable.TestString(strconv.Itoa(i)) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+
+	if _, err := NewSampledStableBloomFilter(1000, 0, 0.01); err == nil {
+		t.Error("Expected error for an invalid rate")
+	}
+}
@@ -16,8 +16,13 @@ copies or substantial portions of the Software.
 package boom
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"hash"
+	"io"
 	"math"
+	"unsafe"
 )
 
 // ScalableBloomFilter implements a Scalable Bloom Filter as described by
@@ -42,13 +47,60 @@ type ScalableBloomFilter struct {
 	fp      float64                   // target false-positive rate
 	p       float64                   // partition fill ratio
 	hint    uint                      // filter size hint
+	lazy    bool                      // defer allocating the first filter until the first Add
+	hash    hash.Hash64               // hash function set before any filter was allocated
+	buckets BucketsFactory            // creates the filters' partitions, if set
+	err     error                     // error of buckets when adding a filter
+	seed    uint64                    // hash seed of every filter in the series
+	modulo  bool                      // filters added reduce hashes to indices with a modulo
+
+	growth   uint      // factor by which the size hint grows with each filter
+	schedule []float64 // tightening ratios of the first filters added, before r
+
+	transformer Transformer // normalizes keys before they're hashed, if set
+
+	maxFilters uint           // most filters in the series, zero if unbounded
+	maxBytes   uint64         // most bytes of the filters' bit arrays, zero if unbounded
+	overflow   OverflowPolicy // policy for additions once the bound is reached
+	overflows  uint           // number of additions made once the bound was reached
+
+	chunks *chunkLoad // dump being loaded by LoadChunk
 }
 
+// OverflowPolicy determines what a ScalableBloomFilter bounded by
+// WithMaxFilters or WithMaxBytes does with an addition once its last filter is
+// full and adding another would exceed the bound.
+type OverflowPolicy uint8
+
+const (
+	// OverflowDegrade stops the series from growing and keeps adding to the
+	// last filter, so no items are lost but the false-positive rate rises
+	// past the target, as reported by EstimatedFPRate. It's the default.
+	OverflowDegrade OverflowPolicy = iota
+
+	// OverflowRotate drops the oldest filters and their items until a new
+	// filter fits within the bound, so the false-positive rate stays bounded
+	// but the dropped items become false negatives, like a
+	// RotatingBloomFilter. Filters whose partitions are created by a factory
+	// degrade instead.
+	OverflowRotate
+
+	// OverflowRefuse refuses the addition, leaving the filter unchanged.
+	// TryAdd returns ErrFilterFull, and the other additions report nothing but
+	// the overflow count.
+	OverflowRefuse
+)
+
+// ErrFilterFull is returned by ScalableBloomFilter.TryAdd when the
+// OverflowRefuse policy refuses an addition because the filter reached its
+// bound.
+var ErrFilterFull = errors.New("filter full")
+
 // NewScalableBloomFilter creates a new Scalable Bloom Filter with the
 // specified target false-positive rate and tightening ratio. Use
 // NewDefaultScalableBloomFilter if you don't want to calculate these
 // parameters.
-func NewScalableBloomFilter(hint uint, fpRate, r float64) *ScalableBloomFilter {
+func NewScalableBloomFilter(hint uint, fpRate, r float64, opts ...Option) *ScalableBloomFilter {
 	s := &ScalableBloomFilter{
 		filters: make([]*PartitionedBloomFilter, 0, 1),
 		r:       r,
@@ -57,14 +109,233 @@ func NewScalableBloomFilter(hint uint, fpRate, r float64) *ScalableBloomFilter {
 		hint:    hint,
 	}
 
+	s.applyOptions(opts)
 	s.addFilter()
 	return s
 }
 
+// NewScalableBloomFilterFromReader creates a new Scalable Bloom Filter with the
+// specified target false-positive rate and tightening ratio holding the keys
+// read from the stream, delimited by delim, like NewBloomFilterFromReader. The
+// keys counted in a first pass over a stream which is an io.Seeker, or the
+// hint set with WithHint, size the first filter of the series, and the filter
+// grows if the stream holds more keys. Returns an error if reading the stream
+// fails.
+func NewScalableBloomFilterFromReader(stream io.Reader, delim byte, fpRate, r float64, opts ...Option) (*ScalableBloomFilter, error) {
+	hint, err := streamHint(stream, delim, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewScalableBloomFilter(hint, fpRate, r, opts...)
+	if err := readKeys(stream, delim, func(key []byte) error {
+		s.Add(key)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewLazyScalableBloomFilter creates a new Scalable Bloom Filter like
+// NewScalableBloomFilter, except the first filter isn't allocated until the
+// first Add. This avoids wasting memory when creating many filters which may
+// stay empty. Testing an empty lazy filter returns false without allocating.
+// Reset also releases every filter.
+func NewLazyScalableBloomFilter(hint uint, fpRate, r float64, opts ...Option) *ScalableBloomFilter {
+	s := &ScalableBloomFilter{
+		r:    r,
+		fp:   fpRate,
+		p:    fillRatio,
+		hint: hint,
+		lazy: true,
+	}
+
+	s.applyOptions(opts)
+	return s
+}
+
+// NewScalableBloomFilterWithBuckets creates a new Scalable Bloom Filter like
+// NewScalableBloomFilter whose filters' partitions are created by the factory,
+// such as one returned by MmapBucketsFactory. If the factory reopens existing
+// data, every filter in the series holding data is restored, with the number
+// of items added to each estimated from its set bits. Returns an error if the
+// factory fails.
+func NewScalableBloomFilterWithBuckets(hint uint, fpRate, r float64, newBuckets BucketsFactory, opts ...Option) (*ScalableBloomFilter, error) {
+	s := &ScalableBloomFilter{
+		filters: make([]*PartitionedBloomFilter, 0, 1),
+		r:       r,
+		fp:      fpRate,
+		p:       fillRatio,
+		hint:    hint,
+		buckets: newBuckets,
+	}
+
+	s.applyOptions(opts)
+	for {
+		if err := s.addFilter(); err != nil {
+			s.Close()
+			return nil, err
+		}
+
+		// Filters are added in order, so only a filter holding reopened data
+		// may be followed by another one.
+		last := s.filters[len(s.filters)-1]
+		if last.count == 0 {
+			return s, nil
+		}
+	}
+}
+
+// NewScalableBloomFilterWithOptions creates a new Scalable Bloom Filter
+// configured entirely by options, such as WithHint, WithFPRate, and
+// WithGrowthRatio, so new settings can be added without changing its
+// signature. If a factory is set with WithBucketsBackend, it creates the
+// filters' partitions like NewScalableBloomFilterWithBuckets. Returns an error
+// if a setting is out of range or the factory fails.
+func NewScalableBloomFilterWithOptions(opts ...Option) (*ScalableBloomFilter, error) {
+	o := applyOptions(opts)
+	hint, fpRate, err := o.sizing()
+	if err != nil {
+		return nil, err
+	}
+
+	r := o.r
+	if r == 0 {
+		r = 0.8
+	}
+	if r < 0 || r >= 1 {
+		return nil, errors.New("tightening ratio must be in (0, 1)")
+	}
+	for _, ratio := range o.schedule {
+		if ratio <= 0 || ratio >= 1 {
+			return nil, errors.New("tightening ratio must be in (0, 1)")
+		}
+	}
+
+	if o.buckets != nil {
+		return NewScalableBloomFilterWithBuckets(hint, fpRate, r, o.buckets, opts...)
+	}
+	return NewScalableBloomFilter(hint, fpRate, r, opts...), nil
+}
+
 // NewDefaultScalableBloomFilter creates a new Scalable Bloom Filter with the
 // specified target false-positive rate and an optimal tightening ratio.
-func NewDefaultScalableBloomFilter(fpRate float64) *ScalableBloomFilter {
-	return NewScalableBloomFilter(10000, fpRate, 0.8)
+func NewDefaultScalableBloomFilter(fpRate float64, opts ...Option) *ScalableBloomFilter {
+	return NewScalableBloomFilter(10000, fpRate, 0.8, opts...)
+}
+
+// applyOptions sets the hash function used by every filter in the series, if
+// an option sets one, the seed, the reduction of hashes to indices, the growth
+// of the series, and the bound and overflow policy.
+func (s *ScalableBloomFilter) applyOptions(opts []Option) {
+	o := applyOptions(opts)
+	if o.hash64 != nil {
+		s.hash = o.hash64()
+	}
+	s.seed = o.seed
+	s.modulo = o.modulo
+	s.growth = o.growth
+	if s.growth == 0 {
+		s.growth = 1
+	}
+	s.schedule = o.schedule
+	s.maxFilters = o.maxFilters
+	s.maxBytes = o.maxBytes
+	s.overflow = o.overflow
+	s.transformer = o.transformer
+}
+
+// GrowthFactor returns the factor by which the size hint of each filter added
+// to the series grows, set with WithGrowthFactor. A factor of one, the
+// default, sizes every filter for the hint.
+func (s *ScalableBloomFilter) GrowthFactor() uint {
+	if s.growth == 0 {
+		return 1
+	}
+	return s.growth
+}
+
+// TighteningRatio returns the ratio by which the false-positive rate of each
+// filter added to the series decreases once the schedule set with
+// WithTighteningSchedule is exhausted.
+func (s *ScalableBloomFilter) TighteningRatio() float64 {
+	return s.r
+}
+
+// TighteningSchedule returns the ratios by which the false-positive rates of
+// the first filters added to the series decrease, set with
+// WithTighteningSchedule, or nil if every filter tightens by the tightening
+// ratio.
+func (s *ScalableBloomFilter) TighteningSchedule() []float64 {
+	return append([]float64(nil), s.schedule...)
+}
+
+// FPBound returns the bound the compounded false-positive rate of the series
+// converges to as filters are added, the sum of the target rates of the
+// filters of an infinite series. It's fpRate / (1 - r) for a target rate
+// fpRate and tightening ratio r without a tightening schedule.
+func (s *ScalableBloomFilter) FPBound() float64 {
+	bound := 0.0
+	for i := range s.schedule {
+		bound += s.stageFPRate(i)
+	}
+	return bound + s.stageFPRate(len(s.schedule))/(1-s.r)
+}
+
+// RemainingFPBudget returns the part of FPBound left to the filters yet to be
+// added, which is FPBound less the target rates of the filters in the series.
+// It shrinks as the series grows, so it tells how close a long-lived filter is
+// to its bound, whereas EstimatedFPRate tells the rate it has reached.
+func (s *ScalableBloomFilter) RemainingFPBudget() float64 {
+	remaining := s.FPBound()
+	for i := range s.filters {
+		remaining -= s.stageFPRate(i)
+	}
+	return remaining
+}
+
+// stageHint returns the size hint of the i-th filter in the series, the hint
+// grown by the growth factor for each filter before it, saturating rather than
+// overflowing.
+func (s *ScalableBloomFilter) stageHint(i int) uint {
+	hint, growth := s.hint, s.GrowthFactor()
+	for ; i > 0 && growth > 1; i-- {
+		if hint > ^uint(0)/growth {
+			return ^uint(0)
+		}
+		hint *= growth
+	}
+	return hint
+}
+
+// stageFPRate returns the target false-positive rate of the i-th filter in the
+// series, tightened by the ratios of the schedule for the filters before it
+// and by the tightening ratio for the rest.
+func (s *ScalableBloomFilter) stageFPRate(i int) float64 {
+	fpRate := s.fp
+	for j := 0; j < i && j < len(s.schedule); j++ {
+		fpRate *= s.schedule[j]
+	}
+	if i > len(s.schedule) {
+		fpRate *= math.Pow(s.r, float64(i-len(s.schedule)))
+	}
+	return fpRate
+}
+
+// Overflow returns the policy for additions once the filter reaches the bound
+// set with WithMaxFilters or WithMaxBytes, set with WithOverflow.
+func (s *ScalableBloomFilter) Overflow() OverflowPolicy {
+	return s.overflow
+}
+
+// OverflowCount returns the number of additions which found the last filter
+// full once the series reached its bound, including those refused by
+// OverflowRefuse. Any overflow means the false-positive rate exceeds the
+// target with OverflowDegrade, or that items were dropped with
+// OverflowRotate.
+func (s *ScalableBloomFilter) OverflowCount() uint {
+	return s.overflows
 }
 
 // Capacity returns the current Scalable Bloom Filter capacity, which is the
@@ -80,11 +351,18 @@ func (s *ScalableBloomFilter) Capacity() uint {
 // K returns the number of hash functions used in each Bloom filter.
 func (s *ScalableBloomFilter) K() uint {
 	// K is the same across every filter.
+	if len(s.filters) == 0 {
+		return OptimalK(s.fp)
+	}
 	return s.filters[0].K()
 }
 
 // FillRatio returns the average ratio of set bits across every filter.
 func (s *ScalableBloomFilter) FillRatio() float64 {
+	if len(s.filters) == 0 {
+		return 0
+	}
+
 	sum := 0.0
 	for _, filter := range s.filters {
 		sum += filter.FillRatio()
@@ -92,11 +370,69 @@ func (s *ScalableBloomFilter) FillRatio() float64 {
 	return sum / float64(len(s.filters))
 }
 
+// ApproximatedSize returns the number of distinct items added to the filter,
+// the sum of the ApproximatedSize of every filter in the series. Since each
+// item is only added to the last filter, items are counted once unless they're
+// added again after a new filter was added.
+func (s *ScalableBloomFilter) ApproximatedSize() uint {
+	size := uint(0)
+	for _, filter := range s.filters {
+		size += filter.ApproximatedSize()
+	}
+	return size
+}
+
+// EstimatedFPRate returns the current probability of a false positive derived
+// from the ratios of set bits of every filter in the series, the probability
+// that any of them reports an absent item as a member. It grows with the
+// number of filters, approaching fpRate / (1 - r) for a target rate fpRate and
+// tightening ratio r while the filters don't exceed their fill ratio.
+func (s *ScalableBloomFilter) EstimatedFPRate() float64 {
+	absent := 1.0
+	for _, filter := range s.filters {
+		absent *= 1 - filter.EstimatedFPRate()
+	}
+	return 1 - absent
+}
+
+// ScalableFilterStats describes a filter in the series of a Scalable Bloom
+// Filter.
+type ScalableFilterStats struct {
+	Index     int     // position in the series, which is the order of creation
+	Capacity  uint    // filter size in bits
+	FillRatio float64 // ratio of set bits
+	FPRate    float64 // false-positive rate the filter was sized for
+	Count     uint    // number of items added
+	Full      bool    // the filter reached its estimated fill ratio and takes no more items
+}
+
+// Stats describes every filter in the series, in the order they were created,
+// so the number of filters a long-lived Scalable Bloom Filter has accumulated
+// and how full each of them is can be monitored. The FPRate of a filter is
+// the rate of a filter of its size holding its size hint of items, which
+// tightens with each filter added.
+func (s *ScalableBloomFilter) Stats() []ScalableFilterStats {
+	stats := make([]ScalableFilterStats, len(s.filters))
+	for i, filter := range s.filters {
+		stats[i] = ScalableFilterStats{
+			Index:     i,
+			Capacity:  filter.Capacity(),
+			FillRatio: filter.FillRatio(),
+			FPRate:    math.Pow(1-math.Exp(-float64(s.stageHint(i))/float64(filter.s)), float64(filter.k)),
+			Count:     filter.Count(),
+			Full:      filter.EstimatedFillRatio() >= s.p,
+		}
+	}
+	return stats
+}
+
 // Test will test for membership of the data and returns true if it is a
 // member, false if not. This is a probabilistic test, meaning there is a
 // non-zero probability of false positives but a zero probability of false
 // negatives.
 func (s *ScalableBloomFilter) Test(data []byte) bool {
+	data = transformKey(s.transformer, data)
+
 	// Querying is made by testing for the presence in each filter.
 	for _, bf := range s.filters {
 		if bf.Test(data) {
@@ -107,19 +443,84 @@ func (s *ScalableBloomFilter) Test(data []byte) bool {
 	return false
 }
 
+// TestWhich is like Test but also returns the index of the first filter in the
+// series reporting membership of the data, or -1 if it's not a member. Since
+// filters are added as earlier ones fill up, a higher index hints that the
+// data was added more recently.
+func (s *ScalableBloomFilter) TestWhich(data []byte) (bool, int) {
+	data = transformKey(s.transformer, data)
+	for i, bf := range s.filters {
+		if bf.Test(data) {
+			return true, i
+		}
+	}
+
+	return false, -1
+}
+
 // Add will add the data to the Bloom filter. It returns the filter to allow
 // for chaining.
 func (s *ScalableBloomFilter) Add(data []byte) Filter {
-	idx := len(s.filters) - 1
+	s.add(data)
+	return s
+}
 
-	// If the last filter has reached its fill ratio, add a new one.
-	if s.filters[idx].EstimatedFillRatio() >= s.p {
-		s.addFilter()
-		idx++
+// TryAdd is equivalent to Add, but returns ErrFilterFull if the OverflowRefuse
+// policy refuses the addition, or the factory's error if no filter could be
+// created.
+func (s *ScalableBloomFilter) TryAdd(data []byte) error {
+	return s.add(data)
+}
+
+// add adds the data to the last filter, adding a new one first if there is no
+// filter yet or the last one has reached its fill ratio, unless the series
+// reached its bound, in which case the overflow policy applies.
+func (s *ScalableBloomFilter) add(data []byte) error {
+	data = transformKey(s.transformer, data)
+	idx := len(s.filters) - 1
+	if idx < 0 || s.filters[idx].EstimatedFillRatio() >= s.p {
+		if idx >= 0 && s.bounded() {
+			s.overflows++
+			switch {
+			case s.overflow == OverflowRefuse:
+				return ErrFilterFull
+			case s.overflow == OverflowRotate && s.buckets == nil:
+				for len(s.filters) > 0 && s.bounded() {
+					s.filters[0] = nil
+					s.filters = s.filters[1:]
+				}
+				s.addFilter()
+				idx = len(s.filters) - 1
+			}
+		} else {
+			// If the buckets can't be created, keep adding to the last filter.
+			if err := s.addFilter(); err == nil {
+				idx++
+			} else if idx < 0 {
+				return err
+			}
+		}
 	}
 
 	s.filters[idx].Add(data)
-	return s
+	return nil
+}
+
+// bounded returns true if adding a filter to the series would exceed the most
+// filters set with WithMaxFilters or bytes set with WithMaxBytes.
+func (s *ScalableBloomFilter) bounded() bool {
+	if s.maxFilters > 0 && uint(len(s.filters)) >= s.maxFilters {
+		return true
+	}
+	if s.maxBytes == 0 {
+		return false
+	}
+
+	size := PartitionedMemory(s.stageHint(len(s.filters)), s.stageFPRate(len(s.filters)))
+	for _, filter := range s.filters {
+		size += uint64(filter.k) * ((uint64(filter.s) + 7) / 8)
+	}
+	return size > s.maxBytes
 }
 
 // TestAndAdd is equivalent to calling Test followed by Add. It returns true if
@@ -130,29 +531,867 @@ func (s *ScalableBloomFilter) TestAndAdd(data []byte) bool {
 	return member
 }
 
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (s *ScalableBloomFilter) TestString(data string) bool {
+	return s.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (s *ScalableBloomFilter) AddString(data string) Filter {
+	return s.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (s *ScalableBloomFilter) TestAndAddString(data string) bool {
+	return s.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter. It returns the filter to allow
+// for chaining.
+func (s *ScalableBloomFilter) AddMany(data [][]byte) Filter {
+	for _, d := range data {
+		s.Add(d)
+	}
+	return s
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+// Each filter in the series is tested in turn with the items which aren't
+// members of an earlier one, so only one filter's data is accessed at a time.
+func (s *ScalableBloomFilter) TestMany(data [][]byte) []bool {
+	var (
+		members = make([]bool, len(data))
+		pending = make([]int, len(data))
+	)
+	for i := range pending {
+		pending[i] = i
+	}
+	if s.transformer != nil {
+		transformed := make([][]byte, len(data))
+		for i, d := range data {
+			transformed[i] = s.transformer.Transform(d)
+		}
+		data = transformed
+	}
+
+	for _, bf := range s.filters {
+		remaining := pending[:0]
+		for _, i := range pending {
+			if bf.Test(data[i]) {
+				members[i] = true
+			} else {
+				remaining = append(remaining, i)
+			}
+		}
+		pending = remaining
+	}
+	return members
+}
+
+// AddAllContext adds each item of data to the filter, checking the context for
+// cancellation periodically. It returns the number of items added, which are
+// the first ones in data, and the context's error if it was cancelled before
+// every item was added.
+func (s *ScalableBloomFilter) AddAllContext(ctx context.Context, data [][]byte) (int, error) {
+	return addAllContext(ctx, data, func(d []byte) error {
+		s.Add(d)
+		return nil
+	})
+}
+
+// Merge appends a copy of every filter in the other Scalable Bloom Filter's
+// series to this one, such that this filter contains the union of the two
+// sets. The filters aren't combined bit by bit, which would push them past
+// their fill ratio, so each keeps its false-positive rate and the
+// false-positive rate of the merged filter is at most the sum of those of
+// the two filters, or twice the target rate. Items added afterwards go to the
+// last filter of the series until it fills up. The filters must have the same
+// target false-positive rate, tightening ratio, growth factor, and tightening
+// schedule and use the same seed and Transformer. Returns an error if they are
+// incompatible or if this filter's partitions are created by a factory, whose
+// data couldn't be reopened after a merge.
+func (s *ScalableBloomFilter) Merge(other *ScalableBloomFilter) error {
+	if s.fp != other.fp || s.r != other.r {
+		return errors.New("false-positive rate and tightening ratio must match")
+	}
+
+	if s.seed != other.seed {
+		return errors.New("hash seed must match")
+	}
+
+	if transformerName(s.transformer) != transformerName(other.transformer) {
+		return errors.New("transformers must match")
+	}
+
+	if s.GrowthFactor() != other.GrowthFactor() || !equalRatios(s.schedule, other.schedule) {
+		return errors.New("growth factor and tightening schedule must match")
+	}
+
+	if s.buckets != nil {
+		return errors.New("filters with buckets from a factory can't be merged into")
+	}
+
+	hash := s.hash
+	if len(s.filters) > 0 {
+		hash = s.filters[0].hash
+	} else if hash == nil {
+		hash = newFNV64()
+	}
+
+	filters := make([]*PartitionedBloomFilter, len(other.filters))
+	for i, filter := range other.filters {
+		filters[i] = filter.clone(hash)
+	}
+
+	s.filters = append(s.filters, filters...)
+	return nil
+}
+
+// Compact rebuilds the series into as few filters as possible, such as once a
+// long-lived filter has accumulated many small filters which every Test probes.
+// Membership can't be recovered from the bits, so the keys are re-fed from the
+// iterator, which must yield every key added. The first filter of the new
+// series is sized for the estimated number of distinct items or the size hint
+// if larger, which becomes the size hint of the filters added afterwards, and
+// more are added as usual if the iterator yields more keys, within the bound
+// set with WithMaxFilters or WithMaxBytes, whose overflow policy applies to the
+// keys beyond it. Returns an error if keys is nil, the iterator fails, or the
+// filter's partitions are created by a factory, in which case the filter is
+// unchanged.
+func (s *ScalableBloomFilter) Compact(keys Iterator) error {
+	if keys == nil {
+		return errors.New("the keys are required to compact the filter")
+	}
+
+	if s.buckets != nil {
+		return errors.New("filters with buckets from a factory can't be compacted")
+	}
+
+	hint := s.ApproximatedSize()
+	if hint < s.hint {
+		hint = s.hint
+	}
+
+	compacted := &ScalableBloomFilter{
+		filters: make([]*PartitionedBloomFilter, 0, 1),
+		r:       s.r,
+		fp:      s.fp,
+		p:       s.p,
+		hint:    hint,
+		hash:    s.hash,
+		seed:    s.seed,
+		modulo:  s.modulo,
+
+		growth:   s.growth,
+		schedule: s.schedule,
+
+		transformer: s.transformer,
+
+		maxFilters: s.maxFilters,
+		maxBytes:   s.maxBytes,
+		overflow:   s.overflow,
+	}
+	if len(s.filters) > 0 {
+		compacted.hash = s.filters[0].hash
+	}
+	if err := keys(func(key []byte) error {
+		compacted.Add(key)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if len(compacted.filters) == 0 && !s.lazy {
+		compacted.addFilter()
+	}
+	s.filters = compacted.filters
+	s.hint = hint
+	s.overflows += compacted.overflows
+	return nil
+}
+
+// Equal returns true if the other Scalable Bloom Filter has the same
+// parameters, hash seed, reduction of hashes to indices, growth factor,
+// tightening schedule, Transformer, bound, and overflow policy and every
+// filter in its series is equal to the corresponding one of this series. The
+// hash functions aren't compared.
+func (s *ScalableBloomFilter) Equal(other *ScalableBloomFilter) bool {
+	if s.r != other.r || s.fp != other.fp || s.p != other.p || s.hint != other.hint ||
+		s.seed != other.seed || s.modulo != other.modulo || len(s.filters) != len(other.filters) ||
+		s.GrowthFactor() != other.GrowthFactor() || !equalRatios(s.schedule, other.schedule) ||
+		s.maxFilters != other.maxFilters || s.maxBytes != other.maxBytes || s.overflow != other.overflow ||
+		transformerName(s.transformer) != transformerName(other.transformer) {
+		return false
+	}
+
+	for i, filter := range s.filters {
+		if !filter.Equal(other.filters[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the Scalable Bloom Filter, including
+// every filter in the series, which can be used concurrently with the
+// original. The copy's filters are held in memory, and so are the filters it
+// adds, even if the original's partitions are created by a factory.
+func (s *ScalableBloomFilter) Clone() *ScalableBloomFilter {
+	c := *s
+	c.hash = cloneHash64(s.hash)
+	c.buckets = nil
+	c.chunks = nil
+	c.filters = make([]*PartitionedBloomFilter, len(s.filters))
+
+	// Every filter in the series shares the hash function.
+	hash := c.hash
+	if len(s.filters) > 0 {
+		hash = cloneHash64(s.filters[0].hash)
+	}
+	for i, filter := range s.filters {
+		c.filters[i] = filter.clone(hash)
+	}
+	return &c
+}
+
+// ByteSize returns the number of bytes used by every filter in the series and
+// the metadata of the Scalable Bloom Filter, excluding the hash function. It
+// grows as filters are added.
+func (s *ScalableBloomFilter) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*s)) + uint64(cap(s.filters))*pointerSize + uint64(cap(s.schedule))*8
+	for _, filter := range s.filters {
+		size += filter.ByteSize()
+	}
+	return size
+}
+
 // Reset restores the Bloom filter to its original state. It returns the filter
 // to allow for chaining.
 func (s *ScalableBloomFilter) Reset() *ScalableBloomFilter {
+	s.overflows = 0
+	if s.buckets != nil && len(s.filters) > 0 {
+		// Clear the buckets so reopening them doesn't restore the filters.
+		for _, filter := range s.filters {
+			filter.Reset()
+			filter.Close()
+		}
+		s.filters = s.filters[:0]
+		s.addFilter()
+		return s
+	}
+
 	s.filters = make([]*PartitionedBloomFilter, 0, 1)
-	s.addFilter()
+	if !s.lazy {
+		s.addFilter()
+	}
 	return s
 }
 
-// addFilter adds a new Bloom filter with a restricted false-positive rate to
-// the Scalable Bloom Filter
-func (s *ScalableBloomFilter) addFilter() {
-	fpRate := s.fp * math.Pow(s.r, float64(len(s.filters)))
-	p := NewPartitionedBloomFilter(s.hint, fpRate)
+// Err returns the first error of the factory passed to
+// NewScalableBloomFilterWithBuckets when adding a filter to the series. While
+// a filter can't be added, items are added to the last filter.
+func (s *ScalableBloomFilter) Err() error {
+	return s.err
+}
+
+// Sync flushes the data of every filter to the files backing their
+// partitions, if they're memory-mapped.
+func (s *ScalableBloomFilter) Sync() error {
+	for _, filter := range s.filters {
+		if err := filter.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the storage backing every filter's partitions, such as
+// memory-mapped files. The filter must not be used afterwards.
+func (s *ScalableBloomFilter) Close() error {
+	var err error
+	for _, filter := range s.filters {
+		if cerr := filter.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// addFilter adds a new Bloom filter with a restricted false-positive rate,
+// sized for the grown size hint, to the Scalable Bloom Filter. Returns an
+// error if the filter's buckets can't be created.
+func (s *ScalableBloomFilter) addFilter() error {
+	var (
+		hint   = s.stageHint(len(s.filters))
+		fpRate = s.stageFPRate(len(s.filters))
+		p      *PartitionedBloomFilter
+		opts   = []Option{WithSeed(s.seed)}
+	)
+	if s.modulo {
+		opts = append(opts, WithModuloReduction())
+	}
+	if s.buckets != nil {
+		index := 0
+		for _, filter := range s.filters {
+			index += int(filter.k)
+		}
+
+		var err error
+		p, err = newPartitionedBloomFilterWithBuckets(hint, fpRate, s.buckets, index, opts...)
+		if err != nil {
+			if s.err == nil {
+				s.err = err
+			}
+			return err
+		}
+	} else {
+		p = NewPartitionedBloomFilter(hint, fpRate, opts...)
+	}
+
 	if len(s.filters) > 0 {
 		p.SetHash(s.filters[0].hash)
+	} else if s.hash != nil {
+		p.SetHash(s.hash)
 	}
 	s.filters = append(s.filters, p)
+	return nil
+}
+
+// WriteTo writes a binary representation of the ScalableBloomFilter, including
+// every filter in the series, to an I/O stream. The hash function is not
+// written, but the seed, the name of the Transformer set with WithTransformer,
+// and the growth factor and tightening schedule are. It returns the number of
+// bytes written.
+func (s *ScalableBloomFilter) WriteTo(stream io.Writer) (int64, error) {
+	var lazy uint8
+	if s.lazy {
+		lazy = 1
+	}
+
+	var e encoder
+	e.write(s.r)
+	e.write(s.fp)
+	e.write(s.p)
+	e.write(uint64(s.hint))
+	e.write(lazy)
+	e.write(uint64(len(s.filters)))
+	for _, filter := range s.filters {
+		e.writeTo(filter)
+	}
+	e.write(s.seed)
+	s.writeBound(&e)
+	e.writeBytes([]byte(transformerName(s.transformer)))
+	s.writeGrowth(&e)
+	writeReduction(&e, s.modulo)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a ScalableBloomFilter (such as
+// might have been written by WriteTo()) from an I/O stream. The filter keeps
+// its current hash function, which must match the one used by the filter that
+// was written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package, or ErrTransformerMismatch if it was
+// written with a different Transformer than the one the filter was created
+// with. It returns the number of bytes read.
+func (s *ScalableBloomFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d        = decoder{r: payload}
+		r, fp, p float64
+		hint, l  uint64
+		lazy     uint8
+		hash     = s.hash
+	)
+	d.read(&r)
+	d.read(&fp)
+	d.read(&p)
+	d.read(&hint)
+	d.read(&lazy)
+	d.read(&l)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if hash == nil && len(s.filters) > 0 {
+		hash = s.filters[0].hash
+	} else if hash == nil {
+		hash = newFNV64()
+	}
+
+	filters := make([]*PartitionedBloomFilter, 0, 1)
+	for i := uint64(0); i < l && d.err == nil; i++ {
+		filter := &PartitionedBloomFilter{hash: hash}
+		d.readFrom(filter)
+		filters = append(filters, filter)
+	}
+	var seed uint64
+	if payload.Len() > 0 {
+		// Written by format version 2.3 or later.
+		d.read(&seed)
+	}
+	bound, err := readScalableBound(&d, payload)
+	if err != nil {
+		return n, err
+	}
+	if err := readTransformerName(&d, payload, s.transformer); err != nil {
+		return n, err
+	}
+	growth, err := readScalableGrowth(&d, payload)
+	if err != nil {
+		return n, err
+	}
+	modulo, err := readReduction(&d, payload)
+	if err != nil {
+		return n, err
+	}
+
+	s.filters = filters
+	s.setBound(bound)
+	s.setGrowth(growth)
+	s.r = r
+	s.fp = fp
+	s.p = p
+	s.hint = uint(hint)
+	s.lazy = lazy == 1
+	s.seed = seed
+	s.modulo = modulo
+	return n, nil
+}
+
+// scalableBound is the bound and overflow policy of a ScalableBloomFilter as
+// written by WriteTo.
+type scalableBound struct {
+	maxFilters, maxBytes, overflows uint64
+	overflow                        uint8
+}
+
+// writeBound writes the bound and overflow policy following the seed.
+func (s *ScalableBloomFilter) writeBound(e *encoder) {
+	e.write(uint64(s.maxFilters))
+	e.write(s.maxBytes)
+	e.write(uint8(s.overflow))
+	e.write(uint64(s.overflows))
+}
+
+// readScalableBound reads the bound and overflow policy following the seed, if
+// they were written, returning the decoder's error if reading failed.
+func readScalableBound(d *decoder, payload *bytes.Reader) (scalableBound, error) {
+	var bound scalableBound
+	if payload.Len() > 0 {
+		// Written with a bound.
+		d.read(&bound.maxFilters)
+		d.read(&bound.maxBytes)
+		d.read(&bound.overflow)
+		d.read(&bound.overflows)
+	}
+	if d.err != nil {
+		return bound, d.err
+	}
+
+	if OverflowPolicy(bound.overflow) > OverflowRefuse {
+		return bound, errors.New("unknown overflow policy")
+	}
+	return bound, nil
+}
+
+// setBound sets the bound and overflow policy read by readScalableBound.
+func (s *ScalableBloomFilter) setBound(bound scalableBound) {
+	s.maxFilters = uint(bound.maxFilters)
+	s.maxBytes = bound.maxBytes
+	s.overflow = OverflowPolicy(bound.overflow)
+	s.overflows = uint(bound.overflows)
+}
+
+// scalableGrowth is the growth factor and tightening schedule of a
+// ScalableBloomFilter as written by WriteTo.
+type scalableGrowth struct {
+	growth   uint64
+	schedule []float64
+}
+
+// writeGrowth writes the growth factor and tightening schedule following the
+// name of the Transformer.
+func (s *ScalableBloomFilter) writeGrowth(e *encoder) {
+	e.write(uint64(s.GrowthFactor()))
+	e.write(uint64(len(s.schedule)))
+	e.write(s.schedule)
+}
+
+// readScalableGrowth reads the growth factor and tightening schedule following
+// the name of the Transformer, if they were written, returning the decoder's
+// error if reading failed. Filters written without them grow by a factor of
+// one.
+func readScalableGrowth(d *decoder, payload *bytes.Reader) (scalableGrowth, error) {
+	growth := scalableGrowth{growth: 1}
+	if payload.Len() > 0 {
+		// Written by format version 2.7 or later.
+		var l uint64
+		d.read(&growth.growth)
+		d.read(&l)
+		if d.err == nil && l > uint64(payload.Len())/8 {
+			return growth, io.ErrUnexpectedEOF
+		}
+		if d.err == nil && l > 0 {
+			growth.schedule = make([]float64, l)
+			d.read(growth.schedule)
+		}
+	}
+	if d.err != nil {
+		return growth, d.err
+	}
+
+	if growth.growth == 0 {
+		return growth, errors.New("growth factor must be positive")
+	}
+	for _, ratio := range growth.schedule {
+		if ratio <= 0 || ratio >= 1 {
+			return growth, errors.New("tightening ratio must be in (0, 1)")
+		}
+	}
+	return growth, nil
+}
+
+// setGrowth sets the growth factor and tightening schedule read by
+// readScalableGrowth.
+func (s *ScalableBloomFilter) setGrowth(growth scalableGrowth) {
+	s.growth = uint(growth.growth)
+	s.schedule = growth.schedule
+}
+
+// equalRatios returns true if both tightening schedules hold the same ratios.
+func equalRatios(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ScanDump returns the chunk of the filter following the iterator, along with
+// the iterator to load the chunk with and to pass to the next call, like
+// RedisBloom's BF.SCANDUMP. Start with an iterator of zero. The first chunk
+// holds the filter's parameters and each following chunk at most maxChunkSize
+// bytes of its data, so large filters can be stored or sent in pieces of
+// bounded size. An iterator of zero and a nil chunk are returned once the dump
+// is complete. The filter must not be modified until then. Returns an error if
+// the filter's buckets use a BucketStore.
+func (s *ScalableBloomFilter) ScanDump(iter uint64, maxChunkSize int) (uint64, []byte, error) {
+	return scanDump(s, iter, maxChunkSize)
+}
+
+// LoadChunk restores a chunk returned by ScanDump along with its iterator,
+// like RedisBloom's BF.LOADCHUNK. The chunks must be loaded in the order they
+// were dumped, starting with the first. They're held until the last one is
+// loaded, which replaces the filter, so a header describing more data than is
+// loaded doesn't allocate it. The filter keeps its current hash function,
+// which must match the one used by the filter that was dumped.
+func (s *ScalableBloomFilter) LoadChunk(iter uint64, chunk []byte) error {
+	return loadChunk(s, &s.chunks, iter, chunk)
+}
+
+// chunkHeader returns the parameters of the filter and of each filter in the
+// series.
+func (s *ScalableBloomFilter) chunkHeader() ([]byte, error) {
+	for _, filter := range s.filters {
+		for _, partition := range filter.partitions {
+			if partition.store != nil {
+				return nil, errors.New("buckets using a BucketStore can't be dumped in chunks")
+			}
+		}
+	}
+
+	var lazy uint8
+	if s.lazy {
+		lazy = 1
+	}
+
+	var e encoder
+	e.write(s.r)
+	e.write(s.fp)
+	e.write(s.p)
+	e.write(uint64(s.hint))
+	e.write(lazy)
+	e.write(uint64(len(s.filters)))
+	for _, filter := range s.filters {
+		e.write(uint64(filter.m))
+		e.write(uint64(filter.k))
+		e.write(uint64(filter.s))
+		e.write(uint64(filter.count))
+	}
+	e.write(s.seed)
+	s.writeBound(&e)
+	e.writeBytes([]byte(transformerName(s.transformer)))
+	s.writeGrowth(&e)
+	writeReduction(&e, s.modulo)
+	for _, filter := range s.filters {
+		writeReduction(&e, filter.modulo)
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	var buf bytes.Buffer
+	_, err := writeFrame(&buf, e.buf.Bytes())
+	return buf.Bytes(), err
+}
+
+// scalableChunkHeader is the header of a ScalableBloomFilter's dump.
+type scalableChunkHeader struct {
+	r, fp, p float64
+	hint     uint64
+	lazy     uint8
+	filters  []partitionedChunkHeader
+	seed     uint64
+	bound    scalableBound
+	name     string // name of the Transformer
+	growth   scalableGrowth
+	modulo   bool // filters added reduce hashes to indices with a modulo
+}
+
+// partitionedChunkHeader describes a filter in the series of a dump.
+type partitionedChunkHeader struct {
+	m, k, s, count uint64
+	modulo         bool // reduce hashes to indices with a modulo
+}
+
+// readScalableChunkHeader reads and validates the header, returning the size
+// of the filters' data it describes.
+func readScalableChunkHeader(header []byte) (scalableChunkHeader, uint64, error) {
+	var h scalableChunkHeader
+	payload, _, err := readFrame(bytes.NewReader(header))
+	if err != nil {
+		return h, 0, err
+	}
+
+	var (
+		d = decoder{r: payload}
+		l uint64
+	)
+	d.read(&h.r)
+	d.read(&h.fp)
+	d.read(&h.p)
+	d.read(&h.hint)
+	d.read(&h.lazy)
+	d.read(&l)
+	if d.err != nil {
+		return h, 0, d.err
+	}
+
+	// Every filter takes 32 bytes for its parameters.
+	if l > uint64(payload.Len())/32 {
+		return h, 0, io.ErrUnexpectedEOF
+	}
+
+	var (
+		size uint64
+		ok   = true
+	)
+	h.filters = make([]partitionedChunkHeader, l)
+	for i := range h.filters {
+		f := &h.filters[i]
+		d.read(&f.m)
+		d.read(&f.k)
+		d.read(&f.s)
+		d.read(&f.count)
+		if d.err != nil {
+			return h, 0, d.err
+		}
+
+		// The partitions hold at least the m bits of the filter.
+		if f.k == 0 || f.s == 0 || f.m/f.k > f.s || (f.m/f.k == f.s && f.m%f.k != 0) {
+			return h, 0, errors.New("partitions don't match filter size")
+		}
+		if size, ok = addChunkDataSize(size, f.k, (f.s+7)/8); !ok {
+			return h, 0, errors.New("partitions don't match filter size")
+		}
+	}
+
+	if payload.Len() > 0 {
+		// Written by format version 2.3 or later.
+		d.read(&h.seed)
+	}
+	if h.bound, err = readScalableBound(&d, payload); err != nil {
+		return h, 0, err
+	}
+	if payload.Len() > 0 {
+		// Written by format version 2.5 or later.
+		h.name = string(d.readBytes())
+	}
+	if h.growth, err = readScalableGrowth(&d, payload); err != nil {
+		return h, 0, err
+	}
+	if h.modulo, err = readReduction(&d, payload); err != nil {
+		return h, 0, err
+	}
+	for i := range h.filters {
+		if h.filters[i].modulo, err = readReduction(&d, payload); err != nil {
+			return h, 0, err
+		}
+	}
+	return h, size, nil
+}
+
+// chunkHeaderDataSize validates the header and returns the size of the
+// filters' data it describes.
+func (s *ScalableBloomFilter) chunkHeaderDataSize(header []byte) (uint64, error) {
+	h, size, err := readScalableChunkHeader(header)
+	if err == nil && h.name != transformerName(s.transformer) {
+		err = ErrTransformerMismatch
+	}
+	return size, err
+}
+
+// loadChunkHeader replaces the filter with an empty one described by the
+// header.
+func (s *ScalableBloomFilter) loadChunkHeader(header []byte) error {
+	h, _, err := readScalableChunkHeader(header)
+	if err != nil {
+		return err
+	}
+	if h.name != transformerName(s.transformer) {
+		return ErrTransformerMismatch
+	}
+
+	hash := s.hash
+	if hash == nil && len(s.filters) > 0 {
+		hash = s.filters[0].hash
+	} else if hash == nil {
+		hash = newFNV64()
+	}
+
+	filters := make([]*PartitionedBloomFilter, 0, len(h.filters))
+	for _, f := range h.filters {
+		partitions := make([]*Buckets, f.k)
+		for j := range partitions {
+			partitions[j] = NewBuckets(uint(f.s), 1)
+		}
+		filters = append(filters, &PartitionedBloomFilter{
+			partitions: partitions,
+			hash:       hash,
+			m:          uint(f.m),
+			k:          uint(f.k),
+			s:          uint(f.s),
+			count:      uint(f.count),
+			seed:       h.seed,
+			modulo:     f.modulo,
+		})
+	}
+
+	s.filters = filters
+	s.setBound(h.bound)
+	s.setGrowth(h.growth)
+	s.r = h.r
+	s.fp = h.fp
+	s.p = h.p
+	s.hint = uint(h.hint)
+	s.lazy = h.lazy == 1
+	s.seed = h.seed
+	s.modulo = h.modulo
+	return nil
+}
+
+// chunkSlices returns the data of every partition of every filter in the
+// series.
+func (s *ScalableBloomFilter) chunkSlices() [][]byte {
+	var slices [][]byte
+	for _, filter := range s.filters {
+		for _, partition := range filter.partitions {
+			slices = append(slices, partition.data)
+		}
+	}
+	return slices
+}
+
+// chunkDataSize returns the combined size of the filters' data in bytes.
+func (s *ScalableBloomFilter) chunkDataSize() uint64 {
+	var size uint64
+	for _, data := range s.chunkSlices() {
+		size += uint64(len(data))
+	}
+	return size
+}
+
+// readChunkData copies the filters' data starting at the offset into p.
+func (s *ScalableBloomFilter) readChunkData(offset uint64, p []byte) {
+	copySlices(s.chunkSlices(), offset, p, false)
+}
+
+// writeChunkData copies p into the filters' data starting at the offset.
+func (s *ScalableBloomFilter) writeChunkData(offset uint64, p []byte) {
+	copySlices(s.chunkSlices(), offset, p, true)
 }
 
 // SetHash sets the hashing function used in the filter.
 // For the effect on false positive rates see: https://github.com/tylertreat/BoomFilters/pull/1
 func (s *ScalableBloomFilter) SetHash(h hash.Hash64) {
+	s.hash = h
 	for _, bf := range s.filters {
 		bf.SetHash(h)
 	}
 }
+
+// ScalableMemory returns the estimated number of bytes used by the filters of
+// a ScalableBloomFilter with the specified hint, target false-positive rate,
+// and tightening ratio, growing by a factor of one without a tightening
+// schedule, once n items have been added to it.
+func ScalableMemory(n, hint uint, fpRate, r float64) uint64 {
+	var (
+		memory   = uint64(0)
+		capacity = uint(0)
+	)
+	for i := 0; i == 0 || capacity < n; i++ {
+		stageFP := fpRate * math.Pow(r, float64(i))
+		memory += PartitionedMemory(hint, stageFP)
+		capacity += stageCapacity(hint, stageFP)
+	}
+	return memory
+}
+
+// ExpectedStages returns the number of filters a ScalableBloomFilter with the
+// specified hint and tightening ratio, growing by a factor of one, is expected
+// to contain once n items have been added to it. The tightening ratio shrinks
+// the false-positive rate of each filter but not the number of items it's
+// sized for, so every filter holds about hint items. The count is exact when
+// n is a multiple of the hint and the rates are powers of two, such as 1/128
+// tightened by 0.5. Other rates round up the number of hash functions, so the
+// filters fill up slightly earlier and more of them may be used.
+func ExpectedStages(hint uint, r float64, n uint) int {
+	if hint == 0 {
+		hint = 1
+	}
+	stages := n / hint
+	if n%hint != 0 || stages == 0 {
+		stages++
+	}
+	return int(stages)
+}
+
+// stageCapacity returns the number of items which can be added to a filter of
+// a ScalableBloomFilter with the given hint and false-positive rate before it
+// reaches the fill ratio and a new filter is added.
+func stageCapacity(hint uint, fpRate float64) uint {
+	var (
+		m = OptimalM(hint, fpRate)
+		k = OptimalK(fpRate)
+		s = math.Ceil(float64(m) / float64(k))
+	)
+
+	// A filter accepts items until its estimated fill ratio,
+	// 1 - e^(-count/s), reaches the fill ratio.
+	return uint(math.Floor(-s*math.Log(1-fillRatio))) + 1
+}
@@ -1,7 +1,12 @@
 package boom
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -58,6 +63,184 @@ func TestScalableFillRatio(t *testing.T) {
 	}
 }
 
+// Ensures that EstimatedFPRate combines the rates of every filter and stays
+// below the bound of the series.
+func TestScalableEstimatedFPRate(t *testing.T) {
+	f := NewScalableBloomFilter(100, 0.01, 0.8)
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	rate := f.EstimatedFPRate()
+	if last := f.filters[len(f.filters)-1].EstimatedFPRate(); rate <= last {
+		t.Errorf("Expected more than the last filter's %f, got %f", last, rate)
+	}
+	if bound := 0.01 / (1 - 0.8); rate > bound {
+		t.Errorf("Expected at most %f, got %f", bound, rate)
+	}
+}
+
+// Ensures that ApproximatedSize sums the estimates of every filter.
+func TestScalableApproximatedSize(t *testing.T) {
+	f := NewScalableBloomFilter(100, 0.01, 0.8)
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	if size := f.ApproximatedSize(); size < 950 || size > 1050 {
+		t.Errorf("Expected approximately 1000, got %d", size)
+	}
+}
+
+// Ensures that Stats describes every filter in the series in order.
+func TestScalableStats(t *testing.T) {
+	f := NewScalableBloomFilter(100, 0.01, 0.8)
+	if stats := f.Stats(); len(stats) != 1 || stats[0].Count != 0 || stats[0].Full {
+		t.Errorf("Expected a single empty filter, got %+v", stats)
+	}
+
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	stats := f.Stats()
+	if len(stats) < 2 {
+		t.Fatalf("Expected several filters, got %d", len(stats))
+	}
+	count, capacity := uint(0), uint(0)
+	for i, stat := range stats {
+		if stat.Index != i {
+			t.Errorf("Expected index %d, got %d", i, stat.Index)
+		}
+		if stat.Full != (i < len(stats)-1) {
+			t.Errorf("Expected every filter but the last to be full, got %+v", stat)
+		}
+		if rate := 0.01 * math.Pow(0.8, float64(i)); math.Abs(stat.FPRate-rate) > rate/10 {
+			t.Errorf("Expected rate of filter %d close to %f, got %f", i, rate, stat.FPRate)
+		}
+		if stat.FillRatio <= 0 || stat.FillRatio > 1 {
+			t.Errorf("Expected fill ratio in (0, 1], got %f", stat.FillRatio)
+		}
+		count += stat.Count
+		capacity += stat.Capacity
+	}
+	if count != 1000 || capacity != f.Capacity() {
+		t.Errorf("Expected count 1000 and capacity %d, got %d and %d", f.Capacity(), count, capacity)
+	}
+}
+
+// Ensures that WithGrowthFactor sizes each filter for the grown hint, so the
+// series takes fewer filters, each keeping its target rate.
+func TestScalableGrowthFactor(t *testing.T) {
+	var (
+		f      = NewScalableBloomFilter(100, 0.01, 0.8)
+		grown  = NewScalableBloomFilter(100, 0.01, 0.8, WithGrowthFactor(2))
+		buf    bytes.Buffer
+		loaded = NewScalableBloomFilter(100, 0.01, 0.8)
+	)
+	if f.GrowthFactor() != 1 || grown.GrowthFactor() != 2 {
+		t.Errorf("Expected growth factors 1 and 2, got %d and %d", f.GrowthFactor(), grown.GrowthFactor())
+	}
+	for i := 0; i < 5000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+		grown.Add([]byte(strconv.Itoa(i)))
+	}
+
+	stats := grown.Stats()
+	if len(stats) >= len(f.Stats()) {
+		t.Errorf("Expected fewer than %d filters, got %d", len(f.Stats()), len(stats))
+	}
+	for i, stat := range stats {
+		if i > 0 && stat.Capacity < 2*stats[i-1].Capacity {
+			t.Errorf("Expected filter %d to at least double in size, got %d after %d", i, stat.Capacity, stats[i-1].Capacity)
+		}
+		if rate := 0.01 * math.Pow(0.8, float64(i)); math.Abs(stat.FPRate-rate) > rate/10 {
+			t.Errorf("Expected rate of filter %d close to %f, got %f", i, rate, stat.FPRate)
+		}
+	}
+
+	if _, err := grown.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := loaded.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !loaded.Equal(grown) {
+		t.Error("Expected the growth factor to be restored")
+	}
+
+	if err := f.Merge(grown); err == nil {
+		t.Error("Expected error for mismatched growth factor")
+	}
+}
+
+// Ensures that WithTighteningSchedule tightens the first filters by its ratios
+// before the tightening ratio applies, and that FPBound and RemainingFPBudget
+// account for it.
+func TestScalableTighteningSchedule(t *testing.T) {
+	f := NewScalableBloomFilter(100, 0.01, 0.8)
+	if bound := f.FPBound(); math.Abs(bound-0.05) > 1e-9 {
+		t.Errorf("Expected bound 0.05, got %f", bound)
+	}
+	if remaining := f.RemainingFPBudget(); math.Abs(remaining-0.04) > 1e-9 {
+		t.Errorf("Expected remaining budget 0.04, got %f", remaining)
+	}
+
+	f = NewScalableBloomFilter(100, 0.01, 0.8, WithTighteningSchedule(0.5, 0.9))
+	if schedule := f.TighteningSchedule(); len(schedule) != 2 || f.TighteningRatio() != 0.8 {
+		t.Errorf("Expected schedule of 2 ratios and ratio 0.8, got %v and %f", schedule, f.TighteningRatio())
+	}
+	if bound, expected := f.FPBound(), 0.01+0.005+0.0045/0.2; math.Abs(bound-expected) > 1e-9 {
+		t.Errorf("Expected bound %f, got %f", expected, bound)
+	}
+
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	stats := f.Stats()
+	if len(stats) < 4 {
+		t.Fatalf("Expected several filters, got %d", len(stats))
+	}
+	used := 0.0
+	for i, rate := range []float64{0.01, 0.005, 0.0045, 0.0036} {
+		if math.Abs(stats[i].FPRate-rate) > rate/10 {
+			t.Errorf("Expected rate of filter %d close to %f, got %f", i, rate, stats[i].FPRate)
+		}
+	}
+	for i := range stats {
+		used += f.stageFPRate(i)
+	}
+	if remaining := f.RemainingFPBudget(); math.Abs(remaining-(f.FPBound()-used)) > 1e-9 || remaining <= 0 {
+		t.Errorf("Expected remaining budget %f, got %f", f.FPBound()-used, remaining)
+	}
+
+	if _, err := NewScalableBloomFilterWithOptions(WithTighteningSchedule(0.5, 1)); err == nil {
+		t.Error("Expected error for out of range tightening ratio")
+	}
+}
+
+// Ensures that NewScalableBloomFilterFromReader adds every delimited key of
+// the stream, growing past the hint if needed.
+func TestNewScalableBloomFilterFromReader(t *testing.T) {
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+
+	f, err := NewScalableBloomFilterFromReader(io.MultiReader(strings.NewReader(strings.Join(keys, ","))), ',', 0.01, 0.8, WithHint(100))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(f.Stats()) < 2 {
+		t.Errorf("Expected the filter to grow, got %d filters", len(f.Stats()))
+	}
+	for _, key := range keys {
+		if !f.TestString(key) {
+			t.Errorf("Expected %s to be a member", key)
+		}
+	}
+}
+
 // Ensures that Test, Add, and TestAndAdd behave correctly.
 func TestScalableBloomTestAndAdd(t *testing.T) {
 	f := NewScalableBloomFilter(1000, 0.01, 0.8)
@@ -111,6 +294,50 @@ func TestScalableBloomTestAndAdd(t *testing.T) {
 	}
 }
 
+// Ensures that TestWhich returns the index of the filter the data was added
+// to.
+func TestScalableBloomTestWhich(t *testing.T) {
+	f := NewScalableBloomFilter(100, 0.001, 0.8)
+	f.Add([]byte(`foo`))
+
+	for i := 0; len(f.filters) < 3; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	f.Add([]byte(`bar`))
+
+	if found, stage := f.TestWhich([]byte(`foo`)); !found || stage != 0 {
+		t.Errorf("Expected `foo` in stage 0, got %v %d", found, stage)
+	}
+
+	if found, stage := f.TestWhich([]byte(`bar`)); !found || stage != 2 {
+		t.Errorf("Expected `bar` in stage 2, got %v %d", found, stage)
+	}
+
+	if found, stage := f.TestWhich([]byte(`baz`)); found || stage != -1 {
+		t.Errorf("Expected `baz` not to be found, got %v %d", found, stage)
+	}
+}
+
+// Ensures that ExpectedStages predicts the number of filters used to store n
+// items, and doesn't overestimate it for rates which aren't powers of two.
+func TestExpectedStages(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 1000, 5000} {
+		f := NewScalableBloomFilter(100, 1.0/128, 0.5)
+		other := NewScalableBloomFilter(100, 0.01, 0.8)
+		for i := 0; i < n; i++ {
+			f.Add([]byte(strconv.Itoa(i)))
+			other.Add([]byte(strconv.Itoa(i)))
+		}
+
+		if expected, actual := ExpectedStages(100, 0.5, uint(n)), len(f.filters); expected != actual {
+			t.Errorf("Expected %d stages for %d items, got %d", expected, n, actual)
+		}
+		if expected, actual := ExpectedStages(100, 0.8, uint(n)), len(other.filters); expected > actual {
+			t.Errorf("Expected at most %d stages for %d items, got %d", actual, n, expected)
+		}
+	}
+}
+
 // Ensures that Reset removes all Bloom filters and resets the initial one.
 func TestScalableBloomReset(t *testing.T) {
 	f := NewScalableBloomFilter(10, 0.1, 0.8)
@@ -139,6 +366,211 @@ func TestScalableBloomReset(t *testing.T) {
 	}
 }
 
+// Ensures that Merge appends copies of the other filter's series and returns
+// an error for incompatible filters.
+func TestScalableBloomMerge(t *testing.T) {
+	a := NewScalableBloomFilter(100, 0.01, 0.8)
+	b := NewScalableBloomFilter(100, 0.01, 0.8)
+	for i := 0; i < 300; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+		b.Add([]byte(strconv.Itoa(i + 300)))
+	}
+	stages := len(a.filters) + len(b.filters)
+
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(a.filters) != stages {
+		t.Errorf("Expected %d filters, got %d", stages, len(a.filters))
+	}
+
+	for i := 0; i < 600; i++ {
+		if !a.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+
+	// The merged filters are copies.
+	b.Reset()
+	if !a.Test([]byte(`300`)) {
+		t.Error("Expected `300` to be a member")
+	}
+	a.Add([]byte(`foo`))
+	if b.Test([]byte(`foo`)) {
+		t.Error("Expected `foo` not to be a member")
+	}
+
+	if err := a.Merge(NewScalableBloomFilter(100, 0.1, 0.8)); err == nil {
+		t.Error("Expected error for mismatched false-positive rate")
+	}
+
+	if err := a.Merge(NewScalableBloomFilter(100, 0.01, 0.8, WithSeed(42))); err == nil {
+		t.Error("Expected error for mismatched seed")
+	}
+}
+
+// Ensures that Compact rebuilds the series into fewer filters holding the
+// re-fed keys and leaves the filter unchanged on error.
+func TestScalableBloomCompact(t *testing.T) {
+	f := NewScalableBloomFilter(10, 0.01, 0.8)
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	stages := len(f.filters)
+
+	keys := func(fn func([]byte) error) error {
+		for i := 0; i < 1000; i++ {
+			if err := fn([]byte(strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := f.Compact(keys); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(f.filters) >= stages || len(f.filters) > 2 {
+		t.Errorf("Expected at most 2 filters, fewer than %d, got %d", stages, len(f.filters))
+	}
+
+	for i := 0; i < 1000; i++ {
+		if !f.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+
+	if rate := f.EstimatedFPRate(); rate > 0.02 {
+		t.Errorf("Expected a false-positive rate of at most 0.02, got %f", rate)
+	}
+
+	stages = len(f.filters)
+	if err := f.Compact(func(func([]byte) error) error {
+		return errors.New("read failed")
+	}); err == nil {
+		t.Error("Expected error from the iterator")
+	}
+	if len(f.filters) != stages || !f.Test([]byte(`0`)) {
+		t.Error("Expected the filter to be unchanged")
+	}
+
+	if err := f.Compact(nil); err == nil {
+		t.Error("Expected error without keys")
+	}
+}
+
+// Ensures that Compact keeps the bound and overflow policy, so the compacted
+// series doesn't grow past the bound when the iterator yields more keys than
+// the first filter holds.
+func TestScalableBloomCompactBounded(t *testing.T) {
+	f := NewScalableBloomFilter(10, 0.01, 0.8, WithMaxFilters(2), WithOverflow(OverflowRefuse))
+	for i := 0; i < 100; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	if len(f.filters) != 2 {
+		t.Fatalf("Expected 2 filters, got %d", len(f.filters))
+	}
+	overflows := f.OverflowCount()
+
+	if err := f.Compact(func(fn func([]byte) error) error {
+		for i := 0; i < 1000; i++ {
+			if err := fn([]byte(strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(f.filters) > 2 {
+		t.Errorf("Expected at most 2 filters, got %d", len(f.filters))
+	}
+	if f.maxFilters != 2 || f.Overflow() != OverflowRefuse {
+		t.Errorf("Expected the bound and policy to be kept, got %d and %d", f.maxFilters, f.Overflow())
+	}
+	if f.OverflowCount() <= overflows {
+		t.Errorf("Expected the refused keys to be counted, got %d", f.OverflowCount())
+	}
+}
+
+// Ensures that a ScalableBloomFilter bounded by WithMaxFilters or WithMaxBytes
+// stops growing once it reaches the bound and applies its overflow policy.
+func TestScalableBloomOverflow(t *testing.T) {
+	degrade := NewScalableBloomFilter(100, 0.01, 0.8, WithMaxFilters(2))
+	for i := 0; i < 1000; i++ {
+		degrade.Add([]byte(strconv.Itoa(i)))
+	}
+	if len(degrade.filters) != 2 {
+		t.Errorf("Expected 2 filters, got %d", len(degrade.filters))
+	}
+	if degrade.OverflowCount() == 0 {
+		t.Error("Expected overflows")
+	}
+	if rate := degrade.EstimatedFPRate(); rate < 0.1 {
+		t.Errorf("Expected a degraded false-positive rate, got %f", rate)
+	}
+	for i := 0; i < 1000; i++ {
+		if !degrade.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+
+	rotate := NewScalableBloomFilter(100, 0.01, 0.8, WithMaxFilters(2), WithOverflow(OverflowRotate))
+	for i := 0; i < 1000; i++ {
+		rotate.Add([]byte(strconv.Itoa(i)))
+	}
+	if len(rotate.filters) != 2 {
+		t.Errorf("Expected 2 filters, got %d", len(rotate.filters))
+	}
+	if rate := rotate.EstimatedFPRate(); rate > 0.02 {
+		t.Errorf("Expected a false-positive rate of at most 0.02, got %f", rate)
+	}
+	if !rotate.Test([]byte(`999`)) {
+		t.Error("Expected `999` to be a member")
+	}
+	if rotate.Test([]byte(`0`)) {
+		t.Error("Expected `0` to have been rotated out")
+	}
+
+	limit := ScalableMemory(150, 100, 0.01, 0.8)
+	refuse := NewScalableBloomFilter(100, 0.01, 0.8, WithMaxBytes(limit), WithOverflow(OverflowRefuse))
+	refused := 0
+	for i := 0; i < 1000; i++ {
+		if err := refuse.TryAdd([]byte(strconv.Itoa(i))); err == ErrFilterFull {
+			refused++
+		} else if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if len(refuse.filters) != 2 {
+		t.Errorf("Expected 2 filters, got %d", len(refuse.filters))
+	}
+	if refused == 0 || uint(refused) != refuse.OverflowCount() {
+		t.Errorf("Expected %d overflows, got %d", refused, refuse.OverflowCount())
+	}
+	if refuse.Test([]byte(`999`)) {
+		t.Error("Expected `999` not to be a member")
+	}
+
+	var buf bytes.Buffer
+	if _, err := refuse.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	read := &ScalableBloomFilter{}
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !read.Equal(refuse) || read.OverflowCount() != refuse.OverflowCount() {
+		t.Error("Expected the bound and overflow policy to be read")
+	}
+	refuse.Reset()
+	if refuse.OverflowCount() != 0 {
+		t.Errorf("Expected no overflows after Reset, got %d", refuse.OverflowCount())
+	}
+}
+
 func BenchmarkScalableBloomAdd(b *testing.B) {
 	b.StopTimer()
 	f := NewScalableBloomFilter(100000, 0.1, 0.8)
@@ -180,3 +612,185 @@ func BenchmarkScalableBloomTestAndAdd(b *testing.B) {
 		f.TestAndAdd(data[n])
 	}
 }
+
+// Ensures that a lazy ScalableBloomFilter doesn't allocate a filter until the
+// first Add.
+func TestLazyScalableBloomFilter(t *testing.T) {
+	f := NewLazyScalableBloomFilter(1000, 0.01, 0.8)
+
+	if f.Test([]byte(`a`)) {
+		t.Error("`a` should not be a member")
+	}
+
+	if l := len(f.filters); l != 0 {
+		t.Errorf("Expected 0 filters, got %d", l)
+	}
+
+	if c := f.Capacity(); c != 0 {
+		t.Errorf("Expected 0, got %d", c)
+	}
+
+	if r := f.FillRatio(); r != 0 {
+		t.Errorf("Expected 0, got %f", r)
+	}
+
+	if k := f.K(); k != 7 {
+		t.Errorf("Expected 7, got %d", k)
+	}
+
+	f.Add([]byte(`a`))
+	if l := len(f.filters); l != 1 {
+		t.Errorf("Expected 1 filter, got %d", l)
+	}
+
+	if c, e := f.Capacity(), NewScalableBloomFilter(1000, 0.01, 0.8).Capacity(); c != e {
+		t.Errorf("Expected %d, got %d", e, c)
+	}
+
+	if !f.Test([]byte(`a`)) {
+		t.Error("`a` should be a member")
+	}
+
+	f.Reset()
+	if l := len(f.filters); l != 0 {
+		t.Errorf("Expected 0 filters, got %d", l)
+	}
+}
+
+// Ensures that a ScalableBloomFilter read from its written representation has
+// every filter in the series and continues to grow like the original.
+func TestScalableBloomWriteToReadFrom(t *testing.T) {
+	f := NewScalableBloomFilter(100, 0.01, 0.8)
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	restored := NewDefaultScalableBloomFilter(0.1)
+	var buf bytes.Buffer
+	wn, err := f.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	rn, err := restored.ReadFrom(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if wn != rn {
+		t.Errorf("Expected %d bytes read, got %d", wn, rn)
+	}
+
+	if len(restored.filters) != len(f.filters) || restored.Capacity() != f.Capacity() {
+		t.Errorf("Expected %d filters, got %d", len(f.filters), len(restored.filters))
+	}
+
+	for i := 1000; i < 2000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+		restored.Add([]byte(strconv.Itoa(i)))
+	}
+
+	if len(restored.filters) != len(f.filters) {
+		t.Errorf("Expected %d filters, got %d", len(f.filters), len(restored.filters))
+	}
+
+	for i := 0; i < 4000; i++ {
+		data := []byte(strconv.Itoa(i))
+		if restored.Test(data) != f.Test(data) {
+			t.Errorf("Expected %t for %s, got %t", f.Test(data), data, restored.Test(data))
+		}
+	}
+}
+
+// Ensures that a filter restored from the chunks returned by ScanDump matches
+// the original and that no data chunk exceeds the maximum size.
+func TestScalableBloomScanDumpLoadChunk(t *testing.T) {
+	f := NewScalableBloomFilter(100, 0.01, 0.8)
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	var (
+		restored = NewDefaultScalableBloomFilter(0.1)
+		chunks   = 0
+	)
+	for iter := uint64(0); ; {
+		next, chunk, err := f.ScanDump(iter, 100)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if next == 0 {
+			break
+		}
+		if iter > 0 && len(chunk) > 100 {
+			t.Errorf("Expected chunk of at most 100 bytes, got %d", len(chunk))
+		}
+		if err := restored.LoadChunk(next, chunk); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		iter = next
+		chunks++
+	}
+
+	if chunks < 2 {
+		t.Errorf("Expected several chunks, got %d", chunks)
+	}
+
+	if len(restored.filters) != len(f.filters) || restored.Capacity() != f.Capacity() {
+		t.Errorf("Expected %d filters, got %d", len(f.filters), len(restored.filters))
+	}
+
+	for i := 0; i < 4000; i++ {
+		data := []byte(strconv.Itoa(i))
+		if restored.Test(data) != f.Test(data) {
+			t.Errorf("Expected %t for %s, got %t", f.Test(data), data, restored.Test(data))
+		}
+	}
+
+	if err := restored.LoadChunk(1<<40, []byte{1}); err == nil {
+		t.Error("Expected error for chunk beyond the filter")
+	}
+}
+
+// Ensures that LoadChunk rejects a crafted header claiming more filters than
+// it holds or filters of overflowing sizes.
+func TestScalableBloomLoadChunkCraftedHeader(t *testing.T) {
+	header := func(l uint64, filters ...uint64) []byte {
+		var e encoder
+		e.write(0.8)
+		e.write(0.01)
+		e.write(0.5)
+		e.write(uint64(1000))
+		e.write(uint8(0))
+		e.write(l)
+		for _, v := range filters {
+			e.write(v)
+		}
+		e.write(uint64(0))
+		var buf bytes.Buffer
+		if _, err := writeFrame(&buf, e.buf.Bytes()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	f := NewScalableBloomFilter(100, 0.01, 0.8)
+	f.Add([]byte("a"))
+
+	if err := f.LoadChunk(1, header(1<<60)); err == nil {
+		t.Error("Expected error for too many filters")
+	}
+	if err := f.LoadChunk(1, header(1, 1<<62, 1<<62, 1<<62, 0)); err == nil {
+		t.Error("Expected error for overflowing size")
+	}
+
+	if err := f.LoadChunk(1, header(1, 1<<40, 1, 1<<40, 0)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := f.LoadChunk(1+8, make([]byte, 8)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.K() == 0 || !f.Test([]byte("a")) {
+		t.Error("Expected incomplete dump to leave the filter unchanged")
+	}
+}
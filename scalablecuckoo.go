@@ -0,0 +1,284 @@
+package boom
+
+import (
+	"context"
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"unsafe"
+)
+
+// ScalableCuckooFilter implements a Cuckoo Filter which grows on demand, like
+// the Scalable Bloom Filter. It's a series of Cuckoo Filters, each twice the
+// capacity of the previous one with a false-positive rate tightened by the
+// ratio r, so the compounded false-positive rate of the series converges to
+// fpRate / (1 - r). Items are added to the last filter, and a new filter is
+// added once it holds its capacity or an insertion fails, rather than
+// removing an item to make room as a full Cuckoo Filter does.
+//
+// Unlike a Scalable Bloom Filter, items can be removed, from whichever filter
+// holds them.
+type ScalableCuckooFilter struct {
+	filters []*CuckooFilter    // filters with doubling capacities and decreasing error rates
+	r       float64            // tightening ratio
+	fp      float64            // target false-positive rate of the first filter
+	hint    uint               // capacity of the first filter
+	newHash func() hash.Hash32 // creates the filters' hash functions, if set
+	seed    uint64             // hash seed of every filter in the series
+}
+
+// NewScalableCuckooFilter creates a new Scalable Cuckoo Filter whose first
+// filter stores hint items with the false-positive rate fpRate, and whose
+// following filters tighten it by the ratio r, such as 0.8.
+func NewScalableCuckooFilter(hint uint, fpRate, r float64, opts ...Option) *ScalableCuckooFilter {
+	if hint == 0 {
+		hint = 1
+	}
+
+	o := applyOptions(opts)
+	s := &ScalableCuckooFilter{
+		filters: make([]*CuckooFilter, 0, 1),
+		r:       r,
+		fp:      fpRate,
+		hint:    hint,
+		newHash: o.hash32,
+		seed:    o.seed,
+	}
+	s.addFilter()
+	return s
+}
+
+// Capacity returns the current capacity, which is the sum of the capacities
+// of the filters in the series.
+func (s *ScalableCuckooFilter) Capacity() uint {
+	capacity := uint(0)
+	for _, filter := range s.filters {
+		capacity += filter.Capacity()
+	}
+	return capacity
+}
+
+// Count returns the number of items in the filter.
+func (s *ScalableCuckooFilter) Count() uint {
+	count := uint(0)
+	for _, filter := range s.filters {
+		count += filter.Count()
+	}
+	return count
+}
+
+// Filters returns the number of filters in the series.
+func (s *ScalableCuckooFilter) Filters() int {
+	return len(s.filters)
+}
+
+// EstimatedFPRate returns the estimated false-positive rate for the current
+// number of items, the probability that any filter in the series reports an
+// absent item as a member.
+func (s *ScalableCuckooFilter) EstimatedFPRate() float64 {
+	absent := 1.0
+	for _, filter := range s.filters {
+		absent *= 1 - filter.EstimatedFPRate()
+	}
+	return 1 - absent
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. This is a probabilistic test, meaning there is a
+// non-zero probability of false positives.
+func (s *ScalableCuckooFilter) Test(data []byte) bool {
+	for _, filter := range s.filters {
+		if filter.Test(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add will add the data to the last filter in the series, adding a new filter
+// if it's full. Since the new filter is empty, an error is only returned if
+// the data can't be added even to it.
+func (s *ScalableCuckooFilter) Add(data []byte) error {
+	last := s.filters[len(s.filters)-1]
+	if last.count < last.n {
+		var buf [4]byte
+		i1, i2, f := last.components(data, &buf)
+		if last.insert(i1, i2, f, true) {
+			return nil
+		}
+	}
+
+	s.addFilter()
+	return s.filters[len(s.filters)-1].Add(data)
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data is a member, false if not. An error is returned if the data can't
+// be added.
+func (s *ScalableCuckooFilter) TestAndAdd(data []byte) (bool, error) {
+	if s.Test(data) {
+		return true, nil
+	}
+	return false, s.Add(data)
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (s *ScalableCuckooFilter) TestString(data string) bool {
+	return s.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied.
+func (s *ScalableCuckooFilter) AddString(data string) error {
+	return s.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (s *ScalableCuckooFilter) TestAndAddString(data string) (bool, error) {
+	return s.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter, stopping at the first error
+// returned by Add. It returns the number of items added, which are the first
+// ones in data, and the error, if any.
+func (s *ScalableCuckooFilter) AddMany(data [][]byte) (int, error) {
+	return addAllContext(context.Background(), data, s.Add)
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+func (s *ScalableCuckooFilter) TestMany(data [][]byte) []bool {
+	return testMany(data, s.Test)
+}
+
+// TestAndRemove will test for membership of the data and remove it from the
+// newest filter holding it. Returns true if the data was a member, false if
+// not.
+func (s *ScalableCuckooFilter) TestAndRemove(data []byte) bool {
+	for i := len(s.filters) - 1; i >= 0; i-- {
+		if s.filters[i].TestAndRemove(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reset restores the filter to its original state, with only the first filter
+// of the series. It returns the filter to allow for chaining.
+func (s *ScalableCuckooFilter) Reset() *ScalableCuckooFilter {
+	s.filters[0].Reset()
+	for i := range s.filters[1:] {
+		s.filters[i+1] = nil
+	}
+	s.filters = s.filters[:1]
+	return s
+}
+
+// ByteSize returns the number of bytes used by every filter in the series and
+// metadata of the Scalable Cuckoo Filter. The hash functions aren't included.
+func (s *ScalableCuckooFilter) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*s)) + uint64(cap(s.filters))*pointerSize
+	for _, filter := range s.filters {
+		size += filter.ByteSize()
+	}
+	return size
+}
+
+// addFilter adds a new filter to the series with twice the capacity of the
+// last one and a tightened false-positive rate.
+func (s *ScalableCuckooFilter) addFilter() {
+	var (
+		i    = len(s.filters)
+		opts = []Option{WithSeed(s.seed)}
+	)
+	if s.newHash != nil {
+		opts = append(opts, WithHasher32(s.newHash))
+	}
+	s.filters = append(s.filters, NewCuckooFilter(s.hint<<uint(i), s.fp*math.Pow(s.r, float64(i)), opts...))
+}
+
+// WriteTo writes a binary representation of the ScalableCuckooFilter,
+// including every filter in the series, to an I/O stream. The hash function is
+// not written, but the seed is. It returns the number of bytes written.
+func (s *ScalableCuckooFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(s.r)
+	e.write(s.fp)
+	e.write(uint64(s.hint))
+	e.write(s.seed)
+	e.write(uint64(len(s.filters)))
+	for _, filter := range s.filters {
+		e.writeTo(filter)
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a ScalableCuckooFilter (such as
+// might have been written by WriteTo()) from an I/O stream. The filter keeps
+// the hash function set with WithHasher32, if any, which must match the one
+// used by the filter that was written. Returns ErrUnsupportedVersion if the
+// data was written by an incompatible version of the package. It returns the
+// number of bytes read.
+func (s *ScalableCuckooFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d             = decoder{r: payload}
+		r, fp         float64
+		hint, seed, l uint64
+	)
+	d.read(&r)
+	d.read(&fp)
+	d.read(&hint)
+	d.read(&seed)
+	d.read(&l)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	// Every filter takes at least its frame header.
+	if l == 0 || l > uint64(payload.Len())/headerSize {
+		return n, errors.New("invalid number of filters")
+	}
+
+	filters := make([]*CuckooFilter, 0, l)
+	for i := uint64(0); i < l && d.err == nil; i++ {
+		filter := &CuckooFilter{hash: newFNV32()}
+		if s.newHash != nil {
+			filter.hash = s.newHash()
+		}
+		d.readFrom(filter)
+		filters = append(filters, filter)
+	}
+	if d.err != nil {
+		return n, d.err
+	}
+
+	s.filters = filters
+	s.r = r
+	s.fp = fp
+	s.hint = uint(hint)
+	s.seed = seed
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (s *ScalableCuckooFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(s)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (s *ScalableCuckooFilter) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(s, data)
+}
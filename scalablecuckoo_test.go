@@ -0,0 +1,105 @@
+package boom
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Ensures that a Scalable Cuckoo Filter grows past the capacity of its first
+// filter without false negatives and keeps its compounded false-positive rate
+// near the bound.
+func TestScalableCuckooFilter(t *testing.T) {
+	f := NewScalableCuckooFilter(100, 0.01, 0.8, WithSeed(5))
+	for i := 0; i < 10000; i++ {
+		if err := f.AddString(strconv.Itoa(i)); err != nil {
+			t.Fatalf("Unexpected error adding %d: %v", i, err)
+		}
+	}
+
+	if f.Count() != 10000 {
+		t.Errorf("Expected 10000 items, got %d", f.Count())
+	}
+	if f.Filters() < 2 || f.Capacity() < 10000 {
+		t.Errorf("Expected the series to grow, got %d filters with capacity %d", f.Filters(), f.Capacity())
+	}
+
+	for i := 0; i < 10000; i++ {
+		if !f.TestString(strconv.Itoa(i)) {
+			t.Fatalf("Expected %d to be a member", i)
+		}
+	}
+
+	fp := 0
+	for i := 10000; i < 20000; i++ {
+		if f.TestString(strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 10000; rate > 0.01/(1-0.8) {
+		t.Errorf("Expected false-positive rate below %f, got %f", 0.01/(1-0.8), rate)
+	}
+	if rate := f.EstimatedFPRate(); rate <= 0 || rate > 0.01/(1-0.8) {
+		t.Errorf("Expected estimated false-positive rate below %f, got %f", 0.01/(1-0.8), rate)
+	}
+}
+
+// Ensures that items are removed from whichever filter in the series holds
+// them.
+func TestScalableCuckooTestAndRemove(t *testing.T) {
+	f := NewScalableCuckooFilter(10, 0.001, 0.8)
+	for i := 0; i < 100; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	for i := 0; i < 100; i += 2 {
+		if !f.TestAndRemove([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to be removed", i)
+		}
+	}
+	if f.Count() != 50 {
+		t.Errorf("Expected 50 items, got %d", f.Count())
+	}
+	for i := 1; i < 100; i += 2 {
+		if !f.Test([]byte(strconv.Itoa(i))) {
+			t.Errorf("Expected %d to remain a member", i)
+		}
+	}
+
+	f.Reset()
+	if f.Filters() != 1 || f.Count() != 0 {
+		t.Errorf("Expected 1 empty filter, got %d filters with %d items", f.Filters(), f.Count())
+	}
+}
+
+// Ensures that MarshalBinary and UnmarshalBinary round-trip the series.
+func TestScalableCuckooReadWrite(t *testing.T) {
+	f := NewScalableCuckooFilter(100, 0.01, 0.8)
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restored := &ScalableCuckooFilter{}
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if restored.Filters() != f.Filters() || restored.Count() != f.Count() {
+		t.Errorf("Expected %d filters and %d items, got %d and %d",
+			f.Filters(), f.Count(), restored.Filters(), restored.Count())
+	}
+	for i := 0; i < 2000; i++ {
+		data := []byte(strconv.Itoa(i))
+		if restored.Test(data) != f.Test(data) {
+			t.Errorf("Expected %t for %s, got %t", f.Test(data), data, restored.Test(data))
+		}
+	}
+
+	// Adding after restoring continues the series.
+	if err := restored.Add([]byte(`a`)); err != nil || !restored.Test([]byte(`a`)) {
+		t.Errorf("Expected `a` to be added, got %v", err)
+	}
+}
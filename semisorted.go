@@ -0,0 +1,512 @@
+package boom
+
+import (
+	"context"
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"math/rand"
+	"unsafe"
+)
+
+// Parameters of the buckets of a SemiSortedCuckooFilter. Each bucket has four
+// entries, whose sorted 4-bit prefixes are encoded as one of the 3876
+// multisets of four prefixes in 12 bits instead of 16.
+const (
+	semiSortedEntries    = 4
+	semiSortedPrefixBits = 4
+	semiSortedCodeBits   = 12
+	semiSortedCodes      = 3876 // multisets of four 4-bit prefixes
+)
+
+// semiSortedPrefixes maps each code to the sorted prefixes it encodes.
+var semiSortedPrefixes = newSemiSortedPrefixes()
+
+// SemiSortedCuckooFilter implements a Cuckoo Filter whose buckets are
+// semi-sorted as described by Andersen, Kaminsky, and Mitzenmacher in
+// Cuckoo Filter: Practically Better Than Bloom:
+//
+// http://www.pdl.cmu.edu/PDL-FTP/FS/cuckoo-conext2014.pdf
+//
+// The four fingerprints of each bucket are kept sorted, so their 4-bit
+// prefixes form a multiset which is encoded in 12 bits rather than stored in
+// 16. This saves one bit per entry compared to a Cuckoo Filter with
+// fingerprints of the same length and the same false-positive rate, at the
+// cost of decoding and re-encoding a bucket on every lookup and insertion,
+// which makes operations slightly slower.
+//
+// Buckets are packed in a bit array, and an empty entry has a fingerprint of
+// zero, so fingerprints which hash to zero are stored as one.
+type SemiSortedCuckooFilter struct {
+	words []uint64    // packed buckets
+	hash  hash.Hash64 // hash function (used for fingerprint and index)
+	m     uint        // number of buckets
+	f     uint        // length of fingerprints (in bits)
+	count uint        // number of items in the filter
+	n     uint        // filter capacity
+	seed  uint64      // hash seed (zero means unseeded)
+	rand  *rand.Rand  // source of randomness for relocations, if seeded
+}
+
+// NewSemiSortedCuckooFilter creates a new Cuckoo Filter with semi-sorted
+// buckets optimized to store n items with a specified target false-positive
+// rate. Fingerprints take between 4 and 32 bits, so the false-positive rate is
+// at most CuckooFPRate(bits, 4) for the fingerprint length chosen.
+func NewSemiSortedCuckooFilter(n uint, fpRate float64, opts ...Option) *SemiSortedCuckooFilter {
+	f := uint(math.Ceil(math.Log2(2 * semiSortedEntries / fpRate)))
+	if f < semiSortedPrefixBits {
+		f = semiSortedPrefixBits
+	} else if f > 32 {
+		f = 32
+	}
+
+	m := power2(uint(math.Ceil(float64(n) / (semiSortedEntries * cuckooLoad(semiSortedEntries)))))
+	if m == 0 {
+		m = 1
+	}
+
+	o := applyOptions(opts)
+	s := &SemiSortedCuckooFilter{
+		hash: o.newHash64(newFNV64),
+		m:    m,
+		f:    f,
+		n:    n,
+		seed: o.seed,
+		rand: o.rand(),
+	}
+	s.words = make([]uint64, s.wordCount())
+	return s
+}
+
+// Buckets returns the number of buckets.
+func (s *SemiSortedCuckooFilter) Buckets() uint {
+	return s.m
+}
+
+// Capacity returns the number of items the filter can store.
+func (s *SemiSortedCuckooFilter) Capacity() uint {
+	return s.n
+}
+
+// Count returns the number of items in the filter.
+func (s *SemiSortedCuckooFilter) Count() uint {
+	return s.count
+}
+
+// FingerprintBits returns the length of fingerprints in bits.
+func (s *SemiSortedCuckooFilter) FingerprintBits() uint {
+	return s.f
+}
+
+// EstimatedFPRate returns the estimated false-positive rate for the current
+// number of items, like CuckooFilter.EstimatedFPRate.
+func (s *SemiSortedCuckooFilter) EstimatedFPRate() float64 {
+	load := float64(s.count) / float64(s.m*semiSortedEntries)
+	return 1 - math.Pow(1-math.Exp2(-float64(s.f)), 2*semiSortedEntries*load)
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. This is a probabilistic test, meaning there is a
+// non-zero probability of false positives.
+func (s *SemiSortedCuckooFilter) Test(data []byte) bool {
+	i1, i2, f := s.components(data)
+	return s.indexOf(i1, f) != -1 || s.indexOf(i2, f) != -1
+}
+
+// Add will add the data to the filter. It returns an error if the filter is
+// full. If the filter is full, an item is removed to make room for the new
+// item. This introduces a possibility for false negatives. To avoid this, use
+// Count and Capacity to check if the filter is full before adding an item.
+func (s *SemiSortedCuckooFilter) Add(data []byte) error {
+	return s.add(s.components(data))
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data is a member, false if not. An error is returned if the filter is
+// full.
+func (s *SemiSortedCuckooFilter) TestAndAdd(data []byte) (bool, error) {
+	i1, i2, f := s.components(data)
+	if s.indexOf(i1, f) != -1 || s.indexOf(i2, f) != -1 {
+		return true, nil
+	}
+
+	return false, s.add(i1, i2, f)
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (s *SemiSortedCuckooFilter) TestString(data string) bool {
+	return s.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied.
+func (s *SemiSortedCuckooFilter) AddString(data string) error {
+	return s.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (s *SemiSortedCuckooFilter) TestAndAddString(data string) (bool, error) {
+	return s.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter, stopping at the first error
+// returned by Add. It returns the number of items added, which are the first
+// ones in data, and the error, if any.
+func (s *SemiSortedCuckooFilter) AddMany(data [][]byte) (int, error) {
+	return addAllContext(context.Background(), data, s.Add)
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+func (s *SemiSortedCuckooFilter) TestMany(data [][]byte) []bool {
+	return testMany(data, s.Test)
+}
+
+// TestAndRemove will test for membership of the data and remove it from the
+// filter if it exists. Returns true if the data was a member, false if not.
+func (s *SemiSortedCuckooFilter) TestAndRemove(data []byte) bool {
+	i1, i2, f := s.components(data)
+	for _, i := range [2]uint{i1, i2} {
+		entries := s.readBucket(i)
+		for j, entry := range entries {
+			if entry == f {
+				entries[j] = 0
+				s.writeBucket(i, entries)
+				s.count--
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reset restores the filter to its original state. It returns the filter to
+// allow for chaining.
+func (s *SemiSortedCuckooFilter) Reset() *SemiSortedCuckooFilter {
+	for i := range s.words {
+		s.words[i] = 0
+	}
+	s.count = 0
+	return s
+}
+
+// ByteSize returns the number of bytes used by the packed buckets and metadata
+// of the filter. The hash function and source of randomness aren't included.
+func (s *SemiSortedCuckooFilter) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*s)) + uint64(cap(s.words))*8
+}
+
+// SetHash sets the hashing function used to compute fingerprints and bucket
+// indices.
+func (s *SemiSortedCuckooFilter) SetHash(h hash.Hash64) {
+	s.hash = h
+}
+
+// WriteTo writes a binary representation of the SemiSortedCuckooFilter to an
+// I/O stream, including its packed buckets. The hash function is not written,
+// but the seed is. It returns the number of bytes written.
+func (s *SemiSortedCuckooFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(s.m))
+	e.write(uint64(s.f))
+	e.write(uint64(s.count))
+	e.write(uint64(s.n))
+	e.write(s.seed)
+	e.write(s.words)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a SemiSortedCuckooFilter (such as
+// might have been written by WriteTo()) from an I/O stream. The filter keeps
+// its current hash function, which must match the one used by the filter that
+// was written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (s *SemiSortedCuckooFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                    = decoder{r: payload}
+		m, f, count, size    uint64
+		seed                 uint64
+		decoded              SemiSortedCuckooFilter
+		maxBuckets, maxWords uint64
+	)
+	d.read(&m)
+	d.read(&f)
+	d.read(&count)
+	d.read(&size)
+	d.read(&seed)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	// Every bucket takes at least 12 bits.
+	maxWords = uint64(payload.Len()) / 8
+	maxBuckets = maxWords * 64 / semiSortedCodeBits
+	if m == 0 || m&(m-1) != 0 || m > maxBuckets || f < semiSortedPrefixBits || f > 32 {
+		return n, errors.New("invalid filter parameters")
+	}
+
+	decoded.m = uint(m)
+	decoded.f = uint(f)
+	if uint64(decoded.wordCount()) > maxWords {
+		return n, io.ErrUnexpectedEOF
+	}
+	words := make([]uint64, decoded.wordCount())
+	d.read(words)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	decoded.words = words
+	for i := uint(0); i < decoded.m; i++ {
+		if decoded.code(i) >= semiSortedCodes {
+			return n, errors.New("invalid bucket")
+		}
+	}
+
+	if s.hash == nil {
+		s.hash = newFNV64()
+	}
+	s.words = words
+	s.m = uint(m)
+	s.f = uint(f)
+	s.count = uint(count)
+	s.n = uint(size)
+	s.seed = seed
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (s *SemiSortedCuckooFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(s)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo. If the filter
+// has no hash function, such as when it's the zero value, the default hash
+// function is used.
+func (s *SemiSortedCuckooFilter) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(s, data)
+}
+
+// add will insert the fingerprint into the filter returning an error if the
+// filter is full.
+func (s *SemiSortedCuckooFilter) add(i1, i2 uint, f uint32) error {
+	if s.insert(i1, f) || s.insert(i2, f) {
+		s.count++
+		return nil
+	}
+
+	// Must relocate existing items.
+	i := i1
+	for n := 0; n < maxNumKicks; n++ {
+		entries := s.readBucket(i)
+		j := s.intn(semiSortedEntries)
+		f, entries[j] = entries[j], f
+		s.writeBucket(i, entries)
+
+		i = s.altIndex(i, f)
+		if s.insert(i, f) {
+			s.count++
+			return nil
+		}
+	}
+
+	return errors.New("full")
+}
+
+// insert inserts the fingerprint into an empty entry of the bucket. Returns
+// false if the bucket is full.
+func (s *SemiSortedCuckooFilter) insert(i uint, f uint32) bool {
+	entries := s.readBucket(i)
+	for j, entry := range entries {
+		if entry == 0 {
+			entries[j] = f
+			s.writeBucket(i, entries)
+			return true
+		}
+	}
+	return false
+}
+
+// indexOf returns the entry index of the fingerprint in the bucket or -1 if
+// it's not in the bucket.
+func (s *SemiSortedCuckooFilter) indexOf(i uint, f uint32) int {
+	for j, entry := range s.readBucket(i) {
+		if entry == f {
+			return j
+		}
+	}
+	return -1
+}
+
+// components returns the two buckets of the data and its fingerprint, which
+// is never zero.
+func (s *SemiSortedCuckooFilter) components(data []byte) (uint, uint, uint32) {
+	lower, upper := seededHashKernel(data, s.hash, s.seed)
+	f := upper >> (32 - s.f)
+	if f == 0 {
+		f = 1
+	}
+
+	i1 := uint(lower) & (s.m - 1)
+	return i1, s.altIndex(i1, f), f
+}
+
+// altIndex returns the other bucket of an entry with the fingerprint in the
+// bucket.
+func (s *SemiSortedCuckooFilter) altIndex(i uint, f uint32) uint {
+	return (i ^ uint(mix64(uint64(f)))) & (s.m - 1)
+}
+
+// intn returns a random number in [0, n) from the filter's source of
+// randomness, falling back to the default source if there is none.
+func (s *SemiSortedCuckooFilter) intn(n int) int {
+	if s.rand != nil {
+		return s.rand.Intn(n)
+	}
+	return rand.Intn(n)
+}
+
+// bucketBits returns the number of bits of a bucket, the code of its prefixes
+// followed by the remaining bits of each of its fingerprints.
+func (s *SemiSortedCuckooFilter) bucketBits() uint {
+	return semiSortedCodeBits + semiSortedEntries*(s.f-semiSortedPrefixBits)
+}
+
+// wordCount returns the number of words holding the packed buckets.
+func (s *SemiSortedCuckooFilter) wordCount() uint {
+	return (s.m*s.bucketBits() + 63) / 64
+}
+
+// code returns the code of the prefixes of the bucket.
+func (s *SemiSortedCuckooFilter) code(i uint) uint32 {
+	return getWordBits(s.words, i*s.bucketBits(), semiSortedCodeBits)
+}
+
+// readBucket returns the fingerprints of the bucket in sorted order, with
+// empty entries first.
+func (s *SemiSortedCuckooFilter) readBucket(i uint) [semiSortedEntries]uint32 {
+	var (
+		entries  [semiSortedEntries]uint32
+		pos      = i * s.bucketBits()
+		rest     = s.f - semiSortedPrefixBits
+		prefixes = semiSortedPrefixes[getWordBits(s.words, pos, semiSortedCodeBits)]
+	)
+	pos += semiSortedCodeBits
+	for j := range entries {
+		entries[j] = uint32(prefixes[j])<<rest | getWordBits(s.words, pos, rest)
+		pos += rest
+	}
+	return entries
+}
+
+// writeBucket sorts the fingerprints and writes them to the bucket.
+func (s *SemiSortedCuckooFilter) writeBucket(i uint, entries [semiSortedEntries]uint32) {
+	for j := 1; j < len(entries); j++ {
+		for k := j; k > 0 && entries[k] < entries[k-1]; k-- {
+			entries[k], entries[k-1] = entries[k-1], entries[k]
+		}
+	}
+
+	var (
+		prefixes [semiSortedEntries]uint8
+		pos      = i * s.bucketBits()
+		rest     = s.f - semiSortedPrefixBits
+	)
+	for j, entry := range entries {
+		prefixes[j] = uint8(entry >> rest)
+	}
+	setWordBits(s.words, pos, semiSortedCodeBits, semiSortedCode(prefixes))
+	pos += semiSortedCodeBits
+	for _, entry := range entries {
+		setWordBits(s.words, pos, rest, entry)
+		pos += rest
+	}
+}
+
+// semiSortedCode returns the code of the sorted prefixes, their rank among
+// sorted prefixes in the combinatorial number system: adding its index to
+// each prefix makes them strictly increasing, and the rank is the sum of
+// binomial(prefix+j, j+1).
+func semiSortedCode(prefixes [semiSortedEntries]uint8) uint32 {
+	var code uint32
+	for j, p := range prefixes {
+		code += binomial(uint32(p)+uint32(j), uint32(j)+1)
+	}
+	return code
+}
+
+// newSemiSortedPrefixes returns the table mapping codes to the sorted prefixes
+// they encode.
+func newSemiSortedPrefixes() *[semiSortedCodes][semiSortedEntries]uint8 {
+	var (
+		table    [semiSortedCodes][semiSortedEntries]uint8
+		prefixes [semiSortedEntries]uint8
+	)
+	for a := uint8(0); a < 16; a++ {
+		for b := a; b < 16; b++ {
+			for c := b; c < 16; c++ {
+				for d := c; d < 16; d++ {
+					prefixes = [semiSortedEntries]uint8{a, b, c, d}
+					table[semiSortedCode(prefixes)] = prefixes
+				}
+			}
+		}
+	}
+	return &table
+}
+
+// binomial returns n choose k.
+func binomial(n, k uint32) uint32 {
+	if k > n {
+		return 0
+	}
+	result := uint32(1)
+	for i := uint32(1); i <= k; i++ {
+		result = result * (n - k + i) / i
+	}
+	return result
+}
+
+// getWordBits returns the n bits, up to 32, at the bit position of the words.
+func getWordBits(words []uint64, pos, n uint) uint32 {
+	if n == 0 {
+		return 0
+	}
+	w, off := pos/64, pos%64
+	v := words[w] >> off
+	if off+n > 64 {
+		v |= words[w+1] << (64 - off)
+	}
+	return uint32(v & (1<<n - 1))
+}
+
+// setWordBits sets the n bits, up to 32, at the bit position of the words to
+// the low bits of v.
+func setWordBits(words []uint64, pos, n uint, v uint32) {
+	if n == 0 {
+		return
+	}
+	var (
+		w, off = pos / 64, pos % 64
+		mask   = uint64(1)<<n - 1
+		bits   = uint64(v) & mask
+	)
+	words[w] = words[w]&^(mask<<off) | bits<<off
+	if off+n > 64 {
+		shift := 64 - off
+		words[w+1] = words[w+1]&^(mask>>shift) | bits>>shift
+	}
+}
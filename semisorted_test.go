@@ -0,0 +1,129 @@
+package boom
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Ensures that every multiset of prefixes has a distinct code which decodes to
+// it.
+func TestSemiSortedCodes(t *testing.T) {
+	seen := make(map[uint32]bool)
+	for code, prefixes := range semiSortedPrefixes {
+		if c := semiSortedCode(prefixes); c != uint32(code) {
+			t.Fatalf("Expected code %d for %v, got %d", code, prefixes, c)
+		}
+		seen[uint32(code)] = true
+	}
+	if len(seen) != semiSortedCodes {
+		t.Errorf("Expected %d codes, got %d", semiSortedCodes, len(seen))
+	}
+}
+
+// Ensures that a SemiSortedCuckooFilter holds every added item, removes them,
+// and stays below the false-positive rate bound.
+func TestSemiSortedCuckooFilter(t *testing.T) {
+	for _, fpRate := range []float64{0.5, 0.01, 0.0001} {
+		f := NewSemiSortedCuckooFilter(10000, fpRate, WithSeed(3))
+		for i := 0; i < 10000; i++ {
+			if err := f.AddString(strconv.Itoa(i)); err != nil {
+				t.Fatalf("Unexpected error adding %d: %v", i, err)
+			}
+		}
+		if f.Count() != 10000 {
+			t.Errorf("Expected 10000 items, got %d", f.Count())
+		}
+
+		for i := 0; i < 10000; i++ {
+			if !f.TestString(strconv.Itoa(i)) {
+				t.Fatalf("Expected %d to be a member", i)
+			}
+		}
+
+		fp := 0
+		for i := 10000; i < 110000; i++ {
+			if f.TestString(strconv.Itoa(i)) {
+				fp++
+			}
+		}
+		bound := CuckooFPRate(f.FingerprintBits(), semiSortedEntries)
+		if rate := float64(fp) / 100000; rate > bound {
+			t.Errorf("Expected false-positive rate below %f, got %f", bound, rate)
+		}
+
+		for i := 0; i < 5000; i++ {
+			if !f.TestAndRemove([]byte(strconv.Itoa(i))) {
+				t.Fatalf("Expected %d to be removed", i)
+			}
+		}
+		for i := 5000; i < 10000; i++ {
+			if !f.TestString(strconv.Itoa(i)) {
+				t.Fatalf("Expected %d to remain a member", i)
+			}
+		}
+
+		f.Reset()
+		if f.Count() != 0 || f.TestString("5000") {
+			t.Error("Expected empty filter after reset")
+		}
+	}
+}
+
+// Ensures that semi-sorted buckets take one bit per entry less than the
+// fingerprints of a Cuckoo Filter with the same fingerprint length.
+func TestSemiSortedCuckooByteSize(t *testing.T) {
+	f := NewSemiSortedCuckooFilter(100000, 0.001)
+	if f.bucketBits() != semiSortedEntries*(f.FingerprintBits()-1) {
+		t.Errorf("Expected %d bits per bucket, got %d", semiSortedEntries*(f.FingerprintBits()-1), f.bucketBits())
+	}
+	if size, max := f.ByteSize(), uint64(f.Buckets()*semiSortedEntries*f.FingerprintBits()/8); size >= max {
+		t.Errorf("Expected fewer than %d bytes, got %d", max, size)
+	}
+}
+
+// Ensures that MarshalBinary and UnmarshalBinary round-trip the filter.
+func TestSemiSortedCuckooReadWrite(t *testing.T) {
+	f := NewSemiSortedCuckooFilter(1000, 0.01)
+	for i := 0; i < 800; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restored := &SemiSortedCuckooFilter{}
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if restored.Count() != f.Count() || restored.Buckets() != f.Buckets() || restored.FingerprintBits() != f.FingerprintBits() {
+		t.Errorf("Expected %d items, %d buckets, and %d bits, got %d, %d, and %d",
+			f.Count(), f.Buckets(), f.FingerprintBits(), restored.Count(), restored.Buckets(), restored.FingerprintBits())
+	}
+	for i := 0; i < 2000; i++ {
+		data := []byte(strconv.Itoa(i))
+		if restored.Test(data) != f.Test(data) {
+			t.Errorf("Expected %t for %s, got %t", f.Test(data), data, restored.Test(data))
+		}
+	}
+
+	if err := restored.UnmarshalBinary(data[:len(data)-8]); err == nil {
+		t.Error("Expected error for truncated data")
+	}
+}
+
+func BenchmarkSemiSortedCuckooTest(b *testing.B) {
+	f := NewSemiSortedCuckooFilter(100000, 0.001)
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+		if i < 90000 {
+			f.Add(data[i])
+		}
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		f.Test(data[n])
+	}
+}
@@ -0,0 +1,264 @@
+package boom
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// ShardedScalableBloomFilter partitions data across several independent
+// Scalable Bloom Filters, each guarded by its own mutex, so it's safe for
+// concurrent use and writes to different shards don't contend. The shard of an
+// item is picked by a hash independent of the one used by the filters, and
+// Test only consults that shard. Compared to a single ScalableBloomFilter, the
+// false-positive rate is unchanged since every item is tested against the
+// shard it would have been added to.
+type ShardedScalableBloomFilter struct {
+	shards      []shard
+	transformer Transformer // normalizes keys before they're sharded, if set
+}
+
+// minShardHint is the least number of items a shard is sized for. Filters sized
+// for only a few items exceed their false-positive rate, so splitting a small
+// hint across many shards would too.
+const minShardHint = 64
+
+// shard is a ScalableBloomFilter with its lock.
+type shard struct {
+	mu     sync.Mutex
+	filter *ScalableBloomFilter
+}
+
+// NewShardedScalableBloomFilter creates a new ShardedScalableBloomFilter with
+// the specified number of shards, at least one, each a Scalable Bloom Filter
+// with the specified target false-positive rate and tightening ratio. The hint
+// is split evenly across the shards, each sized for at least 64 items.
+func NewShardedScalableBloomFilter(shards, hint uint, fpRate, r float64, opts ...Option) *ShardedScalableBloomFilter {
+	if shards == 0 {
+		shards = 1
+	}
+
+	shardHint := hint / shards
+	if shardHint < minShardHint {
+		shardHint = minShardHint
+	}
+
+	s := &ShardedScalableBloomFilter{
+		shards:      make([]shard, shards),
+		transformer: applyOptions(opts).transformer,
+	}
+	for i := range s.shards {
+		s.shards[i].filter = NewScalableBloomFilter(shardHint, fpRate, r, opts...)
+	}
+	return s
+}
+
+// Shards returns the number of shards.
+func (s *ShardedScalableBloomFilter) Shards() uint {
+	return uint(len(s.shards))
+}
+
+// Capacity returns the sum of the capacities of every shard.
+func (s *ShardedScalableBloomFilter) Capacity() uint {
+	capacity := uint(0)
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		capacity += sh.filter.Capacity()
+		sh.mu.Unlock()
+	}
+	return capacity
+}
+
+// FillRatio returns the average ratio of set bits across every shard, weighted
+// by the shards' capacities.
+func (s *ShardedScalableBloomFilter) FillRatio() float64 {
+	var sum, capacity float64
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		c := float64(sh.filter.Capacity())
+		sum += sh.filter.FillRatio() * c
+		capacity += c
+		sh.mu.Unlock()
+	}
+
+	if capacity == 0 {
+		return 0
+	}
+	return sum / capacity
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. This is a probabilistic test, meaning there is a
+// non-zero probability of false positives but a zero probability of false
+// negatives.
+func (s *ShardedScalableBloomFilter) Test(data []byte) bool {
+	sh := s.shard(data)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.filter.Test(data)
+}
+
+// Add will add the data to the Bloom filter. It returns the filter to allow
+// for chaining.
+func (s *ShardedScalableBloomFilter) Add(data []byte) Filter {
+	sh := s.shard(data)
+	sh.mu.Lock()
+	sh.filter.Add(data)
+	sh.mu.Unlock()
+	return s
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add, atomically. It
+// returns true if the data is a member, false if not.
+func (s *ShardedScalableBloomFilter) TestAndAdd(data []byte) bool {
+	sh := s.shard(data)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.filter.TestAndAdd(data)
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (s *ShardedScalableBloomFilter) TestString(data string) bool {
+	return s.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (s *ShardedScalableBloomFilter) AddString(data string) Filter {
+	return s.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (s *ShardedScalableBloomFilter) TestAndAddString(data string) bool {
+	return s.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter. The items are grouped by
+// shard, so each shard's lock is held once for the whole batch. It returns the
+// filter to allow for chaining.
+func (s *ShardedScalableBloomFilter) AddMany(data [][]byte) Filter {
+	for i, items := range s.group(data) {
+		if len(items) == 0 {
+			continue
+		}
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for _, j := range items {
+			sh.filter.Add(data[j])
+		}
+		sh.mu.Unlock()
+	}
+	return s
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+// Like AddMany, each shard's lock is held once for the whole batch.
+func (s *ShardedScalableBloomFilter) TestMany(data [][]byte) []bool {
+	members := make([]bool, len(data))
+	for i, items := range s.group(data) {
+		if len(items) == 0 {
+			continue
+		}
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for _, j := range items {
+			members[j] = sh.filter.Test(data[j])
+		}
+		sh.mu.Unlock()
+	}
+	return members
+}
+
+// Reset restores every shard to its original state. It returns the filter to
+// allow for chaining.
+func (s *ShardedScalableBloomFilter) Reset() *ShardedScalableBloomFilter {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		sh.filter.Reset()
+		sh.mu.Unlock()
+	}
+	return s
+}
+
+// Equal returns true if the other filter has the same number of shards and
+// each of its shards' filters is equal to the corresponding one of this
+// filter. The other filter must not be modified concurrently.
+func (s *ShardedScalableBloomFilter) Equal(other *ShardedScalableBloomFilter) bool {
+	if s == other {
+		return true
+	}
+
+	if len(s.shards) != len(other.shards) {
+		return false
+	}
+
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		equal := sh.filter.Equal(other.shards[i].filter)
+		sh.mu.Unlock()
+		if !equal {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the filter, cloning each shard's filter
+// while holding its lock, so it may be called concurrently with other
+// operations.
+func (s *ShardedScalableBloomFilter) Clone() *ShardedScalableBloomFilter {
+	c := &ShardedScalableBloomFilter{
+		shards:      make([]shard, len(s.shards)),
+		transformer: s.transformer,
+	}
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		c.shards[i].filter = sh.filter.Clone()
+		sh.mu.Unlock()
+	}
+	return c
+}
+
+// ByteSize returns the number of bytes used by every shard's Scalable Bloom
+// Filter and the shards themselves.
+func (s *ShardedScalableBloomFilter) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*s)) + uint64(cap(s.shards))*uint64(unsafe.Sizeof(shard{}))
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		size += sh.filter.ByteSize()
+		sh.mu.Unlock()
+	}
+	return size
+}
+
+// shard returns the shard owning the data.
+func (s *ShardedScalableBloomFilter) shard(data []byte) *shard {
+	return &s.shards[s.shardIndex(data)]
+}
+
+// shardIndex returns the index of the shard owning the data, once normalized
+// by the Transformer, so keys which differ only in form share a shard.
+func (s *ShardedScalableBloomFilter) shardIndex(data []byte) int {
+	if len(s.shards) == 1 {
+		return 0
+	}
+	data = transformKey(s.transformer, data)
+	return int(murmur64A(data, 0) % uint64(len(s.shards)))
+}
+
+// group returns the indices of the items of data owned by each shard.
+func (s *ShardedScalableBloomFilter) group(data [][]byte) [][]int {
+	groups := make([][]int, len(s.shards))
+	for i, d := range data {
+		idx := s.shardIndex(d)
+		groups[idx] = append(groups[idx], i)
+	}
+	return groups
+}
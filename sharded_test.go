@@ -0,0 +1,92 @@
+package boom
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Ensures that a ShardedScalableBloomFilter finds every item added
+// concurrently and aggregates its shards.
+func TestShardedScalableBloomFilter(t *testing.T) {
+	s := NewShardedScalableBloomFilter(4, 10000, 0.01, 0.8)
+	if shards := s.Shards(); shards != 4 {
+		t.Errorf("Expected 4, got %d", shards)
+	}
+
+	capacity := NewScalableBloomFilter(2500, 0.01, 0.8).Capacity() * 4
+	if c := s.Capacity(); c != capacity {
+		t.Errorf("Expected %d, got %d", capacity, c)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				s.Add([]byte(strconv.Itoa(g*1000 + i)))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for i := 0; i < 8000; i++ {
+		if !s.Test([]byte(strconv.Itoa(i))) {
+			t.Fatalf("Expected %d to be a member", i)
+		}
+	}
+
+	if s.TestAndAdd([]byte(`a`)) {
+		t.Error("`a` should not be a member")
+	}
+
+	if ratio := s.FillRatio(); ratio <= 0 || ratio > 1 {
+		t.Errorf("Expected fill ratio between 0 and 1, got %f", ratio)
+	}
+
+	if s.Reset().Test([]byte(`a`)) {
+		t.Error("`a` should not be a member after Reset")
+	}
+}
+
+// Ensures that a small hint split across several shards doesn't leave them too
+// small to keep the false-positive rate.
+func TestShardedScalableBloomFilterSmallHint(t *testing.T) {
+	s := NewShardedScalableBloomFilter(4, 7, 0.01, 0.8)
+	for i := 0; i < 7; i++ {
+		s.Add([]byte(strconv.Itoa(i)))
+	}
+
+	fp := 0
+	for i := 7; i < 10007; i++ {
+		if s.Test([]byte(strconv.Itoa(i))) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 10000; rate > 0.01 {
+		t.Errorf("Expected a false-positive rate of at most 0.01, got %f", rate)
+	}
+}
+
+func BenchmarkShardedScalableBloomAddParallel(b *testing.B) {
+	s := NewShardedScalableBloomFilter(32, 100000, 0.1, 0.8)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			s.Add([]byte(strconv.Itoa(i)))
+			i++
+		}
+	})
+}
+
+func BenchmarkSafeScalableBloomAddParallel(b *testing.B) {
+	s := NewSafeFilter(NewScalableBloomFilter(100000, 0.1, 0.8))
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			s.Add([]byte(strconv.Itoa(i)))
+			i++
+		}
+	})
+}
@@ -0,0 +1,326 @@
+package boom
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"unsafe"
+)
+
+// shiftingWindow is the number of bits read by a single probe of a
+// ShiftingBloomFilter, so the largest offset is one less. The paper uses a
+// 64-bit word less 7 bits, so that a window read from byte boundaries fits in
+// one word.
+const shiftingWindow = 57
+
+// ShiftingSet identifies the sets of a ShiftingBloomFilter an item is in.
+type ShiftingSet uint8
+
+const (
+	// ShiftingSetA is the first set.
+	ShiftingSetA ShiftingSet = 1 << iota
+
+	// ShiftingSetB is the second set.
+	ShiftingSetB
+
+	// ShiftingSetBoth is both sets.
+	ShiftingSetBoth = ShiftingSetA | ShiftingSetB
+)
+
+// ShiftingBloomFilter implements the Shifting Bloom Filter for association
+// queries, ShBF_A, as described by Yang, Liu, Shahzad, Zhong, Fu, Li, Xie, and
+// Li in A Shifting Bloom Filter Framework for Set Queries:
+//
+// http://www.vldb.org/pvldb/vol9/p408-yang.pdf
+//
+// It answers which of two sets, A, B, or both, an item is in with a single bit
+// array, instead of a Bloom filter per set. An item's k positions are the same
+// whatever its sets, but its bits are shifted from them by an offset which
+// encodes the sets: zero for A, o1 for B, and o2 for both, where 0 < o1 < o2 <
+// 57 are derived from the item's hash. A query reads the 57 bits starting at
+// each position with one memory access and checks the three offsets at once,
+// so it costs k probes like a single Bloom filter rather than 2k for two.
+//
+// Sets returns every combination of sets whose bits are all set. It never
+// misses a set the item was added to, but may report one it wasn't, with about
+// the false-positive rate for each of the other offsets. Adding an item to A
+// and then to B sets the bits of both offsets, which is reported as both
+// sets.
+type ShiftingBloomFilter struct {
+	words  []uint64    // bit array, padded so a window never runs past the end
+	hash   hash.Hash64 // hash function (kernel for all k functions)
+	m      uint        // number of positions
+	k      uint        // number of hash functions
+	count  uint        // number of items added
+	seed   uint64      // hash seed (zero means unseeded)
+	modulo bool        // reduce hashes to positions with a modulo
+}
+
+// NewShiftingBloomFilter creates a new Shifting Bloom Filter optimized to
+// store n items, in either or both sets, with a specified target
+// false-positive rate.
+func NewShiftingBloomFilter(n uint, fpRate float64, opts ...Option) *ShiftingBloomFilter {
+	var (
+		m = OptimalM(n, fpRate)
+		o = applyOptions(opts)
+	)
+	return &ShiftingBloomFilter{
+		words:  make([]uint64, shiftingWords(m)),
+		hash:   o.newHash64(newFNV64),
+		m:      m,
+		k:      OptimalK(fpRate),
+		seed:   o.seed,
+		modulo: o.modulo,
+	}
+}
+
+// Capacity returns the number of positions, m.
+func (s *ShiftingBloomFilter) Capacity() uint {
+	return s.m
+}
+
+// K returns the number of hash functions.
+func (s *ShiftingBloomFilter) K() uint {
+	return s.k
+}
+
+// Count returns the number of items added to the filter.
+func (s *ShiftingBloomFilter) Count() uint {
+	return s.count
+}
+
+// Sets returns the sets the data may be in, or zero if it's in neither. This
+// is a probabilistic test, meaning a set the data was never added to may be
+// reported, but one it was added to never goes missing.
+func (s *ShiftingBloomFilter) Sets(data []byte) ShiftingSet {
+	var (
+		lower, upper, o1, o2 = s.hashKernel(data)
+		a, b, both           = true, true, true
+	)
+	for i := uint(0); i < s.k && (a || b || both); i++ {
+		window := s.window(reducedIndex(lower, upper, i, s.m, s.modulo))
+		a = a && window&1 != 0
+		b = b && window>>o1&1 != 0
+		both = both && window>>o2&1 != 0
+	}
+
+	var sets ShiftingSet
+	if a {
+		sets |= ShiftingSetA
+	}
+	if b {
+		sets |= ShiftingSetB
+	}
+	if both {
+		sets |= ShiftingSetBoth
+	}
+	return sets
+}
+
+// SetsString is equivalent to Sets for a string, which is hashed without being
+// copied.
+func (s *ShiftingBloomFilter) SetsString(data string) ShiftingSet {
+	return s.Sets(stringBytes(data))
+}
+
+// Test will test for membership of the data in either set and returns true if
+// it is a member, false if not. This is a probabilistic test, meaning there is
+// a non-zero probability of false positives but a zero probability of false
+// negatives.
+func (s *ShiftingBloomFilter) Test(data []byte) bool {
+	return s.Sets(data) != 0
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (s *ShiftingBloomFilter) TestString(data string) bool {
+	return s.Test(stringBytes(data))
+}
+
+// Add will add the data to the sets, A, B, or both. Adding it to no set leaves
+// the filter unchanged. It returns the filter to allow for chaining.
+func (s *ShiftingBloomFilter) Add(data []byte, sets ShiftingSet) *ShiftingBloomFilter {
+	var offset uint
+	lower, upper, o1, o2 := s.hashKernel(data)
+	switch sets & ShiftingSetBoth {
+	case ShiftingSetA:
+		offset = 0
+	case ShiftingSetB:
+		offset = o1
+	case ShiftingSetBoth:
+		offset = o2
+	default:
+		return s
+	}
+
+	for i := uint(0); i < s.k; i++ {
+		bit := reducedIndex(lower, upper, i, s.m, s.modulo) + offset
+		s.words[bit/64] |= 1 << (bit % 64)
+	}
+	s.count++
+	return s
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (s *ShiftingBloomFilter) AddString(data string, sets ShiftingSet) *ShiftingBloomFilter {
+	return s.Add(stringBytes(data), sets)
+}
+
+// Reset restores the filter to its original state. It returns the filter to
+// allow for chaining.
+func (s *ShiftingBloomFilter) Reset() *ShiftingBloomFilter {
+	for i := range s.words {
+		s.words[i] = 0
+	}
+	s.count = 0
+	return s
+}
+
+// Equal returns true if the other Shifting Bloom Filter has the same
+// parameters, hash seed, reduction of hashes to positions, number of items
+// added, and bits. The hash functions aren't compared.
+func (s *ShiftingBloomFilter) Equal(other *ShiftingBloomFilter) bool {
+	if s.m != other.m || s.k != other.k || s.count != other.count || s.seed != other.seed ||
+		s.modulo != other.modulo {
+		return false
+	}
+
+	for i, word := range s.words {
+		if word != other.words[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the Shifting Bloom Filter, which can be
+// used concurrently with the original.
+func (s *ShiftingBloomFilter) Clone() *ShiftingBloomFilter {
+	c := *s
+	c.words = make([]uint64, len(s.words))
+	copy(c.words, s.words)
+	c.hash = cloneHash64(s.hash)
+	return &c
+}
+
+// ByteSize returns the number of bytes used by the bit array and metadata of
+// the filter, excluding the hash function.
+func (s *ShiftingBloomFilter) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*s)) + uint64(cap(s.words))*8
+}
+
+// SetHash sets the hashing function used in the filter.
+func (s *ShiftingBloomFilter) SetHash(h hash.Hash64) {
+	s.hash = h
+}
+
+// hashKernel returns the base hash values from which the k positions are
+// derived, and the offsets of set B and of both sets. The offsets come from a
+// remix of the hash, with 0 < o1 < o2 < shiftingWindow.
+func (s *ShiftingBloomFilter) hashKernel(data []byte) (uint64, uint64, uint, uint) {
+	var (
+		lower, upper = sizedHashKernel(data, s.hash, s.seed, s.m)
+		mixed        = mix64(lower<<32 ^ upper)
+		half         = uint64(shiftingWindow-1) / 2
+		o1           = uint(uint32(mixed)%uint32(half)) + 1
+		o2           = o1 + uint(uint32(mixed>>32)%uint32(half)) + 1
+	)
+	return lower, upper, o1, o2
+}
+
+// window returns the 64 bits starting at the bit, the low shiftingWindow of
+// which are read by a probe.
+func (s *ShiftingBloomFilter) window(bit uint) uint64 {
+	var (
+		i     = bit / 64
+		shift = bit % 64
+		w     = s.words[i] >> shift
+	)
+	if shift != 0 {
+		w |= s.words[i+1] << (64 - shift)
+	}
+	return w
+}
+
+// shiftingWords returns the number of words holding m positions and the
+// largest offset from the last one.
+func shiftingWords(m uint) uint {
+	return (m+shiftingWindow-1+63)/64 + 1
+}
+
+// WriteTo writes a binary representation of the ShiftingBloomFilter to an I/O
+// stream. The hash function is not written, but the seed is. It returns the
+// number of bytes written.
+func (s *ShiftingBloomFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(s.m))
+	e.write(uint64(s.k))
+	e.write(uint64(s.count))
+	e.write(s.seed)
+	e.write(s.words)
+	writeReduction(&e, s.modulo)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a ShiftingBloomFilter (such as
+// might have been written by WriteTo()) from an I/O stream. The filter keeps
+// its current hash function, which must match the one used by the filter that
+// was written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (s *ShiftingBloomFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                 = decoder{r: payload}
+		m, k, count, seed uint64
+	)
+	d.read(&m)
+	d.read(&k)
+	d.read(&count)
+	d.read(&seed)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if m == 0 || k == 0 || k > m || m/8 > uint64(payload.Len()) {
+		return n, errors.New("bits don't match filter size")
+	}
+
+	words := make([]uint64, shiftingWords(uint(m)))
+	d.read(words)
+	modulo, err := readReduction(&d, payload)
+	if err != nil {
+		return n, err
+	}
+
+	if s.hash == nil {
+		s.hash = newFNV64()
+	}
+	s.words = words
+	s.m = uint(m)
+	s.k = uint(k)
+	s.count = uint(count)
+	s.seed = seed
+	s.modulo = modulo
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (s *ShiftingBloomFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(s)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (s *ShiftingBloomFilter) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(s, data)
+}
@@ -0,0 +1,108 @@
+package boom
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Ensures that a Shifting Bloom Filter reports every set an item was added to
+// and rarely reports another.
+func TestShiftingBloomFilterSets(t *testing.T) {
+	s := NewShiftingBloomFilter(3000, 0.01, WithSeed(3))
+	expected := func(i int) ShiftingSet {
+		return ShiftingSet(i%3 + 1)
+	}
+	for i := 0; i < 3000; i++ {
+		s.AddString(strconv.Itoa(i), expected(i))
+	}
+	if s.Count() != 3000 {
+		t.Errorf("Expected 3000 items, got %d", s.Count())
+	}
+
+	wrong := 0
+	for i := 0; i < 3000; i++ {
+		sets := s.SetsString(strconv.Itoa(i))
+		if sets&expected(i) != expected(i) {
+			t.Fatalf("Expected %d to be in sets %d, got %d", i, expected(i), sets)
+		}
+		if sets != expected(i) {
+			wrong++
+		}
+	}
+	// Each item can be misreported by two other offsets.
+	if rate := float64(wrong) / 3000; rate > 0.03 {
+		t.Errorf("Expected ambiguous rate below 0.03, got %f", rate)
+	}
+
+	fp := 0
+	for i := 3000; i < 103000; i++ {
+		if s.TestString(strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 100000; rate > 0.03 {
+		t.Errorf("Expected false-positive rate below 0.03, got %f", rate)
+	}
+}
+
+// Ensures that adding an item to each set separately reports it in both.
+func TestShiftingBloomFilterAddSeparately(t *testing.T) {
+	s := NewShiftingBloomFilter(100, 0.01)
+	s.AddString("a", ShiftingSetA).AddString("a", ShiftingSetB).AddString("b", 0)
+	if sets := s.SetsString("a"); sets != ShiftingSetBoth {
+		t.Errorf("Expected a in both sets, got %d", sets)
+	}
+	if s.TestString("b") || s.Count() != 2 {
+		t.Error("Expected b not to be added")
+	}
+
+	s.Reset()
+	if s.TestString("a") || s.Count() != 0 {
+		t.Error("Expected empty filter after reset")
+	}
+}
+
+// Ensures that MarshalBinary and UnmarshalBinary round-trip the filter.
+func TestShiftingBloomFilterReadWrite(t *testing.T) {
+	s := NewShiftingBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		s.Add([]byte(strconv.Itoa(i)), ShiftingSet(i%3+1))
+	}
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restored := &ShiftingBloomFilter{}
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !restored.Equal(s) {
+		t.Error("Expected restored filter to equal original")
+	}
+
+	clone := s.Clone()
+	clone.AddString("x", ShiftingSetA)
+	if clone.Equal(s) {
+		t.Error("Expected clone to be independent")
+	}
+
+	if err := restored.UnmarshalBinary(data[:len(data)-8]); err == nil {
+		t.Error("Expected error for truncated data")
+	}
+}
+
+func BenchmarkShiftingBloomFilterSets(b *testing.B) {
+	s := NewShiftingBloomFilter(100000, 0.01)
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+		if i < 100000 {
+			s.Add(data[i], ShiftingSet(i%3+1))
+		}
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		s.Sets(data[n])
+	}
+}
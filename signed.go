@@ -0,0 +1,78 @@
+package boom
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"io"
+)
+
+// ErrSignatureMismatch is returned by ReadSigned when the data's signature
+// doesn't match the key, meaning it was tampered with, signed with another
+// key, or not signed at all.
+var ErrSignatureMismatch = errors.New("signature mismatch")
+
+// WriteSigned writes the binary representation of the value, such as a filter,
+// to an I/O stream signed with an HMAC-SHA256 of the key, so the value can be
+// distributed over untrusted channels and verified by ReadSigned with the same
+// key. The checksum of a frame only detects accidental corruption, whereas a
+// deliberately flipped bit, which could make a filter report an item as a
+// member, can't be forged without the key. Returns an error if the key is
+// empty. It returns the number of bytes written.
+func WriteSigned(stream io.Writer, w io.WriterTo, key []byte) (int64, error) {
+	if len(key) == 0 {
+		return 0, errors.New("the signing key is required")
+	}
+
+	data, err := marshalBinary(w)
+	if err != nil {
+		return 0, err
+	}
+
+	payload := make([]byte, 0, sha256.Size+len(data))
+	payload = append(payload, signature(key, data)...)
+	payload = append(payload, data...)
+	return writeFrame(stream, payload)
+}
+
+// ReadSigned reads a value written by WriteSigned from an I/O stream, verifying
+// its signature with the key before the value reads it, so a tampered value is
+// never deserialized. Returns ErrSignatureMismatch if the signature doesn't
+// match, or an error if the key is empty. It returns the number of bytes read.
+func ReadSigned(stream io.Reader, r io.ReaderFrom, key []byte) (int64, error) {
+	if len(key) == 0 {
+		return 0, errors.New("the signing key is required")
+	}
+
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	if payload.Len() < sha256.Size {
+		return n, ErrSignatureMismatch
+	}
+
+	var (
+		tag  = make([]byte, sha256.Size)
+		data = make([]byte, payload.Len()-sha256.Size)
+	)
+	payload.Read(tag)
+	payload.Read(data)
+	if !hmac.Equal(tag, signature(key, data)) {
+		return n, ErrSignatureMismatch
+	}
+
+	if _, err := r.ReadFrom(bytes.NewReader(data)); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// signature returns the HMAC-SHA256 of the data with the key.
+func signature(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,66 @@
+package boom
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Ensures that a filter written by WriteSigned is read by ReadSigned with the
+// same key, and refused if it was tampered with or the key differs.
+func TestSigned(t *testing.T) {
+	var (
+		key = []byte("secret")
+		f   = NewBloomFilter(100, 0.01)
+		buf bytes.Buffer
+	)
+	f.AddString("a")
+	n, err := WriteSigned(&buf, f, key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("Expected %d bytes written, got %d", buf.Len(), n)
+	}
+	signed := buf.Bytes()
+
+	read := NewBloomFilter(1, 0.5)
+	if n, err := ReadSigned(bytes.NewReader(signed), read, key); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	} else if n != int64(len(signed)) {
+		t.Errorf("Expected %d bytes read, got %d", len(signed), n)
+	}
+	if !read.Equal(f) {
+		t.Error("Expected filters to be equal")
+	}
+
+	if _, err := ReadSigned(bytes.NewReader(signed), NewBloomFilter(1, 0.5), []byte("other")); err != ErrSignatureMismatch {
+		t.Errorf("Expected ErrSignatureMismatch, got %v", err)
+	}
+
+	// Flip a bit of the filter and recompute the frame's checksum, as an
+	// attacker would, so only the signature detects it.
+	data := signed[headerSize:]
+	data[len(data)-1] ^= 1
+	var tampered bytes.Buffer
+	if _, err := writeFrame(&tampered, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := ReadSigned(&tampered, NewBloomFilter(1, 0.5), key); err != ErrSignatureMismatch {
+		t.Errorf("Expected ErrSignatureMismatch, got %v", err)
+	}
+
+	buf.Reset()
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := ReadSigned(&buf, NewBloomFilter(1, 0.5), key); err != ErrSignatureMismatch {
+		t.Errorf("Expected ErrSignatureMismatch for unsigned data, got %v", err)
+	}
+
+	if _, err := WriteSigned(&buf, f, nil); err == nil {
+		t.Error("Expected error for an empty key")
+	}
+	if _, err := ReadSigned(bytes.NewReader(signed), f, nil); err == nil {
+		t.Error("Expected error for an empty key")
+	}
+}
@@ -0,0 +1,221 @@
+package boom
+
+import (
+	"errors"
+	"hash"
+	"math/bits"
+	"sort"
+)
+
+// SimHash computes the SimHash fingerprint of a document's weighted features,
+// such as its words or shingles, as described by Charikar in Similarity
+// Estimation Techniques from Rounding Algorithms:
+//
+// http://www.cs.princeton.edu/courses/archive/spr04/cos598B/bib/CharikarEstim.pdf
+//
+// Each feature is hashed to 64 bits, and each bit of the fingerprint is set if
+// the weights of the features with the bit set outweigh those without it.
+// Documents sharing most of their weight have fingerprints differing in few
+// bits, so near-duplicates are found by the Hamming distance of their
+// fingerprints, which SimHashIndex looks up without comparing every pair.
+// Unlike a MinHashSignature, a fingerprint takes 8 bytes whatever the accuracy
+// and reflects the cosine similarity of the feature weights rather than the
+// Jaccard similarity of the sets.
+type SimHash struct {
+	weights [64]float64 // sum of the weights for each bit set less those not
+	hash    hash.Hash64 // hash function
+	seed    uint64      // hash seed (zero means unseeded)
+}
+
+// NewSimHash creates a new SimHash with no features.
+func NewSimHash(opts ...Option) *SimHash {
+	o := applyOptions(opts)
+	return &SimHash{hash: o.newHash64(newFNV64), seed: o.seed}
+}
+
+// Add will add the feature with a weight of one. Returns the SimHash to allow
+// for chaining.
+func (s *SimHash) Add(data []byte) *SimHash {
+	return s.AddWeighted(data, 1)
+}
+
+// AddWeighted will add the feature with the weight, such as its frequency in
+// the document. Returns the SimHash to allow for chaining.
+func (s *SimHash) AddWeighted(data []byte, weight float64) *SimHash {
+	lower, upper := seededHashKernel(data, s.hash, s.seed)
+	x := mix64(uint64(upper)<<32 | uint64(lower))
+	for i := range s.weights {
+		if x&(1<<uint(i)) != 0 {
+			s.weights[i] += weight
+		} else {
+			s.weights[i] -= weight
+		}
+	}
+	return s
+}
+
+// Fingerprint returns the 64-bit fingerprint of the features added.
+func (s *SimHash) Fingerprint() uint64 {
+	var fingerprint uint64
+	for i, w := range s.weights {
+		if w > 0 {
+			fingerprint |= 1 << uint(i)
+		}
+	}
+	return fingerprint
+}
+
+// Reset restores the SimHash to its original state. It returns itself to
+// allow for chaining.
+func (s *SimHash) Reset() *SimHash {
+	s.weights = [64]float64{}
+	return s
+}
+
+// SetHash sets the hashing function used.
+func (s *SimHash) SetHash(h hash.Hash64) {
+	s.hash = h
+}
+
+// HammingDistance returns the number of bits in which the fingerprints differ.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// simHashEntry is a key in a SimHashIndex and its fingerprint.
+type simHashEntry struct {
+	key         string
+	fingerprint uint64
+}
+
+// SimHashIndex finds the fingerprints within a Hamming distance d of a query
+// using the multi-index tables described by Manku, Jain, and Das Sarma in
+// Detecting Near-Duplicates for Web Crawling:
+//
+// https://research.google/pubs/pub33026/
+//
+// The fingerprints are split into d + 1 blocks of bits, and each is filed in
+// a table per block by that block's bits. Fingerprints differing in at most d
+// bits match exactly in at least one block, so a query only compares the
+// fingerprints sharing one of its blocks rather than every one in the index.
+// Larger distances have shorter blocks, which match more fingerprints by
+// chance, so a query takes longer.
+type SimHashIndex struct {
+	tables   []map[uint64][]simHashEntry // entries by block, one table per block
+	keys     map[string]uint64           // fingerprint of each key
+	distance uint                        // largest Hamming distance of a match
+}
+
+// NewSimHashIndex creates a new SimHashIndex finding fingerprints within the
+// Hamming distance. Returns an error if the distance is more than 63.
+func NewSimHashIndex(distance uint) (*SimHashIndex, error) {
+	if distance > 63 {
+		return nil, errors.New("distance must be at most 63")
+	}
+
+	tables := make([]map[uint64][]simHashEntry, distance+1)
+	for i := range tables {
+		tables[i] = make(map[uint64][]simHashEntry)
+	}
+	return &SimHashIndex{
+		tables:   tables,
+		keys:     make(map[string]uint64),
+		distance: distance,
+	}, nil
+}
+
+// Distance returns the largest Hamming distance of the fingerprints found.
+func (s *SimHashIndex) Distance() uint {
+	return s.distance
+}
+
+// Len returns the number of keys in the index.
+func (s *SimHashIndex) Len() int {
+	return len(s.keys)
+}
+
+// Insert adds the key to the index with the fingerprint, replacing the
+// fingerprint it was inserted with before.
+func (s *SimHashIndex) Insert(key string, fingerprint uint64) {
+	s.Remove(key)
+	for i := range s.tables {
+		block := s.block(fingerprint, i)
+		s.tables[i][block] = append(s.tables[i][block], simHashEntry{key: key, fingerprint: fingerprint})
+	}
+	s.keys[key] = fingerprint
+}
+
+// Remove removes the key from the index. Returns true if it was in the index.
+func (s *SimHashIndex) Remove(key string) bool {
+	fingerprint, ok := s.keys[key]
+	if !ok {
+		return false
+	}
+
+	for i, table := range s.tables {
+		block := s.block(fingerprint, i)
+		entries := table[block]
+		for j, e := range entries {
+			if e.key == key {
+				entries = append(entries[:j], entries[j+1:]...)
+				break
+			}
+		}
+		if len(entries) == 0 {
+			delete(table, block)
+		} else {
+			table[block] = entries
+		}
+	}
+	delete(s.keys, key)
+	return true
+}
+
+// Query returns the keys whose fingerprints are within the Hamming distance of
+// the fingerprint, in sorted order.
+func (s *SimHashIndex) Query(fingerprint uint64) []string {
+	seen := make(map[string]bool)
+	for i, table := range s.tables {
+		for _, e := range table[s.block(fingerprint, i)] {
+			if !seen[e.key] && HammingDistance(e.fingerprint, fingerprint) <= int(s.distance) {
+				seen[e.key] = true
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Reset restores the SimHashIndex to an empty index. It returns itself to
+// allow for chaining.
+func (s *SimHashIndex) Reset() *SimHashIndex {
+	for i := range s.tables {
+		s.tables[i] = make(map[uint64][]simHashEntry)
+	}
+	s.keys = make(map[string]uint64)
+	return s
+}
+
+// block returns the bits of the ith block of the fingerprint. The 64 bits are
+// split into blocks as even as possible, the first ones a bit longer.
+func (s *SimHashIndex) block(fingerprint uint64, i int) uint64 {
+	var (
+		blocks = uint(len(s.tables))
+		size   = 64 / blocks
+		extra  = 64 % blocks
+		start  = uint(i)*size + extra
+	)
+	if uint(i) < extra {
+		start -= extra - uint(i)
+		size++
+	}
+	if size == 64 {
+		return fingerprint
+	}
+	return (fingerprint >> start) & (1<<size - 1)
+}
@@ -0,0 +1,91 @@
+package boom
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Ensures that the fingerprints of documents sharing most of their features
+// are closer than those of unrelated documents.
+func TestSimHash(t *testing.T) {
+	fingerprint := func(start, end int) uint64 {
+		s := NewSimHash()
+		for i := start; i < end; i++ {
+			s.Add([]byte(strconv.Itoa(i)))
+		}
+		return s.Fingerprint()
+	}
+
+	var (
+		a         = fingerprint(0, 1000)
+		near      = fingerprint(10, 1000)
+		unrelated = fingerprint(1000, 2000)
+	)
+	if d := HammingDistance(a, near); d > 8 {
+		t.Errorf("Expected a distance of at most 8 for near-duplicates, got %d", d)
+	}
+	if d := HammingDistance(a, unrelated); d < 16 {
+		t.Errorf("Expected a distance of at least 16 for unrelated documents, got %d", d)
+	}
+	if HammingDistance(a, fingerprint(0, 1000)) != 0 {
+		t.Error("Expected identical fingerprints for identical documents")
+	}
+
+	// Weighting a feature outweighs the others.
+	s := NewSimHash().AddWeighted([]byte(`a`), 10).Add([]byte(`b`)).Add([]byte(`c`))
+	if s.Fingerprint() != NewSimHash().Add([]byte(`a`)).Fingerprint() {
+		t.Error("Expected the heaviest feature to decide the fingerprint")
+	}
+	if s.Reset().Fingerprint() != 0 {
+		t.Error("Expected an empty fingerprint")
+	}
+}
+
+// Ensures that SimHashIndex finds exactly the fingerprints within its
+// distance.
+func TestSimHashIndex(t *testing.T) {
+	if _, err := NewSimHashIndex(64); err == nil {
+		t.Error("Expected an error for a distance more than 63")
+	}
+	index, err := NewSimHashIndex(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const query = uint64(0xdeadbeefcafebabe)
+	var expected []string
+	for i := 0; i < 64; i++ {
+		// Flip bits spread across the blocks.
+		fingerprint := query ^ (1 << uint(i)) ^ (1 << uint((i+21)%64)) ^ (1 << uint((i+43)%64))
+		if i%2 == 0 {
+			fingerprint ^= 1 << uint((i+32)%64)
+		} else {
+			expected = append(expected, strconv.Itoa(100+i))
+		}
+		index.Insert(strconv.Itoa(100+i), fingerprint)
+	}
+	index.Insert("far", ^query)
+	if index.Len() != 65 {
+		t.Errorf("Expected 65 keys, got %d", index.Len())
+	}
+
+	keys := index.Query(query)
+	if len(keys) != len(expected) {
+		t.Fatalf("Expected %d keys, got %d", len(expected), len(keys))
+	}
+	for i, key := range keys {
+		if key != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], key)
+		}
+	}
+
+	if !index.Remove("101") || index.Remove("101") {
+		t.Error("Expected to remove 101 once")
+	}
+	if keys := index.Query(query); len(keys) != len(expected)-1 {
+		t.Errorf("Expected %d keys, got %d", len(expected)-1, len(keys))
+	}
+	if index.Reset().Len() != 0 {
+		t.Error("Expected an empty index")
+	}
+}
@@ -0,0 +1,80 @@
+/*
+SipHash was designed by Jean-Philippe Aumasson and Daniel J. Bernstein and
+placed in the public domain. This is a Go port of SipHash-2-4.
+*/
+
+package boom
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math/bits"
+)
+
+// sipKey is a 128-bit SipHash key.
+type sipKey [16]byte
+
+// newSipKey returns a random SipHash key from the operating system's
+// cryptographically secure source.
+func newSipKey() sipKey {
+	var key sipKey
+	rand.Read(key[:])
+	return key
+}
+
+// siphash24 returns the 64-bit SipHash-2-4 of the data using the key. Unlike
+// unkeyed hash functions, its output can't be predicted without the key, so
+// inputs can't be crafted to collide.
+func siphash24(key sipKey, data []byte) uint64 {
+	var (
+		k0     = binary.LittleEndian.Uint64(key[:8])
+		k1     = binary.LittleEndian.Uint64(key[8:])
+		v0     = k0 ^ 0x736f6d6570736575
+		v1     = k1 ^ 0x646f72616e646f6d
+		v2     = k0 ^ 0x6c7967656e657261
+		v3     = k1 ^ 0x7465646279746573
+		length = uint64(len(data))
+	)
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	for len(data) >= 8 {
+		m := binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+		data = data[8:]
+	}
+
+	m := length << 56
+	for i, c := range data {
+		m |= uint64(c) << (8 * uint(i))
+	}
+	v3 ^= m
+	round()
+	round()
+	v0 ^= m
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
+}
@@ -0,0 +1,105 @@
+package boom
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Ensures that siphash24 matches the reference implementation's test vectors,
+// which use the key 00 01 ... 0f and the messages 00 01 ... (n-1).
+func TestSipHash24(t *testing.T) {
+	var key sipKey
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	data := make([]byte, 15)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	tests := []struct {
+		n    int
+		hash uint64
+	}{
+		{0, 0x726fdb47dd0e0e31},
+		{15, 0xa129ca6149be45e5},
+	}
+
+	for _, test := range tests {
+		if hash := siphash24(key, data[:test.n]); hash != test.hash {
+			t.Errorf("Expected %x for %d bytes, got %x", test.hash, test.n, hash)
+		}
+	}
+}
+
+// Ensures that a keyed BloomFilter tests membership, has a random key, and
+// keeps its key when serialized.
+func TestKeyedBloomFilter(t *testing.T) {
+	f := NewKeyedBloomFilter(100, 0.01)
+	other := NewKeyedBloomFilter(100, 0.01)
+	if f.Key() == other.Key() {
+		t.Error("Expected different keys")
+	}
+
+	f.Add([]byte("a"))
+	if !f.Test([]byte("a")) {
+		t.Error("Expected a to be a member")
+	}
+
+	other.SetKey(f.Key())
+	other.Add([]byte("a"))
+	if !other.Test([]byte("a")) {
+		t.Error("Expected a to be a member with the restored key")
+	}
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	restored := NewBloomFilter(10, 0.1)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if restored.Key() != f.Key() {
+		t.Error("Expected key to be restored")
+	}
+
+	if !restored.Test([]byte("a")) {
+		t.Error("Expected a to be a member")
+	}
+}
+
+// Ensures that a keyed InverseBloomFilter tests membership and keeps its key
+// when serialized.
+func TestKeyedInverseBloomFilter(t *testing.T) {
+	f := NewKeyedInverseBloomFilter(100)
+	if f.Key() == NewKeyedInverseBloomFilter(100).Key() {
+		t.Error("Expected different keys")
+	}
+
+	f.Add([]byte("a"))
+	if !f.Test([]byte("a")) {
+		t.Error("Expected a to be a member")
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	restored := NewInverseBloomFilter(10)
+	if _, err := restored.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if restored.Key() != f.Key() {
+		t.Error("Expected key to be restored")
+	}
+
+	if !restored.Test([]byte("a")) {
+		t.Error("Expected a to be a member")
+	}
+}
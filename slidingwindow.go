@@ -0,0 +1,331 @@
+package boom
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"time"
+	"unsafe"
+)
+
+// SlidingWindowFilter is a Bloom filter which only reports items added within
+// a sliding window, defined either by time or by a number of items. The window
+// is divided into slices of equal width, each held by a Bloom filter, plus one
+// for the slice being filled. An item is added to the filter of its slice and
+// tested against all of them, and once a slice falls out of the window its
+// filter is cleared for reuse.
+//
+// An item added within the window is always reported, since its slice hasn't
+// been cleared yet. An item is forgotten at most one slice width after it
+// leaves the window, once its slice is cleared, and is only reported again as
+// a false positive. More slices make the window more precise but take more
+// filters to test.
+//
+// A time window advances with the timestamps passed to Add and Advance, which
+// needn't be in order: an item timestamped within the window is added to the
+// slice of its timestamp, and one timestamped before it is ignored. A count
+// window advances with every addition, whatever its timestamp.
+type SlidingWindowFilter struct {
+	filters []*BloomFilter // ring of slices, the newest at head
+	width   int64          // width of a slice in nanoseconds or items
+	head    int64          // number of the newest slice
+	byCount bool           // the window is a number of items
+	started bool           // a time window has seen a timestamp
+	count   uint           // number of items added
+}
+
+// NewSlidingWindowFilter creates a new Sliding Window Filter which reports
+// items added within the window of time, divided into a number of slices, at
+// least 1, expecting at most n items per window, with a specified target
+// false-positive rate.
+func NewSlidingWindowFilter(window time.Duration, slices, n uint, fpRate float64, opts ...Option) *SlidingWindowFilter {
+	if slices == 0 {
+		slices = 1
+	}
+	width := int64(window) / int64(slices)
+	if width <= 0 {
+		width = 1
+	}
+	return newSlidingWindowFilter(width, slices, (n+slices-1)/slices, fpRate, false, opts)
+}
+
+// NewCountSlidingWindowFilter creates a new Sliding Window Filter which reports
+// the last window items added, divided into a number of slices, at least 1,
+// with a specified target false-positive rate.
+func NewCountSlidingWindowFilter(window, slices uint, fpRate float64, opts ...Option) *SlidingWindowFilter {
+	if slices == 0 {
+		slices = 1
+	}
+	width := (window + slices - 1) / slices
+	if width == 0 {
+		width = 1
+	}
+	return newSlidingWindowFilter(int64(width), slices, width, fpRate, true, opts)
+}
+
+// newSlidingWindowFilter creates the ring of slices of the width, each
+// optimized to store n items, such that the false-positive rate of testing all
+// of them is fpRate.
+func newSlidingWindowFilter(width int64, slices, n uint, fpRate float64, byCount bool, opts []Option) *SlidingWindowFilter {
+	filters := make([]*BloomFilter, slices+1)
+	for i := range filters {
+		filters[i] = NewBloomFilter(n, fpRate/float64(len(filters)), opts...)
+	}
+	return &SlidingWindowFilter{
+		filters: filters,
+		width:   width,
+		byCount: byCount,
+	}
+}
+
+// Slices returns the number of slices in the window, excluding the one being
+// filled.
+func (s *SlidingWindowFilter) Slices() uint {
+	return uint(len(s.filters) - 1)
+}
+
+// Count returns the number of items added to the filter, including those which
+// have left the window.
+func (s *SlidingWindowFilter) Count() uint {
+	return s.count
+}
+
+// Test will test for membership of the data within the window ending at the
+// latest timestamp passed to Add or Advance, or at the latest item of a count
+// window, and returns true if it is a member, false if not. This is a
+// probabilistic test, meaning there is a non-zero probability of false
+// positives but a zero probability of false negatives.
+func (s *SlidingWindowFilter) Test(data []byte) bool {
+	for _, filter := range s.filters {
+		if filter.Test(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (s *SlidingWindowFilter) TestString(data string) bool {
+	return s.Test(stringBytes(data))
+}
+
+// Add will add the data at the timestamp, advancing a time window to it if
+// it's later than any before. Data timestamped before the window is ignored.
+// The timestamp of a count window is ignored. It returns the filter to allow
+// for chaining.
+func (s *SlidingWindowFilter) Add(data []byte, t time.Time) *SlidingWindowFilter {
+	var slice int64
+	if s.byCount {
+		slice = int64(s.count) / s.width
+	} else {
+		slice = s.slice(t)
+	}
+	s.advance(slice)
+	if slice <= s.head-int64(len(s.filters)) {
+		return s
+	}
+
+	s.filters[s.ring(slice)].Add(data)
+	s.count++
+	return s
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (s *SlidingWindowFilter) AddString(data string, t time.Time) *SlidingWindowFilter {
+	return s.Add(stringBytes(data), t)
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data is a member, false if not.
+func (s *SlidingWindowFilter) TestAndAdd(data []byte, t time.Time) bool {
+	member := s.Test(data)
+	s.Add(data, t)
+	return member
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (s *SlidingWindowFilter) TestAndAddString(data string, t time.Time) bool {
+	return s.TestAndAdd(stringBytes(data), t)
+}
+
+// Advance advances a time window to end at the time, if it's later than any
+// timestamp before, forgetting the items which have left it. This lets Test
+// forget items after a period without additions. A count window is left
+// unchanged. It returns the filter to allow for chaining.
+func (s *SlidingWindowFilter) Advance(t time.Time) *SlidingWindowFilter {
+	if !s.byCount {
+		s.advance(s.slice(t))
+	}
+	return s
+}
+
+// Reset restores the filter to its original state. It returns the filter to
+// allow for chaining.
+func (s *SlidingWindowFilter) Reset() *SlidingWindowFilter {
+	for _, filter := range s.filters {
+		filter.Reset()
+	}
+	s.head = 0
+	s.started = false
+	s.count = 0
+	return s
+}
+
+// ByteSize returns the number of bytes used by the slices and metadata of the
+// filter, excluding the hash functions.
+func (s *SlidingWindowFilter) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*s)) + uint64(cap(s.filters))*pointerSize
+	for _, filter := range s.filters {
+		size += filter.ByteSize()
+	}
+	return size
+}
+
+// SetHash sets the hashing function used in the filter.
+func (s *SlidingWindowFilter) SetHash(h hash.Hash64) {
+	for _, filter := range s.filters {
+		filter.SetHash(h)
+	}
+}
+
+// slice returns the number of the slice holding the time, starting a time
+// window at the first timestamp.
+func (s *SlidingWindowFilter) slice(t time.Time) int64 {
+	slice := t.UnixNano() / s.width
+	if t.UnixNano()%s.width < 0 {
+		slice--
+	}
+	if !s.started {
+		s.head = slice
+		s.started = true
+	}
+	return slice
+}
+
+// advance makes the slice the newest if it's newer than the head, clearing the
+// slices which leave the window.
+func (s *SlidingWindowFilter) advance(slice int64) {
+	if slice <= s.head {
+		return
+	}
+	if slice-s.head >= int64(len(s.filters)) {
+		for _, filter := range s.filters {
+			filter.Reset()
+		}
+	} else {
+		for i := s.head + 1; i <= slice; i++ {
+			s.filters[s.ring(i)].Reset()
+		}
+	}
+	s.head = slice
+}
+
+// ring returns the index of the slice's filter.
+func (s *SlidingWindowFilter) ring(slice int64) int {
+	i := slice % int64(len(s.filters))
+	if i < 0 {
+		i += int64(len(s.filters))
+	}
+	return int(i)
+}
+
+// WriteTo writes a binary representation of the SlidingWindowFilter to an I/O
+// stream. The hash function is not written, but the seed is. It returns the
+// number of bytes written.
+func (s *SlidingWindowFilter) WriteTo(stream io.Writer) (int64, error) {
+	var byCount, started uint8
+	if s.byCount {
+		byCount = 1
+	}
+	if s.started {
+		started = 1
+	}
+
+	var e encoder
+	e.write(s.width)
+	e.write(s.head)
+	e.write(byCount)
+	e.write(started)
+	e.write(uint64(s.count))
+	e.write(uint64(len(s.filters)))
+	for _, filter := range s.filters {
+		e.writeTo(filter)
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a SlidingWindowFilter (such as
+// might have been written by WriteTo()) from an I/O stream. The filter keeps
+// its current hash function, which must match the one used by the filter that
+// was written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (s *SlidingWindowFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                = decoder{r: payload}
+		width, head      int64
+		byCount, started uint8
+		count, l         uint64
+		hash             hash.Hash64
+	)
+	d.read(&width)
+	d.read(&head)
+	d.read(&byCount)
+	d.read(&started)
+	d.read(&count)
+	d.read(&l)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if width <= 0 || l < 2 || byCount > 1 || started > 1 {
+		return n, errors.New("invalid filter parameters")
+	}
+	if len(s.filters) > 0 {
+		hash = s.filters[0].hash
+	}
+	if hash == nil {
+		hash = newFNV64()
+	}
+
+	filters := make([]*BloomFilter, 0, 2)
+	for i := uint64(0); i < l && d.err == nil; i++ {
+		filter := &BloomFilter{hash: hash}
+		d.readFrom(filter)
+		filters = append(filters, filter)
+	}
+	if d.err != nil {
+		return n, d.err
+	}
+
+	s.filters = filters
+	s.width = width
+	s.head = head
+	s.byCount = byCount == 1
+	s.started = started == 1
+	s.count = uint(count)
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (s *SlidingWindowFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(s)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (s *SlidingWindowFilter) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(s, data)
+}
@@ -0,0 +1,129 @@
+package boom
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Ensures that a time Sliding Window Filter reports items within the window
+// and forgets them at most one slice after they leave it.
+func TestSlidingWindowFilterTime(t *testing.T) {
+	var (
+		s     = NewSlidingWindowFilter(24*time.Hour, 24, 10000, 0.01)
+		start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+	for i := 0; i < 48; i++ {
+		s.AddString(strconv.Itoa(i), start.Add(time.Duration(i)*time.Hour))
+	}
+	if s.Count() != 48 || s.Slices() != 24 {
+		t.Errorf("Expected 48 items and 24 slices, got %d and %d", s.Count(), s.Slices())
+	}
+
+	// The window ends at hour 47, so hours 23 to 47 are within it.
+	for i := 23; i < 48; i++ {
+		if !s.TestString(strconv.Itoa(i)) {
+			t.Errorf("Expected %d to be a member", i)
+		}
+	}
+	for i := 0; i < 22; i++ {
+		if s.TestString(strconv.Itoa(i)) {
+			t.Errorf("Expected %d to be forgotten", i)
+		}
+	}
+
+	// Out-of-order items are added to their slice unless before the window.
+	s.AddString("late", start.Add(30*time.Hour)).AddString("expired", start)
+	if !s.TestString("late") || s.TestString("expired") || s.Count() != 49 {
+		t.Error("Expected late item to be added and expired item to be ignored")
+	}
+
+	s.Advance(start.Add(100 * time.Hour))
+	if s.TestString("47") || s.TestString("late") {
+		t.Error("Expected every item to be forgotten after advancing")
+	}
+	if s.TestAndAddString("a", start.Add(100*time.Hour)) || !s.TestString("a") {
+		t.Error("Expected a to be added")
+	}
+
+	s.Reset()
+	if s.TestString("a") || s.Count() != 0 {
+		t.Error("Expected empty filter after reset")
+	}
+}
+
+// Ensures that a count Sliding Window Filter reports the last window items.
+func TestSlidingWindowFilterCount(t *testing.T) {
+	s := NewCountSlidingWindowFilter(10000, 10, 0.01)
+	for i := 0; i < 50000; i++ {
+		s.AddString(strconv.Itoa(i), time.Time{})
+	}
+
+	for i := 40000; i < 50000; i++ {
+		if !s.TestString(strconv.Itoa(i)) {
+			t.Fatalf("Expected %d to be a member", i)
+		}
+	}
+	fp := 0
+	for i := 0; i < 39000; i++ {
+		if s.TestString(strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 39000; rate > 0.02 {
+		t.Errorf("Expected false-positive rate below 0.02, got %f", rate)
+	}
+}
+
+// Ensures that MarshalBinary and UnmarshalBinary round-trip the filter.
+func TestSlidingWindowFilterReadWrite(t *testing.T) {
+	var (
+		s     = NewSlidingWindowFilter(time.Hour, 4, 1000, 0.01)
+		start = time.Unix(0, 0)
+	)
+	for i := 0; i < 100; i++ {
+		s.AddString(strconv.Itoa(i), start.Add(time.Duration(i)*time.Minute))
+	}
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restored := &SlidingWindowFilter{}
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if restored.Count() != s.Count() {
+		t.Errorf("Expected %d items, got %d", s.Count(), restored.Count())
+	}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if restored.TestString(key) != s.TestString(key) {
+			t.Errorf("Expected %t for %s, got %t", s.TestString(key), key, restored.TestString(key))
+		}
+	}
+
+	restored.Advance(start.Add(200 * time.Minute))
+	if restored.TestString("99") {
+		t.Error("Expected restored filter to keep advancing")
+	}
+
+	if err := restored.UnmarshalBinary(data[:len(data)-8]); err == nil {
+		t.Error("Expected error for truncated data")
+	}
+}
+
+func BenchmarkSlidingWindowFilterTestAndAdd(b *testing.B) {
+	var (
+		s     = NewSlidingWindowFilter(time.Hour, 12, 100000, 0.01)
+		start = time.Now()
+		data  = make([][]byte, b.N)
+	)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		s.TestAndAdd(data[n], start.Add(time.Duration(n)*time.Millisecond))
+	}
+}
@@ -0,0 +1,280 @@
+package boom
+
+import (
+	"container/heap"
+	"errors"
+	"io"
+	"unsafe"
+)
+
+// spaceSavingCounter is an item monitored by a SpaceSaving summary.
+type spaceSavingCounter struct {
+	data  []byte
+	count uint64 // estimated count, never below the true count
+	err   uint64 // most by which count exceeds the true count
+	index int    // position in the heap
+}
+
+// A spaceSavingHeap is a min-heap of counters by count.
+type spaceSavingHeap []*spaceSavingCounter
+
+func (s spaceSavingHeap) Len() int           { return len(s) }
+func (s spaceSavingHeap) Less(i, j int) bool { return s[i].count < s[j].count }
+
+func (s spaceSavingHeap) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+	s[i].index = i
+	s[j].index = j
+}
+
+func (s *spaceSavingHeap) Push(x interface{}) {
+	counter := x.(*spaceSavingCounter)
+	counter.index = len(*s)
+	*s = append(*s, counter)
+}
+
+func (s *spaceSavingHeap) Pop() interface{} {
+	old := *s
+	n := len(old)
+	x := old[n-1]
+	*s = old[0 : n-1]
+	return x
+}
+
+// SpaceSaving implements the Space-Saving algorithm as described by Metwally,
+// Agrawal, and El Abbadi in Efficient Computation of Frequent and Top-k
+// Elements in Data Streams:
+//
+// https://doi.org/10.1007/978-3-540-30570-5_27
+//
+// It monitors k items with a counter each. An item already monitored
+// increments its counter, and an item which isn't replaces the item with the
+// smallest counter, taking over its count plus one and recording it as its
+// error. Unlike TopK, whose sketch can let items which are never frequent
+// into the heap when their counters collide with others', its guarantees are
+// deterministic: each count is at most its error above the true count, and
+// every item added more than n/k times out of n is monitored. It takes O(k)
+// memory whatever the number of distinct items.
+type SpaceSaving struct {
+	counters map[string]*spaceSavingCounter
+	elements spaceSavingHeap
+	k        uint
+	n        uint64
+}
+
+// NewSpaceSaving creates a new SpaceSaving summary monitoring k items, at
+// least 1.
+func NewSpaceSaving(k uint) *SpaceSaving {
+	if k == 0 {
+		k = 1
+	}
+	return &SpaceSaving{
+		counters: make(map[string]*spaceSavingCounter, k),
+		elements: make(spaceSavingHeap, 0, k),
+		k:        k,
+	}
+}
+
+// K returns the number of items monitored.
+func (s *SpaceSaving) K() uint {
+	return s.k
+}
+
+// TotalCount returns the number of items added.
+func (s *SpaceSaving) TotalCount() uint64 {
+	return s.n
+}
+
+// Add will add the data to the summary, replacing the item with the smallest
+// count if it isn't monitored and k items already are. Returns the SpaceSaving
+// to allow for chaining.
+func (s *SpaceSaving) Add(data []byte) *SpaceSaving {
+	s.n++
+	if counter, ok := s.counters[string(data)]; ok {
+		counter.count++
+		heap.Fix(&s.elements, counter.index)
+		return s
+	}
+
+	if uint(len(s.elements)) < s.k {
+		counter := &spaceSavingCounter{data: append([]byte(nil), data...), count: 1}
+		s.counters[string(counter.data)] = counter
+		heap.Push(&s.elements, counter)
+		return s
+	}
+
+	// Replace the item with the smallest count.
+	counter := s.elements[0]
+	delete(s.counters, string(counter.data))
+	counter.data = append([]byte(nil), data...)
+	counter.err = counter.count
+	counter.count++
+	s.counters[string(counter.data)] = counter
+	heap.Fix(&s.elements, 0)
+	return s
+}
+
+// Count returns the estimated count of the data and the most by which it
+// exceeds the true count. An item which isn't monitored has an estimate of
+// zero, and was added at most as many times as the smallest monitored count.
+func (s *SpaceSaving) Count(data []byte) (uint64, uint64) {
+	if counter, ok := s.counters[string(data)]; ok {
+		return counter.count, counter.err
+	}
+	return 0, 0
+}
+
+// Elements returns the monitored elements from lowest to highest count.
+func (s *SpaceSaving) Elements() [][]byte {
+	estimates := s.Estimates()
+	elements := make([][]byte, len(estimates))
+	for i, estimate := range estimates {
+		elements[i] = estimate.Data
+	}
+	return elements
+}
+
+// Estimates returns the monitored elements with their estimated counts and
+// errors, from lowest to highest count. Each true count is between the count
+// minus the error and the count.
+func (s *SpaceSaving) Estimates() []TopKElement {
+	elements := make(spaceSavingHeap, len(s.elements))
+	for i, counter := range s.elements {
+		elements[i] = &spaceSavingCounter{data: counter.data, count: counter.count, err: counter.err}
+	}
+	heap.Init(&elements)
+
+	estimates := make([]TopKElement, 0, len(elements))
+	for elements.Len() > 0 {
+		counter := heap.Pop(&elements).(*spaceSavingCounter)
+		estimates = append(estimates, TopKElement{Data: counter.data, Count: counter.count, Error: counter.err})
+	}
+	return estimates
+}
+
+// Reset restores the SpaceSaving to its original state. It returns itself to
+// allow for chaining.
+func (s *SpaceSaving) Reset() *SpaceSaving {
+	s.counters = make(map[string]*spaceSavingCounter, s.k)
+	s.elements = s.elements[:0]
+	s.n = 0
+	return s
+}
+
+// Clone returns an independent copy of the SpaceSaving, which can be used
+// concurrently with the original.
+func (s *SpaceSaving) Clone() *SpaceSaving {
+	clone := &SpaceSaving{
+		counters: make(map[string]*spaceSavingCounter, s.k),
+		elements: make(spaceSavingHeap, len(s.elements), cap(s.elements)),
+		k:        s.k,
+		n:        s.n,
+	}
+	for i, counter := range s.elements {
+		c := *counter
+		c.data = append([]byte(nil), counter.data...)
+		clone.elements[i] = &c
+		clone.counters[string(c.data)] = &c
+	}
+	return clone
+}
+
+// ByteSize returns the number of bytes used by the monitored items and
+// metadata of the summary, excluding the overhead of the map of items.
+func (s *SpaceSaving) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*s)) + uint64(cap(s.elements))*pointerSize
+	for _, counter := range s.elements {
+		size += uint64(unsafe.Sizeof(*counter)) + uint64(cap(counter.data))
+	}
+	return size
+}
+
+// WriteTo writes a binary representation of the SpaceSaving to an I/O stream,
+// including the monitored items with their counts and errors. It returns the
+// number of bytes written.
+func (s *SpaceSaving) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(s.k))
+	e.write(s.n)
+	e.write(uint64(len(s.elements)))
+	for _, counter := range s.elements {
+		e.write(counter.count)
+		e.write(counter.err)
+		e.writeBytes(counter.data)
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a SpaceSaving (such as might have
+// been written by WriteTo()) from an I/O stream. The items are restored in the
+// order they were written, so adding to the summary afterwards updates it
+// exactly as if it had never been written. Returns ErrUnsupportedVersion if
+// the data was written by an incompatible version of the package. It returns
+// the number of bytes read.
+func (s *SpaceSaving) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d           = decoder{r: payload}
+		k, count, l uint64
+	)
+	d.read(&k)
+	d.read(&count)
+	d.read(&l)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if k == 0 || l > k {
+		return n, errors.New("invalid summary parameters")
+	}
+	// Every counter takes at least 24 bytes.
+	if l > uint64(payload.Len())/24 {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	var (
+		counters = make(map[string]*spaceSavingCounter, l)
+		elements = make(spaceSavingHeap, 0, l)
+	)
+	for i := uint64(0); i < l && d.err == nil; i++ {
+		counter := &spaceSavingCounter{index: int(i)}
+		d.read(&counter.count)
+		d.read(&counter.err)
+		counter.data = d.readBytes()
+		counters[string(counter.data)] = counter
+		elements = append(elements, counter)
+	}
+	if d.err != nil {
+		return n, d.err
+	}
+	if len(counters) != len(elements) {
+		return n, errors.New("duplicate items")
+	}
+	heap.Init(&elements)
+
+	s.counters = counters
+	s.elements = elements
+	s.k = uint(k)
+	s.n = count
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (s *SpaceSaving) MarshalBinary() ([]byte, error) {
+	return marshalBinary(s)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (s *SpaceSaving) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(s, data)
+}
@@ -0,0 +1,128 @@
+package boom
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+// Ensures that SpaceSaving monitors every item added more than n/k times, and
+// that each count is within its error of the true count.
+func TestSpaceSaving(t *testing.T) {
+	var (
+		s      = NewSpaceSaving(80)
+		counts = make(map[string]uint64)
+	)
+	for i := 0; i < 10000; i++ {
+		data := strconv.Itoa(i)
+		if i%10 == 0 {
+			data = "a"
+		} else if i%25 == 0 {
+			data = "b"
+		}
+		s.Add([]byte(data))
+		counts[data]++
+	}
+	if s.TotalCount() != 10000 || s.K() != 80 {
+		t.Fatalf("Expected 10000 and 80, got %d and %d", s.TotalCount(), s.K())
+	}
+
+	estimates := s.Estimates()
+	if len(estimates) != 80 {
+		t.Fatalf("Expected 80 estimates, got %d", len(estimates))
+	}
+	for i, e := range estimates {
+		if count := counts[string(e.Data)]; e.Count < count || e.Count-e.Error > count {
+			t.Errorf("Expected %s to be within %d of %d, got %d", e.Data, e.Error, count, e.Count)
+		}
+		if i > 0 && e.Count < estimates[i-1].Count {
+			t.Errorf("Expected ascending counts, got %d after %d", e.Count, estimates[i-1].Count)
+		}
+	}
+	if top := estimates[len(estimates)-1]; string(top.Data) != "a" {
+		t.Errorf("Expected a, got %s", top.Data)
+	}
+	if count, _ := s.Count([]byte(`a`)); count < 1000 {
+		t.Errorf("Expected at least 1000, got %d", count)
+	}
+	if count, _ := s.Count([]byte(`b`)); count < 200 {
+		t.Errorf("Expected b to be monitored, got %d", count)
+	}
+	if elements := s.Elements(); len(elements) != 80 || string(elements[79]) != "a" {
+		t.Errorf("Expected 80 elements ending in a, got %q", elements)
+	}
+
+	if s.Reset() != s || len(s.Elements()) != 0 || s.TotalCount() != 0 {
+		t.Error("Expected an empty summary")
+	}
+}
+
+// Ensures that a SpaceSaving read from its written representation continues
+// to update like one which was never written.
+func TestSpaceSavingWriteToReadFrom(t *testing.T) {
+	expected := NewSpaceSaving(3)
+	for i := 0; i < 100; i++ {
+		expected.Add([]byte(strconv.Itoa(i % 7)))
+	}
+
+	var buf bytes.Buffer
+	if _, err := expected.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var read SpaceSaving
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	clone := expected.Clone()
+	for i := 0; i < 50; i++ {
+		data := []byte(strconv.Itoa(i % 5))
+		expected.Add(data)
+		read.Add(data)
+		clone.Add(data)
+	}
+
+	want := expected.Estimates()
+	for _, actual := range [][]TopKElement{read.Estimates(), clone.Estimates()} {
+		for i, e := range actual {
+			if !bytes.Equal(e.Data, want[i].Data) || e.Count != want[i].Count || e.Error != want[i].Error {
+				t.Errorf("Expected %v, got %v", want[i], e)
+			}
+		}
+	}
+}
+
+// Ensures that ReadFrom allocates for the counters read rather than for k,
+// which is unchecked, and rejects more counters than the data can hold.
+func TestSpaceSavingReadFromLargeK(t *testing.T) {
+	var e encoder
+	e.write(uint64(1 << 60))
+	e.write(uint64(1))
+	e.write(uint64(1))
+	e.write(uint64(1))
+	e.write(uint64(0))
+	e.writeBytes([]byte(`a`))
+
+	var buf bytes.Buffer
+	if _, err := writeFrame(&buf, e.buf.Bytes()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	read := &SpaceSaving{}
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count, _ := read.Count([]byte(`a`)); cap(read.elements) != 1 || count != 1 {
+		t.Errorf("Expected capacity 1 and a count of 1, got %d and %d", cap(read.elements), count)
+	}
+
+	e = encoder{}
+	e.write(uint64(1 << 60))
+	e.write(uint64(1))
+	e.write(uint64(1 << 59))
+	buf.Reset()
+	if _, err := writeFrame(&buf, e.buf.Bytes()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := (&SpaceSaving{}).ReadFrom(&buf); err == nil {
+		t.Error("Expected error for more counters than the data holds")
+	}
+}
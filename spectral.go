@@ -0,0 +1,346 @@
+package boom
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"unsafe"
+)
+
+// SpectralBloomFilter implements a Spectral Bloom Filter as described by
+// Cohen and Matias in Spectral Bloom Filters:
+//
+// http://theory.stanford.edu/~matias/papers/sbf-sigmod-03.pdf
+//
+// A Spectral Bloom Filter extends a Counting Bloom Filter to estimate the
+// multiplicity of each item, the number of times it was added, rather than
+// only its membership. Like a Counting Bloom Filter, an item increments its k
+// counters, and its multiplicity is estimated by the smallest of them, the
+// minimum selection heuristic. That estimate is never too low, but it's too
+// high when each of the item's counters is shared with another item.
+//
+// A filter created by NewMinimalIncreaseSpectralBloomFilter instead uses the
+// minimal increase heuristic, which only increments the item's counters which
+// hold the smallest value, since the others already count other items. This
+// keeps counters much closer to the multiplicities they estimate, especially
+// when a few items are added very often, but it prevents removing items:
+// decrementing counters which weren't all incremented could make another
+// item's estimate too low.
+//
+// Counters saturate at the maximum value of their bits.
+type SpectralBloomFilter struct {
+	buckets         *Buckets    // counters
+	hash            hash.Hash64 // hash function (kernel for all k functions)
+	m               uint        // number of counters
+	k               uint        // number of hash functions
+	count           uint        // number of items added
+	minimalIncrease bool        // only increment the smallest counters
+	indexBuffer     []uint      // buffer used to cache indices
+	seed            uint64      // hash seed (zero means unseeded)
+	modulo          bool        // reduce hashes to indices with a modulo
+}
+
+// NewSpectralBloomFilter creates a new Spectral Bloom Filter optimized to
+// store n distinct items with a specified target false-positive rate, whose
+// counters have b bits, using the minimum selection heuristic.
+func NewSpectralBloomFilter(n uint, b uint8, fpRate float64, opts ...Option) *SpectralBloomFilter {
+	var (
+		m = OptimalM(n, fpRate)
+		k = OptimalK(fpRate)
+	)
+	o := applyOptions(opts)
+	return &SpectralBloomFilter{
+		buckets:     NewBuckets(m, b),
+		hash:        o.newHash64(newFNV64),
+		m:           m,
+		k:           k,
+		indexBuffer: make([]uint, k),
+		seed:        o.seed,
+		modulo:      o.modulo,
+	}
+}
+
+// NewMinimalIncreaseSpectralBloomFilter creates a new Spectral Bloom Filter
+// like NewSpectralBloomFilter using the minimal increase heuristic, which
+// gives better estimates but doesn't support removing items.
+func NewMinimalIncreaseSpectralBloomFilter(n uint, b uint8, fpRate float64, opts ...Option) *SpectralBloomFilter {
+	s := NewSpectralBloomFilter(n, b, fpRate, opts...)
+	s.minimalIncrease = true
+	return s
+}
+
+// Capacity returns the number of counters, m.
+func (s *SpectralBloomFilter) Capacity() uint {
+	return s.m
+}
+
+// K returns the number of hash functions.
+func (s *SpectralBloomFilter) K() uint {
+	return s.k
+}
+
+// Count returns the number of items added, counting each addition.
+func (s *SpectralBloomFilter) Count() uint {
+	return s.count
+}
+
+// MinimalIncrease returns true if the filter uses the minimal increase
+// heuristic, false if it uses minimum selection.
+func (s *SpectralBloomFilter) MinimalIncrease() bool {
+	return s.minimalIncrease
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. This is a probabilistic test, meaning there is a
+// non-zero probability of false positives.
+func (s *SpectralBloomFilter) Test(data []byte) bool {
+	return s.EstimatedCount(data) > 0
+}
+
+// EstimatedCount returns the estimated number of times the data has been added
+// to the filter, the minimum value of its k counters. The estimate may be too
+// high due to hash collisions, but it's never too low unless a counter has
+// saturated.
+func (s *SpectralBloomFilter) EstimatedCount(data []byte) uint {
+	s.indices(data)
+	return uint(s.minimum())
+}
+
+// Add will add the data to the filter, incrementing its estimated count. It
+// returns the filter to allow for chaining.
+func (s *SpectralBloomFilter) Add(data []byte) Filter {
+	s.indices(data)
+	s.increment(s.minimum())
+	return s
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data is a member, false if not.
+func (s *SpectralBloomFilter) TestAndAdd(data []byte) bool {
+	member, _ := s.TestAndAddCount(data)
+	return member
+}
+
+// TestAndAddCount is equivalent to calling Test followed by Add and then
+// EstimatedCount. It returns true if the data was a member before it was
+// added, false if not, along with its estimated count including this
+// addition.
+func (s *SpectralBloomFilter) TestAndAddCount(data []byte) (bool, uint) {
+	s.indices(data)
+	min := s.minimum()
+	s.increment(min)
+	return min > 0, uint(s.minimum())
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (s *SpectralBloomFilter) TestString(data string) bool {
+	return s.Test(stringBytes(data))
+}
+
+// EstimatedCountString is equivalent to EstimatedCount for a string, which is
+// hashed without being copied.
+func (s *SpectralBloomFilter) EstimatedCountString(data string) uint {
+	return s.EstimatedCount(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (s *SpectralBloomFilter) AddString(data string) Filter {
+	return s.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (s *SpectralBloomFilter) TestAndAddString(data string) bool {
+	return s.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter. It returns the filter to allow
+// for chaining.
+func (s *SpectralBloomFilter) AddMany(data [][]byte) Filter {
+	for _, d := range data {
+		s.Add(d)
+	}
+	return s
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+func (s *SpectralBloomFilter) TestMany(data [][]byte) []bool {
+	return testMany(data, s.Test)
+}
+
+// TestAndRemove will test for membership of the data and remove one addition
+// of it from the filter if it exists. Returns true if the data was a member,
+// false if not. A filter using minimal increase can't remove items, so it's
+// left unchanged and false is returned.
+func (s *SpectralBloomFilter) TestAndRemove(data []byte) bool {
+	if s.minimalIncrease {
+		return false
+	}
+
+	s.indices(data)
+	if s.minimum() == 0 {
+		return false
+	}
+	for _, idx := range s.indexBuffer {
+		s.buckets.Increment(idx, -1)
+	}
+	s.count--
+	return true
+}
+
+// Reset restores the filter to its original state. It returns the filter to
+// allow for chaining.
+func (s *SpectralBloomFilter) Reset() *SpectralBloomFilter {
+	s.buckets.Reset()
+	s.count = 0
+	return s
+}
+
+// indices stores the counters of the data in the index buffer.
+func (s *SpectralBloomFilter) indices(data []byte) {
+	lower, upper := sizedHashKernel(data, s.hash, s.seed, s.m)
+	for i := uint(0); i < s.k; i++ {
+		s.indexBuffer[i] = reducedIndex(lower, upper, i, s.m, s.modulo)
+	}
+}
+
+// minimum returns the smallest value of the counters in the index buffer.
+func (s *SpectralBloomFilter) minimum() uint32 {
+	min := s.buckets.MaxBucketValueWide()
+	for _, idx := range s.indexBuffer {
+		if val := s.buckets.Get(idx); val < min {
+			min = val
+		}
+	}
+	return min
+}
+
+// increment increments the counters in the index buffer, only those holding
+// the minimum value with minimal increase. A counter shared by two of the
+// indices is incremented once with minimal increase, which still raises the
+// minimum.
+func (s *SpectralBloomFilter) increment(min uint32) {
+	for _, idx := range s.indexBuffer {
+		if !s.minimalIncrease || s.buckets.Get(idx) == min {
+			s.buckets.Increment(idx, 1)
+		}
+	}
+	s.count++
+}
+
+// WriteTo writes a binary representation of the SpectralBloomFilter to an I/O
+// stream. The hash function is not written, but the seed is. It returns the
+// number of bytes written.
+func (s *SpectralBloomFilter) WriteTo(stream io.Writer) (int64, error) {
+	var (
+		e               encoder
+		minimalIncrease uint8
+	)
+	if s.minimalIncrease {
+		minimalIncrease = 1
+	}
+	e.write(uint64(s.m))
+	e.write(uint64(s.k))
+	e.write(uint64(s.count))
+	e.write(minimalIncrease)
+	e.write(s.seed)
+	e.writeTo(s.buckets)
+	writeReduction(&e, s.modulo)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a SpectralBloomFilter (such as
+// might have been written by WriteTo()) from an I/O stream. The filter keeps
+// its current hash function, which must match the one used by the filter that
+// was written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (s *SpectralBloomFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                 = decoder{r: payload}
+		m, k, count, seed uint64
+		minimalIncrease   uint8
+		buckets           = &Buckets{}
+	)
+	d.read(&m)
+	d.read(&k)
+	d.read(&count)
+	d.read(&minimalIncrease)
+	d.read(&seed)
+	d.readFrom(buckets)
+	modulo, err := readReduction(&d, payload)
+	if err != nil {
+		return n, err
+	}
+
+	if buckets.Count() != uint(m) || k > m || k == 0 || minimalIncrease > 1 {
+		return n, errors.New("counters don't match filter")
+	}
+	if s.hash == nil {
+		s.hash = newFNV64()
+	}
+
+	s.buckets = buckets
+	s.m = uint(m)
+	s.k = uint(k)
+	s.count = uint(count)
+	s.minimalIncrease = minimalIncrease == 1
+	s.indexBuffer = make([]uint, k)
+	s.seed = seed
+	s.modulo = modulo
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (s *SpectralBloomFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(s)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo. If the filter
+// has no hash function, such as when it's the zero value, the default hash
+// function is used.
+func (s *SpectralBloomFilter) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(s, data)
+}
+
+// Equal returns true if the other Spectral Bloom Filter has the same
+// parameters, heuristic, hash seed, reduction of hashes to indices, number of
+// items, and counter values. The hash functions aren't compared.
+func (s *SpectralBloomFilter) Equal(other *SpectralBloomFilter) bool {
+	return s.m == other.m && s.k == other.k && s.count == other.count &&
+		s.minimalIncrease == other.minimalIncrease && s.seed == other.seed &&
+		s.modulo == other.modulo && s.buckets.Equal(other.buckets)
+}
+
+// Clone returns an independent copy of the Spectral Bloom Filter, which can be
+// used concurrently with the original.
+func (s *SpectralBloomFilter) Clone() *SpectralBloomFilter {
+	clone := *s
+	clone.buckets = s.buckets.Clone()
+	clone.hash = cloneHash64(s.hash)
+	clone.indexBuffer = make([]uint, len(s.indexBuffer))
+	return &clone
+}
+
+// ByteSize returns the number of bytes used by the counters and metadata of
+// the Spectral Bloom Filter, excluding the hash function.
+func (s *SpectralBloomFilter) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*s)) + s.buckets.ByteSize() + uint64(cap(s.indexBuffer))*uintSize
+}
+
+// SetHash sets the hashing function used in the filter.
+func (s *SpectralBloomFilter) SetHash(h hash.Hash64) {
+	s.hash = h
+}
@@ -0,0 +1,117 @@
+package boom
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+// Ensures that both heuristics never underestimate the counts of a skewed
+// stream, and that minimal increase overestimates less than minimum selection.
+func TestSpectralEstimatedCount(t *testing.T) {
+	var (
+		ms     = NewSpectralBloomFilter(1000, 16, 0.1)
+		mi     = NewMinimalIncreaseSpectralBloomFilter(1000, 16, 0.1)
+		counts = make(map[string]uint)
+	)
+	for i := 0; i < 1000; i++ {
+		// Item i is added about 1000/(i+1) times.
+		key := strconv.Itoa(i)
+		for j := 0; j < 1000/(i+1); j++ {
+			ms.AddString(key)
+			mi.AddString(key)
+			counts[key]++
+		}
+	}
+
+	var msError, miError uint
+	for key, count := range counts {
+		msCount, miCount := ms.EstimatedCountString(key), mi.EstimatedCountString(key)
+		if msCount < count || miCount < count {
+			t.Fatalf("Expected counts of at least %d for %s, got %d and %d", count, key, msCount, miCount)
+		}
+		msError += msCount - count
+		miError += miCount - count
+	}
+	if miError >= msError {
+		t.Errorf("Expected minimal increase error below %d, got %d", msError, miError)
+	}
+	if ms.Count() != mi.Count() || !mi.MinimalIncrease() || ms.MinimalIncrease() {
+		t.Errorf("Expected %d additions with each heuristic, got %d", ms.Count(), mi.Count())
+	}
+	if ms.TestString("1000000") && mi.TestString("1000000") {
+		t.Error("Expected 1000000 not to be a member")
+	}
+}
+
+// Ensures that TestAndAddCount returns membership before the addition and
+// the count after it.
+func TestSpectralTestAndAddCount(t *testing.T) {
+	s := NewSpectralBloomFilter(100, 8, 0.01)
+	if member, count := s.TestAndAddCount([]byte(`a`)); member || count != 1 {
+		t.Errorf("Expected false and 1, got %t and %d", member, count)
+	}
+	if member, count := s.TestAndAddCount([]byte(`a`)); !member || count != 2 {
+		t.Errorf("Expected true and 2, got %t and %d", member, count)
+	}
+}
+
+// Ensures that TestAndRemove removes one addition with minimum selection and
+// is refused with minimal increase.
+func TestSpectralTestAndRemove(t *testing.T) {
+	s := NewSpectralBloomFilter(100, 8, 0.01)
+	s.Add([]byte(`a`)).Add([]byte(`a`))
+	if !s.TestAndRemove([]byte(`a`)) || s.EstimatedCount([]byte(`a`)) != 1 || s.Count() != 1 {
+		t.Errorf("Expected a count of 1 after removal, got %d", s.EstimatedCount([]byte(`a`)))
+	}
+	if !s.TestAndRemove([]byte(`a`)) || s.TestAndRemove([]byte(`a`)) {
+		t.Error("Expected a single remaining addition to remove")
+	}
+
+	mi := NewMinimalIncreaseSpectralBloomFilter(100, 8, 0.01)
+	mi.Add([]byte(`a`))
+	if mi.TestAndRemove([]byte(`a`)) || !mi.Test([]byte(`a`)) {
+		t.Error("Expected minimal increase filter to refuse removal")
+	}
+}
+
+// Ensures that a Spectral Bloom Filter read by ReadFrom equals the one written
+// by WriteTo.
+func TestSpectralWriteToReadFrom(t *testing.T) {
+	s := NewMinimalIncreaseSpectralBloomFilter(100, 4, 0.01, WithSeed(5))
+	for i := 0; i < 50; i++ {
+		s.AddString(strconv.Itoa(i % 7))
+	}
+
+	var buf bytes.Buffer
+	if _, err := s.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restored := &SpectralBloomFilter{}
+	if _, err := restored.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !restored.Equal(s) || restored.EstimatedCountString("3") != s.EstimatedCountString("3") {
+		t.Error("Expected restored filter to equal original")
+	}
+
+	clone := s.Clone()
+	clone.AddString("3")
+	if clone.Equal(s) {
+		t.Error("Expected clone to be independent")
+	}
+}
+
+func BenchmarkSpectralAdd(b *testing.B) {
+	b.StopTimer()
+	f := NewMinimalIncreaseSpectralBloomFilter(100000, 8, 0.1)
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i % 1000))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.Add(data[n])
+	}
+}
@@ -1,10 +1,14 @@
 package boom
 
 import (
+	"context"
+	"errors"
 	"hash"
-	"hash/fnv"
+	"io"
 	"math"
+	"math/bits"
 	"math/rand"
+	"unsafe"
 )
 
 // StableBloomFilter implements a Stable Bloom Filter as described by Deng and
@@ -38,29 +42,35 @@ type StableBloomFilter struct {
 	k           uint        // number of hash functions
 	max         uint8       // cell max value
 	indexBuffer []uint      // buffer used to cache indices
+	rand        *rand.Rand  // source of randomness for cell decrements
+	source      *splitMix64 // rand source unless one was provided to SetRand
+	seed        uint64      // hash seed (zero means unseeded)
+	modulo      bool        // reduce hashes to indices with a modulo
 }
 
 // NewStableBloomFilter creates a new Stable Bloom Filter with m cells and d
 // bits allocated per cell optimized for the target false-positive rate. Use
 // NewDefaultStableFilter if you don't want to calculate d.
-func NewStableBloomFilter(m uint, d uint8, fpRate float64) *StableBloomFilter {
-	k := OptimalK(fpRate) / 2
-	if k > m {
-		k = m
-	} else if k <= 0 {
-		k = 1
-	}
-
-	cells := NewBuckets(m, d)
+func NewStableBloomFilter(m uint, d uint8, fpRate float64, opts ...Option) *StableBloomFilter {
+	var (
+		k      = optimalStableK(m, fpRate)
+		cells  = NewBuckets(m, d)
+		o      = applyOptions(opts)
+		source = o.source()
+	)
 
 	return &StableBloomFilter{
-		hash:        fnv.New64(),
+		hash:        o.newHash64(newFNV64),
 		m:           m,
 		k:           k,
 		p:           optimalStableP(m, k, d, fpRate),
 		max:         cells.MaxBucketValue(),
 		cells:       cells,
 		indexBuffer: make([]uint, k),
+		rand:        rand.New(source),
+		source:      source,
+		seed:        o.seed,
+		modulo:      o.modulo,
 	}
 }
 
@@ -68,28 +78,67 @@ func NewStableBloomFilter(m uint, d uint8, fpRate float64) *StableBloomFilter {
 // cells and which is optimized for cases where there is no prior knowledge of
 // the input data stream while maintaining an upper bound using the provided
 // rate of false positives.
-func NewDefaultStableBloomFilter(m uint, fpRate float64) *StableBloomFilter {
-	return NewStableBloomFilter(m, 1, fpRate)
+func NewDefaultStableBloomFilter(m uint, fpRate float64, opts ...Option) *StableBloomFilter {
+	return NewStableBloomFilter(m, 1, fpRate, opts...)
+}
+
+// NewTunedStableBloomFilter creates a new Stable Bloom Filter with the
+// fewest bits for which an element added gap additions before it's tested,
+// such as the expected stream rate times the period duplicates must be
+// detected within, is expected to be reported as absent at most at the target
+// false-negative rate once the filter is stable, along with the target
+// false-positive rate. The number of bits per cell, d, between 1 and 8, the
+// number of cells, m, and the number of cells decremented per addition, P,
+// are derived from the analysis of Deng and Rafiei. Returns an error if a rate
+// isn't between 0 and 1, or gap is zero.
+func NewTunedStableBloomFilter(gap uint, fpRate, fnRate float64, opts ...Option) (*StableBloomFilter, error) {
+	if fpRate <= 0 || fpRate >= 1 || fnRate <= 0 || fnRate >= 1 {
+		return nil, errors.New("rates must be between 0 and 1")
+	}
+	if gap == 0 {
+		return nil, errors.New("gap must be positive")
+	}
+
+	var (
+		bestM uint
+		bestD uint8
+	)
+	for d := uint8(1); d <= 8; d++ {
+		m, ok := tuneStableCells(gap, d, fpRate, fnRate)
+		if ok && (bestM == 0 || m*uint(d) < bestM*uint(bestD)) {
+			bestM, bestD = m, d
+		}
+	}
+	if bestM == 0 {
+		return nil, errors.New("no filter reaches the false-negative rate")
+	}
+	return NewStableBloomFilter(bestM, bestD, fpRate, opts...), nil
 }
 
 // NewUnstableBloomFilter creates a new special case of Stable Bloom Filter
 // which is a traditional Bloom filter with m bits and an optimal number of
 // hash functions for the target false-positive rate. Unlike the stable
 // variant, data is not evicted and a cell contains a maximum of 1 hash value.
-func NewUnstableBloomFilter(m uint, fpRate float64) *StableBloomFilter {
+func NewUnstableBloomFilter(m uint, fpRate float64, opts ...Option) *StableBloomFilter {
 	var (
-		cells = NewBuckets(m, 1)
-		k     = OptimalK(fpRate)
+		cells  = NewBuckets(m, 1)
+		k      = OptimalK(fpRate)
+		o      = applyOptions(opts)
+		source = o.source()
 	)
 
 	return &StableBloomFilter{
-		hash:        fnv.New64(),
+		hash:        o.newHash64(newFNV64),
 		m:           m,
 		k:           k,
 		p:           0,
 		max:         cells.MaxBucketValue(),
 		cells:       cells,
 		indexBuffer: make([]uint, k),
+		rand:        rand.New(source),
+		source:      source,
+		seed:        o.seed,
+		modulo:      o.modulo,
 	}
 }
 
@@ -108,6 +157,20 @@ func (s *StableBloomFilter) P() uint {
 	return s.p
 }
 
+// D returns the number of bits per cell.
+func (s *StableBloomFilter) D() uint8 {
+	return uint8(bits.Len8(s.max))
+}
+
+// FalseNegativeRate returns the expected probability that an element added gap
+// additions before it's tested is reported as absent once the filter has
+// become stable, which can be compared with the stream's duplicate window to
+// check the filter forgets at the intended rate. Filters which don't evict
+// data have no false negatives.
+func (s *StableBloomFilter) FalseNegativeRate(gap uint) float64 {
+	return stableFalseNegativeRate(s.m, s.k, s.p, s.max, gap)
+}
+
 // StablePoint returns the limit of the expected fraction of zeros in the
 // Stable Bloom Filter when the number of iterations goes to infinity. When
 // this limit is reached, the Stable Bloom Filter is considered stable.
@@ -127,15 +190,52 @@ func (s *StableBloomFilter) FalsePositiveRate() float64 {
 	return math.Pow(1-s.StablePoint(), float64(s.k))
 }
 
+// ZeroRatio returns the current fraction of zero cells, an estimate of the
+// stable point which converges to StablePoint as data is added.
+func (s *StableBloomFilter) ZeroRatio() float64 {
+	zeros, _ := s.cellCounts()
+	return float64(zeros) / float64(s.m)
+}
+
+// MaxRatio returns the current fraction of cells at the max value, those set
+// by an addition and not decremented since. As each cell is decremented with
+// probability P/m per addition, they're mostly cells of the last m/P
+// additions.
+func (s *StableBloomFilter) MaxRatio() float64 {
+	_, maxed := s.cellCounts()
+	return float64(maxed) / float64(s.m)
+}
+
+// EstimatedFalsePositiveRate returns the false-positive rate estimated from
+// the current fraction of zero cells, which converges to FalsePositiveRate as
+// the filter becomes stable.
+func (s *StableBloomFilter) EstimatedFalsePositiveRate() float64 {
+	return math.Pow(1-s.ZeroRatio(), float64(s.k))
+}
+
+// cellCounts returns the number of zero cells and of cells at the max value.
+func (s *StableBloomFilter) cellCounts() (uint, uint) {
+	var zeros, maxed uint
+	for i := uint(0); i < s.m; i++ {
+		switch s.cells.Get(i) {
+		case 0:
+			zeros++
+		case uint32(s.max):
+			maxed++
+		}
+	}
+	return zeros, maxed
+}
+
 // Test will test for membership of the data and returns true if it is a
 // member, false if not. This is a probabilistic test, meaning there is a
 // non-zero probability of false positives and false negatives.
 func (s *StableBloomFilter) Test(data []byte) bool {
-	lower, upper := hashKernel(data, s.hash)
+	lower, upper := sizedHashKernel(data, s.hash, s.seed, s.m)
 
 	// If any of the K cells are 0, then it's not a member.
 	for i := uint(0); i < s.k; i++ {
-		if s.cells.Get((uint(lower)+uint(upper)*i)%s.m) == 0 {
+		if s.cells.Get(reducedIndex(lower, upper, i, s.m, s.modulo)) == 0 {
 			return false
 		}
 	}
@@ -149,11 +249,11 @@ func (s *StableBloomFilter) Add(data []byte) Filter {
 	// Randomly decrement p cells to make room for new elements.
 	s.decrement()
 
-	lower, upper := hashKernel(data, s.hash)
+	lower, upper := sizedHashKernel(data, s.hash, s.seed, s.m)
 
 	// Set the K cells to max.
 	for i := uint(0); i < s.k; i++ {
-		s.cells.Set((uint(lower)+uint(upper)*i)%s.m, s.max)
+		s.cells.Set(reducedIndex(lower, upper, i, s.m, s.modulo), s.max)
 	}
 
 	return s
@@ -162,12 +262,12 @@ func (s *StableBloomFilter) Add(data []byte) Filter {
 // TestAndAdd is equivalent to calling Test followed by Add. It returns true if
 // the data is a member, false if not.
 func (s *StableBloomFilter) TestAndAdd(data []byte) bool {
-	lower, upper := hashKernel(data, s.hash)
+	lower, upper := sizedHashKernel(data, s.hash, s.seed, s.m)
 	member := true
 
 	// If any of the K cells are 0, then it's not a member.
 	for i := uint(0); i < s.k; i++ {
-		s.indexBuffer[i] = (uint(lower) + uint(upper)*i) % s.m
+		s.indexBuffer[i] = reducedIndex(lower, upper, i, s.m, s.modulo)
 		if s.cells.Get(s.indexBuffer[i]) == 0 {
 			member = false
 		}
@@ -184,6 +284,78 @@ func (s *StableBloomFilter) TestAndAdd(data []byte) bool {
 	return member
 }
 
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (s *StableBloomFilter) TestString(data string) bool {
+	return s.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (s *StableBloomFilter) AddString(data string) Filter {
+	return s.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (s *StableBloomFilter) TestAndAddString(data string) bool {
+	return s.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter. It returns the filter to allow
+// for chaining.
+func (s *StableBloomFilter) AddMany(data [][]byte) Filter {
+	for _, d := range data {
+		s.Add(d)
+	}
+	return s
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+func (s *StableBloomFilter) TestMany(data [][]byte) []bool {
+	return testMany(data, s.Test)
+}
+
+// AddAllContext adds each item of data to the filter, checking the context for
+// cancellation periodically. It returns the number of items added, which are
+// the first ones in data, and the context's error if it was cancelled before
+// every item was added.
+func (s *StableBloomFilter) AddAllContext(ctx context.Context, data [][]byte) (int, error) {
+	return addAllContext(ctx, data, func(d []byte) error {
+		s.Add(d)
+		return nil
+	})
+}
+
+// WarmUp sets every cell to a value drawn from the distribution of cell values
+// expected once the filter has become stable, so that its false-positive rate
+// matches FalsePositiveRate immediately rather than after streaming many
+// elements through it. Previously added data is lost. Cell values are
+// generated using the source provided to SetRand, or the default source if
+// none was set. Filters which don't evict data, such as those created with
+// NewUnstableBloomFilter, have no stable point and are left unchanged. It
+// returns the filter to allow for chaining.
+func (s *StableBloomFilter) WarmUp() *StableBloomFilter {
+	if s.p == 0 {
+		return s
+	}
+
+	// A cell holds the value max - j when it has been decremented j times
+	// since it was last set, where each subsequent event is a decrement with
+	// probability base. The fraction of zeros is then base^max, the stable
+	// point.
+	base := math.Pow(s.StablePoint(), 1/float64(s.max))
+	for i := uint(0); i < s.m; i++ {
+		val := s.max
+		for val > 0 && s.float64() < base {
+			val--
+		}
+		s.cells.Set(i, val)
+	}
+
+	return s
+}
+
 // Reset restores the Stable Bloom Filter to its original state. It returns the
 // filter to allow for chaining.
 func (s *StableBloomFilter) Reset() *StableBloomFilter {
@@ -196,32 +368,294 @@ func (s *StableBloomFilter) Reset() *StableBloomFilter {
 // picking the p cells are not independent, each cell has a probability of p/m
 // for being picked at each iteration, which means the properties still hold.
 func (s *StableBloomFilter) decrement() {
-	r := rand.Intn(int(s.m))
+	r := s.intn(int(s.m))
 	for i := uint(0); i < s.p; i++ {
 		idx := (r + int(i)) % int(s.m)
 		s.cells.Increment(uint(idx), -1)
 	}
 }
 
+// intn returns a random number in [0, n) from the filter's source of
+// randomness, falling back to the default source if there is none.
+func (s *StableBloomFilter) intn(n int) int {
+	if s.rand != nil {
+		return s.rand.Intn(n)
+	}
+	return rand.Intn(n)
+}
+
+// float64 returns a random number in [0.0, 1.0) from the filter's source of
+// randomness, falling back to the default source if there is none.
+func (s *StableBloomFilter) float64() float64 {
+	if s.rand != nil {
+		return s.rand.Float64()
+	}
+	return rand.Float64()
+}
+
+// Equal returns true if the other Stable Bloom Filter has the same parameters,
+// hash seed, reduction of hashes to indices, and cell values. Neither the hash
+// functions nor the sources of randomness for cell decrements are compared.
+func (s *StableBloomFilter) Equal(other *StableBloomFilter) bool {
+	return s.m == other.m && s.p == other.p && s.k == other.k && s.max == other.max &&
+		s.seed == other.seed && s.modulo == other.modulo && s.cells.Equal(other.cells)
+}
+
+// Clone returns an independent copy of the Stable Bloom Filter, which can be
+// used concurrently with the original. The copy's source of randomness
+// continues from the state of the original's, or is seeded from the one passed
+// to SetRand.
+func (s *StableBloomFilter) Clone() *StableBloomFilter {
+	c := *s
+	c.cells = s.cells.Clone()
+	c.hash = cloneHash64(s.hash)
+	c.indexBuffer = make([]uint, len(s.indexBuffer))
+	if s.source != nil && s.rand != nil {
+		source := *s.source
+		c.source = &source
+		c.rand = rand.New(c.source)
+	} else {
+		c.rand = cloneRand(s.rand)
+	}
+	return &c
+}
+
+// ByteSize returns the number of bytes used by the cells and metadata of the
+// Stable Bloom Filter. The hash function and source of randomness aren't
+// included.
+func (s *StableBloomFilter) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*s)) + s.cells.ByteSize() + uint64(cap(s.indexBuffer))*uintSize
+}
+
 // SetHash sets the hashing function used in the filter.
 // For the effect on false positive rates see: https://github.com/tylertreat/BoomFilters/pull/1
 func (s *StableBloomFilter) SetHash(h hash.Hash64) {
 	s.hash = h
 }
 
+// SetRand sets the source of randomness used to pick the cells decremented on
+// every add.
+func (s *StableBloomFilter) SetRand(r *rand.Rand) {
+	s.rand = r
+	s.source = nil
+}
+
+// RandState returns a snapshot of the state of the filter's source of
+// randomness which can be passed to RestoreRandState to reproduce the exact
+// sequence of cells decremented from this point on. Returns nil if the source
+// was provided to SetRand, since its state can't be captured.
+func (s *StableBloomFilter) RandState() []byte {
+	if s.source == nil {
+		return nil
+	}
+	state, _ := s.source.MarshalBinary()
+	return state
+}
+
+// RestoreRandState restores the state of the filter's source of randomness
+// from a snapshot returned by RandState, replacing any source provided to
+// SetRand. Returns an error if the snapshot is invalid.
+func (s *StableBloomFilter) RestoreRandState(state []byte) error {
+	source := &splitMix64{}
+	if err := source.UnmarshalBinary(state); err != nil {
+		return err
+	}
+	s.source = source
+	s.rand = rand.New(source)
+	return nil
+}
+
+// WriteTo writes a binary representation of the StableBloomFilter to an I/O
+// stream, including the state of its source of randomness unless one was
+// provided to SetRand. The hash function is not written, but the seed is. It
+// returns the number of bytes written.
+func (s *StableBloomFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(s.m))
+	e.write(uint64(s.k))
+	e.write(uint64(s.p))
+	e.writeBytes(s.RandState())
+	e.writeTo(s.cells)
+	e.write(s.seed)
+	writeReduction(&e, s.modulo)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a StableBloomFilter (such as might
+// have been written by WriteTo()) from an I/O stream. The filter keeps its
+// current hash function, which must match the one used by the filter that was
+// written. If the state of the source of randomness was written, it's
+// restored, otherwise the filter keeps its current source. Returns
+// ErrUnsupportedVersion if the data was written by an incompatible version of
+// the package. It returns the number of bytes read.
+func (s *StableBloomFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d       = decoder{r: payload}
+		m, k, p uint64
+		cells   = &Buckets{}
+	)
+	d.read(&m)
+	d.read(&k)
+	d.read(&p)
+	state := d.readBytes()
+	d.readFrom(cells)
+	var seed uint64
+	if payload.Len() > 0 {
+		// Written by format version 2.3 or later.
+		d.read(&seed)
+	}
+	modulo, err := readReduction(&d, payload)
+	if err != nil {
+		return n, err
+	}
+
+	if cells.Count() != uint(m) || k > m || p > m {
+		return n, errors.New("cells don't match filter size")
+	}
+
+	if len(state) > 0 {
+		if err := s.RestoreRandState(state); err != nil {
+			return n, err
+		}
+	}
+
+	s.cells = cells
+	s.m = uint(m)
+	s.k = uint(k)
+	s.p = uint(p)
+	s.max = cells.MaxBucketValue()
+	s.indexBuffer = make([]uint, k)
+	s.seed = seed
+	s.modulo = modulo
+	return n, nil
+}
+
 // optimalStableP returns the optimal number of cells to decrement, p, per
-// iteration for the provided parameters of an SBF.
+// iteration for the provided parameters of an SBF, between 1 and m. The
+// formula has no solution once there are as many hash functions as cells, in
+// which case every cell is decremented.
 func optimalStableP(m, k uint, d uint8, fpRate float64) uint {
+	// Check the float before converting it, which can't represent +Inf or
+	// negative values.
+	p, ok := stableP(m, k, d, fpRate)
+	if !ok {
+		return m
+	}
+	if p < 1 {
+		return 1
+	}
+	return uint(p)
+}
+
+// stableP returns the optimal number of cells to decrement per iteration of
+// an SBF, and false if the formula has no solution of at most m cells.
+func stableP(m, k uint, d uint8, fpRate float64) (float64, bool) {
 	var (
 		max      = math.Pow(2, float64(d)) - 1
 		subDenom = math.Pow(1-math.Pow(fpRate, 1/float64(k)), 1/max)
 		denom    = (1/subDenom - 1) * (1/float64(k) - 1/float64(m))
 	)
 
-	p := uint(1 / denom)
-	if p <= 0 {
-		p = 1
+	p := 1 / denom
+	return p, k < m && denom > 0 && p <= float64(m)
+}
+
+// optimalStableK returns the number of hash functions of a Stable Bloom Filter
+// with m cells for the target false-positive rate.
+func optimalStableK(m uint, fpRate float64) uint {
+	k := OptimalK(fpRate) / 2
+	if k > m {
+		k = m
+	} else if k <= 0 {
+		k = 1
+	}
+	return k
+}
+
+// tuneStableCells returns the fewest cells of d bits for which the
+// false-negative rate after gap additions is at most fnRate, and false if
+// there are none below 2^48 bits.
+func tuneStableCells(gap uint, d uint8, fpRate, fnRate float64) (uint, bool) {
+	var (
+		max      = uint8(1<<d - 1)
+		fnRateOf = func(m uint) float64 {
+			// Filters too small for the formula of p never reach the
+			// false-positive rate, so they're rejected.
+			k := optimalStableK(m, fpRate)
+			if _, ok := stableP(m, k, d, fpRate); !ok {
+				return 1
+			}
+			return stableFalseNegativeRate(m, k, optimalStableP(m, k, d, fpRate), max, gap)
+		}
+		lo, hi uint = 1, 2
+	)
+
+	// The false-negative rate decreases as cells are added.
+	for fnRateOf(hi) > fnRate {
+		if uint64(hi)*uint64(d) >= 1<<48 {
+			return 0, false
+		}
+		lo, hi = hi, hi*2
+	}
+	for lo+1 < hi {
+		if mid := lo + (hi-lo)/2; fnRateOf(mid) > fnRate {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return hi, true
+}
+
+// stableFalseNegativeRate returns the probability that an element added gap
+// additions ago is reported as absent by a stable filter with m cells, k hash
+// functions, p decrements per addition, and a cell max value, following the
+// analysis of Deng and Rafiei. Each of the element's cells is decremented p/m
+// times and set to max by another element with probability about k/m per
+// addition, and is zero if it has been decremented max times since it was last
+// set. Treating both as Poisson processes, looking back from the test, the
+// first max events must all be decrements, and must happen within the gap.
+func stableFalseNegativeRate(m, k, p uint, max uint8, gap uint) float64 {
+	if p == 0 || max == 0 || gap < uint(max) {
+		return 0
+	}
+
+	var (
+		q      = float64(p) / float64(m)
+		r      = 1 - math.Pow(1-1/float64(m), float64(k))
+		lambda = (q + r) * float64(gap)
+		need   = int(max)
+		within float64 // probability of at least max events within the gap
+	)
+	poisson := func(j int) float64 {
+		lg, _ := math.Lgamma(float64(j + 1))
+		return math.Exp(-lambda + float64(j)*math.Log(lambda) - lg)
+	}
+	if lambda < float64(need) {
+		// The terms of the upper tail decrease from the first.
+		for j := need; ; j++ {
+			term := poisson(j)
+			within += term
+			if term <= within*1e-17 {
+				break
+			}
+		}
+	} else {
+		within = 1
+		for j := 0; j < need; j++ {
+			within -= poisson(j)
+		}
 	}
 
-	return p
+	zero := math.Pow(q/(q+r), float64(need)) * math.Max(0, within)
+	return 1 - math.Pow(1-zero, float64(k))
 }
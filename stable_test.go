@@ -1,7 +1,10 @@
 package boom
 
 import (
+	"bytes"
 	"math"
+	"math/rand"
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -13,7 +16,7 @@ func TestNewUnstableBloomFilter(t *testing.T) {
 	k := OptimalK(0.1)
 
 	if f.k != k {
-		t.Errorf("Expected %f, got %d", k, f.k)
+		t.Errorf("Expected %d, got %d", k, f.k)
 	}
 
 	if f.m != 100 {
@@ -132,6 +135,109 @@ func TestStablePoint(t *testing.T) {
 	}
 }
 
+// Ensures that a filter whose cells are decremented by a source set with
+// SetRand reaches the same stable point, and decrements the same cells as
+// another filter using a source with the same seed.
+func TestStablePointSetRand(t *testing.T) {
+	f := NewStableBloomFilter(1000, 1, 0.1)
+	f.SetRand(rand.New(rand.NewSource(1)))
+	other := NewStableBloomFilter(1000, 1, 0.1)
+	other.SetRand(rand.New(rand.NewSource(1)))
+	for i := 0; i < 1000000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+		other.Add([]byte(strconv.Itoa(i)))
+	}
+
+	zeros := 0
+	for i := uint(0); i < f.m; i++ {
+		if f.cells.Get(i) == 0 {
+			zeros++
+		}
+		if f.cells.Get(i) != other.cells.Get(i) {
+			t.Fatalf("Expected cell %d to be %d, got %d", i, f.cells.Get(i), other.cells.Get(i))
+		}
+	}
+
+	actual := round(float64(zeros)/float64(f.m), 0.5, 1)
+	expected := round(f.StablePoint(), 0.5, 1)
+	if actual != expected {
+		t.Errorf("Expected stable point %f, got %f", expected, actual)
+	}
+}
+
+// Ensures that WarmUp sets the cells to the distribution expected at the
+// stable point.
+func TestWarmUp(t *testing.T) {
+	f := NewStableBloomFilter(10000, 3, 0.01)
+	f.SetRand(rand.New(rand.NewSource(1)))
+
+	if f.WarmUp() != f {
+		t.Error("Returned StableBloomFilter should be the same instance")
+	}
+
+	zeros := 0
+	for i := uint(0); i < f.m; i++ {
+		if f.cells.Get(i) == 0 {
+			zeros++
+		}
+	}
+
+	if actual, expected := float64(zeros)/float64(f.m), f.StablePoint(); math.Abs(actual-expected) > 0.02 {
+		t.Errorf("Expected zero fraction %f, got %f", expected, actual)
+	}
+
+	// Unstable filters have no stable point.
+	bf := NewUnstableBloomFilter(1000, 0.1)
+	bf.WarmUp()
+	for i := uint(0); i < bf.m; i++ {
+		if cell := bf.cells.Get(i); cell != 0 {
+			t.Fatalf("Expected zero cell, got %d", cell)
+		}
+	}
+}
+
+// Ensures that restoring the rand state and replaying the same adds
+// reproduces identical cell contents.
+func TestRandState(t *testing.T) {
+	f := NewStableBloomFilter(1000, 3, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	state := f.RandState()
+	if state == nil {
+		t.Fatal("Expected rand state")
+	}
+
+	replay := NewStableBloomFilter(1000, 3, 0.01)
+	if err := replay.RestoreRandState(state); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := uint(0); i < f.m; i++ {
+		replay.cells.Set(i, uint8(f.cells.Get(i)))
+	}
+
+	for i := 1000; i < 2000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+		replay.Add([]byte(strconv.Itoa(i)))
+	}
+
+	for i := uint(0); i < f.m; i++ {
+		if f.cells.Get(i) != replay.cells.Get(i) {
+			t.Fatalf("Expected cell %d to be %d, got %d", i, f.cells.Get(i), replay.cells.Get(i))
+		}
+	}
+
+	if err := replay.RestoreRandState([]byte{1, 2}); err == nil {
+		t.Error("Expected error for invalid state")
+	}
+
+	replay.SetRand(rand.New(rand.NewSource(1)))
+	if state := replay.RandState(); state != nil {
+		t.Errorf("Expected nil state, got %v", state)
+	}
+}
+
 // Ensures that FalsePositiveRate returns the upper bound on false positives
 // for stable filters.
 func TestFalsePositiveRate(t *testing.T) {
@@ -195,6 +301,20 @@ func BenchmarkStableTest(b *testing.B) {
 	}
 }
 
+func BenchmarkStableTestModulo(b *testing.B) {
+	b.StopTimer()
+	f := NewDefaultStableBloomFilter(100000, 0.01, WithModuloReduction())
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.Test(data[n])
+	}
+}
+
 func BenchmarkStableTestAndAdd(b *testing.B) {
 	b.StopTimer()
 	f := NewDefaultStableBloomFilter(100000, 0.01)
@@ -263,3 +383,160 @@ func round(val float64, roundOn float64, places int) (newVal float64) {
 	newVal = round / pow
 	return
 }
+
+// Ensures that a StableBloomFilter read from its written representation has
+// the same cells and decrements the same cells as the original afterwards.
+func TestStableBloomWriteToReadFrom(t *testing.T) {
+	f := NewStableBloomFilter(1000, 2, 0.01)
+	for i := 0; i < 500; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	restored := NewDefaultStableBloomFilter(10, 0.1)
+	var buf bytes.Buffer
+	wn, err := f.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	rn, err := restored.ReadFrom(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if wn != rn {
+		t.Errorf("Expected %d bytes read, got %d", wn, rn)
+	}
+
+	if restored.Cells() != f.Cells() || restored.K() != f.K() || restored.P() != f.P() {
+		t.Errorf("Expected %d, %d, %d, got %d, %d, %d", f.Cells(), f.K(), f.P(),
+			restored.Cells(), restored.K(), restored.P())
+	}
+
+	if !bytes.Equal(restored.RandState(), f.RandState()) {
+		t.Error("Expected rand state to be restored")
+	}
+
+	for i := 500; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+		restored.Add([]byte(strconv.Itoa(i)))
+	}
+
+	if !reflect.DeepEqual(restored.cells, f.cells) {
+		t.Error("Expected cells to match")
+	}
+}
+
+// Ensures that ReadFrom rejects decrementing more cells than the filter has on
+// every add, and still reads an unstable filter, which decrements none.
+func TestStableBloomReadFromInvalidP(t *testing.T) {
+	f := NewStableBloomFilter(1000, 3, 0.01)
+	f.p = 0x00ff00000000000a
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := (&StableBloomFilter{}).ReadFrom(&buf); err == nil {
+		t.Error("Expected error for p exceeding the number of cells")
+	}
+
+	buf.Reset()
+	if _, err := NewUnstableBloomFilter(1000, 0.01).WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := (&StableBloomFilter{}).ReadFrom(&buf); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+// Ensures that NewTunedStableBloomFilter meets the target rates and that
+// FalseNegativeRate matches the rate observed on a stream of distinct items.
+func TestTunedStableBloomFilter(t *testing.T) {
+	f, err := NewTunedStableBloomFilter(1000, 0.01, 0.01, WithSeed(1))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fnRate := f.FalseNegativeRate(1000); fnRate > 0.01 {
+		t.Errorf("Expected false-negative rate below 0.01, got %f", fnRate)
+	}
+	if fpRate := f.FalsePositiveRate(); fpRate > 0.012 {
+		t.Errorf("Expected false-positive rate about 0.01, got %f", fpRate)
+	}
+	if f.D() == 0 || f.D() > 8 {
+		t.Errorf("Expected between 1 and 8 bits per cell, got %d", f.D())
+	}
+
+	// Small gaps used to produce filters of a few cells decrementing 2^63 of
+	// them per addition.
+	for _, gap := range []uint{1, 10, 250, 1000} {
+		f, err := NewTunedStableBloomFilter(gap, 0.01, 0.01, WithSeed(1))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if f.Cells() < gap/2 {
+			t.Errorf("Expected about %d cells or more, got %d", gap, f.Cells())
+		}
+		if f.P() == 0 || f.P() > f.Cells() {
+			t.Errorf("Expected between 1 and %d cells decremented, got %d", f.Cells(), f.P())
+		}
+		for i := uint(0); i < gap; i++ {
+			f.AddString(strconv.Itoa(int(i)))
+		}
+		if !f.TestString(strconv.Itoa(int(gap - 1))) {
+			t.Errorf("Expected %d to be a member", gap-1)
+		}
+	}
+
+	// A smaller filter with a visible false-negative rate.
+	f = NewStableBloomFilter(10000, 2, 0.01, WithSeed(1)).WarmUp()
+	var (
+		gap       = 500
+		fn        = 0
+		expected  = f.FalseNegativeRate(uint(gap))
+		additions = 100000
+	)
+	for i := 0; i < additions; i++ {
+		f.AddString(strconv.Itoa(i))
+		if i >= gap && !f.TestString(strconv.Itoa(i-gap)) {
+			fn++
+		}
+	}
+	if rate := float64(fn) / float64(additions-gap); math.Abs(rate-expected) > 0.02 {
+		t.Errorf("Expected false-negative rate about %f, got %f", expected, rate)
+	}
+
+	if _, err := NewTunedStableBloomFilter(1000, 0.01, 0); err == nil {
+		t.Error("Expected error for zero false-negative rate")
+	}
+	if NewUnstableBloomFilter(1000, 0.01).FalseNegativeRate(1000000) != 0 {
+		t.Error("Expected no false negatives without eviction")
+	}
+}
+
+// Ensures that ZeroRatio and EstimatedFalsePositiveRate converge to the stable
+// point and MaxRatio reports the cells set since their last decrement.
+func TestStableBloomFilterRatios(t *testing.T) {
+	f := NewStableBloomFilter(10000, 3, 0.01, WithSeed(1))
+	if f.ZeroRatio() != 1 || f.MaxRatio() != 0 || f.EstimatedFalsePositiveRate() != 0 {
+		t.Error("Expected only zero cells in an empty filter")
+	}
+
+	f.AddString("a")
+	if expected := float64(f.K()) / float64(f.Cells()); f.MaxRatio() != expected {
+		t.Errorf("Expected max ratio %f, got %f", expected, f.MaxRatio())
+	}
+
+	for i := 0; i < 100000; i++ {
+		f.AddString(strconv.Itoa(i))
+	}
+	if actual, expected := f.ZeroRatio(), f.StablePoint(); math.Abs(actual-expected) > 0.02 {
+		t.Errorf("Expected zero ratio about %f, got %f", expected, actual)
+	}
+	if actual, expected := f.EstimatedFalsePositiveRate(), f.FalsePositiveRate(); math.Abs(actual-expected) > 0.005 {
+		t.Errorf("Expected false-positive rate about %f, got %f", expected, actual)
+	}
+	if ratio := f.MaxRatio(); ratio <= 0 || ratio >= 1-f.ZeroRatio() {
+		t.Errorf("Expected max ratio below the fraction of nonzero cells, got %f", ratio)
+	}
+}
@@ -0,0 +1,80 @@
+package boom
+
+// BucketStore is the storage of a Buckets' values, such as BoltDB pages or a
+// Redis bitfield. Filters whose Buckets use a BucketStore shared between
+// processes share their members, but each process tracks its own count of
+// items added. A BucketStore which also implements io.Closer is closed by the
+// Buckets' Close.
+type BucketStore interface {
+	// Get returns the value in the specified bucket.
+	Get(bucket uint) uint32
+
+	// Set sets the value in the specified bucket. The value never exceeds
+	// the Buckets' maximum bucket value.
+	Set(bucket uint, value uint32)
+
+	// Reset sets every bucket to zero.
+	Reset()
+
+	// Count returns the number of buckets.
+	Count() uint
+}
+
+// BucketIncrementer is implemented by a BucketStore which can increment a
+// bucket in a single operation. Stores shared between processes should
+// implement it so concurrent increments, such as those of a
+// CountingBloomFilter, aren't lost.
+type BucketIncrementer interface {
+	// Increment adds delta to the value in the specified bucket, clamping the
+	// result to zero and max.
+	Increment(bucket uint, delta int32, max uint32)
+}
+
+// NewBucketsWithStore creates a new Buckets whose values are kept in the
+// store, where each bucket is the specified number of bits, up to 31. The
+// number of buckets is the store's Count. Without a store, Buckets keep their
+// values in memory as created by NewBuckets.
+func NewBucketsWithStore(store BucketStore, bucketSize uint8) *Buckets {
+	return &Buckets{
+		count:      store.Count(),
+		bucketSize: bucketSize,
+		max:        (1 << bucketSize) - 1,
+		store:      store,
+	}
+}
+
+// incrementStored increments the bucket in the store, in a single operation if
+// the store supports it.
+func (b *Buckets) incrementStored(bucket uint, delta int32) {
+	if incrementer, ok := b.store.(BucketIncrementer); ok {
+		incrementer.Increment(bucket, delta, b.max)
+		return
+	}
+
+	val := int64(b.store.Get(bucket)) + int64(delta)
+	if val > int64(b.max) {
+		val = int64(b.max)
+	} else if val < 0 {
+		val = 0
+	}
+	b.store.Set(bucket, uint32(val))
+}
+
+// storedData returns the values in the store encoded like the data of
+// in-memory Buckets.
+func (b *Buckets) storedData() []byte {
+	tmp := NewBuckets(b.count, b.bucketSize)
+	for i := uint(0); i < b.count; i++ {
+		tmp.setBits(i*uint(b.bucketSize), uint(b.bucketSize), b.store.Get(i))
+	}
+	return tmp.data
+}
+
+// loadStored sets the values in the store from data encoded like the data of
+// in-memory Buckets.
+func (b *Buckets) loadStored(data []byte) {
+	tmp := &Buckets{data: data, bucketSize: b.bucketSize}
+	for i := uint(0); i < b.count; i++ {
+		b.store.Set(i, tmp.Get(i))
+	}
+}
@@ -0,0 +1,113 @@
+package boom
+
+import (
+	"bytes"
+	"testing"
+)
+
+// mapStore is a BucketStore keeping values in a map, shared by every Buckets
+// created from it.
+type mapStore struct {
+	values map[uint]uint32
+	count  uint
+	closed bool
+}
+
+func (m *mapStore) Get(bucket uint) uint32        { return m.values[bucket] }
+func (m *mapStore) Set(bucket uint, value uint32) { m.values[bucket] = value }
+func (m *mapStore) Reset()                        { m.values = make(map[uint]uint32) }
+func (m *mapStore) Count() uint                   { return m.count }
+func (m *mapStore) Close() error                  { m.closed = true; return nil }
+
+// Ensures that Buckets using a BucketStore keep their values in the store and
+// clamp them to the maximum bucket value.
+func TestBucketsWithStore(t *testing.T) {
+	store := &mapStore{values: make(map[uint]uint32), count: 10}
+	b := NewBucketsWithStore(store, 3)
+
+	if count := b.Count(); count != 10 {
+		t.Errorf("Expected 10, got %d", count)
+	}
+
+	b.Increment(2, 5).Increment(2, 5).Set(3, 4).Increment(4, -1)
+	if val := store.values[2]; val != 7 {
+		t.Errorf("Expected 7, got %d", val)
+	}
+
+	if val := b.Get(3); val != 4 {
+		t.Errorf("Expected 4, got %d", val)
+	}
+
+	if val := b.Get(4); val != 0 {
+		t.Errorf("Expected 0, got %d", val)
+	}
+
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	mem := &Buckets{}
+	if _, err := mem.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if mem.Get(2) != 7 || mem.Get(3) != 4 {
+		t.Errorf("Expected 7 and 4, got %d and %d", mem.Get(2), mem.Get(3))
+	}
+
+	b.Reset()
+	if _, err := b.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if val := store.values[2]; val != 7 {
+		t.Errorf("Expected 7, got %d", val)
+	}
+
+	// Clones are held in memory.
+	clone := b.Clone()
+	b.Set(2, 1)
+	if clone.store != nil || clone.Get(2) != 7 || !clone.Equal(mem) {
+		t.Errorf("Expected an in-memory copy, got %d", clone.Get(2))
+	}
+
+	if err := b.Close(); err != nil || !store.closed {
+		t.Errorf("Expected store to be closed, got %v", err)
+	}
+}
+
+// Ensures that Counting Bloom Filters sharing a BucketStore share members.
+func TestCountingBloomFilterWithStore(t *testing.T) {
+	store := &mapStore{values: make(map[uint]uint32)}
+	newBuckets := func(index int, count uint, bucketSize uint8) (*Buckets, error) {
+		store.count = count
+		return NewBucketsWithStore(store, bucketSize), nil
+	}
+
+	f1, err := NewCountingBloomFilterWithBuckets(100, 4, 0.01, newBuckets)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	f2, err := NewCountingBloomFilterWithBuckets(100, 4, 0.01, newBuckets)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	f1.Add([]byte(`a`))
+	if !f2.Test([]byte(`a`)) {
+		t.Error("`a` should be a member")
+	}
+
+	if !f2.TestAndRemove([]byte(`a`)) {
+		t.Error("`a` should be a member")
+	}
+	if f1.Test([]byte(`a`)) {
+		t.Error("`a` should not be a member")
+	}
+
+	mismatched := func(index int, count uint, bucketSize uint8) (*Buckets, error) {
+		return NewBuckets(count+1, bucketSize), nil
+	}
+	if _, err := NewCountingBloomFilterWithBuckets(100, 4, 0.01, mismatched); err == nil {
+		t.Error("Expected error for mismatched buckets")
+	}
+}
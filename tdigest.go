@@ -0,0 +1,382 @@
+package boom
+
+import (
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"unsafe"
+)
+
+// centroid is the mean of a cluster of values added to a TDigest and their
+// total weight.
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// centroids sorts centroids by mean.
+type centroids []centroid
+
+func (c centroids) Len() int           { return len(c) }
+func (c centroids) Less(i, j int) bool { return c[i].mean < c[j].mean }
+func (c centroids) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+// TDigest implements the merging t-digest as described by Dunning and Ertl in
+// Computing Extremely Accurate Quantiles Using t-Digests:
+//
+// https://arxiv.org/abs/1902.04023
+//
+// It estimates the quantiles of a stream of values, such as the 99th
+// percentile of request latencies, by clustering the values into centroids of
+// their mean and weight. The clusters are small near the ends of the
+// distribution and large near the median, bounded by a scale function, so the
+// extreme quantiles are estimated with a small error relative to q(1-q) rather
+// than the error of a uniform sample. Values are buffered and merged into the
+// centroids in batches, and digests of different streams merge into a digest
+// of the combined stream. The compression, delta, bounds the number of
+// centroids to about delta, so larger compressions are more accurate and take
+// more memory.
+type TDigest struct {
+	compression float64   // delta, which bounds the number of centroids
+	centroids   centroids // merged centroids, sorted by mean
+	buffer      centroids // values added since the last merge
+	count       float64   // total weight of the centroids and buffer
+	min, max    float64   // smallest and largest values added
+}
+
+// maxTDigestCompression is the largest compression of a TDigest, which bounds
+// the buffer allocated for one read from an untrusted representation.
+const maxTDigestCompression = 1 << 16
+
+// NewTDigest creates a new TDigest of the compression, at least 1 and at most
+// 65536, typically 100.
+func NewTDigest(compression float64) *TDigest {
+	if !(compression >= 1) {
+		compression = 1
+	}
+	if compression > maxTDigestCompression {
+		compression = maxTDigestCompression
+	}
+	return &TDigest{
+		compression: compression,
+		buffer:      make(centroids, 0, tdigestBufferSize(compression)),
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+// tdigestBufferSize returns the number of values buffered before they're
+// merged into the centroids of a TDigest of the compression.
+func tdigestBufferSize(compression float64) int {
+	return int(math.Ceil(5 * compression))
+}
+
+// Compression returns the compression, delta.
+func (t *TDigest) Compression() float64 {
+	return t.compression
+}
+
+// Count returns the total weight of the values added.
+func (t *TDigest) Count() float64 {
+	return t.count
+}
+
+// Min returns the smallest value added, or +Inf if none have been.
+func (t *TDigest) Min() float64 {
+	return t.min
+}
+
+// Max returns the largest value added, or -Inf if none have been.
+func (t *TDigest) Max() float64 {
+	return t.max
+}
+
+// Add will add the value with a weight of one. Returns the TDigest to allow for
+// chaining.
+func (t *TDigest) Add(value float64) *TDigest {
+	return t.AddWeighted(value, 1)
+}
+
+// AddWeighted will add the value with the weight, such as the number of times
+// it occurred. NaN values and weights of zero or less are ignored. Returns the
+// TDigest to allow for chaining.
+func (t *TDigest) AddWeighted(value, weight float64) *TDigest {
+	if math.IsNaN(value) || !(weight > 0) {
+		return t
+	}
+
+	t.buffer = append(t.buffer, centroid{mean: value, weight: weight})
+	t.count += weight
+	t.min = math.Min(t.min, value)
+	t.max = math.Max(t.max, value)
+	if len(t.buffer) >= tdigestBufferSize(t.compression) {
+		t.process()
+	}
+	return t
+}
+
+// process merges the buffered values into the centroids, combining adjacent
+// centroids while the scale function allows.
+func (t *TDigest) process() {
+	if len(t.buffer) == 0 {
+		return
+	}
+
+	all := append(t.buffer, t.centroids...)
+	sort.Sort(all)
+
+	weight := 0.0
+	for _, c := range all {
+		weight += c.weight
+	}
+
+	var (
+		merged = make(centroids, 0, len(t.centroids)+1)
+		total  = 0.0 // weight of the merged centroids
+		kLeft  = t.scale(0)
+	)
+	for _, c := range all {
+		if n := len(merged); n > 0 && t.scale((total+c.weight)/weight)-kLeft <= 1 {
+			last := &merged[n-1]
+			last.weight += c.weight
+			last.mean += (c.mean - last.mean) * c.weight / last.weight
+			total += c.weight
+			continue
+		}
+		kLeft = t.scale(total / weight)
+		merged = append(merged, c)
+		total += c.weight
+	}
+
+	t.centroids = merged
+	t.buffer = t.buffer[:0]
+}
+
+// scale is the scale function k1 of the t-digest, which bounds a centroid to
+// a difference of one between its quantiles, so centroids are smaller near
+// the ends.
+func (t *TDigest) scale(q float64) float64 {
+	return t.compression / (2 * math.Pi) * math.Asin(2*math.Min(1, math.Max(0, q))-1)
+}
+
+// Quantile returns the estimated value at the quantile q, between zero and
+// one, interpolating between the centroids, or NaN if no values have been
+// added.
+func (t *TDigest) Quantile(q float64) float64 {
+	t.process()
+	if len(t.centroids) == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return t.min
+	}
+	if q >= 1 {
+		return t.max
+	}
+
+	var (
+		index = q * t.count
+		first = t.centroids[0]
+	)
+	if index < first.weight/2 {
+		return t.min + (first.mean-t.min)*index/(first.weight/2)
+	}
+
+	// Each centroid's mean is taken to be the value at its center.
+	center := first.weight / 2
+	for i := 1; i < len(t.centroids); i++ {
+		var (
+			prev, c = t.centroids[i-1], t.centroids[i]
+			next    = center + (prev.weight+c.weight)/2
+		)
+		if index < next {
+			return prev.mean + (c.mean-prev.mean)*(index-center)/(next-center)
+		}
+		center = next
+	}
+
+	last := t.centroids[len(t.centroids)-1]
+	if rest := t.count - center; rest > 0 {
+		return last.mean + (t.max-last.mean)*(index-center)/rest
+	}
+	return t.max
+}
+
+// CDF returns the estimated fraction of the weight of values at or below the
+// value, the inverse of Quantile, or NaN if no values have been added.
+func (t *TDigest) CDF(value float64) float64 {
+	t.process()
+	if len(t.centroids) == 0 || math.IsNaN(value) {
+		return math.NaN()
+	}
+	if value < t.min {
+		return 0
+	}
+	if value >= t.max {
+		return 1
+	}
+
+	first := t.centroids[0]
+	if value < first.mean {
+		if first.mean == t.min {
+			return 0
+		}
+		return first.weight / 2 * (value - t.min) / (first.mean - t.min) / t.count
+	}
+
+	center := first.weight / 2
+	for i := 1; i < len(t.centroids); i++ {
+		var (
+			prev, c = t.centroids[i-1], t.centroids[i]
+			next    = center + (prev.weight+c.weight)/2
+		)
+		if value < c.mean {
+			return (center + (next-center)*(value-prev.mean)/(c.mean-prev.mean)) / t.count
+		}
+		center = next
+	}
+
+	last := t.centroids[len(t.centroids)-1]
+	return (center + (t.count-center)*(value-last.mean)/(t.max-last.mean)) / t.count
+}
+
+// Merge combines this TDigest with another, so it's a digest of both streams,
+// such as to combine digests of different shards. The other digest is
+// unchanged, and the compression of this one is kept.
+func (t *TDigest) Merge(other *TDigest) *TDigest {
+	if other.count == 0 {
+		return t
+	}
+
+	for _, values := range []centroids{other.centroids, other.buffer} {
+		for _, c := range values {
+			t.buffer = append(t.buffer, c)
+			if len(t.buffer) >= tdigestBufferSize(t.compression) {
+				t.process()
+			}
+		}
+	}
+	t.count += other.count
+	t.min = math.Min(t.min, other.min)
+	t.max = math.Max(t.max, other.max)
+	t.process()
+	return t
+}
+
+// Reset restores the TDigest to its original state. It returns itself to
+// allow for chaining.
+func (t *TDigest) Reset() *TDigest {
+	t.centroids = t.centroids[:0]
+	t.buffer = t.buffer[:0]
+	t.count = 0
+	t.min = math.Inf(1)
+	t.max = math.Inf(-1)
+	return t
+}
+
+// Clone returns an independent copy of the TDigest, which can be used
+// concurrently with the original.
+func (t *TDigest) Clone() *TDigest {
+	clone := *t
+	clone.centroids = append(centroids(nil), t.centroids...)
+	clone.buffer = append(make(centroids, 0, cap(t.buffer)), t.buffer...)
+	return &clone
+}
+
+// ByteSize returns the number of bytes used by the centroids, buffer, and
+// metadata of the TDigest.
+func (t *TDigest) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*t)) + uint64(cap(t.centroids)+cap(t.buffer))*uint64(unsafe.Sizeof(centroid{}))
+}
+
+// WriteTo writes a binary representation of the TDigest to an I/O stream,
+// merging the buffered values into the centroids first. It returns the number
+// of bytes written.
+func (t *TDigest) WriteTo(stream io.Writer) (int64, error) {
+	t.process()
+
+	var e encoder
+	e.write(t.compression)
+	e.write(t.min)
+	e.write(t.max)
+	e.write(uint64(len(t.centroids)))
+	for _, c := range t.centroids {
+		e.write(c.mean)
+		e.write(c.weight)
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a TDigest (such as might have been
+// written by WriteTo()) from an I/O stream. Returns ErrUnsupportedVersion if
+// the data was written by an incompatible version of the package. It returns
+// the number of bytes read.
+func (t *TDigest) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                     = decoder{r: payload}
+		compression, min, max float64
+		l                     uint64
+	)
+	d.read(&compression)
+	d.read(&min)
+	d.read(&max)
+	d.read(&l)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if !(compression >= 1 && compression <= maxTDigestCompression) {
+		return n, errors.New("invalid digest parameters")
+	}
+	if l > uint64(payload.Len())/16 {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	var (
+		read  = make(centroids, l)
+		count = 0.0
+	)
+	for i := range read {
+		d.read(&read[i].mean)
+		d.read(&read[i].weight)
+		count += read[i].weight
+	}
+	if d.err != nil {
+		return n, d.err
+	}
+	for i, c := range read {
+		if !(c.weight > 0) || (i > 0 && c.mean < read[i-1].mean) {
+			return n, errors.New("invalid centroids")
+		}
+	}
+
+	t.compression = compression
+	t.centroids = read
+	t.buffer = make(centroids, 0, tdigestBufferSize(compression))
+	t.count = count
+	t.min = min
+	t.max = max
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (t *TDigest) MarshalBinary() ([]byte, error) {
+	return marshalBinary(t)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (t *TDigest) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(t, data)
+}
@@ -0,0 +1,116 @@
+package boom
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Ensures that TDigest estimates quantiles of a shuffled stream accurately,
+// and more so towards the ends.
+func TestTDigest(t *testing.T) {
+	var (
+		td = NewTDigest(100)
+		n  = 100000.0
+	)
+	if !math.IsNaN(td.Quantile(0.5)) {
+		t.Error("Expected NaN for an empty digest")
+	}
+	for _, v := range rand.New(rand.NewSource(1)).Perm(int(n)) {
+		td.Add(float64(v))
+	}
+	if td.Count() != n || td.Min() != 0 || td.Max() != n-1 {
+		t.Errorf("Expected %f values from 0 to %f, got %f from %f to %f", n, n-1, td.Count(), td.Min(), td.Max())
+	}
+
+	for _, tc := range []struct{ q, tolerance float64 }{
+		{0.001, 0.001}, {0.01, 0.001}, {0.5, 0.005}, {0.99, 0.001}, {0.999, 0.001},
+	} {
+		if actual := td.Quantile(tc.q); math.Abs(actual-tc.q*n) > tc.tolerance*n {
+			t.Errorf("Expected quantile %f within %f of %f, got %f", tc.q, tc.tolerance*n, tc.q*n, actual)
+		}
+		if actual := td.CDF(tc.q * n); math.Abs(actual-tc.q) > tc.tolerance {
+			t.Errorf("Expected CDF within %f of %f, got %f", tc.tolerance, tc.q, actual)
+		}
+	}
+	if td.Quantile(0) != 0 || td.Quantile(1) != n-1 || td.CDF(-1) != 0 || td.CDF(n) != 1 {
+		t.Error("Expected the extremes at quantiles 0 and 1")
+	}
+	if size := len(td.centroids); size > 100 {
+		t.Errorf("Expected at most 100 centroids, got %d", size)
+	}
+
+	if !math.IsNaN(td.Reset().Quantile(0.5)) || td.Count() != 0 {
+		t.Error("Expected an empty digest")
+	}
+}
+
+// Ensures that merging digests of halves of a stream estimates the quantiles
+// of the whole stream.
+func TestTDigestMerge(t *testing.T) {
+	a, b := NewTDigest(100), NewTDigest(100)
+	for i := 0; i < 10000; i++ {
+		a.AddWeighted(float64(i), 1)
+		b.AddWeighted(float64(i+10000), 1)
+	}
+	other := b.Clone()
+
+	if td := a.Merge(b); td != a || a.Count() != 20000 || a.Max() != 19999 {
+		t.Errorf("Expected 20000 values up to 19999, got %f up to %f", a.Count(), a.Max())
+	}
+	if median := a.Quantile(0.5); math.Abs(median-10000) > 100 {
+		t.Errorf("Expected a median of about 10000, got %f", median)
+	}
+	if b.Count() != other.Count() || b.Quantile(0.5) != other.Quantile(0.5) {
+		t.Error("Expected the other digest to be unchanged")
+	}
+}
+
+// Ensures that a TDigest read from its written representation estimates the
+// same quantiles as the original.
+func TestTDigestWriteToReadFrom(t *testing.T) {
+	expected := NewTDigest(50)
+	for i := 0; i < 1000; i++ {
+		expected.Add(math.Sqrt(float64(i)))
+	}
+
+	var buf bytes.Buffer
+	if _, err := expected.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var read TDigest
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if read.Compression() != 50 || read.Count() != expected.Count() {
+		t.Errorf("Expected compression 50 and count %f, got %f and %f", expected.Count(), read.Compression(), read.Count())
+	}
+	for _, q := range []float64{0.01, 0.5, 0.99} {
+		if read.Quantile(q) != expected.Quantile(q) {
+			t.Errorf("Expected quantile %f to be %f, got %f", q, expected.Quantile(q), read.Quantile(q))
+		}
+	}
+}
+
+// Ensures that ReadFrom rejects a compression larger than a TDigest can be
+// created with instead of allocating its buffer, and that NewTDigest caps it.
+func TestTDigestReadFromLargeCompression(t *testing.T) {
+	var e encoder
+	e.write(1e18)
+	e.write(0.0)
+	e.write(0.0)
+	e.write(uint64(0))
+
+	var buf bytes.Buffer
+	if _, err := writeFrame(&buf, e.buf.Bytes()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := (&TDigest{}).ReadFrom(&buf); err == nil {
+		t.Error("Expected error for a compression too large")
+	}
+
+	if c := NewTDigest(1e18).Compression(); c != maxTDigestCompression {
+		t.Errorf("Expected compression %d, got %f", maxTDigestCompression, c)
+	}
+}
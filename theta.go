@@ -0,0 +1,354 @@
+package boom
+
+import (
+	"container/heap"
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"sort"
+	"unsafe"
+)
+
+// A uint64Heap is a max-heap of hash values.
+type uint64Heap []uint64
+
+func (h uint64Heap) Len() int           { return len(h) }
+func (h uint64Heap) Less(i, j int) bool { return h[i] > h[j] }
+func (h uint64Heap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *uint64Heap) Push(x interface{}) {
+	*h = append(*h, x.(uint64))
+}
+
+func (h *uint64Heap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
+
+// uint64s sorts hash values in increasing order.
+type uint64s []uint64
+
+func (u uint64s) Len() int           { return len(u) }
+func (u uint64s) Less(i, j int) bool { return u[i] < u[j] }
+func (u uint64s) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+
+// ThetaSketch implements a K-Minimum-Values sketch in the Theta Sketch
+// Framework described by Dasgupta, Lang, Rhodes, and Thaler in A Framework
+// for Estimating Stream Expression Cardinalities:
+//
+// https://arxiv.org/abs/1510.01455
+//
+// It keeps the distinct hashes of the items below a threshold, theta, which
+// starts at one and drops to the smallest hash evicted once more than k are
+// kept, so the hashes kept are a uniform sample of the distinct items with
+// probability theta, and the number of distinct items is estimated as the
+// number kept divided by theta. Unlike HyperLogLog, whose registers only
+// combine into the sketch of a union, the samples of two sketches combine
+// into sketches of their union, intersection, and difference, estimating
+// each with a relative standard error of about 1/sqrt(k) times the square
+// root of the ratio of the union to the result, such as to measure the
+// overlap of two audiences.
+type ThetaSketch struct {
+	hashes uint64Heap          // hashes kept, below theta
+	set    map[uint64]struct{} // hashes kept, for deduplication
+	k      uint                // most hashes kept
+	theta  uint64              // threshold as a fraction of 2^64, MaxUint64 for one
+	hash   hash.Hash64         // hash function
+	seed   uint64              // hash seed (zero means unseeded)
+}
+
+// NewThetaSketch creates a new ThetaSketch keeping at most k hashes, at least
+// 1.
+func NewThetaSketch(k uint, opts ...Option) *ThetaSketch {
+	if k == 0 {
+		k = 1
+	}
+
+	o := applyOptions(opts)
+	return &ThetaSketch{
+		hashes: make(uint64Heap, 0, k+1),
+		set:    make(map[uint64]struct{}, k+1),
+		k:      k,
+		theta:  math.MaxUint64,
+		hash:   o.newHash64(newFNV64),
+		seed:   o.seed,
+	}
+}
+
+// K returns the most hashes kept.
+func (t *ThetaSketch) K() uint {
+	return t.k
+}
+
+// Theta returns the probability with which the hash of each distinct item is
+// kept.
+func (t *ThetaSketch) Theta() float64 {
+	if t.theta == math.MaxUint64 {
+		return 1
+	}
+	return math.Ldexp(float64(t.theta), -64)
+}
+
+// Retained returns the number of hashes kept.
+func (t *ThetaSketch) Retained() int {
+	return len(t.hashes)
+}
+
+// Add will add the data to the set. Returns the ThetaSketch to allow for
+// chaining.
+func (t *ThetaSketch) Add(data []byte) *ThetaSketch {
+	lower, upper := seededHashKernel(data, t.hash, t.seed)
+	t.insert(mix64(uint64(upper)<<32 | uint64(lower)))
+	return t
+}
+
+// insert keeps the hash if it's below theta, evicting the largest hash and
+// lowering theta to it if more than k are kept.
+func (t *ThetaSketch) insert(h uint64) {
+	if h >= t.theta {
+		return
+	}
+	if _, ok := t.set[h]; ok {
+		return
+	}
+
+	t.set[h] = struct{}{}
+	heap.Push(&t.hashes, h)
+	if uint(len(t.hashes)) > t.k {
+		t.theta = heap.Pop(&t.hashes).(uint64)
+		delete(t.set, t.theta)
+	}
+}
+
+// Count returns the approximated number of distinct items added.
+func (t *ThetaSketch) Count() uint64 {
+	return uint64(math.Floor(float64(len(t.hashes))/t.Theta() + 0.5))
+}
+
+// Union returns a new sketch of the union of this set and the other. It keeps
+// the k of this sketch, and theta is at most the smaller of the two. Returns
+// an error if the sketches have a different hash seed.
+func (t *ThetaSketch) Union(other *ThetaSketch) (*ThetaSketch, error) {
+	union := t.Clone()
+	if err := union.Merge(other); err != nil {
+		return nil, err
+	}
+	return union, nil
+}
+
+// Intersection returns a new sketch of the items in both this set and the
+// other, with the smaller theta of the two. Returns an error if the sketches
+// have a different hash seed.
+func (t *ThetaSketch) Intersection(other *ThetaSketch) (*ThetaSketch, error) {
+	return t.combine(other, true)
+}
+
+// Difference returns a new sketch of the items in this set but not the other,
+// A and not B, with the smaller theta of the two. Returns an error if the
+// sketches have a different hash seed.
+func (t *ThetaSketch) Difference(other *ThetaSketch) (*ThetaSketch, error) {
+	return t.combine(other, false)
+}
+
+// combine returns a new sketch with the smaller theta of the two of the hashes
+// of this sketch which are in the other, or which aren't.
+func (t *ThetaSketch) combine(other *ThetaSketch, in bool) (*ThetaSketch, error) {
+	if t.seed != other.seed {
+		return nil, errors.New("hash seed must match")
+	}
+
+	result := &ThetaSketch{
+		hashes: make(uint64Heap, 0, t.k+1),
+		set:    make(map[uint64]struct{}, len(t.hashes)),
+		k:      t.k,
+		theta:  t.theta,
+		hash:   cloneHash64(t.hash),
+		seed:   t.seed,
+	}
+	if other.theta < result.theta {
+		result.theta = other.theta
+	}
+	for _, h := range t.hashes {
+		if _, ok := other.set[h]; ok == in && h < result.theta {
+			result.hashes = append(result.hashes, h)
+			result.set[h] = struct{}{}
+		}
+	}
+	heap.Init(&result.hashes)
+	return result, nil
+}
+
+// Merge combines this ThetaSketch with another, so it's the sketch of the
+// union of the sets, such as to combine sketches built on different shards.
+// The sketches should use the same hash function. Returns an error if they
+// have a different hash seed, in which case this sketch is unchanged.
+func (t *ThetaSketch) Merge(other *ThetaSketch) error {
+	if t.seed != other.seed {
+		return errors.New("hash seed must match")
+	}
+
+	if other.theta < t.theta {
+		t.theta = other.theta
+		kept := t.hashes[:0]
+		for _, h := range t.hashes {
+			if h < t.theta {
+				kept = append(kept, h)
+			} else {
+				delete(t.set, h)
+			}
+		}
+		t.hashes = kept
+		heap.Init(&t.hashes)
+	}
+	for _, h := range other.hashes {
+		t.insert(h)
+	}
+	return nil
+}
+
+// Equal returns true if the other ThetaSketch keeps at most the same number of
+// hashes and has the same hash seed, theta, and hashes. The hash functions
+// aren't compared.
+func (t *ThetaSketch) Equal(other *ThetaSketch) bool {
+	if t.k != other.k || t.seed != other.seed || t.theta != other.theta || len(t.set) != len(other.set) {
+		return false
+	}
+
+	for h := range t.set {
+		if _, ok := other.set[h]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the ThetaSketch, which can be used
+// concurrently with the original.
+func (t *ThetaSketch) Clone() *ThetaSketch {
+	clone := *t
+	clone.hash = cloneHash64(t.hash)
+	clone.hashes = append(make(uint64Heap, 0, cap(t.hashes)), t.hashes...)
+	clone.set = make(map[uint64]struct{}, len(t.set))
+	for h := range t.set {
+		clone.set[h] = struct{}{}
+	}
+	return &clone
+}
+
+// ByteSize returns the number of bytes used by the hashes and metadata of the
+// sketch, excluding the overhead of the map of hashes and the hash function.
+func (t *ThetaSketch) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*t)) + uint64(cap(t.hashes)+len(t.set))*8
+}
+
+// Reset restores the ThetaSketch to its original state. It returns itself to
+// allow for chaining.
+func (t *ThetaSketch) Reset() *ThetaSketch {
+	t.hashes = t.hashes[:0]
+	t.set = make(map[uint64]struct{}, t.k+1)
+	t.theta = math.MaxUint64
+	return t
+}
+
+// SetHash sets the hashing function used.
+func (t *ThetaSketch) SetHash(h hash.Hash64) {
+	t.hash = h
+}
+
+// WriteTo writes a binary representation of the ThetaSketch to an I/O stream,
+// with the hashes in increasing order. The hash function is not written, but
+// the seed is. It returns the number of bytes written.
+func (t *ThetaSketch) WriteTo(stream io.Writer) (int64, error) {
+	hashes := append(uint64s(nil), t.hashes...)
+	sort.Sort(hashes)
+
+	var e encoder
+	e.write(uint64(t.k))
+	e.write(t.seed)
+	e.write(t.theta)
+	e.write(uint64(len(hashes)))
+	e.write([]uint64(hashes))
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a ThetaSketch (such as might have
+// been written by WriteTo()) from an I/O stream. The sketch keeps its current
+// hash function, which must match the one used by the sketch that was
+// written, or uses the default one if it has none, such as when it's the zero
+// value. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (t *ThetaSketch) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                 = decoder{r: payload}
+		k, seed, theta, l uint64
+	)
+	d.read(&k)
+	d.read(&seed)
+	d.read(&theta)
+	d.read(&l)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if k == 0 || k > math.MaxInt32 || l > k {
+		return n, errors.New("invalid sketch parameters")
+	}
+	if l > uint64(payload.Len())/8 {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	// The hashes are allocated for those read, bounded by the data, rather
+	// than for k, which may be much larger.
+	hashes := make(uint64Heap, l, l+1)
+	d.read([]uint64(hashes))
+	if d.err != nil {
+		return n, d.err
+	}
+
+	set := make(map[uint64]struct{}, l+1)
+	for _, h := range hashes {
+		if h >= theta {
+			return n, errors.New("hash exceeds theta")
+		}
+		set[h] = struct{}{}
+	}
+	if len(set) != len(hashes) {
+		return n, errors.New("duplicate hashes")
+	}
+	heap.Init(&hashes)
+
+	if t.hash == nil {
+		t.hash = newFNV64()
+	}
+	t.hashes = hashes
+	t.set = set
+	t.k = uint(k)
+	t.theta = theta
+	t.seed = seed
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (t *ThetaSketch) MarshalBinary() ([]byte, error) {
+	return marshalBinary(t)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (t *ThetaSketch) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(t, data)
+}
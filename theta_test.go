@@ -0,0 +1,118 @@
+package boom
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// Ensures that ThetaSketch estimates the cardinalities of the union,
+// intersection, and difference of overlapping sets.
+func TestThetaSketch(t *testing.T) {
+	a, b := NewThetaSketch(4096), NewThetaSketch(4096)
+	for i := 0; i < 100000; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+		b.Add([]byte(strconv.Itoa(i + 50000)))
+	}
+	if a.Retained() != 4096 || a.Theta() >= 1 {
+		t.Errorf("Expected 4096 hashes below a theta less than one, got %d below %f", a.Retained(), a.Theta())
+	}
+
+	within := func(name string, actual uint64, expected, tolerance float64) {
+		if math.Abs(float64(actual)-expected) > tolerance*expected {
+			t.Errorf("Expected %s within %.0f%% of %.0f, got %d", name, tolerance*100, expected, actual)
+		}
+	}
+	within("count", a.Count(), 100000, 0.05)
+
+	union, err := a.Union(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	within("union", union.Count(), 150000, 0.05)
+	intersection, err := a.Intersection(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	within("intersection", intersection.Count(), 50000, 0.1)
+	difference, err := a.Difference(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	within("difference", difference.Count(), 50000, 0.1)
+
+	if count := a.Count(); count == union.Count() {
+		t.Error("Expected Union to leave the sketch unchanged")
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Equal(union) {
+		t.Error("Expected the merged sketch to equal the union")
+	}
+	if _, err := a.Intersection(NewThetaSketch(4096, WithSeed(1))); err == nil {
+		t.Error("Expected an error for different seeds")
+	}
+
+	// Small sets are counted exactly.
+	small := NewThetaSketch(4096)
+	for i := 0; i < 100; i++ {
+		small.Add([]byte(strconv.Itoa(i % 50)))
+	}
+	if small.Count() != 50 || small.Theta() != 1 {
+		t.Errorf("Expected exactly 50, got %d", small.Count())
+	}
+	if a.Reset().Count() != 0 || a.Theta() != 1 {
+		t.Error("Expected an empty sketch")
+	}
+}
+
+// Ensures that a ThetaSketch read from its written representation into the
+// zero value equals the original.
+func TestThetaSketchWriteToReadFrom(t *testing.T) {
+	expected := NewThetaSketch(64, WithSeed(11))
+	for i := 0; i < 1000; i++ {
+		expected.Add([]byte(strconv.Itoa(i)))
+	}
+
+	var buf bytes.Buffer
+	if _, err := expected.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var read ThetaSketch
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !read.Equal(expected) || read.Count() != expected.Count() {
+		t.Error("Expected the read sketch to equal the original")
+	}
+	if read.Add([]byte(`x`)); !read.Equal(expected.Add([]byte(`x`))) {
+		t.Error("Expected the read sketch to keep adding like the original")
+	}
+}
+
+// Ensures that ReadFrom rejects a k whose capacity wraps and allocates for the
+// hashes read rather than for k.
+func TestThetaSketchReadFromLargeK(t *testing.T) {
+	for _, k := range []uint64{math.MaxUint64, 1 << 30} {
+		var e encoder
+		e.write(k)
+		e.write(uint64(0))
+		e.write(uint64(math.MaxUint64))
+		e.write(uint64(1))
+		e.write(uint64(5))
+
+		var buf bytes.Buffer
+		if _, err := writeFrame(&buf, e.buf.Bytes()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		read := &ThetaSketch{}
+		_, err := read.ReadFrom(&buf)
+		if k == math.MaxUint64 && err == nil {
+			t.Error("Expected error for a k whose capacity wraps")
+		} else if k != math.MaxUint64 && (err != nil || cap(read.hashes) != 2) {
+			t.Errorf("Expected no error and capacity 2, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package boom
+
+// TieredFilter composes filters into tiers tested in order, so that cheap or
+// specialized front tiers absorb most queries before later ones are consulted.
+// For example, an InverseBloomFilter fronting a ScalableBloomFilter answers
+// recently repeated keys without false positives and without touching the
+// larger filter, which remembers every key.
+//
+// Every tier receives added items. An item is a member if any tier reports it
+// as one, so the false-positive rate of the composite is at most the sum of
+// those of its tiers, while a tier which may forget items, such as a Stable or
+// Inverse Bloom Filter, only causes a false negative if all of them do.
+type TieredFilter struct {
+	tiers []Filter
+}
+
+// NewTieredFilter creates a new TieredFilter testing the tiers in the order
+// given. Filters which don't implement Filter, such as CuckooFilter, can be
+// used as tiers through NewFilterAdapter.
+func NewTieredFilter(tiers ...Filter) *TieredFilter {
+	return &TieredFilter{tiers: tiers}
+}
+
+// Tiers returns the filters composed by the TieredFilter, in order.
+func (t *TieredFilter) Tiers() []Filter {
+	return t.tiers
+}
+
+// Test will test for membership of the data in each tier in order and returns
+// true as soon as one reports it as a member, false if none does.
+func (t *TieredFilter) Test(data []byte) bool {
+	for _, tier := range t.tiers {
+		if tier.Test(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add will add the data to every tier. It returns the filter to allow for
+// chaining.
+func (t *TieredFilter) Add(data []byte) Filter {
+	for _, tier := range t.tiers {
+		tier.Add(data)
+	}
+	return t
+}
+
+// TestAndAdd calls TestAndAdd on each tier in order until one reports the data
+// as a member, so the data is added to every tier up to that one and later
+// tiers, which already received it when it was first added, aren't consulted.
+// It returns true if a tier reported the data as a member, false if not.
+func (t *TieredFilter) TestAndAdd(data []byte) bool {
+	for _, tier := range t.tiers {
+		if tier.TestAndAdd(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (t *TieredFilter) TestString(data string) bool {
+	return t.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied. It returns the filter to allow for chaining.
+func (t *TieredFilter) AddString(data string) Filter {
+	return t.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (t *TieredFilter) TestAndAddString(data string) bool {
+	return t.TestAndAdd(stringBytes(data))
+}
+
+// FallibleFilter is implemented by filters whose additions can fail, such as
+// CuckooFilter and AdaptiveCuckooFilter when they're full.
+type FallibleFilter interface {
+	Test([]byte) bool
+	Add([]byte) error
+	TestAndAdd([]byte) (bool, error)
+}
+
+// FilterAdapter adapts a FallibleFilter to the Filter interface, so it can be
+// composed with other filters, such as in a TieredFilter. Errors of additions
+// are recorded rather than returned.
+type FilterAdapter struct {
+	filter FallibleFilter
+	err    error
+}
+
+// NewFilterAdapter creates a new FilterAdapter wrapping the filter.
+func NewFilterAdapter(filter FallibleFilter) *FilterAdapter {
+	return &FilterAdapter{filter: filter}
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not.
+func (f *FilterAdapter) Test(data []byte) bool {
+	return f.filter.Test(data)
+}
+
+// Add will add the data to the filter, recording the error if it fails. It
+// returns the adapter to allow for chaining.
+func (f *FilterAdapter) Add(data []byte) Filter {
+	if err := f.filter.Add(data); err != nil && f.err == nil {
+		f.err = err
+	}
+	return f
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add, recording the
+// error if adding fails. It returns true if the data is a member, false if
+// not.
+func (f *FilterAdapter) TestAndAdd(data []byte) bool {
+	member, err := f.filter.TestAndAdd(data)
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+	return member
+}
+
+// Err returns the first error of an addition to the wrapped filter, or nil if
+// every one succeeded.
+func (f *FilterAdapter) Err() error {
+	return f.err
+}
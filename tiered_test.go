@@ -0,0 +1,69 @@
+package boom
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Ensures that a TieredFilter tests its tiers in order and adds to them.
+func TestTieredFilter(t *testing.T) {
+	var (
+		front = NewInverseBloomFilter(10)
+		back  = NewScalableBloomFilter(100, 0.01, 0.8)
+		f     = NewTieredFilter(front, back)
+	)
+
+	if f.TestAndAdd([]byte(`a`)) {
+		t.Error("`a` should not be a member")
+	}
+	if !front.Test([]byte(`a`)) || !back.Test([]byte(`a`)) {
+		t.Error("Expected `a` to be added to every tier")
+	}
+
+	// `a` is answered by the back tier after being evicted from the front one.
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+	if front.Test([]byte(`a`)) {
+		t.Fatal("Expected `a` to be evicted from the front tier")
+	}
+	if !f.Test([]byte(`a`)) {
+		t.Error("`a` should be a member of the back tier")
+	}
+	if !f.TestAndAdd([]byte(`a`)) || !front.Test([]byte(`a`)) {
+		t.Error("Expected `a` to be added back to the front tier")
+	}
+
+	if tiers := f.Tiers(); len(tiers) != 2 || tiers[0] != front {
+		t.Errorf("Expected the tiers in order, got %v", tiers)
+	}
+
+	if f.AddString(`b`) != f || !f.TestString(`b`) || !f.TestAndAddString(`b`) {
+		t.Error("`b` should be a member")
+	}
+}
+
+// Ensures that a FilterAdapter composes a CuckooFilter with other filters and
+// records the errors of additions.
+func TestFilterAdapter(t *testing.T) {
+	var (
+		cuckoo  = NewCuckooFilter(10, 0.01)
+		adapter = NewFilterAdapter(cuckoo)
+		f       = NewTieredFilter(adapter, NewBloomFilter(1000, 0.01))
+	)
+
+	f.Add([]byte(`a`))
+	if !cuckoo.Test([]byte(`a`)) || !f.Test([]byte(`a`)) {
+		t.Error("`a` should be a member")
+	}
+
+	for i := 0; i < 1000; i++ {
+		f.TestAndAdd([]byte(strconv.Itoa(i)))
+	}
+	if adapter.Err() == nil {
+		t.Error("Expected error for a full filter")
+	}
+	if !f.Test([]byte(`999`)) {
+		t.Error("`999` should be a member of the back tier")
+	}
+}
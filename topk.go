@@ -3,6 +3,11 @@ package boom
 import (
 	"bytes"
 	"container/heap"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"unsafe"
 )
 
 type element struct {
@@ -29,6 +34,23 @@ func (e *elementHeap) Pop() interface{} {
 	return x
 }
 
+// TopKElement is an element of a TopK with its estimated frequency, which is
+// never below its true frequency and, with probability 1-delta, at most Error
+// above it.
+type TopKElement struct {
+	Data  []byte
+	Count uint64
+	Error uint64
+}
+
+// topKElements attaches the methods of sort.Interface to []TopKElement, from
+// lowest to highest count.
+type topKElements []TopKElement
+
+func (e topKElements) Len() int           { return len(e) }
+func (e topKElements) Less(i, j int) bool { return e[i].Count < e[j].Count }
+func (e topKElements) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
 // TopK uses a Count-Min Sketch to calculate the top-K frequent elements in a
 // stream.
 type TopK struct {
@@ -41,11 +63,11 @@ type TopK struct {
 // NewTopK creates a new TopK backed by a Count-Min sketch whose relative
 // accuracy is within a factor of epsilon with probability delta. It tracks the
 // k-most frequent elements.
-func NewTopK(epsilon, delta float64, k uint) *TopK {
+func NewTopK(epsilon, delta float64, k uint, opts ...Option) *TopK {
 	elements := make(elementHeap, 0, k)
 	heap.Init(&elements)
 	return &TopK{
-		cms:      NewCountMinSketch(epsilon, delta),
+		cms:      NewCountMinSketch(epsilon, delta, opts...),
 		k:        k,
 		elements: &elements,
 	}
@@ -65,16 +87,60 @@ func (t *TopK) Add(data []byte) *TopK {
 	return t
 }
 
+// MergeSketch merges the counts of the provided Count-Min Sketch into the
+// TopK's underlying sketch and re-evaluates the frequencies of the elements in
+// the top-k heap against the combined counts. Only elements already in the
+// heap are considered, as the sketch doesn't record the items it has seen.
+// Returns an error if the sketch's matrix width and depth don't match those of
+// the underlying sketch.
+func (t *TopK) MergeSketch(cms *CountMinSketch) error {
+	if err := t.cms.Merge(cms); err != nil {
+		return err
+	}
+
+	t.n += uint(cms.count)
+	for _, element := range *t.elements {
+		element.freq = t.cms.Count(element.data)
+	}
+	heap.Init(t.elements)
+	return nil
+}
+
+// Merge combines another TopK, such as the top-k of another shard, into this
+// one, merging the other's sketch into this one's with MergeSketch and then
+// re-ranking the candidates of both heaps by their estimates in the merged
+// sketch, keeping this TopK's k. Returns an error if the sketches can't be
+// merged, in which case this TopK is unchanged.
+//
+// Estimates are within epsilon times the merged number of items of the true
+// counts, as for the merged sketch. An item is only a candidate if it was in
+// the top-k of either shard, so an item which is frequent overall but not in
+// the top-k of any shard is missed, which is unlikely when k is larger than
+// the number of items needed from the merged top-k.
+func (t *TopK) Merge(other *TopK) error {
+	if err := t.MergeSketch(other.cms); err != nil {
+		return err
+	}
+
+	for _, element := range *other.elements {
+		if freq := t.cms.Count(element.data); t.isTop(freq) {
+			t.insert(append([]byte(nil), element.data...), freq)
+		}
+	}
+	heap.Init(t.elements)
+	return nil
+}
+
 // Elements returns the top-k elements from lowest to highest frequency.
 func (t *TopK) Elements() [][]byte {
 	if t.elements.Len() == 0 {
 		return [][]byte{}
 	}
 
-	elements := make(elementHeap, t.k)
+	elements := make(elementHeap, len(*t.elements))
 	copy(elements, *t.elements)
 	heap.Init(&elements)
-	topK := make([][]byte, 0, t.k)
+	topK := make([][]byte, 0, len(elements))
 
 	for elements.Len() > 0 {
 		topK = append(topK, heap.Pop(&elements).(*element).data)
@@ -83,6 +149,56 @@ func (t *TopK) Elements() [][]byte {
 	return topK
 }
 
+// Estimates returns the top-k elements with their current estimated
+// frequencies and the error bound, from lowest to highest estimate. Each true
+// frequency is between the estimate minus the error and the estimate with
+// probability 1-delta, so two adjacent elements are only distinguishable if
+// their estimates differ by more than the error.
+func (t *TopK) Estimates() []TopKElement {
+	var (
+		bound     = t.ErrorBound()
+		estimates = make(topKElements, 0, t.elements.Len())
+	)
+	for _, data := range t.Elements() {
+		estimates = append(estimates, TopKElement{Data: data, Count: t.cms.Count(data), Error: bound})
+	}
+	sort.Stable(estimates)
+	return estimates
+}
+
+// ErrorBound returns the most by which an estimated frequency exceeds the true
+// frequency with probability 1-delta, epsilon times the number of items added.
+func (t *TopK) ErrorBound() uint64 {
+	return uint64(math.Ceil(t.cms.Epsilon() * float64(t.cms.TotalCount())))
+}
+
+// Clone returns an independent copy of the TopK, including the underlying
+// Count-Min Sketch and the top-k heap, which can be used concurrently with the
+// original.
+func (t *TopK) Clone() *TopK {
+	elements := make(elementHeap, len(*t.elements), cap(*t.elements))
+	for i, e := range *t.elements {
+		elements[i] = &element{data: append([]byte(nil), e.data...), freq: e.freq}
+	}
+
+	return &TopK{
+		cms:      t.cms.Clone(),
+		k:        t.k,
+		n:        t.n,
+		elements: &elements,
+	}
+}
+
+// ByteSize returns the number of bytes used by the TopK's Count-Min Sketch,
+// the elements it tracks, and its metadata.
+func (t *TopK) ByteSize() uint64 {
+	size := uint64(unsafe.Sizeof(*t)) + t.cms.ByteSize() + sliceSize + uint64(cap(*t.elements))*pointerSize
+	for _, e := range *t.elements {
+		size += uint64(unsafe.Sizeof(*e)) + uint64(cap(e.data))
+	}
+	return size
+}
+
 // Reset restores the TopK to its original state. It returns itself to allow
 // for chaining.
 func (t *TopK) Reset() *TopK {
@@ -94,6 +210,103 @@ func (t *TopK) Reset() *TopK {
 	return t
 }
 
+// WriteTo writes a binary representation of the TopK to an I/O stream,
+// including the underlying Count-Min Sketch and the elements of the top-k heap
+// with their frequencies. The hash function is not written. It returns the
+// number of bytes written.
+func (t *TopK) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(t.k))
+	e.write(uint64(t.n))
+	e.writeTo(t.cms)
+	e.write(uint64(t.elements.Len()))
+	for _, element := range *t.elements {
+		e.write(element.freq)
+		e.writeBytes(element.data)
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a TopK (such as might have been
+// written by WriteTo()) from an I/O stream. The heap elements are restored in
+// the order they were written, so adding to the TopK afterwards updates the
+// top-k exactly as if it had never been written. The underlying sketch keeps
+// its current hash function, so the TopK must have one: the zero value returns
+// an error, unlike with UnmarshalBinary. Returns ErrUnsupportedVersion if the
+// data was written by an incompatible version of the package. It returns the
+// number of bytes read.
+func (t *TopK) ReadFrom(stream io.Reader) (int64, error) {
+	if t.cms == nil {
+		return 0, errors.New("TopK has no sketch to read into; create it with NewTopK or use UnmarshalBinary")
+	}
+
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d           = decoder{r: payload}
+		k, count, l uint64
+		cms         = &CountMinSketch{hash: t.cms.hash}
+	)
+	d.read(&k)
+	d.read(&count)
+	d.readFrom(cms)
+	d.read(&l)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if k == 0 || k > math.MaxInt32 {
+		return n, errors.New("invalid number of elements")
+	}
+	if l > k {
+		return n, errors.New("heap exceeds k elements")
+	}
+	// Every element takes at least 16 bytes for its frequency and length.
+	if l > uint64(payload.Len())/16 {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	elements := make(elementHeap, 0, l)
+	for i := uint64(0); i < l && d.err == nil; i++ {
+		var freq uint64
+		d.read(&freq)
+		data := d.readBytes()
+		elements = append(elements, &element{data: data, freq: freq})
+	}
+	if d.err != nil {
+		return n, d.err
+	}
+
+	t.cms = cms
+	t.k = uint(k)
+	t.n = uint(count)
+	t.elements = &elements
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (t *TopK) MarshalBinary() ([]byte, error) {
+	return marshalBinary(t)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo. If the TopK
+// is the zero value, its sketch uses the default hash function.
+func (t *TopK) UnmarshalBinary(data []byte) error {
+	if t.cms == nil {
+		t.cms = &CountMinSketch{hash: newFNV64()}
+	}
+	return unmarshalBinary(t, data)
+}
+
 // isTop indicates if the given frequency falls within the top-k heap.
 func (t *TopK) isTop(freq uint64) bool {
 	if t.elements.Len() < int(t.k) {
@@ -107,10 +320,12 @@ func (t *TopK) isTop(freq uint64) bool {
 // the frequency is updated. If the heap already has k elements, the element
 // with the minimum frequency is removed.
 func (t *TopK) insert(data []byte, freq uint64) {
-	for _, element := range *t.elements {
+	for i, element := range *t.elements {
 		if bytes.Compare(data, element.data) == 0 {
-			// Element already in top-k.
+			// Element already in top-k. It's moved to keep the element with
+			// the minimum frequency at the root, which isTop compares with.
 			element.freq = freq
+			heap.Fix(t.elements, i)
 			return
 		}
 	}
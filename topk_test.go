@@ -1,6 +1,8 @@
 package boom
 
 import (
+	"bytes"
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -20,7 +22,7 @@ func TestTopK(t *testing.T) {
 		t.Error("Returned TopK should be the same instance")
 	}
 
-	expected := []string{"bill", "sara", "bob", "alice", "tyler"}
+	expected := []string{"sara", "fred", "bob", "alice", "tyler"}
 	actual := topk.Elements()
 
 	if l := len(actual); l != 5 {
@@ -46,6 +48,132 @@ func TestTopK(t *testing.T) {
 	}
 }
 
+// Ensures that MergeSketch merges the counts of the provided sketch and
+// re-evaluates the top-k elements.
+func TestTopKMergeSketch(t *testing.T) {
+	topk := NewTopK(0.001, 0.99, 2)
+	topk.Add([]byte(`bob`)).Add([]byte(`bob`)).Add([]byte(`bob`))
+	topk.Add([]byte(`tyler`)).Add([]byte(`tyler`))
+
+	worker := NewCountMinSketch(0.001, 0.99)
+	for i := 0; i < 5; i++ {
+		worker.Add([]byte(`tyler`))
+	}
+
+	if err := topk.MergeSketch(worker); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []string{"bob", "tyler"}
+	actual := topk.Elements()
+	if l := len(actual); l != 2 {
+		t.Fatalf("Expected len 2, got %d", l)
+	}
+	for i, element := range actual {
+		if e := string(element); e != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], e)
+		}
+	}
+
+	if freq := topk.cms.Count([]byte(`tyler`)); freq != 7 {
+		t.Errorf("Expected 7, got %d", freq)
+	}
+
+	if n := topk.n; n != 10 {
+		t.Errorf("Expected 10, got %d", n)
+	}
+
+	if err := topk.MergeSketch(NewCountMinSketch(0.01, 0.99)); err == nil {
+		t.Error("Expected error")
+	}
+}
+
+// Ensures that Merge re-ranks the candidates of both shards by their merged
+// counts.
+func TestTopKMerge(t *testing.T) {
+	var (
+		shard = NewTopK(0.001, 0.99, 2)
+		other = NewTopK(0.001, 0.99, 2)
+	)
+	for i := 0; i < 5; i++ {
+		shard.Add([]byte(`bob`))
+		other.Add([]byte(`alice`)).Add([]byte(`alice`))
+	}
+	shard.Add([]byte(`tyler`)).Add([]byte(`tyler`))
+	other.Add([]byte(`bob`)).Add([]byte(`james`))
+
+	if err := shard.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"bob", "alice"}
+	actual := shard.Elements()
+	if l := len(actual); l != 2 {
+		t.Fatalf("Expected len 2, got %d", l)
+	}
+	for i, element := range actual {
+		if e := string(element); e != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], e)
+		}
+	}
+	if n := shard.n; n != 19 {
+		t.Errorf("Expected 19, got %d", n)
+	}
+
+	if err := shard.Merge(NewTopK(0.01, 0.99, 2)); err == nil {
+		t.Error("Expected error")
+	}
+}
+
+// Ensures that Estimates returns the top-k elements with their estimated
+// frequencies and the error bound.
+func TestTopKEstimates(t *testing.T) {
+	topk := NewTopK(0.01, 0.01, 2)
+	if estimates := topk.Estimates(); len(estimates) != 0 {
+		t.Errorf("Expected no estimates, got %v", estimates)
+	}
+	for i := 0; i < 300; i++ {
+		topk.Add([]byte(strconv.Itoa(i)))
+	}
+	for i := 0; i < 20; i++ {
+		topk.Add([]byte(`bob`)).Add([]byte(`bob`)).Add([]byte(`alice`))
+	}
+
+	if bound := topk.ErrorBound(); bound != 4 {
+		t.Errorf("Expected 4, got %d", bound)
+	}
+	estimates := topk.Estimates()
+	if len(estimates) != 2 {
+		t.Fatalf("Expected 2 estimates, got %d", len(estimates))
+	}
+	for i, expected := range []struct {
+		data  string
+		count uint64
+	}{{"alice", 20}, {"bob", 40}} {
+		e := estimates[i]
+		if string(e.Data) != expected.data || e.Count < expected.count || e.Count > expected.count+e.Error || e.Error != 4 {
+			t.Errorf("Expected %s with about %d, got %s with %d ± %d", expected.data, expected.count, e.Data, e.Count, e.Error)
+		}
+	}
+}
+
+// Ensures that Elements and Estimates return only the tracked elements of a
+// TopK holding fewer than k of them.
+func TestTopKPartlyFilled(t *testing.T) {
+	topk := NewTopK(0.01, 0.01, 5)
+	topk.Add([]byte(`bob`)).Add([]byte(`bob`)).Add([]byte(`alice`))
+
+	if elements := topk.Elements(); len(elements) != 2 ||
+		string(elements[0]) != "alice" || string(elements[1]) != "bob" {
+		t.Errorf("Expected [alice bob], got %q", elements)
+	}
+	if estimates := topk.Estimates(); len(estimates) != 2 || estimates[1].Count != 2 {
+		t.Errorf("Expected 2 estimates, got %v", estimates)
+	}
+	if elements := NewSafeTopK(topk).Elements(); len(elements) != 2 {
+		t.Errorf("Expected 2 elements, got %q", elements)
+	}
+}
+
 func BenchmarkTopKAdd(b *testing.B) {
 	b.StopTimer()
 	topk := NewTopK(0.001, 0.99, 5)
@@ -59,3 +187,71 @@ func BenchmarkTopKAdd(b *testing.B) {
 		topk.Add(data[n])
 	}
 }
+
+// Ensures that a TopK read from its written representation continues to track
+// the same top-k as a TopK which was never written.
+func TestTopKWriteToReadFrom(t *testing.T) {
+	var (
+		expected = NewTopK(0.001, 0.99, 5)
+		topk     = NewTopK(0.001, 0.99, 5)
+		buf      bytes.Buffer
+	)
+
+	for i := 0; i < 100; i++ {
+		data := []byte(strconv.Itoa(i % 10))
+		for j := 0; j < i%10; j++ {
+			expected.Add(data)
+			topk.Add(data)
+		}
+	}
+
+	if _, err := topk.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	restored := NewTopK(0.1, 0.5, 1)
+	if _, err := restored.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(restored.Elements(), expected.Elements()) {
+		t.Errorf("Expected %q, got %q", expected.Elements(), restored.Elements())
+	}
+
+	// An item outside of the top-k overtakes the heap.
+	for i := 0; i < 75; i++ {
+		expected.Add([]byte(`a`))
+		restored.Add([]byte(`a`))
+	}
+
+	actual := restored.Elements()
+	if !reflect.DeepEqual(actual, expected.Elements()) {
+		t.Errorf("Expected %q, got %q", expected.Elements(), actual)
+	}
+
+	if restored.n != expected.n {
+		t.Errorf("Expected %d, got %d", expected.n, restored.n)
+	}
+}
+
+// Ensures that ReadFrom rejects a crafted number of elements instead of
+// allocating it, and a TopK without a sketch instead of panicking.
+func TestTopKReadFromInvalid(t *testing.T) {
+	var e encoder
+	e.write(uint64(1 << 62))
+	e.write(uint64(0))
+	e.writeTo(NewCountMinSketch(0.1, 0.1))
+	e.write(uint64(1 << 40))
+
+	var buf bytes.Buffer
+	if _, err := writeFrame(&buf, e.buf.Bytes()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := NewTopK(0.1, 0.5, 1).ReadFrom(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("Expected error for a crafted number of elements")
+	}
+
+	if _, err := (&TopK{}).ReadFrom(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("Expected error for a TopK without a sketch")
+	}
+}
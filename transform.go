@@ -0,0 +1,83 @@
+package boom
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrTransformerMismatch is returned when reading a filter written with a
+// different Transformer, or none, than the one it's read into, since its keys
+// would no longer be found.
+var ErrTransformerMismatch = errors.New("transformer doesn't match the serialized filter")
+
+// Transformer normalizes keys before they're hashed, such as by folding case,
+// trimming white space, or canonicalizing URLs, so keys which differ only in
+// form are the same member of a filter.
+type Transformer interface {
+	// Name identifies the transformation in serialized filters, so a filter
+	// is only read into one normalizing keys the same way.
+	Name() string
+
+	// Transform returns the normalized key. It mustn't modify the key, may
+	// return it unchanged, and must be idempotent, so a normalized key is
+	// transformed into itself.
+	Transform(key []byte) []byte
+}
+
+// funcTransformer is a Transformer applying a function.
+type funcTransformer struct {
+	name string
+	fn   func([]byte) []byte
+}
+
+func (f funcTransformer) Name() string                { return f.name }
+func (f funcTransformer) Transform(key []byte) []byte { return f.fn(key) }
+
+// NewTransformer returns a Transformer applying fn, identified by the name.
+func NewTransformer(name string, fn func(key []byte) []byte) Transformer {
+	return funcTransformer{name: name, fn: fn}
+}
+
+var (
+	// LowerCase folds keys to lower case, treating them as UTF-8.
+	LowerCase = NewTransformer("lower", bytes.ToLower)
+
+	// TrimSpace trims leading and trailing white space from keys.
+	TrimSpace = NewTransformer("trim", bytes.TrimSpace)
+)
+
+// transformerName returns the name of the transformer, or an empty string if
+// it's nil.
+func transformerName(t Transformer) string {
+	if t == nil {
+		return ""
+	}
+	return t.Name()
+}
+
+// transformKey returns the key normalized by the transformer, if it's set.
+func transformKey(t Transformer, key []byte) []byte {
+	if t == nil {
+		return key
+	}
+	return t.Transform(key)
+}
+
+// readTransformerName reads the name of the transformer written after the
+// other fields of a payload, if any, returning ErrTransformerMismatch if it
+// isn't the name of the transformer t.
+func readTransformerName(d *decoder, payload *bytes.Reader, t Transformer) error {
+	var name []byte
+	if payload.Len() > 0 {
+		// Written by format version 2.5 or later.
+		name = d.readBytes()
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	if string(name) != transformerName(t) {
+		return ErrTransformerMismatch
+	}
+	return nil
+}
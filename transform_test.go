@@ -0,0 +1,137 @@
+package boom
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Ensures that a BloomFilter with a Transformer treats keys differing only in
+// form as the same member.
+func TestBloomTransformer(t *testing.T) {
+	f := NewBloomFilter(100, 0.01, WithTransformer(LowerCase))
+	f.AddString("Alice@Example.com")
+	for _, key := range []string{"Alice@Example.com", "alice@example.com", "ALICE@EXAMPLE.COM"} {
+		if !f.TestString(key) {
+			t.Errorf("Expected %s to be a member", key)
+		}
+	}
+	if f.TestString("bob@example.com") {
+		t.Error("Expected bob@example.com not to be a member")
+	}
+
+	key := []byte("  Bob ")
+	trimmed := NewBloomFilter(100, 0.01, WithTransformer(TrimSpace))
+	if trimmed.TestAndAdd(key) {
+		t.Error("Expected key not to be a member")
+	}
+	if !trimmed.TestString("Bob") || trimmed.TestString("bob") {
+		t.Error("Expected only the trimmed key to be a member")
+	}
+	if string(key) != "  Bob " {
+		t.Errorf("Expected key to be unmodified, got %q", key)
+	}
+
+	plain := NewBloomFilter(100, 0.01)
+	if err := f.Union(plain); err == nil {
+		t.Error("Expected error for union with a different transformer")
+	}
+}
+
+// Ensures that the name of the Transformer is serialized, so a filter is only
+// read into one normalizing keys the same way.
+func TestBloomTransformerSerialization(t *testing.T) {
+	f := NewBloomFilter(100, 0.01, WithTransformer(LowerCase))
+	f.AddString("Alice")
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	read := NewBloomFilter(100, 0.01, WithTransformer(LowerCase))
+	if _, err := read.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !read.TestString("ALICE") {
+		t.Error("Expected ALICE to be a member")
+	}
+
+	for _, opts := range [][]Option{nil, {WithTransformer(TrimSpace)}} {
+		other := NewBloomFilter(100, 0.01, opts...)
+		if _, err := other.ReadFrom(bytes.NewReader(buf.Bytes())); err != ErrTransformerMismatch {
+			t.Errorf("Expected ErrTransformerMismatch, got %v", err)
+		}
+	}
+
+	buf.Reset()
+	if _, err := NewBloomFilter(100, 0.01).WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := read.ReadFrom(&buf); err != ErrTransformerMismatch {
+		t.Errorf("Expected ErrTransformerMismatch, got %v", err)
+	}
+}
+
+// Ensures that Scalable Bloom Filters normalize keys with their Transformer
+// and serialize its name, including in chunks.
+func TestScalableBloomTransformer(t *testing.T) {
+	custom := NewTransformer("upper", bytes.ToUpper)
+	f := NewScalableBloomFilter(10, 0.01, 0.8, WithTransformer(custom))
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+		f.AddString(key)
+	}
+	if len(f.filters) < 2 {
+		t.Fatalf("Expected several filters, got %d", len(f.filters))
+	}
+	if !f.TestString("A") || !f.TestString("l") {
+		t.Error("Expected A and l to be members")
+	}
+	if members := f.TestMany([][]byte{[]byte("B"), []byte("k"), []byte("z")}); !members[0] || !members[1] || members[2] != f.TestString("Z") {
+		t.Errorf("Expected B and k to be members and z to be tested like Z, got %v", members)
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	read := NewScalableBloomFilter(10, 0.01, 0.8, WithTransformer(custom))
+	if _, err := read.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !read.Equal(f) {
+		t.Error("Expected filters to be equal")
+	}
+	if _, err := NewScalableBloomFilter(10, 0.01, 0.8).ReadFrom(bytes.NewReader(buf.Bytes())); err != ErrTransformerMismatch {
+		t.Errorf("Expected ErrTransformerMismatch, got %v", err)
+	}
+
+	iter, header, err := f.ScanDump(0, 64)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := NewScalableBloomFilter(10, 0.01, 0.8).LoadChunk(iter, header); err != ErrTransformerMismatch {
+		t.Errorf("Expected ErrTransformerMismatch, got %v", err)
+	}
+
+	if err := NewScalableBloomFilter(10, 0.01, 0.8).Merge(f); err == nil {
+		t.Error("Expected error for merge with a different transformer")
+	}
+}
+
+// Ensures that a ShardedScalableBloomFilter shards keys once normalized, so
+// keys differing only in form are found in the same shard.
+func TestShardedScalableBloomTransformer(t *testing.T) {
+	f := NewShardedScalableBloomFilter(8, 1000, 0.01, 0.8, WithTransformer(LowerCase))
+	keys := []string{"Alice", "Bob", "Carol", "Dave", "Eve", "Frank", "Grace", "Heidi"}
+	for _, key := range keys {
+		f.AddString(key)
+	}
+	for _, key := range keys {
+		if !f.TestString(string(bytes.ToUpper([]byte(key)))) {
+			t.Errorf("Expected %s to be a member", key)
+		}
+	}
+	if !f.Clone().TestString("alice") {
+		t.Error("Expected alice to be a member of the clone")
+	}
+}
@@ -0,0 +1,117 @@
+//go:build go1.18
+// +build go1.18
+
+package boom
+
+import "encoding/binary"
+
+// Hasher converts items of type T to the bytes which a TypedFilter passes to
+// the filter it wraps, so the items are hashed like any other data.
+type Hasher[T any] interface {
+	// Bytes returns the bytes representing the item, which may be appended to
+	// dst to reuse its storage. Equal items must be represented by equal bytes.
+	Bytes(dst []byte, item T) []byte
+}
+
+// HasherFunc is an adapter to use an ordinary function as a Hasher.
+type HasherFunc[T any] func(dst []byte, item T) []byte
+
+// Bytes returns f(dst, item).
+func (f HasherFunc[T]) Bytes(dst []byte, item T) []byte {
+	return f(dst, item)
+}
+
+// Uint64Hasher is a Hasher representing a uint64 by its eight big-endian
+// bytes.
+type Uint64Hasher struct{}
+
+// Bytes appends the big-endian bytes of the item to dst.
+func (Uint64Hasher) Bytes(dst []byte, item uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], item)
+	return append(dst, b[:]...)
+}
+
+// StringHasher is a Hasher representing a string by its bytes, which aren't
+// copied.
+type StringHasher struct{}
+
+// Bytes returns the bytes of the item without copying them.
+func (StringHasher) Bytes(dst []byte, item string) []byte {
+	return stringBytes(item)
+}
+
+// TypedFilter adds and tests items of type T in a Filter, converting each to
+// bytes with a Hasher in a buffer reused across calls rather than allocating,
+// such as with strconv, for every item. The []byte API of the wrapped filter
+// remains the canonical implementation, so typed items are members of it as
+// the bytes the Hasher returns for them.
+//
+// A TypedFilter isn't safe for concurrent use because of its buffer, even if
+// the wrapped filter is. Create one per goroutine wrapping the same filter
+// instead, which is cheap.
+type TypedFilter[T any] struct {
+	filter Filter
+	hasher Hasher[T]
+	buf    []byte
+	copy   bool // copy the bytes of added items, which the filter keeps
+}
+
+// NewTypedFilter returns a TypedFilter adding and testing items of type T in
+// the filter. Since an InverseBloomFilter keeps the data it's given, items
+// added to one, or to a SafeFilter wrapping one, are copied.
+func NewTypedFilter[T any](filter Filter, hasher Hasher[T]) *TypedFilter[T] {
+	return &TypedFilter[T]{
+		filter: filter,
+		hasher: hasher,
+		copy:   retainsData(filter),
+	}
+}
+
+// Filter returns the wrapped filter.
+func (t *TypedFilter[T]) Filter() Filter {
+	return t.filter
+}
+
+// Test will test for membership of the item and returns true if it is a
+// member, false if not.
+func (t *TypedFilter[T]) Test(item T) bool {
+	return t.filter.Test(t.bytes(item, false))
+}
+
+// Add will add the item to the filter. It returns the TypedFilter to allow for
+// chaining.
+func (t *TypedFilter[T]) Add(item T) *TypedFilter[T] {
+	t.filter.Add(t.bytes(item, t.copy))
+	return t
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the item is a member, false if not.
+func (t *TypedFilter[T]) TestAndAdd(item T) bool {
+	return t.filter.TestAndAdd(t.bytes(item, t.copy))
+}
+
+// bytes returns the bytes of the item, in the buffer unless they're copied.
+func (t *TypedFilter[T]) bytes(item T, copy bool) []byte {
+	if copy {
+		return t.hasher.Bytes(nil, item)
+	}
+	data := t.hasher.Bytes(t.buf[:0], item)
+	if len(data) > cap(t.buf) {
+		// Grow the buffer so the next item of this length fits.
+		t.buf = make([]byte, 0, len(data))
+	}
+	return data
+}
+
+// retainsData returns true if the filter keeps the data added to it.
+func retainsData(filter Filter) bool {
+	switch f := filter.(type) {
+	case *InverseBloomFilter:
+		return true
+	case *SafeFilter:
+		return retainsData(f.filter)
+	}
+	return false
+}
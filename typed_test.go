@@ -0,0 +1,93 @@
+//go:build go1.18
+// +build go1.18
+
+package boom
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// point is a custom type hashed by its coordinates.
+type point struct {
+	x, y int32
+}
+
+// Ensures that typed filters add and test items as the bytes their Hasher
+// returns.
+func TestTypedFilter(t *testing.T) {
+	f := NewBloomFilter(1000, 0.01)
+	ids := NewTypedFilter[uint64](f, Uint64Hasher{})
+
+	if ids.TestAndAdd(42) {
+		t.Error("42 should not be a member")
+	}
+	if !ids.Add(7).Test(42) || !ids.Test(7) {
+		t.Error("42 and 7 should be members")
+	}
+	if ids.Test(8) {
+		t.Error("8 should not be a member")
+	}
+	if ids.Filter() != f || !f.Test([]byte{0, 0, 0, 0, 0, 0, 0, 42}) {
+		t.Error("Expected the big-endian bytes of 42 to be a member of the filter")
+	}
+
+	names := NewTypedFilter[string](NewScalableBloomFilter(10, 0.01, 0.8), StringHasher{})
+	names.Add("foo")
+	if !names.Test("foo") || !names.Filter().Test([]byte("foo")) {
+		t.Error("`foo` should be a member")
+	}
+
+	points := NewTypedFilter[point](NewPartitionedBloomFilter(100, 0.01), HasherFunc[point](func(dst []byte, p point) []byte {
+		var b [8]byte
+		binary.BigEndian.PutUint32(b[:4], uint32(p.x))
+		binary.BigEndian.PutUint32(b[4:], uint32(p.y))
+		return append(dst, b[:]...)
+	}))
+	points.Add(point{1, 2})
+	if !points.Test(point{1, 2}) || points.Test(point{2, 1}) {
+		t.Error("Expected only {1, 2} to be a member")
+	}
+}
+
+// Ensures that items added to an InverseBloomFilter, which keeps the data, are
+// copied.
+func TestTypedFilterRetainsData(t *testing.T) {
+	ids := NewTypedFilter[uint64](NewSafeFilter(NewInverseBloomFilter(1000)), Uint64Hasher{})
+	for i := uint64(0); i < 100; i++ {
+		ids.Add(i)
+	}
+
+	members := 0
+	for i := uint64(0); i < 100; i++ {
+		if ids.Test(i) {
+			members++
+		}
+	}
+	if members < 90 {
+		t.Errorf("Expected most items to be members, got %d", members)
+	}
+}
+
+// Ensures that testing and adding typed items doesn't allocate.
+func TestTypedFilterAllocs(t *testing.T) {
+	ids := NewTypedFilter[uint64](NewBloomFilter(1000, 0.01), Uint64Hasher{})
+	ids.Add(0)
+
+	n := uint64(0)
+	allocs := testing.AllocsPerRun(100, func() {
+		ids.Add(n)
+		ids.Test(n)
+		n++
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations, got %v", allocs)
+	}
+}
+
+func BenchmarkTypedFilterAdd(b *testing.B) {
+	ids := NewTypedFilter[uint64](NewBloomFilter(100000, 0.1), Uint64Hasher{})
+	for n := 0; n < b.N; n++ {
+		ids.Add(uint64(n))
+	}
+}
@@ -0,0 +1,443 @@
+package boom
+
+import (
+	"context"
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"math/rand"
+	"unsafe"
+)
+
+// Parameters of a VacuumFilter.
+const (
+	vacuumEntries = 4
+
+	// vacuumMinBits is the shortest fingerprint. Alternate buckets are
+	// derived from fingerprints, and shorter ones have too few distinct
+	// offsets for a VacuumFilter to reach its load.
+	vacuumMinBits = 6
+
+	// vacuumLoad is the fraction of entries a VacuumFilter is sized to fill,
+	// which its insertions reach with high probability.
+	vacuumLoad = 0.95
+
+	// vacuumMaxChunk is the largest alternate range within a chunk, in
+	// buckets. The number of buckets is a multiple of it, so it bounds the
+	// buckets added to round up a filter's size.
+	vacuumMaxChunk = 64
+)
+
+// VacuumFilter implements a Cuckoo Filter variant as described by Wang,
+// Zhou, Chen, Li, Lou, and Lu in Vacuum Filters: More Space-Efficient and
+// Faster Replacement for Bloom and Cuckoo Filters:
+//
+// http://www.vldb.org/pvldb/vol13/p197-wang.pdf
+//
+// Like a Cuckoo Filter, it stores a fingerprint of each item in one of two
+// buckets of four entries, the second computed from the first and the
+// fingerprint, but the table is split into aligned chunks of 64 buckets and
+// the two buckets of most items are in the same chunk, at most 64, 32, or 16
+// buckets apart depending on the fingerprint. A Cuckoo Filter's table must
+// have a power-of-two number of buckets, which for an arbitrary number of
+// items can waste almost half of it, where a VacuumFilter's is a multiple of
+// the chunk size, so it takes close to the minimum space at a 95% load. The
+// small ranges keep most relocations, and both buckets of most items, close
+// together in memory, which makes insertions faster.
+//
+// Chunks fill unevenly, so a quarter of the items, chosen by fingerprint, have
+// buckets anywhere in the table to even them out. The paper gives these items
+// a larger power-of-two range instead, which the table size must then be a
+// multiple of; an odd offset whose direction depends on the parity of the
+// bucket, as in a MortonFilter, works for any even number of buckets.
+//
+// Entries are packed in a bit array, and an empty entry has a fingerprint of
+// zero, so fingerprints which hash to zero are stored as one.
+type VacuumFilter struct {
+	words  []uint64    // packed buckets
+	hash   hash.Hash64 // hash function (used for fingerprint and index)
+	m      uint        // number of buckets
+	f      uint        // length of fingerprints (in bits)
+	ranges [4]uint     // alternate ranges by the low bits of a fingerprint, zero for the table
+	count  uint        // number of items in the filter
+	n      uint        // filter capacity
+	seed   uint64      // hash seed (zero means unseeded)
+	rand   *rand.Rand  // source of randomness for relocations, if seeded
+}
+
+// NewVacuumFilter creates a new Vacuum Filter optimized to store n items with
+// a specified target false-positive rate. Fingerprints take between 6 and 32
+// bits, so the false-positive rate is at most CuckooFPRate(bits, 4) for the
+// fingerprint length chosen.
+func NewVacuumFilter(n uint, fpRate float64, opts ...Option) *VacuumFilter {
+	f := uint(math.Ceil(math.Log2(2 * vacuumEntries / fpRate)))
+	if f < vacuumMinBits || math.IsNaN(fpRate) {
+		f = vacuumMinBits
+	} else if f > 32 {
+		f = 32
+	}
+
+	m := uint(math.Ceil(float64(n) / (vacuumEntries * vacuumLoad)))
+	if m < 2 {
+		m = 2
+	}
+	ranges := vacuumRanges(m)
+	m = (m + ranges[1] - 1) / ranges[1] * ranges[1]
+
+	o := applyOptions(opts)
+	v := &VacuumFilter{
+		hash:   o.newHash64(newFNV64),
+		m:      m,
+		f:      f,
+		ranges: ranges,
+		n:      n,
+		seed:   o.seed,
+		rand:   o.rand(),
+	}
+	v.words = make([]uint64, v.wordCount())
+	return v
+}
+
+// vacuumRanges returns the alternate ranges of a table of at least m buckets:
+// zero, the whole table, followed by powers of two from the chunk size, up to
+// vacuumMaxChunk, down to a quarter of it.
+func vacuumRanges(m uint) [4]uint {
+	chunk := power2(m)
+	if chunk > vacuumMaxChunk {
+		chunk = vacuumMaxChunk
+	}
+	ranges := [4]uint{0, chunk, chunk / 2, chunk / 4}
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i] < 2 {
+			ranges[i] = 2
+		}
+	}
+	return ranges
+}
+
+// Buckets returns the number of buckets.
+func (v *VacuumFilter) Buckets() uint {
+	return v.m
+}
+
+// Capacity returns the number of items the filter can store.
+func (v *VacuumFilter) Capacity() uint {
+	return v.n
+}
+
+// Count returns the number of items in the filter.
+func (v *VacuumFilter) Count() uint {
+	return v.count
+}
+
+// FingerprintBits returns the length of fingerprints in bits.
+func (v *VacuumFilter) FingerprintBits() uint {
+	return v.f
+}
+
+// EstimatedFPRate returns the estimated false-positive rate for the current
+// number of items, like CuckooFilter.EstimatedFPRate.
+func (v *VacuumFilter) EstimatedFPRate() float64 {
+	load := float64(v.count) / float64(v.m*vacuumEntries)
+	return 1 - math.Pow(1-math.Exp2(-float64(v.f)), 2*vacuumEntries*load)
+}
+
+// Test will test for membership of the data and returns true if it is a
+// member, false if not. This is a probabilistic test, meaning there is a
+// non-zero probability of false positives.
+func (v *VacuumFilter) Test(data []byte) bool {
+	i1, i2, f := v.components(data)
+	return v.indexOf(i1, f) != -1 || v.indexOf(i2, f) != -1
+}
+
+// Add will add the data to the filter. It returns an error if the filter is
+// full. If the filter is full, an item is removed to make room for the new
+// item. This introduces a possibility for false negatives. To avoid this, use
+// Count and Capacity to check if the filter is full before adding an item.
+func (v *VacuumFilter) Add(data []byte) error {
+	return v.add(v.components(data))
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data is a member, false if not. An error is returned if the filter is
+// full.
+func (v *VacuumFilter) TestAndAdd(data []byte) (bool, error) {
+	i1, i2, f := v.components(data)
+	if v.indexOf(i1, f) != -1 || v.indexOf(i2, f) != -1 {
+		return true, nil
+	}
+
+	return false, v.add(i1, i2, f)
+}
+
+// TestString is equivalent to Test for a string, which is hashed without being
+// copied.
+func (v *VacuumFilter) TestString(data string) bool {
+	return v.Test(stringBytes(data))
+}
+
+// AddString is equivalent to Add for a string, which is hashed without being
+// copied.
+func (v *VacuumFilter) AddString(data string) error {
+	return v.Add(stringBytes(data))
+}
+
+// TestAndAddString is equivalent to TestAndAdd for a string, which is hashed
+// without being copied.
+func (v *VacuumFilter) TestAndAddString(data string) (bool, error) {
+	return v.TestAndAdd(stringBytes(data))
+}
+
+// AddMany adds each item of data to the filter, stopping at the first error
+// returned by Add. It returns the number of items added, which are the first
+// ones in data, and the error, if any.
+func (v *VacuumFilter) AddMany(data [][]byte) (int, error) {
+	return addAllContext(context.Background(), data, v.Add)
+}
+
+// TestMany returns whether each item of data is a member, in the same order.
+func (v *VacuumFilter) TestMany(data [][]byte) []bool {
+	return testMany(data, v.Test)
+}
+
+// TestAndRemove will test for membership of the data and remove it from the
+// filter if it exists. Returns true if the data was a member, false if not.
+func (v *VacuumFilter) TestAndRemove(data []byte) bool {
+	i1, i2, f := v.components(data)
+	for _, i := range [2]uint{i1, i2} {
+		if j := v.indexOf(i, f); j != -1 {
+			v.setEntry(i, uint(j), 0)
+			v.count--
+			return true
+		}
+	}
+	return false
+}
+
+// Reset restores the filter to its original state. It returns the filter to
+// allow for chaining.
+func (v *VacuumFilter) Reset() *VacuumFilter {
+	for i := range v.words {
+		v.words[i] = 0
+	}
+	v.count = 0
+	return v
+}
+
+// ByteSize returns the number of bytes used by the packed buckets and metadata
+// of the filter. The hash function and source of randomness aren't included.
+func (v *VacuumFilter) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*v)) + uint64(cap(v.words))*8
+}
+
+// SetHash sets the hashing function used to compute fingerprints and bucket
+// indices.
+func (v *VacuumFilter) SetHash(h hash.Hash64) {
+	v.hash = h
+}
+
+// WriteTo writes a binary representation of the VacuumFilter to an I/O
+// stream, including its packed buckets. The hash function is not written, but
+// the seed is. It returns the number of bytes written.
+func (v *VacuumFilter) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(v.m))
+	e.write(uint64(v.f))
+	e.write(uint64(v.count))
+	e.write(uint64(v.n))
+	e.write(v.seed)
+	e.write(v.words)
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a VacuumFilter (such as might have
+// been written by WriteTo()) from an I/O stream. The filter keeps its current
+// hash function, which must match the one used by the filter that was
+// written. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (v *VacuumFilter) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d                 = decoder{r: payload}
+		m, f, count, size uint64
+		seed              uint64
+		decoded           VacuumFilter
+	)
+	d.read(&m)
+	d.read(&f)
+	d.read(&count)
+	d.read(&size)
+	d.read(&seed)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	// Every bucket takes at least 24 bits.
+	maxWords := uint64(payload.Len()) / 8
+	if m < 2 || m > maxWords*64/(vacuumEntries*vacuumMinBits) || f < vacuumMinBits || f > 32 {
+		return n, errors.New("invalid filter parameters")
+	}
+	ranges := vacuumRanges(uint(m))
+	if m%uint64(ranges[1]) != 0 {
+		return n, errors.New("invalid filter parameters")
+	}
+
+	decoded.m = uint(m)
+	decoded.f = uint(f)
+	if uint64(decoded.wordCount()) > maxWords {
+		return n, io.ErrUnexpectedEOF
+	}
+	words := make([]uint64, decoded.wordCount())
+	d.read(words)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if v.hash == nil {
+		v.hash = newFNV64()
+	}
+	v.words = words
+	v.m = uint(m)
+	v.f = uint(f)
+	v.ranges = ranges
+	v.count = uint(count)
+	v.n = uint(size)
+	v.seed = seed
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (v *VacuumFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(v)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo. If the filter
+// has no hash function, such as when it's the zero value, the default hash
+// function is used.
+func (v *VacuumFilter) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(v, data)
+}
+
+// add will insert the fingerprint into the filter returning an error if the
+// filter is full.
+func (v *VacuumFilter) add(i1, i2 uint, f uint32) error {
+	if v.insert(i1, f) || v.insert(i2, f) {
+		v.count++
+		return nil
+	}
+
+	// Must relocate existing items, preferring an entry whose other bucket
+	// has room, which ends the relocations at once.
+	i := i1
+	for n := 0; n < maxNumKicks; n++ {
+		j := uint(v.intn(vacuumEntries))
+		for k := uint(0); k < vacuumEntries; k++ {
+			if v.indexOf(v.altIndex(i, v.entry(i, k)), 0) != -1 {
+				j = k
+				break
+			}
+		}
+		victim := v.entry(i, j)
+		v.setEntry(i, j, f)
+
+		f, i = victim, v.altIndex(i, victim)
+		if v.insert(i, f) {
+			v.count++
+			return nil
+		}
+	}
+
+	return errors.New("full")
+}
+
+// insert inserts the fingerprint into an empty entry of the bucket. Returns
+// false if the bucket is full.
+func (v *VacuumFilter) insert(i uint, f uint32) bool {
+	if j := v.indexOf(i, 0); j != -1 {
+		v.setEntry(i, uint(j), f)
+		return true
+	}
+	return false
+}
+
+// indexOf returns the entry index of the fingerprint in the bucket or -1 if
+// it's not in the bucket.
+func (v *VacuumFilter) indexOf(i uint, f uint32) int {
+	for j := uint(0); j < vacuumEntries; j++ {
+		if v.entry(i, j) == f {
+			return int(j)
+		}
+	}
+	return -1
+}
+
+// components returns the two buckets of the data and its fingerprint, which
+// is never zero. The hash is mixed first, like a MortonFilter's.
+func (v *VacuumFilter) components(data []byte) (uint, uint, uint32) {
+	lower, upper := seededHashKernel(data, v.hash, v.seed)
+	h := mix64(uint64(upper)<<32 | uint64(lower))
+	f := uint32(h>>32) >> (32 - v.f)
+	if f == 0 {
+		f = 1
+	}
+
+	i1 := uint(uint64(uint32(h)) * uint64(v.m) >> 32)
+	return i1, v.altIndex(i1, f), f
+}
+
+// altIndex returns the other bucket of an entry with the fingerprint in the
+// bucket. Within a chunk, it differs from the bucket only in the bits below
+// the fingerprint's alternate range. Otherwise, the offset from the bucket is
+// odd and the number of buckets even, so the two buckets have different
+// parities, and the parity gives the direction of the offset.
+func (v *VacuumFilter) altIndex(i uint, f uint32) uint {
+	var (
+		r = v.ranges[f&3]
+		h = uint(mix64(uint64(f)))
+	)
+	if r != 0 {
+		return i ^ (h%(r-1) + 1)
+	}
+
+	offset := h%v.m | 1
+	if i%2 == 0 {
+		return (i + offset) % v.m
+	}
+	return (i + v.m - offset) % v.m
+}
+
+// intn returns a random number in [0, n) from the filter's source of
+// randomness, falling back to the default source if there is none.
+func (v *VacuumFilter) intn(n int) int {
+	if v.rand != nil {
+		return v.rand.Intn(n)
+	}
+	return rand.Intn(n)
+}
+
+// wordCount returns the number of words holding the packed buckets.
+func (v *VacuumFilter) wordCount() uint {
+	return (v.m*vacuumEntries*v.f + 63) / 64
+}
+
+// entry returns the fingerprint in the j-th entry of the bucket, zero if it's
+// empty.
+func (v *VacuumFilter) entry(i, j uint) uint32 {
+	return getWordBits(v.words, (i*vacuumEntries+j)*v.f, v.f)
+}
+
+// setEntry sets the fingerprint in the j-th entry of the bucket.
+func (v *VacuumFilter) setEntry(i, j uint, f uint32) {
+	setWordBits(v.words, (i*vacuumEntries+j)*v.f, v.f, f)
+}
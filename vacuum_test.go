@@ -0,0 +1,120 @@
+package boom
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Ensures that a VacuumFilter holds every added item, removes them, and stays
+// below the false-positive rate bound.
+func TestVacuumFilter(t *testing.T) {
+	for _, fpRate := range []float64{0.5, 0.01, 0.0001} {
+		f := NewVacuumFilter(10000, fpRate, WithSeed(3))
+		for i := 0; i < 10000; i++ {
+			if err := f.AddString(strconv.Itoa(i)); err != nil {
+				t.Fatalf("Unexpected error adding %d: %v", i, err)
+			}
+		}
+		if f.Count() != 10000 {
+			t.Errorf("Expected 10000 items, got %d", f.Count())
+		}
+
+		for i := 0; i < 10000; i++ {
+			if !f.TestString(strconv.Itoa(i)) {
+				t.Fatalf("Expected %d to be a member", i)
+			}
+		}
+
+		fp := 0
+		for i := 10000; i < 110000; i++ {
+			if f.TestString(strconv.Itoa(i)) {
+				fp++
+			}
+		}
+		bound := CuckooFPRate(f.FingerprintBits(), vacuumEntries)
+		if rate := float64(fp) / 100000; rate > bound {
+			t.Errorf("Expected false-positive rate below %f, got %f", bound, rate)
+		}
+
+		for i := 0; i < 5000; i++ {
+			if !f.TestAndRemove([]byte(strconv.Itoa(i))) {
+				t.Fatalf("Expected %d to be removed", i)
+			}
+		}
+		for i := 5000; i < 10000; i++ {
+			if !f.TestString(strconv.Itoa(i)) {
+				t.Fatalf("Expected %d to remain a member", i)
+			}
+		}
+
+		f.Reset()
+		if f.Count() != 0 || f.TestString("5000") {
+			t.Error("Expected empty filter after reset")
+		}
+	}
+}
+
+// Ensures that a VacuumFilter's buckets are within a chunk of the number its
+// items need, and that every alternate bucket is in range and maps back.
+func TestVacuumFilterBuckets(t *testing.T) {
+	for _, n := range []uint{1, 10, 1000, 100000, 300000} {
+		f := NewVacuumFilter(n, 0.01)
+		need := uint(float64(n)/(vacuumEntries*vacuumLoad)) + 1
+		if f.Buckets() < need || f.Buckets() >= need+vacuumMaxChunk || f.Buckets()%2 != 0 {
+			t.Errorf("Expected an even number of buckets from %d to %d, got %d", need, need+vacuumMaxChunk, f.Buckets())
+		}
+
+		for i := uint(0); i < f.Buckets(); i++ {
+			for fp := uint32(1); fp < 64; fp++ {
+				alt := f.altIndex(i, fp)
+				if alt >= f.Buckets() || f.altIndex(alt, fp) != i {
+					t.Fatalf("Expected alternate bucket of %d in range and mapping back, got %d", i, alt)
+				}
+			}
+		}
+	}
+}
+
+// Ensures that MarshalBinary and UnmarshalBinary round-trip the filter.
+func TestVacuumFilterReadWrite(t *testing.T) {
+	f := NewVacuumFilter(1000, 0.01)
+	for i := 0; i < 800; i++ {
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restored := &VacuumFilter{}
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if restored.Count() != f.Count() || restored.Buckets() != f.Buckets() || restored.FingerprintBits() != f.FingerprintBits() {
+		t.Errorf("Expected %d items, %d buckets, and %d bits, got %d, %d, and %d",
+			f.Count(), f.Buckets(), f.FingerprintBits(), restored.Count(), restored.Buckets(), restored.FingerprintBits())
+	}
+	for i := 0; i < 2000; i++ {
+		data := []byte(strconv.Itoa(i))
+		if restored.Test(data) != f.Test(data) {
+			t.Errorf("Expected %t for %s, got %t", f.Test(data), data, restored.Test(data))
+		}
+	}
+
+	if err := restored.UnmarshalBinary(data[:len(data)-8]); err == nil {
+		t.Error("Expected error for truncated data")
+	}
+}
+
+func BenchmarkVacuumFilterAdd(b *testing.B) {
+	f := NewVacuumFilter(uint(b.N), 0.001)
+	data := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		f.Add(data[n])
+	}
+}
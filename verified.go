@@ -0,0 +1,182 @@
+package boom
+
+import (
+	"container/list"
+	"errors"
+)
+
+// VerifyFunc checks whether the data is a member of the exact set a filter
+// approximates, such as by looking it up in a database. Returns an error if
+// the check fails.
+type VerifyFunc func(data []byte) (bool, error)
+
+// VerifyStats is a snapshot of the lookups made and saved by a
+// VerifiedFilter.
+type VerifyStats struct {
+	Tests          uint64 // calls to TestVerified
+	Negatives      uint64 // tests the filter reported as non-members, without a lookup
+	CacheHits      uint64 // tests of known false positives, without a lookup
+	Lookups        uint64 // tests the exact check was called for
+	FalsePositives uint64 // lookups which found the filter's positive was false
+	Errors         uint64 // lookups which failed
+}
+
+// SavedLookups returns the number of tests answered without calling the exact
+// check, by the filter or by the cache of known false positives.
+func (s VerifyStats) SavedLookups() uint64 {
+	return s.Negatives + s.CacheHits
+}
+
+// FPRate returns the fraction of successful lookups which found the filter's
+// positive was false, or zero if there were none. Tests of cached false
+// positives aren't counted.
+func (s VerifyStats) FPRate() float64 {
+	if s.Lookups == s.Errors {
+		return 0
+	}
+	return float64(s.FalsePositives) / float64(s.Lookups-s.Errors)
+}
+
+// VerifiedFilter puts a Filter in front of an exact set, such as a database
+// table, so that TestVerified only calls the exact check for data the filter
+// reports as a member, and never reports a false positive. Data found to be a
+// false positive is remembered in a cache of bounded size, evicting the least
+// recently used, so repeated tests of the same absent data don't repeat its
+// lookup. Data added through the VerifiedFilter is removed from the cache, but
+// the cache isn't aware of additions made to the exact set otherwise, which
+// TestVerified may report as non-members until Forget is called or they're
+// evicted.
+//
+// A VerifiedFilter isn't safe for concurrent use.
+type VerifiedFilter struct {
+	filter   Filter                   // filter in front of the exact set
+	verify   VerifyFunc               // exact check of membership
+	cached   map[string]*list.Element // known false positives by data
+	lru      *list.List               // known false positives from most to least recently used
+	maxCache uint                     // most false positives cached, zero to cache none
+	stats    VerifyStats              // measurements so far
+}
+
+// NewVerifiedFilter creates a new VerifiedFilter calling verify for data the
+// filter reports as a member, and caching at most cacheSize false positives.
+func NewVerifiedFilter(filter Filter, verify VerifyFunc, cacheSize uint) *VerifiedFilter {
+	return &VerifiedFilter{
+		filter:   filter,
+		verify:   verify,
+		cached:   make(map[string]*list.Element),
+		lru:      list.New(),
+		maxCache: cacheSize,
+	}
+}
+
+// Filter returns the wrapped filter.
+func (v *VerifiedFilter) Filter() Filter {
+	return v.filter
+}
+
+// Stats returns the measurements so far.
+func (v *VerifiedFilter) Stats() VerifyStats {
+	return v.stats
+}
+
+// TestVerified returns true if the data is a member of the exact set. The
+// exact check is only called if the filter reports the data as a member and it
+// isn't a cached false positive. Returns the error of the exact check, if it
+// failed, in which case nothing is cached.
+func (v *VerifiedFilter) TestVerified(data []byte) (bool, error) {
+	v.stats.Tests++
+	if !v.filter.Test(data) {
+		v.stats.Negatives++
+		return false, nil
+	}
+
+	if e, ok := v.cached[string(data)]; ok {
+		v.lru.MoveToFront(e)
+		v.stats.CacheHits++
+		return false, nil
+	}
+
+	v.stats.Lookups++
+	member, err := v.verify(data)
+	if err != nil {
+		v.stats.Errors++
+		return false, err
+	}
+	if !member {
+		v.stats.FalsePositives++
+		v.cache(data)
+	}
+	return member, nil
+}
+
+// TestVerifiedString is equivalent to TestVerified for a string, which is
+// hashed without being copied.
+func (v *VerifiedFilter) TestVerifiedString(data string) (bool, error) {
+	return v.TestVerified(stringBytes(data))
+}
+
+// Test will test for membership of the data with the filter alone, without the
+// exact check, and returns true if it is a member, false if not.
+func (v *VerifiedFilter) Test(data []byte) bool {
+	return v.filter.Test(data)
+}
+
+// Add will add the data to the filter and remove it from the cached false
+// positives, such as once it's added to the exact set. It returns the
+// VerifiedFilter to allow for chaining.
+func (v *VerifiedFilter) Add(data []byte) Filter {
+	v.filter.Add(data)
+	v.Forget(data)
+	return v
+}
+
+// TestAndAdd is equivalent to calling Test followed by Add. It returns true if
+// the data is a member, false if not.
+func (v *VerifiedFilter) TestAndAdd(data []byte) bool {
+	member := v.filter.TestAndAdd(data)
+	v.Forget(data)
+	return member
+}
+
+// Forget removes the data from the cached false positives, such as once it's
+// added to the exact set without going through Add. Returns true if it was
+// cached.
+func (v *VerifiedFilter) Forget(data []byte) bool {
+	e, ok := v.cached[string(data)]
+	if !ok {
+		return false
+	}
+
+	v.lru.Remove(e)
+	delete(v.cached, string(data))
+	return true
+}
+
+// Reset restores the wrapped filter to its original state and clears the
+// cached false positives, keeping the measurements. Returns an error if the
+// filter can't be reset, such as a TieredFilter.
+func (v *VerifiedFilter) Reset() error {
+	if !resetFilter(v.filter) {
+		return errors.New("filter can't be reset")
+	}
+	v.cached = make(map[string]*list.Element)
+	v.lru.Init()
+	return nil
+}
+
+// cache adds the data to the cached false positives, evicting the least
+// recently used if that exceeds the most cached.
+func (v *VerifiedFilter) cache(data []byte) {
+	if v.maxCache == 0 {
+		return
+	}
+
+	if uint(len(v.cached)) >= v.maxCache {
+		oldest := v.lru.Back()
+		v.lru.Remove(oldest)
+		delete(v.cached, oldest.Value.(string))
+	}
+
+	key := string(data)
+	v.cached[key] = v.lru.PushFront(key)
+}
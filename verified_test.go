@@ -0,0 +1,113 @@
+package boom
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+// Ensures that TestVerified only looks up data the filter reports as a member,
+// never reports a false positive, and caches false positives.
+func TestVerifiedFilter(t *testing.T) {
+	var (
+		set     = make(map[string]bool)
+		lookups = 0
+		verify  = func(data []byte) (bool, error) {
+			lookups++
+			return set[string(data)], nil
+		}
+		f = NewVerifiedFilter(NewBloomFilter(1000, 0.1), verify, 10000)
+	)
+	for i := 0; i < 1000; i++ {
+		set[strconv.Itoa(i)] = true
+		f.Add([]byte(strconv.Itoa(i)))
+	}
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 10000; i++ {
+			member, err := f.TestVerified([]byte(strconv.Itoa(i)))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if member != (i < 1000) {
+				t.Errorf("Expected %t for %d, got %t", i < 1000, i, member)
+			}
+		}
+	}
+
+	stats := f.Stats()
+	if stats.Tests != 20000 || uint64(lookups) != stats.Lookups {
+		t.Errorf("Expected 20000 tests and %d lookups, got %+v", lookups, stats)
+	}
+	if stats.FalsePositives == 0 || stats.CacheHits != stats.FalsePositives {
+		t.Errorf("Expected every false positive to hit the cache once, got %+v", stats)
+	}
+	if stats.Lookups != 2000+stats.FalsePositives {
+		t.Errorf("Expected %d lookups, got %d", 2000+stats.FalsePositives, stats.Lookups)
+	}
+	if saved := stats.SavedLookups(); saved != stats.Tests-stats.Lookups {
+		t.Errorf("Expected %d saved lookups, got %d", stats.Tests-stats.Lookups, saved)
+	}
+	if rate := stats.FPRate(); rate <= 0 || rate >= 1 {
+		t.Errorf("Expected a false-positive rate in (0, 1), got %f", rate)
+	}
+
+	// A cached false positive added to the set is forgotten by Add.
+	var cached []byte
+	for i := 1000; cached == nil; i++ {
+		if _, ok := f.cached[strconv.Itoa(i)]; ok {
+			cached = []byte(strconv.Itoa(i))
+		}
+	}
+	set[string(cached)] = true
+	f.Add(cached)
+	if member, _ := f.TestVerified(cached); !member {
+		t.Errorf("Expected %s to be a member once added", cached)
+	}
+}
+
+// Ensures that the cache of false positives is bounded and that a failed
+// lookup is reported and not cached.
+func TestVerifiedFilterCacheBoundAndErrors(t *testing.T) {
+	var (
+		failure = errors.New("lookup failed")
+		fail    = true
+		verify  = func(data []byte) (bool, error) {
+			if fail {
+				return false, failure
+			}
+			return false, nil
+		}
+		f = NewVerifiedFilter(NewBloomFilter(10, 0.5), verify, 2)
+	)
+	f.Add([]byte(`a`))
+
+	if _, err := f.TestVerified([]byte(`a`)); err != failure {
+		t.Errorf("Expected the lookup's error, got %v", err)
+	}
+	if stats := f.Stats(); stats.Errors != 1 || len(f.cached) != 0 {
+		t.Errorf("Expected one error and nothing cached, got %+v", stats)
+	}
+
+	fail = false
+	for _, data := range []string{"a", "b", "c"} {
+		f.Add([]byte(data))
+		f.TestVerified([]byte(data))
+	}
+	if len(f.cached) != 2 {
+		t.Errorf("Expected 2 cached false positives, got %d", len(f.cached))
+	}
+	if _, ok := f.cached["a"]; ok {
+		t.Error("Expected the least recently used false positive to be evicted")
+	}
+
+	if !f.Forget([]byte(`b`)) || f.Forget([]byte(`b`)) {
+		t.Error("Expected b to be forgotten once")
+	}
+	if err := f.Reset(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(f.cached) != 0 || f.Test([]byte(`c`)) {
+		t.Error("Expected the filter and cache to be cleared")
+	}
+}
@@ -0,0 +1,270 @@
+package boom
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"unsafe"
+)
+
+// weightedSample is the element a WeightedMinHash samples for one of its hash
+// functions.
+type weightedSample struct {
+	a   float64 // value minimized over the elements
+	key uint64  // base hash of the element
+	t   int64   // quantized weight of the element
+}
+
+// WeightedMinHash is a MinHash signature of a weighted set, such as the term
+// frequencies or BM25 weights of a document's words, using the Improved
+// Consistent Weighted Sampling described by Ioffe in Improved Consistent
+// Sampling, Weighted Minhash and L1 Sketching:
+//
+// https://doi.org/10.1109/ICDM.2010.80
+//
+// For each of k hash functions it samples an element with a probability
+// proportional to its weight, together with a quantization of the weight, so
+// two signatures sample the same element and quantization with a probability
+// equal to the weighted Jaccard similarity of their sets: the sum of the
+// smaller weights of each element divided by the sum of the larger. Unlike
+// MinHashSignature, sets with the same elements in different proportions
+// aren't identical. The standard error is about sqrt(J(1-J)/k) for a
+// similarity J.
+type WeightedMinHash struct {
+	samples []weightedSample
+	hash    hash.Hash64 // hash function (kernel for all k functions)
+	seed    uint64      // hash seed (zero means unseeded)
+}
+
+// NewWeightedMinHash creates a new empty WeightedMinHash of k hash functions,
+// at least 1.
+func NewWeightedMinHash(k uint, opts ...Option) *WeightedMinHash {
+	if k == 0 {
+		k = 1
+	}
+
+	o := applyOptions(opts)
+	w := &WeightedMinHash{
+		samples: make([]weightedSample, k),
+		hash:    o.newHash64(newFNV64),
+		seed:    o.seed,
+	}
+	return w.Reset()
+}
+
+// K returns the number of hash functions.
+func (w *WeightedMinHash) K() uint {
+	return uint(len(w.samples))
+}
+
+// Add will add the data to the set with the weight. Each element should be
+// added once with its total weight: adding it again samples it as if it had
+// the larger of the weights. Elements with a weight of zero or less aren't
+// added. Returns the WeightedMinHash to allow for chaining.
+func (w *WeightedMinHash) Add(data []byte, weight float64) *WeightedMinHash {
+	if !(weight > 0) || math.IsInf(weight, 1) {
+		return w
+	}
+
+	lower, upper := seededHashKernel(data, w.hash, w.seed)
+	var (
+		key = uint64(upper)<<32 | uint64(lower)
+		lw  = math.Log(weight)
+	)
+	for i := range w.samples {
+		// r and c are Gamma(2, 1) and beta is uniform, all derived from the
+		// element and hash function so they're the same for every set.
+		var (
+			x    = minHashValue(key, i)
+			r    = -math.Log(unitUniform(x, 0) * unitUniform(x, 1))
+			c    = -math.Log(unitUniform(x, 2) * unitUniform(x, 3))
+			beta = unitUniform(x, 4)
+			t    = math.Floor(lw/r + beta)
+			a    = c / math.Exp(r*(t-beta)+r)
+		)
+		if a < w.samples[i].a {
+			w.samples[i] = weightedSample{a: a, key: key, t: int64(t)}
+		}
+	}
+	return w
+}
+
+// unitUniform returns the jth of the uniform values in (0, 1) derived from
+// the hash value x.
+func unitUniform(x uint64, j uint64) float64 {
+	return (float64(mix64(x+j*0xbf58476d1ce4e5b9)>>11) + 0.5) / (1 << 53)
+}
+
+// Similarity returns the approximated weighted Jaccard similarity of this set
+// and the other, the fraction of the hash functions which sample the same
+// element and quantized weight in both signatures. It's zero if either set is
+// empty. Returns an error if the signatures have a different number of hash
+// functions or hash seed.
+func (w *WeightedMinHash) Similarity(other *WeightedMinHash) (float64, error) {
+	if err := w.compatible(other); err != nil {
+		return 0, err
+	}
+
+	matches := 0
+	for i, s := range w.samples {
+		o := other.samples[i]
+		if s.key == o.key && s.t == o.t && !math.IsInf(s.a, 1) {
+			matches++
+		}
+	}
+	return float64(matches) / float64(len(w.samples)), nil
+}
+
+// compatible returns an error if the other WeightedMinHash has a different
+// number of hash functions or hash seed.
+func (w *WeightedMinHash) compatible(other *WeightedMinHash) error {
+	if len(w.samples) != len(other.samples) {
+		return errors.New("number of hash functions must match")
+	}
+
+	if w.seed != other.seed {
+		return errors.New("hash seed must match")
+	}
+	return nil
+}
+
+// Merge combines this WeightedMinHash with another, so it's the signature of
+// the union of the sets in which each element has the larger of its weights.
+// The signatures should use the same hash function. Returns an error if they
+// have a different number of hash functions or hash seed, in which case this
+// signature is unchanged.
+func (w *WeightedMinHash) Merge(other *WeightedMinHash) error {
+	if err := w.compatible(other); err != nil {
+		return err
+	}
+
+	for i, o := range other.samples {
+		if o.a < w.samples[i].a {
+			w.samples[i] = o
+		}
+	}
+	return nil
+}
+
+// Equal returns true if the other WeightedMinHash has the same number of hash
+// functions, hash seed, and samples. The hash functions aren't compared.
+func (w *WeightedMinHash) Equal(other *WeightedMinHash) bool {
+	if w.compatible(other) != nil {
+		return false
+	}
+
+	for i, s := range w.samples {
+		if s != other.samples[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the WeightedMinHash, which can be used
+// concurrently with the original.
+func (w *WeightedMinHash) Clone() *WeightedMinHash {
+	c := *w
+	c.hash = cloneHash64(w.hash)
+	c.samples = append([]weightedSample(nil), w.samples...)
+	return &c
+}
+
+// ByteSize returns the number of bytes used by the samples and metadata of
+// the signature, excluding the hash function.
+func (w *WeightedMinHash) ByteSize() uint64 {
+	return uint64(unsafe.Sizeof(*w)) + uint64(cap(w.samples))*uint64(unsafe.Sizeof(weightedSample{}))
+}
+
+// Reset restores the WeightedMinHash to the signature of the empty set. It
+// returns itself to allow for chaining.
+func (w *WeightedMinHash) Reset() *WeightedMinHash {
+	for i := range w.samples {
+		w.samples[i] = weightedSample{a: math.Inf(1)}
+	}
+	return w
+}
+
+// SetHash sets the hashing function used.
+func (w *WeightedMinHash) SetHash(h hash.Hash64) {
+	w.hash = h
+}
+
+// WriteTo writes a binary representation of the WeightedMinHash to an I/O
+// stream. The hash function is not written, but the seed is. It returns the
+// number of bytes written.
+func (w *WeightedMinHash) WriteTo(stream io.Writer) (int64, error) {
+	var e encoder
+	e.write(uint64(len(w.samples)))
+	e.write(w.seed)
+	for _, s := range w.samples {
+		e.write(s.a)
+		e.write(s.key)
+		e.write(s.t)
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	return writeFrame(stream, e.buf.Bytes())
+}
+
+// ReadFrom reads a binary representation of a WeightedMinHash (such as might
+// have been written by WriteTo()) from an I/O stream. The signature keeps its
+// current hash function, which must match the one used by the signature that
+// was written, or uses the default one if it has none, such as when it's the
+// zero value. Returns ErrUnsupportedVersion if the data was written by an
+// incompatible version of the package. It returns the number of bytes read.
+func (w *WeightedMinHash) ReadFrom(stream io.Reader) (int64, error) {
+	payload, n, err := readFrame(stream)
+	if err != nil {
+		return n, err
+	}
+
+	var (
+		d       = decoder{r: payload}
+		k, seed uint64
+	)
+	d.read(&k)
+	d.read(&seed)
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if k == 0 {
+		return n, errors.New("invalid signature parameters")
+	}
+	if k > uint64(payload.Len())/24 {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	samples := make([]weightedSample, k)
+	for i := range samples {
+		d.read(&samples[i].a)
+		d.read(&samples[i].key)
+		d.read(&samples[i].t)
+	}
+	if d.err != nil {
+		return n, d.err
+	}
+
+	if w.hash == nil {
+		w.hash = newFNV64()
+	}
+	w.samples = samples
+	w.seed = seed
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the same
+// representation as WriteTo.
+func (w *WeightedMinHash) MarshalBinary() ([]byte, error) {
+	return marshalBinary(w)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading a
+// representation returned by MarshalBinary or written by WriteTo.
+func (w *WeightedMinHash) UnmarshalBinary(data []byte) error {
+	return unmarshalBinary(w, data)
+}
@@ -0,0 +1,81 @@
+package boom
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// Ensures that WeightedMinHash estimates the weighted Jaccard similarity of
+// sets with the same elements in different proportions.
+func TestWeightedMinHash(t *testing.T) {
+	var (
+		a, b     = NewWeightedMinHash(512), NewWeightedMinHash(512)
+		min, max float64
+	)
+	for i := 0; i < 1000; i++ {
+		data := []byte(strconv.Itoa(i))
+		wa, wb := float64(i%5+1), 0.5*float64((i+2)%7+1)
+		a.Add(data, wa)
+		b.Add(data, wb)
+		min += math.Min(wa, wb)
+		max += math.Max(wa, wb)
+	}
+
+	similarity, err := a.Similarity(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := min / max; math.Abs(similarity-expected) > 0.1 {
+		t.Errorf("Expected similarity about %f, got %f", expected, similarity)
+	}
+	if similarity, _ := a.Similarity(a.Clone()); similarity != 1 {
+		t.Errorf("Expected similarity 1, got %f", similarity)
+	}
+
+	// Doubling every weight halves the similarity.
+	c := NewWeightedMinHash(512)
+	for i := 0; i < 1000; i++ {
+		c.Add([]byte(strconv.Itoa(i)), float64(2*(i%5+1)))
+	}
+	if similarity, _ := a.Similarity(c); math.Abs(similarity-0.5) > 0.1 {
+		t.Errorf("Expected similarity about 0.5, got %f", similarity)
+	}
+
+	// Merging takes the larger weight of each element.
+	if err := a.Merge(c); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Equal(c) {
+		t.Error("Expected the merged signature to equal the one with larger weights")
+	}
+	if err := a.Merge(NewWeightedMinHash(256)); err == nil {
+		t.Error("Expected an error for different numbers of hash functions")
+	}
+
+	if similarity, _ := a.Reset().Add([]byte(`x`), 0).Similarity(NewWeightedMinHash(512)); similarity != 0 {
+		t.Errorf("Expected similarity 0 for empty sets, got %f", similarity)
+	}
+}
+
+// Ensures that a WeightedMinHash read from its written representation into
+// the zero value equals the original.
+func TestWeightedMinHashWriteToReadFrom(t *testing.T) {
+	expected := NewWeightedMinHash(64, WithSeed(9))
+	for i := 0; i < 100; i++ {
+		expected.Add([]byte(strconv.Itoa(i)), float64(i))
+	}
+
+	var buf bytes.Buffer
+	if _, err := expected.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var read WeightedMinHash
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !read.Equal(expected) || read.K() != 64 {
+		t.Error("Expected the read signature to equal the original")
+	}
+}
@@ -0,0 +1,120 @@
+package boom
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+// Ensures that an XorFilter holds every key, has roughly the expected
+// false-positive rate, and is smaller than a Bloom filter with the same rate.
+func TestXorFilter(t *testing.T) {
+	keys := make([][]byte, 10000)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+	}
+
+	for _, bits := range []uint{8, 16} {
+		x, err := NewXorFilter(keys, bits)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if x.Count() != 10000 || x.FingerprintBits() != bits {
+			t.Errorf("Expected 10000 keys with %d-bit fingerprints, got %d with %d", bits, x.Count(), x.FingerprintBits())
+		}
+
+		for _, key := range keys {
+			if !x.Test(key) {
+				t.Fatalf("Expected %s to be a member", key)
+			}
+		}
+
+		fp := 0
+		for i := 10000; i < 110000; i++ {
+			if x.TestString(strconv.Itoa(i)) {
+				fp++
+			}
+		}
+		if rate := float64(fp) / 100000; rate > 2*x.EstimatedFPRate()+0.0005 {
+			t.Errorf("Expected false-positive rate near %f, got %f", x.EstimatedFPRate(), rate)
+		}
+
+		bloom := ClassicMemory(10000, x.EstimatedFPRate())
+		if size := uint64(len(x.fingerprints)); size >= bloom {
+			t.Errorf("Expected fewer than %d bytes, got %d", bloom, size)
+		}
+	}
+}
+
+// Ensures that NewXorFilter deduplicates keys, accepts an empty set, and
+// rejects unsupported fingerprint lengths.
+func TestNewXorFilter(t *testing.T) {
+	x, err := NewXorFilter([][]byte{[]byte(`a`), []byte(`b`), []byte(`a`)}, 8, WithSeed(7))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if x.Count() != 2 || !x.Test([]byte(`a`)) || !x.Test([]byte(`b`)) {
+		t.Errorf("Expected 2 members, got %d", x.Count())
+	}
+
+	empty, err := NewXorFilter(nil, 16)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if empty.Count() != 0 || empty.Test([]byte(`a`)) {
+		t.Error("Expected an empty filter")
+	}
+
+	if _, err := NewXorFilter(nil, 12); err == nil {
+		t.Error("Expected error for 12-bit fingerprints")
+	}
+}
+
+// Ensures that an XorFilter read by ReadFrom reports the same members as the
+// one written by WriteTo.
+func TestXorFilterReadWrite(t *testing.T) {
+	keys := make([][]byte, 1000)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+	}
+	x, err := NewXorFilter(keys, 16, WithSeed(3))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := x.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data := append([]byte(nil), buf.Bytes()...)
+
+	restored := &XorFilter{}
+	if _, err := restored.ReadFrom(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 0; i < 5000; i++ {
+		key := []byte(strconv.Itoa(i))
+		if restored.Test(key) != x.Test(key) {
+			t.Errorf("Expected restored membership of %d to match original", i)
+		}
+	}
+
+	if err := restored.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Error("Expected error for truncated data")
+	}
+}
+
+func BenchmarkXorFilterTest(b *testing.B) {
+	keys := make([][]byte, 100000)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+	}
+	x, err := NewXorFilter(keys, 8)
+	if err != nil {
+		b.Fatalf("Unexpected error: %v", err)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		x.Test(keys[n%len(keys)])
+	}
+}
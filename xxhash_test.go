@@ -0,0 +1,24 @@
+package boom
+
+import "testing"
+
+// Ensures that xxhash64 matches the reference implementation.
+func TestXXHash64(t *testing.T) {
+	tests := []struct {
+		data string
+		seed uint64
+		hash uint64
+	}{
+		{``, 0, 0xef46db3751d8e999},
+		{`a`, 0, 0xd24ec4f1a98c6e5b},
+		{`hello`, 0, 0x26c7827d889f6da3},
+		{`hello`, 42, 0xc3629e6318d53932},
+		{`The quick brown fox jumps over the lazy dog`, 0, 0x0b242d361fda71bc},
+	}
+
+	for _, test := range tests {
+		if hash := xxhash64([]byte(test.data), test.seed); hash != test.hash {
+			t.Errorf("Expected %x for %q, got %x", test.hash, test.data, hash)
+		}
+	}
+}